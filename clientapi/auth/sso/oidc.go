@@ -0,0 +1,236 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// How long to keep the metadata of an identity provider before looking it
+// up again.
+const oidcDiscoveryLifetime = time.Hour
+
+// oidcIdentityProvider logs users in with the OpenID Connect authorization
+// code flow. Rather than validating the ID token, it asks the userinfo
+// endpoint who the user is, using the access token that it got directly
+// from the token endpoint.
+type oidcIdentityProvider struct {
+	cfg            *config.IdentityProvider
+	hc             *http.Client
+	scopes         []string
+	localpartClaim string
+
+	mu              sync.Mutex
+	discovery       *oidcDiscovery
+	discoveryExpiry time.Time
+}
+
+// oidcDiscovery is the part of the provider metadata that we need.
+// See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func newOIDCIdentityProvider(cfg *config.IdentityProvider, hc *http.Client) *oidcIdentityProvider {
+	p := &oidcIdentityProvider{
+		cfg:            cfg,
+		hc:             hc,
+		scopes:         cfg.Scopes,
+		localpartClaim: cfg.LocalpartClaim,
+	}
+	if len(p.scopes) == 0 {
+		p.scopes = []string{"openid", "profile", "email"}
+	}
+	if p.localpartClaim == "" {
+		p.localpartClaim = "preferred_username"
+	}
+	return p
+}
+
+func (p *oidcIdentityProvider) AuthorizationURL(ctx context.Context, callbackURL, nonce string) (string, error) {
+	disc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(disc.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("url.Parse: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", callbackURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (p *oidcIdentityProvider) ProcessCallback(ctx context.Context, callbackURL, nonce string, query url.Values) (*CallbackResult, error) {
+	if errCode := query.Get("error"); errCode != "" {
+		return nil, fmt.Errorf("identity provider returned an error: %s %s", errCode, query.Get("error_description"))
+	}
+	// The state has to match the nonce that we put in the authorization URL,
+	// otherwise the callback may not belong to this login.
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("state does not match")
+	}
+	code := query.Get("code")
+	if code == "" {
+		return nil, fmt.Errorf("no authorization code was returned")
+	}
+
+	disc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := p.exchangeCode(ctx, disc, callbackURL, code)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.userInfo(ctx, disc, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("the userinfo response has no subject")
+	}
+	res := &CallbackResult{
+		Identity: Identity{
+			IdPID:   p.cfg.ID,
+			Subject: subject,
+		},
+	}
+	res.SuggestedLocalpart, _ = claims[p.localpartClaim].(string)
+	res.DisplayName, _ = claims["name"].(string)
+	return res, nil
+}
+
+// exchangeCode swaps the authorization code for an access token.
+func (p *oidcIdentityProvider) exchangeCode(ctx context.Context, disc *oidcDiscovery, callbackURL, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {callbackURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var tokenRes oidcTokenResponse
+	if err = p.doJSON(req, &tokenRes); err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return "", fmt.Errorf("the token response has no access token")
+	}
+	if !strings.EqualFold(tokenRes.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type %q", tokenRes.TokenType)
+	}
+	return tokenRes.AccessToken, nil
+}
+
+// userInfo returns the claims that the identity provider makes about the
+// user that the access token belongs to.
+func (p *oidcIdentityProvider) userInfo(ctx context.Context, disc *oidcDiscovery, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, disc.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	claims := map[string]interface{}{}
+	if err = p.doJSON(req, &claims); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	return claims, nil
+}
+
+// getDiscovery returns the provider metadata, looking it up if we don't
+// have a recent copy.
+func (p *oidcIdentityProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Now().Before(p.discoveryExpiry) {
+		return p.discovery, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	var disc oidcDiscovery
+	if err = p.doJSON(req, &disc); err != nil {
+		return nil, fmt.Errorf("discovery request failed: %w", err)
+	}
+	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if strings.TrimSuffix(disc.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("the provider metadata is for issuer %q, not %q", disc.Issuer, p.cfg.Issuer)
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("the provider metadata is missing required endpoints")
+	}
+
+	p.discovery = &disc
+	p.discoveryExpiry = time.Now().Add(oidcDiscoveryLifetime)
+	return p.discovery, nil
+}
+
+func (p *oidcIdentityProvider) doJSON(req *http.Request, res interface{}) error {
+	hresp, err := p.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close() // nolint:errcheck
+
+	body, err := ioutil.ReadAll(io.LimitReader(hresp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if hresp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d: %s", hresp.StatusCode, body)
+	}
+	return json.Unmarshal(body, res)
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+const (
+	testClientID     = "dendrite"
+	testClientSecret = "s3cret"
+	testCode         = "thecode"
+	testAccessToken  = "theaccesstoken"
+	testCallbackURL  = "https://matrix.example.com/_matrix/client/r0/login/sso/callback"
+)
+
+// newTestIdentityProvider starts a minimal OpenID Connect provider that
+// always logs in the same user.
+func newTestIdentityProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != testClientID || pass != testClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != testCode ||
+			r.PostFormValue("redirect_uri") != testCallbackURL {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": testAccessToken,
+			"token_type":   "Bearer",
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"sub":                "1234",
+			"preferred_username": "alice",
+			"name":               "Alice Liddell",
+		})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestAuthenticator(issuer string) *Authenticator {
+	return NewAuthenticator(&config.SSO{
+		Enabled:     true,
+		CallbackURL: testCallbackURL,
+		Providers: []config.IdentityProvider{
+			{
+				ID:           "corp",
+				Name:         "Corporate login",
+				Issuer:       issuer,
+				ClientID:     testClientID,
+				ClientSecret: testClientSecret,
+			},
+		},
+	})
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	srv := newTestIdentityProvider(t)
+	a := newTestAuthenticator(srv.URL)
+
+	if p := a.Provider(""); p == nil || p.ID != "corp" {
+		t.Fatalf("Provider(\"\"): got %v, want the first provider", p)
+	}
+	if p := a.Provider("unknown"); p != nil {
+		t.Fatalf("Provider(\"unknown\"): got %v, want nil", p)
+	}
+
+	got, err := a.AuthorizationURL(context.Background(), "corp", testCallbackURL, "anonce")
+	if err != nil {
+		t.Fatalf("AuthorizationURL failed: %v", err)
+	}
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("url.Parse failed: %v", err)
+	}
+	if want := srv.URL + "/authorize"; u.Scheme+"://"+u.Host+u.Path != want {
+		t.Errorf("AuthorizationURL: got endpoint %q, want %q", u.Path, want)
+	}
+	for k, want := range map[string]string{
+		"response_type": "code",
+		"client_id":     testClientID,
+		"redirect_uri":  testCallbackURL,
+		"scope":         "openid profile email",
+		"state":         "anonce",
+	} {
+		if got := u.Query().Get(k); got != want {
+			t.Errorf("AuthorizationURL: got %s=%q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestOIDCProcessCallback(t *testing.T) {
+	srv := newTestIdentityProvider(t)
+	a := newTestAuthenticator(srv.URL)
+	ctx := context.Background()
+
+	tsts := []struct {
+		Name    string
+		Query   url.Values
+		WantErr bool
+	}{
+		{
+			Name:  "success",
+			Query: url.Values{"code": {testCode}, "state": {"anonce"}},
+		},
+		{
+			Name:    "wrongState",
+			Query:   url.Values{"code": {testCode}, "state": {"othernonce"}},
+			WantErr: true,
+		},
+		{
+			Name:    "wrongCode",
+			Query:   url.Values{"code": {"othercode"}, "state": {"anonce"}},
+			WantErr: true,
+		},
+		{
+			Name:    "providerError",
+			Query:   url.Values{"error": {"access_denied"}, "state": {"anonce"}},
+			WantErr: true,
+		},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			res, err := a.ProcessCallback(ctx, "corp", testCallbackURL, "anonce", tst.Query)
+			if tst.WantErr {
+				if err == nil {
+					t.Fatalf("ProcessCallback: got %+v, want an error", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessCallback failed: %v", err)
+			}
+			want := CallbackResult{
+				Identity:           Identity{IdPID: "corp", Subject: "1234"},
+				SuggestedLocalpart: "alice",
+				DisplayName:        "Alice Liddell",
+			}
+			if *res != want {
+				t.Errorf("ProcessCallback: got %+v, want %+v", *res, want)
+			}
+		})
+	}
+}
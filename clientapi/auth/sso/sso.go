@@ -0,0 +1,114 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sso implements single sign-on logins with external identity
+// providers.
+package sso
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// An Identity is a user's identity at an identity provider.
+type Identity struct {
+	// The ID of the identity provider, from the config.
+	IdPID string
+	// The subject that the identity provider uses for the user. It is
+	// stable and unique within the identity provider.
+	Subject string
+}
+
+// CallbackResult is the result of a successful login at an identity provider.
+type CallbackResult struct {
+	Identity Identity
+	// A localpart that the user would like, if the identity provider
+	// gave us one. It has not been validated.
+	SuggestedLocalpart string
+	// The display name of the user, if the identity provider gave us one.
+	DisplayName string
+}
+
+type identityProvider interface {
+	// AuthorizationURL returns the URL to send the user's browser to in order
+	// to log in. The identity provider will return the nonce to the callback
+	// URL along with the result of the login.
+	AuthorizationURL(ctx context.Context, callbackURL, nonce string) (string, error)
+	// ProcessCallback checks the query parameters that the identity provider
+	// sent to the callback URL and works out who the user is.
+	ProcessCallback(ctx context.Context, callbackURL, nonce string, query url.Values) (*CallbackResult, error)
+}
+
+// An Authenticator logs users in with the configured identity providers.
+type Authenticator struct {
+	cfg       *config.SSO
+	providers map[string]identityProvider
+}
+
+// NewAuthenticator creates an Authenticator for the identity providers in
+// the config.
+func NewAuthenticator(cfg *config.SSO) *Authenticator {
+	hc := &http.Client{Timeout: time.Second * 30}
+	a := &Authenticator{
+		cfg:       cfg,
+		providers: make(map[string]identityProvider, len(cfg.Providers)),
+	}
+	for i := range cfg.Providers {
+		a.providers[cfg.Providers[i].ID] = newOIDCIdentityProvider(&cfg.Providers[i], hc)
+	}
+	return a
+}
+
+// Provider returns the config of the identity provider with the given ID, or
+// of the default identity provider if the ID is empty. Returns nil if there is
+// no such identity provider.
+func (a *Authenticator) Provider(id string) *config.IdentityProvider {
+	if id == "" {
+		id = a.cfg.DefaultProviderID
+		if id == "" && len(a.cfg.Providers) > 0 {
+			id = a.cfg.Providers[0].ID
+		}
+	}
+	for i := range a.cfg.Providers {
+		if a.cfg.Providers[i].ID == id {
+			return &a.cfg.Providers[i]
+		}
+	}
+	return nil
+}
+
+// AuthorizationURL returns the URL to send the user's browser to in order to
+// log in with the identity provider.
+func (a *Authenticator) AuthorizationURL(ctx context.Context, providerID, callbackURL, nonce string) (string, error) {
+	p, ok := a.providers[providerID]
+	if !ok {
+		return "", fmt.Errorf("unknown identity provider %q", providerID)
+	}
+	return p.AuthorizationURL(ctx, callbackURL, nonce)
+}
+
+// ProcessCallback works out who the user is once the identity provider has
+// sent them back to the callback URL.
+func (a *Authenticator) ProcessCallback(ctx context.Context, providerID, callbackURL, nonce string, query url.Values) (*CallbackResult, error) {
+	p, ok := a.providers[providerID]
+	if !ok {
+		return nil, fmt.Errorf("unknown identity provider %q", providerID)
+	}
+	return p.ProcessCallback(ctx, callbackURL, nonce, query)
+}
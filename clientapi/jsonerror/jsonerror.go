@@ -149,6 +149,30 @@ func MissingParam(msg string) *MatrixError {
 	return &MatrixError{"M_MISSING_PARAM", msg}
 }
 
+// UnableToAuthoriseJoin is an error that is returned when a server can't
+// determine whether a user is allowed to join a restricted room.
+func UnableToAuthoriseJoin(msg string) *MatrixError {
+	return &MatrixError{"M_UNABLE_TO_AUTHORISE_JOIN", msg}
+}
+
+// UnableToGrantJoin is an error that is returned when a server can't issue
+// a restricted join, e.g. because none of its users have the power to invite.
+func UnableToGrantJoin(msg string) *MatrixError {
+	return &MatrixError{"M_UNABLE_TO_GRANT_JOIN", msg}
+}
+
+// UnknownPos is an error that is returned when the client tries to continue
+// a sliding sync session that the server doesn't know about (MSC3575).
+func UnknownPos(msg string) *MatrixError {
+	return &MatrixError{"M_UNKNOWN_POS", msg}
+}
+
+// ResourceLimitExceeded is an error when the client has used up a resource
+// that the server limits, such as their media quota.
+func ResourceLimitExceeded(msg string) *MatrixError {
+	return &MatrixError{"M_RESOURCE_LIMIT_EXCEEDED", msg}
+}
+
 type IncompatibleRoomVersionError struct {
 	RoomVersion string `json:"room_version"`
 	Error       string `json:"error"`
@@ -185,6 +209,23 @@ func LimitExceeded(msg string, retryAfterMS int64) *LimitExceededError {
 	}
 }
 
+// SoftLogoutError is an unknown token error that tells the client whether it
+// can log in again without losing its session.
+type SoftLogoutError struct {
+	MatrixError
+	SoftLogout bool `json:"soft_logout"`
+}
+
+// ExpiredToken is an error when the client supplies an access token that has
+// expired. The client can get a new one by refreshing or logging in again,
+// without losing its session.
+func ExpiredToken(msg string) *SoftLogoutError {
+	return &SoftLogoutError{
+		MatrixError: MatrixError{"M_UNKNOWN_TOKEN", msg},
+		SoftLogout:  true,
+	}
+}
+
 // NotTrusted is an error which is returned when the client asks the server to
 // proxy a request (e.g. 3PID association) to a server that isn't trusted
 func NotTrusted(serverName string) *MatrixError {
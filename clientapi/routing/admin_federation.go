@@ -0,0 +1,83 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	federationAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// GetAdminFederationBlacklist implements GET /admin/federation/blacklist
+func GetAdminFederationBlacklist(
+	req *http.Request, federationSender federationAPI.FederationInternalAPI,
+) util.JSONResponse {
+	var res federationAPI.QueryBlacklistResponse
+	if err := federationSender.QueryBlacklist(req.Context(), &federationAPI.QueryBlacklistRequest{}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("federationSender.QueryBlacklist failed")
+		return jsonerror.InternalServerError()
+	}
+	if res.Destinations == nil {
+		res.Destinations = []federationAPI.DestinationStatus{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminBlacklistServer implements PUT /admin/federation/blacklist/{serverName}
+func AdminBlacklistServer(
+	req *http.Request, cfg *config.ClientAPI,
+	federationSender federationAPI.FederationInternalAPI, serverName gomatrixserverlib.ServerName,
+) util.JSONResponse {
+	if serverName == cfg.Matrix.ServerName {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("Cannot blacklist this server"),
+		}
+	}
+	if err := federationSender.PerformBlacklist(req.Context(), &federationAPI.PerformBlacklistRequest{
+		ServerName: serverName,
+	}, &federationAPI.PerformBlacklistResponse{}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("federationSender.PerformBlacklist failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// AdminUnblacklistServer implements DELETE /admin/federation/blacklist/{serverName}
+func AdminUnblacklistServer(
+	req *http.Request, federationSender federationAPI.FederationInternalAPI,
+	serverName gomatrixserverlib.ServerName,
+) util.JSONResponse {
+	if err := federationSender.PerformUnblacklist(req.Context(), &federationAPI.PerformUnblacklistRequest{
+		ServerName: serverName,
+	}, &federationAPI.PerformUnblacklistResponse{}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("federationSender.PerformUnblacklist failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+const (
+	defaultEventReportsLimit = 100
+	maxEventReportsLimit     = 1000
+)
+
+type adminEventReportsResponse struct {
+	EventReports []userapi.EventReport `json:"event_reports"`
+	NextToken    *int                  `json:"next_token,omitempty"`
+	Total        int64                 `json:"total"`
+}
+
+type adminEventReportResponse struct {
+	userapi.EventReport
+	EventJSON json.RawMessage `json:"event_json,omitempty"`
+}
+
+// GetAdminEventReports implements GET /admin/event_reports
+func GetAdminEventReports(
+	req *http.Request, userAPI userapi.UserInternalAPI,
+) util.JSONResponse {
+	query := req.URL.Query()
+	from, limit := 0, defaultEventReportsLimit
+	var err error
+	if s := query.Get("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil || from < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("from must be a non-negative integer"),
+			}
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("limit must be a positive integer"),
+			}
+		}
+	}
+	if limit > maxEventReportsLimit {
+		limit = maxEventReportsLimit
+	}
+
+	var queryRes userapi.QueryEventReportsResponse
+	err = userAPI.QueryEventReports(req.Context(), &userapi.QueryEventReportsRequest{
+		RoomID:         query.Get("room_id"),
+		UserID:         query.Get("user_id"),
+		OnlyUnresolved: query.Get("resolved") == "false",
+		Limit:          limit,
+		Offset:         from,
+	}, &queryRes)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryEventReports failed")
+		return jsonerror.InternalServerError()
+	}
+	res := adminEventReportsResponse{
+		EventReports: queryRes.Reports,
+		Total:        queryRes.Total,
+	}
+	if res.EventReports == nil {
+		res.EventReports = []userapi.EventReport{}
+	}
+	if next := from + len(queryRes.Reports); int64(next) < queryRes.Total {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// GetAdminEventReport implements GET /admin/event_reports/{reportID}
+func GetAdminEventReport(
+	req *http.Request, userAPI userapi.UserInternalAPI,
+	rsAPI roomserverAPI.RoomserverInternalAPI, reportID string,
+) util.JSONResponse {
+	id, err := strconv.ParseInt(reportID, 10, 64)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("reportID must be an integer"),
+		}
+	}
+	var queryRes userapi.QueryEventReportResponse
+	err = userAPI.QueryEventReport(req.Context(), &userapi.QueryEventReportRequest{
+		ReportID: id,
+	}, &queryRes)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryEventReport failed")
+		return jsonerror.InternalServerError()
+	}
+	if queryRes.Report == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event report not found"),
+		}
+	}
+
+	res := adminEventReportResponse{
+		EventReport: *queryRes.Report,
+	}
+	var eventsRes roomserverAPI.QueryEventsByIDResponse
+	err = rsAPI.QueryEventsByID(req.Context(), &roomserverAPI.QueryEventsByIDRequest{
+		EventIDs: []string{queryRes.Report.EventID},
+	}, &eventsRes)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryEventsByID failed")
+		return jsonerror.InternalServerError()
+	}
+	// The event may since have been purged, in which case the report is
+	// returned without it.
+	if len(eventsRes.Events) == 1 {
+		res.EventJSON = eventsRes.Events[0].JSON()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// ResolveAdminEventReport implements POST /admin/event_reports/{reportID}/resolve
+func ResolveAdminEventReport(
+	req *http.Request, userAPI userapi.UserInternalAPI,
+	reportID string,
+) util.JSONResponse {
+	id, err := strconv.ParseInt(reportID, 10, 64)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("reportID must be an integer"),
+		}
+	}
+	var res userapi.PerformEventReportResolutionResponse
+	err = userAPI.PerformEventReportResolution(req.Context(), &userapi.PerformEventReportResolutionRequest{
+		ReportID: id,
+	}, &res)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformEventReportResolution failed")
+		return jsonerror.InternalServerError()
+	}
+	if !res.Found {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event report not found"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
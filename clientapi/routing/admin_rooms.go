@@ -0,0 +1,77 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+type adminRoomShutdownRequest struct {
+	Purge bool `json:"purge"`
+}
+
+type adminRoomShutdownResponse struct {
+	KickedUsers    []string `json:"kicked_users"`
+	RemovedAliases []string `json:"removed_aliases"`
+	// The media referred to by the purged events, so that the admin can
+	// decide whether to delete it too.
+	Media []string `json:"media"`
+}
+
+// AdminShutdownRoom implements POST /admin/rooms/{roomID}/shutdown
+func AdminShutdownRoom(
+	req *http.Request, device *userapi.Device,
+	rsAPI roomserverAPI.RoomserverInternalAPI, roomID string,
+) util.JSONResponse {
+	var r adminRoomShutdownRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+
+	var shutdownRes roomserverAPI.PerformAdminRoomShutdownResponse
+	rsAPI.PerformAdminRoomShutdown(req.Context(), &roomserverAPI.PerformAdminRoomShutdownRequest{
+		RoomID: roomID,
+		UserID: device.UserID,
+		Purge:  r.Purge,
+	}, &shutdownRes)
+	if shutdownRes.Error != nil {
+		util.GetLogger(req.Context()).WithError(shutdownRes.Error).Error("PerformAdminRoomShutdown failed")
+		return shutdownRes.Error.JSONResponse()
+	}
+
+	res := adminRoomShutdownResponse{
+		KickedUsers:    shutdownRes.KickedUsers,
+		RemovedAliases: shutdownRes.RemovedAliases,
+		Media:          shutdownRes.MediaURIs,
+	}
+	if res.KickedUsers == nil {
+		res.KickedUsers = []string{}
+	}
+	if res.RemovedAliases == nil {
+		res.RemovedAliases = []string{}
+	}
+	if res.Media == nil {
+		res.Media = []string{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+type adminUserAdminRequest struct {
+	Admin bool `json:"admin"`
+}
+
+// AdminSetUserAdmin implements PUT /admin/users/{userID}/admin
+func AdminSetUserAdmin(
+	req *http.Request, device *userapi.Device, cfg *config.ClientAPI,
+	userAPI userapi.UserInternalAPI, userID string,
+) util.JSONResponse {
+	var r adminUserAdminRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue(err.Error()),
+		}
+	}
+	if domain != cfg.Matrix.ServerName {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("Only local users can be admins"),
+		}
+	}
+	// Otherwise the server could be left without any admins.
+	if !r.Admin && userID == device.UserID {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("You cannot revoke your own admin rights"),
+		}
+	}
+
+	accountType := userapi.AccountTypeUser
+	if r.Admin {
+		accountType = userapi.AccountTypeAdmin
+	}
+	var res userapi.PerformAccountTypeUpdateResponse
+	if err = userAPI.PerformAccountTypeUpdate(req.Context(), &userapi.PerformAccountTypeUpdateRequest{
+		Localpart:   localpart,
+		AccountType: accountType,
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformAccountTypeUpdate failed")
+		return jsonerror.InternalServerError()
+	}
+	if !res.Updated {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("No such user, or the user is a guest"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
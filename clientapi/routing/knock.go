@@ -0,0 +1,89 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+type knockRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// KnockRoomByIDOrAlias implements POST /knock/{roomIDOrAlias}
+func KnockRoomByIDOrAlias(
+	req *http.Request,
+	device *api.Device,
+	rsAPI roomserverAPI.RoomserverInternalAPI,
+	accountDB accounts.Database,
+	roomIDOrAlias string,
+) util.JSONResponse {
+	var r knockRequest
+	if req.ContentLength != 0 {
+		if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+			return *resErr
+		}
+	}
+
+	knockReq := roomserverAPI.PerformKnockRequest{
+		RoomIDOrAlias: roomIDOrAlias,
+		UserID:        device.UserID,
+		Content:       map[string]interface{}{},
+	}
+	if r.Reason != "" {
+		knockReq.Content["reason"] = r.Reason
+	}
+
+	// Check to see if any ?server_name= query parameters were
+	// given in the request.
+	for _, serverName := range req.URL.Query()["server_name"] {
+		knockReq.ServerNames = append(
+			knockReq.ServerNames,
+			gomatrixserverlib.ServerName(serverName),
+		)
+	}
+
+	// Include our profile in the knock so that the room admins can
+	// see who is asking to join.
+	localpart, _, err := gomatrixserverlib.SplitID('@', device.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("gomatrixserverlib.SplitID failed")
+	} else if profile, err := accountDB.GetProfileByLocalpart(req.Context(), localpart); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("accountDB.GetProfileByLocalpart failed")
+	} else {
+		knockReq.Content["displayname"] = profile.DisplayName
+		knockReq.Content["avatar_url"] = profile.AvatarURL
+	}
+
+	var knockRes roomserverAPI.PerformKnockResponse
+	rsAPI.PerformKnock(req.Context(), &knockReq, &knockRes)
+	if knockRes.Error != nil {
+		return knockRes.Error.JSONResponse()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct {
+			RoomID string `json:"room_id"`
+		}{knockRes.RoomID},
+	}
+}
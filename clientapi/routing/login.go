@@ -29,10 +29,12 @@ import (
 )
 
 type loginResponse struct {
-	UserID      string                       `json:"user_id"`
-	AccessToken string                       `json:"access_token"`
-	HomeServer  gomatrixserverlib.ServerName `json:"home_server"`
-	DeviceID    string                       `json:"device_id"`
+	UserID       string                       `json:"user_id"`
+	AccessToken  string                       `json:"access_token"`
+	RefreshToken string                       `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64                        `json:"expires_in_ms,omitempty"`
+	HomeServer   gomatrixserverlib.ServerName `json:"home_server"`
+	DeviceID     string                       `json:"device_id"`
 }
 
 type flows struct {
@@ -40,15 +42,38 @@ type flows struct {
 }
 
 type flow struct {
-	Type string `json:"type"`
+	Type              string             `json:"type"`
+	IdentityProviders []identityProvider `json:"identity_providers,omitempty"`
 }
 
-func passwordLogin() flows {
+type identityProvider struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Icon string `json:"icon,omitempty"`
+}
+
+func loginFlows(cfg *config.ClientAPI) flows {
 	f := flows{}
-	s := flow{
+	f.Flows = append(f.Flows, flow{
 		Type: "m.login.password",
+	})
+	if cfg.Login.SSO.Enabled {
+		ssoFlow := flow{
+			Type: "m.login.sso",
+		}
+		for _, p := range cfg.Login.SSO.Providers {
+			ssoFlow.IdentityProviders = append(ssoFlow.IdentityProviders, identityProvider{
+				ID:   p.ID,
+				Name: p.Name,
+				Icon: p.Icon,
+			})
+		}
+		// Clients complete an SSO login by exchanging the login token that
+		// the callback gives them.
+		f.Flows = append(f.Flows, ssoFlow, flow{
+			Type: "m.login.token",
+		})
 	}
-	f.Flows = append(f.Flows, s)
 	return f
 }
 
@@ -58,10 +83,9 @@ func Login(
 	cfg *config.ClientAPI,
 ) util.JSONResponse {
 	if req.Method == http.MethodGet {
-		// TODO: support other forms of login other than password, depending on config options
 		return util.JSONResponse{
 			Code: http.StatusOK,
-			JSON: passwordLogin(),
+			JSON: loginFlows(cfg),
 		}
 	} else if req.Method == http.MethodPost {
 		login, cleanup, authErr := auth.LoginFromJSONReader(req.Context(), req.Body, accountDB, userAPI, cfg)
@@ -103,6 +127,7 @@ func completeAuth(
 		Localpart:         localpart,
 		IPAddr:            ipAddr,
 		UserAgent:         userAgent,
+		RefreshToken:      login.RefreshToken,
 	}, &performRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -114,10 +139,12 @@ func completeAuth(
 	return util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: loginResponse{
-			UserID:      performRes.Device.UserID,
-			AccessToken: performRes.Device.AccessToken,
-			HomeServer:  serverName,
-			DeviceID:    performRes.Device.ID,
+			UserID:       performRes.Device.UserID,
+			AccessToken:  performRes.Device.AccessToken,
+			RefreshToken: performRes.RefreshToken,
+			ExpiresInMS:  accessTokenExpiresInMS(performRes.Device),
+			HomeServer:   serverName,
+			DeviceID:     performRes.Device.ID,
 		},
 	}
 }
@@ -0,0 +1,169 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+const (
+	// The maximum lengths of pusher fields, as in Synapse.
+	maxPusherAppIDLength   = 64
+	maxPusherPushKeyLength = 512
+
+	// The path that HTTP pusher URLs must have, as required by the
+	// Push Gateway API.
+	pushGatewayNotifyPath = "/_matrix/push/v1/notify"
+)
+
+// pusherSetRequest is the body of a POST /pushers/set request.
+type pusherSetRequest struct {
+	userapi.Pusher
+	Append bool `json:"append"`
+}
+
+// GetPushers implements GET /_matrix/client/r0/pushers
+func GetPushers(
+	req *http.Request, device *userapi.Device,
+	userAPI userapi.UserInternalAPI,
+) util.JSONResponse {
+	localpart, _, err := gomatrixserverlib.SplitID('@', device.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("SplitID failed")
+		return jsonerror.InternalServerError()
+	}
+	var queryRes userapi.QueryPushersResponse
+	err = userAPI.QueryPushers(req.Context(), &userapi.QueryPushersRequest{
+		Localpart: localpart,
+	}, &queryRes)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("QueryPushers failed")
+		return jsonerror.InternalServerError()
+	}
+	for i := range queryRes.Pushers {
+		// The session ID is an internal detail, so isn't returned to clients.
+		queryRes.Pushers[i].SessionID = 0
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: queryRes,
+	}
+}
+
+// SetPusher implements POST /_matrix/client/r0/pushers/set
+// This endpoint allows the creation, modification and deletion of pushers for this user ID.
+// The behaviour of this endpoint varies depending on the values in the JSON body.
+func SetPusher(
+	req *http.Request, device *userapi.Device,
+	userAPI userapi.UserInternalAPI,
+) util.JSONResponse {
+	localpart, _, err := gomatrixserverlib.SplitID('@', device.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("SplitID failed")
+		return jsonerror.InternalServerError()
+	}
+	var body pusherSetRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+	if body.AppID == "" || len(body.AppID) > maxPusherAppIDLength {
+		return invalidParam("app_id must be between 1 and 64 characters")
+	}
+	if body.PushKey == "" || len(body.PushKey) > maxPusherPushKeyLength {
+		return invalidParam("pushkey must be between 1 and 512 bytes")
+	}
+
+	// A null kind means the pusher should be removed.
+	if body.Kind == "" {
+		err = userAPI.PerformPusherDeletion(req.Context(), &userapi.PerformPusherDeletionRequest{
+			Localpart: localpart,
+			AppID:     body.AppID,
+			PushKey:   body.PushKey,
+		}, &userapi.PerformPusherDeletionResponse{})
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("PerformPusherDeletion failed")
+			return jsonerror.InternalServerError()
+		}
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}
+	}
+
+	switch body.Kind {
+	case userapi.HTTPKind:
+		// The url field is required for HTTP pushers, and must be a valid
+		// Push Gateway API URL.
+		pushURL, ok := body.Data["url"].(string)
+		if !ok || pushURL == "" {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.MissingParam("data.url is required for http pushers"),
+			}
+		}
+		pURL, perr := url.Parse(pushURL)
+		if perr != nil || (pURL.Scheme != "http" && pURL.Scheme != "https") || pURL.Host == "" {
+			return invalidParam("data.url must be a valid http or https URL")
+		}
+		if pURL.Path != pushGatewayNotifyPath {
+			return invalidParam("data.url must have the path " + pushGatewayNotifyPath)
+		}
+	case userapi.EmailKind:
+		// Nothing extra to validate.
+	default:
+		return invalidParam("kind must be either 'http' or 'email'")
+	}
+
+	if body.AppDisplayName == "" || body.DeviceDisplayName == "" || body.Language == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingParam("app_display_name, device_display_name and lang are required"),
+		}
+	}
+	if body.Data == nil {
+		body.Data = map[string]interface{}{}
+	}
+
+	body.Pusher.SessionID = device.SessionID
+	body.Pusher.PushKeyTS = 0
+	err = userAPI.PerformPusherSet(req.Context(), &userapi.PerformPusherSetRequest{
+		Pusher:    body.Pusher,
+		Localpart: localpart,
+		Append:    body.Append,
+	}, &userapi.PerformPusherSetResponse{})
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("PerformPusherSet failed")
+		return jsonerror.InternalServerError()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func invalidParam(msg string) util.JSONResponse {
+	return util.JSONResponse{
+		Code: http.StatusBadRequest,
+		JSON: jsonerror.InvalidParam(msg),
+	}
+}
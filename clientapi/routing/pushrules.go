@@ -0,0 +1,417 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/producers"
+	"github.com/matrix-org/dendrite/internal/pushrules"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+// GetAllPushRules implements GET /_matrix/client/r0/pushrules/
+func GetAllPushRules(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: ruleSets,
+	}
+}
+
+// GetPushRulesByScope implements GET /_matrix/client/r0/pushrules/{scope}/
+func GetPushRulesByScope(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	scope string,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	ruleSet, resErr := pushRuleSetByScope(ruleSets, scope)
+	if resErr != nil {
+		return *resErr
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: ruleSet,
+	}
+}
+
+// GetPushRulesByKind implements GET /_matrix/client/r0/pushrules/{scope}/{kind}/
+func GetPushRulesByKind(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	scope, kind string,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: *rules,
+	}
+}
+
+// GetPushRuleByRuleID implements GET /_matrix/client/r0/pushrules/{scope}/{kind}/{ruleID}
+func GetPushRuleByRuleID(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	scope, kind, ruleID string,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+	i := pushRuleIndexByID(*rules, ruleID)
+	if i < 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("push rule ID not found"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: (*rules)[i],
+	}
+}
+
+// PutPushRuleByRuleID implements PUT /_matrix/client/r0/pushrules/{scope}/{kind}/{ruleID}
+func PutPushRuleByRuleID(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	syncProducer *producers.SyncAPIProducer, scope, kind, ruleID string,
+) util.JSONResponse {
+	if strings.HasPrefix(ruleID, ".") {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("rule IDs starting with a dot are reserved for server-default rules"),
+		}
+	}
+
+	var newRule pushrules.Rule
+	if resErr := httputil.UnmarshalJSONRequest(req, &newRule); resErr != nil {
+		return *resErr
+	}
+	newRule.RuleID = ruleID
+	newRule.Default = false
+	newRule.Enabled = true
+	switch pushrules.Kind(kind) {
+	case pushrules.OverrideKind, pushrules.UnderrideKind:
+		if newRule.Conditions == nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.MissingParam("missing conditions for " + kind + " rule"),
+			}
+		}
+	}
+	if errs := pushrules.ValidateRule(pushrules.Kind(kind), &newRule); len(errs) > 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue(errs[0].Error()),
+		}
+	}
+
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+
+	query := req.URL.Query()
+	beforeRuleID, afterRuleID := query.Get("before"), query.Get("after")
+
+	i := pushRuleIndexByID(*rules, ruleID)
+	if i >= 0 {
+		// Replacing a rule keeps it enabled or disabled as it was.
+		newRule.Enabled = (*rules)[i].Enabled
+		if beforeRuleID == "" && afterRuleID == "" {
+			(*rules)[i] = &newRule
+			return putPushRules(req, device.UserID, ruleSets, userAPI, syncProducer)
+		}
+		*rules = append((*rules)[:i], (*rules)[i+1:]...)
+	}
+
+	var pos int
+	switch {
+	case beforeRuleID != "" || afterRuleID != "":
+		anchorRuleID := beforeRuleID
+		if anchorRuleID == "" {
+			anchorRuleID = afterRuleID
+		}
+		pos = pushRuleIndexByID(*rules, anchorRuleID)
+		if pos < 0 {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: jsonerror.NotFound("before/after rule not found: " + anchorRuleID),
+			}
+		}
+		if (*rules)[pos].Default {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("rules cannot be positioned relative to server-default rules"),
+			}
+		}
+		if beforeRuleID == "" {
+			pos++
+		}
+	default:
+		// New rules get the highest priority of all user-defined rules
+		// of the same kind.
+		pos = firstUserPushRuleIndex(*rules)
+	}
+
+	*rules = append((*rules)[:pos], append([]*pushrules.Rule{&newRule}, (*rules)[pos:]...)...)
+	return putPushRules(req, device.UserID, ruleSets, userAPI, syncProducer)
+}
+
+// DeletePushRuleByRuleID implements DELETE /_matrix/client/r0/pushrules/{scope}/{kind}/{ruleID}
+func DeletePushRuleByRuleID(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	syncProducer *producers.SyncAPIProducer, scope, kind, ruleID string,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+	i := pushRuleIndexByID(*rules, ruleID)
+	if i < 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("push rule ID not found"),
+		}
+	}
+	if (*rules)[i].Default {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("server-default rules cannot be deleted"),
+		}
+	}
+	*rules = append((*rules)[:i], (*rules)[i+1:]...)
+	return putPushRules(req, device.UserID, ruleSets, userAPI, syncProducer)
+}
+
+// GetPushRuleAttrByRuleID implements GET /_matrix/client/r0/pushrules/{scope}/{kind}/{ruleID}/{attr}
+func GetPushRuleAttrByRuleID(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	scope, kind, ruleID, attr string,
+) util.JSONResponse {
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+	i := pushRuleIndexByID(*rules, ruleID)
+	if i < 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("push rule ID not found"),
+		}
+	}
+	rule := (*rules)[i]
+	switch attr {
+	case "enabled":
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: pushRuleAttrJSON{Enabled: &rule.Enabled},
+		}
+	case "actions":
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: pushRuleAttrJSON{Actions: rule.Actions},
+		}
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("invalid push rule attribute"),
+		}
+	}
+}
+
+// PutPushRuleAttrByRuleID implements PUT /_matrix/client/r0/pushrules/{scope}/{kind}/{ruleID}/{attr}
+func PutPushRuleAttrByRuleID(
+	req *http.Request, device *userapi.Device, userAPI userapi.UserInternalAPI,
+	syncProducer *producers.SyncAPIProducer, scope, kind, ruleID, attr string,
+) util.JSONResponse {
+	var body pushRuleAttrJSON
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+
+	ruleSets, resErr := queryPushRules(req, device.UserID, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	rules, resErr := pushRulesByKind(ruleSets, scope, kind)
+	if resErr != nil {
+		return *resErr
+	}
+	i := pushRuleIndexByID(*rules, ruleID)
+	if i < 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("push rule ID not found"),
+		}
+	}
+	rule := (*rules)[i]
+
+	switch attr {
+	case "enabled":
+		if body.Enabled == nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.MissingParam("missing enabled"),
+			}
+		}
+		rule.Enabled = *body.Enabled
+	case "actions":
+		if body.Actions == nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.MissingParam("missing actions"),
+			}
+		}
+		updated := *rule
+		updated.Actions = body.Actions
+		if errs := pushrules.ValidateRule(pushrules.Kind(kind), &updated); len(errs) > 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue(errs[0].Error()),
+			}
+		}
+		rule.Actions = body.Actions
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("invalid push rule attribute"),
+		}
+	}
+
+	return putPushRules(req, device.UserID, ruleSets, userAPI, syncProducer)
+}
+
+type pushRuleAttrJSON struct {
+	Enabled *bool               `json:"enabled,omitempty"`
+	Actions []*pushrules.Action `json:"actions,omitempty"`
+}
+
+func queryPushRules(
+	req *http.Request, userID string, userAPI userapi.UserInternalAPI,
+) (*pushrules.AccountRuleSets, *util.JSONResponse) {
+	queryReq := userapi.QueryPushRulesRequest{UserID: userID}
+	var queryRes userapi.QueryPushRulesResponse
+	if err := userAPI.QueryPushRules(req.Context(), &queryReq, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryPushRules failed")
+		resErr := jsonerror.InternalServerError()
+		return nil, &resErr
+	}
+	return queryRes.RuleSets, nil
+}
+
+func putPushRules(
+	req *http.Request, userID string, ruleSets *pushrules.AccountRuleSets,
+	userAPI userapi.UserInternalAPI, syncProducer *producers.SyncAPIProducer,
+) util.JSONResponse {
+	putReq := userapi.PerformPushRulesPutRequest{
+		UserID:   userID,
+		RuleSets: ruleSets,
+	}
+	var putRes userapi.PerformPushRulesPutResponse
+	if err := userAPI.PerformPushRulesPut(req.Context(), &putReq, &putRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformPushRulesPut failed")
+		return jsonerror.InternalServerError()
+	}
+
+	if err := syncProducer.SendData(userID, "", "m.push_rules"); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("syncProducer.SendData failed")
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func pushRuleSetByScope(ruleSets *pushrules.AccountRuleSets, scope string) (*pushrules.RuleSet, *util.JSONResponse) {
+	ruleSet := ruleSets.RuleSetForScope(pushrules.Scope(scope))
+	if ruleSet == nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("invalid push rule set scope"),
+		}
+	}
+	return ruleSet, nil
+}
+
+func pushRulesByKind(ruleSets *pushrules.AccountRuleSets, scope, kind string) (*[]*pushrules.Rule, *util.JSONResponse) {
+	ruleSet, resErr := pushRuleSetByScope(ruleSets, scope)
+	if resErr != nil {
+		return nil, resErr
+	}
+	rules := ruleSet.RulesForKind(pushrules.Kind(kind))
+	if rules == nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("invalid push rule kind"),
+		}
+	}
+	return rules, nil
+}
+
+func pushRuleIndexByID(rules []*pushrules.Rule, ruleID string) int {
+	for i, rule := range rules {
+		if rule.RuleID == ruleID {
+			return i
+		}
+	}
+	return -1
+}
+
+// firstUserPushRuleIndex returns the position at which a new user-defined
+// rule with the highest priority should be inserted. Only .m.rule.master
+// ranks above all user-defined rules.
+func firstUserPushRuleIndex(rules []*pushrules.Rule) int {
+	for i, rule := range rules {
+		if !rule.Default || rule.RuleID != pushrules.MRuleMaster {
+			return i
+		}
+	}
+	return len(rules)
+}
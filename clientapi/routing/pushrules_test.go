@@ -0,0 +1,214 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/clientapi/producers"
+	"github.com/matrix-org/dendrite/internal/pushrules"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/nats-io/nats.go"
+)
+
+// pushRulesUserAPI keeps a single user's push rules. Rules are copied in and
+// out through JSON, as they would be when stored in the database.
+type pushRulesUserAPI struct {
+	userapi.UserInternalAPI
+	ruleSets []byte
+}
+
+func (a *pushRulesUserAPI) QueryPushRules(ctx context.Context, req *userapi.QueryPushRulesRequest, res *userapi.QueryPushRulesResponse) error {
+	res.RuleSets = &pushrules.AccountRuleSets{}
+	return json.Unmarshal(a.ruleSets, res.RuleSets)
+}
+
+func (a *pushRulesUserAPI) PerformPushRulesPut(ctx context.Context, req *userapi.PerformPushRulesPutRequest, res *userapi.PerformPushRulesPutResponse) error {
+	var err error
+	a.ruleSets, err = json.Marshal(req.RuleSets)
+	return err
+}
+
+// pushRulesJetStream drops everything that is published to it.
+type pushRulesJetStream struct {
+	nats.JetStreamContext
+}
+
+func (js *pushRulesJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	return &nats.PubAck{}, nil
+}
+
+func TestPushRules(t *testing.T) {
+	device := &userapi.Device{UserID: "@alice:test"}
+	syncProducer := &producers.SyncAPIProducer{JetStream: &pushRulesJetStream{}}
+	newUserAPI := func(t *testing.T) *pushRulesUserAPI {
+		t.Helper()
+		ruleSets, err := json.Marshal(pushrules.DefaultAccountRuleSets("alice", "test"))
+		if err != nil {
+			t.Fatalf("failed to marshal default push rules: %s", err)
+		}
+		return &pushRulesUserAPI{ruleSets: ruleSets}
+	}
+	newRequest := func(method, query, body string) *http.Request {
+		return httptest.NewRequest(method, "/pushrules/"+query, strings.NewReader(body))
+	}
+	ruleIDs := func(t *testing.T, userAPI *pushRulesUserAPI, kind pushrules.Kind) []string {
+		t.Helper()
+		res := GetPushRulesByKind(newRequest(http.MethodGet, "", ""), device, userAPI, "global", string(kind))
+		if res.Code != http.StatusOK {
+			t.Fatalf("GetPushRulesByKind: got HTTP %d, want 200", res.Code)
+		}
+		var ids []string
+		for _, rule := range res.JSON.([]*pushrules.Rule) {
+			ids = append(ids, rule.RuleID)
+		}
+		return ids
+	}
+	const overrideRule = `{"actions":["notify"],"conditions":[{"kind":"event_match","key":"content.body","pattern":"cake"}]}`
+
+	t.Run("positioning", func(t *testing.T) {
+		userAPI := newUserAPI(t)
+		for _, tc := range []struct {
+			ruleID, query string
+		}{
+			{"a", ""},
+			{"b", "?before=a"},
+			{"c", "?after=a"},
+			{"d", ""},
+		} {
+			res := PutPushRuleByRuleID(newRequest(http.MethodPut, tc.query, overrideRule), device, userAPI, syncProducer, "global", "override", tc.ruleID)
+			if res.Code != http.StatusOK {
+				t.Fatalf("PutPushRuleByRuleID(%s%s): got HTTP %d, want 200: %+v", tc.ruleID, tc.query, res.Code, res.JSON)
+			}
+		}
+		ids := ruleIDs(t, userAPI, pushrules.OverrideKind)
+		want := []string{pushrules.MRuleMaster, "d", "b", "a", "c", pushrules.MRuleSuppressNotices}
+		for i := range want {
+			if i >= len(ids) || ids[i] != want[i] {
+				t.Fatalf("got override rules %v, want them to start with %v", ids, want)
+			}
+		}
+
+		// Moving an existing rule keeps a single copy of it.
+		res := PutPushRuleByRuleID(newRequest(http.MethodPut, "?after=c", overrideRule), device, userAPI, syncProducer, "global", "override", "d")
+		if res.Code != http.StatusOK {
+			t.Fatalf("PutPushRuleByRuleID: got HTTP %d, want 200: %+v", res.Code, res.JSON)
+		}
+		ids = ruleIDs(t, userAPI, pushrules.OverrideKind)
+		want = []string{pushrules.MRuleMaster, "b", "a", "c", "d", pushrules.MRuleSuppressNotices}
+		for i := range want {
+			if i >= len(ids) || ids[i] != want[i] {
+				t.Fatalf("got override rules %v, want them to start with %v", ids, want)
+			}
+		}
+	})
+
+	t.Run("invalid positioning", func(t *testing.T) {
+		userAPI := newUserAPI(t)
+		for _, tc := range []struct {
+			query    string
+			wantCode int
+		}{
+			{"?before=unknown", http.StatusNotFound},
+			{"?after=" + pushrules.MRuleSuppressNotices, http.StatusBadRequest},
+		} {
+			res := PutPushRuleByRuleID(newRequest(http.MethodPut, tc.query, overrideRule), device, userAPI, syncProducer, "global", "override", "a")
+			if res.Code != tc.wantCode {
+				t.Errorf("PutPushRuleByRuleID(%s): got HTTP %d, want %d", tc.query, res.Code, tc.wantCode)
+			}
+		}
+	})
+
+	t.Run("rejects reserved rule IDs", func(t *testing.T) {
+		userAPI := newUserAPI(t)
+		res := PutPushRuleByRuleID(newRequest(http.MethodPut, "", overrideRule), device, userAPI, syncProducer, "global", "override", ".my.rule")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("PutPushRuleByRuleID: got HTTP %d, want 400", res.Code)
+		}
+		res = PutPushRuleByRuleID(newRequest(http.MethodPut, "", overrideRule), device, userAPI, syncProducer, "global", "override", pushrules.MRuleMaster)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("PutPushRuleByRuleID(%s): got HTTP %d, want 400", pushrules.MRuleMaster, res.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		userAPI := newUserAPI(t)
+		res := PutPushRuleByRuleID(newRequest(http.MethodPut, "", overrideRule), device, userAPI, syncProducer, "global", "override", "a")
+		if res.Code != http.StatusOK {
+			t.Fatalf("PutPushRuleByRuleID: got HTTP %d, want 200: %+v", res.Code, res.JSON)
+		}
+		for _, tc := range []struct {
+			kind, ruleID string
+			wantCode     int
+		}{
+			{"underride", pushrules.MRuleMessage, http.StatusBadRequest},
+			{"override", pushrules.MRuleMaster, http.StatusBadRequest},
+			{"override", "unknown", http.StatusNotFound},
+			{"override", "a", http.StatusOK},
+			{"override", "a", http.StatusNotFound},
+		} {
+			res = DeletePushRuleByRuleID(newRequest(http.MethodDelete, "", ""), device, userAPI, syncProducer, "global", tc.kind, tc.ruleID)
+			if res.Code != tc.wantCode {
+				t.Errorf("DeletePushRuleByRuleID(%s/%s): got HTTP %d, want %d", tc.kind, tc.ruleID, res.Code, tc.wantCode)
+			}
+		}
+		for _, id := range ruleIDs(t, userAPI, pushrules.UnderrideKind) {
+			if id == pushrules.MRuleMessage {
+				return
+			}
+		}
+		t.Errorf("server-default rule %s was deleted", pushrules.MRuleMessage)
+	})
+
+	t.Run("enabled and actions", func(t *testing.T) {
+		userAPI := newUserAPI(t)
+		res := PutPushRuleByRuleID(newRequest(http.MethodPut, "", overrideRule), device, userAPI, syncProducer, "global", "override", "a")
+		if res.Code != http.StatusOK {
+			t.Fatalf("PutPushRuleByRuleID: got HTTP %d, want 200: %+v", res.Code, res.JSON)
+		}
+		for _, tc := range []struct {
+			kind, ruleID string
+		}{
+			{"override", "a"},
+			{"override", pushrules.MRuleMaster},
+			{"underride", pushrules.MRuleMessage},
+		} {
+			res = GetPushRuleAttrByRuleID(newRequest(http.MethodGet, "", ""), device, userAPI, "global", tc.kind, tc.ruleID, "enabled")
+			if res.Code != http.StatusOK {
+				t.Fatalf("GetPushRuleAttrByRuleID(%s/%s/enabled): got HTTP %d, want 200", tc.kind, tc.ruleID, res.Code)
+			}
+			enabled := *res.JSON.(pushRuleAttrJSON).Enabled
+
+			res = PutPushRuleAttrByRuleID(newRequest(http.MethodPut, "", `{"enabled":`+strconv.FormatBool(!enabled)+`}`), device, userAPI, syncProducer, "global", tc.kind, tc.ruleID, "enabled")
+			if res.Code != http.StatusOK {
+				t.Fatalf("PutPushRuleAttrByRuleID(%s/%s/enabled): got HTTP %d, want 200: %+v", tc.kind, tc.ruleID, res.Code, res.JSON)
+			}
+			res = GetPushRuleAttrByRuleID(newRequest(http.MethodGet, "", ""), device, userAPI, "global", tc.kind, tc.ruleID, "enabled")
+			if got := *res.JSON.(pushRuleAttrJSON).Enabled; got == enabled {
+				t.Errorf("GetPushRuleAttrByRuleID(%s/%s/enabled): got %v, want %v", tc.kind, tc.ruleID, got, !enabled)
+			}
+
+			res = PutPushRuleAttrByRuleID(newRequest(http.MethodPut, "", `{"actions":["dont_notify"]}`), device, userAPI, syncProducer, "global", tc.kind, tc.ruleID, "actions")
+			if res.Code != http.StatusOK {
+				t.Fatalf("PutPushRuleAttrByRuleID(%s/%s/actions): got HTTP %d, want 200: %+v", tc.kind, tc.ruleID, res.Code, res.JSON)
+			}
+			res = GetPushRuleAttrByRuleID(newRequest(http.MethodGet, "", ""), device, userAPI, "global", tc.kind, tc.ruleID, "actions")
+			if actions := res.JSON.(pushRuleAttrJSON).Actions; len(actions) != 1 || actions[0].Kind != pushrules.DontNotifyAction {
+				t.Errorf("GetPushRuleAttrByRuleID(%s/%s/actions): got %+v, want [dont_notify]", tc.kind, tc.ruleID, actions)
+			}
+		}
+
+		res = PutPushRuleAttrByRuleID(newRequest(http.MethodPut, "", `{}`), device, userAPI, syncProducer, "global", "override", "a", "enabled")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("PutPushRuleAttrByRuleID without enabled: got HTTP %d, want 400", res.Code)
+		}
+		res = GetPushRuleAttrByRuleID(newRequest(http.MethodGet, "", ""), device, userAPI, "global", "override", "a", "pattern")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("GetPushRuleAttrByRuleID(pattern): got HTTP %d, want 400", res.Code)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type refreshResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64  `json:"expires_in_ms,omitempty"`
+}
+
+// Refresh implements POST /refresh
+func Refresh(req *http.Request, userAPI userapi.UserInternalAPI) util.JSONResponse {
+	var r refreshRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.RefreshToken == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("refresh_token is required"),
+		}
+	}
+
+	token, err := auth.GenerateAccessToken()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("auth.GenerateAccessToken failed")
+		return jsonerror.InternalServerError()
+	}
+
+	var res userapi.PerformTokenRefreshResponse
+	err = userAPI.PerformTokenRefresh(req.Context(), &userapi.PerformTokenRefreshRequest{
+		RefreshToken: r.RefreshToken,
+		AccessToken:  token,
+	}, &res)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformTokenRefresh failed")
+		return jsonerror.InternalServerError()
+	}
+	if res.Device == nil {
+		return util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: jsonerror.UnknownToken("Unknown refresh token"),
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: refreshResponse{
+			AccessToken:  res.Device.AccessToken,
+			RefreshToken: res.RefreshToken,
+			ExpiresInMS:  accessTokenExpiresInMS(res.Device),
+		},
+	}
+}
+
+// accessTokenExpiresInMS returns how long the access token of the device is
+// valid for, or 0 if it never expires.
+func accessTokenExpiresInMS(dev *userapi.Device) int64 {
+	if dev.AccessTokenExpiresTS == 0 {
+		return 0
+	}
+	expiresInMS := dev.AccessTokenExpiresTS - time.Now().UnixNano()/int64(time.Millisecond)
+	if expiresInMS < 1 {
+		expiresInMS = 1
+	}
+	return expiresInMS
+}
@@ -134,6 +134,10 @@ type registerRequest struct {
 
 	// Prevent this user from logging in
 	InhibitLogin eventutil.WeakBoolean `json:"inhibit_login"`
+	// Whether the client supports refresh tokens
+	RefreshToken bool `json:"refresh_token"`
+	// The access token of a guest account that is being upgraded
+	GuestAccessToken string `json:"guest_access_token"`
 
 	// Application Services place Type in the root of their registration
 	// request, whereas clients place it in the authDict struct.
@@ -172,10 +176,12 @@ func newUserInteractiveResponse(
 
 // http://matrix.org/speculator/spec/HEAD/client_server/unstable.html#post-matrix-client-unstable-register
 type registerResponse struct {
-	UserID      string                       `json:"user_id"`
-	AccessToken string                       `json:"access_token,omitempty"`
-	HomeServer  gomatrixserverlib.ServerName `json:"home_server"`
-	DeviceID    string                       `json:"device_id,omitempty"`
+	UserID       string                       `json:"user_id"`
+	AccessToken  string                       `json:"access_token,omitempty"`
+	RefreshToken string                       `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64                        `json:"expires_in_ms,omitempty"`
+	HomeServer   gomatrixserverlib.ServerName `json:"home_server"`
+	DeviceID     string                       `json:"device_id,omitempty"`
 }
 
 // recaptchaResponse represents the HTTP response from a Google Recaptcha server
@@ -466,6 +472,21 @@ func Register(
 		sessionID = util.RandomString(sessionIDLength)
 	}
 
+	// Guests upgrade their account by registering with their access token
+	// and the localpart of their user ID, which is numeric so would not be
+	// allowed below.
+	// https://spec.matrix.org/v1.2/client-server-api/#guest-access
+	if r.GuestAccessToken != "" {
+		if resErr = validateGuestUpgrade(req.Context(), &r, userAPI); resErr != nil {
+			return *resErr
+		}
+		if resErr = validatePassword(r.Password); resErr != nil {
+			return *resErr
+		}
+		accessToken, accessTokenErr := auth.ExtractAccessToken(req)
+		return handleRegistrationFlow(req, r, sessionID, cfg, userAPI, accessToken, accessTokenErr)
+	}
+
 	// Don't allow numeric usernames less than MAX_INT64.
 	if _, err := strconv.ParseInt(r.Username, 10, 64); err == nil {
 		return util.JSONResponse{
@@ -561,6 +582,7 @@ func handleGuestRegistration(
 		AccessToken:       token,
 		IPAddr:            req.RemoteAddr,
 		UserAgent:         req.UserAgent(),
+		RefreshToken:      r.RefreshToken,
 	}, &devRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -571,14 +593,60 @@ func handleGuestRegistration(
 	return util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: registerResponse{
-			UserID:      devRes.Device.UserID,
-			AccessToken: devRes.Device.AccessToken,
-			HomeServer:  res.Account.ServerName,
-			DeviceID:    devRes.Device.ID,
+			UserID:       devRes.Device.UserID,
+			AccessToken:  devRes.Device.AccessToken,
+			RefreshToken: devRes.RefreshToken,
+			ExpiresInMS:  accessTokenExpiresInMS(devRes.Device),
+			HomeServer:   res.Account.ServerName,
+			DeviceID:     devRes.Device.ID,
 		},
 	}
 }
 
+// validateGuestUpgrade checks that the guest access token of the request
+// belongs to a guest account, and that the requested username is the localpart
+// of the guest's user ID. If no username was requested then the localpart is
+// filled in.
+func validateGuestUpgrade(
+	ctx context.Context, r *registerRequest, userAPI userapi.UserInternalAPI,
+) *util.JSONResponse {
+	var res userapi.QueryAccessTokenResponse
+	if err := userAPI.QueryAccessToken(ctx, &userapi.QueryAccessTokenRequest{
+		AccessToken: r.GuestAccessToken,
+	}, &res); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.QueryAccessToken failed")
+		resErr := jsonerror.InternalServerError()
+		return &resErr
+	}
+	if res.Device == nil {
+		return &util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: jsonerror.UnknownToken("Unknown guest access token"),
+		}
+	}
+	if res.Device.AccountType != userapi.AccountTypeGuest {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("The access token doesn't belong to a guest account"),
+		}
+	}
+	localpart, _, err := gomatrixserverlib.SplitID('@', res.Device.UserID)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("gomatrixserverlib.SplitID failed")
+		resErr := jsonerror.InternalServerError()
+		return &resErr
+	}
+	if r.Username == "" {
+		r.Username = localpart
+	} else if r.Username != localpart {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidUsername("Guests can only upgrade to the user ID of their guest account"),
+		}
+	}
+	return nil
+}
+
 // handleRegistrationFlow will direct and complete registration flow stages
 // that the client has requested.
 // nolint: gocyclo
@@ -592,7 +660,6 @@ func handleRegistrationFlow(
 	accessTokenErr error,
 ) util.JSONResponse {
 	// TODO: Enable registration config flag
-	// TODO: Guest account upgrading
 
 	// TODO: Handle loading of previous session parameters from database.
 	// TODO: Handle mapping registrationRequest parameters into session parameters
@@ -701,7 +768,8 @@ func handleApplicationServiceRegistration(
 	// application service registration is entirely separate.
 	return completeRegistration(
 		req.Context(), userAPI, r.Username, "", appserviceID, req.RemoteAddr, req.UserAgent(),
-		r.InhibitLogin, r.InitialDisplayName, r.DeviceID,
+		r.InhibitLogin, r.InitialDisplayName, r.DeviceID, r.RefreshToken,
+		userapi.AccountTypeUser,
 	)
 }
 
@@ -718,9 +786,16 @@ func checkAndCompleteFlow(
 ) util.JSONResponse {
 	if checkFlowCompleted(flow, cfg.Derived.Registration.Flows) {
 		// This flow was completed, registration can continue
+		if r.GuestAccessToken != "" {
+			return completeGuestUpgrade(
+				req.Context(), userAPI, r.Username, r.Password, req.RemoteAddr, req.UserAgent(),
+				r.InhibitLogin, r.InitialDisplayName, r.DeviceID, r.RefreshToken,
+			)
+		}
 		return completeRegistration(
 			req.Context(), userAPI, r.Username, r.Password, "", req.RemoteAddr, req.UserAgent(),
-			r.InhibitLogin, r.InitialDisplayName, r.DeviceID,
+			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, r.RefreshToken,
+			userapi.AccountTypeUser,
 		)
 	}
 
@@ -744,7 +819,8 @@ func completeRegistration(
 	userAPI userapi.UserInternalAPI,
 	username, password, appserviceID, ipAddr, userAgent string,
 	inhibitLogin eventutil.WeakBoolean,
-	displayName, deviceID *string,
+	displayName, deviceID *string, refreshToken bool,
+	accountType userapi.AccountType,
 ) util.JSONResponse {
 	if username == "" {
 		return util.JSONResponse{
@@ -765,7 +841,7 @@ func completeRegistration(
 		AppServiceID: appserviceID,
 		Localpart:    username,
 		Password:     password,
-		AccountType:  userapi.AccountTypeUser,
+		AccountType:  accountType,
 		OnConflict:   userapi.ConflictAbort,
 	}, &accRes)
 	if err != nil {
@@ -784,14 +860,63 @@ func completeRegistration(
 	// Increment prometheus counter for created users
 	amtRegUsers.Inc()
 
+	return completeRegistrationLogin(
+		ctx, userAPI, accRes.Account, ipAddr, userAgent,
+		inhibitLogin, displayName, deviceID, refreshToken,
+	)
+}
+
+// completeGuestUpgrade turns a guest account into a user account, which keeps
+// the user ID, devices and room memberships of the guest, then logs in like
+// completeRegistration does.
+func completeGuestUpgrade(
+	ctx context.Context,
+	userAPI userapi.UserInternalAPI,
+	username, password, ipAddr, userAgent string,
+	inhibitLogin eventutil.WeakBoolean,
+	displayName, deviceID *string, refreshToken bool,
+) util.JSONResponse {
+	var res userapi.PerformGuestUpgradeResponse
+	err := userAPI.PerformGuestUpgrade(ctx, &userapi.PerformGuestUpgradeRequest{
+		Localpart: username,
+		Password:  password,
+	}, &res)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.PerformGuestUpgrade failed")
+		return jsonerror.InternalServerError()
+	}
+	if !res.Upgraded {
+		// Another request upgraded the guest account first.
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.UserInUse("Desired user ID is already taken."),
+		}
+	}
+
+	return completeRegistrationLogin(
+		ctx, userAPI, res.Account, ipAddr, userAgent,
+		inhibitLogin, displayName, deviceID, refreshToken,
+	)
+}
+
+// completeRegistrationLogin creates a device for a newly registered account,
+// unless the inhibit_login option is set.
+func completeRegistrationLogin(
+	ctx context.Context,
+	userAPI userapi.UserInternalAPI,
+	account *userapi.Account,
+	ipAddr, userAgent string,
+	inhibitLogin eventutil.WeakBoolean,
+	displayName, deviceID *string, refreshToken bool,
+) util.JSONResponse {
 	// Check whether inhibit_login option is set. If so, don't create an access
 	// token or a device for this user
 	if inhibitLogin {
 		return util.JSONResponse{
 			Code: http.StatusOK,
 			JSON: registerResponse{
-				UserID:     userutil.MakeUserID(username, accRes.Account.ServerName),
-				HomeServer: accRes.Account.ServerName,
+				UserID:     userutil.MakeUserID(account.Localpart, account.ServerName),
+				HomeServer: account.ServerName,
 			},
 		}
 	}
@@ -806,12 +931,13 @@ func completeRegistration(
 
 	var devRes userapi.PerformDeviceCreationResponse
 	err = userAPI.PerformDeviceCreation(ctx, &userapi.PerformDeviceCreationRequest{
-		Localpart:         username,
+		Localpart:         account.Localpart,
 		AccessToken:       token,
 		DeviceDisplayName: displayName,
 		DeviceID:          deviceID,
 		IPAddr:            ipAddr,
 		UserAgent:         userAgent,
+		RefreshToken:      refreshToken,
 	}, &devRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -823,10 +949,12 @@ func completeRegistration(
 	return util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: registerResponse{
-			UserID:      devRes.Device.UserID,
-			AccessToken: devRes.Device.AccessToken,
-			HomeServer:  accRes.Account.ServerName,
-			DeviceID:    devRes.Device.ID,
+			UserID:       devRes.Device.UserID,
+			AccessToken:  devRes.Device.AccessToken,
+			RefreshToken: devRes.RefreshToken,
+			ExpiresInMS:  accessTokenExpiresInMS(devRes.Device),
+			HomeServer:   account.ServerName,
+			DeviceID:     devRes.Device.ID,
 		},
 	}
 }
@@ -963,5 +1091,9 @@ func handleSharedSecretRegistration(userAPI userapi.UserInternalAPI, sr *SharedS
 		return *resErr
 	}
 	deviceID := "shared_secret_registration"
-	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), false, &ssrr.User, &deviceID)
+	accType := userapi.AccountTypeUser
+	if ssrr.Admin {
+		accType = userapi.AccountTypeAdmin
+	}
+	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), false, &ssrr.User, &deviceID, false, accType)
 }
@@ -0,0 +1,137 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+type reportEventRequest struct {
+	Reason string `json:"reason"`
+	Score  *int   `json:"score"`
+}
+
+// ReportEvent implements POST /rooms/{roomID}/report/{eventID}
+func ReportEvent(
+	req *http.Request, device *userapi.Device,
+	rsAPI roomserverAPI.RoomserverInternalAPI, userAPI userapi.UserInternalAPI,
+	roomID, eventID string,
+) util.JSONResponse {
+	var r reportEventRequest
+	if rErr := httputil.UnmarshalJSONRequest(req, &r); rErr != nil {
+		return *rErr
+	}
+	if r.Score != nil && (*r.Score < -100 || *r.Score > 0) {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("score must be between -100 and 0"),
+		}
+	}
+
+	resErr := checkMemberInRoom(req.Context(), rsAPI, device.UserID, roomID)
+	if resErr != nil {
+		return *resErr
+	}
+	ev := roomserverAPI.GetEvent(req.Context(), rsAPI, eventID)
+	if ev == nil || ev.RoomID() != roomID {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event not found"),
+		}
+	}
+	// Users can only report events that they were allowed to see, so that
+	// reporting can't be used to find out whether an event exists.
+	visible, err := eventVisibleToReporter(req.Context(), rsAPI, ev, device.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("eventVisibleToReporter failed")
+		return jsonerror.InternalServerError()
+	}
+	if !visible {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event not found"),
+		}
+	}
+
+	var res userapi.PerformEventReportResponse
+	err = userAPI.PerformEventReport(req.Context(), &userapi.PerformEventReportRequest{
+		RoomID:  roomID,
+		EventID: eventID,
+		UserID:  device.UserID,
+		Reason:  r.Reason,
+		Score:   r.Score,
+	}, &res)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformEventReport failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// eventVisibleToReporter returns whether the history visibility of the room
+// at the event allows the user to see it. The user must already be joined to
+// the room.
+func eventVisibleToReporter(
+	ctx context.Context, rsAPI roomserverAPI.RoomserverInternalAPI,
+	ev *gomatrixserverlib.HeaderedEvent, userID string,
+) (bool, error) {
+	var stateRes roomserverAPI.QueryStateAfterEventsResponse
+	err := rsAPI.QueryStateAfterEvents(ctx, &roomserverAPI.QueryStateAfterEventsRequest{
+		RoomID:       ev.RoomID(),
+		PrevEventIDs: ev.PrevEventIDs(),
+		StateToFetch: []gomatrixserverlib.StateKeyTuple{
+			{EventType: gomatrixserverlib.MRoomMember, StateKey: userID},
+			{EventType: gomatrixserverlib.MRoomHistoryVisibility, StateKey: ""},
+		},
+	}, &stateRes)
+	if err != nil {
+		return false, err
+	}
+	if !stateRes.RoomExists || !stateRes.PrevEventsExist {
+		return false, nil
+	}
+	hisVis, membership := "shared", gomatrixserverlib.Leave
+	for _, stateEvent := range stateRes.StateEvents {
+		switch stateEvent.Type() {
+		case gomatrixserverlib.MRoomHistoryVisibility:
+			if v, err := stateEvent.HistoryVisibility(); err == nil {
+				hisVis = v
+			}
+		case gomatrixserverlib.MRoomMember:
+			if m, err := stateEvent.Membership(); err == nil {
+				membership = m
+			}
+		}
+	}
+	switch hisVis {
+	case "shared", "world_readable":
+		return true, nil
+	case "invited":
+		return membership == gomatrixserverlib.Join || membership == gomatrixserverlib.Invite, nil
+	default:
+		return membership == gomatrixserverlib.Join, nil
+	}
+}
@@ -0,0 +1,236 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// reportRoomserverAPI works out the state of the test room from its events.
+type reportRoomserverAPI struct {
+	roomserverAPI.RoomserverInternalAPI
+	room *test.Room
+}
+
+// stateAfter returns the state in the room after the event with the given
+// ID, or the current state if there is no such event.
+func (a *reportRoomserverAPI) stateAfter(eventID string) map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent {
+	state := map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{}
+	for _, ev := range a.room.Events() {
+		if ev.StateKey() != nil {
+			state[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+		}
+		if ev.EventID() == eventID {
+			break
+		}
+	}
+	return state
+}
+
+func (a *reportRoomserverAPI) QueryCurrentState(ctx context.Context, req *roomserverAPI.QueryCurrentStateRequest, res *roomserverAPI.QueryCurrentStateResponse) error {
+	state := a.stateAfter("")
+	res.StateEvents = map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{}
+	for _, tuple := range req.StateTuples {
+		if ev, ok := state[tuple]; ok {
+			res.StateEvents[tuple] = ev
+		}
+	}
+	return nil
+}
+
+func (a *reportRoomserverAPI) QueryEventsByID(ctx context.Context, req *roomserverAPI.QueryEventsByIDRequest, res *roomserverAPI.QueryEventsByIDResponse) error {
+	for _, ev := range a.room.Events() {
+		for _, eventID := range req.EventIDs {
+			if ev.EventID() == eventID {
+				res.Events = append(res.Events, ev)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *reportRoomserverAPI) QueryStateAfterEvents(ctx context.Context, req *roomserverAPI.QueryStateAfterEventsRequest, res *roomserverAPI.QueryStateAfterEventsResponse) error {
+	res.RoomExists, res.PrevEventsExist = true, true
+	if len(req.PrevEventIDs) == 0 {
+		return nil
+	}
+	state := a.stateAfter(req.PrevEventIDs[0])
+	for _, tuple := range req.StateToFetch {
+		if ev, ok := state[tuple]; ok {
+			res.StateEvents = append(res.StateEvents, ev)
+		}
+	}
+	return nil
+}
+
+// reportUserAPI stores event reports in a real database.
+type reportUserAPI struct {
+	userapi.UserInternalAPI
+	db accounts.Database
+}
+
+func (a *reportUserAPI) PerformEventReport(ctx context.Context, req *userapi.PerformEventReportRequest, res *userapi.PerformEventReportResponse) error {
+	var err error
+	res.ReportID, err = a.db.InsertEventReport(ctx, &userapi.EventReport{
+		RoomID:  req.RoomID,
+		EventID: req.EventID,
+		UserID:  req.UserID,
+		Reason:  req.Reason,
+		Score:   req.Score,
+	})
+	return err
+}
+
+func (a *reportUserAPI) PerformEventReportResolution(ctx context.Context, req *userapi.PerformEventReportResolutionRequest, res *userapi.PerformEventReportResolutionResponse) error {
+	var err error
+	res.Found, err = a.db.ResolveEventReport(ctx, req.ReportID)
+	return err
+}
+
+func (a *reportUserAPI) QueryEventReports(ctx context.Context, req *userapi.QueryEventReportsRequest, res *userapi.QueryEventReportsResponse) error {
+	var err error
+	res.Reports, res.Total, err = a.db.GetEventReports(ctx, req.RoomID, req.UserID, req.OnlyUnresolved, req.Limit, req.Offset)
+	return err
+}
+
+func (a *reportUserAPI) QueryEventReport(ctx context.Context, req *userapi.QueryEventReportRequest, res *userapi.QueryEventReportResponse) error {
+	var err error
+	res.Report, err = a.db.GetEventReport(ctx, req.ReportID)
+	return err
+}
+
+func TestReportEvent(t *testing.T) {
+	alice, bob := "@alice:test", "@bob:test"
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomHistoryVisibility, map[string]interface{}{
+		"history_visibility": "joined",
+	}, "")
+	beforeBob := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "before bob"})
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": gomatrixserverlib.Join}, bob)
+	afterBob := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "after bob"})
+	other := test.NewRoom(t, alice)
+	otherEvent := other.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "elsewhere"})
+	rsAPI := &reportRoomserverAPI{room: room}
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		accountDB, err := accounts.NewDatabase(&config.DatabaseOptions{
+			ConnectionString: test.PrepareDBConnectionString(t, dbType),
+		}, "test", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		userAPI := &reportUserAPI{db: accountDB}
+
+		report := func(t *testing.T, userID, eventID, body string) int {
+			t.Helper()
+			req := httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(body))
+			return ReportEvent(req, &userapi.Device{UserID: userID}, rsAPI, userAPI, room.ID, eventID).Code
+		}
+		listReports := func(t *testing.T, query string) adminEventReportsResponse {
+			t.Helper()
+			res := GetAdminEventReports(httptest.NewRequest(http.MethodGet, "/admin/event_reports"+query, nil), userAPI)
+			if res.Code != http.StatusOK {
+				t.Fatalf("GetAdminEventReports(%s): got HTTP %d, want 200: %+v", query, res.Code, res.JSON)
+			}
+			return res.JSON.(adminEventReportsResponse)
+		}
+
+		t.Run("valid report", func(t *testing.T) {
+			if code := report(t, bob, afterBob.EventID(), `{"reason":"rude","score":-50}`); code != http.StatusOK {
+				t.Fatalf("got HTTP %d, want 200", code)
+			}
+			reports := listReports(t, "").EventReports
+			if len(reports) != 1 {
+				t.Fatalf("got %d reports, want 1", len(reports))
+			}
+			got := reports[0]
+			if got.RoomID != room.ID || got.EventID != afterBob.EventID() || got.UserID != bob || got.Reason != "rude" || got.Score == nil || *got.Score != -50 || got.Resolved {
+				t.Errorf("got report %+v, want an unresolved report from bob about %s", got, afterBob.EventID())
+			}
+		})
+
+		t.Run("score out of range", func(t *testing.T) {
+			for _, score := range []int{1, -101} {
+				if code := report(t, bob, afterBob.EventID(), fmt.Sprintf(`{"reason":"rude","score":%d}`, score)); code != http.StatusBadRequest {
+					t.Errorf("score %d: got HTTP %d, want 400", score, code)
+				}
+			}
+		})
+
+		t.Run("events the reporter can't see", func(t *testing.T) {
+			for name, eventID := range map[string]string{
+				"before joining":   beforeBob.EventID(),
+				"in another room":  otherEvent.EventID(),
+				"unknown event ID": "$unknown:test",
+			} {
+				if code := report(t, bob, eventID, `{"reason":"rude"}`); code != http.StatusNotFound {
+					t.Errorf("%s: got HTTP %d, want 404", name, code)
+				}
+			}
+			if code := report(t, "@charlie:test", afterBob.EventID(), `{"reason":"rude"}`); code != http.StatusForbidden {
+				t.Errorf("not in the room: got HTTP %d, want 403", code)
+			}
+			if total := listReports(t, "").Total; total != 1 {
+				t.Errorf("got %d reports, want only the valid one", total)
+			}
+		})
+
+		t.Run("listing and resolving reports", func(t *testing.T) {
+			if code := report(t, alice, beforeBob.EventID(), `{"reason":"spam"}`); code != http.StatusOK {
+				t.Fatalf("got HTTP %d, want 200", code)
+			}
+
+			// Reports are listed newest first, with a token for the next page.
+			page := listReports(t, "?limit=1")
+			if page.Total != 2 || len(page.EventReports) != 1 || page.EventReports[0].UserID != alice || page.NextToken == nil {
+				t.Fatalf("got first page %+v, want alice's report and a next token", page)
+			}
+			page = listReports(t, fmt.Sprintf("?limit=1&from=%d", *page.NextToken))
+			if len(page.EventReports) != 1 || page.EventReports[0].UserID != bob || page.NextToken != nil {
+				t.Fatalf("got second page %+v, want bob's report and no next token", page)
+			}
+			bobReport := page.EventReports[0]
+			if got := listReports(t, "?user_id="+bob).EventReports; len(got) != 1 || got[0].ID != bobReport.ID {
+				t.Errorf("got reports %+v, want only bob's", got)
+			}
+
+			reportID := fmt.Sprint(bobReport.ID)
+			res := GetAdminEventReport(httptest.NewRequest(http.MethodGet, "/", nil), userAPI, rsAPI, reportID)
+			if res.Code != http.StatusOK {
+				t.Fatalf("GetAdminEventReport: got HTTP %d, want 200", res.Code)
+			}
+			if got := res.JSON.(adminEventReportResponse); got.ID != bobReport.ID || string(got.EventJSON) != string(afterBob.JSON()) {
+				t.Errorf("GetAdminEventReport: got %+v, want bob's report with the event", got)
+			}
+
+			if res = ResolveAdminEventReport(httptest.NewRequest(http.MethodPost, "/", nil), userAPI, reportID); res.Code != http.StatusOK {
+				t.Fatalf("ResolveAdminEventReport: got HTTP %d, want 200", res.Code)
+			}
+			if got := listReports(t, "?resolved=false").EventReports; len(got) != 1 || got[0].UserID != alice {
+				t.Errorf("got unresolved reports %+v, want only alice's", got)
+			}
+			res = GetAdminEventReport(httptest.NewRequest(http.MethodGet, "/", nil), userAPI, rsAPI, reportID)
+			if got := res.JSON.(adminEventReportResponse); !got.Resolved {
+				t.Errorf("GetAdminEventReport: got %+v, want it to be resolved", got)
+			}
+
+			for id, wantCode := range map[string]int{"12345": http.StatusNotFound, "nope": http.StatusBadRequest} {
+				res = ResolveAdminEventReport(httptest.NewRequest(http.MethodPost, "/", nil), userAPI, id)
+				if res.Code != wantCode {
+					t.Errorf("ResolveAdminEventReport(%s): got HTTP %d, want %d", id, res.Code, wantCode)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,325 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/producers"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+// serverNoticeTag is the room tag that marks a room as a server notice room.
+const serverNoticeTag = "m.server_notice"
+
+type sendServerNoticeRequest struct {
+	// The user to send the notice to. If empty, the notice is sent to all
+	// active local users.
+	UserID  string                 `json:"user_id"`
+	Content map[string]interface{} `json:"content"`
+	Type    string                 `json:"type"`
+}
+
+type sendServerNoticeResponse struct {
+	// The ID of the notice event, when it was sent to a single user.
+	EventID string `json:"event_id,omitempty"`
+	// The IDs of the notice events by user ID, when it was sent to all users.
+	EventIDs map[string]string `json:"event_ids,omitempty"`
+}
+
+// serverNotices sends notices to users from the configured server notices
+// user, creating a notice room for each user on demand.
+type serverNotices struct {
+	cfg          *config.ClientAPI
+	rsAPI        roomserverAPI.RoomserverInternalAPI
+	asAPI        appserviceAPI.AppServiceQueryAPI
+	accountDB    accounts.Database
+	userAPI      userapi.UserInternalAPI
+	syncProducer *producers.SyncAPIProducer
+}
+
+// SendServerNotice implements POST /admin/send_server_notice
+func SendServerNotice(
+	req *http.Request, cfg *config.ClientAPI,
+	rsAPI roomserverAPI.RoomserverInternalAPI,
+	asAPI appserviceAPI.AppServiceQueryAPI,
+	accountDB accounts.Database,
+	userAPI userapi.UserInternalAPI,
+	syncProducer *producers.SyncAPIProducer,
+) util.JSONResponse {
+	var r sendServerNoticeRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if len(r.Content) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("A notice content is required"),
+		}
+	}
+	if r.Type == "" {
+		r.Type = "m.room.message"
+	}
+
+	ctx := req.Context()
+	sn := &serverNotices{
+		cfg:          cfg,
+		rsAPI:        rsAPI,
+		asAPI:        asAPI,
+		accountDB:    accountDB,
+		userAPI:      userAPI,
+		syncProducer: syncProducer,
+	}
+	if err := sn.ensureSender(ctx); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("Failed to set up the server notices user")
+		return jsonerror.InternalServerError()
+	}
+
+	if r.UserID != "" {
+		localpart, domain, err := gomatrixserverlib.SplitID('@', r.UserID)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("Invalid user ID"),
+			}
+		}
+		if domain != cfg.Matrix.ServerName {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("Server notices can only be sent to local users"),
+			}
+		}
+		if _, err = accountDB.GetAccountByLocalpart(ctx, localpart); err == sql.ErrNoRows {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: jsonerror.NotFound("Unknown user"),
+			}
+		} else if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("accountDB.GetAccountByLocalpart failed")
+			return jsonerror.InternalServerError()
+		}
+		eventID, resErr := sn.send(ctx, r.UserID, r.Type, r.Content)
+		if resErr != nil {
+			return *resErr
+		}
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: sendServerNoticeResponse{EventID: eventID},
+		}
+	}
+
+	localparts, err := accountDB.GetActiveLocalparts(ctx)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("accountDB.GetActiveLocalparts failed")
+		return jsonerror.InternalServerError()
+	}
+	res := sendServerNoticeResponse{EventIDs: make(map[string]string, len(localparts))}
+	for _, localpart := range localparts {
+		if localpart == cfg.Matrix.ServerNotices.LocalPart {
+			continue
+		}
+		userID := fmt.Sprintf("@%s:%s", localpart, cfg.Matrix.ServerName)
+		eventID, resErr := sn.send(ctx, userID, r.Type, r.Content)
+		if resErr != nil {
+			// Don't let one broken room stop everyone else from getting
+			// the notice.
+			util.GetLogger(ctx).WithField("user_id", userID).Errorf("Failed to send server notice: %+v", resErr.JSON)
+			continue
+		}
+		res.EventIDs[userID] = eventID
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// senderID returns the user ID of the server notices user.
+func (sn *serverNotices) senderID() string {
+	return fmt.Sprintf("@%s:%s", sn.cfg.Matrix.ServerNotices.LocalPart, sn.cfg.Matrix.ServerName)
+}
+
+// ensureSender creates the server notices user if it doesn't exist yet and
+// brings its profile in line with the config.
+func (sn *serverNotices) ensureSender(ctx context.Context) error {
+	noticeCfg := &sn.cfg.Matrix.ServerNotices
+	var res userapi.PerformAccountCreationResponse
+	if err := sn.userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
+		AccountType: userapi.AccountTypeUser,
+		Localpart:   noticeCfg.LocalPart,
+		OnConflict:  userapi.ConflictUpdate,
+	}, &res); err != nil {
+		return fmt.Errorf("userAPI.PerformAccountCreation: %w", err)
+	}
+	if err := sn.accountDB.SetDisplayName(ctx, noticeCfg.LocalPart, noticeCfg.DisplayName); err != nil {
+		return fmt.Errorf("accountDB.SetDisplayName: %w", err)
+	}
+	if noticeCfg.AvatarURL != "" {
+		if err := sn.accountDB.SetAvatarURL(ctx, noticeCfg.LocalPart, noticeCfg.AvatarURL); err != nil {
+			return fmt.Errorf("accountDB.SetAvatarURL: %w", err)
+		}
+	}
+	return nil
+}
+
+// send sends a notice to the given user, returning the event ID.
+func (sn *serverNotices) send(
+	ctx context.Context, userID, eventType string, content map[string]interface{},
+) (string, *util.JSONResponse) {
+	sender := &userapi.Device{UserID: sn.senderID()}
+	roomID, resErr := sn.noticeRoom(ctx, sender, userID)
+	if resErr != nil {
+		return "", resErr
+	}
+
+	verReq := roomserverAPI.QueryRoomVersionForRoomRequest{RoomID: roomID}
+	verRes := roomserverAPI.QueryRoomVersionForRoomResponse{}
+	if err := sn.rsAPI.QueryRoomVersionForRoom(ctx, &verReq, &verRes); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryRoomVersionForRoom failed")
+		resErr := jsonerror.InternalServerError()
+		return "", &resErr
+	}
+	e, resErr := generateSendEvent(ctx, content, sender, roomID, eventType, nil, sn.cfg, sn.rsAPI, time.Now())
+	if resErr != nil {
+		return "", resErr
+	}
+	if err := roomserverAPI.SendEvents(
+		ctx, sn.rsAPI,
+		roomserverAPI.KindNew,
+		[]*gomatrixserverlib.HeaderedEvent{
+			e.Headered(verRes.RoomVersion),
+		},
+		sn.cfg.Matrix.ServerName,
+		sn.cfg.Matrix.ServerName,
+		nil,
+		false,
+	); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("SendEvents failed")
+		resErr := jsonerror.InternalServerError()
+		return "", &resErr
+	}
+	return e.EventID(), nil
+}
+
+// noticeRoom returns the ID of the notice room shared by the server notices
+// user and the given user, creating it if there isn't one.
+func (sn *serverNotices) noticeRoom(
+	ctx context.Context, sender *userapi.Device, userID string,
+) (string, *util.JSONResponse) {
+	senderRooms, err := sn.roomsForUser(ctx, sender.UserID, gomatrixserverlib.Join)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryRoomsForUser failed")
+		resErr := jsonerror.InternalServerError()
+		return "", &resErr
+	}
+	for _, membership := range []string{gomatrixserverlib.Join, gomatrixserverlib.Invite} {
+		userRooms, err := sn.roomsForUser(ctx, userID, membership)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryRoomsForUser failed")
+			resErr := jsonerror.InternalServerError()
+			return "", &resErr
+		}
+		for roomID := range userRooms {
+			if _, ok := senderRooms[roomID]; ok {
+				return roomID, nil
+			}
+		}
+	}
+
+	powerLevels, err := json.Marshal(map[string]interface{}{
+		"events_default": 100,
+		"invite":         100,
+		"users": map[string]int{
+			sender.UserID: 100,
+		},
+	})
+	if err != nil {
+		resErr := jsonerror.InternalServerError()
+		return "", &resErr
+	}
+	roomID := fmt.Sprintf("!%s:%s", util.RandomString(16), sn.cfg.Matrix.ServerName)
+	res := createRoom(ctx, createRoomRequest{
+		Invite:                    []string{userID},
+		Name:                      sn.cfg.Matrix.ServerNotices.RoomName,
+		Preset:                    presetPrivateChat,
+		PowerLevelContentOverride: powerLevels,
+	}, sender, sn.cfg, roomID, sn.accountDB, sn.rsAPI, sn.asAPI, time.Now())
+	if res.Code != http.StatusOK {
+		return "", &res
+	}
+
+	if err = sn.tagRoom(ctx, userID, roomID); err != nil {
+		// The notice still gets through, clients just won't show the room
+		// as a notice room.
+		util.GetLogger(ctx).WithError(err).Error("Failed to tag server notice room")
+	}
+	return roomID, nil
+}
+
+// tagRoom adds the m.server_notice tag to the room for the given user.
+func (sn *serverNotices) tagRoom(ctx context.Context, userID, roomID string) error {
+	tagData, err := json.Marshal(map[string]interface{}{
+		"tags": map[string]interface{}{
+			serverNoticeTag: struct{}{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	dataReq := userapi.InputAccountDataRequest{
+		UserID:      userID,
+		RoomID:      roomID,
+		DataType:    "m.tag",
+		AccountData: tagData,
+	}
+	dataRes := userapi.InputAccountDataResponse{}
+	if err = sn.userAPI.InputAccountData(ctx, &dataReq, &dataRes); err != nil {
+		return err
+	}
+	if err = sn.syncProducer.SendData(userID, roomID, "m.tag"); err != nil {
+		logrus.WithError(err).Error("Failed to send m.tag account data update to syncapi")
+	}
+	return nil
+}
+
+// roomsForUser returns the set of rooms the user has the given membership in.
+func (sn *serverNotices) roomsForUser(ctx context.Context, userID, membership string) (map[string]struct{}, error) {
+	var res roomserverAPI.QueryRoomsForUserResponse
+	if err := sn.rsAPI.QueryRoomsForUser(ctx, &roomserverAPI.QueryRoomsForUserRequest{
+		UserID:         userID,
+		WantMembership: membership,
+	}, &res); err != nil {
+		return nil, err
+	}
+	rooms := make(map[string]struct{}, len(res.RoomIDs))
+	for _, roomID := range res.RoomIDs {
+		rooms[roomID] = struct{}{}
+	}
+	return rooms, nil
+}
@@ -0,0 +1,275 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/clientapi/producers"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/test"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+)
+
+// noticeRoomserverAPI keeps the rooms it is given in memory, checking each
+// event against the current state of its room.
+type noticeRoomserverAPI struct {
+	roomserverAPI.RoomserverInternalAPI
+	rooms map[string][]*gomatrixserverlib.HeaderedEvent
+}
+
+func (a *noticeRoomserverAPI) currentState(roomID string) map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent {
+	state := map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{}
+	for _, ev := range a.rooms[roomID] {
+		if ev.StateKey() != nil {
+			state[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+		}
+	}
+	return state
+}
+
+func (a *noticeRoomserverAPI) input(ev *gomatrixserverlib.HeaderedEvent) error {
+	var stateEvents []*gomatrixserverlib.Event
+	for _, stateEv := range a.currentState(ev.RoomID()) {
+		stateEvents = append(stateEvents, stateEv.Unwrap())
+	}
+	authEvents := gomatrixserverlib.NewAuthEvents(stateEvents)
+	if err := gomatrixserverlib.Allowed(ev.Unwrap(), &authEvents); err != nil {
+		return err
+	}
+	a.rooms[ev.RoomID()] = append(a.rooms[ev.RoomID()], ev)
+	return nil
+}
+
+func (a *noticeRoomserverAPI) InputRoomEvents(
+	ctx context.Context, req *roomserverAPI.InputRoomEventsRequest, res *roomserverAPI.InputRoomEventsResponse,
+) {
+	for _, input := range req.InputRoomEvents {
+		if err := a.input(input.Event); err != nil {
+			res.ErrMsg, res.NotAllowed = err.Error(), true
+			return
+		}
+	}
+}
+
+func (a *noticeRoomserverAPI) PerformInvite(
+	ctx context.Context, req *roomserverAPI.PerformInviteRequest, res *roomserverAPI.PerformInviteResponse,
+) error {
+	if err := a.input(req.Event); err != nil {
+		res.Error = &roomserverAPI.PerformError{Code: roomserverAPI.PerformErrorNotAllowed, Msg: err.Error()}
+	}
+	return nil
+}
+
+func (a *noticeRoomserverAPI) QueryRoomVersionForRoom(
+	ctx context.Context, req *roomserverAPI.QueryRoomVersionForRoomRequest, res *roomserverAPI.QueryRoomVersionForRoomResponse,
+) error {
+	events, ok := a.rooms[req.RoomID]
+	if !ok {
+		return fmt.Errorf("unknown room %s", req.RoomID)
+	}
+	res.RoomVersion = events[0].RoomVersion
+	return nil
+}
+
+func (a *noticeRoomserverAPI) QueryLatestEventsAndState(
+	ctx context.Context, req *roomserverAPI.QueryLatestEventsAndStateRequest, res *roomserverAPI.QueryLatestEventsAndStateResponse,
+) error {
+	events, ok := a.rooms[req.RoomID]
+	if !ok {
+		return nil
+	}
+	last := events[len(events)-1]
+	res.RoomExists = true
+	res.RoomVersion = last.RoomVersion
+	res.Depth = last.Depth() + 1
+	res.LatestEvents = []gomatrixserverlib.EventReference{last.EventReference()}
+	for _, ev := range a.currentState(req.RoomID) {
+		res.StateEvents = append(res.StateEvents, ev)
+	}
+	return nil
+}
+
+func (a *noticeRoomserverAPI) QueryRoomsForUser(
+	ctx context.Context, req *roomserverAPI.QueryRoomsForUserRequest, res *roomserverAPI.QueryRoomsForUserResponse,
+) error {
+	for roomID := range a.rooms {
+		member := a.currentState(roomID)[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomMember, StateKey: req.UserID}]
+		if member == nil {
+			continue
+		}
+		if membership, _ := member.Membership(); membership == req.WantMembership {
+			res.RoomIDs = append(res.RoomIDs, roomID)
+		}
+	}
+	return nil
+}
+
+// noticeAccountDB knows the profile of every local user.
+type noticeAccountDB struct {
+	accounts.Database
+}
+
+func (d *noticeAccountDB) GetProfileByLocalpart(ctx context.Context, localpart string) (*authtypes.Profile, error) {
+	return &authtypes.Profile{Localpart: localpart, DisplayName: localpart}, nil
+}
+
+// noticeUserAPI records the account data that is stored for users.
+type noticeUserAPI struct {
+	userapi.UserInternalAPI
+	accountData []userapi.InputAccountDataRequest
+}
+
+func (a *noticeUserAPI) InputAccountData(
+	ctx context.Context, req *userapi.InputAccountDataRequest, res *userapi.InputAccountDataResponse,
+) error {
+	a.accountData = append(a.accountData, *req)
+	return nil
+}
+
+// noticeJetStream drops everything that is published to it.
+type noticeJetStream struct {
+	nats.JetStreamContext
+}
+
+func (js *noticeJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	return &nats.PubAck{}, nil
+}
+
+func TestSendServerNotice(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	cfg := &config.ClientAPI{
+		Matrix: &config.Global{
+			ServerName: "test",
+			KeyID:      test.KeyID,
+			PrivateKey: test.PrivateKey,
+			ServerNotices: config.ServerNotices{
+				Enabled:   true,
+				LocalPart: "_server",
+				RoomName:  "Server Alerts",
+			},
+		},
+	}
+	rsAPI := &noticeRoomserverAPI{rooms: map[string][]*gomatrixserverlib.HeaderedEvent{}}
+	userAPI := &noticeUserAPI{}
+	sn := &serverNotices{
+		cfg:          cfg,
+		rsAPI:        rsAPI,
+		accountDB:    &noticeAccountDB{},
+		userAPI:      userAPI,
+		syncProducer: &producers.SyncAPIProducer{JetStream: &noticeJetStream{}},
+	}
+	send := func(t *testing.T, body string) (roomID string) {
+		t.Helper()
+		eventID, resErr := sn.send(ctx, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": body})
+		if resErr != nil {
+			t.Fatalf("send failed: %+v", resErr.JSON)
+		}
+		for roomID, events := range rsAPI.rooms {
+			if last := events[len(events)-1]; last.EventID() == eventID {
+				if last.Sender() != sn.senderID() {
+					t.Errorf("got notice sent by %s, want %s", last.Sender(), sn.senderID())
+				}
+				return roomID
+			}
+		}
+		t.Fatalf("notice %s isn't the latest event in any room", eventID)
+		return ""
+	}
+	setMembership := func(t *testing.T, roomID, membership string) {
+		t.Helper()
+		builder := gomatrixserverlib.EventBuilder{
+			Sender:   alice,
+			RoomID:   roomID,
+			Type:     gomatrixserverlib.MRoomMember,
+			StateKey: &alice,
+		}
+		if err := builder.SetContent(map[string]interface{}{"membership": membership}); err != nil {
+			t.Fatalf("failed to set content: %s", err)
+		}
+		ev, err := eventutil.QueryAndBuildEvent(ctx, &builder, cfg.Matrix, time.Now(), rsAPI, nil)
+		if err != nil {
+			t.Fatalf("failed to build %s event: %s", membership, err)
+		}
+		if err = rsAPI.input(ev); err != nil {
+			t.Fatalf("%s wasn't allowed: %s", membership, err)
+		}
+	}
+
+	roomID := send(t, "first")
+	t.Run("creates a notice room", func(t *testing.T) {
+		state := rsAPI.currentState(roomID)
+		if member := state[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomMember, StateKey: alice}]; member == nil {
+			t.Errorf("user wasn't invited to the notice room")
+		} else if membership, _ := member.Membership(); membership != gomatrixserverlib.Invite {
+			t.Errorf("got membership %q, want invite", membership)
+		}
+		plEvent := state[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomPowerLevels, StateKey: ""}]
+		var pl map[string]interface{}
+		if err := json.Unmarshal(plEvent.Content(), &pl); err != nil {
+			t.Fatalf("failed to unmarshal power levels: %s", err)
+		}
+		if pl["events_default"] != float64(100) || pl["invite"] != float64(100) {
+			t.Errorf("got events_default %v and invite %v, want 100", pl["events_default"], pl["invite"])
+		}
+		var name eventutil.NameContent
+		if err := json.Unmarshal(state[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomName, StateKey: ""}].Content(), &name); err != nil {
+			t.Fatalf("failed to unmarshal room name: %s", err)
+		}
+		if name.Name != cfg.Matrix.ServerNotices.RoomName {
+			t.Errorf("got room name %q, want %q", name.Name, cfg.Matrix.ServerNotices.RoomName)
+		}
+		if len(userAPI.accountData) != 1 {
+			t.Fatalf("got %d account data updates, want 1", len(userAPI.accountData))
+		}
+		tag := userAPI.accountData[0]
+		if tag.UserID != alice || tag.RoomID != roomID || tag.DataType != "m.tag" {
+			t.Errorf("got %s account data for %s in %s", tag.DataType, tag.UserID, tag.RoomID)
+		}
+		var tags struct {
+			Tags map[string]interface{} `json:"tags"`
+		}
+		if err := json.Unmarshal(tag.AccountData, &tags); err != nil {
+			t.Fatalf("failed to unmarshal tags: %s", err)
+		}
+		if _, ok := tags.Tags[serverNoticeTag]; !ok {
+			t.Errorf("got tags %v, want %s", tags.Tags, serverNoticeTag)
+		}
+	})
+
+	t.Run("reuses the room while invited", func(t *testing.T) {
+		if got := send(t, "second"); got != roomID {
+			t.Errorf("got notice in room %s, want %s", got, roomID)
+		}
+	})
+
+	t.Run("reuses the room once joined", func(t *testing.T) {
+		setMembership(t, roomID, gomatrixserverlib.Join)
+		if got := send(t, "third"); got != roomID {
+			t.Errorf("got notice in room %s, want %s", got, roomID)
+		}
+		if len(rsAPI.rooms) != 1 || len(userAPI.accountData) != 1 {
+			t.Errorf("got %d rooms and %d tags, want 1 of each", len(rsAPI.rooms), len(userAPI.accountData))
+		}
+	})
+
+	t.Run("creates a new room after leaving", func(t *testing.T) {
+		setMembership(t, roomID, gomatrixserverlib.Leave)
+		newRoomID := send(t, "fourth")
+		if newRoomID == roomID {
+			t.Fatalf("got notice in the room that the user left")
+		}
+		if len(userAPI.accountData) != 2 || userAPI.accountData[1].RoomID != newRoomID {
+			t.Errorf("new notice room wasn't tagged")
+		}
+	})
+}
@@ -0,0 +1,287 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/sso"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/util"
+)
+
+const (
+	// The cookie that remembers an SSO login between the redirect to the
+	// identity provider and the callback.
+	ssoCookieName = "dendrite_sso_session"
+	// How long the user has to log in at the identity provider.
+	ssoCookieLifetime = 10 * time.Minute
+)
+
+// ssoSession is stored in a cookie while the user is at the identity provider.
+type ssoSession struct {
+	Nonce       string `json:"nonce"`
+	ProviderID  string `json:"provider"`
+	RedirectURL string `json:"redirect_url"`
+}
+
+// SSORedirect implements GET /login/sso/redirect and GET /login/sso/redirect/{idpID}
+func SSORedirect(
+	req *http.Request, idpID string, auth *sso.Authenticator, cfg *config.SSO,
+) util.JSONResponse {
+	redirectURL, err := url.Parse(req.URL.Query().Get("redirectUrl"))
+	if err != nil || redirectURL.String() == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("redirectUrl parameter missing"),
+		}
+	}
+	if redirectURL.Scheme != "http" && redirectURL.Scheme != "https" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("redirectUrl must be an absolute http or https URL"),
+		}
+	}
+	if !cfg.IsClientAllowed(redirectURL) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("redirectUrl is not an allowed client"),
+		}
+	}
+
+	provider := auth.Provider(idpID)
+	if provider == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown identity provider"),
+		}
+	}
+
+	nonceBytes := make([]byte, 32)
+	if _, err = rand.Read(nonceBytes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rand.Read failed")
+		return jsonerror.InternalServerError()
+	}
+	session := ssoSession{
+		Nonce:       base64.RawURLEncoding.EncodeToString(nonceBytes),
+		ProviderID:  provider.ID,
+		RedirectURL: redirectURL.String(),
+	}
+
+	location, err := auth.AuthorizationURL(req.Context(), provider.ID, cfg.CallbackURL, session.Nonce)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("auth.AuthorizationURL failed")
+		return util.JSONResponse{
+			Code: http.StatusBadGateway,
+			JSON: jsonerror.Unknown("Failed to contact the identity provider"),
+		}
+	}
+
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("json.Marshal failed")
+		return jsonerror.InternalServerError()
+	}
+	cookie := ssoCookie(cfg.CallbackURL)
+	cookie.Value = base64.RawURLEncoding.EncodeToString(sessionJSON)
+	cookie.Expires = time.Now().Add(ssoCookieLifetime)
+	cookie.MaxAge = int(ssoCookieLifetime.Seconds())
+
+	res := util.RedirectResponse(location)
+	res.Headers["Set-Cookie"] = cookie.String()
+	return res
+}
+
+// SSOCallback implements GET /login/sso/callback
+func SSOCallback(
+	req *http.Request, auth *sso.Authenticator,
+	accountDB accounts.Database, userAPI userapi.UserInternalAPI, cfg *config.ClientAPI,
+) util.JSONResponse {
+	ctx := req.Context()
+	logger := util.GetLogger(ctx)
+
+	session, err := ssoSessionFromRequest(req)
+	if err != nil {
+		logger.WithError(err).Warn("No valid SSO session cookie")
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.Unknown("No SSO login is in progress, or it has expired"),
+		}
+	}
+	// The cookie isn't signed, so check the redirect URL again rather than
+	// trusting that it was checked when the login started.
+	redirectURL, err := url.Parse(session.RedirectURL)
+	if err != nil || !cfg.Login.SSO.IsClientAllowed(redirectURL) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("redirectUrl is not an allowed client"),
+		}
+	}
+
+	result, err := auth.ProcessCallback(ctx, session.ProviderID, cfg.Login.SSO.CallbackURL, session.Nonce, req.URL.Query())
+	if err != nil {
+		logger.WithError(err).Warn("SSO login failed")
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Login at the identity provider failed"),
+		}
+	}
+
+	localpart, err := accountDB.GetLocalpartForSSOIdentity(ctx, result.Identity.IdPID, result.Identity.Subject)
+	if err != nil {
+		logger.WithError(err).Error("accountDB.GetLocalpartForSSOIdentity failed")
+		return jsonerror.InternalServerError()
+	}
+	if localpart == "" {
+		provider := auth.Provider(session.ProviderID)
+		if provider == nil || !provider.AutoProvision {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden("There is no account linked to this identity"),
+			}
+		}
+		var resErr *util.JSONResponse
+		localpart, resErr = provisionSSOAccount(ctx, result, accountDB, userAPI)
+		if resErr != nil {
+			return *resErr
+		}
+	}
+
+	var tokenRes userapi.PerformLoginTokenCreationResponse
+	err = userAPI.PerformLoginTokenCreation(ctx, &userapi.PerformLoginTokenCreationRequest{
+		Data: userapi.LoginTokenData{
+			UserID: userutil.MakeUserID(localpart, cfg.Matrix.ServerName),
+		},
+	}, &tokenRes)
+	if err != nil {
+		logger.WithError(err).Error("userAPI.PerformLoginTokenCreation failed")
+		return jsonerror.InternalServerError()
+	}
+
+	q := redirectURL.Query()
+	q.Set("loginToken", tokenRes.Metadata.Token)
+	redirectURL.RawQuery = q.Encode()
+
+	// The session can only be used once, so clear the cookie.
+	cookie := ssoCookie(cfg.Login.SSO.CallbackURL)
+	cookie.MaxAge = -1
+	res := util.RedirectResponse(redirectURL.String())
+	res.Headers["Set-Cookie"] = cookie.String()
+	return res
+}
+
+// provisionSSOAccount creates an account for an identity that we haven't seen
+// before and links the two. It tries to use the localpart that the identity
+// provider suggested, and otherwise uses a numeric one.
+func provisionSSOAccount(
+	ctx context.Context, result *sso.CallbackResult,
+	accountDB accounts.Database, userAPI userapi.UserInternalAPI,
+) (string, *util.JSONResponse) {
+	logger := util.GetLogger(ctx)
+	internalErr := jsonerror.InternalServerError()
+
+	localpart := strings.ToLower(result.SuggestedLocalpart)
+	created := false
+	if localpart != "" && validateUsername(localpart) == nil {
+		err := createSSOAccount(ctx, userAPI, localpart)
+		if _, ok := err.(*userapi.ErrorConflict); err != nil && !ok {
+			logger.WithError(err).Error("userAPI.PerformAccountCreation failed")
+			return "", &internalErr
+		}
+		created = err == nil
+	}
+	if !created {
+		// Either the suggested localpart was unusable or it is already taken.
+		id, err := accountDB.GetNewNumericLocalpart(ctx)
+		if err != nil {
+			logger.WithError(err).Error("accountDB.GetNewNumericLocalpart failed")
+			return "", &internalErr
+		}
+		localpart = strconv.FormatInt(id, 10)
+		if err = createSSOAccount(ctx, userAPI, localpart); err != nil {
+			logger.WithError(err).Error("userAPI.PerformAccountCreation failed")
+			return "", &internalErr
+		}
+	}
+
+	if err := accountDB.SaveSSOIdentity(ctx, result.Identity.IdPID, result.Identity.Subject, localpart); err != nil {
+		logger.WithError(err).Error("accountDB.SaveSSOIdentity failed")
+		return "", &internalErr
+	}
+	if result.DisplayName != "" {
+		if err := accountDB.SetDisplayName(ctx, localpart, result.DisplayName); err != nil {
+			// The account is usable without a display name, so carry on.
+			logger.WithError(err).Warn("accountDB.SetDisplayName failed")
+		}
+	}
+	return localpart, nil
+}
+
+// createSSOAccount creates a passwordless account. Returns a
+// *userapi.ErrorConflict if the localpart is taken.
+func createSSOAccount(ctx context.Context, userAPI userapi.UserInternalAPI, localpart string) error {
+	var res userapi.PerformAccountCreationResponse
+	return userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
+		AccountType: userapi.AccountTypeUser,
+		Localpart:   localpart,
+		OnConflict:  userapi.ConflictAbort,
+	}, &res)
+}
+
+func ssoSessionFromRequest(req *http.Request) (*ssoSession, error) {
+	cookie, err := req.Cookie(ssoCookieName)
+	if err != nil {
+		return nil, err
+	}
+	sessionJSON, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var session ssoSession
+	if err = json.Unmarshal(sessionJSON, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ssoCookie returns the session cookie without a value. The cookie is only
+// sent back to the callback URL.
+func ssoCookie(callbackURL string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     ssoCookieName,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	}
+	if u, err := url.Parse(callbackURL); err == nil {
+		cookie.Secure = u.Scheme == "https"
+		if u.Path != "" {
+			cookie.Path = u.Path
+		}
+	}
+	return cookie
+}
@@ -0,0 +1,37 @@
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/sso"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestSSORedirectClientWhitelist(t *testing.T) {
+	cfg := &config.SSO{
+		Enabled:     true,
+		CallbackURL: "https://example.com/_matrix/client/r0/login/sso/callback",
+		Providers: []config.IdentityProvider{
+			{ID: "corp", Name: "Corporate login", Issuer: "https://idp.example.com", ClientID: "dendrite"},
+		},
+		ClientWhitelist: []string{"https://app.example.com/"},
+	}
+	auth := sso.NewAuthenticator(cfg)
+	redirect := func(redirectURL string) int {
+		req := httptest.NewRequest(http.MethodGet, "/login/sso/redirect/unknown?redirectUrl="+url.QueryEscape(redirectURL), nil)
+		return SSORedirect(req, "unknown", auth, cfg).Code
+	}
+
+	for _, redirectURL := range []string{"https://evil.example.org/", "https://app.example.com.evil.org/"} {
+		if code := redirect(redirectURL); code != http.StatusForbidden {
+			t.Errorf("%s: got HTTP %d, want 403", redirectURL, code)
+		}
+	}
+	// Allowed clients get as far as looking up the identity provider.
+	if code := redirect("https://app.example.com/#/home"); code != http.StatusNotFound {
+		t.Errorf("allowed client: got HTTP %d, want 404 for the unknown identity provider", code)
+	}
+}
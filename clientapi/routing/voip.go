@@ -34,7 +34,13 @@ import (
 func RequestTurnServer(req *http.Request, device *api.Device, cfg *config.ClientAPI) util.JSONResponse {
 	turnConfig := cfg.TURN
 
-	// TODO Guest Support
+	if device.AccountType == api.AccountTypeGuest && !turnConfig.AllowGuests {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.GuestAccessForbidden("Guests cannot use the TURN server"),
+		}
+	}
+
 	if len(turnConfig.URIs) == 0 || turnConfig.UserLifetime == "" {
 		return util.JSONResponse{
 			Code: http.StatusOK,
@@ -0,0 +1,32 @@
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestRequestTurnServerGuests(t *testing.T) {
+	cfg := &config.ClientAPI{}
+	cfg.Defaults(false)
+	cfg.TURN.URIs = []string{"turn:turn.example.com:3478?transport=udp"}
+	cfg.TURN.UserLifetime = "1h"
+	cfg.TURN.SharedSecret = "secret"
+	guest := &api.Device{UserID: "@1:test", AccountType: api.AccountTypeGuest}
+
+	req := httptest.NewRequest(http.MethodGet, "/voip/turnServer", nil)
+	if res := RequestTurnServer(req, guest, cfg); res.Code != http.StatusOK {
+		t.Errorf("guests allowed: got HTTP %d, want 200", res.Code)
+	}
+	cfg.TURN.AllowGuests = false
+	if res := RequestTurnServer(req, guest, cfg); res.Code != http.StatusForbidden {
+		t.Errorf("guests not allowed: got HTTP %d, want 403", res.Code)
+	}
+	user := &api.Device{UserID: "@alice:test", AccountType: api.AccountTypeUser}
+	if res := RequestTurnServer(req, user, cfg); res.Code != http.StatusOK {
+		t.Errorf("user: got HTTP %d, want 200", res.Code)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	federationAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: %s [flags] list|block|unblock [server name]
+
+Manages the servers that the homeserver won't send federation traffic to.
+Servers are blacklisted automatically after too many failed requests, or
+can be blocked by hand. Blocked servers are never unblacklisted
+automatically. Unblocking a server retries sending to it straight away.
+
+Requires the access token of an admin account.
+
+Example:
+
+	# list blacklisted servers and servers that are being backed off from
+	%s -access-token XXX list
+	# block a server
+	%s -access-token XXX block example.com
+	# unblock a server
+	%s -access-token XXX unblock example.com
+
+Arguments:
+
+`
+
+var (
+	serverURL   = flag.String("url", "http://localhost:8008", "The URL of the homeserver's client API")
+	accessToken = flag.String("access-token", os.Getenv("DENDRITE_ACCESS_TOKEN"), "The access token of an admin account (defaults to $DENDRITE_ACCESS_TOKEN)")
+)
+
+func main() {
+	name := os.Args[0]
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, usage, name, name, name, name)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *accessToken == "" || flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	base := strings.TrimSuffix(*serverURL, "/") + "/_dendrite/admin/federation/blacklist"
+	switch command := flag.Arg(0); command {
+	case "list":
+		var res federationAPI.QueryBlacklistResponse
+		if err := doRequest(http.MethodGet, base, &res); err != nil {
+			logrus.Fatalln("Failed to list the blacklist:", err)
+		}
+		printDestinations(res.Destinations)
+	case "block", "unblock":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		method := http.MethodPut
+		if command == "unblock" {
+			method = http.MethodDelete
+		}
+		serverName := flag.Arg(1)
+		if err := doRequest(method, base+"/"+url.PathEscape(serverName), nil); err != nil {
+			logrus.Fatalf("Failed to %s %s: %s", command, serverName, err)
+		}
+		logrus.Infof("Done: %s %s", command, serverName)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func doRequest(method, target string, res interface{}) error {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*accessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(body, res)
+}
+
+func printDestinations(destinations []federationAPI.DestinationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVER\tSTATUS\tFAILURES\tSINCE/RETRY")
+	for _, d := range destinations {
+		status, when := "backing off", ""
+		switch {
+		case d.Manual:
+			status = "blocked"
+		case d.Blacklisted:
+			status = "blacklisted"
+		}
+		if d.Blacklisted && d.BlacklistedAt != 0 {
+			when = d.BlacklistedAt.Time().Format(time.RFC3339)
+		} else if !d.Blacklisted && d.RetryAt != 0 {
+			when = d.RetryAt.Time().Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", d.ServerName, status, d.FailureCount, when)
+	}
+	_ = w.Flush()
+}
@@ -75,6 +75,24 @@ type InputReceiptEventRequest struct {
 // InputReceiptEventResponse is a response to InputReceiptEventRequest
 type InputReceiptEventResponse struct{}
 
+// InputPresenceEvent is an event for notifying the EDU server about
+// a change to the presence of a user.
+type InputPresenceEvent struct {
+	UserID    string   `json:"user_id"`
+	Presence  Presence `json:"presence"`
+	StatusMsg *string  `json:"status_msg,omitempty"`
+	// LastActiveTS is when the user was last active.
+	LastActiveTS gomatrixserverlib.Timestamp `json:"last_active_ts"`
+}
+
+// InputPresenceEventRequest is a request to EDUServerInputAPI
+type InputPresenceEventRequest struct {
+	InputPresenceEvent InputPresenceEvent `json:"input_presence_event"`
+}
+
+// InputPresenceEventResponse is a response to InputPresenceEventRequest
+type InputPresenceEventResponse struct{}
+
 type InputCrossSigningKeyUpdateRequest struct {
 	CrossSigningKeyUpdate `json:"signing_keys"`
 }
@@ -100,4 +118,10 @@ type EDUServerInputAPI interface {
 		request *InputReceiptEventRequest,
 		response *InputReceiptEventResponse,
 	) error
+
+	InputPresenceEvent(
+		ctx context.Context,
+		request *InputPresenceEventRequest,
+		response *InputPresenceEventResponse,
+	) error
 }
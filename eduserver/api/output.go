@@ -51,6 +51,15 @@ type OutputReceiptEvent struct {
 	Timestamp gomatrixserverlib.Timestamp `json:"timestamp"`
 }
 
+// OutputPresenceEvent is an entry in the presence output kafka log.
+// It contains the complete presence state of the user.
+type OutputPresenceEvent struct {
+	UserID       string                      `json:"user_id"`
+	Presence     Presence                    `json:"presence"`
+	StatusMsg    *string                     `json:"status_msg,omitempty"`
+	LastActiveTS gomatrixserverlib.Timestamp `json:"last_active_ts"`
+}
+
 // OutputCrossSigningKeyUpdate is an entry in the signing key update output kafka log
 type OutputCrossSigningKeyUpdate struct {
 	CrossSigningKeyUpdate `json:"signing_keys"`
@@ -18,8 +18,29 @@ import "github.com/matrix-org/gomatrixserverlib"
 
 const (
 	MSigningKeyUpdate = "m.signing_key_update"
+	MPresence         = "m.presence"
 )
 
+// Presence is the presence state of a user, as described in
+// https://spec.matrix.org/v1.2/client-server-api/#presence
+type Presence string
+
+const (
+	PresenceOnline      Presence = "online"
+	PresenceUnavailable Presence = "unavailable"
+	PresenceOffline     Presence = "offline"
+)
+
+// IsValid returns true if the presence is one of those defined by the
+// specification.
+func (p Presence) IsValid() bool {
+	switch p {
+	case PresenceOnline, PresenceUnavailable, PresenceOffline:
+		return true
+	}
+	return false
+}
+
 type TypingEvent struct {
 	Type   string `json:"type"`
 	RoomID string `json:"room_id"`
@@ -52,6 +73,21 @@ type ReceiptTS struct {
 	TS gomatrixserverlib.Timestamp `json:"ts"`
 }
 
+// FederationPresenceData is the content of an m.presence EDU.
+type FederationPresenceData struct {
+	Push []FederationPresenceUpdate `json:"push"`
+}
+
+// FederationPresenceUpdate is the presence of a single user in an
+// m.presence EDU.
+type FederationPresenceUpdate struct {
+	UserID          string   `json:"user_id"`
+	Presence        Presence `json:"presence"`
+	StatusMsg       *string  `json:"status_msg,omitempty"`
+	LastActiveAgo   int64    `json:"last_active_ago"`
+	CurrentlyActive bool     `json:"currently_active,omitempty"`
+}
+
 type CrossSigningKeyUpdate struct {
 	MasterKey      *gomatrixserverlib.CrossSigningKey `json:"master_key,omitempty"`
 	SelfSigningKey *gomatrixserverlib.CrossSigningKey `json:"self_signing_key,omitempty"`
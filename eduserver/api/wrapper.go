@@ -68,6 +68,24 @@ func SendToDevice(
 	return eduAPI.InputSendToDeviceEvent(ctx, &request, &response)
 }
 
+// SendPresence sends a presence update to EDU Server
+func SendPresence(
+	ctx context.Context,
+	eduAPI EDUServerInputAPI, userID string, presence Presence, statusMsg *string,
+	lastActiveTS gomatrixserverlib.Timestamp,
+) error {
+	request := InputPresenceEventRequest{
+		InputPresenceEvent: InputPresenceEvent{
+			UserID:       userID,
+			Presence:     presence,
+			StatusMsg:    statusMsg,
+			LastActiveTS: lastActiveTS,
+		},
+	}
+	response := InputPresenceEventResponse{}
+	return eduAPI.InputPresenceEvent(ctx, &request, &response)
+}
+
 // SendReceipt sends a receipt event to EDU Server
 func SendReceipt(
 	ctx context.Context,
@@ -51,6 +51,7 @@ func NewInternalAPI(
 		OutputTypingEventTopic:       cfg.Matrix.JetStream.TopicFor(jetstream.OutputTypingEvent),
 		OutputSendToDeviceEventTopic: cfg.Matrix.JetStream.TopicFor(jetstream.OutputSendToDeviceEvent),
 		OutputReceiptEventTopic:      cfg.Matrix.JetStream.TopicFor(jetstream.OutputReceiptEvent),
+		OutputPresenceEventTopic:     cfg.Matrix.JetStream.TopicFor(jetstream.OutputPresenceEvent),
 		ServerName:                   cfg.Matrix.ServerName,
 	}
 }
@@ -39,6 +39,8 @@ type EDUServerInputAPI struct {
 	OutputSendToDeviceEventTopic string
 	// The kafka topic to output new receipt events to
 	OutputReceiptEventTopic string
+	// The kafka topic to output new presence events to
+	OutputPresenceEventTopic string
 	// kafka producer
 	JetStream nats.JetStreamContext
 	// Internal user query API
@@ -196,3 +198,32 @@ func (t *EDUServerInputAPI) InputReceiptEvent(
 	})
 	return err
 }
+
+// InputPresenceEvent implements api.EDUServerInputAPI
+func (t *EDUServerInputAPI) InputPresenceEvent(
+	ctx context.Context,
+	request *api.InputPresenceEventRequest,
+	response *api.InputPresenceEventResponse,
+) error {
+	ipe := &request.InputPresenceEvent
+	logrus.WithFields(logrus.Fields{
+		"user_id":  ipe.UserID,
+		"presence": ipe.Presence,
+	}).Tracef("Producing to topic '%s'", t.OutputPresenceEventTopic)
+	output := &api.OutputPresenceEvent{
+		UserID:       ipe.UserID,
+		Presence:     ipe.Presence,
+		StatusMsg:    ipe.StatusMsg,
+		LastActiveTS: ipe.LastActiveTS,
+	}
+	js, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.JetStream.PublishMsg(&nats.Msg{
+		Subject: t.OutputPresenceEventTopic,
+		Data:    js,
+	})
+	return err
+}
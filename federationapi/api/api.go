@@ -68,6 +68,12 @@ type FederationInternalAPI interface {
 		request *PerformJoinRequest,
 		response *PerformJoinResponse,
 	)
+	// Handle an instruction to make_knock & send_knock with a remote server.
+	PerformKnock(
+		ctx context.Context,
+		request *PerformKnockRequest,
+		response *PerformKnockResponse,
+	)
 	// Handle an instruction to peek a room on a remote server.
 	PerformOutboundPeek(
 		ctx context.Context,
@@ -98,6 +104,24 @@ type FederationInternalAPI interface {
 		request *PerformBroadcastEDURequest,
 		response *PerformBroadcastEDUResponse,
 	) error
+	// Query the servers that are blacklisted or that we are backing off from.
+	QueryBlacklist(
+		ctx context.Context,
+		request *QueryBlacklistRequest,
+		response *QueryBlacklistResponse,
+	) error
+	// Blacklist a server until it is explicitly unblacklisted.
+	PerformBlacklist(
+		ctx context.Context,
+		request *PerformBlacklistRequest,
+		response *PerformBlacklistResponse,
+	) error
+	// Lift the blacklist on a server and retry sending messages to it.
+	PerformUnblacklist(
+		ctx context.Context,
+		request *PerformUnblacklistRequest,
+		response *PerformUnblacklistResponse,
+	) error
 }
 
 type QueryServerKeysRequest struct {
@@ -150,6 +174,49 @@ type PerformJoinResponse struct {
 	LastError *gomatrix.HTTPError
 }
 
+type PerformKnockRequest struct {
+	RoomID string `json:"room_id"`
+	UserID string `json:"user_id"`
+	// The sorted list of servers to try. Servers will be tried sequentially, after de-duplication.
+	ServerNames types.ServerNames      `json:"server_names"`
+	Content     map[string]interface{} `json:"content"`
+}
+
+type PerformKnockResponse struct {
+	Event          *gomatrixserverlib.HeaderedEvent          `json:"event"`
+	KnockRoomState []gomatrixserverlib.InviteV2StrippedState `json:"knock_room_state"`
+	KnockedVia     gomatrixserverlib.ServerName              `json:"knocked_via"`
+	LastError      *gomatrix.HTTPError                       `json:"last_error"`
+}
+
+// RespMakeKnock is the content of a response to
+// GET /_matrix/federation/v1/make_knock/{roomID}/{userID}
+type RespMakeKnock struct {
+	// An incomplete m.room.member event for a user on the requesting server
+	// generated by the responding server.
+	KnockEvent  gomatrixserverlib.EventBuilder `json:"event"`
+	RoomVersion gomatrixserverlib.RoomVersion  `json:"room_version"`
+}
+
+// RespSendRestrictedJoin is the content of a response to
+// PUT /_matrix/federation/v2/send_join/{roomID}/{eventID} for a join that
+// we authorised under a restricted join rule. Unlike the response type in
+// gomatrixserverlib, it includes the join event with our signature on it.
+type RespSendRestrictedJoin struct {
+	StateEvents gomatrixserverlib.EventJSONs `json:"state"`
+	AuthEvents  gomatrixserverlib.EventJSONs `json:"auth_chain"`
+	Origin      gomatrixserverlib.ServerName `json:"origin"`
+	Event       gomatrixserverlib.RawJSON    `json:"event,omitempty"`
+}
+
+// RespSendKnock is the content of a response to
+// PUT /_matrix/federation/v1/send_knock/{roomID}/{eventID}
+type RespSendKnock struct {
+	// The stripped state of the room, so that the knocking user can see
+	// what they have knocked on.
+	KnockRoomState []gomatrixserverlib.InviteV2StrippedState `json:"knock_room_state"`
+}
+
 type PerformOutboundPeekRequest struct {
 	RoomID string `json:"room_id"`
 	// The sorted list of servers to try. Servers will be tried sequentially, after de-duplication.
@@ -203,6 +270,41 @@ type PerformBroadcastEDURequest struct {
 type PerformBroadcastEDUResponse struct {
 }
 
+// DestinationStatus describes a server that we are having trouble sending to.
+type DestinationStatus struct {
+	ServerName  gomatrixserverlib.ServerName `json:"server_name"`
+	Blacklisted bool                         `json:"blacklisted"`
+	// Whether the server was blacklisted by an admin.
+	Manual bool `json:"manual"`
+	// The number of consecutive failures.
+	FailureCount uint32 `json:"failure_count"`
+	// When the server was blacklisted, if it is.
+	BlacklistedAt gomatrixserverlib.Timestamp `json:"blacklisted_ts,omitempty"`
+	// When we will next try to send to the server, if it isn't blacklisted.
+	RetryAt gomatrixserverlib.Timestamp `json:"retry_ts,omitempty"`
+}
+
+type QueryBlacklistRequest struct {
+}
+
+type QueryBlacklistResponse struct {
+	Destinations []DestinationStatus `json:"destinations"`
+}
+
+type PerformBlacklistRequest struct {
+	ServerName gomatrixserverlib.ServerName `json:"server_name"`
+}
+
+type PerformBlacklistResponse struct {
+}
+
+type PerformUnblacklistRequest struct {
+	ServerName gomatrixserverlib.ServerName `json:"server_name"`
+}
+
+type PerformUnblacklistResponse struct {
+}
+
 type InputPublicKeysRequest struct {
 	Keys map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult `json:"keys"`
 }
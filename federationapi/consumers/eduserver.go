@@ -17,10 +17,12 @@ package consumers
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/matrix-org/dendrite/eduserver/api"
 	"github.com/matrix-org/dendrite/federationapi/queue"
 	"github.com/matrix-org/dendrite/federationapi/storage"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/setup/jetstream"
 	"github.com/matrix-org/dendrite/setup/process"
@@ -37,10 +39,13 @@ type OutputEDUConsumer struct {
 	durable           string
 	db                storage.Database
 	queues            *queue.OutgoingQueues
+	rsAPI             roomserverAPI.RoomserverInternalAPI
 	ServerName        gomatrixserverlib.ServerName
 	typingTopic       string
 	sendToDeviceTopic string
 	receiptTopic      string
+	presenceTopic     string
+	sendPresence      bool
 }
 
 // NewOutputEDUConsumer creates a new OutputEDUConsumer. Call Start() to begin consuming from EDU servers.
@@ -50,17 +55,21 @@ func NewOutputEDUConsumer(
 	js nats.JetStreamContext,
 	queues *queue.OutgoingQueues,
 	store storage.Database,
+	rsAPI roomserverAPI.RoomserverInternalAPI,
 ) *OutputEDUConsumer {
 	return &OutputEDUConsumer{
 		ctx:               process.Context(),
 		jetstream:         js,
 		queues:            queues,
 		db:                store,
+		rsAPI:             rsAPI,
 		ServerName:        cfg.Matrix.ServerName,
 		durable:           cfg.Matrix.JetStream.Durable("FederationAPIEDUServerConsumer"),
 		typingTopic:       cfg.Matrix.JetStream.TopicFor(jetstream.OutputTypingEvent),
 		sendToDeviceTopic: cfg.Matrix.JetStream.TopicFor(jetstream.OutputSendToDeviceEvent),
 		receiptTopic:      cfg.Matrix.JetStream.TopicFor(jetstream.OutputReceiptEvent),
+		presenceTopic:     cfg.Matrix.JetStream.TopicFor(jetstream.OutputPresenceEvent),
+		sendPresence:      cfg.Matrix.Presence.EnableOutbound,
 	}
 }
 
@@ -84,6 +93,14 @@ func (t *OutputEDUConsumer) Start() error {
 	); err != nil {
 		return err
 	}
+	if t.sendPresence {
+		if err := jetstream.JetStreamConsumer(
+			t.ctx, t.jetstream, t.presenceTopic, t.durable, t.onPresenceEvent,
+			nats.DeliverAll(), nats.ManualAck(),
+		); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -255,3 +272,76 @@ func (t *OutputEDUConsumer) onReceiptEvent(ctx context.Context, msg *nats.Msg) b
 
 	return true
 }
+
+// onPresenceEvent is called in response to a message received on the
+// presence events topic. The presence of local users is sent to every
+// server that they share a room with.
+func (t *OutputEDUConsumer) onPresenceEvent(ctx context.Context, msg *nats.Msg) bool {
+	var presence api.OutputPresenceEvent
+	if err := json.Unmarshal(msg.Data, &presence); err != nil {
+		// Skip this msg but continue processing messages.
+		log.WithError(err).Errorf("eduserver output log: message parse failed (expected presence)")
+		return true
+	}
+
+	// only send presence events which originated from us
+	_, presenceServerName, err := gomatrixserverlib.SplitID('@', presence.UserID)
+	if err != nil {
+		log.WithError(err).WithField("user_id", presence.UserID).Error("failed to extract domain from presence sender")
+		return true
+	}
+	if presenceServerName != t.ServerName {
+		return true
+	}
+
+	var queryRes roomserverAPI.QueryRoomsForUserResponse
+	err = t.rsAPI.QueryRoomsForUser(ctx, &roomserverAPI.QueryRoomsForUserRequest{
+		UserID:         presence.UserID,
+		WantMembership: gomatrixserverlib.Join,
+	}, &queryRes)
+	if err != nil {
+		log.WithError(err).WithField("user_id", presence.UserID).Error("failed to query rooms for user")
+		return false
+	}
+
+	names, err := t.db.GetJoinedHostsForRooms(ctx, queryRes.RoomIDs, true)
+	if err != nil {
+		log.WithError(err).WithField("user_id", presence.UserID).Error("failed to get joined hosts for rooms")
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+
+	lastActiveAgo := time.Since(presence.LastActiveTS.Time()).Milliseconds()
+	if lastActiveAgo < 0 {
+		lastActiveAgo = 0
+	}
+	content := api.FederationPresenceData{
+		Push: []api.FederationPresenceUpdate{
+			{
+				UserID:          presence.UserID,
+				Presence:        presence.Presence,
+				StatusMsg:       presence.StatusMsg,
+				LastActiveAgo:   lastActiveAgo,
+				CurrentlyActive: presence.Presence == api.PresenceOnline,
+			},
+		},
+	}
+
+	edu := &gomatrixserverlib.EDU{
+		Type:   api.MPresence,
+		Origin: string(t.ServerName),
+	}
+	if edu.Content, err = json.Marshal(content); err != nil {
+		log.WithError(err).Error("failed to marshal EDU JSON")
+		return true
+	}
+
+	if err := t.queues.SendEDU(edu, t.ServerName, names); err != nil {
+		log.WithError(err).Error("failed to send EDU")
+		return false
+	}
+
+	return true
+}
@@ -96,7 +96,7 @@ func NewInternalAPI(
 
 	queues := queue.NewOutgoingQueues(
 		federationDB, base.ProcessContext,
-		cfg.Matrix.DisableFederation,
+		cfg.Matrix.DisableFederation, &cfg.FederationDomains,
 		cfg.Matrix.ServerName, federation, rsAPI, stats,
 		&queue.SigningInfo{
 			KeyID:      cfg.Matrix.KeyID,
@@ -114,7 +114,7 @@ func NewInternalAPI(
 	}
 
 	tsConsumer := consumers.NewOutputEDUConsumer(
-		base.ProcessContext, cfg, js, queues, federationDB,
+		base.ProcessContext, cfg, js, queues, federationDB, rsAPI,
 	)
 	if err := tsConsumer.Start(); err != nil {
 		logrus.WithError(err).Panic("failed to start typing server consumer")
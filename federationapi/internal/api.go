@@ -49,14 +49,17 @@ func NewFederationInternalAPI(
 	if keyRing == nil {
 		keyRing = &gomatrixserverlib.KeyRing{
 			KeyFetchers: []gomatrixserverlib.KeyFetcher{},
-			KeyDatabase: serverKeyDB,
+			KeyDatabase: &allowedKeyDatabase{serverKeyDB, cfg},
 		}
 
 		addDirectFetcher := func() {
 			keyRing.KeyFetchers = append(
 				keyRing.KeyFetchers,
-				&gomatrixserverlib.DirectKeyFetcher{
-					Client: federation,
+				&allowedKeyFetcher{
+					&gomatrixserverlib.DirectKeyFetcher{
+						Client: federation,
+					},
+					cfg,
 				},
 			)
 		}
@@ -87,7 +90,7 @@ func NewFederationInternalAPI(
 				perspective.PerspectiveServerKeys[key.KeyID] = rawkey
 			}
 
-			keyRing.KeyFetchers = append(keyRing.KeyFetchers, perspective)
+			keyRing.KeyFetchers = append(keyRing.KeyFetchers, &allowedKeyFetcher{perspective, cfg})
 
 			logrus.WithFields(logrus.Fields{
 				"server_name":     ps.ServerName,
@@ -108,6 +111,9 @@ func NewFederationInternalAPI(
 }
 
 func (a *FederationInternalAPI) isBlacklistedOrBackingOff(s gomatrixserverlib.ServerName) (*statistics.ServerStatistics, error) {
+	if err := a.checkFederationAllowed(s); err != nil {
+		return nil, err
+	}
 	stats := a.statistics.ForServer(s)
 	until, blacklisted := stats.BackoffInfo()
 	if blacklisted {
@@ -125,6 +131,18 @@ func (a *FederationInternalAPI) isBlacklistedOrBackingOff(s gomatrixserverlib.Se
 	return stats, nil
 }
 
+// checkFederationAllowed returns an error if the federation domain lists
+// don't allow us to talk to the server.
+func (a *FederationInternalAPI) checkFederationAllowed(s gomatrixserverlib.ServerName) error {
+	if !a.cfg.FederationDomains.IsAllowed(s) {
+		return &api.FederationClientError{
+			Err:         fmt.Sprintf("federation with server %q is not allowed", s),
+			Blacklisted: true,
+		}
+	}
+	return nil
+}
+
 func failBlacklistableError(err error, stats *statistics.ServerStatistics) (until time.Time, blacklisted bool) {
 	if err == nil {
 		return
@@ -170,6 +188,9 @@ func (a *FederationInternalAPI) doRequestIfNotBackingOffOrBlacklisted(
 func (a *FederationInternalAPI) doRequestIfNotBlacklisted(
 	s gomatrixserverlib.ServerName, request func() (interface{}, error),
 ) (interface{}, error) {
+	if err := a.checkFederationAllowed(s); err != nil {
+		return nil, err
+	}
 	stats := a.statistics.ForServer(s)
 	if _, blacklisted := stats.BackoffInfo(); blacklisted {
 		return stats, &api.FederationClientError{
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/sirupsen/logrus"
 )
@@ -246,3 +247,52 @@ func (s *FederationInternalAPI) handleFetcherKeys(
 
 	return nil
 }
+
+// allowedKeyRequests returns the key requests for our own server and the
+// servers that we are allowed to federate with. We don't want to trust the
+// keys of any other servers, or to contact them to fetch their keys.
+func allowedKeyRequests(
+	cfg *config.FederationAPI,
+	requests map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.Timestamp,
+) map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.Timestamp {
+	allowed := make(map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.Timestamp, len(requests))
+	for req, ts := range requests {
+		if req.ServerName == cfg.Matrix.ServerName || cfg.FederationDomains.IsAllowed(req.ServerName) {
+			allowed[req] = ts
+		}
+	}
+	return allowed
+}
+
+// allowedKeyFetcher is a key fetcher that is only asked for the keys of the
+// servers that we are allowed to federate with.
+type allowedKeyFetcher struct {
+	gomatrixserverlib.KeyFetcher
+	cfg *config.FederationAPI
+}
+
+func (f *allowedKeyFetcher) FetchKeys(
+	ctx context.Context,
+	requests map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.Timestamp,
+) (map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult, error) {
+	allowed := allowedKeyRequests(f.cfg, requests)
+	if len(allowed) == 0 {
+		return map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult{}, nil
+	}
+	return f.KeyFetcher.FetchKeys(ctx, allowed)
+}
+
+// allowedKeyDatabase is a key database that only returns the stored keys of
+// the servers that we are allowed to federate with, so that events from
+// other servers fail signature checks even if we have their keys.
+type allowedKeyDatabase struct {
+	gomatrixserverlib.KeyDatabase
+	cfg *config.FederationAPI
+}
+
+func (d *allowedKeyDatabase) FetchKeys(
+	ctx context.Context,
+	requests map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.Timestamp,
+) (map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult, error) {
+	return d.KeyDatabase.FetchKeys(ctx, allowedKeyRequests(d.cfg, requests))
+}
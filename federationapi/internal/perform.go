@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/matrix-org/dendrite/federationapi/api"
@@ -22,6 +24,9 @@ func (r *FederationInternalAPI) PerformDirectoryLookup(
 	request *api.PerformDirectoryLookupRequest,
 	response *api.PerformDirectoryLookupResponse,
 ) (err error) {
+	if err = r.checkFederationAllowed(request.ServerName); err != nil {
+		return err
+	}
 	dir, err := r.federation.LookupRoomAlias(
 		ctx,
 		request.ServerName,
@@ -109,27 +114,33 @@ func (r *FederationInternalAPI) PerformJoin(
 	}
 
 	// If we reach here then we didn't complete a join for some reason.
+	response.LastError = lastHTTPError(lastErr)
+
+	logrus.Errorf(
+		"failed to join user %q to room %q through %d server(s): last error %s",
+		request.UserID, request.RoomID, len(request.ServerNames), lastErr,
+	)
+}
+
+// lastHTTPError converts the last error from trying a list of servers into
+// an HTTP error that can be returned in an API response.
+func lastHTTPError(lastErr error) *gomatrix.HTTPError {
 	var httpErr gomatrix.HTTPError
 	if ok := errors.As(lastErr, &httpErr); ok {
 		httpErr.Message = string(httpErr.Contents)
 		// Clear the wrapped error, else serialising to JSON (in polylith mode) will fail
 		httpErr.WrappedError = nil
-		response.LastError = &httpErr
-	} else {
-		response.LastError = &gomatrix.HTTPError{
-			Code:         0,
-			WrappedError: nil,
-			Message:      "Unknown HTTP error",
-		}
-		if lastErr != nil {
-			response.LastError.Message = lastErr.Error()
-		}
+		return &httpErr
 	}
-
-	logrus.Errorf(
-		"failed to join user %q to room %q through %d server(s): last error %s",
-		request.UserID, request.RoomID, len(request.ServerNames), lastErr,
-	)
+	res := &gomatrix.HTTPError{
+		Code:         0,
+		WrappedError: nil,
+		Message:      "Unknown HTTP error",
+	}
+	if lastErr != nil {
+		res.Message = lastErr.Error()
+	}
+	return res
 }
 
 func (r *FederationInternalAPI) performJoinUsingServer(
@@ -139,6 +150,9 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 	serverName gomatrixserverlib.ServerName,
 	supportedVersions []gomatrixserverlib.RoomVersion,
 ) error {
+	if err := r.checkFederationAllowed(serverName); err != nil {
+		return err
+	}
 	// Try to perform a make_join using the information supplied in the
 	// request.
 	respMakeJoin, err := r.federation.MakeJoin(
@@ -166,6 +180,19 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 		content = map[string]interface{}{}
 	}
 	content["membership"] = "join"
+
+	// If the room has a restricted join rule then the remote server will
+	// have nominated one of its users to authorise the join, so keep that.
+	var makeJoinContent gomatrixserverlib.MemberContent
+	if err = json.Unmarshal(respMakeJoin.JoinEvent.Content, &makeJoinContent); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	authorisedVia := makeJoinContent.AuthorisedVia
+	if authorisedVia != "" {
+		content["join_authorised_via_users_server"] = authorisedVia
+	} else {
+		delete(content, "join_authorised_via_users_server")
+	}
 	if err = respMakeJoin.JoinEvent.SetContent(content); err != nil {
 		return fmt.Errorf("respMakeJoin.JoinEvent.SetContent: %w", err)
 	}
@@ -196,12 +223,19 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 		return fmt.Errorf("respMakeJoin.JoinEvent.Build: %w", err)
 	}
 
-	// Try to perform a send_join using the newly built event.
-	respSendJoin, err := r.federation.SendJoin(
-		context.Background(),
-		serverName,
-		event,
-	)
+	// Try to perform a send_join using the newly built event. If the join
+	// was authorised by the remote server then it will have signed it, so
+	// we need to use the event that it sends back to us.
+	var respSendJoin gomatrixserverlib.RespSendJoin
+	if authorisedVia != "" {
+		respSendJoin, event, err = r.sendRestrictedJoin(ctx, serverName, event, respMakeJoin.RoomVersion)
+	} else {
+		respSendJoin, err = r.federation.SendJoin(
+			context.Background(),
+			serverName,
+			event,
+		)
+	}
 	if err != nil {
 		r.statistics.ForServer(serverName).Failure()
 		return fmt.Errorf("r.federation.SendJoin: %w", err)
@@ -254,6 +288,223 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 	return nil
 }
 
+// sendRestrictedJoin performs a send_join for a join that is authorised
+// by the remote server, returning the join event with its signature.
+func (r *FederationInternalAPI) sendRestrictedJoin(
+	ctx context.Context, s gomatrixserverlib.ServerName,
+	event *gomatrixserverlib.Event, roomVersion gomatrixserverlib.RoomVersion,
+) (gomatrixserverlib.RespSendJoin, *gomatrixserverlib.Event, error) {
+	path := "/_matrix/federation/v2/send_join/" +
+		url.PathEscape(event.RoomID()) + "/" +
+		url.PathEscape(event.EventID())
+	req := gomatrixserverlib.NewFederationRequest(http.MethodPut, s, path)
+	if err := req.SetContent(event); err != nil {
+		return gomatrixserverlib.RespSendJoin{}, nil, err
+	}
+	var res api.RespSendRestrictedJoin
+	if err := r.doFederationRequest(ctx, req, &res); err != nil {
+		return gomatrixserverlib.RespSendJoin{}, nil, err
+	}
+	respSendJoin := gomatrixserverlib.RespSendJoin{
+		StateEvents: res.StateEvents,
+		AuthEvents:  res.AuthEvents,
+		Origin:      res.Origin,
+	}
+	if len(res.Event) == 0 {
+		return respSendJoin, nil, fmt.Errorf("server %q did not return the signed join event", s)
+	}
+	signed, err := gomatrixserverlib.NewEventFromUntrustedJSON(res.Event, roomVersion)
+	if err != nil {
+		return respSendJoin, nil, fmt.Errorf("gomatrixserverlib.NewEventFromUntrustedJSON: %w", err)
+	}
+	// Only the signatures should have changed, which don't affect the
+	// event ID.
+	if signed.EventID() != event.EventID() {
+		return respSendJoin, nil, fmt.Errorf("server %q returned a different join event", s)
+	}
+	return respSendJoin, signed, nil
+}
+
+// PerformKnock implements api.FederationInternalAPI
+func (r *FederationInternalAPI) PerformKnock(
+	ctx context.Context,
+	request *api.PerformKnockRequest,
+	response *api.PerformKnockResponse,
+) {
+	// Only offer the room versions that we support knocking in, as the
+	// remote server will refuse the make_knock otherwise.
+	var supportedVersions []gomatrixserverlib.RoomVersion
+	for v := range version.SupportedRoomVersions() {
+		if allowed, err := v.AllowKnockingInEventAuth(); err == nil && allowed {
+			supportedVersions = append(supportedVersions, v)
+		}
+	}
+
+	// Deduplicate the server names we were provided but keep the ordering
+	// as this encodes useful information about which servers are most likely
+	// to respond.
+	seenSet := make(map[gomatrixserverlib.ServerName]bool)
+	var uniqueList []gomatrixserverlib.ServerName
+	for _, srv := range request.ServerNames {
+		if seenSet[srv] {
+			continue
+		}
+		seenSet[srv] = true
+		uniqueList = append(uniqueList, srv)
+	}
+	request.ServerNames = uniqueList
+
+	// Try each server that we were provided until we land on one that
+	// successfully completes the make-knock send-knock dance.
+	var lastErr error
+	for _, serverName := range request.ServerNames {
+		event, knockRoomState, err := r.performKnockUsingServer(
+			ctx,
+			request.RoomID,
+			request.UserID,
+			request.Content,
+			serverName,
+			supportedVersions,
+		)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"server_name": serverName,
+				"room_id":     request.RoomID,
+			}).Warnf("Failed to knock on room through server")
+			lastErr = err
+			continue
+		}
+
+		response.Event = event
+		response.KnockRoomState = knockRoomState
+		response.KnockedVia = serverName
+		return
+	}
+
+	// If we reach here then we didn't complete a knock for some reason.
+	response.LastError = lastHTTPError(lastErr)
+
+	logrus.Errorf(
+		"failed to knock user %q on room %q through %d server(s): last error %s",
+		request.UserID, request.RoomID, len(request.ServerNames), lastErr,
+	)
+}
+
+func (r *FederationInternalAPI) performKnockUsingServer(
+	ctx context.Context,
+	roomID, userID string,
+	content map[string]interface{},
+	serverName gomatrixserverlib.ServerName,
+	supportedVersions []gomatrixserverlib.RoomVersion,
+) (*gomatrixserverlib.HeaderedEvent, []gomatrixserverlib.InviteV2StrippedState, error) {
+	if err := r.checkFederationAllowed(serverName); err != nil {
+		return nil, nil, err
+	}
+	respMakeKnock, err := r.makeKnock(ctx, serverName, roomID, userID, supportedVersions)
+	if err != nil {
+		r.statistics.ForServer(serverName).Failure()
+		return nil, nil, fmt.Errorf("r.makeKnock: %w", err)
+	}
+	r.statistics.ForServer(serverName).Success()
+
+	// Set all the fields to be what they should be, this should be a no-op
+	// but it's possible that the remote server returned us something "odd"
+	respMakeKnock.KnockEvent.Type = gomatrixserverlib.MRoomMember
+	respMakeKnock.KnockEvent.Sender = userID
+	respMakeKnock.KnockEvent.StateKey = &userID
+	respMakeKnock.KnockEvent.RoomID = roomID
+	respMakeKnock.KnockEvent.Redacts = ""
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	content["membership"] = gomatrixserverlib.Knock
+	if err = respMakeKnock.KnockEvent.SetContent(content); err != nil {
+		return nil, nil, fmt.Errorf("respMakeKnock.KnockEvent.SetContent: %w", err)
+	}
+	if err = respMakeKnock.KnockEvent.SetUnsigned(struct{}{}); err != nil {
+		return nil, nil, fmt.Errorf("respMakeKnock.KnockEvent.SetUnsigned: %w", err)
+	}
+
+	// Knocking was only introduced in room version 7, so unlike make_join
+	// there's no default room version to fall back to.
+	allowed, err := respMakeKnock.RoomVersion.AllowKnockingInEventAuth()
+	if err != nil {
+		return nil, nil, fmt.Errorf("respMakeKnock.RoomVersion.AllowKnockingInEventAuth: %w", err)
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("room version %q does not support knocking", respMakeKnock.RoomVersion)
+	}
+
+	// Build the knock event.
+	event, err := respMakeKnock.KnockEvent.Build(
+		time.Now(),
+		r.cfg.Matrix.ServerName,
+		r.cfg.Matrix.KeyID,
+		r.cfg.Matrix.PrivateKey,
+		respMakeKnock.RoomVersion,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("respMakeKnock.KnockEvent.Build: %w", err)
+	}
+
+	// Try to perform a send_knock using the newly built event.
+	respSendKnock, err := r.sendKnock(ctx, serverName, event)
+	if err != nil {
+		r.statistics.ForServer(serverName).Failure()
+		return nil, nil, fmt.Errorf("r.sendKnock: %w", err)
+	}
+	r.statistics.ForServer(serverName).Success()
+
+	return event.Headered(respMakeKnock.RoomVersion), respSendKnock.KnockRoomState, nil
+}
+
+// makeKnock asks a remote server for a knock event template. Knocking isn't
+// supported by the federation client yet, so the request is made by hand.
+func (r *FederationInternalAPI) makeKnock(
+	ctx context.Context, s gomatrixserverlib.ServerName, roomID, userID string,
+	roomVersions []gomatrixserverlib.RoomVersion,
+) (res api.RespMakeKnock, err error) {
+	query := url.Values{}
+	for _, v := range roomVersions {
+		query.Add("ver", string(v))
+	}
+	path := "/_matrix/federation/v1/make_knock/" +
+		url.PathEscape(roomID) + "/" +
+		url.PathEscape(userID) + "?" + query.Encode()
+	req := gomatrixserverlib.NewFederationRequest(http.MethodGet, s, path)
+	err = r.doFederationRequest(ctx, req, &res)
+	return
+}
+
+// sendKnock sends a knock event obtained using makeKnock to a remote server.
+func (r *FederationInternalAPI) sendKnock(
+	ctx context.Context, s gomatrixserverlib.ServerName, event *gomatrixserverlib.Event,
+) (res api.RespSendKnock, err error) {
+	path := "/_matrix/federation/v1/send_knock/" +
+		url.PathEscape(event.RoomID()) + "/" +
+		url.PathEscape(event.EventID())
+	req := gomatrixserverlib.NewFederationRequest(http.MethodPut, s, path)
+	if err = req.SetContent(event); err != nil {
+		return
+	}
+	err = r.doFederationRequest(ctx, req, &res)
+	return
+}
+
+// doFederationRequest signs the request with our server key and sends it.
+func (r *FederationInternalAPI) doFederationRequest(
+	ctx context.Context, req gomatrixserverlib.FederationRequest, res interface{},
+) error {
+	if err := req.Sign(r.cfg.Matrix.ServerName, r.cfg.Matrix.KeyID, r.cfg.Matrix.PrivateKey); err != nil {
+		return fmt.Errorf("req.Sign: %w", err)
+	}
+	httpReq, err := req.HTTPRequest()
+	if err != nil {
+		return fmt.Errorf("req.HTTPRequest: %w", err)
+	}
+	return r.federation.DoRequestAndParseResponse(ctx, httpReq, res)
+}
+
 // PerformOutboundPeekRequest implements api.FederationInternalAPI
 func (r *FederationInternalAPI) PerformOutboundPeek(
 	ctx context.Context,
@@ -341,6 +592,9 @@ func (r *FederationInternalAPI) performOutboundPeekUsingServer(
 	serverName gomatrixserverlib.ServerName,
 	supportedVersions []gomatrixserverlib.RoomVersion,
 ) error {
+	if err := r.checkFederationAllowed(serverName); err != nil {
+		return err
+	}
 	// create a unique ID for this peek.
 	// for now we just use the room ID again. In future, if we ever
 	// support concurrent peeks to the same room with different filters
@@ -444,6 +698,9 @@ func (r *FederationInternalAPI) PerformLeave(
 	// Try each server that we were provided until we land on one that
 	// successfully completes the make-leave send-leave dance.
 	for _, serverName := range request.ServerNames {
+		if !r.cfg.FederationDomains.IsAllowed(serverName) {
+			continue
+		}
 		// Try to perform a make_leave using the information supplied in the
 		// request.
 		respMakeLeave, err := r.federation.MakeLeave(
@@ -536,6 +793,9 @@ func (r *FederationInternalAPI) PerformInvite(
 	if err != nil {
 		return fmt.Errorf("gomatrixserverlib.SplitID: %w", err)
 	}
+	if err = r.checkFederationAllowed(destination); err != nil {
+		return err
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"event_id":     request.Event.EventID(),
@@ -571,7 +831,10 @@ func (r *FederationInternalAPI) PerformServersAlive(
 	response *api.PerformServersAliveResponse,
 ) (err error) {
 	for _, srv := range request.Servers {
-		_ = r.db.RemoveServerFromBlacklist(srv)
+		// Servers that an admin has blocked stay blocked.
+		if stats := r.statistics.ForServer(srv); !stats.Manual() {
+			_ = stats.Unblacklist()
+		}
 		r.queues.RetryServer(srv)
 	}
 
@@ -613,6 +876,36 @@ func (r *FederationInternalAPI) PerformBroadcastEDU(
 	return nil
 }
 
+// PerformBlacklist implements api.FederationInternalAPI
+func (r *FederationInternalAPI) PerformBlacklist(
+	ctx context.Context,
+	request *api.PerformBlacklistRequest,
+	response *api.PerformBlacklistResponse,
+) error {
+	if request.ServerName == r.cfg.Matrix.ServerName {
+		return fmt.Errorf("cannot blacklist our own server name")
+	}
+	if err := r.statistics.ForServer(request.ServerName).Blacklist(); err != nil {
+		return fmt.Errorf("stats.Blacklist: %w", err)
+	}
+	logrus.WithContext(ctx).Infof("Blacklisted %q", request.ServerName)
+	return nil
+}
+
+// PerformUnblacklist implements api.FederationInternalAPI
+func (r *FederationInternalAPI) PerformUnblacklist(
+	ctx context.Context,
+	request *api.PerformUnblacklistRequest,
+	response *api.PerformUnblacklistResponse,
+) error {
+	if err := r.statistics.ForServer(request.ServerName).Unblacklist(); err != nil {
+		return fmt.Errorf("stats.Unblacklist: %w", err)
+	}
+	logrus.WithContext(ctx).Infof("Unblacklisted %q", request.ServerName)
+	r.queues.RetryServer(request.ServerName)
+	return nil
+}
+
 func sanityCheckAuthChain(authChain []*gomatrixserverlib.Event) error {
 	// sanity check we have a create event and it has a known room version
 	for _, ev := range authChain {
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/matrix-org/dendrite/federationapi/api"
@@ -95,3 +96,51 @@ func (a *FederationInternalAPI) QueryServerKeys(
 	res.ServerKeys = []gomatrixserverlib.ServerKeys{*serverKeys}
 	return nil
 }
+
+// QueryBlacklist implements api.FederationInternalAPI
+func (a *FederationInternalAPI) QueryBlacklist(
+	ctx context.Context,
+	request *api.QueryBlacklistRequest,
+	response *api.QueryBlacklistResponse,
+) error {
+	// The database knows about every blacklisted server, but only the
+	// statistics know about servers that we are still backing off from.
+	entries, err := a.db.GetAllBlacklistEntries()
+	if err != nil {
+		return fmt.Errorf("a.db.GetAllBlacklistEntries: %w", err)
+	}
+	seen := make(map[gomatrixserverlib.ServerName]struct{}, len(entries))
+	for _, entry := range entries {
+		seen[entry.ServerName] = struct{}{}
+		response.Destinations = append(response.Destinations, api.DestinationStatus{
+			ServerName:    entry.ServerName,
+			Blacklisted:   true,
+			Manual:        entry.Manual,
+			FailureCount:  entry.FailureCount,
+			BlacklistedAt: entry.BlacklistedAt,
+		})
+	}
+	for _, stats := range a.statistics.BackingOff() {
+		if _, ok := seen[stats.ServerName()]; ok {
+			continue
+		}
+		status := api.DestinationStatus{
+			ServerName:   stats.ServerName(),
+			FailureCount: stats.FailureCount(),
+		}
+		until, blacklisted := stats.BackoffInfo()
+		if blacklisted {
+			// Either the server was blacklisted after we read the database
+			// or the database write failed.
+			status.Blacklisted = true
+			status.Manual = stats.Manual()
+		} else if until != nil && until.After(time.Now()) {
+			status.RetryAt = gomatrixserverlib.AsTimestamp(*until)
+		}
+		response.Destinations = append(response.Destinations, status)
+	}
+	sort.Slice(response.Destinations, func(i, j int) bool {
+		return response.Destinations[i].ServerName < response.Destinations[j].ServerName
+	})
+	return nil
+}
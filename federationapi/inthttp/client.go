@@ -21,10 +21,14 @@ const (
 	FederationAPIPerformDirectoryLookupRequestPath = "/federationapi/performDirectoryLookup"
 	FederationAPIPerformJoinRequestPath            = "/federationapi/performJoinRequest"
 	FederationAPIPerformLeaveRequestPath           = "/federationapi/performLeaveRequest"
+	FederationAPIPerformKnockRequestPath           = "/federationapi/performKnockRequest"
 	FederationAPIPerformInviteRequestPath          = "/federationapi/performInviteRequest"
 	FederationAPIPerformOutboundPeekRequestPath    = "/federationapi/performOutboundPeekRequest"
 	FederationAPIPerformServersAlivePath           = "/federationapi/performServersAlive"
 	FederationAPIPerformBroadcastEDUPath           = "/federationapi/performBroadcastEDU"
+	FederationAPIQueryBlacklistPath                = "/federationapi/queryBlacklist"
+	FederationAPIPerformBlacklistPath              = "/federationapi/performBlacklist"
+	FederationAPIPerformUnblacklistPath            = "/federationapi/performUnblacklist"
 
 	FederationAPIGetUserDevicesPath      = "/federationapi/client/getUserDevices"
 	FederationAPIClaimKeysPath           = "/federationapi/client/claimKeys"
@@ -142,6 +146,26 @@ func (h *httpFederationInternalAPI) PerformJoin(
 	}
 }
 
+// Handle an instruction to make_knock & send_knock with a remote server.
+func (h *httpFederationInternalAPI) PerformKnock(
+	ctx context.Context,
+	request *api.PerformKnockRequest,
+	response *api.PerformKnockResponse,
+) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformKnockRequest")
+	defer span.Finish()
+
+	apiURL := h.federationAPIURL + FederationAPIPerformKnockRequestPath
+	err := httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
+	if err != nil {
+		response.LastError = &gomatrix.HTTPError{
+			Message:      err.Error(),
+			Code:         0,
+			WrappedError: err,
+		}
+	}
+}
+
 // Handle an instruction to make_join & send_join with a remote server.
 func (h *httpFederationInternalAPI) PerformDirectoryLookup(
 	ctx context.Context,
@@ -168,6 +192,45 @@ func (h *httpFederationInternalAPI) PerformBroadcastEDU(
 	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
 }
 
+// Query the servers that are blacklisted or that we are backing off from.
+func (h *httpFederationInternalAPI) QueryBlacklist(
+	ctx context.Context,
+	request *api.QueryBlacklistRequest,
+	response *api.QueryBlacklistResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryBlacklist")
+	defer span.Finish()
+
+	apiURL := h.federationAPIURL + FederationAPIQueryBlacklistPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
+}
+
+// Blacklist a server until it is explicitly unblacklisted.
+func (h *httpFederationInternalAPI) PerformBlacklist(
+	ctx context.Context,
+	request *api.PerformBlacklistRequest,
+	response *api.PerformBlacklistResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformBlacklist")
+	defer span.Finish()
+
+	apiURL := h.federationAPIURL + FederationAPIPerformBlacklistPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
+}
+
+// Lift the blacklist on a server and retry sending messages to it.
+func (h *httpFederationInternalAPI) PerformUnblacklist(
+	ctx context.Context,
+	request *api.PerformUnblacklistRequest,
+	response *api.PerformUnblacklistResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformUnblacklist")
+	defer span.Finish()
+
+	apiURL := h.federationAPIURL + FederationAPIPerformUnblacklistPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
+}
+
 type getUserDevices struct {
 	S      gomatrixserverlib.ServerName
 	UserID string
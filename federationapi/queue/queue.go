@@ -31,6 +31,7 @@ import (
 	"github.com/matrix-org/dendrite/federationapi/storage"
 	"github.com/matrix-org/dendrite/federationapi/storage/shared"
 	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/setup/process"
 )
 
@@ -40,6 +41,7 @@ type OutgoingQueues struct {
 	db          storage.Database
 	process     *process.ProcessContext
 	disabled    bool
+	domains     *config.FederationDomains
 	rsAPI       api.RoomserverInternalAPI
 	origin      gomatrixserverlib.ServerName
 	client      *gomatrixserverlib.FederationClient
@@ -85,6 +87,7 @@ func NewOutgoingQueues(
 	db storage.Database,
 	process *process.ProcessContext,
 	disabled bool,
+	domains *config.FederationDomains,
 	origin gomatrixserverlib.ServerName,
 	client *gomatrixserverlib.FederationClient,
 	rsAPI api.RoomserverInternalAPI,
@@ -93,6 +96,7 @@ func NewOutgoingQueues(
 ) *OutgoingQueues {
 	queues := &OutgoingQueues{
 		disabled:   disabled,
+		domains:    domains,
 		process:    process,
 		db:         db,
 		rsAPI:      rsAPI,
@@ -149,6 +153,9 @@ type queuedEDU struct {
 }
 
 func (oqs *OutgoingQueues) getQueue(destination gomatrixserverlib.ServerName) *destinationQueue {
+	if !oqs.domains.IsAllowed(destination) {
+		return nil
+	}
 	if oqs.statistics.ForServer(destination).Blacklisted() {
 		return nil
 	}
@@ -208,6 +215,13 @@ func (oqs *OutgoingQueues) SendEvent(
 	}
 	delete(destmap, oqs.origin)
 
+	// Remove any destinations that we aren't allowed to federate with.
+	for destination := range destmap {
+		if !oqs.domains.IsAllowed(destination) {
+			delete(destmap, destination)
+		}
+	}
+
 	// Check if any of the destinations are prohibited by server ACLs.
 	for destination := range destmap {
 		if api.IsServerBannedFromRoom(
@@ -273,6 +287,13 @@ func (oqs *OutgoingQueues) SendEDU(
 	}
 	delete(destmap, oqs.origin)
 
+	// Remove any destinations that we aren't allowed to federate with.
+	for destination := range destmap {
+		if !oqs.domains.IsAllowed(destination) {
+			delete(destmap, destination)
+		}
+	}
+
 	// There is absolutely no guarantee that the EDU will have a room_id
 	// field, as it is not required by the spec. However, if it *does*
 	// (e.g. typing notifications) then we should try to make sure we don't
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+
+	"github.com/matrix-org/dendrite/federationapi/statistics"
+	"github.com/matrix-org/dendrite/federationapi/storage"
+	"github.com/matrix-org/dendrite/federationapi/storage/shared"
+	"github.com/matrix-org/dendrite/federationapi/types"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/process"
+)
+
+// queueDatabase records which destinations were queued for. Associating an
+// item with a destination fails so that the queues never try to send it.
+type queueDatabase struct {
+	storage.Database
+	mu           sync.Mutex
+	stored       int
+	destinations []gomatrixserverlib.ServerName
+}
+
+func (d *queueDatabase) StoreJSON(ctx context.Context, js string) (*shared.Receipt, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stored++
+	return &shared.Receipt{}, nil
+}
+
+func (d *queueDatabase) AssociatePDUWithDestination(ctx context.Context, transactionID gomatrixserverlib.TransactionID, serverName gomatrixserverlib.ServerName, receipt *shared.Receipt) error {
+	return d.associate(serverName)
+}
+
+func (d *queueDatabase) AssociateEDUWithDestination(ctx context.Context, serverName gomatrixserverlib.ServerName, receipt *shared.Receipt) error {
+	return d.associate(serverName)
+}
+
+func (d *queueDatabase) associate(serverName gomatrixserverlib.ServerName) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.destinations = append(d.destinations, serverName)
+	return fmt.Errorf("not sending to %s in tests", serverName)
+}
+
+func (d *queueDatabase) GetBlacklistEntry(serverName gomatrixserverlib.ServerName) (*types.BlacklistEntry, error) {
+	return nil, nil
+}
+
+type queueRoomserverAPI struct {
+	api.RoomserverInternalAPI
+}
+
+func (a *queueRoomserverAPI) QueryServerBannedFromRoom(ctx context.Context, req *api.QueryServerBannedFromRoomRequest, res *api.QueryServerBannedFromRoomResponse) error {
+	return nil
+}
+
+func TestOutgoingQueuesFederationDomains(t *testing.T) {
+	room := test.NewRoom(t, "@alice:test")
+	ev := room.CreateAndInsert(t, "@alice:test", "m.room.message", map[string]interface{}{"body": "hello"})
+	edu := &gomatrixserverlib.EDU{Type: gomatrixserverlib.MTyping}
+
+	newQueues := func(db *queueDatabase) *OutgoingQueues {
+		return &OutgoingQueues{
+			db:         db,
+			process:    process.NewProcessContext(),
+			domains:    &config.FederationDomains{Deny: []string{"denied.org"}},
+			rsAPI:      &queueRoomserverAPI{},
+			origin:     "test",
+			statistics: &statistics.Statistics{DB: db},
+			queues:     map[gomatrixserverlib.ServerName]*destinationQueue{},
+		}
+	}
+
+	t.Run("denied destinations are dropped", func(t *testing.T) {
+		db := &queueDatabase{}
+		oqs := newQueues(db)
+		if err := oqs.SendEvent(ev, "test", []gomatrixserverlib.ServerName{"allowed.org", "denied.org"}); err != nil {
+			t.Fatalf("SendEvent: %s", err)
+		}
+		if err := oqs.SendEDU(edu, "test", []gomatrixserverlib.ServerName{"allowed.org", "denied.org"}); err != nil {
+			t.Fatalf("SendEDU: %s", err)
+		}
+		if db.stored != 2 {
+			t.Errorf("got %d stored items, want 2", db.stored)
+		}
+		for _, destination := range db.destinations {
+			if destination != "allowed.org" {
+				t.Errorf("queued for %q, want only allowed.org", destination)
+			}
+		}
+		if len(db.destinations) != 2 {
+			t.Errorf("queued %d items, want 2", len(db.destinations))
+		}
+		if oqs.getQueue("denied.org") != nil {
+			t.Errorf("got a queue for denied.org, want none")
+		}
+	})
+
+	t.Run("nothing is stored for only denied destinations", func(t *testing.T) {
+		db := &queueDatabase{}
+		oqs := newQueues(db)
+		if err := oqs.SendEvent(ev, "test", []gomatrixserverlib.ServerName{"denied.org"}); err != nil {
+			t.Fatalf("SendEvent: %s", err)
+		}
+		if err := oqs.SendEDU(edu, "test", []gomatrixserverlib.ServerName{"denied.org"}); err != nil {
+			t.Fatalf("SendEDU: %s", err)
+		}
+		if db.stored != 0 || len(db.destinations) != 0 {
+			t.Errorf("got %d stored items queued for %v, want nothing", db.stored, db.destinations)
+		}
+	})
+}
@@ -15,12 +15,14 @@
 package routing
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
 	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	federationAPI "github.com/matrix-org/dendrite/federationapi/api"
 	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -103,6 +105,17 @@ func MakeJoin(
 		}
 	}
 
+	// If the room has a restricted join rule then we need to nominate one
+	// of our users to authorise the join.
+	content := map[string]interface{}{"membership": gomatrixserverlib.Join}
+	authorisedVia, resErr := checkRestrictedJoin(httpReq, rsAPI, roomID, userID)
+	if resErr != nil {
+		return *resErr
+	}
+	if authorisedVia != "" {
+		content["join_authorised_via_users_server"] = authorisedVia
+	}
+
 	// Try building an event for the server
 	builder := gomatrixserverlib.EventBuilder{
 		Sender:   userID,
@@ -110,7 +123,7 @@ func MakeJoin(
 		Type:     "m.room.member",
 		StateKey: &userID,
 	}
-	err = builder.SetContent(map[string]interface{}{"membership": gomatrixserverlib.Join})
+	err = builder.SetContent(content)
 	if err != nil {
 		util.GetLogger(httpReq.Context()).WithError(err).Error("builder.SetContent failed")
 		return jsonerror.InternalServerError()
@@ -250,6 +263,34 @@ func SendJoin(
 		}
 	}
 
+	// If the join claims to have been authorised by one of our users, then
+	// check that the user is still allowed to join before we sign it.
+	var memberContent gomatrixserverlib.MemberContent
+	if err = json.Unmarshal(event.Content(), &memberContent); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The membership content could not be decoded: " + err.Error()),
+		}
+	}
+	if memberContent.AuthorisedVia != "" {
+		_, domain, serr := gomatrixserverlib.SplitID('@', memberContent.AuthorisedVia)
+		if serr != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("The join_authorised_via_users_server is not a valid user ID"),
+			}
+		}
+		if domain != cfg.Matrix.ServerName {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("The join must be sent to the server of the join_authorised_via_users_server"),
+			}
+		}
+		if _, resErr := checkRestrictedJoin(httpReq, rsAPI, roomID, *event.StateKey()); resErr != nil {
+			return *resErr
+		}
+	}
+
 	// Check that the event is signed by the server sending the request.
 	redacted := event.Redact()
 	verifyRequests := []gomatrixserverlib.VerifyJSONRequest{{
@@ -270,6 +311,13 @@ func SendJoin(
 		}
 	}
 
+	// The auth rules require that a restricted join is signed by the
+	// server of the user that authorised it, which is us.
+	if memberContent.AuthorisedVia != "" {
+		signed := event.Sign(string(cfg.Matrix.ServerName), cfg.Matrix.KeyID, cfg.Matrix.PrivateKey)
+		event = &signed
+	}
+
 	// Fetch the state and auth chain. We do this before we send the events
 	// on, in case this fails.
 	var stateAndAuthChainResponse api.QueryStateAndAuthChainResponse
@@ -347,6 +395,20 @@ func SendJoin(
 	sort.Sort(eventsByDepth(stateAndAuthChainResponse.StateEvents))
 	sort.Sort(eventsByDepth(stateAndAuthChainResponse.AuthChainEvents))
 
+	// If we signed the join then the joining server needs our signature too,
+	// so return the event along with the state.
+	if memberContent.AuthorisedVia != "" {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: federationAPI.RespSendRestrictedJoin{
+				StateEvents: gomatrixserverlib.NewEventJSONsFromHeaderedEvents(stateAndAuthChainResponse.StateEvents),
+				AuthEvents:  gomatrixserverlib.NewEventJSONsFromHeaderedEvents(stateAndAuthChainResponse.AuthChainEvents),
+				Origin:      cfg.Matrix.ServerName,
+				Event:       event.JSON(),
+			},
+		}
+	}
+
 	// https://matrix.org/docs/spec/server_server/latest#put-matrix-federation-v1-send-join-roomid-eventid
 	return util.JSONResponse{
 		Code: http.StatusOK,
@@ -358,6 +420,49 @@ func SendJoin(
 	}
 }
 
+// checkRestrictedJoin works out whether the user may join the room if it
+// has a restricted join rule. It returns the local user that should
+// authorise the join, or an empty string if the join doesn't need to be
+// authorised.
+func checkRestrictedJoin(
+	httpReq *http.Request,
+	rsAPI api.RoomserverInternalAPI,
+	roomID, userID string,
+) (string, *util.JSONResponse) {
+	req := &api.QueryRestrictedJoinAllowedRequest{
+		RoomID: roomID,
+		UserID: userID,
+	}
+	res := &api.QueryRestrictedJoinAllowedResponse{}
+	if err := rsAPI.QueryRestrictedJoinAllowed(httpReq.Context(), req, res); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryRestrictedJoinAllowed failed")
+		resErr := jsonerror.InternalServerError()
+		return "", &resErr
+	}
+	switch {
+	case !res.Restricted:
+		return "", nil
+	case !res.Resident && !res.Allowed:
+		// We aren't in all of the allowed rooms, so another server may know
+		// better than us.
+		return "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.UnableToAuthoriseJoin("This server cannot determine whether the user may join the room"),
+		}
+	case !res.Allowed:
+		return "", &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("The user is not a member of any of the rooms that allow joining this room"),
+		}
+	case !res.Resident:
+		return "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.UnableToGrantJoin("None of the users on this server are able to authorise the join"),
+		}
+	}
+	return res.AuthorisedVia, nil
+}
+
 type eventsByDepth []*gomatrixserverlib.HeaderedEvent
 
 func (e eventsByDepth) Len() int {
@@ -196,6 +196,10 @@ func NotaryKeys(
 
 	for serverName, kidToCriteria := range req.ServerKeys {
 		var keyList []gomatrixserverlib.ServerKeys
+		if !cfg.FederationDomains.IsAllowed(serverName) {
+			// We don't vouch for the keys of servers we won't talk to.
+			continue
+		}
 		if serverName == cfg.Matrix.ServerName {
 			if k, err := localKeys(cfg, time.Now().Add(cfg.Matrix.KeyValidityPeriod)); err == nil {
 				keyList = append(keyList, *k)
@@ -0,0 +1,360 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	federationAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+// knockRoomStateTypes are the types of state event that are given to a
+// knocking user, so that their client can show what they've knocked on.
+var knockRoomStateTypes = []string{
+	gomatrixserverlib.MRoomCreate,
+	gomatrixserverlib.MRoomName,
+	gomatrixserverlib.MRoomCanonicalAlias,
+	gomatrixserverlib.MRoomJoinRules,
+	gomatrixserverlib.MRoomAvatar,
+	gomatrixserverlib.MRoomEncryption,
+	"m.room.topic",
+}
+
+// MakeKnock implements the /make_knock API
+func MakeKnock(
+	httpReq *http.Request,
+	request *gomatrixserverlib.FederationRequest,
+	cfg *config.FederationAPI,
+	rsAPI api.RoomserverInternalAPI,
+	roomID, userID string,
+	remoteVersions []gomatrixserverlib.RoomVersion,
+) util.JSONResponse {
+	verReq := api.QueryRoomVersionForRoomRequest{RoomID: roomID}
+	verRes := api.QueryRoomVersionForRoomResponse{}
+	if err := rsAPI.QueryRoomVersionForRoom(httpReq.Context(), &verReq, &verRes); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: jsonerror.InternalServerError(),
+		}
+	}
+
+	// Check that the room that the remote side is trying to knock on is
+	// actually one of the room versions that they listed in their supported
+	// ?ver= in the make_knock URL.
+	remoteSupportsVersion := false
+	for _, v := range remoteVersions {
+		if v == verRes.RoomVersion {
+			remoteSupportsVersion = true
+			break
+		}
+	}
+	if !remoteSupportsVersion {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.IncompatibleRoomVersion(verRes.RoomVersion),
+		}
+	}
+	if resErr := checkKnockingSupported(verRes.RoomVersion); resErr != nil {
+		return *resErr
+	}
+
+	_, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("Invalid UserID"),
+		}
+	}
+	if domain != request.Origin() {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("The knock must be sent by the server of the user"),
+		}
+	}
+
+	// Check if we think we are still joined to the room
+	inRoomReq := &api.QueryServerJoinedToRoomRequest{
+		ServerName: cfg.Matrix.ServerName,
+		RoomID:     roomID,
+	}
+	inRoomRes := &api.QueryServerJoinedToRoomResponse{}
+	if err = rsAPI.QueryServerJoinedToRoom(httpReq.Context(), inRoomReq, inRoomRes); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryServerJoinedToRoom failed")
+		return jsonerror.InternalServerError()
+	}
+	if !inRoomRes.RoomExists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound(fmt.Sprintf("Room ID %q was not found on this server", roomID)),
+		}
+	}
+	if !inRoomRes.IsInRoom {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound(fmt.Sprintf("Room ID %q has no remaining users on this server", roomID)),
+		}
+	}
+
+	// Try building an event for the server
+	builder := gomatrixserverlib.EventBuilder{
+		Sender:   userID,
+		RoomID:   roomID,
+		Type:     gomatrixserverlib.MRoomMember,
+		StateKey: &userID,
+	}
+	err = builder.SetContent(map[string]interface{}{"membership": gomatrixserverlib.Knock})
+	if err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("builder.SetContent failed")
+		return jsonerror.InternalServerError()
+	}
+
+	queryRes := api.QueryLatestEventsAndStateResponse{
+		RoomVersion: verRes.RoomVersion,
+	}
+	event, err := eventutil.QueryAndBuildEvent(httpReq.Context(), &builder, cfg.Matrix, time.Now(), rsAPI, &queryRes)
+	if err == eventutil.ErrRoomNoExists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Room does not exist"),
+		}
+	} else if e, ok := err.(gomatrixserverlib.BadJSONError); ok {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON(e.Error()),
+		}
+	} else if err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("eventutil.BuildEvent failed")
+		return jsonerror.InternalServerError()
+	}
+
+	// Check that the knock is allowed or not, e.g. that the join rules
+	// permit knocking and that the user isn't banned.
+	stateEvents := make([]*gomatrixserverlib.Event, len(queryRes.StateEvents))
+	for i := range queryRes.StateEvents {
+		stateEvents[i] = queryRes.StateEvents[i].Event
+	}
+
+	provider := gomatrixserverlib.NewAuthEvents(stateEvents)
+	if err = gomatrixserverlib.Allowed(event.Event, &provider); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden(err.Error()),
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: federationAPI.RespMakeKnock{
+			KnockEvent:  builder,
+			RoomVersion: verRes.RoomVersion,
+		},
+	}
+}
+
+// SendKnock implements the /send_knock API
+func SendKnock(
+	httpReq *http.Request,
+	request *gomatrixserverlib.FederationRequest,
+	cfg *config.FederationAPI,
+	rsAPI api.RoomserverInternalAPI,
+	keys gomatrixserverlib.JSONVerifier,
+	roomID, eventID string,
+) util.JSONResponse {
+	verReq := api.QueryRoomVersionForRoomRequest{RoomID: roomID}
+	verRes := api.QueryRoomVersionForRoomResponse{}
+	if err := rsAPI.QueryRoomVersionForRoom(httpReq.Context(), &verReq, &verRes); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryRoomVersionForRoom failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: jsonerror.InternalServerError(),
+		}
+	}
+	if resErr := checkKnockingSupported(verRes.RoomVersion); resErr != nil {
+		return *resErr
+	}
+
+	event, err := gomatrixserverlib.NewEventFromUntrustedJSON(request.Content(), verRes.RoomVersion)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The request body could not be decoded into valid JSON: " + err.Error()),
+		}
+	}
+
+	// Check that a state key is provided and that it matches the sender.
+	if event.StateKey() == nil || event.StateKeyEquals("") {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("No state key was provided in the knock event."),
+		}
+	}
+	if !event.StateKeyEquals(event.Sender()) {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("Event state key must match the event sender."),
+		}
+	}
+
+	// Check that the room ID and event ID are correct.
+	if event.RoomID() != roomID {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON(
+				fmt.Sprintf(
+					"The room ID in the request path (%q) must match the room ID in the knock event JSON (%q)",
+					roomID, event.RoomID(),
+				),
+			),
+		}
+	}
+	if event.EventID() != eventID {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON(
+				fmt.Sprintf(
+					"The event ID in the request path (%q) must match the event ID in the knock event JSON (%q)",
+					eventID, event.EventID(),
+				),
+			),
+		}
+	}
+
+	// Check that the event is from the server sending the request.
+	if event.Origin() != request.Origin() {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("The knock must be sent by the server it originated on"),
+		}
+	}
+
+	// Check that this is in fact a knock event
+	membership, err := event.Membership()
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("missing content.membership key"),
+		}
+	}
+	if membership != gomatrixserverlib.Knock {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("membership must be 'knock'"),
+		}
+	}
+
+	// Check that the event is signed by the server sending the request.
+	redacted := event.Redact()
+	verifyRequests := []gomatrixserverlib.VerifyJSONRequest{{
+		ServerName:             event.Origin(),
+		Message:                redacted.JSON(),
+		AtTS:                   event.OriginServerTS(),
+		StrictValidityChecking: true,
+	}}
+	verifyResults, err := keys.VerifyJSONs(httpReq.Context(), verifyRequests)
+	if err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("keys.VerifyJSONs failed")
+		return jsonerror.InternalServerError()
+	}
+	if verifyResults[0].Error != nil {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Signature check failed: " + verifyResults[0].Error.Error()),
+		}
+	}
+
+	// Send the event to the room server. We are responsible for notifying
+	// other servers that the user has knocked, so set SendAsServer to
+	// cfg.Matrix.ServerName. The roomserver will check that the knock is
+	// allowed by the join rules.
+	var response api.InputRoomEventsResponse
+	rsAPI.InputRoomEvents(httpReq.Context(), &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{
+			{
+				Kind:          api.KindNew,
+				Event:         event.Headered(verRes.RoomVersion),
+				SendAsServer:  string(cfg.Matrix.ServerName),
+				TransactionID: nil,
+			},
+		},
+	}, &response)
+	if response.ErrMsg != "" {
+		util.GetLogger(httpReq.Context()).WithField(logrus.ErrorKey, response.ErrMsg).Error("SendEvents failed")
+		if response.NotAllowed {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden(response.ErrMsg),
+			}
+		}
+		return jsonerror.InternalServerError()
+	}
+
+	// Give the knocking server the stripped state of the room, so that
+	// their user can see what they've knocked on.
+	stateTuples := make([]gomatrixserverlib.StateKeyTuple, 0, len(knockRoomStateTypes))
+	for _, eventType := range knockRoomStateTypes {
+		stateTuples = append(stateTuples, gomatrixserverlib.StateKeyTuple{
+			EventType: eventType,
+			StateKey:  "",
+		})
+	}
+	var stateRes api.QueryCurrentStateResponse
+	err = rsAPI.QueryCurrentState(httpReq.Context(), &api.QueryCurrentStateRequest{
+		RoomID:      roomID,
+		StateTuples: stateTuples,
+	}, &stateRes)
+	if err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryCurrentState failed")
+		return jsonerror.InternalServerError()
+	}
+	knockRoomState := []gomatrixserverlib.InviteV2StrippedState{}
+	for _, ev := range stateRes.StateEvents {
+		knockRoomState = append(knockRoomState, gomatrixserverlib.NewInviteV2StrippedState(ev.Event))
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: federationAPI.RespSendKnock{
+			KnockRoomState: knockRoomState,
+		},
+	}
+}
+
+// checkKnockingSupported returns an error response if the room version
+// doesn't allow knocking.
+func checkKnockingSupported(roomVersion gomatrixserverlib.RoomVersion) *util.JSONResponse {
+	allowed, err := roomVersion.AllowKnockingInEventAuth()
+	if err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.UnsupportedRoomVersion(err.Error()),
+		}
+	}
+	if !allowed {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden(fmt.Sprintf("Room version %q does not support knocking", roomVersion)),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	federationAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const knockingServer = gomatrixserverlib.ServerName("remote")
+
+// knockRoomserverAPI answers queries about a single room that this server
+// is joined to, and checks the events given to it against the room's state.
+type knockRoomserverAPI struct {
+	api.RoomserverInternalAPI
+	room  *test.Room
+	input []*gomatrixserverlib.HeaderedEvent
+}
+
+func (a *knockRoomserverAPI) currentState() map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent {
+	state := map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{}
+	for _, ev := range a.room.Events() {
+		if ev.StateKey() != nil {
+			state[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+		}
+	}
+	return state
+}
+
+func (a *knockRoomserverAPI) QueryRoomVersionForRoom(
+	ctx context.Context, req *api.QueryRoomVersionForRoomRequest, res *api.QueryRoomVersionForRoomResponse,
+) error {
+	if req.RoomID != a.room.ID {
+		return fmt.Errorf("unknown room %s", req.RoomID)
+	}
+	res.RoomVersion = a.room.Version
+	return nil
+}
+
+func (a *knockRoomserverAPI) QueryServerJoinedToRoom(
+	ctx context.Context, req *api.QueryServerJoinedToRoomRequest, res *api.QueryServerJoinedToRoomResponse,
+) error {
+	res.RoomExists = req.RoomID == a.room.ID
+	res.IsInRoom = res.RoomExists
+	return nil
+}
+
+func (a *knockRoomserverAPI) QueryLatestEventsAndState(
+	ctx context.Context, req *api.QueryLatestEventsAndStateRequest, res *api.QueryLatestEventsAndStateResponse,
+) error {
+	events := a.room.Events()
+	last := events[len(events)-1]
+	res.RoomExists = true
+	res.RoomVersion = a.room.Version
+	res.Depth = last.Depth() + 1
+	res.LatestEvents = []gomatrixserverlib.EventReference{last.EventReference()}
+	for _, ev := range a.currentState() {
+		res.StateEvents = append(res.StateEvents, ev)
+	}
+	return nil
+}
+
+func (a *knockRoomserverAPI) InputRoomEvents(
+	ctx context.Context, req *api.InputRoomEventsRequest, res *api.InputRoomEventsResponse,
+) {
+	for _, input := range req.InputRoomEvents {
+		var stateEvents []*gomatrixserverlib.Event
+		for _, ev := range a.currentState() {
+			stateEvents = append(stateEvents, ev.Unwrap())
+		}
+		authEvents := gomatrixserverlib.NewAuthEvents(stateEvents)
+		if err := gomatrixserverlib.Allowed(input.Event.Unwrap(), &authEvents); err != nil {
+			res.ErrMsg, res.NotAllowed = err.Error(), true
+			return
+		}
+		a.input = append(a.input, input.Event)
+	}
+}
+
+func (a *knockRoomserverAPI) QueryCurrentState(
+	ctx context.Context, req *api.QueryCurrentStateRequest, res *api.QueryCurrentStateResponse,
+) error {
+	state := a.currentState()
+	res.StateEvents = map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{}
+	for _, tuple := range req.StateTuples {
+		if ev, ok := state[tuple]; ok {
+			res.StateEvents[tuple] = ev
+		}
+	}
+	return nil
+}
+
+// testKeyRing accepts the signatures of every server unless told otherwise.
+type testKeyRing struct {
+	err error
+}
+
+func (k *testKeyRing) VerifyJSONs(
+	ctx context.Context, requests []gomatrixserverlib.VerifyJSONRequest,
+) ([]gomatrixserverlib.VerifyJSONResult, error) {
+	results := make([]gomatrixserverlib.VerifyJSONResult, len(requests))
+	for i := range results {
+		results[i].Error = k.err
+	}
+	return results, nil
+}
+
+func mustFederationRequest(t *testing.T, origin gomatrixserverlib.ServerName, content interface{}) *gomatrixserverlib.FederationRequest {
+	t.Helper()
+	req := gomatrixserverlib.NewFederationRequest(http.MethodPut, testDestination, "/")
+	if content != nil {
+		if err := req.SetContent(content); err != nil {
+			t.Fatalf("failed to set content: %s", err)
+		}
+	}
+	if err := req.Sign(origin, test.KeyID, test.PrivateKey); err != nil {
+		t.Fatalf("failed to sign request: %s", err)
+	}
+	return &req
+}
+
+func knockTestConfig() *config.FederationAPI {
+	return &config.FederationAPI{
+		Matrix: &config.Global{
+			ServerName: testDestination,
+			KeyID:      test.KeyID,
+			PrivateKey: test.PrivateKey,
+		},
+	}
+}
+
+func TestMakeKnock(t *testing.T) {
+	alice, bob := "@alice:"+string(testDestination), "@bob:"+string(knockingServer)
+	knockRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+	knockRoom.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+	publicRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+	oldRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV6)
+	oldRoom.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+	allVersions := []gomatrixserverlib.RoomVersion{gomatrixserverlib.RoomVersionV6, gomatrixserverlib.RoomVersionV7}
+
+	testCases := []struct {
+		name           string
+		room           *test.Room
+		userID         string
+		remoteVersions []gomatrixserverlib.RoomVersion
+		wantCode       int
+	}{
+		{name: "knock", room: knockRoom, userID: bob, remoteVersions: allVersions, wantCode: http.StatusOK},
+		{name: "version not supported by the remote server", room: knockRoom, userID: bob, remoteVersions: allVersions[:1], wantCode: http.StatusBadRequest},
+		{name: "user from another server", room: knockRoom, userID: "@bob:elsewhere", remoteVersions: allVersions, wantCode: http.StatusForbidden},
+		{name: "public room", room: publicRoom, userID: bob, remoteVersions: allVersions, wantCode: http.StatusForbidden},
+		{name: "room version without knocking", room: oldRoom, userID: bob, remoteVersions: allVersions, wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rsAPI := &knockRoomserverAPI{room: tc.room}
+			res := MakeKnock(
+				httptest.NewRequest(http.MethodGet, "/", nil), mustFederationRequest(t, knockingServer, nil),
+				knockTestConfig(), rsAPI, tc.room.ID, tc.userID, tc.remoteVersions,
+			)
+			if res.Code != tc.wantCode {
+				t.Fatalf("got status %d (%+v), want %d", res.Code, res.JSON, tc.wantCode)
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			template := res.JSON.(federationAPI.RespMakeKnock)
+			if template.RoomVersion != gomatrixserverlib.RoomVersionV7 {
+				t.Errorf("got room version %s, want %s", template.RoomVersion, gomatrixserverlib.RoomVersionV7)
+			}
+			if template.KnockEvent.Sender != tc.userID || template.KnockEvent.StateKey == nil || *template.KnockEvent.StateKey != tc.userID {
+				t.Errorf("got knock template for %s, want %s", template.KnockEvent.Sender, tc.userID)
+			}
+			if got := string(template.KnockEvent.Content); got != `{"membership":"knock"}` {
+				t.Errorf("got knock template content %s", got)
+			}
+		})
+	}
+}
+
+func TestSendKnock(t *testing.T) {
+	alice, bob := "@alice:"+string(testDestination), "@bob:"+string(knockingServer)
+	room := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomName, map[string]interface{}{"name": "Knock knock"}, "")
+	knock := room.CreateEvent(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": gomatrixserverlib.Knock}, bob)
+
+	testCases := []struct {
+		name      string
+		origin    gomatrixserverlib.ServerName
+		eventID   string
+		keyErr    error
+		wantCode  int
+		wantInput bool
+	}{
+		{name: "knock", origin: knockingServer, eventID: knock.EventID(), wantCode: http.StatusOK, wantInput: true},
+		{name: "event ID mismatch", origin: knockingServer, eventID: "$other", wantCode: http.StatusBadRequest},
+		{name: "sent by another server", origin: "elsewhere", eventID: knock.EventID(), wantCode: http.StatusForbidden},
+		{name: "bad signature", origin: knockingServer, eventID: knock.EventID(), keyErr: errors.New("bad signature"), wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rsAPI := &knockRoomserverAPI{room: room}
+			res := SendKnock(
+				httptest.NewRequest(http.MethodPut, "/", nil), mustFederationRequest(t, tc.origin, knock.Unwrap()),
+				knockTestConfig(), rsAPI, &testKeyRing{err: tc.keyErr}, room.ID, tc.eventID,
+			)
+			if res.Code != tc.wantCode {
+				t.Fatalf("got status %d (%+v), want %d", res.Code, res.JSON, tc.wantCode)
+			}
+			if gotInput := len(rsAPI.input) == 1 && rsAPI.input[0].EventID() == knock.EventID(); gotInput != tc.wantInput {
+				t.Errorf("got knock sent to the roomserver %v, want %v", gotInput, tc.wantInput)
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			evTypes := map[string]bool{}
+			for _, ev := range res.JSON.(federationAPI.RespSendKnock).KnockRoomState {
+				evTypes[ev.Type()] = true
+			}
+			for _, evType := range []string{gomatrixserverlib.MRoomCreate, gomatrixserverlib.MRoomJoinRules, gomatrixserverlib.MRoomName} {
+				if !evTypes[evType] {
+					t.Errorf("knock room state is missing %s", evType)
+				}
+			}
+		})
+	}
+
+	t.Run("room no longer allows knocking", func(t *testing.T) {
+		closedRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+		closedRoom.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+		closedKnock := closedRoom.CreateEvent(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": gomatrixserverlib.Knock}, bob)
+		closedRoom.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Invite}, "")
+
+		rsAPI := &knockRoomserverAPI{room: closedRoom}
+		res := SendKnock(
+			httptest.NewRequest(http.MethodPut, "/", nil), mustFederationRequest(t, knockingServer, closedKnock.Unwrap()),
+			knockTestConfig(), rsAPI, &testKeyRing{}, closedRoom.ID, closedKnock.EventID(),
+		)
+		if res.Code != http.StatusForbidden {
+			t.Errorf("got status %d (%+v), want %d", res.Code, res.JSON, http.StatusForbidden)
+		}
+		if len(rsAPI.input) != 0 {
+			t.Errorf("refused knock was sent to the roomserver")
+		}
+	})
+}
@@ -133,6 +133,9 @@ func Send(
 		servers:    servers,
 		keyAPI:     keyAPI,
 		roomsMu:    mu,
+		domains:    &cfg.FederationDomains,
+
+		inboundPresence: cfg.Matrix.Presence.EnableInbound,
 	}
 
 	var txnEvents struct {
@@ -191,6 +194,10 @@ type txnReq struct {
 	federation txnFederationClient
 	roomsMu    *internal.MutexByRoom
 	servers    federationAPI.ServersInRoomProvider
+	// The servers that we are allowed to federate with
+	domains *config.FederationDomains
+	// Whether presence EDUs from other servers are accepted
+	inboundPresence bool
 }
 
 // A subset of FederationClient functionality that txn requires. Useful for testing.
@@ -261,6 +268,12 @@ func (t *txnReq) processTransaction(ctx context.Context) (*gomatrixserverlib.Res
 		if event.Type() == gomatrixserverlib.MRoomCreate && event.StateKeyEquals("") {
 			continue
 		}
+		if !t.isEventOriginAllowed(event) {
+			results[event.EventID()] = gomatrixserverlib.PDUResult{
+				Error: "Forbidden by the federation domain lists",
+			}
+			continue
+		}
 		if api.IsServerBannedFromRoom(ctx, t.rsAPI, event.RoomID(), t.Origin) {
 			results[event.EventID()] = gomatrixserverlib.PDUResult{
 				Error: "Forbidden by server ACLs",
@@ -305,6 +318,17 @@ func (t *txnReq) processTransaction(ctx context.Context) (*gomatrixserverlib.Res
 	return &gomatrixserverlib.RespSend{PDUs: results}, nil
 }
 
+// isEventOriginAllowed returns whether we may federate with the server that
+// an event came from. Transactions can relay events from other servers, so
+// this is checked for each event as well as for the origin of the transaction.
+func (t *txnReq) isEventOriginAllowed(event *gomatrixserverlib.Event) bool {
+	if origin := event.Origin(); origin != "" && !t.domains.IsAllowed(origin) {
+		return false
+	}
+	_, senderDomain, err := gomatrixserverlib.SplitID('@', event.Sender())
+	return err == nil && t.domains.IsAllowed(senderDomain)
+}
+
 func (t *txnReq) processEDUs(ctx context.Context) {
 	for _, e := range t.EDUs {
 		eduCountTotal.Inc()
@@ -388,6 +412,13 @@ func (t *txnReq) processEDUs(ctx context.Context) {
 			if err := t.processSigningKeyUpdate(ctx, e); err != nil {
 				logrus.WithError(err).Errorf("Failed to process signing key update")
 			}
+		case eduserverAPI.MPresence:
+			if !t.inboundPresence {
+				continue
+			}
+			if err := t.processPresence(ctx, e); err != nil {
+				util.GetLogger(ctx).WithError(err).Error("Failed to process presence update")
+			}
 		default:
 			util.GetLogger(ctx).WithField("type", e.Type).Debug("Unhandled EDU")
 		}
@@ -448,6 +479,37 @@ func (t *txnReq) processReceiptEvent(ctx context.Context,
 	return nil
 }
 
+func (t *txnReq) processPresence(ctx context.Context, e gomatrixserverlib.EDU) error {
+	// https://matrix.org/docs/spec/server_server/r0.1.4#presence
+	var payload eduserverAPI.FederationPresenceData
+	if err := json.Unmarshal(e.Content, &payload); err != nil {
+		return fmt.Errorf("unable to unmarshal presence update: %w", err)
+	}
+	now := time.Now()
+	for _, update := range payload.Push {
+		_, domain, err := gomatrixserverlib.SplitID('@', update.UserID)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Debug("Failed to split domain from presence update user")
+			continue
+		}
+		if t.Origin != domain {
+			util.GetLogger(ctx).Debugf("Dropping presence update where user domain (%q) doesn't match origin (%q)", domain, t.Origin)
+			continue
+		}
+		if !update.Presence.IsValid() {
+			util.GetLogger(ctx).Debugf("Dropping presence update with invalid presence %q", update.Presence)
+			continue
+		}
+		lastActiveTS := gomatrixserverlib.AsTimestamp(now.Add(-time.Duration(update.LastActiveAgo) * time.Millisecond))
+		if err = eduserverAPI.SendPresence(
+			ctx, t.eduAPI, update.UserID, update.Presence, update.StatusMsg, lastActiveTS,
+		); err != nil {
+			return fmt.Errorf("unable to set presence: %w", err)
+		}
+	}
+	return nil
+}
+
 func (t *txnReq) processDeviceListUpdate(ctx context.Context, e gomatrixserverlib.EDU) {
 	var payload gomatrixserverlib.DeviceListUpdateEvent
 	if err := json.Unmarshal(e.Content, &payload); err != nil {
@@ -11,6 +11,7 @@ import (
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/test"
 	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
 )
 
@@ -83,6 +84,14 @@ func (o *testEDUProducer) InputReceiptEvent(
 	return nil
 }
 
+func (o *testEDUProducer) InputPresenceEvent(
+	ctx context.Context,
+	request *eduAPI.InputPresenceEventRequest,
+	response *eduAPI.InputPresenceEventResponse,
+) error {
+	return nil
+}
+
 func (o *testEDUProducer) InputCrossSigningKeyUpdate(
 	ctx context.Context,
 	request *eduAPI.InputCrossSigningKeyUpdateRequest,
@@ -229,6 +238,7 @@ func mustCreateTransaction(rsAPI api.RoomserverInternalAPI, fedClient txnFederat
 		keys:       &test.NopJSONVerifier{},
 		federation: fedClient,
 		roomsMu:    internal.NewMutexByRoom(),
+		domains:    &config.FederationDomains{},
 	}
 	t.PDUs = pdus
 	t.Origin = testOrigin
@@ -319,6 +329,24 @@ func TestTransactionFailAuthChecks(t *testing.T) {
 	assertInputRoomEvents(t, rsAPI.inputRoomEvents, []*gomatrixserverlib.HeaderedEvent{testEvents[len(testEvents)-1]})
 }
 
+// The purpose of this test is to check that events from servers that we aren't allowed to federate with are rejected,
+// even when they are relayed by a server that we are allowed to federate with.
+func TestTransactionDeniedEventOrigin(t *testing.T) {
+	rsAPI := &testRoomserverAPI{}
+	ev := testEvents[len(testEvents)-1]
+	txn := mustCreateTransaction(rsAPI, &txnFedClient{}, []json.RawMessage{testData[len(testData)-1]})
+	txn.Origin = "relay.org"
+	txn.domains = &config.FederationDomains{Deny: []string{string(testOrigin)}}
+	res, err := txn.processTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("txn.processTransaction returned an error: %v", err)
+	}
+	if res.PDUs[ev.EventID()].Error == "" {
+		t.Errorf("event from a denied server was accepted")
+	}
+	assertInputRoomEvents(t, rsAPI.inputRoomEvents, nil)
+}
+
 // The purpose of this test is to make sure that when an event is received for which we do not know the prev_events,
 // we request them from /get_missing_events. It works by setting PrevEventsExist=false in the roomserver query response,
 // resulting in a call to /get_missing_events which returns the missing prev event. Both events should be processed in
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/matrix-org/dendrite/federationapi/storage"
+	"github.com/matrix-org/dendrite/federationapi/types"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/atomic"
@@ -48,16 +49,33 @@ func (s *Statistics) ForServer(serverName gomatrixserverlib.ServerName) *ServerS
 		}
 		s.servers[serverName] = server
 		s.mutex.Unlock()
-		blacklisted, err := s.DB.IsServerBlacklisted(serverName)
+		entry, err := s.DB.GetBlacklistEntry(serverName)
 		if err != nil {
 			logrus.WithError(err).Errorf("Failed to get blacklist entry %q", serverName)
-		} else {
-			server.blacklisted.Store(blacklisted)
+		} else if entry != nil {
+			server.blacklisted.Store(true)
+			server.manual.Store(entry.Manual)
+			server.backoffCount.Store(entry.FailureCount)
 		}
 	}
 	return server
 }
 
+// BackingOff returns the statistics of all servers that have failed since
+// their last success, including blacklisted servers that we have tried to
+// talk to since startup.
+func (s *Statistics) BackingOff() []*ServerStatistics {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var servers []*ServerStatistics
+	for _, server := range s.servers {
+		if server.blacklisted.Load() || server.backoffCount.Load() > 0 {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
 // ServerStatistics contains information about our interactions with a
 // remote federated host, e.g. how many times we were successful, how
 // many times we failed etc. It also manages the backoff time and black-
@@ -66,6 +84,7 @@ type ServerStatistics struct {
 	statistics     *Statistics                  //
 	serverName     gomatrixserverlib.ServerName //
 	blacklisted    atomic.Bool                  // is the node blacklisted
+	manual         atomic.Bool                  // was the node blacklisted by an admin
 	backoffStarted atomic.Bool                  // is the backoff started
 	backoffUntil   atomic.Value                 // time.Time until this backoff interval ends
 	backoffCount   atomic.Uint32                // number of times BackoffDuration has been called
@@ -93,8 +112,12 @@ func (s *ServerStatistics) cancel() {
 // failure counters. If a host was blacklisted at this point then
 // we will unblacklist it.
 func (s *ServerStatistics) Success() {
-	s.cancel()
 	s.successCounter.Inc()
+	if s.manual.Load() {
+		// Only an admin can lift a blacklist that an admin put in place.
+		return
+	}
+	s.cancel()
 	s.backoffCount.Store(0)
 	if s.statistics.DB != nil {
 		if err := s.statistics.DB.RemoveServerFromBlacklist(s.serverName); err != nil {
@@ -114,10 +137,14 @@ func (s *ServerStatistics) Failure() (time.Time, bool) {
 	// start a goroutine which will wait out the backoff and
 	// unset the backoffStarted flag when done.
 	if s.backoffStarted.CAS(false, true) {
-		if s.backoffCount.Inc() >= s.statistics.FailuresUntilBlacklist {
+		if count := s.backoffCount.Inc(); count >= s.statistics.FailuresUntilBlacklist {
 			s.blacklisted.Store(true)
 			if s.statistics.DB != nil {
-				if err := s.statistics.DB.AddServerToBlacklist(s.serverName); err != nil {
+				if err := s.statistics.DB.AddServerToBlacklist(&types.BlacklistEntry{
+					ServerName:    s.serverName,
+					FailureCount:  count,
+					BlacklistedAt: gomatrixserverlib.AsTimestamp(time.Now()),
+				}); err != nil {
 					logrus.WithError(err).Errorf("Failed to add %q to blacklist", s.serverName)
 				}
 			}
@@ -167,6 +194,52 @@ func (s *ServerStatistics) BackoffInfo() (*time.Time, bool) {
 	return nil, s.blacklisted.Load()
 }
 
+// Blacklist blocks all traffic to the server until it is unblacklisted,
+// regardless of whether requests to it would succeed.
+func (s *ServerStatistics) Blacklist() error {
+	s.blacklisted.Store(true)
+	s.manual.Store(true)
+	if s.statistics.DB == nil {
+		return nil
+	}
+	return s.statistics.DB.AddServerToBlacklist(&types.BlacklistEntry{
+		ServerName:    s.serverName,
+		FailureCount:  s.backoffCount.Load(),
+		BlacklistedAt: gomatrixserverlib.AsTimestamp(time.Now()),
+		Manual:        true,
+	})
+}
+
+// Unblacklist lifts the blacklist on the server, however it was put in
+// place, and resets the backoff so that the next request goes straight
+// through.
+func (s *ServerStatistics) Unblacklist() error {
+	s.manual.Store(false)
+	s.cancel()
+	s.backoffCount.Store(0)
+	// Blacklisting doesn't start a backoff goroutine to clear this for us.
+	s.backoffStarted.Store(false)
+	if s.statistics.DB == nil {
+		return nil
+	}
+	return s.statistics.DB.RemoveServerFromBlacklist(s.serverName)
+}
+
+// ServerName returns the name of the server that these statistics are for.
+func (s *ServerStatistics) ServerName() gomatrixserverlib.ServerName {
+	return s.serverName
+}
+
+// FailureCount returns the number of consecutive failures.
+func (s *ServerStatistics) FailureCount() uint32 {
+	return s.backoffCount.Load()
+}
+
+// Manual returns true if the server was blacklisted by an admin.
+func (s *ServerStatistics) Manual() bool {
+	return s.manual.Load()
+}
+
 // Blacklisted returns true if the server is blacklisted and false
 // otherwise.
 func (s *ServerStatistics) Blacklisted() bool {
@@ -62,3 +62,41 @@ func TestBackoff(t *testing.T) {
 		}
 	}
 }
+
+func TestManualBlacklist(t *testing.T) {
+	stats := Statistics{
+		FailuresUntilBlacklist: 7,
+	}
+	server := ServerStatistics{
+		statistics: &stats,
+		serverName: "test.com",
+		interrupt:  make(chan struct{}),
+	}
+
+	// A blocked server should stay blocked even if a request succeeds.
+	if err := server.Blacklist(); err != nil {
+		t.Fatalf("Blacklist failed: %s", err)
+	}
+	server.Success()
+	if !server.Blacklisted() || !server.Manual() {
+		t.Fatalf("Success lifted a manual blacklist")
+	}
+
+	// Unblacklisting should clear the blacklist and the backoff, so that
+	// failures are counted from scratch again.
+	server.Failure()
+	server.Failure()
+	if err := server.Unblacklist(); err != nil {
+		t.Fatalf("Unblacklist failed: %s", err)
+	}
+	if server.Blacklisted() || server.Manual() {
+		t.Fatalf("Unblacklist didn't lift the blacklist")
+	}
+	if until, _ := server.BackoffInfo(); until != nil && time.Now().Before(*until) {
+		t.Fatalf("Unblacklist didn't reset the backoff")
+	}
+	server.Failure()
+	if count := server.FailureCount(); count != 1 {
+		t.Fatalf("Expected failure count 1 after unblacklisting, got %d", count)
+	}
+}
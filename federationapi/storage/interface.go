@@ -51,10 +51,12 @@ type Database interface {
 	GetPendingEDUServerNames(ctx context.Context) ([]gomatrixserverlib.ServerName, error)
 
 	// these don't have contexts passed in as we want things to happen regardless of the request context
-	AddServerToBlacklist(serverName gomatrixserverlib.ServerName) error
+	AddServerToBlacklist(entry *types.BlacklistEntry) error
 	RemoveServerFromBlacklist(serverName gomatrixserverlib.ServerName) error
 	RemoveAllServersFromBlacklist() error
-	IsServerBlacklisted(serverName gomatrixserverlib.ServerName) (bool, error)
+	// GetBlacklistEntry returns nil if the server isn't blacklisted.
+	GetBlacklistEntry(serverName gomatrixserverlib.ServerName) (*types.BlacklistEntry, error)
+	GetAllBlacklistEntries() ([]types.BlacklistEntry, error)
 
 	AddOutboundPeek(ctx context.Context, serverName gomatrixserverlib.ServerName, roomID, peekID string, renewalInterval int64) error
 	RenewOutboundPeek(ctx context.Context, serverName gomatrixserverlib.ServerName, roomID, peekID string, renewalInterval int64) error
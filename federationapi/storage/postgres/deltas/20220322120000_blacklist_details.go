@@ -0,0 +1,49 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadBlacklistDetails(m *sqlutil.Migrations) {
+	m.AddMigration(UpBlacklistDetails, DownBlacklistDetails)
+}
+
+// UpBlacklistDetails records why and when servers were blacklisted.
+func UpBlacklistDetails(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE federationsender_blacklist ADD COLUMN IF NOT EXISTS failure_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE federationsender_blacklist ADD COLUMN IF NOT EXISTS blacklisted_ts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE federationsender_blacklist ADD COLUMN IF NOT EXISTS manual BOOLEAN NOT NULL DEFAULT FALSE;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownBlacklistDetails(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE federationsender_blacklist DROP COLUMN failure_count;
+ALTER TABLE federationsender_blacklist DROP COLUMN blacklisted_ts;
+ALTER TABLE federationsender_blacklist DROP COLUMN manual;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
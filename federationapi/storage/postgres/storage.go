@@ -85,9 +85,13 @@ func NewDatabase(dbProperties *config.DatabaseOptions, cache caching.FederationC
 	}
 	m := sqlutil.NewMigrations()
 	deltas.LoadRemoveRoomsTable(m)
+	deltas.LoadBlacklistDetails(m)
 	if err = m.RunDeltas(d.db, dbProperties); err != nil {
 		return nil, err
 	}
+	if err = blacklist.Prepare(); err != nil {
+		return nil, err
+	}
 	d.Database = shared.Database{
 		DB:                       d.db,
 		ServerName:               serverName,
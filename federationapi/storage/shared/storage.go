@@ -152,9 +152,9 @@ func (d *Database) PurgeRoomState(
 	})
 }
 
-func (d *Database) AddServerToBlacklist(serverName gomatrixserverlib.ServerName) error {
+func (d *Database) AddServerToBlacklist(entry *types.BlacklistEntry) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.FederationBlacklist.InsertBlacklist(context.TODO(), txn, serverName)
+		return d.FederationBlacklist.InsertBlacklist(context.TODO(), txn, entry)
 	})
 }
 
@@ -170,10 +170,14 @@ func (d *Database) RemoveAllServersFromBlacklist() error {
 	})
 }
 
-func (d *Database) IsServerBlacklisted(serverName gomatrixserverlib.ServerName) (bool, error) {
+func (d *Database) GetBlacklistEntry(serverName gomatrixserverlib.ServerName) (*types.BlacklistEntry, error) {
 	return d.FederationBlacklist.SelectBlacklist(context.TODO(), nil, serverName)
 }
 
+func (d *Database) GetAllBlacklistEntries() ([]types.BlacklistEntry, error) {
+	return d.FederationBlacklist.SelectAllBlacklist(context.TODO(), nil)
+}
+
 func (d *Database) AddOutboundPeek(ctx context.Context, serverName gomatrixserverlib.ServerName, roomID, peekID string, renewalInterval int64) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		return d.FederationOutboundPeeks.InsertOutboundPeek(ctx, txn, serverName, roomID, peekID, renewalInterval)
@@ -18,6 +18,8 @@ import (
 	"context"
 	"database/sql"
 
+	"github.com/matrix-org/dendrite/federationapi/types"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/gomatrixserverlib"
 )
@@ -26,16 +28,27 @@ const blacklistSchema = `
 CREATE TABLE IF NOT EXISTS federationsender_blacklist (
     -- The blacklisted server name
 	server_name TEXT NOT NULL,
+	-- How many consecutive failures led to the server being blacklisted
+	failure_count BIGINT NOT NULL DEFAULT 0,
+	-- When the server was blacklisted, in milliseconds
+	blacklisted_ts BIGINT NOT NULL DEFAULT 0,
+	-- Whether an admin blocked the server, in which case it is never
+	-- lifted automatically
+	manual BOOLEAN NOT NULL DEFAULT FALSE,
 	UNIQUE (server_name)
 );
 `
 
 const insertBlacklistSQL = "" +
-	"INSERT INTO federationsender_blacklist (server_name) VALUES ($1)" +
-	" ON CONFLICT DO NOTHING"
+	"INSERT INTO federationsender_blacklist (server_name, failure_count, blacklisted_ts, manual)" +
+	" VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (server_name) DO UPDATE SET failure_count = $2, blacklisted_ts = $3, manual = $4"
 
 const selectBlacklistSQL = "" +
-	"SELECT server_name FROM federationsender_blacklist WHERE server_name = $1"
+	"SELECT server_name, failure_count, blacklisted_ts, manual FROM federationsender_blacklist WHERE server_name = $1"
+
+const selectAllBlacklistSQL = "" +
+	"SELECT server_name, failure_count, blacklisted_ts, manual FROM federationsender_blacklist ORDER BY server_name"
 
 const deleteBlacklistSQL = "" +
 	"DELETE FROM federationsender_blacklist WHERE server_name = $1"
@@ -47,6 +60,7 @@ type blacklistStatements struct {
 	db                     *sql.DB
 	insertBlacklistStmt    *sql.Stmt
 	selectBlacklistStmt    *sql.Stmt
+	selectAllBlacklistStmt *sql.Stmt
 	deleteBlacklistStmt    *sql.Stmt
 	deleteAllBlacklistStmt *sql.Stmt
 }
@@ -56,46 +70,77 @@ func NewSQLiteBlacklistTable(db *sql.DB) (s *blacklistStatements, err error) {
 		db: db,
 	}
 	_, err = db.Exec(blacklistSchema)
-	if err != nil {
+	return
+}
+
+// Prepare prepares the statements. It must be called after the deltas have
+// been run, as the statements refer to columns added by them.
+func (s *blacklistStatements) Prepare() (err error) {
+	if s.insertBlacklistStmt, err = s.db.Prepare(insertBlacklistSQL); err != nil {
 		return
 	}
-
-	if s.insertBlacklistStmt, err = db.Prepare(insertBlacklistSQL); err != nil {
+	if s.selectBlacklistStmt, err = s.db.Prepare(selectBlacklistSQL); err != nil {
 		return
 	}
-	if s.selectBlacklistStmt, err = db.Prepare(selectBlacklistSQL); err != nil {
+	if s.selectAllBlacklistStmt, err = s.db.Prepare(selectAllBlacklistSQL); err != nil {
 		return
 	}
-	if s.deleteBlacklistStmt, err = db.Prepare(deleteBlacklistSQL); err != nil {
+	if s.deleteBlacklistStmt, err = s.db.Prepare(deleteBlacklistSQL); err != nil {
 		return
 	}
-	if s.deleteAllBlacklistStmt, err = db.Prepare(deleteAllBlacklistSQL); err != nil {
+	if s.deleteAllBlacklistStmt, err = s.db.Prepare(deleteAllBlacklistSQL); err != nil {
 		return
 	}
 	return
 }
 
 func (s *blacklistStatements) InsertBlacklist(
-	ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName,
+	ctx context.Context, txn *sql.Tx, entry *types.BlacklistEntry,
 ) error {
 	stmt := sqlutil.TxStmt(txn, s.insertBlacklistStmt)
-	_, err := stmt.ExecContext(ctx, serverName)
+	_, err := stmt.ExecContext(ctx, entry.ServerName, entry.FailureCount, entry.BlacklistedAt, entry.Manual)
 	return err
 }
 
+// SelectBlacklist returns the blacklist entry for the server, or nil if the
+// server isn't blacklisted.
 func (s *blacklistStatements) SelectBlacklist(
 	ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName,
-) (bool, error) {
+) (*types.BlacklistEntry, error) {
 	stmt := sqlutil.TxStmt(txn, s.selectBlacklistStmt)
-	res, err := stmt.QueryContext(ctx, serverName)
+	var entry types.BlacklistEntry
+	err := stmt.QueryRowContext(ctx, serverName).Scan(
+		&entry.ServerName, &entry.FailureCount, &entry.BlacklistedAt, &entry.Manual,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *blacklistStatements) SelectAllBlacklist(
+	ctx context.Context, txn *sql.Tx,
+) ([]types.BlacklistEntry, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectAllBlacklistStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAllBlacklist: rows.close() failed")
+	var entries []types.BlacklistEntry
+	for rows.Next() {
+		var entry types.BlacklistEntry
+		if err = rows.Scan(
+			&entry.ServerName, &entry.FailureCount, &entry.BlacklistedAt, &entry.Manual,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
-	defer res.Close() // nolint:errcheck
-	// The query will return the server name if the server is blacklisted, and
-	// will return no rows if not. By calling Next, we find out if a row was
-	// returned or not - we don't care about the value itself.
-	return res.Next(), nil
+	return entries, rows.Err()
 }
 
 func (s *blacklistStatements) DeleteBlacklist(
@@ -59,8 +59,9 @@ type FederationJoinedHosts interface {
 }
 
 type FederationBlacklist interface {
-	InsertBlacklist(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName) error
-	SelectBlacklist(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName) (bool, error)
+	InsertBlacklist(ctx context.Context, txn *sql.Tx, entry *types.BlacklistEntry) error
+	SelectBlacklist(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName) (*types.BlacklistEntry, error)
+	SelectAllBlacklist(ctx context.Context, txn *sql.Tx) ([]types.BlacklistEntry, error)
 	DeleteBlacklist(ctx context.Context, txn *sql.Tx, serverName gomatrixserverlib.ServerName) error
 	DeleteAllBlacklist(ctx context.Context, txn *sql.Tx) error
 }
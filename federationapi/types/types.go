@@ -51,3 +51,17 @@ type InboundPeek struct {
 	RenewedTimestamp  int64
 	RenewalInterval   int64
 }
+
+// A BlacklistEntry is a server that we won't send federation traffic to,
+// either because it failed too many times in a row or because an admin
+// blocked it.
+type BlacklistEntry struct {
+	ServerName gomatrixserverlib.ServerName `json:"server_name"`
+	// How many consecutive failures led to the server being blacklisted.
+	FailureCount uint32 `json:"failure_count"`
+	// When the server was blacklisted.
+	BlacklistedAt gomatrixserverlib.Timestamp `json:"blacklisted_ts"`
+	// Whether the server was blocked by an admin rather than for failing.
+	// These entries are never lifted automatically.
+	Manual bool `json:"manual"`
+}
@@ -0,0 +1,60 @@
+package caching
+
+import (
+	"strings"
+
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// The lazy-loading cache is mutable because the membership event that was
+// last sent to a device for a given member changes whenever that member's
+// membership changes.
+
+const (
+	LazyLoadCacheName       = "lazy_load_members"
+	LazyLoadCacheMaxEntries = 65536
+	LazyLoadCacheMutable    = true
+)
+
+// LazyLoadCache remembers which membership events have already been sent to
+// a device when lazy-loading room members, so that they aren't sent again
+// unless the client asks for redundant members. It is owned by the sync API.
+type LazyLoadCache struct {
+	members Cache
+}
+
+// NewLazyLoadCache creates a new in-memory LRU lazy-loading cache.
+func NewLazyLoadCache(enablePrometheus bool) (*LazyLoadCache, error) {
+	members, err := NewInMemoryLRUCachePartition(
+		LazyLoadCacheName,
+		LazyLoadCacheMutable,
+		LazyLoadCacheMaxEntries,
+		enablePrometheus,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &LazyLoadCache{members: members}, nil
+}
+
+func lazyLoadCacheKey(device *userapi.Device, roomID, userID string) string {
+	return strings.Join([]string{device.UserID, device.ID, roomID, userID}, "\x1f")
+}
+
+// StoreLazyLoadedUser remembers that the membership event with the given
+// event ID was sent to the device for the user in the room.
+func (c *LazyLoadCache) StoreLazyLoadedUser(device *userapi.Device, roomID, userID, eventID string) {
+	c.members.Set(lazyLoadCacheKey(device, roomID, userID), eventID)
+}
+
+// IsLazyLoadedUserCached returns the event ID of the membership event that
+// was last sent to the device for the user in the room, if any.
+func (c *LazyLoadCache) IsLazyLoadedUserCached(device *userapi.Device, roomID, userID string) (string, bool) {
+	val, found := c.members.Get(lazyLoadCacheKey(device, roomID, userID))
+	if found && val != nil {
+		if eventID, ok := val.(string); ok {
+			return eventID, true
+		}
+	}
+	return "", false
+}
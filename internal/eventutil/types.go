@@ -30,6 +30,14 @@ type AccountData struct {
 	Type   string `json:"type"`
 }
 
+// NotificationData contains the unread notification counts of a user in
+// a room, sent from the user API server to the sync API server
+type NotificationData struct {
+	RoomID                  string `json:"room_id"`
+	UnreadHighlightCount    int    `json:"unread_highlight_count"`
+	UnreadNotificationCount int    `json:"unread_notification_count"`
+}
+
 // ProfileResponse is a struct containing all known user profile data
 type ProfileResponse struct {
 	AvatarURL   string `json:"avatar_url"`
@@ -29,7 +29,9 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/gorilla/mux"
 	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	federationapiAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
@@ -47,11 +49,32 @@ type BasicAuth struct {
 	Password string `yaml:"password"`
 }
 
+// AuthAPIOption is an option for MakeAuthAPI.
+type AuthAPIOption func(opts *authAPIOptions)
+
+type authAPIOptions struct {
+	guestAccessAllowed bool
+}
+
+// WithAllowGuests lets guest accounts use the API, which they otherwise
+// can't. Only the APIs that the spec allows guests to use should do so.
+// https://spec.matrix.org/v1.2/client-server-api/#client-behaviour-14
+func WithAllowGuests() AuthAPIOption {
+	return func(opts *authAPIOptions) {
+		opts.guestAccessAllowed = true
+	}
+}
+
 // MakeAuthAPI turns a util.JSONRequestHandler function into an http.Handler which authenticates the request.
 func MakeAuthAPI(
 	metricsName string, userAPI userapi.UserInternalAPI,
 	f func(*http.Request, *userapi.Device) util.JSONResponse,
+	checks ...AuthAPIOption,
 ) http.Handler {
+	var options authAPIOptions
+	for _, opt := range checks {
+		opt(&options)
+	}
 	h := func(req *http.Request) util.JSONResponse {
 		logger := util.GetLogger(req.Context())
 		device, err := auth.VerifyUserFromRequest(req, userAPI)
@@ -59,6 +82,12 @@ func MakeAuthAPI(
 			logger.Debugf("VerifyUserFromRequest %s -> HTTP %d", req.RemoteAddr, err.Code)
 			return *err
 		}
+		if device.AccountType == userapi.AccountTypeGuest && !options.guestAccessAllowed {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.GuestAccessForbidden("Guest access not allowed"),
+			}
+		}
 		// add the user ID to the logger
 		logger = logger.WithField("user_id", device.UserID)
 		req = req.WithContext(util.ContextWithLogger(req.Context(), logger))
@@ -89,6 +118,23 @@ func MakeAuthAPI(
 	return MakeExternalAPI(metricsName, h)
 }
 
+// MakeAdminAPI is a wrapper around MakeAuthAPI which only lets the request
+// through if the device belongs to a server admin.
+func MakeAdminAPI(
+	metricsName string, userAPI userapi.UserInternalAPI,
+	f func(*http.Request, *userapi.Device) util.JSONResponse,
+) http.Handler {
+	return MakeAuthAPI(metricsName, userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		if device.AccountType != userapi.AccountTypeAdmin {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden("This API can only be used by server admins"),
+			}
+		}
+		return f(req, device)
+	})
+}
+
 // MakeExternalAPI turns a util.JSONRequestHandler function into an http.Handler.
 // This is used for APIs that are called from the internet.
 func MakeExternalAPI(metricsName string, f func(*http.Request) util.JSONResponse) http.Handler {
@@ -204,10 +250,12 @@ func MakeInternalAPI(metricsName string, f func(*http.Request) util.JSONResponse
 	return http.HandlerFunc(withSpan)
 }
 
-// MakeFedAPI makes an http.Handler that checks matrix federation authentication.
+// MakeFedAPI makes an http.Handler that checks matrix federation authentication,
+// and that we are allowed to federate with the origin server.
 func MakeFedAPI(
 	metricsName string,
 	serverName gomatrixserverlib.ServerName,
+	domains *config.FederationDomains,
 	keyRing gomatrixserverlib.JSONVerifier,
 	wakeup *FederationWakeups,
 	f func(*http.Request, *gomatrixserverlib.FederationRequest, map[string]string) util.JSONResponse,
@@ -219,6 +267,9 @@ func MakeFedAPI(
 		if fedReq == nil {
 			return errResp
 		}
+		if !domains.IsAllowed(fedReq.Origin()) {
+			return util.MatrixErrorResponse(http.StatusForbidden, "M_FORBIDDEN", "Federation with this server is not allowed")
+		}
 		// add the user to Sentry, if enabled
 		hub := sentry.GetHubFromContext(req.Context())
 		if hub != nil {
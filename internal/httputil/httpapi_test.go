@@ -15,9 +15,17 @@
 package httputil
 
 import (
+	"context"
+	"crypto/ed25519"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	federationapiAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
 )
 
 func TestWrapHandlerInBasicAuth(t *testing.T) {
@@ -107,3 +115,51 @@ func TestWrapHandlerInBasicAuth(t *testing.T) {
 		})
 	}
 }
+
+type aliveFederationAPI struct {
+	federationapiAPI.FederationInternalAPI
+}
+
+func (a *aliveFederationAPI) PerformServersAlive(ctx context.Context, req *federationapiAPI.PerformServersAliveRequest, res *federationapiAPI.PerformServersAliveResponse) error {
+	return nil
+}
+
+func TestMakeFedAPIFederationDomains(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	domains := &config.FederationDomains{Deny: []string{"*.denied.org"}}
+	called := false
+	h := MakeFedAPI(
+		"test", "localhost", domains, &test.NopJSONVerifier{}, &FederationWakeups{FsAPI: &aliveFederationAPI{}},
+		func(req *http.Request, fedReq *gomatrixserverlib.FederationRequest, vars map[string]string) util.JSONResponse {
+			called = true
+			return util.JSONResponse{Code: http.StatusOK, JSON: struct{}{}}
+		},
+	)
+
+	for origin, wantCode := range map[gomatrixserverlib.ServerName]int{
+		"allowed.org":       http.StatusOK,
+		"matrix.denied.org": http.StatusForbidden,
+	} {
+		called = false
+		fedReq := gomatrixserverlib.NewFederationRequest(http.MethodGet, "localhost", "/_matrix/federation/v1/query/profile")
+		if err = fedReq.Sign(origin, "ed25519:test", privateKey); err != nil {
+			t.Fatalf("failed to sign request: %s", err)
+		}
+		req, err := fedReq.HTTPRequest()
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err)
+		}
+		req.Body = http.NoBody
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != wantCode {
+			t.Errorf("%s: got HTTP %d, want %d", origin, rec.Code, wantCode)
+		}
+		if wantCalled := wantCode == http.StatusOK; called != wantCalled {
+			t.Errorf("%s: handler called: got %v, want %v", origin, called, wantCalled)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushgateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	// maxNotifyAttempts is how many times a notification is sent to
+	// a gateway before giving up.
+	maxNotifyAttempts = 5
+	// initialNotifyBackoff is how long to wait before the first retry.
+	// It doubles with each subsequent attempt.
+	initialNotifyBackoff = 2 * time.Second
+)
+
+type httpClient struct {
+	hc      *http.Client
+	backoff time.Duration
+}
+
+// NewHTTPClient creates a new Push Gateway client.
+func NewHTTPClient(disableTLSValidation bool) Client {
+	return &httpClient{
+		hc: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: disableTLSValidation,
+				},
+			},
+		},
+		backoff: initialNotifyBackoff,
+	}
+}
+
+// Notify sends the notification, retrying with an exponential backoff
+// if the gateway is unreachable or returns a server error. Client errors
+// are returned immediately, since retrying won't help.
+func (h *httpClient) Notify(ctx context.Context, url string, req *NotifyRequest, resp *NotifyResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Notify")
+	defer span.Finish()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	backoff := h.backoff
+	for attempt := 1; ; attempt++ {
+		err = h.notify(ctx, url, body, resp)
+		if err == nil {
+			return nil
+		}
+		var perr *permanentError
+		if errors.As(err, &perr) || attempt >= maxNotifyAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (h *httpClient) notify(ctx context.Context, url string, body []byte, resp *NotifyResponse) error {
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hresp, err := h.hc.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer internal.CloseAndLogIfError(ctx, hresp.Body, "failed to close response body")
+
+	switch {
+	case hresp.StatusCode == http.StatusOK:
+		// Handled below.
+	case hresp.StatusCode == http.StatusTooManyRequests || hresp.StatusCode >= 500:
+		return fmt.Errorf("push gateway returned HTTP %d", hresp.StatusCode)
+	default:
+		return &permanentError{fmt.Errorf("push gateway returned HTTP %d", hresp.StatusCode)}
+	}
+
+	if err = json.NewDecoder(hresp.Body).Decode(resp); err != nil {
+		return &permanentError{fmt.Errorf("json.Decode: %w", err)}
+	}
+	return nil
+}
+
+// permanentError is an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	tsts := []struct {
+		Name         string
+		Statuses     []int
+		WantErr      bool
+		WantAttempts int
+	}{
+		{"ok", []int{http.StatusOK}, false, 1},
+		{"retriesServerError", []int{http.StatusBadGateway, http.StatusOK}, false, 2},
+		{"retriesRateLimit", []int{http.StatusTooManyRequests, http.StatusOK}, false, 2},
+		{"noRetryClientError", []int{http.StatusBadRequest, http.StatusOK}, true, 1},
+		{"givesUp", []int{500, 500, 500, 500, 500, http.StatusOK}, true, maxNotifyAttempts},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			var attempts int
+			var gotReq NotifyRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tst.Statuses[attempts]
+				attempts++
+				if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+					t.Errorf("Decode failed: %v", err)
+				}
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(`{"rejected":["badkey"]}`))
+				}
+			}))
+			defer srv.Close()
+
+			c := &httpClient{hc: srv.Client()}
+			req := NotifyRequest{Notification: Notification{
+				EventID: "$event",
+				Devices: []*Device{{AppID: "app", PushKey: "key"}},
+			}}
+			var resp NotifyResponse
+			err := c.Notify(context.Background(), srv.URL, &req, &resp)
+			if gotErr := err != nil; gotErr != tst.WantErr {
+				t.Fatalf("Notify: got error %v, want error: %v", err, tst.WantErr)
+			}
+			if attempts != tst.WantAttempts {
+				t.Errorf("Notify: got %d attempts, want %d", attempts, tst.WantAttempts)
+			}
+			if !reflect.DeepEqual(gotReq, req) {
+				t.Errorf("Notify: gateway got %+v, want %+v", gotReq, req)
+			}
+			if !tst.WantErr && !reflect.DeepEqual(resp.Rejected, []string{"badkey"}) {
+				t.Errorf("Notify: got rejected %v, want [badkey]", resp.Rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushrules
+
+import (
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// DefaultAccountRuleSets is the complete set of default push rules
+// for an account.
+func DefaultAccountRuleSets(localpart string, serverName gomatrixserverlib.ServerName) *AccountRuleSets {
+	return &AccountRuleSets{
+		Global: *DefaultGlobalRuleSet(localpart, serverName),
+	}
+}
+
+// DefaultGlobalRuleSet returns the default ruleset for a given (fully
+// qualified) MXID.
+func DefaultGlobalRuleSet(localpart string, serverName gomatrixserverlib.ServerName) *RuleSet {
+	return &RuleSet{
+		Override:  defaultOverrideRules("@" + localpart + ":" + string(serverName)),
+		Content:   defaultContentRules(localpart),
+		Room:      []*Rule{},
+		Sender:    []*Rule{},
+		Underride: defaultUnderrideRules,
+	}
+}
+
+// MergeDefaults makes sure that every server-default rule is present
+// in the given rule sets. Server-default rules that the user has
+// already got keep their enabled flag and actions, but pick up the
+// current server definition of their conditions and pattern. Missing
+// server-default rules are added with the lowest priority of their
+// kind, except for .m.rule.master, which always comes first.
+func MergeDefaults(ruleSets *AccountRuleSets, localpart string, serverName gomatrixserverlib.ServerName) {
+	defaults := DefaultGlobalRuleSet(localpart, serverName)
+	for _, kind := range Kinds {
+		rules := ruleSets.Global.RulesForKind(kind)
+		*rules = mergeDefaultRules(*rules, *defaults.RulesForKind(kind))
+	}
+}
+
+func mergeDefaultRules(rules, defaults []*Rule) []*Rule {
+	merged := make([]*Rule, 0, len(rules)+len(defaults))
+	var prepend, appended []*Rule
+	existing := make(map[string]int, len(rules))
+	for i, rule := range rules {
+		existing[rule.RuleID] = i
+	}
+	merged = append(merged, rules...)
+	for _, def := range defaults {
+		rule := *def
+		if i, ok := existing[rule.RuleID]; ok {
+			rule.Enabled = merged[i].Enabled
+			rule.Actions = merged[i].Actions
+			merged[i] = &rule
+			continue
+		}
+		if rule.RuleID == MRuleMaster {
+			prepend = append(prepend, &rule)
+		} else {
+			appended = append(appended, &rule)
+		}
+	}
+	merged = append(prepend, merged...)
+	return append(merged, appended...)
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushrules
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestRuleSetEvaluatorMatchEvent(t *testing.T) {
+	ev := mustEventFromJSON(t, `{"room_id":"!room:a","sender":"@bob:a","type":"m.room.message","content":{"msgtype":"m.text","body":"hello alice"}}`)
+
+	defaultEnabled := &Rule{
+		RuleID:  ".default.enabled",
+		Default: true,
+		Enabled: true,
+	}
+	userEnabled := &Rule{
+		RuleID:  "user.enabled",
+		Default: false,
+		Enabled: true,
+	}
+	userEnabled2 := &Rule{
+		RuleID:  "user.enabled2",
+		Default: false,
+		Enabled: true,
+	}
+
+	tsts := []struct {
+		Name    string
+		RuleSet RuleSet
+		Want    *Rule
+	}{
+		{"empty", RuleSet{}, nil},
+		{"defaultCanWin", RuleSet{Override: []*Rule{defaultEnabled}}, defaultEnabled},
+		{"userOverrideWins", RuleSet{Override: []*Rule{userEnabled, defaultEnabled}}, userEnabled},
+		{"overrideContent", RuleSet{Override: []*Rule{userEnabled}, Content: []*Rule{userEnabled2}}, userEnabled},
+		{"overrideRoom", RuleSet{Override: []*Rule{userEnabled}, Room: []*Rule{userEnabled2}}, userEnabled},
+		{"overrideSender", RuleSet{Override: []*Rule{userEnabled}, Sender: []*Rule{userEnabled2}}, userEnabled},
+		{"overrideUnderride", RuleSet{Override: []*Rule{userEnabled}, Underride: []*Rule{userEnabled2}}, userEnabled},
+		{"disabledSkipped", RuleSet{Override: []*Rule{{RuleID: "off", Enabled: false}, userEnabled}}, userEnabled},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			rse := NewRuleSetEvaluator(&fakeEvaluationContext{}, &tst.RuleSet)
+			got, err := rse.MatchEvent(ev)
+			if err != nil {
+				t.Fatalf("MatchEvent failed: %v", err)
+			}
+			if got != tst.Want {
+				t.Errorf("MatchEvent: got %+v, want %+v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	emptyRule := Rule{Enabled: true}
+	tsts := []struct {
+		Name      string
+		Kind      Kind
+		Rule      Rule
+		EventJSON string
+		Want      bool
+	}{
+		{"emptyOverride", OverrideKind, emptyRule, `{"room_id":"!room:example.com"}`, true},
+		{"emptyContent", ContentKind, emptyRule, `{"room_id":"!room:example.com"}`, false},
+		{"emptyRoom", RoomKind, emptyRule, `{"room_id":"!room:example.com"}`, false},
+		{"emptySender", SenderKind, emptyRule, `{"room_id":"!room:example.com"}`, true},
+		{"emptyUnderride", UnderrideKind, emptyRule, `{"room_id":"!room:example.com"}`, true},
+
+		{"disabled", OverrideKind, Rule{}, `{"room_id":"!room:example.com"}`, false},
+
+		{"overrideConditionMatch", OverrideKind, Rule{Enabled: true}, `{"room_id":"!room:example.com"}`, true},
+		{"overrideConditionNoMatch", OverrideKind, Rule{Enabled: true, Conditions: []*Condition{{}}}, `{"room_id":"!room:example.com"}`, false},
+
+		{"underrideConditionMatch", UnderrideKind, Rule{Enabled: true}, `{"room_id":"!room:example.com"}`, true},
+		{"underrideConditionNoMatch", UnderrideKind, Rule{Enabled: true, Conditions: []*Condition{{}}}, `{"room_id":"!room:example.com"}`, false},
+
+		{"contentMatch", ContentKind, Rule{Enabled: true, Pattern: "b"}, `{"room_id":"!room:example.com","content":{"body":"abc b def"}}`, true},
+		{"contentNoMatch", ContentKind, Rule{Enabled: true, Pattern: "b"}, `{"room_id":"!room:example.com","content":{"body":"abcd"}}`, false},
+
+		{"roomMatch", RoomKind, Rule{Enabled: true, RuleID: "!room:example.com"}, `{"room_id":"!room:example.com"}`, true},
+		{"roomNoMatch", RoomKind, Rule{Enabled: true, RuleID: "!room:example.com"}, `{"room_id":"!otherroom:example.com"}`, false},
+
+		{"senderMatch", SenderKind, Rule{Enabled: true, RuleID: "@user:example.com"}, `{"room_id":"!room:example.com","sender":"@user:example.com"}`, true},
+		{"senderNoMatch", SenderKind, Rule{Enabled: true, RuleID: "@user:example.com"}, `{"room_id":"!room:example.com","sender":"@otheruser:example.com"}`, false},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			ev := mustEventFromJSON(t, tst.EventJSON)
+			got, err := ruleMatches(&tst.Rule, tst.Kind, ev, mustEventMap(t, ev), &fakeEvaluationContext{})
+			if err != nil {
+				t.Fatalf("ruleMatches failed: %v", err)
+			}
+			if got != tst.Want {
+				t.Errorf("ruleMatches: got %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestConditionMatches(t *testing.T) {
+	tsts := []struct {
+		Name      string
+		Cond      Condition
+		EventJSON string
+		Want      bool
+	}{
+		{"empty", Condition{}, `{}`, false},
+		{"unknown", Condition{Kind: "unknownstring"}, `{}`, false},
+
+		{"eventMatch", Condition{Kind: EventMatchCondition, Key: "content"}, `{"content":{}}`, false},
+		{"eventMatchType", Condition{Kind: EventMatchCondition, Key: "type", Pattern: "m.room.message"}, `{"type":"m.room.message"}`, true},
+		{"eventMatchTypeGlob", Condition{Kind: EventMatchCondition, Key: "type", Pattern: "m.room.*"}, `{"type":"m.room.message"}`, true},
+		{"eventMatchTypeWhole", Condition{Kind: EventMatchCondition, Key: "type", Pattern: "m.room"}, `{"type":"m.room.message"}`, false},
+		{"eventMatchEmptyStateKey", Condition{Kind: EventMatchCondition, Key: "state_key", Pattern: ""}, `{"state_key":""}`, true},
+		{"eventMatchBodyWord", Condition{Kind: EventMatchCondition, Key: "content.body", Pattern: "@room"}, `{"content":{"body":"hey @room, look"}}`, true},
+		{"eventMatchBodyCase", Condition{Kind: EventMatchCondition, Key: "content.body", Pattern: "HELLO"}, `{"content":{"body":"hello world"}}`, true},
+
+		{"displayNameNoMatch", Condition{Kind: ContainsDisplayNameCondition}, `{"content":{"body":"something without displayname"}}`, false},
+		{"displayNameMatch", Condition{Kind: ContainsDisplayNameCondition}, `{"content":{"body":"hello Dear User, how are you?"}}`, true},
+
+		{"roomMemberCountLessNoMatch", Condition{Kind: RoomMemberCountCondition, Is: "<2"}, `{}`, false},
+		{"roomMemberCountLessMatch", Condition{Kind: RoomMemberCountCondition, Is: "<3"}, `{}`, true},
+		{"roomMemberCountLessEqualNoMatch", Condition{Kind: RoomMemberCountCondition, Is: "<=1"}, `{}`, false},
+		{"roomMemberCountLessEqualMatch", Condition{Kind: RoomMemberCountCondition, Is: "<=2"}, `{}`, true},
+		{"roomMemberCountEqualNoMatch", Condition{Kind: RoomMemberCountCondition, Is: "==1"}, `{}`, false},
+		{"roomMemberCountEqualMatch", Condition{Kind: RoomMemberCountCondition, Is: "==2"}, `{}`, true},
+		{"roomMemberCountImplicitEqualMatch", Condition{Kind: RoomMemberCountCondition, Is: "2"}, `{}`, true},
+		{"roomMemberCountGreaterEqualNoMatch", Condition{Kind: RoomMemberCountCondition, Is: ">=3"}, `{}`, false},
+		{"roomMemberCountGreaterEqualMatch", Condition{Kind: RoomMemberCountCondition, Is: ">=2"}, `{}`, true},
+		{"roomMemberCountGreaterNoMatch", Condition{Kind: RoomMemberCountCondition, Is: ">2"}, `{}`, false},
+		{"roomMemberCountGreaterMatch", Condition{Kind: RoomMemberCountCondition, Is: ">1"}, `{}`, true},
+
+		{"senderNotificationPermissionMatch", Condition{Kind: SenderNotificationPermissionCondition, Key: "powerlevel"}, `{"sender":"@poweruser:example.com"}`, true},
+		{"senderNotificationPermissionNoMatch", Condition{Kind: SenderNotificationPermissionCondition, Key: "powerlevel"}, `{"sender":"@nobody:example.com"}`, false},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			ev := mustEventFromJSON(t, tst.EventJSON)
+			got, err := conditionMatches(&tst.Cond, ev, mustEventMap(t, ev), &fakeEvaluationContext{})
+			if err != nil {
+				t.Fatalf("conditionMatches failed: %v", err)
+			}
+			if got != tst.Want {
+				t.Errorf("conditionMatches: got %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestMergeDefaults(t *testing.T) {
+	ruleSets := AccountRuleSets{
+		Global: RuleSet{
+			Override: []*Rule{
+				{RuleID: "user.rule", Enabled: true, Actions: []*Action{{Kind: NotifyAction}}},
+				{RuleID: MRuleSuppressNotices, Default: true, Enabled: false, Actions: []*Action{{Kind: NotifyAction}}},
+			},
+		},
+	}
+	MergeDefaults(&ruleSets, "alice", "example.com")
+
+	override := ruleSets.Global.Override
+	if len(override) != 8 {
+		t.Fatalf("MergeDefaults: got %d override rules, want 8", len(override))
+	}
+	if override[0].RuleID != MRuleMaster {
+		t.Errorf("MergeDefaults: first override rule is %q, want %q", override[0].RuleID, MRuleMaster)
+	}
+	if override[1].RuleID != "user.rule" {
+		t.Errorf("MergeDefaults: second override rule is %q, want %q", override[1].RuleID, "user.rule")
+	}
+	if suppress := override[2]; suppress.RuleID != MRuleSuppressNotices || suppress.Enabled || len(suppress.Conditions) != 1 {
+		t.Errorf("MergeDefaults: got %+v, want disabled %q with server conditions", suppress, MRuleSuppressNotices)
+	}
+	if len(ruleSets.Global.Content) != 1 || ruleSets.Global.Content[0].Pattern != "alice" {
+		t.Errorf("MergeDefaults: got content rules %+v, want contains_user_name for alice", ruleSets.Global.Content)
+	}
+	if len(ruleSets.Global.Underride) != len(defaultUnderrideRules) {
+		t.Errorf("MergeDefaults: got %d underride rules, want %d", len(ruleSets.Global.Underride), len(defaultUnderrideRules))
+	}
+
+	// Merging again must not change anything.
+	MergeDefaults(&ruleSets, "alice", "example.com")
+	if len(ruleSets.Global.Override) != 8 {
+		t.Errorf("MergeDefaults: got %d override rules after second merge, want 8", len(ruleSets.Global.Override))
+	}
+}
+
+type fakeEvaluationContext struct{}
+
+func (fakeEvaluationContext) UserDisplayName() string       { return "Dear User" }
+func (fakeEvaluationContext) RoomMemberCount() (int, error) { return 2, nil }
+func (fakeEvaluationContext) HasPowerLevel(userID, levelKey string) (bool, error) {
+	return userID == "@poweruser:example.com" && levelKey == "powerlevel", nil
+}
+
+func mustEventFromJSON(t *testing.T, eventJSON string) *gomatrixserverlib.Event {
+	ev, err := gomatrixserverlib.NewEventFromTrustedJSON([]byte(eventJSON), false, gomatrixserverlib.RoomVersionV7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func mustEventMap(t *testing.T, ev *gomatrixserverlib.Event) map[string]interface{} {
+	var m map[string]interface{}
+	if err := json.Unmarshal(ev.JSON(), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
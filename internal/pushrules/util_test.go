@@ -0,0 +1,177 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushrules
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestActionsToTweaks(t *testing.T) {
+	tsts := []struct {
+		Name       string
+		Input      []*Action
+		WantKind   ActionKind
+		WantTweaks map[string]interface{}
+	}{
+		{"empty", nil, UnknownAction, nil},
+		{"zero", []*Action{{}}, UnknownAction, nil},
+		{"onlyPrimary", []*Action{{Kind: NotifyAction}}, NotifyAction, nil},
+		{"onlyTweak", []*Action{{Kind: SetTweakAction, Tweak: HighlightTweak}}, UnknownAction, map[string]interface{}{"highlight": nil}},
+		{"onlyTweakWithValue", []*Action{{Kind: SetTweakAction, Tweak: SoundTweak, Value: "default"}}, UnknownAction, map[string]interface{}{"sound": "default"}},
+		{
+			"all",
+			[]*Action{
+				{Kind: CoalesceAction},
+				{Kind: SetTweakAction, Tweak: HighlightTweak},
+				{Kind: SetTweakAction, Tweak: SoundTweak, Value: "default"},
+			},
+			CoalesceAction,
+			map[string]interface{}{"highlight": nil, "sound": "default"},
+		},
+		{"dontNotifyStops", []*Action{{Kind: DontNotifyAction}, {Kind: SetTweakAction, Tweak: HighlightTweak}}, DontNotifyAction, nil},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			gotKind, gotTweaks, err := ActionsToTweaks(tst.Input)
+			if tst.Name == "zero" {
+				if err == nil {
+					t.Fatalf("ActionsToTweaks: expected an error for an unknown action")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ActionsToTweaks failed: %v", err)
+			}
+			if gotKind != tst.WantKind {
+				t.Errorf("kind: got %v, want %v", gotKind, tst.WantKind)
+			}
+			if !reflect.DeepEqual(gotTweaks, tst.WantTweaks) {
+				t.Errorf("tweaks: got %+v, want %+v", gotTweaks, tst.WantTweaks)
+			}
+		})
+	}
+}
+
+func TestBoolTweakOr(t *testing.T) {
+	tsts := []struct {
+		Name  string
+		Input map[string]interface{}
+		Def   bool
+		Want  bool
+	}{
+		{"nil", nil, false, false},
+		{"nilValue", map[string]interface{}{"highlight": nil}, false, true},
+		{"false", map[string]interface{}{"highlight": false}, true, false},
+		{"true", map[string]interface{}{"highlight": true}, false, true},
+		{"wrongType", map[string]interface{}{"highlight": "yes"}, false, false},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if got := BoolTweakOr(tst.Input, HighlightTweak, tst.Def); got != tst.Want {
+				t.Errorf("BoolTweakOr: got %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tsts := []struct {
+		Input string
+		Words bool
+		Value string
+		Want  bool
+	}{
+		{"", false, "", true},
+		{"a", false, "a", true},
+		{"a", false, "ab", false},
+		{"a*", false, "abc", true},
+		{"a?c", false, "abc", true},
+		{"a?c", false, "ac", false},
+		{"[ab]c", false, "bc", true},
+		{"[!ab]c", false, "bc", false},
+		{"a.b", false, "axb", false},
+		{`a\*`, false, "a*", true},
+		{`a\*`, false, "ab", false},
+		{"Cake", false, "cake", true},
+		{"cake", true, "I like cake.", true},
+		{"cake", true, "I like cakes.", false},
+		{"cake*", true, "I like cakes.", true},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Input+"/"+tst.Value, func(t *testing.T) {
+			re, err := globToRegexp(tst.Input, tst.Words)
+			if err != nil {
+				t.Fatalf("globToRegexp failed: %v", err)
+			}
+			if got := re.MatchString(tst.Value); got != tst.Want {
+				t.Errorf("globToRegexp(%q) matching %q: got %v, want %v (regexp %s)", tst.Input, tst.Value, got, tst.Want, re)
+			}
+		})
+	}
+}
+
+func TestActionJSON(t *testing.T) {
+	tsts := []struct {
+		Want Action
+	}{
+		{Action{Kind: NotifyAction}},
+		{Action{Kind: DontNotifyAction}},
+		{Action{Kind: CoalesceAction}},
+		{Action{Kind: SetTweakAction, Tweak: HighlightTweak}},
+		{Action{Kind: SetTweakAction, Tweak: SoundTweak, Value: "default"}},
+	}
+	for _, tst := range tsts {
+		t.Run(string(tst.Want.Kind)+string(tst.Want.Tweak), func(t *testing.T) {
+			bs, err := json.Marshal(&tst.Want)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			var got Action
+			if err := json.Unmarshal(bs, &got); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tst.Want) {
+				t.Errorf("round-trip of %s: got %+v, want %+v", bs, got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	tsts := []struct {
+		Name    string
+		Kind    Kind
+		Rule    Rule
+		WantErr bool
+	}{
+		{"validOverride", OverrideKind, Rule{RuleID: "my.rule", Actions: []*Action{{Kind: NotifyAction}}}, false},
+		{"missingActions", OverrideKind, Rule{RuleID: "my.rule"}, true},
+		{"badRuleID", OverrideKind, Rule{RuleID: "my/rule", Actions: []*Action{{Kind: NotifyAction}}}, true},
+		{"contentWithoutPattern", ContentKind, Rule{RuleID: "my.rule", Actions: []*Action{{Kind: NotifyAction}}}, true},
+		{"roomNotARoom", RoomKind, Rule{RuleID: "my.rule", Actions: []*Action{{Kind: NotifyAction}}}, true},
+		{"senderIsUser", SenderKind, Rule{RuleID: "@alice:example.com", Actions: []*Action{{Kind: NotifyAction}}}, false},
+		{"badCondition", UnderrideKind, Rule{RuleID: "my.rule", Actions: []*Action{{Kind: NotifyAction}}, Conditions: []*Condition{{Kind: RoomMemberCountCondition, Is: "lots"}}}, true},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			errs := ValidateRule(tst.Kind, &tst.Rule)
+			if got := len(errs) > 0; got != tst.WantErr {
+				t.Errorf("ValidateRule: got errors %v, want errors: %v", errs, tst.WantErr)
+			}
+		})
+	}
+}
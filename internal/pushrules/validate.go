@@ -0,0 +1,123 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushrules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValidateRule checks the rule for errors. These follow from Sections
+// 6.5 and 6.6 of the push rules specification.
+func ValidateRule(kind Kind, rule *Rule) []error {
+	var errs []error
+
+	if !validRuleIDRE.MatchString(rule.RuleID) {
+		errs = append(errs, fmt.Errorf("invalid rule ID: %s", rule.RuleID))
+	}
+
+	if len(rule.Actions) == 0 {
+		errs = append(errs, fmt.Errorf("missing actions"))
+	}
+	for _, action := range rule.Actions {
+		errs = append(errs, validateAction(action)...)
+	}
+
+	for _, cond := range rule.Conditions {
+		errs = append(errs, validateCondition(cond)...)
+	}
+
+	switch kind {
+	case OverrideKind, UnderrideKind:
+		// A rule without conditions matches every event, so there is
+		// nothing more to check.
+
+	case ContentKind:
+		if rule.Pattern == "" {
+			errs = append(errs, fmt.Errorf("missing content rule pattern"))
+		}
+
+	case RoomKind:
+		if !strings.HasPrefix(rule.RuleID, "!") {
+			errs = append(errs, fmt.Errorf("room rule ID must be a room ID: %s", rule.RuleID))
+		}
+
+	case SenderKind:
+		if !strings.HasPrefix(rule.RuleID, "@") {
+			errs = append(errs, fmt.Errorf("sender rule ID must be a user ID: %s", rule.RuleID))
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("invalid rule kind: %s", kind))
+	}
+
+	return errs
+}
+
+// validRuleIDRE is a regexp for valid IDs.
+//
+// TODO: the specification doesn't seem to say what the rule ID syntax
+// is. A Rule is fairly complex, so some kind of escaping is needed to
+// put it in a URL path.
+var validRuleIDRE = regexp.MustCompile(`^([^\\/]|\\.)+$`)
+
+func validateAction(action *Action) []error {
+	var errs []error
+
+	switch action.Kind {
+	case NotifyAction, DontNotifyAction, CoalesceAction:
+		// Allowed.
+
+	case SetTweakAction:
+		if action.Tweak == UnknownTweak {
+			errs = append(errs, fmt.Errorf("missing tweak for set_tweak action"))
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("invalid rule action kind: %s", action.Kind))
+	}
+
+	return errs
+}
+
+func validateCondition(cond *Condition) []error {
+	var errs []error
+
+	switch cond.Kind {
+	case EventMatchCondition:
+		if cond.Key == "" {
+			errs = append(errs, fmt.Errorf("missing event_match condition key"))
+		}
+
+	case ContainsDisplayNameCondition:
+		// Nothing to validate.
+
+	case RoomMemberCountCondition:
+		if _, err := parseRoomMemberCountCondition(cond.Is); err != nil {
+			errs = append(errs, fmt.Errorf("invalid room_member_count condition: %w", err))
+		}
+
+	case SenderNotificationPermissionCondition:
+		if cond.Key == "" {
+			errs = append(errs, fmt.Errorf("missing sender_notification_permission condition key"))
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("invalid rule condition kind: %s", cond.Kind))
+	}
+
+	return errs
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// DBType is a kind of database that tests can be run against.
+type DBType int
+
+const (
+	DBTypeSQLite   DBType = 1
+	DBTypePostgres DBType = 2
+)
+
+func (t DBType) String() string {
+	switch t {
+	case DBTypeSQLite:
+		return "SQLite"
+	case DBTypePostgres:
+		return "Postgres"
+	}
+	return fmt.Sprintf("DBType(%d)", int(t))
+}
+
+// postgresConnectionString returns a connection string for the named database
+// on the PostgreSQL server given by the POSTGRES_* environment variables.
+func postgresConnectionString(dbName string) config.DataSource {
+	user := Defaulting(os.Getenv("POSTGRES_USER"), "dendrite")
+	connStr := fmt.Sprintf("user=%s dbname=%s sslmode=disable", user, dbName)
+	if password := os.Getenv("POSTGRES_PASSWORD"); password != "" {
+		connStr += fmt.Sprintf(" password=%s", password)
+	}
+	if host := os.Getenv("POSTGRES_HOST"); host != "" {
+		connStr += fmt.Sprintf(" host=%s", host)
+	}
+	return config.DataSource(connStr)
+}
+
+// openPostgresAdmin connects to the database given by POSTGRES_DB, which is
+// used to create and drop the databases for each test.
+func openPostgresAdmin() (*sql.DB, error) {
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: postgresConnectionString(Defaulting(os.Getenv("POSTGRES_DB"), "dendrite")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close() // nolint: errcheck
+		return nil, err
+	}
+	return db, nil
+}
+
+// PrepareDBConnectionString returns the connection string of an empty
+// database of the given type for the test to use, which is removed once
+// the test has finished. Tests are skipped if PostgreSQL is unavailable.
+func PrepareDBConnectionString(t *testing.T, dbType DBType) config.DataSource {
+	t.Helper()
+	if dbType == DBTypeSQLite {
+		return config.DataSource("file:" + filepath.Join(t.TempDir(), "dendrite_test.db"))
+	}
+
+	db, err := openPostgresAdmin()
+	if err != nil {
+		t.Skipf("PostgreSQL not available (%s), skipping", err)
+	}
+	defer db.Close() // nolint: errcheck
+
+	// Each test gets a database of its own, named after the test.
+	hash := sha256.Sum256([]byte(t.Name()))
+	dbName := "dendrite_test_" + hex.EncodeToString(hash[:8])
+	if _, err = db.Exec("DROP DATABASE IF EXISTS " + dbName); err != nil {
+		t.Fatalf("failed to drop database %s: %s", dbName, err)
+	}
+	if _, err = db.Exec("CREATE DATABASE " + dbName); err != nil {
+		t.Fatalf("failed to create database %s: %s", dbName, err)
+	}
+	t.Cleanup(func() {
+		cleanupDB, err := openPostgresAdmin()
+		if err != nil {
+			t.Logf("failed to drop database %s: %s", dbName, err)
+			return
+		}
+		defer cleanupDB.Close() // nolint: errcheck
+		if _, err = cleanupDB.Exec("DROP DATABASE IF EXISTS " + dbName); err != nil {
+			t.Logf("failed to drop database %s: %s", dbName, err)
+		}
+	})
+	return postgresConnectionString(dbName)
+}
+
+// WithAllDatabases runs the test once against each of the database types.
+func WithAllDatabases(t *testing.T, testFn func(t *testing.T, dbType DBType)) {
+	for _, dbType := range []DBType{DBTypeSQLite, DBTypePostgres} {
+		dbType := dbType
+		t.Run(dbType.String(), func(t *testing.T) {
+			testFn(t, dbType)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+var (
+	// KeyID and PrivateKey sign all of the events made by Room.
+	KeyID      = gomatrixserverlib.KeyID("ed25519:test")
+	PrivateKey = ed25519.NewKeyFromSeed([]byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32,
+	})
+
+	roomCounter int64
+)
+
+// Room builds a linear chain of events in a room, for tests that need real,
+// signed events with the right auth and prev events.
+type Room struct {
+	ID      string
+	Version gomatrixserverlib.RoomVersion
+	Creator string
+
+	authEvents gomatrixserverlib.AuthEvents
+	events     []*gomatrixserverlib.HeaderedEvent
+}
+
+// NewRoom creates a public room, made by the creator, which contains the
+// create event, the creator's join, the power levels and the join rules.
+func NewRoom(t *testing.T, creator string) *Room {
+	t.Helper()
+	return NewRoomWithVersion(t, creator, gomatrixserverlib.RoomVersionV4)
+}
+
+// NewRoomWithVersion is NewRoom for a particular room version.
+func NewRoomWithVersion(t *testing.T, creator string, version gomatrixserverlib.RoomVersion) *Room {
+	t.Helper()
+	_, domain, err := gomatrixserverlib.SplitID('@', creator)
+	if err != nil {
+		t.Fatalf("invalid creator %q: %s", creator, err)
+	}
+	r := &Room{
+		ID:         fmt.Sprintf("!%d:%s", atomic.AddInt64(&roomCounter, 1), domain),
+		Version:    version,
+		Creator:    creator,
+		authEvents: gomatrixserverlib.NewAuthEvents(nil),
+	}
+	r.CreateAndInsert(t, creator, gomatrixserverlib.MRoomCreate, map[string]interface{}{
+		"creator":      creator,
+		"room_version": version,
+	}, "")
+	r.CreateAndInsert(t, creator, gomatrixserverlib.MRoomMember, map[string]interface{}{
+		"membership": gomatrixserverlib.Join,
+	}, creator)
+	r.CreateAndInsert(t, creator, gomatrixserverlib.MRoomPowerLevels, map[string]interface{}{
+		"users":          map[string]int64{creator: 100},
+		"users_default":  0,
+		"events_default": 0,
+		"state_default":  50,
+		"invite":         0,
+		"kick":           50,
+		"ban":            50,
+		"redact":         50,
+	}, "")
+	r.CreateAndInsert(t, creator, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{
+		"join_rule": gomatrixserverlib.Public,
+	}, "")
+	return r
+}
+
+// CreateEvent builds an event which follows on from the last event in the
+// room, without adding it to the room. Passing a state key makes it a state
+// event.
+func (r *Room) CreateEvent(
+	t *testing.T, sender, eventType string, content interface{}, stateKey ...string,
+) *gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	_, origin, err := gomatrixserverlib.SplitID('@', sender)
+	if err != nil {
+		t.Fatalf("invalid sender %q: %s", sender, err)
+	}
+	builder := &gomatrixserverlib.EventBuilder{
+		Sender: sender,
+		RoomID: r.ID,
+		Type:   eventType,
+		Depth:  int64(len(r.events) + 1),
+	}
+	if len(stateKey) > 0 {
+		builder.StateKey = &stateKey[0]
+	}
+	if err = builder.SetContent(content); err != nil {
+		t.Fatalf("failed to set content: %s", err)
+	}
+	if len(r.events) > 0 {
+		builder.PrevEvents = []gomatrixserverlib.EventReference{r.events[len(r.events)-1].EventReference()}
+	}
+	stateNeeded, err := gomatrixserverlib.StateNeededForEventBuilder(builder)
+	if err != nil {
+		t.Fatalf("failed to work out auth events: %s", err)
+	}
+	if builder.AuthEvents, err = stateNeeded.AuthEventReferences(&r.authEvents); err != nil {
+		t.Fatalf("failed to work out auth events: %s", err)
+	}
+	ev, err := builder.Build(time.Now(), origin, KeyID, PrivateKey, r.Version)
+	if err != nil {
+		t.Fatalf("failed to build event: %s", err)
+	}
+	if err = gomatrixserverlib.Allowed(ev, &r.authEvents); err != nil {
+		t.Fatalf("event %s of type %s is not allowed: %s", ev.EventID(), eventType, err)
+	}
+	return ev.Headered(r.Version)
+}
+
+// InsertEvent adds an event to the end of the room.
+func (r *Room) InsertEvent(t *testing.T, ev *gomatrixserverlib.HeaderedEvent) {
+	t.Helper()
+	if ev.StateKey() != nil {
+		if err := r.authEvents.AddEvent(ev.Unwrap()); err != nil {
+			t.Fatalf("failed to add auth event: %s", err)
+		}
+	}
+	r.events = append(r.events, ev)
+}
+
+// CreateAndInsert builds an event with CreateEvent and adds it to the room.
+func (r *Room) CreateAndInsert(
+	t *testing.T, sender, eventType string, content interface{}, stateKey ...string,
+) *gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	ev := r.CreateEvent(t, sender, eventType, content, stateKey...)
+	r.InsertEvent(t, ev)
+	return ev
+}
+
+// Events returns all of the events in the room, oldest first.
+func (r *Room) Events() []*gomatrixserverlib.HeaderedEvent {
+	return r.events
+}
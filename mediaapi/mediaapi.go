@@ -27,8 +27,10 @@ import (
 // AddPublicRoutes sets up and registers HTTP handlers for the MediaAPI component.
 func AddPublicRoutes(
 	router *mux.Router,
+	dendriteAdminRouter *mux.Router,
 	cfg *config.MediaAPI,
 	rateLimit *config.RateLimiting,
+	federationDomains *config.FederationDomains,
 	userAPI userapi.UserInternalAPI,
 	client *gomatrixserverlib.Client,
 ) {
@@ -38,6 +40,6 @@ func AddPublicRoutes(
 	}
 
 	routing.Setup(
-		router, cfg, rateLimit, mediaDB, userAPI, client,
+		router, dendriteAdminRouter, cfg, rateLimit, federationDomains, mediaDB, userAPI, client,
 	)
 }
@@ -0,0 +1,249 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	log "github.com/sirupsen/logrus"
+)
+
+type deleteMediaResponse struct {
+	DeletedMedia []types.MediaID `json:"deleted_media"`
+	Total        int             `json:"total"`
+}
+
+type purgeMediaCacheResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+func requireLocalUser(cfg *config.MediaAPI, userID string) *util.JSONResponse {
+	_, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("Invalid user ID"),
+		}
+	}
+	if domain != cfg.Matrix.ServerName {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("User must be local to this server"),
+		}
+	}
+	return nil
+}
+
+// AdminQuarantineMedia implements POST /admin/media/quarantine/{serverName}/{mediaId}
+// and POST /admin/media/unquarantine/{serverName}/{mediaId}
+// Quarantined media is no longer served to clients or to other servers. Only
+// media that is stored on this server can be quarantined.
+func AdminQuarantineMedia(
+	req *http.Request, db storage.Database,
+	origin gomatrixserverlib.ServerName, mediaID types.MediaID, quarantined bool,
+) util.JSONResponse {
+	mediaMetadata, err := db.GetMediaMetadata(req.Context(), mediaID, origin)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetMediaMetadata failed")
+		return jsonerror.InternalServerError()
+	}
+	if mediaMetadata == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Media not found"),
+		}
+	}
+	if err = db.SetMediaQuarantined(req.Context(), mediaID, origin, quarantined); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.SetMediaQuarantined failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// AdminDeleteMedia implements DELETE /admin/media/{serverName}/{mediaId}
+// This deletes local media, or our cached copy of remote media.
+func AdminDeleteMedia(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database,
+	origin gomatrixserverlib.ServerName, mediaID types.MediaID,
+) util.JSONResponse {
+	mediaMetadata, err := db.GetMediaMetadata(req.Context(), mediaID, origin)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetMediaMetadata failed")
+		return jsonerror.InternalServerError()
+	}
+	if mediaMetadata == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Media not found"),
+		}
+	}
+	if err = deleteMedia(req.Context(), cfg, db, mediaMetadata, util.GetLogger(req.Context())); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to delete media")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: deleteMediaResponse{
+			DeletedMedia: []types.MediaID{mediaID},
+			Total:        1,
+		},
+	}
+}
+
+// AdminDeleteUserMedia implements DELETE /admin/users/{userID}/media
+// This deletes all of the media that a local user has uploaded.
+func AdminDeleteUserMedia(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string,
+) util.JSONResponse {
+	if resErr := requireLocalUser(cfg, userID); resErr != nil {
+		return *resErr
+	}
+	media, err := db.GetMediaByUser(req.Context(), types.MatrixUserID(userID))
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetMediaByUser failed")
+		return jsonerror.InternalServerError()
+	}
+	res := deleteMediaResponse{
+		DeletedMedia: []types.MediaID{},
+	}
+	for _, mediaMetadata := range media {
+		if err = deleteMedia(req.Context(), cfg, db, mediaMetadata, util.GetLogger(req.Context())); err != nil {
+			util.GetLogger(req.Context()).WithError(err).WithField("media_id", mediaMetadata.MediaID).Error("Failed to delete media")
+			continue
+		}
+		res.DeletedMedia = append(res.DeletedMedia, mediaMetadata.MediaID)
+	}
+	res.Total = len(res.DeletedMedia)
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminPurgeMediaCache implements POST /admin/purge_media_cache?before_ts=<ms>
+// This deletes our cached copies of remote media that haven't been downloaded
+// since before_ts.
+func AdminPurgeMediaCache(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database,
+) util.JSONResponse {
+	beforeTS := req.URL.Query().Get("before_ts")
+	if beforeTS == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("Missing before_ts parameter"),
+		}
+	}
+	before, err := strconv.ParseInt(beforeTS, 10, 64)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("before_ts must be a timestamp in milliseconds"),
+		}
+	}
+	deleted, err := purgeRemoteMedia(req.Context(), cfg, db, types.UnixMs(before), util.GetLogger(req.Context()))
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to purge remote media")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: purgeMediaCacheResponse{Deleted: deleted},
+	}
+}
+
+// deleteMedia removes the media and its thumbnails from the database. The file
+// is removed from disk unless other media IDs still refer to it, which happens
+// when the same file is uploaded more than once.
+func deleteMedia(
+	ctx context.Context, cfg *config.MediaAPI, db storage.Database,
+	mediaMetadata *types.MediaMetadata, logger *log.Entry,
+) error {
+	if err := db.DeleteMedia(ctx, mediaMetadata.MediaID, mediaMetadata.Origin); err != nil {
+		return fmt.Errorf("db.DeleteMedia: %w", err)
+	}
+	count, err := db.GetMediaCountByHash(ctx, mediaMetadata.Base64Hash)
+	if err != nil {
+		return fmt.Errorf("db.GetMediaCountByHash: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	filePath, err := fileutils.GetPathFromBase64Hash(mediaMetadata.Base64Hash, cfg.AbsBasePath)
+	if err != nil {
+		return fmt.Errorf("fileutils.GetPathFromBase64Hash: %w", err)
+	}
+	// Thumbnails are stored alongside the file, so this removes them too.
+	fileutils.RemoveDir(types.Path(filepath.Dir(filePath)), logger)
+	return nil
+}
+
+// purgeRemoteMedia deletes all remote media that hasn't been downloaded since
+// before. It returns the number of media IDs that were deleted.
+func purgeRemoteMedia(
+	ctx context.Context, cfg *config.MediaAPI, db storage.Database,
+	before types.UnixMs, logger *log.Entry,
+) (int, error) {
+	media, err := db.GetRemoteMediaLastAccessedBefore(ctx, cfg.Matrix.ServerName, before)
+	if err != nil {
+		return 0, fmt.Errorf("db.GetRemoteMediaLastAccessedBefore: %w", err)
+	}
+	deleted := 0
+	for _, mediaMetadata := range media {
+		if err = deleteMedia(ctx, cfg, db, mediaMetadata, logger); err != nil {
+			logger.WithError(err).WithFields(log.Fields{
+				"media_id":     mediaMetadata.MediaID,
+				"media_origin": mediaMetadata.Origin,
+			}).Warn("Failed to purge remote media")
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// purgeRemoteMediaPeriodically purges remote media that hasn't been downloaded
+// for media_api.remote_media_lifetime_days, checking once an hour.
+func purgeRemoteMediaPeriodically(cfg *config.MediaAPI, db storage.Database) {
+	lifetime := time.Duration(cfg.RemoteMediaLifetimeDays) * 24 * time.Hour
+	logger := log.WithField("remote_media_lifetime_days", cfg.RemoteMediaLifetimeDays)
+	for {
+		before := time.Now().Add(-lifetime)
+		deleted, err := purgeRemoteMedia(
+			context.Background(), cfg, db,
+			types.UnixMs(before.UnixNano()/int64(time.Millisecond)), logger,
+		)
+		if err != nil {
+			logger.WithError(err).Error("Failed to purge remote media")
+		} else if deleted > 0 {
+			logger.Infof("Purged %d remote media", deleted)
+		}
+		time.Sleep(time.Hour)
+	}
+}
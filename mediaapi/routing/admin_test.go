@@ -0,0 +1,226 @@
+package routing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	log "github.com/sirupsen/logrus"
+)
+
+// mustStoreMedia writes the file for the media to disk and stores its
+// metadata, as though it had just been uploaded or fetched from a remote
+// server.
+func mustStoreMedia(
+	t *testing.T, cfg *config.MediaAPI, db storage.Database,
+	origin gomatrixserverlib.ServerName, mediaID types.MediaID, userID types.MatrixUserID,
+	content string,
+) *types.MediaMetadata {
+	t.Helper()
+	hash := sha256.Sum256([]byte(content))
+	mediaMetadata := &types.MediaMetadata{
+		MediaID:       mediaID,
+		Origin:        origin,
+		ContentType:   "text/plain",
+		FileSizeBytes: types.FileSizeBytes(len(content)),
+		UploadName:    types.Filename(mediaID),
+		Base64Hash:    types.Base64Hash(base64.RawURLEncoding.EncodeToString(hash[:])),
+		UserID:        userID,
+	}
+	filePath, err := fileutils.GetPathFromBase64Hash(mediaMetadata.Base64Hash, cfg.AbsBasePath)
+	if err != nil {
+		t.Fatalf("GetPathFromBase64Hash failed: %s", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+		t.Fatalf("failed to create media directory: %s", err)
+	}
+	if err = ioutil.WriteFile(filePath, []byte(content), 0660); err != nil {
+		t.Fatalf("failed to write media file: %s", err)
+	}
+	if err = db.StoreMediaMetadata(context.Background(), mediaMetadata); err != nil {
+		t.Fatalf("StoreMediaMetadata failed: %s", err)
+	}
+	return mediaMetadata
+}
+
+func mediaFileExists(t *testing.T, cfg *config.MediaAPI, mediaMetadata *types.MediaMetadata) bool {
+	t.Helper()
+	filePath, err := fileutils.GetPathFromBase64Hash(mediaMetadata.Base64Hash, cfg.AbsBasePath)
+	if err != nil {
+		t.Fatalf("GetPathFromBase64Hash failed: %s", err)
+	}
+	_, err = os.Stat(filePath)
+	return err == nil
+}
+
+func TestAdminMedia(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := types.MatrixUserID("@alice:test"), types.MatrixUserID("@bob:test")
+	local, remote := gomatrixserverlib.ServerName("test"), gomatrixserverlib.ServerName("remote")
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		basePath := config.Path(t.TempDir())
+		cfg := &config.MediaAPI{
+			Matrix:      &config.Global{ServerName: local},
+			BasePath:    basePath,
+			AbsBasePath: basePath,
+		}
+		db, err := storage.Open(&config.DatabaseOptions{
+			ConnectionString:   test.PrepareDBConnectionString(t, dbType),
+			MaxOpenConnections: 1,
+			MaxIdleConnections: 1,
+		})
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+
+		// Alice and Bob uploaded the same file, so both media IDs refer to it.
+		aliceShared := mustStoreMedia(t, cfg, db, local, "aliceshared", alice, "shared")
+		bobShared := mustStoreMedia(t, cfg, db, local, "bobshared", bob, "shared")
+		aliceOwn := mustStoreMedia(t, cfg, db, local, "aliceown", alice, "alice only")
+		oldRemote := mustStoreMedia(t, cfg, db, remote, "oldremote", "", "old remote")
+		newRemote := mustStoreMedia(t, cfg, db, remote, "newremote", "", "new remote")
+		// Remote media is stored as last downloaded when it was fetched, so
+		// make one of them look like it hasn't been downloaded for a while.
+		if err = db.UpdateMediaLastAccess(ctx, oldRemote.MediaID, remote, 1000); err != nil {
+			t.Fatalf("UpdateMediaLastAccess failed: %s", err)
+		}
+		for _, mediaMetadata := range []*types.MediaMetadata{aliceOwn, oldRemote} {
+			if err = db.StoreThumbnail(ctx, &types.ThumbnailMetadata{
+				MediaMetadata: mediaMetadata,
+				ThumbnailSize: types.ThumbnailSize{Width: 32, Height: 32, ResizeMethod: types.Scale},
+			}); err != nil {
+				t.Fatalf("StoreThumbnail failed: %s", err)
+			}
+		}
+
+		download := func(mediaMetadata *types.MediaMetadata) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			Download(
+				w, httptest.NewRequest(http.MethodGet, "/", nil),
+				mediaMetadata.Origin, mediaMetadata.MediaID, cfg, db, nil,
+				&types.ActiveRemoteRequests{MXCToResult: map[string]*types.RemoteRequestResult{}},
+				&types.ActiveThumbnailGeneration{PathToResult: map[string]*types.ThumbnailGenerationResult{}},
+				false, "",
+			)
+			return w
+		}
+		mustBeDeleted := func(t *testing.T, mediaMetadata *types.MediaMetadata) {
+			t.Helper()
+			got, err := db.GetMediaMetadata(ctx, mediaMetadata.MediaID, mediaMetadata.Origin)
+			if err != nil {
+				t.Fatalf("GetMediaMetadata failed: %s", err)
+			}
+			if got != nil {
+				t.Errorf("media %s wasn't deleted", mediaMetadata.MediaID)
+			}
+			thumbnails, err := db.GetThumbnails(ctx, mediaMetadata.MediaID, mediaMetadata.Origin)
+			if err != nil {
+				t.Fatalf("GetThumbnails failed: %s", err)
+			}
+			if len(thumbnails) != 0 {
+				t.Errorf("thumbnails of media %s weren't deleted", mediaMetadata.MediaID)
+			}
+		}
+
+		t.Run("quarantine", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if res := AdminQuarantineMedia(req, db, local, "unknown", true); res.Code != http.StatusNotFound {
+				t.Errorf("got status %d for unknown media, want %d", res.Code, http.StatusNotFound)
+			}
+			if res := AdminQuarantineMedia(req, db, local, aliceOwn.MediaID, true); res.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.Code, http.StatusOK)
+			}
+			if w := download(aliceOwn); w.Code != http.StatusNotFound {
+				t.Errorf("got status %d downloading quarantined media, want %d", w.Code, http.StatusNotFound)
+			}
+			if res := AdminQuarantineMedia(req, db, local, aliceOwn.MediaID, false); res.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.Code, http.StatusOK)
+			}
+			if w := download(aliceOwn); w.Code != http.StatusOK || w.Body.String() != "alice only" {
+				t.Errorf("got status %d and body %q downloading unquarantined media", w.Code, w.Body.String())
+			}
+		})
+
+		t.Run("delete media with a shared file", func(t *testing.T) {
+			res := AdminDeleteMedia(httptest.NewRequest(http.MethodDelete, "/", nil), cfg, db, local, aliceShared.MediaID)
+			if res.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.Code, http.StatusOK)
+			}
+			mustBeDeleted(t, aliceShared)
+			if !mediaFileExists(t, cfg, bobShared) {
+				t.Errorf("file was removed while other media still refers to it")
+			}
+			if w := download(bobShared); w.Code != http.StatusOK {
+				t.Errorf("got status %d downloading the other media, want %d", w.Code, http.StatusOK)
+			}
+		})
+
+		t.Run("delete user media", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/", nil)
+			if res := AdminDeleteUserMedia(req, cfg, db, "@alice:remote"); res.Code != http.StatusBadRequest {
+				t.Errorf("got status %d for a remote user, want %d", res.Code, http.StatusBadRequest)
+			}
+			res := AdminDeleteUserMedia(req, cfg, db, string(alice))
+			if res.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.Code, http.StatusOK)
+			}
+			if got, want := res.JSON.(deleteMediaResponse).DeletedMedia, []types.MediaID{aliceOwn.MediaID}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got deleted media %v, want %v", got, want)
+			}
+			mustBeDeleted(t, aliceOwn)
+			if mediaFileExists(t, cfg, aliceOwn) {
+				t.Errorf("file of deleted media wasn't removed")
+			}
+			if got, _ := db.GetMediaMetadata(ctx, bobShared.MediaID, local); got == nil {
+				t.Errorf("media of another user was deleted")
+			}
+		})
+
+		t.Run("purge remote media", func(t *testing.T) {
+			if res := AdminPurgeMediaCache(httptest.NewRequest(http.MethodPost, "/", nil), cfg, db); res.Code != http.StatusBadRequest {
+				t.Errorf("got status %d without before_ts, want %d", res.Code, http.StatusBadRequest)
+			}
+			res := AdminPurgeMediaCache(httptest.NewRequest(http.MethodPost, "/?before_ts="+strconv.FormatInt(int64(newRemote.LastAccessTimestamp), 10), nil), cfg, db)
+			if res.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.Code, http.StatusOK)
+			}
+			if deleted := res.JSON.(purgeMediaCacheResponse).Deleted; deleted != 1 {
+				t.Errorf("got %d media purged, want 1", deleted)
+			}
+			mustBeDeleted(t, oldRemote)
+			if mediaFileExists(t, cfg, oldRemote) {
+				t.Errorf("file of purged media wasn't removed")
+			}
+			for _, kept := range []*types.MediaMetadata{newRemote, bobShared} {
+				if got, _ := db.GetMediaMetadata(ctx, kept.MediaID, kept.Origin); got == nil || !mediaFileExists(t, cfg, kept) {
+					t.Errorf("media %s was purged", kept.MediaID)
+				}
+			}
+		})
+
+		t.Run("delete the last media with a shared file", func(t *testing.T) {
+			if err := deleteMedia(ctx, cfg, db, bobShared, log.NewEntry(log.StandardLogger())); err != nil {
+				t.Fatalf("deleteMedia failed: %s", err)
+			}
+			mustBeDeleted(t, bobShared)
+			if mediaFileExists(t, cfg, bobShared) {
+				t.Errorf("file wasn't removed once no media referred to it")
+			}
+		})
+	})
+}
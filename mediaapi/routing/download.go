@@ -29,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
@@ -48,6 +49,9 @@ const mediaIDCharacters = "A-Za-z0-9_=-"
 // Note: unfortunately regex.MustCompile() cannot be assigned to a const
 var mediaIDRegex = regexp.MustCompile("^[" + mediaIDCharacters + "]+$")
 
+// How often to record that remote media was downloaded
+const lastAccessUpdateInterval = time.Hour
+
 // Regular expressions to help us cope with Content-Disposition parsing
 var rfc2183 = regexp.MustCompile(`filename\=utf-8\"(.*)\"`)
 var rfc6266 = regexp.MustCompile(`filename\*\=utf-8\'\'(.*)`)
@@ -230,8 +234,17 @@ func (r *downloadRequest) doDownload(
 			return nil, resErr
 		}
 	} else {
+		if mediaMetadata.Quarantined {
+			// Quarantined media is treated as though it doesn't exist, both
+			// for our own clients and for other servers.
+			r.Logger.Info("Refusing to serve quarantined media")
+			return nil, nil
+		}
 		// If we have a record, we can respond from the local file
 		r.MediaMetadata = mediaMetadata
+		if mediaMetadata.Origin != cfg.Matrix.ServerName {
+			r.updateLastAccess(ctx, db)
+		}
 	}
 	return r.respondFromLocalFile(
 		ctx, w, cfg.AbsBasePath, activeThumbnailGeneration,
@@ -240,6 +253,23 @@ func (r *downloadRequest) doDownload(
 	)
 }
 
+// updateLastAccess records that the media was downloaded, so that it isn't
+// purged. To avoid writing to the database on every download, this is only
+// done if it wasn't already recorded within lastAccessUpdateInterval.
+func (r *downloadRequest) updateLastAccess(ctx context.Context, db storage.Database) {
+	now := time.Now()
+	lastAccess := time.Unix(0, int64(r.MediaMetadata.LastAccessTimestamp)*int64(time.Millisecond))
+	if now.Sub(lastAccess) < lastAccessUpdateInterval {
+		return
+	}
+	lastAccessTS := types.UnixMs(now.UnixNano() / int64(time.Millisecond))
+	if err := db.UpdateMediaLastAccess(ctx, r.MediaMetadata.MediaID, r.MediaMetadata.Origin, lastAccessTS); err != nil {
+		r.Logger.WithError(err).Warn("Failed to update the last access time of media")
+		return
+	}
+	r.MediaMetadata.LastAccessTimestamp = lastAccessTS
+}
+
 // respondFromLocalFile reads a file from local storage and writes it to the http.ResponseWriter
 // If no file was found then returns nil, nil
 func (r *downloadRequest) respondFromLocalFile(
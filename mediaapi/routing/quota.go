@@ -0,0 +1,203 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+// mediaUsageResponse is the response to GET /usage. A quota of 0 means
+// that the user can upload as much as they like.
+type mediaUsageResponse struct {
+	UsedBytes  types.FileSizeBytes `json:"used_bytes"`
+	QuotaBytes types.FileSizeBytes `json:"quota_bytes"`
+}
+
+type adminMediaQuotaResponse struct {
+	UserID     string              `json:"user_id"`
+	UsedBytes  types.FileSizeBytes `json:"used_bytes"`
+	QuotaBytes types.FileSizeBytes `json:"quota_bytes"`
+	// Whether the quota was set by an admin rather than being the default.
+	Custom bool `json:"custom"`
+}
+
+type adminSetMediaQuotaRequest struct {
+	QuotaBytes *types.FileSizeBytes `json:"quota_bytes"`
+}
+
+// getUserMediaQuota returns the quota of the user, which is the default quota
+// unless an admin has set another one. custom is true in that case.
+func getUserMediaQuota(
+	ctx context.Context, cfg *config.MediaAPI, db storage.Database, userID types.MatrixUserID,
+) (quota types.FileSizeBytes, custom bool, err error) {
+	userQuota, err := db.GetUserMediaQuota(ctx, userID)
+	if err != nil {
+		return 0, false, fmt.Errorf("db.GetUserMediaQuota: %w", err)
+	}
+	if userQuota != nil {
+		return *userQuota, true, nil
+	}
+	return types.FileSizeBytes(cfg.DefaultUserQuotaBytes), false, nil
+}
+
+// checkQuota returns an error response if storing size more bytes would take
+// the uploading user over their quota.
+func (r *uploadRequest) checkQuota(
+	ctx context.Context, cfg *config.MediaAPI, db storage.Database, size types.FileSizeBytes,
+) *util.JSONResponse {
+	if r.MediaMetadata.UserID == "" {
+		return nil
+	}
+	quota, _, err := getUserMediaQuota(ctx, cfg, db, r.MediaMetadata.UserID)
+	if err != nil {
+		r.Logger.WithError(err).Error("Failed to get media quota")
+		resErr := jsonerror.InternalServerError()
+		return &resErr
+	}
+	if quota == 0 {
+		return nil
+	}
+	used, err := db.GetUserMediaUsage(ctx, r.MediaMetadata.UserID)
+	if err != nil {
+		r.Logger.WithError(err).Error("db.GetUserMediaUsage failed")
+		resErr := jsonerror.InternalServerError()
+		return &resErr
+	}
+	if used+size > quota {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.ResourceLimitExceeded(fmt.Sprintf(
+				"Uploading this file would exceed your media quota of %d bytes (%d bytes used)", quota, used,
+			)),
+		}
+	}
+	return nil
+}
+
+// GetMediaUsage implements GET /usage
+// This returns how much the user has uploaded and how much they are allowed to.
+func GetMediaUsage(
+	req *http.Request, device *userapi.Device, cfg *config.MediaAPI, db storage.Database,
+) util.JSONResponse {
+	userID := types.MatrixUserID(device.UserID)
+	quota, _, err := getUserMediaQuota(req.Context(), cfg, db, userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to get media quota")
+		return jsonerror.InternalServerError()
+	}
+	used, err := db.GetUserMediaUsage(req.Context(), userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetUserMediaUsage failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: mediaUsageResponse{
+			UsedBytes:  used,
+			QuotaBytes: quota,
+		},
+	}
+}
+
+// AdminGetUserMediaQuota implements GET /admin/users/{userID}/media_quota
+func AdminGetUserMediaQuota(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string,
+) util.JSONResponse {
+	if resErr := requireLocalUser(cfg, userID); resErr != nil {
+		return *resErr
+	}
+	quota, custom, err := getUserMediaQuota(req.Context(), cfg, db, types.MatrixUserID(userID))
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to get media quota")
+		return jsonerror.InternalServerError()
+	}
+	used, err := db.GetUserMediaUsage(req.Context(), types.MatrixUserID(userID))
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetUserMediaUsage failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: adminMediaQuotaResponse{
+			UserID:     userID,
+			UsedBytes:  used,
+			QuotaBytes: quota,
+			Custom:     custom,
+		},
+	}
+}
+
+// AdminSetUserMediaQuota implements PUT /admin/users/{userID}/media_quota
+// This gives the user a quota other than the default. A quota of 0 lets the
+// user upload as much as they like.
+func AdminSetUserMediaQuota(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string,
+) util.JSONResponse {
+	if resErr := requireLocalUser(cfg, userID); resErr != nil {
+		return *resErr
+	}
+	var r adminSetMediaQuotaRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.QuotaBytes == nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("Missing quota_bytes"),
+		}
+	}
+	if *r.QuotaBytes < 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("quota_bytes must not be negative"),
+		}
+	}
+	if err := db.SetUserMediaQuota(req.Context(), types.MatrixUserID(userID), *r.QuotaBytes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.SetUserMediaQuota failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// AdminRemoveUserMediaQuota implements DELETE /admin/users/{userID}/media_quota
+// This puts the user back on the default quota.
+func AdminRemoveUserMediaQuota(
+	req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string,
+) util.JSONResponse {
+	if resErr := requireLocalUser(cfg, userID); resErr != nil {
+		return *resErr
+	}
+	if err := db.RemoveUserMediaQuota(req.Context(), types.MatrixUserID(userID)); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.RemoveUserMediaQuota failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
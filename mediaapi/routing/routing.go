@@ -20,6 +20,7 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/internal/httputil"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -45,8 +46,10 @@ type configResponse struct {
 // nolint: gocyclo
 func Setup(
 	publicAPIMux *mux.Router,
+	dendriteAdminRouter *mux.Router,
 	cfg *config.MediaAPI,
 	rateLimit *config.RateLimiting,
+	federationDomains *config.FederationDomains,
 	db storage.Database,
 	userAPI userapi.UserInternalAPI,
 	client *gomatrixserverlib.Client,
@@ -55,6 +58,7 @@ func Setup(
 
 	r0mux := publicAPIMux.PathPrefix("/r0").Subrouter()
 	v1mux := publicAPIMux.PathPrefix("/v1").Subrouter()
+	unstableMux := publicAPIMux.PathPrefix("/unstable").Subrouter()
 
 	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
 		PathToResult: map[string]*types.ThumbnailGenerationResult{},
@@ -83,26 +87,122 @@ func Setup(
 	r0mux.Handle("/upload", uploadHandler).Methods(http.MethodPost, http.MethodOptions)
 	r0mux.Handle("/config", configHandler).Methods(http.MethodGet, http.MethodOptions)
 	v1mux.Handle("/upload", uploadHandler).Methods(http.MethodPost, http.MethodOptions)
+	unstableMux.Handle("/org.matrix.dendrite/usage", httputil.MakeAuthAPI(
+		"media_usage", userAPI,
+		func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			if r := rateLimits.Limit(req); r != nil {
+				return *r
+			}
+			return GetMediaUsage(req, dev, cfg, db)
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	if cfg.URLPreviews.Enabled {
+		urlPreviewClient := newURLPreviewClient(&cfg.URLPreviews)
+		r0mux.Handle("/preview_url", httputil.MakeAuthAPI(
+			"preview_url", userAPI,
+			func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+				if r := rateLimits.Limit(req); r != nil {
+					return *r
+				}
+				return PreviewURL(req, cfg, dev, db, urlPreviewClient, activeThumbnailGeneration)
+			},
+		)).Methods(http.MethodGet, http.MethodOptions)
+	}
 
 	activeRemoteRequests := &types.ActiveRemoteRequests{
 		MXCToResult: map[string]*types.RemoteRequestResult{},
 	}
 
-	downloadHandler := makeDownloadAPI("download", cfg, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration)
+	downloadHandler := makeDownloadAPI("download", cfg, rateLimits, federationDomains, db, client, activeRemoteRequests, activeThumbnailGeneration)
 	r0mux.Handle("/download/{serverName}/{mediaId}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 	r0mux.Handle("/download/{serverName}/{mediaId}/{downloadName}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 	v1mux.Handle("/download/{serverName}/{mediaId}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)                // TODO: remove when synapse is fixed
 	v1mux.Handle("/download/{serverName}/{mediaId}/{downloadName}", downloadHandler).Methods(http.MethodGet, http.MethodOptions) // TODO: remove when synapse is fixed
 
 	r0mux.Handle("/thumbnail/{serverName}/{mediaId}",
-		makeDownloadAPI("thumbnail", cfg, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration),
+		makeDownloadAPI("thumbnail", cfg, rateLimits, federationDomains, db, client, activeRemoteRequests, activeThumbnailGeneration),
 	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/media/quarantine/{serverName}/{mediaId}",
+		httputil.MakeAdminAPI("admin_quarantine_media", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminQuarantineMedia(req, db, gomatrixserverlib.ServerName(vars["serverName"]), types.MediaID(vars["mediaId"]), true)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/media/unquarantine/{serverName}/{mediaId}",
+		httputil.MakeAdminAPI("admin_unquarantine_media", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminQuarantineMedia(req, db, gomatrixserverlib.ServerName(vars["serverName"]), types.MediaID(vars["mediaId"]), false)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/media/{serverName}/{mediaId}",
+		httputil.MakeAdminAPI("admin_delete_media", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminDeleteMedia(req, cfg, db, gomatrixserverlib.ServerName(vars["serverName"]), types.MediaID(vars["mediaId"]))
+		}),
+	).Methods(http.MethodDelete, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media",
+		httputil.MakeAdminAPI("admin_delete_user_media", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminDeleteUserMedia(req, cfg, db, vars["userID"])
+		}),
+	).Methods(http.MethodDelete, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media_quota",
+		httputil.MakeAdminAPI("admin_get_user_media_quota", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminGetUserMediaQuota(req, cfg, db, vars["userID"])
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media_quota",
+		httputil.MakeAdminAPI("admin_set_user_media_quota", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminSetUserMediaQuota(req, cfg, db, vars["userID"])
+		}),
+	).Methods(http.MethodPut, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media_quota",
+		httputil.MakeAdminAPI("admin_remove_user_media_quota", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminRemoveUserMediaQuota(req, cfg, db, vars["userID"])
+		}),
+	).Methods(http.MethodDelete, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/purge_media_cache",
+		httputil.MakeAdminAPI("admin_purge_media_cache", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			return AdminPurgeMediaCache(req, cfg, db)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	if cfg.RemoteMediaLifetimeDays > 0 {
+		go purgeRemoteMediaPeriodically(cfg, db)
+	}
 }
 
 func makeDownloadAPI(
 	name string,
 	cfg *config.MediaAPI,
 	rateLimits *httputil.RateLimits,
+	federationDomains *config.FederationDomains,
 	db storage.Database,
 	client *gomatrixserverlib.Client,
 	activeRemoteRequests *types.ActiveRemoteRequests,
@@ -146,6 +246,14 @@ func makeDownloadAPI(
 			}
 		}
 
+		// Don't serve or fetch media from servers that we aren't allowed to
+		// federate with.
+		if serverName != cfg.Matrix.ServerName && !federationDomains.IsAllowed(serverName) {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(jsonerror.Forbidden("Federation with this server is not allowed"))
+			return
+		}
+
 		Download(
 			w,
 			req,
@@ -54,6 +54,7 @@ type uploadResponse struct {
 // Upload implements POST /upload
 // This endpoint involves uploading potentially significant amounts of data to the homeserver.
 // This implementation supports a configurable maximum file size limit in bytes. If a user tries to upload more than this, they will receive an error that their upload is too large.
+// Users may also have a quota on the total size of their uploads, and uploads that would exceed it are rejected with M_RESOURCE_LIMIT_EXCEEDED.
 // Uploaded files are processed piece-wise to avoid DoS attacks which would starve the server of memory.
 // TODO: We should time out requests if they have not received any data within a configured timeout period.
 func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db storage.Database, activeThumbnailGeneration *types.ActiveThumbnailGeneration) util.JSONResponse {
@@ -62,6 +63,12 @@ func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db sto
 		return *resErr
 	}
 
+	// Reject the upload before reading it if the client has told us that it
+	// won't fit in the user's quota. It is checked again once we have the file.
+	if resErr = r.checkQuota(req.Context(), cfg, db, r.MediaMetadata.FileSizeBytes); resErr != nil {
+		return *resErr
+	}
+
 	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, activeThumbnailGeneration); resErr != nil {
 		return *resErr
 	}
@@ -174,6 +181,11 @@ func (r *uploadRequest) doUpload(
 		return requestEntityTooLargeJSONResponse(*cfg.MaxFileSizeBytes)
 	}
 
+	if resErr := r.checkQuota(ctx, cfg, db, bytesWritten); resErr != nil {
+		fileutils.RemoveDir(tmpDir, r.Logger)
+		return resErr
+	}
+
 	// Look up the media by the file hash. If we already have the file but under a
 	// different media ID then we won't upload the file again - instead we'll just
 	// add a new metadata entry that refers to the same file.
@@ -3,12 +3,14 @@ package routing
 import (
 	"context"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/mediaapi/fileutils"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -46,6 +48,9 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 		DynamicThumbnails: false,
 	}
 
+	quotaCfg := *cfg
+	quotaCfg.DefaultUserQuotaBytes = 6
+
 	// create testdata folder and remove when done
 	_ = os.Mkdir(testdataPath, os.ModePerm)
 	defer fileutils.RemoveDir(types.Path(testdataPath), nil)
@@ -117,6 +122,45 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 			},
 			want: requestEntityTooLargeJSONResponse(maxSize),
 		},
+		{
+			name: "upload ok (within quota)",
+			args: args{
+				ctx:       context.Background(),
+				reqReader: strings.NewReader("quota"),
+				cfg:       &quotaCfg,
+				db:        db,
+			},
+			fields: fields{
+				Logger: logger,
+				MediaMetadata: &types.MediaMetadata{
+					MediaID:    "1340",
+					UploadName: "test quota ok",
+					UserID:     "@quota:test",
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "upload not ok (exceeds quota)",
+			args: args{
+				ctx:       context.Background(),
+				reqReader: strings.NewReader("quota2"),
+				cfg:       &quotaCfg,
+				db:        db,
+			},
+			fields: fields{
+				Logger: logger,
+				MediaMetadata: &types.MediaMetadata{
+					MediaID:    "1341",
+					UploadName: "test quota fail",
+					UserID:     "@quota:test",
+				},
+			},
+			want: &util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.ResourceLimitExceeded("Uploading this file would exceed your media quota of 6 bytes (5 bytes used)"),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
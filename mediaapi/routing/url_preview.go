@@ -0,0 +1,424 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"  // register the GIF decoder for image.DecodeConfig
+	_ "image/jpeg" // register the JPEG decoder for image.DecodeConfig
+	_ "image/png"  // register the PNG decoder for image.DecodeConfig
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The longest description we will return in a preview, in characters
+const maxPreviewDescriptionLength = 500
+
+// PreviewURL implements GET /preview_url
+// The URL is fetched by the server, and the OpenGraph metadata of the page is
+// returned to the client. The preview image, if there is one, is downloaded
+// into the media store so that clients don't need to fetch it themselves.
+// Previews are cached for url_previews.cache_lifetime.
+func PreviewURL(
+	req *http.Request,
+	cfg *config.MediaAPI,
+	dev *userapi.Device,
+	db storage.Database,
+	client *http.Client,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+) util.JSONResponse {
+	rawURL := req.URL.Query().Get("url")
+	if rawURL == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("Missing url parameter"),
+		}
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("The url parameter must be an absolute HTTP or HTTPS URL"),
+		}
+	}
+	target.Fragment = ""
+
+	logger := util.GetLogger(req.Context()).WithField("url", target.String())
+
+	preview, err := db.GetURLPreview(req.Context(), target.String())
+	if err != nil {
+		logger.WithError(err).Error("db.GetURLPreview failed")
+		return jsonerror.InternalServerError()
+	}
+	if preview == nil || preview.ExpiresTimestamp < types.UnixMs(time.Now().UnixNano()/1000000) {
+		preview, err = generateURLPreview(req.Context(), target, cfg, db, client, activeThumbnailGeneration, logger)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to preview URL")
+			return util.JSONResponse{
+				Code: http.StatusBadGateway,
+				JSON: jsonerror.Unknown("Failed to preview URL"),
+			}
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: json.RawMessage(preview.OpenGraph),
+	}
+}
+
+// generateURLPreview fetches the URL and builds a preview from it, which is
+// then stored in the cache.
+func generateURLPreview(
+	ctx context.Context,
+	target *url.URL,
+	cfg *config.MediaAPI,
+	db storage.Database,
+	client *http.Client,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	logger *log.Entry,
+) (*types.URLPreview, error) {
+	resp, err := fetchForPreview(ctx, client, target.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body := io.LimitReader(resp.Body, int64(cfg.URLPreviews.MaxSpiderSize))
+
+	// The response may have come from somewhere else if we were redirected,
+	// so relative URLs in the page need to be resolved against that instead.
+	finalURL := resp.Request.URL
+
+	og := map[string]interface{}{}
+	var imageMediaID types.MediaID
+	switch contentType := resp.Header.Get("Content-Type"); {
+	case isImage(contentType):
+		og, imageMediaID, err = storePreviewImage(ctx, body, contentType, finalURL, cfg, db, activeThumbnailGeneration, logger)
+		if err != nil {
+			return nil, err
+		}
+	case isHTML(contentType):
+		og = parseHTMLPreview(body, finalURL)
+		if imageURL, ok := og["og:image"].(string); ok {
+			// The og:image we return must be an mxc:// URL, so remove it
+			// until we have downloaded the image ourselves.
+			delete(og, "og:image")
+			imageOG, mediaID, imageErr := fetchPreviewImage(ctx, client, imageURL, cfg, db, activeThumbnailGeneration, logger)
+			if imageErr != nil {
+				logger.WithError(imageErr).WithField("image_url", imageURL).Warn("Failed to fetch preview image")
+			} else {
+				for k, v := range imageOG {
+					og[k] = v
+				}
+				imageMediaID = mediaID
+			}
+		}
+	}
+
+	ogJSON, err := json.Marshal(og)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	now := time.Now()
+	preview := &types.URLPreview{
+		URL:               target.String(),
+		OpenGraph:         ogJSON,
+		ImageMediaID:      imageMediaID,
+		CreationTimestamp: types.UnixMs(now.UnixNano() / 1000000),
+		ExpiresTimestamp:  types.UnixMs(now.Add(cfg.URLPreviews.CacheLifetime).UnixNano() / 1000000),
+	}
+	if err = db.StoreURLPreview(ctx, preview); err != nil {
+		// We still have a preview to return, it just won't be cached.
+		logger.WithError(err).Error("db.StoreURLPreview failed")
+	}
+	return preview, nil
+}
+
+// fetchPreviewImage downloads the image at imageURL into the media store.
+func fetchPreviewImage(
+	ctx context.Context,
+	client *http.Client,
+	imageURL string,
+	cfg *config.MediaAPI,
+	db storage.Database,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	logger *log.Entry,
+) (map[string]interface{}, types.MediaID, error) {
+	resp, err := fetchForPreview(ctx, client, imageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	contentType := resp.Header.Get("Content-Type")
+	if !isImage(contentType) {
+		return nil, "", fmt.Errorf("unexpected content type %q", contentType)
+	}
+	body := io.LimitReader(resp.Body, int64(cfg.URLPreviews.MaxSpiderSize))
+	return storePreviewImage(ctx, body, contentType, resp.Request.URL, cfg, db, activeThumbnailGeneration, logger)
+}
+
+// storePreviewImage stores the image as though it had been uploaded, which also
+// generates thumbnails for it, and returns the OpenGraph image metadata. Previews
+// are shared between users, so the image isn't counted towards anyone's quota.
+func storePreviewImage(
+	ctx context.Context,
+	body io.Reader,
+	contentType string,
+	source *url.URL,
+	cfg *config.MediaAPI,
+	db storage.Database,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	logger *log.Entry,
+) (map[string]interface{}, types.MediaID, error) {
+	filename := path.Base(source.Path)
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	r := &uploadRequest{
+		MediaMetadata: &types.MediaMetadata{
+			Origin:      cfg.Matrix.ServerName,
+			ContentType: types.ContentType(contentType),
+			UploadName:  types.Filename(url.PathEscape(filename)),
+		},
+		Logger: logger,
+	}
+	if resErr := r.doUpload(ctx, body, cfg, db, activeThumbnailGeneration); resErr != nil {
+		return nil, "", fmt.Errorf("failed to store image: %v", resErr.JSON)
+	}
+
+	og := map[string]interface{}{
+		"og:image":          fmt.Sprintf("mxc://%s/%s", cfg.Matrix.ServerName, r.MediaMetadata.MediaID),
+		"og:image:type":     contentType,
+		"matrix:image:size": r.MediaMetadata.FileSizeBytes,
+	}
+	if width, height, err := imageDimensions(r.MediaMetadata.Base64Hash, cfg.AbsBasePath); err == nil {
+		og["og:image:width"] = width
+		og["og:image:height"] = height
+	}
+	return og, r.MediaMetadata.MediaID, nil
+}
+
+// imageDimensions returns the width and height of a stored image, if it is in
+// a format that we can decode.
+func imageDimensions(hash types.Base64Hash, absBasePath config.Path) (int, int, error) {
+	filePath, err := fileutils.GetPathFromBase64Hash(hash, absBasePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close() // nolint: errcheck
+	imgConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return imgConfig.Width, imgConfig.Height, nil
+}
+
+func fetchForPreview(ctx context.Context, client *http.Client, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Dendrite (URL preview)")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response %q", resp.Status)
+	}
+	return resp, nil
+}
+
+func isImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "image/")
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// parseHTMLPreview extracts the OpenGraph metadata from an HTML document. The
+// <title> and description of the page are used in place of og:title and
+// og:description if the page doesn't have them. og:image is resolved against
+// the URL of the page.
+func parseHTMLPreview(r io.Reader, pageURL *url.URL) map[string]interface{} {
+	og := map[string]interface{}{}
+	var title, description string
+	inTitle := false
+
+	z := html.NewTokenizer(r)
+tokens:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// Either the end of the document or the end of what we were
+			// willing to download.
+			break tokens
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			case atom.Title:
+				inTitle = token.Type == html.StartTagToken
+			case atom.Meta:
+				var property, name, content string
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property":
+						property = strings.ToLower(attr.Val)
+					case "name":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				if property == "" && strings.HasPrefix(name, "og:") {
+					// Some sites put OpenGraph properties into the name
+					// attribute instead.
+					property = name
+				}
+				if content == "" {
+					continue
+				}
+				if strings.HasPrefix(property, "og:") {
+					if _, ok := og[property]; !ok {
+						og[property] = content
+					}
+				} else if name == "description" && description == "" {
+					description = content
+				}
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.Title {
+				inTitle = false
+			}
+		}
+	}
+
+	if _, ok := og["og:title"]; !ok && title != "" {
+		og["og:title"] = title
+	}
+	if _, ok := og["og:description"]; !ok && description != "" {
+		og["og:description"] = description
+	}
+	if desc, ok := og["og:description"].(string); ok {
+		if runes := []rune(desc); len(runes) > maxPreviewDescriptionLength {
+			og["og:description"] = string(runes[:maxPreviewDescriptionLength]) + "…"
+		}
+	}
+	if imageRef, ok := og["og:image"].(string); ok {
+		imageURL, err := pageURL.Parse(imageRef)
+		if err != nil || (imageURL.Scheme != "http" && imageURL.Scheme != "https") {
+			delete(og, "og:image")
+		} else {
+			og["og:image"] = imageURL.String()
+		}
+	}
+	return og
+}
+
+// newURLPreviewClient returns an HTTP client for fetching URLs to preview. It
+// refuses to connect to addresses in the IP range blacklist, which is checked
+// after DNS resolution and on every redirect, so that URL previews can't be
+// used to reach services on the internal network.
+func newURLPreviewClient(cfg *config.URLPreviews) *http.Client {
+	blacklist := parseCIDRs(cfg.IPRangeBlacklist)
+	whitelist := parseCIDRs(cfg.IPRangeWhitelist)
+	dialer := &net.Dialer{
+		Timeout: time.Second * 10,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isIPAllowed(ip, blacklist, whitelist) {
+				return fmt.Errorf("connecting to %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: time.Second * 30,
+		Transport: &http.Transport{
+			// Proxy is deliberately left unset, as connections through a
+			// proxy would bypass the IP range checks.
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   time.Second * 10,
+			ResponseHeaderTimeout: time.Second * 10,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Second * 90,
+		},
+	}
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		// The config has already been verified, so invalid ranges won't
+		// get this far.
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// isIPAllowed returns true if the IP address is in the whitelist or is not in
+// the blacklist.
+func isIPAllowed(ip net.IP, blacklist, whitelist []*net.IPNet) bool {
+	for _, ipNet := range whitelist {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, ipNet := range blacklist {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseHTMLPreview(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post.html")
+	tests := []struct {
+		name string
+		html string
+		want map[string]interface{}
+	}{
+		{
+			name: "OpenGraph tags",
+			html: `<html><head>
+				<title>Page title</title>
+				<meta property="og:title" content="OpenGraph title">
+				<meta property="og:description" content="OpenGraph description">
+				<meta name="description" content="Page description">
+				<meta property="og:image" content="/images/cat.png">
+				<meta property="og:site_name" content="Example">
+			</head><body></body></html>`,
+			want: map[string]interface{}{
+				"og:title":       "OpenGraph title",
+				"og:description": "OpenGraph description",
+				"og:image":       "https://example.com/images/cat.png",
+				"og:site_name":   "Example",
+			},
+		},
+		{
+			name: "falls back to title and description",
+			html: `<html><head>
+				<title> Page title </title>
+				<meta name="description" content="Page description">
+			</head><body><title>Not the title</title></body></html>`,
+			want: map[string]interface{}{
+				"og:title":       "Page title",
+				"og:description": "Page description",
+			},
+		},
+		{
+			name: "ignores non-HTTP images",
+			html: `<meta property="og:image" content="javascript:alert(1)">`,
+			want: map[string]interface{}{},
+		},
+		{
+			name: "no metadata",
+			html: `<p>Hello world</p>`,
+			want: map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseHTMLPreview(strings.NewReader(tt.html), pageURL); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHTMLPreview() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isIPAllowed(t *testing.T) {
+	blacklist := parseCIDRs([]string{"127.0.0.0/8", "10.0.0.0/8", "::1/128"})
+	whitelist := parseCIDRs([]string{"10.1.2.0/24"})
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"10.1.2.3", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+	}
+	for _, tt := range tests {
+		if got := isIPAllowed(net.ParseIP(tt.ip), blacklist, whitelist); got != tt.want {
+			t.Errorf("isIPAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
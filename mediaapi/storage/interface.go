@@ -28,4 +28,16 @@ type Database interface {
 	StoreThumbnail(ctx context.Context, thumbnailMetadata *types.ThumbnailMetadata) error
 	GetThumbnail(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, width, height int, resizeMethod string) (*types.ThumbnailMetadata, error)
 	GetThumbnails(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName) ([]*types.ThumbnailMetadata, error)
+	StoreURLPreview(ctx context.Context, preview *types.URLPreview) error
+	GetURLPreview(ctx context.Context, url string) (*types.URLPreview, error)
+	UpdateMediaLastAccess(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, lastAccessTS types.UnixMs) error
+	SetMediaQuarantined(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, quarantined bool) error
+	GetMediaByUser(ctx context.Context, userID types.MatrixUserID) ([]*types.MediaMetadata, error)
+	GetRemoteMediaLastAccessedBefore(ctx context.Context, localServerName gomatrixserverlib.ServerName, before types.UnixMs) ([]*types.MediaMetadata, error)
+	GetMediaCountByHash(ctx context.Context, mediaHash types.Base64Hash) (int64, error)
+	DeleteMedia(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName) error
+	GetUserMediaUsage(ctx context.Context, userID types.MatrixUserID) (types.FileSizeBytes, error)
+	GetUserMediaQuota(ctx context.Context, userID types.MatrixUserID) (*types.FileSizeBytes, error)
+	SetUserMediaQuota(ctx context.Context, userID types.MatrixUserID, quota types.FileSizeBytes) error
+	RemoveUserMediaQuota(ctx context.Context, userID types.MatrixUserID) error
 }
@@ -0,0 +1,50 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadMediaRetention(m *sqlutil.Migrations) {
+	m.AddMigration(UpMediaRetention, DownMediaRetention)
+}
+
+// UpMediaRetention tracks when media was last accessed and whether it has
+// been quarantined. Existing media is treated as last accessed when it was
+// created.
+func UpMediaRetention(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mediaapi_media_repository ADD COLUMN IF NOT EXISTS last_access_ts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE mediaapi_media_repository ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE mediaapi_media_repository SET last_access_ts = creation_ts WHERE last_access_ts = 0;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownMediaRetention(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mediaapi_media_repository DROP COLUMN last_access_ts;
+ALTER TABLE mediaapi_media_repository DROP COLUMN quarantined;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
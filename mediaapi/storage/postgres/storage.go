@@ -22,6 +22,7 @@ import (
 	// Import the postgres database driver.
 	_ "github.com/lib/pq"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/storage/postgres/deltas"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -40,6 +41,16 @@ func Open(dbProperties *config.DatabaseOptions) (*Database, error) {
 	if d.db, err = sqlutil.Open(dbProperties); err != nil {
 		return nil, err
 	}
+	// Create tables before executing migrations so we don't fail if the table is missing,
+	// and THEN prepare statements so we don't fail due to referencing new columns
+	if err = d.statements.media.execSchema(d.db); err != nil {
+		return nil, err
+	}
+	m := sqlutil.NewMigrations()
+	deltas.LoadMediaRetention(m)
+	if err = m.RunDeltas(d.db, dbProperties); err != nil {
+		return nil, err
+	}
 	if err = d.statements.prepare(d.db); err != nil {
 		return nil, err
 	}
@@ -119,3 +130,91 @@ func (d *Database) GetThumbnails(
 	}
 	return thumbnails, err
 }
+
+func (d *Database) StoreURLPreview(
+	ctx context.Context, preview *types.URLPreview,
+) error {
+	return d.statements.urlPreview.upsertURLPreview(ctx, preview)
+}
+
+func (d *Database) GetURLPreview(
+	ctx context.Context, url string,
+) (*types.URLPreview, error) {
+	preview, err := d.statements.urlPreview.selectURLPreview(ctx, url)
+	if err != nil && err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return preview, err
+}
+
+func (d *Database) UpdateMediaLastAccess(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, lastAccessTS types.UnixMs,
+) error {
+	return d.statements.media.updateMediaLastAccess(ctx, mediaID, mediaOrigin, lastAccessTS)
+}
+
+func (d *Database) SetMediaQuarantined(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, quarantined bool,
+) error {
+	return d.statements.media.updateMediaQuarantined(ctx, mediaID, mediaOrigin, quarantined)
+}
+
+func (d *Database) GetMediaByUser(
+	ctx context.Context, userID types.MatrixUserID,
+) ([]*types.MediaMetadata, error) {
+	return d.statements.media.selectMediaByUser(ctx, userID)
+}
+
+func (d *Database) GetRemoteMediaLastAccessedBefore(
+	ctx context.Context, localServerName gomatrixserverlib.ServerName, before types.UnixMs,
+) ([]*types.MediaMetadata, error) {
+	return d.statements.media.selectRemoteMediaLastAccessedBefore(ctx, localServerName, before)
+}
+
+func (d *Database) GetMediaCountByHash(
+	ctx context.Context, mediaHash types.Base64Hash,
+) (int64, error) {
+	return d.statements.media.selectMediaCountByHash(ctx, mediaHash)
+}
+
+func (d *Database) DeleteMedia(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName,
+) error {
+	return sqlutil.WithTransaction(d.db, func(txn *sql.Tx) error {
+		if err := d.statements.thumbnail.deleteThumbnails(ctx, txn, mediaID, mediaOrigin); err != nil {
+			return err
+		}
+		return d.statements.media.deleteMedia(ctx, txn, mediaID, mediaOrigin)
+	})
+}
+
+func (d *Database) GetUserMediaUsage(
+	ctx context.Context, userID types.MatrixUserID,
+) (types.FileSizeBytes, error) {
+	return d.statements.media.selectUserMediaUsage(ctx, userID)
+}
+
+func (d *Database) GetUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) (*types.FileSizeBytes, error) {
+	quota, err := d.statements.userQuota.selectUserQuota(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (d *Database) SetUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID, quota types.FileSizeBytes,
+) error {
+	return d.statements.userQuota.upsertUserQuota(ctx, userID, quota)
+}
+
+func (d *Database) RemoveUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) error {
+	return d.statements.userQuota.deleteUserQuota(ctx, userID)
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/mediaapi/types"
+)
+
+const userQuotaSchema = `
+-- The mediaapi_user_quota table holds the media quotas that admins have set
+-- for individual users, in place of the default quota.
+CREATE TABLE IF NOT EXISTS mediaapi_user_quota (
+    -- The local user ID.
+    user_id TEXT NOT NULL PRIMARY KEY,
+    -- The maximum total size of the media the user may upload, or 0 for no limit.
+    quota_bytes BIGINT NOT NULL
+);
+`
+
+const upsertUserQuotaSQL = `
+INSERT INTO mediaapi_user_quota (user_id, quota_bytes) VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET quota_bytes = $2
+`
+
+const selectUserQuotaSQL = `
+SELECT quota_bytes FROM mediaapi_user_quota WHERE user_id = $1
+`
+
+const deleteUserQuotaSQL = `
+DELETE FROM mediaapi_user_quota WHERE user_id = $1
+`
+
+type userQuotaStatements struct {
+	upsertUserQuotaStmt *sql.Stmt
+	selectUserQuotaStmt *sql.Stmt
+	deleteUserQuotaStmt *sql.Stmt
+}
+
+func (s *userQuotaStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(userQuotaSchema)
+	if err != nil {
+		return
+	}
+
+	return statementList{
+		{&s.upsertUserQuotaStmt, upsertUserQuotaSQL},
+		{&s.selectUserQuotaStmt, selectUserQuotaSQL},
+		{&s.deleteUserQuotaStmt, deleteUserQuotaSQL},
+	}.prepare(db)
+}
+
+func (s *userQuotaStatements) upsertUserQuota(
+	ctx context.Context, userID types.MatrixUserID, quota types.FileSizeBytes,
+) error {
+	_, err := s.upsertUserQuotaStmt.ExecContext(ctx, userID, quota)
+	return err
+}
+
+func (s *userQuotaStatements) selectUserQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) (quota types.FileSizeBytes, err error) {
+	err = s.selectUserQuotaStmt.QueryRowContext(ctx, userID).Scan(&quota)
+	return
+}
+
+func (s *userQuotaStatements) deleteUserQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) error {
+	_, err := s.deleteUserQuotaStmt.ExecContext(ctx, userID)
+	return err
+}
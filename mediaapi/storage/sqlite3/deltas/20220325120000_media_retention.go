@@ -0,0 +1,86 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadMediaRetention(m *sqlutil.Migrations) {
+	m.AddMigration(UpMediaRetention, DownMediaRetention)
+}
+
+// UpMediaRetention tracks when media was last accessed and whether it has
+// been quarantined. Existing media is treated as last accessed when it was
+// created.
+func UpMediaRetention(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    ALTER TABLE mediaapi_media_repository RENAME TO mediaapi_media_repository_tmp;
+    CREATE TABLE mediaapi_media_repository (
+        media_id TEXT NOT NULL,
+        media_origin TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        file_size_bytes INTEGER NOT NULL,
+        creation_ts INTEGER NOT NULL,
+        upload_name TEXT NOT NULL,
+        base64hash TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        last_access_ts INTEGER NOT NULL DEFAULT 0,
+        quarantined BOOLEAN NOT NULL DEFAULT FALSE
+    );
+    INSERT
+    INTO mediaapi_media_repository (
+        media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts
+    )  SELECT
+           media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, creation_ts
+    FROM mediaapi_media_repository_tmp;
+    DROP TABLE mediaapi_media_repository_tmp;
+    CREATE UNIQUE INDEX IF NOT EXISTS mediaapi_media_repository_index ON mediaapi_media_repository (media_id, media_origin);
+    CREATE INDEX IF NOT EXISTS mediaapi_media_repository_user_id_idx ON mediaapi_media_repository (user_id);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownMediaRetention(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    ALTER TABLE mediaapi_media_repository RENAME TO mediaapi_media_repository_tmp;
+    CREATE TABLE mediaapi_media_repository (
+        media_id TEXT NOT NULL,
+        media_origin TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        file_size_bytes INTEGER NOT NULL,
+        creation_ts INTEGER NOT NULL,
+        upload_name TEXT NOT NULL,
+        base64hash TEXT NOT NULL,
+        user_id TEXT NOT NULL
+    );
+    INSERT
+    INTO mediaapi_media_repository (
+        media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id
+    )  SELECT
+           media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id
+    FROM mediaapi_media_repository_tmp;
+    DROP TABLE mediaapi_media_repository_tmp;
+    CREATE UNIQUE INDEX IF NOT EXISTS mediaapi_media_repository_index ON mediaapi_media_repository (media_id, media_origin);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -46,45 +47,93 @@ CREATE TABLE IF NOT EXISTS mediaapi_media_repository (
     -- Alternate RFC 4648 unpadded base64 encoding string representation of a SHA-256 hash sum of the file data.
     base64hash TEXT NOT NULL,
     -- The user who uploaded the file. Should be a Matrix user ID.
-    user_id TEXT NOT NULL
+    user_id TEXT NOT NULL,
+    -- When the media was last downloaded in UNIX epoch ms. Remote media which hasn't been
+    -- accessed for a while is purged.
+    last_access_ts INTEGER NOT NULL DEFAULT 0,
+    -- Whether an admin has quarantined the media, in which case it is not served.
+    quarantined BOOLEAN NOT NULL DEFAULT FALSE
 );
 CREATE UNIQUE INDEX IF NOT EXISTS mediaapi_media_repository_index ON mediaapi_media_repository (media_id, media_origin);
+CREATE INDEX IF NOT EXISTS mediaapi_media_repository_user_id_idx ON mediaapi_media_repository (user_id);
 `
 
 const insertMediaSQL = `
-INSERT INTO mediaapi_media_repository (media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+INSERT INTO mediaapi_media_repository (media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 `
 
 const selectMediaSQL = `
-SELECT content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
+SELECT content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
 `
 
 const selectMediaByHashSQL = `
 SELECT content_type, file_size_bytes, creation_ts, upload_name, media_id, user_id FROM mediaapi_media_repository WHERE base64hash = $1 AND media_origin = $2
 `
 
+const updateMediaLastAccessSQL = `
+UPDATE mediaapi_media_repository SET last_access_ts = $1 WHERE media_id = $2 AND media_origin = $3
+`
+
+const updateMediaQuarantinedSQL = `
+UPDATE mediaapi_media_repository SET quarantined = $1 WHERE media_id = $2 AND media_origin = $3
+`
+
+const selectMediaByUserSQL = `
+SELECT media_id, media_origin, base64hash FROM mediaapi_media_repository WHERE user_id = $1
+`
+
+const selectRemoteMediaLastAccessedBeforeSQL = `
+SELECT media_id, media_origin, base64hash FROM mediaapi_media_repository WHERE media_origin != $1 AND last_access_ts < $2
+`
+
+const selectMediaCountByHashSQL = `
+SELECT COUNT(*) FROM mediaapi_media_repository WHERE base64hash = $1
+`
+
+const selectUserMediaUsageSQL = `
+SELECT COALESCE(SUM(file_size_bytes), 0) FROM mediaapi_media_repository WHERE user_id = $1
+`
+
+const deleteMediaSQL = `
+DELETE FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
+`
+
 type mediaStatements struct {
-	db                    *sql.DB
-	writer                sqlutil.Writer
-	insertMediaStmt       *sql.Stmt
-	selectMediaStmt       *sql.Stmt
-	selectMediaByHashStmt *sql.Stmt
+	db                                      *sql.DB
+	writer                                  sqlutil.Writer
+	insertMediaStmt                         *sql.Stmt
+	selectMediaStmt                         *sql.Stmt
+	selectMediaByHashStmt                   *sql.Stmt
+	updateMediaLastAccessStmt               *sql.Stmt
+	updateMediaQuarantinedStmt              *sql.Stmt
+	selectMediaByUserStmt                   *sql.Stmt
+	selectRemoteMediaLastAccessedBeforeStmt *sql.Stmt
+	selectMediaCountByHashStmt              *sql.Stmt
+	selectUserMediaUsageStmt                *sql.Stmt
+	deleteMediaStmt                         *sql.Stmt
+}
+
+func (s *mediaStatements) execSchema(db *sql.DB) error {
+	_, err := db.Exec(mediaSchema)
+	return err
 }
 
 func (s *mediaStatements) prepare(db *sql.DB, writer sqlutil.Writer) (err error) {
 	s.db = db
 	s.writer = writer
 
-	_, err = db.Exec(mediaSchema)
-	if err != nil {
-		return
-	}
-
 	return statementList{
 		{&s.insertMediaStmt, insertMediaSQL},
 		{&s.selectMediaStmt, selectMediaSQL},
 		{&s.selectMediaByHashStmt, selectMediaByHashSQL},
+		{&s.updateMediaLastAccessStmt, updateMediaLastAccessSQL},
+		{&s.updateMediaQuarantinedStmt, updateMediaQuarantinedSQL},
+		{&s.selectMediaByUserStmt, selectMediaByUserSQL},
+		{&s.selectRemoteMediaLastAccessedBeforeStmt, selectRemoteMediaLastAccessedBeforeSQL},
+		{&s.selectMediaCountByHashStmt, selectMediaCountByHashSQL},
+		{&s.selectUserMediaUsageStmt, selectUserMediaUsageSQL},
+		{&s.deleteMediaStmt, deleteMediaSQL},
 	}.prepare(db)
 }
 
@@ -92,6 +141,7 @@ func (s *mediaStatements) insertMedia(
 	ctx context.Context, mediaMetadata *types.MediaMetadata,
 ) error {
 	mediaMetadata.CreationTimestamp = types.UnixMs(time.Now().UnixNano() / 1000000)
+	mediaMetadata.LastAccessTimestamp = mediaMetadata.CreationTimestamp
 	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
 		stmt := sqlutil.TxStmt(txn, s.insertMediaStmt)
 		_, err := stmt.ExecContext(
@@ -104,6 +154,7 @@ func (s *mediaStatements) insertMedia(
 			mediaMetadata.UploadName,
 			mediaMetadata.Base64Hash,
 			mediaMetadata.UserID,
+			mediaMetadata.LastAccessTimestamp,
 		)
 		return err
 	})
@@ -125,6 +176,8 @@ func (s *mediaStatements) selectMedia(
 		&mediaMetadata.UploadName,
 		&mediaMetadata.Base64Hash,
 		&mediaMetadata.UserID,
+		&mediaMetadata.LastAccessTimestamp,
+		&mediaMetadata.Quarantined,
 	)
 	return &mediaMetadata, err
 }
@@ -148,3 +201,76 @@ func (s *mediaStatements) selectMediaByHash(
 	)
 	return &mediaMetadata, err
 }
+
+func (s *mediaStatements) updateMediaLastAccess(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, lastAccessTS types.UnixMs,
+) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		_, err := sqlutil.TxStmt(txn, s.updateMediaLastAccessStmt).ExecContext(ctx, lastAccessTS, mediaID, mediaOrigin)
+		return err
+	})
+}
+
+func (s *mediaStatements) updateMediaQuarantined(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, quarantined bool,
+) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		_, err := sqlutil.TxStmt(txn, s.updateMediaQuarantinedStmt).ExecContext(ctx, quarantined, mediaID, mediaOrigin)
+		return err
+	})
+}
+
+func (s *mediaStatements) selectMediaByUser(
+	ctx context.Context, userID types.MatrixUserID,
+) ([]*types.MediaMetadata, error) {
+	rows, err := s.selectMediaByUserStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMediaIDs(ctx, rows)
+}
+
+func (s *mediaStatements) selectRemoteMediaLastAccessedBefore(
+	ctx context.Context, localServerName gomatrixserverlib.ServerName, before types.UnixMs,
+) ([]*types.MediaMetadata, error) {
+	rows, err := s.selectRemoteMediaLastAccessedBeforeStmt.QueryContext(ctx, localServerName, before)
+	if err != nil {
+		return nil, err
+	}
+	return scanMediaIDs(ctx, rows)
+}
+
+func (s *mediaStatements) selectMediaCountByHash(
+	ctx context.Context, mediaHash types.Base64Hash,
+) (count int64, err error) {
+	err = s.selectMediaCountByHashStmt.QueryRowContext(ctx, mediaHash).Scan(&count)
+	return
+}
+
+func (s *mediaStatements) selectUserMediaUsage(
+	ctx context.Context, userID types.MatrixUserID,
+) (usage types.FileSizeBytes, err error) {
+	err = s.selectUserMediaUsageStmt.QueryRowContext(ctx, userID).Scan(&usage)
+	return
+}
+
+func (s *mediaStatements) deleteMedia(
+	ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteMediaStmt).ExecContext(ctx, mediaID, mediaOrigin)
+	return err
+}
+
+// scanMediaIDs reads rows of media_id, media_origin and base64hash.
+func scanMediaIDs(ctx context.Context, rows *sql.Rows) ([]*types.MediaMetadata, error) {
+	defer internal.CloseAndLogIfError(ctx, rows, "scanMediaIDs: rows.close() failed")
+	var media []*types.MediaMetadata
+	for rows.Next() {
+		var mediaMetadata types.MediaMetadata
+		if err := rows.Scan(&mediaMetadata.MediaID, &mediaMetadata.Origin, &mediaMetadata.Base64Hash); err != nil {
+			return nil, err
+		}
+		media = append(media, &mediaMetadata)
+	}
+	return media, rows.Err()
+}
@@ -21,6 +21,7 @@ import (
 
 	// Import the postgres database driver.
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/storage/sqlite3/deltas"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -42,6 +43,16 @@ func Open(dbProperties *config.DatabaseOptions) (*Database, error) {
 	if d.db, err = sqlutil.Open(dbProperties); err != nil {
 		return nil, err
 	}
+	// Create tables before executing migrations so we don't fail if the table is missing,
+	// and THEN prepare statements so we don't fail due to referencing new columns
+	if err = d.statements.media.execSchema(d.db); err != nil {
+		return nil, err
+	}
+	m := sqlutil.NewMigrations()
+	deltas.LoadMediaRetention(m)
+	if err = m.RunDeltas(d.db, dbProperties); err != nil {
+		return nil, err
+	}
 	if err = d.statements.prepare(d.db, d.writer); err != nil {
 		return nil, err
 	}
@@ -121,3 +132,109 @@ func (d *Database) GetThumbnails(
 	}
 	return thumbnails, err
 }
+
+// StoreURLPreview inserts or replaces the cached preview of a URL.
+func (d *Database) StoreURLPreview(
+	ctx context.Context, preview *types.URLPreview,
+) error {
+	return d.statements.urlPreview.upsertURLPreview(ctx, preview)
+}
+
+// GetURLPreview returns the cached preview of a URL, which may have expired.
+// Returns nil if the URL has never been previewed.
+func (d *Database) GetURLPreview(
+	ctx context.Context, url string,
+) (*types.URLPreview, error) {
+	preview, err := d.statements.urlPreview.selectURLPreview(ctx, url)
+	if err != nil && err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return preview, err
+}
+
+// UpdateMediaLastAccess records when the media was last downloaded.
+func (d *Database) UpdateMediaLastAccess(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, lastAccessTS types.UnixMs,
+) error {
+	return d.statements.media.updateMediaLastAccess(ctx, mediaID, mediaOrigin, lastAccessTS)
+}
+
+// SetMediaQuarantined sets whether the media is quarantined. Quarantined media is not served.
+func (d *Database) SetMediaQuarantined(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName, quarantined bool,
+) error {
+	return d.statements.media.updateMediaQuarantined(ctx, mediaID, mediaOrigin, quarantined)
+}
+
+// GetMediaByUser returns the IDs, origins and hashes of all media uploaded by the user.
+func (d *Database) GetMediaByUser(
+	ctx context.Context, userID types.MatrixUserID,
+) ([]*types.MediaMetadata, error) {
+	return d.statements.media.selectMediaByUser(ctx, userID)
+}
+
+// GetRemoteMediaLastAccessedBefore returns the IDs, origins and hashes of all media
+// from other servers which hasn't been downloaded since the given time.
+func (d *Database) GetRemoteMediaLastAccessedBefore(
+	ctx context.Context, localServerName gomatrixserverlib.ServerName, before types.UnixMs,
+) ([]*types.MediaMetadata, error) {
+	return d.statements.media.selectRemoteMediaLastAccessedBefore(ctx, localServerName, before)
+}
+
+// GetMediaCountByHash returns how many media IDs, from any origin, refer to the file
+// with the given hash.
+func (d *Database) GetMediaCountByHash(
+	ctx context.Context, mediaHash types.Base64Hash,
+) (int64, error) {
+	return d.statements.media.selectMediaCountByHash(ctx, mediaHash)
+}
+
+// DeleteMedia removes the metadata of the media and its thumbnails. The files are
+// not removed.
+func (d *Database) DeleteMedia(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		if err := d.statements.thumbnail.deleteThumbnails(ctx, txn, mediaID, mediaOrigin); err != nil {
+			return err
+		}
+		return d.statements.media.deleteMedia(ctx, txn, mediaID, mediaOrigin)
+	})
+}
+
+// GetUserMediaUsage returns the total size of the media that the user has uploaded.
+func (d *Database) GetUserMediaUsage(
+	ctx context.Context, userID types.MatrixUserID,
+) (types.FileSizeBytes, error) {
+	return d.statements.media.selectUserMediaUsage(ctx, userID)
+}
+
+// GetUserMediaQuota returns the media quota that has been set for the user, or nil
+// if the user has the default quota.
+func (d *Database) GetUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) (*types.FileSizeBytes, error) {
+	quota, err := d.statements.userQuota.selectUserQuota(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// SetUserMediaQuota sets the media quota of the user, replacing the default quota.
+func (d *Database) SetUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID, quota types.FileSizeBytes,
+) error {
+	return d.statements.userQuota.upsertUserQuota(ctx, userID, quota)
+}
+
+// RemoveUserMediaQuota removes the media quota of the user, so that they have the
+// default quota again.
+func (d *Database) RemoveUserMediaQuota(
+	ctx context.Context, userID types.MatrixUserID,
+) error {
+	return d.statements.userQuota.deleteUserQuota(ctx, userID)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+)
+
+const urlPreviewSchema = `
+-- The mediaapi_url_preview table caches the previews generated for URLs so
+-- that we don't need to fetch the same URL every time a client asks for it.
+CREATE TABLE IF NOT EXISTS mediaapi_url_preview (
+    url TEXT NOT NULL PRIMARY KEY,
+    og TEXT NOT NULL,
+    image_media_id TEXT NOT NULL DEFAULT '',
+    creation_ts INTEGER NOT NULL,
+    expires_ts INTEGER NOT NULL
+);
+`
+
+const upsertURLPreviewSQL = `
+INSERT INTO mediaapi_url_preview (url, og, image_media_id, creation_ts, expires_ts)
+    VALUES ($1, $2, $3, $4, $5)
+    ON CONFLICT (url) DO UPDATE SET og = $2, image_media_id = $3, creation_ts = $4, expires_ts = $5
+`
+
+const selectURLPreviewSQL = `
+SELECT og, image_media_id, creation_ts, expires_ts FROM mediaapi_url_preview WHERE url = $1
+`
+
+type urlPreviewStatements struct {
+	db                   *sql.DB
+	writer               sqlutil.Writer
+	upsertURLPreviewStmt *sql.Stmt
+	selectURLPreviewStmt *sql.Stmt
+}
+
+func (s *urlPreviewStatements) prepare(db *sql.DB, writer sqlutil.Writer) (err error) {
+	_, err = db.Exec(urlPreviewSchema)
+	if err != nil {
+		return
+	}
+	s.db = db
+	s.writer = writer
+
+	return statementList{
+		{&s.upsertURLPreviewStmt, upsertURLPreviewSQL},
+		{&s.selectURLPreviewStmt, selectURLPreviewSQL},
+	}.prepare(db)
+}
+
+func (s *urlPreviewStatements) upsertURLPreview(
+	ctx context.Context, preview *types.URLPreview,
+) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		stmt := sqlutil.TxStmt(txn, s.upsertURLPreviewStmt)
+		_, err := stmt.ExecContext(
+			ctx,
+			preview.URL,
+			string(preview.OpenGraph),
+			preview.ImageMediaID,
+			preview.CreationTimestamp,
+			preview.ExpiresTimestamp,
+		)
+		return err
+	})
+}
+
+func (s *urlPreviewStatements) selectURLPreview(
+	ctx context.Context, url string,
+) (*types.URLPreview, error) {
+	preview := types.URLPreview{
+		URL: url,
+	}
+	var og string
+	err := s.selectURLPreviewStmt.QueryRowContext(ctx, url).Scan(
+		&og,
+		&preview.ImageMediaID,
+		&preview.CreationTimestamp,
+		&preview.ExpiresTimestamp,
+	)
+	preview.OpenGraph = []byte(og)
+	return &preview, err
+}
@@ -58,6 +58,23 @@ type MediaMetadata struct {
 	UploadName        Filename
 	Base64Hash        Base64Hash
 	UserID            MatrixUserID
+	// When the media was last downloaded, used to purge remote media
+	// that is no longer being used
+	LastAccessTimestamp UnixMs
+	// Quarantined media is not served to anyone
+	Quarantined bool
+}
+
+// URLPreview is the OpenGraph metadata generated for a URL, which is cached
+// until it expires
+type URLPreview struct {
+	URL string
+	// The JSON-encoded OpenGraph metadata returned to clients
+	OpenGraph []byte
+	// The ID of the local media holding the preview image, if there is one
+	ImageMediaID      MediaID
+	CreationTimestamp UnixMs
+	ExpiresTimestamp  UnixMs
 }
 
 // RemoteRequestResult is used for broadcasting the result of a request for a remote file to routines waiting on the condition
@@ -33,6 +33,12 @@ type RoomserverInternalAPI interface {
 		res *PerformJoinResponse,
 	)
 
+	PerformKnock(
+		ctx context.Context,
+		req *PerformKnockRequest,
+		res *PerformKnockResponse,
+	)
+
 	PerformLeave(
 		ctx context.Context,
 		req *PerformLeaveRequest,
@@ -57,6 +63,21 @@ type RoomserverInternalAPI interface {
 		res *PerformPublishResponse,
 	)
 
+	PerformRoomUpgrade(
+		ctx context.Context,
+		req *PerformRoomUpgradeRequest,
+		res *PerformRoomUpgradeResponse,
+	)
+
+	// PerformAdminRoomShutdown makes all local users leave a room, stops
+	// them from joining it again and removes it from the room directory,
+	// optionally purging the room entirely.
+	PerformAdminRoomShutdown(
+		ctx context.Context,
+		req *PerformAdminRoomShutdownRequest,
+		res *PerformAdminRoomShutdownResponse,
+	)
+
 	PerformInboundPeek(
 		ctx context.Context,
 		req *PerformInboundPeekRequest,
@@ -156,6 +177,9 @@ type RoomserverInternalAPI interface {
 	QueryKnownUsers(ctx context.Context, req *QueryKnownUsersRequest, res *QueryKnownUsersResponse) error
 	// QueryServerBannedFromRoom returns whether a server is banned from a room by server ACLs.
 	QueryServerBannedFromRoom(ctx context.Context, req *QueryServerBannedFromRoomRequest, res *QueryServerBannedFromRoomResponse) error
+	// QueryRestrictedJoinAllowed returns whether a user may join a room with a restricted join rule, and
+	// if so, which local user should authorise the join.
+	QueryRestrictedJoinAllowed(ctx context.Context, req *QueryRestrictedJoinAllowedRequest, res *QueryRestrictedJoinAllowedResponse) error
 
 	// Query a given amount (or less) of events prior to a given set of events.
 	PerformBackfill(
@@ -70,6 +70,15 @@ func (t *RoomserverInternalAPITrace) PerformJoin(
 	util.GetLogger(ctx).Infof("PerformJoin req=%+v res=%+v", js(req), js(res))
 }
 
+func (t *RoomserverInternalAPITrace) PerformKnock(
+	ctx context.Context,
+	req *PerformKnockRequest,
+	res *PerformKnockResponse,
+) {
+	t.Impl.PerformKnock(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformKnock req=%+v res=%+v", js(req), js(res))
+}
+
 func (t *RoomserverInternalAPITrace) PerformLeave(
 	ctx context.Context,
 	req *PerformLeaveRequest,
@@ -89,6 +98,24 @@ func (t *RoomserverInternalAPITrace) PerformPublish(
 	util.GetLogger(ctx).Infof("PerformPublish req=%+v res=%+v", js(req), js(res))
 }
 
+func (t *RoomserverInternalAPITrace) PerformRoomUpgrade(
+	ctx context.Context,
+	req *PerformRoomUpgradeRequest,
+	res *PerformRoomUpgradeResponse,
+) {
+	t.Impl.PerformRoomUpgrade(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformRoomUpgrade req=%+v res=%+v", js(req), js(res))
+}
+
+func (t *RoomserverInternalAPITrace) PerformAdminRoomShutdown(
+	ctx context.Context,
+	req *PerformAdminRoomShutdownRequest,
+	res *PerformAdminRoomShutdownResponse,
+) {
+	t.Impl.PerformAdminRoomShutdown(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformAdminRoomShutdown req=%+v res=%+v", js(req), js(res))
+}
+
 func (t *RoomserverInternalAPITrace) PerformInboundPeek(
 	ctx context.Context,
 	req *PerformInboundPeekRequest,
@@ -323,6 +350,13 @@ func (t *RoomserverInternalAPITrace) QueryKnownUsers(ctx context.Context, req *Q
 	return err
 }
 
+// QueryRestrictedJoinAllowed returns whether a user may join a room with a restricted join rule.
+func (t *RoomserverInternalAPITrace) QueryRestrictedJoinAllowed(ctx context.Context, req *QueryRestrictedJoinAllowedRequest, res *QueryRestrictedJoinAllowedResponse) error {
+	err := t.Impl.QueryRestrictedJoinAllowed(ctx, req, res)
+	util.GetLogger(ctx).WithError(err).Infof("QueryRestrictedJoinAllowed req=%+v res=%+v", js(req), js(res))
+	return err
+}
+
 // QueryServerBannedFromRoom returns whether a server is banned from a room by server ACLs.
 func (t *RoomserverInternalAPITrace) QueryServerBannedFromRoom(ctx context.Context, req *QueryServerBannedFromRoomRequest, res *QueryServerBannedFromRoomResponse) error {
 	err := t.Impl.QueryServerBannedFromRoom(ctx, req, res)
@@ -30,6 +30,10 @@ const (
 	OutputTypeNewInviteEvent OutputType = "new_invite_event"
 	// OutputTypeRetireInviteEvent indicates that the event is an OutputRetireInviteEvent
 	OutputTypeRetireInviteEvent OutputType = "retire_invite_event"
+	// OutputTypeNewKnockEvent indicates that the event is an OutputNewKnockEvent
+	OutputTypeNewKnockEvent OutputType = "new_knock_event"
+	// OutputTypeRetireKnockEvent indicates that the event is an OutputRetireKnockEvent
+	OutputTypeRetireKnockEvent OutputType = "retire_knock_event"
 	// OutputTypeRedactedEvent indicates that the event is an OutputRedactedEvent
 	//
 	// This event is emitted when a redaction has been 'validated' (meaning both the redaction and the event to redact are known).
@@ -55,6 +59,8 @@ const (
 	OutputTypeNewInboundPeek OutputType = "new_inbound_peek"
 	// OutputTypeRetirePeek indicates that the kafka event is an OutputRetirePeek
 	OutputTypeRetirePeek OutputType = "retire_peek"
+	// OutputTypePurgeRoom indicates that the kafka event is an OutputPurgeRoom
+	OutputTypePurgeRoom OutputType = "purge_room"
 )
 
 // An OutputEvent is an entry in the roomserver output kafka log.
@@ -70,6 +76,10 @@ type OutputEvent struct {
 	NewInviteEvent *OutputNewInviteEvent `json:"new_invite_event,omitempty"`
 	// The content of event with type OutputTypeRetireInviteEvent
 	RetireInviteEvent *OutputRetireInviteEvent `json:"retire_invite_event,omitempty"`
+	// The content of event with type OutputTypeNewKnockEvent
+	NewKnockEvent *OutputNewKnockEvent `json:"new_knock_event,omitempty"`
+	// The content of event with type OutputTypeRetireKnockEvent
+	RetireKnockEvent *OutputRetireKnockEvent `json:"retire_knock_event,omitempty"`
 	// The content of event with type OutputTypeRedactedEvent
 	RedactedEvent *OutputRedactedEvent `json:"redacted_event,omitempty"`
 	// The content of event with type OutputTypeNewPeek
@@ -78,6 +88,8 @@ type OutputEvent struct {
 	NewInboundPeek *OutputNewInboundPeek `json:"new_inbound_peek,omitempty"`
 	// The content of event with type OutputTypeRetirePeek
 	RetirePeek *OutputRetirePeek `json:"retire_peek,omitempty"`
+	// The content of event with type OutputTypePurgeRoom
+	PurgeRoom *OutputPurgeRoom `json:"purge_room,omitempty"`
 }
 
 // Type of the OutputNewRoomEvent.
@@ -231,6 +243,31 @@ type OutputRetireInviteEvent struct {
 	Membership string
 }
 
+// An OutputNewKnockEvent is written whenever a local user knocks on a room.
+// Like invites, knocks can be made on rooms that the server isn't in, so
+// they have to be tracked separately from the room events themselves. The
+// "knock_room_state" unsigned field of the event holds the stripped state
+// of the room.
+type OutputNewKnockEvent struct {
+	// The room version of the room that was knocked on.
+	RoomVersion gomatrixserverlib.RoomVersion `json:"room_version"`
+	// The "m.room.member" knock event.
+	Event *gomatrixserverlib.HeaderedEvent `json:"event"`
+}
+
+// An OutputRetireKnockEvent is written whenever a local user's knock is no
+// longer pending, i.e. they have been invited, joined, left or been refused.
+type OutputRetireKnockEvent struct {
+	// The room that was knocked on.
+	RoomID string
+	// The user who knocked.
+	TargetUserID string
+	// The event ID of the event that replaced the knock.
+	RetiredByEventID string
+	// The "membership" of the user after retiring the knock.
+	Membership string
+}
+
 // An OutputRedactedEvent is written whenever a redaction has been /validated/.
 // Downstream components MUST redact the given event ID if they have stored the
 // event JSON. It is guaranteed that this event ID has been seen before.
@@ -268,3 +305,9 @@ type OutputRetirePeek struct {
 	UserID   string
 	DeviceID string
 }
+
+// An OutputPurgeRoom is written whenever an admin purges a room. Downstream
+// components should forget everything that they know about the room.
+type OutputPurgeRoom struct {
+	RoomID string
+}
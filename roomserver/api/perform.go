@@ -45,6 +45,11 @@ func (p *PerformError) JSONResponse() util.JSONResponse {
 			Code: http.StatusForbidden,
 			JSON: jsonerror.Forbidden(p.Msg),
 		}
+	case PerformErrorGuestAccessForbidden:
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.GuestAccessForbidden(p.Msg),
+		}
 	case PerformErrRemote:
 		// if the code is 0 then something bad happened and it isn't
 		// a remote HTTP error being encapsulated, e.g network error to remote.
@@ -72,6 +77,8 @@ const (
 	PerformErrorNoOperation PerformErrorCode = 4
 	// PerformErrRemote means that the request failed and the PerformError.Msg is the raw remote JSON error response
 	PerformErrRemote PerformErrorCode = 5
+	// PerformErrorGuestAccessForbidden means that a guest isn't allowed to join the room.
+	PerformErrorGuestAccessForbidden PerformErrorCode = 6
 )
 
 type PerformJoinRequest struct {
@@ -79,6 +86,9 @@ type PerformJoinRequest struct {
 	UserID        string                         `json:"user_id"`
 	Content       map[string]interface{}         `json:"content"`
 	ServerNames   []gomatrixserverlib.ServerName `json:"server_names"`
+	// IsGuest is set if the user has a guest account, in which case they
+	// can only join rooms with guest_access set to can_join.
+	IsGuest bool `json:"is_guest"`
 }
 
 type PerformJoinResponse struct {
@@ -89,6 +99,20 @@ type PerformJoinResponse struct {
 	Error *PerformError
 }
 
+type PerformKnockRequest struct {
+	RoomIDOrAlias string                         `json:"room_id_or_alias"`
+	UserID        string                         `json:"user_id"`
+	Content       map[string]interface{}         `json:"content"`
+	ServerNames   []gomatrixserverlib.ServerName `json:"server_names"`
+}
+
+type PerformKnockResponse struct {
+	// The room ID, populated on success.
+	RoomID string `json:"room_id"`
+	// If non-nil, the knock request failed. Contains more information why it failed.
+	Error *PerformError
+}
+
 type PerformLeaveRequest struct {
 	RoomID string `json:"room_id"`
 	UserID string `json:"user_id"`
@@ -172,6 +196,43 @@ type PerformPublishResponse struct {
 	Error *PerformError
 }
 
+// PerformRoomUpgradeRequest is a request to PerformRoomUpgrade
+type PerformRoomUpgradeRequest struct {
+	RoomID      string                        `json:"room_id"`
+	UserID      string                        `json:"user_id"`
+	RoomVersion gomatrixserverlib.RoomVersion `json:"room_version"`
+}
+
+// PerformRoomUpgradeResponse is a response to PerformRoomUpgrade
+type PerformRoomUpgradeResponse struct {
+	// The ID of the room that replaces the upgraded room.
+	NewRoomID string `json:"new_room_id"`
+	// If non-nil, the upgrade request failed. Contains more information why it failed.
+	Error *PerformError `json:"error"`
+}
+
+// PerformAdminRoomShutdownRequest is a request to PerformAdminRoomShutdown
+type PerformAdminRoomShutdownRequest struct {
+	RoomID string `json:"room_id"`
+	// The admin who is shutting down the room.
+	UserID string `json:"user_id"`
+	// Whether to also remove the room's events and state snapshots.
+	Purge bool `json:"purge"`
+}
+
+// PerformAdminRoomShutdownResponse is a response to PerformAdminRoomShutdown
+type PerformAdminRoomShutdownResponse struct {
+	// The local users who were made to leave the room.
+	KickedUsers []string `json:"kicked_users"`
+	// The local aliases that used to point at the room.
+	RemovedAliases []string `json:"removed_aliases"`
+	// The mxc:// URIs of the media that the room's events referred to, if
+	// the room was purged, so that they can be reviewed and deleted.
+	MediaURIs []string `json:"media_uris"`
+	// If non-nil, the shutdown request failed. Contains more information why it failed.
+	Error *PerformError `json:"error"`
+}
+
 type PerformInboundPeekRequest struct {
 	UserID          string                       `json:"user_id"`
 	RoomID          string                       `json:"room_id"`
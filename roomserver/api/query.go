@@ -339,6 +339,28 @@ type QueryServerBannedFromRoomResponse struct {
 	Banned bool `json:"banned"`
 }
 
+// QueryRestrictedJoinAllowedRequest is a request to QueryRestrictedJoinAllowed
+type QueryRestrictedJoinAllowedRequest struct {
+	UserID string `json:"user_id"`
+	RoomID string `json:"room_id"`
+}
+
+// QueryRestrictedJoinAllowedResponse is a response to QueryRestrictedJoinAllowed
+type QueryRestrictedJoinAllowedResponse struct {
+	// True if the join rule of the room is "restricted".
+	Restricted bool `json:"restricted"`
+	// True if we are joined to the room and to all of the rooms in the "allow"
+	// conditions, so that we can reliably decide whether the join is allowed.
+	Resident bool `json:"resident"`
+	// True if the user satisfies one of the "allow" conditions, or is already
+	// invited to or joined to the room.
+	Allowed bool `json:"allowed"`
+	// A local user with the power to invite, which should be used as the
+	// "join_authorised_via_users_server" of the join. Empty if the join
+	// doesn't need to be authorised, e.g. because the user was invited.
+	AuthorisedVia string `json:"authorised_via,omitempty"`
+}
+
 // MarshalJSON stringifies the room ID and StateKeyTuple keys so they can be sent over the wire in HTTP API mode.
 func (r *QueryBulkStateContentResponse) MarshalJSON() ([]byte, error) {
 	se := make(map[string]string)
@@ -25,13 +25,16 @@ type RoomserverInternalAPI struct {
 	*query.Queryer
 	*perform.Inviter
 	*perform.Joiner
+	*perform.Knocker
 	*perform.Peeker
 	*perform.InboundPeeker
 	*perform.Unpeeker
 	*perform.Leaver
 	*perform.Publisher
+	*perform.Upgrader
 	*perform.Backfiller
 	*perform.Forgetter
+	*perform.Admin
 	DB                     storage.Database
 	Cfg                    *config.RoomServer
 	Cache                  caching.RoomServerCaches
@@ -109,6 +112,14 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.FederationInternalA
 		Inputer:    r.Inputer,
 		Queryer:    r.Queryer,
 	}
+	r.Knocker = &perform.Knocker{
+		Cfg:     r.Cfg,
+		DB:      r.DB,
+		FSAPI:   r.fsAPI,
+		RSAPI:   r,
+		Inputer: r.Inputer,
+		Queryer: r.Queryer,
+	}
 	r.Peeker = &perform.Peeker{
 		ServerName: r.Cfg.Matrix.ServerName,
 		Cfg:        r.Cfg,
@@ -136,6 +147,11 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.FederationInternalA
 	r.Publisher = &perform.Publisher{
 		DB: r.DB,
 	}
+	r.Upgrader = &perform.Upgrader{
+		Cfg:     r.Cfg,
+		DB:      r.DB,
+		Inputer: r.Inputer,
+	}
 	r.Backfiller = &perform.Backfiller{
 		ServerName: r.ServerName,
 		DB:         r.DB,
@@ -149,6 +165,12 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.FederationInternalA
 	r.Forgetter = &perform.Forgetter{
 		DB: r.DB,
 	}
+	r.Admin = &perform.Admin{
+		Cfg:     r.Cfg,
+		DB:      r.DB,
+		RSAPI:   r,
+		Inputer: r.Inputer,
+	}
 
 	if err := r.Inputer.Start(); err != nil {
 		logrus.WithError(err).Panic("failed to start roomserver input API")
@@ -50,6 +50,27 @@ func UpdateToInviteMembership(
 	return updates, nil
 }
 
+// RetireKnockMembership notifies the consumers that a local user's knock is
+// no longer pending if the user was knocking, e.g. because they have been
+// invited or refused. It must be called before the membership is updated.
+func RetireKnockMembership(
+	mu *shared.MembershipUpdater, add *gomatrixserverlib.Event, newMembership string,
+	updates []api.OutputEvent,
+) []api.OutputEvent {
+	if !mu.IsKnock() || newMembership == gomatrixserverlib.Knock || add.StateKey() == nil {
+		return updates
+	}
+	return append(updates, api.OutputEvent{
+		Type: api.OutputTypeRetireKnockEvent,
+		RetireKnockEvent: &api.OutputRetireKnockEvent{
+			RoomID:           add.RoomID(),
+			TargetUserID:     *add.StateKey(),
+			RetiredByEventID: add.EventID(),
+			Membership:       newMembership,
+		},
+	})
+}
+
 // IsServerCurrentlyInRoom checks if a server is in a given room, based on the room
 // memberships. If the servername is not supplied then the local server will be
 // checked instead using a faster code path.
@@ -128,6 +128,22 @@ func (r *Inputer) processRoomEvent(
 		}
 	}
 
+	// Don't let local users join a room that an admin has shut down, e.g.
+	// by a remote server sending us a join for them.
+	if event.Type() == gomatrixserverlib.MRoomMember && event.StateKey() != nil && input.Kind == api.KindNew {
+		_, domain, _ := gomatrixserverlib.SplitID('@', *event.StateKey())
+		membership, _ := event.Membership()
+		if domain == r.ServerName && membership == gomatrixserverlib.Join {
+			blocked, err := r.DB.IsRoomBlocked(ctx, event.RoomID())
+			if err != nil {
+				return rollbackTransaction, fmt.Errorf("r.DB.IsRoomBlocked: %w", err)
+			}
+			if blocked {
+				return rollbackTransaction, fmt.Errorf("room %s has been blocked on this server", event.RoomID())
+			}
+		}
+	}
+
 	// Don't waste time processing the event if the room doesn't exist.
 	// A room entry locally will only be created in response to a create
 	// event.
@@ -124,10 +124,14 @@ func (r *Inputer) updateMembership(
 		newMembership = gomatrixserverlib.Leave
 	}
 
-	mu, err := updater.MembershipUpdater(targetUserNID, r.isLocalTarget(add))
+	isTargetLocal := r.isLocalTarget(add)
+	mu, err := updater.MembershipUpdater(targetUserNID, isTargetLocal)
 	if err != nil {
 		return nil, err
 	}
+	if isTargetLocal {
+		updates = helpers.RetireKnockMembership(mu, add, newMembership, updates)
+	}
 
 	switch newMembership {
 	case gomatrixserverlib.Invite:
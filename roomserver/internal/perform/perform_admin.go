@@ -0,0 +1,194 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perform
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+// mxcURIRegexp matches the media that an event refers to, wherever it is in
+// the event, e.g. the url of an m.image or the avatar_url of a member event.
+var mxcURIRegexp = regexp.MustCompile(`mxc://[A-Za-z0-9.:\-\[\]]+/[A-Za-z0-9_\-]+`)
+
+type Admin struct {
+	Cfg   *config.RoomServer
+	DB    storage.Database
+	RSAPI api.RoomserverInternalAPI
+
+	Inputer *input.Inputer
+}
+
+// PerformAdminRoomShutdown shuts down a room on behalf of an admin. Local
+// users are made to leave the room and can't join it again, its aliases and
+// directory listing are removed and, if requested, the room is purged.
+func (r *Admin) PerformAdminRoomShutdown(
+	ctx context.Context,
+	req *api.PerformAdminRoomShutdownRequest,
+	res *api.PerformAdminRoomShutdownResponse,
+) {
+	if err := r.performAdminRoomShutdown(ctx, req, res); err != nil {
+		perr, ok := err.(*api.PerformError)
+		if ok {
+			res.Error = perr
+		} else {
+			res.Error = &api.PerformError{
+				Msg: err.Error(),
+			}
+		}
+	}
+}
+
+func (r *Admin) performAdminRoomShutdown(
+	ctx context.Context,
+	req *api.PerformAdminRoomShutdownRequest,
+	res *api.PerformAdminRoomShutdownResponse,
+) error {
+	if _, _, err := gomatrixserverlib.SplitID('!', req.RoomID); err != nil {
+		return &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Room ID %q is invalid: %s", req.RoomID, err),
+		}
+	}
+	logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"room_id": req.RoomID,
+		"user_id": req.UserID,
+		"purge":   req.Purge,
+	})
+	logger.Info("Admin requested to shut down room")
+
+	// Block the room first so that nobody can join while everyone else is
+	// being made to leave. A room that we don't know about yet can still be
+	// blocked.
+	if err := r.DB.BlockRoom(ctx, req.RoomID, req.UserID); err != nil {
+		return fmt.Errorf("r.DB.BlockRoom: %w", err)
+	}
+
+	roomInfo, err := r.DB.RoomInfo(ctx, req.RoomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo != nil && !roomInfo.IsStub {
+		if res.KickedUsers, err = r.removeLocalUsers(ctx, req.RoomID); err != nil {
+			return err
+		}
+	}
+
+	aliases, err := r.DB.GetAliasesForRoomID(ctx, req.RoomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.GetAliasesForRoomID: %w", err)
+	}
+	for _, alias := range aliases {
+		if err = r.DB.RemoveRoomAlias(ctx, alias); err != nil {
+			return fmt.Errorf("r.DB.RemoveRoomAlias: %w", err)
+		}
+		res.RemovedAliases = append(res.RemovedAliases, alias)
+	}
+	if err = r.DB.PublishRoom(ctx, req.RoomID, false); err != nil {
+		return fmt.Errorf("r.DB.PublishRoom: %w", err)
+	}
+
+	if !req.Purge || roomInfo == nil {
+		return nil
+	}
+	eventJSONs, err := r.DB.RoomEventJSONs(ctx, req.RoomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomEventJSONs: %w", err)
+	}
+	res.MediaURIs = mediaURIs(eventJSONs)
+	if err = r.DB.PurgeRoom(ctx, req.RoomID); err != nil {
+		return fmt.Errorf("r.DB.PurgeRoom: %w", err)
+	}
+	err = r.Inputer.WriteOutputEvents(req.RoomID, []api.OutputEvent{
+		{
+			Type: api.OutputTypePurgeRoom,
+			PurgeRoom: &api.OutputPurgeRoom{
+				RoomID: req.RoomID,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("r.Inputer.WriteOutputEvents: %w", err)
+	}
+	logger.Info("Purged room")
+	return nil
+}
+
+// removeLocalUsers makes every local user who is joined or invited to the
+// room leave it, returning the users who left.
+func (r *Admin) removeLocalUsers(ctx context.Context, roomID string) ([]string, error) {
+	var stateRes api.QueryLatestEventsAndStateResponse
+	if err := helpers.QueryLatestEventsAndState(ctx, r.DB, &api.QueryLatestEventsAndStateRequest{
+		RoomID: roomID,
+	}, &stateRes); err != nil {
+		return nil, fmt.Errorf("helpers.QueryLatestEventsAndState: %w", err)
+	}
+	var removed []string
+	for _, ev := range stateRes.StateEvents {
+		if ev.Type() != gomatrixserverlib.MRoomMember || ev.StateKey() == nil {
+			continue
+		}
+		userID := *ev.StateKey()
+		_, domain, err := gomatrixserverlib.SplitID('@', userID)
+		if err != nil || domain != r.Cfg.Matrix.ServerName {
+			continue
+		}
+		membership, err := ev.Membership()
+		if err != nil || (membership != gomatrixserverlib.Join && membership != gomatrixserverlib.Invite) {
+			continue
+		}
+		// Keep going if one of the users can't leave, so that as many
+		// users as possible are removed.
+		var leaveRes api.PerformLeaveResponse
+		if err = r.RSAPI.PerformLeave(ctx, &api.PerformLeaveRequest{
+			RoomID: roomID,
+			UserID: userID,
+		}, &leaveRes); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+				"room_id": roomID,
+				"user_id": userID,
+			}).Error("Failed to remove user from room")
+			continue
+		}
+		removed = append(removed, userID)
+	}
+	return removed, nil
+}
+
+// mediaURIs returns the distinct mxc:// URIs that the events refer to.
+func mediaURIs(eventJSONs [][]byte) []string {
+	seen := map[string]struct{}{}
+	for _, eventJSON := range eventJSONs {
+		for _, uri := range mxcURIRegexp.FindAll(eventJSON, -1) {
+			seen[string(uri)] = struct{}{}
+		}
+	}
+	uris := make([]string, 0, len(seen))
+	for uri := range seen {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
@@ -0,0 +1,231 @@
+package perform
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestMediaURIs(t *testing.T) {
+	testCases := []struct {
+		name   string
+		events []string
+		want   []string
+	}{
+		{
+			name: "content url",
+			events: []string{
+				`{"type":"m.room.message","content":{"msgtype":"m.image","url":"mxc://test/image"}}`,
+			},
+			want: []string{"mxc://test/image"},
+		},
+		{
+			name: "nested and repeated",
+			events: []string{
+				`{"type":"m.room.member","content":{"membership":"join","avatar_url":"mxc://test/avatar"}}`,
+				`{"type":"m.room.message","content":{"url":"mxc://test/video","info":{"thumbnail_url":"mxc://test/thumb"}}}`,
+				`{"type":"m.room.member","content":{"membership":"leave","avatar_url":"mxc://test/avatar"}}`,
+			},
+			want: []string{"mxc://test/avatar", "mxc://test/thumb", "mxc://test/video"},
+		},
+		{
+			name: "server names with ports",
+			events: []string{
+				`{"content":{"url":"mxc://example.com:8448/abc_DEF-123"}}`,
+				`{"content":{"url":"mxc://[::1]:8448/xyz"}}`,
+			},
+			want: []string{"mxc://[::1]:8448/xyz", "mxc://example.com:8448/abc_DEF-123"},
+		},
+		{
+			name: "no media",
+			events: []string{
+				`{"type":"m.room.message","content":{"body":"https://example.com/not-media"}}`,
+			},
+			want: []string{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			eventJSONs := make([][]byte, len(tc.events))
+			for i := range tc.events {
+				eventJSONs[i] = []byte(tc.events[i])
+			}
+			if got := mediaURIs(eventJSONs); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// leavingRoomserverAPI makes users leave rooms by sending their leave events
+// straight to the test roomserver.
+type leavingRoomserverAPI struct {
+	api.RoomserverInternalAPI
+	rs *testRoomserver
+}
+
+func (a *leavingRoomserverAPI) PerformLeave(
+	ctx context.Context, req *api.PerformLeaveRequest, res *api.PerformLeaveResponse,
+) error {
+	ev, err := a.rs.buildMember(ctx, req.RoomID, req.UserID, gomatrixserverlib.Leave)
+	if err != nil {
+		return err
+	}
+	return a.rs.inputEvent(ctx, ev)
+}
+
+func TestPerformAdminRoomShutdown(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, remote := "@alice:test", "@bob:test", "@eve:remote"
+	alias := "#doomed:test"
+
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+		"membership": "join", "avatar_url": "mxc://test/avatar",
+	}, bob)
+	room.CreateAndInsert(t, remote, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, remote)
+	room.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{
+		"msgtype": "m.image", "body": "cat.png", "url": "mxc://test/image",
+	})
+	kept := test.NewRoom(t, alice)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		rs := newTestRoomserver(t, dbType)
+		rs.mustInputRoom(t, room)
+		rs.mustInputRoom(t, kept)
+		if err := rs.db.SetRoomAlias(ctx, alias, room.ID, alice); err != nil {
+			t.Fatalf("failed to set alias: %s", err)
+		}
+		if err := rs.db.PublishRoom(ctx, room.ID, true); err != nil {
+			t.Fatalf("failed to publish room: %s", err)
+		}
+		admin := &Admin{
+			Cfg:     rs.cfg,
+			DB:      rs.db,
+			RSAPI:   &leavingRoomserverAPI{rs: rs},
+			Inputer: rs.inputer,
+		}
+
+		res := &api.PerformAdminRoomShutdownResponse{}
+		admin.PerformAdminRoomShutdown(ctx, &api.PerformAdminRoomShutdownRequest{
+			RoomID: room.ID, UserID: alice,
+		}, res)
+		if res.Error != nil {
+			t.Fatalf("PerformAdminRoomShutdown failed: %s", res.Error)
+		}
+
+		t.Run("local users are removed", func(t *testing.T) {
+			sort.Strings(res.KickedUsers)
+			if want := []string{alice, bob}; !reflect.DeepEqual(res.KickedUsers, want) {
+				t.Errorf("got kicked users %v, want %v", res.KickedUsers, want)
+			}
+			state := rs.mustCurrentState(t, room.ID)
+			for userID, want := range map[string]string{
+				alice: gomatrixserverlib.Leave, bob: gomatrixserverlib.Leave, remote: gomatrixserverlib.Join,
+			} {
+				ev := state[stateTuple(gomatrixserverlib.MRoomMember, userID)]
+				if membership, _ := ev.Membership(); membership != want {
+					t.Errorf("got membership %q for %s, want %q", membership, userID, want)
+				}
+			}
+		})
+
+		t.Run("aliases and publication are removed", func(t *testing.T) {
+			if want := []string{alias}; !reflect.DeepEqual(res.RemovedAliases, want) {
+				t.Errorf("got removed aliases %v, want %v", res.RemovedAliases, want)
+			}
+			if roomID, err := rs.db.GetRoomIDForAlias(ctx, alias); err != nil || roomID != "" {
+				t.Errorf("got alias pointing at %q (err %v), want none", roomID, err)
+			}
+			published, err := rs.db.GetPublishedRooms(ctx)
+			if err != nil {
+				t.Fatalf("GetPublishedRooms failed: %s", err)
+			}
+			if len(published) != 0 {
+				t.Errorf("got published rooms %v, want none", published)
+			}
+		})
+
+		t.Run("rejoining is refused", func(t *testing.T) {
+			joiner := &Joiner{ServerName: serverName, Cfg: rs.cfg, DB: rs.db, Inputer: rs.inputer}
+			joinRes := &api.PerformJoinResponse{}
+			joiner.PerformJoin(ctx, &api.PerformJoinRequest{RoomIDOrAlias: room.ID, UserID: bob}, joinRes)
+			if joinRes.Error == nil || joinRes.Error.Code != api.PerformErrorNotAllowed {
+				t.Errorf("got join error %+v, want not allowed", joinRes.Error)
+			}
+
+			// A join arriving some other way, e.g. over federation, is rejected
+			// by the roomserver too.
+			join, err := rs.buildMember(ctx, room.ID, bob, gomatrixserverlib.Join)
+			if err != nil {
+				t.Fatalf("failed to build join: %s", err)
+			}
+			if err = rs.inputEvent(ctx, join); err == nil {
+				t.Errorf("join event for a blocked room was accepted")
+			}
+			state := rs.mustCurrentState(t, room.ID)
+			if membership, _ := state[stateTuple(gomatrixserverlib.MRoomMember, bob)].Membership(); membership != gomatrixserverlib.Leave {
+				t.Errorf("got membership %q after rejoining, want leave", membership)
+			}
+		})
+
+		t.Run("purge", func(t *testing.T) {
+			eventIDs := make([]string, 0, len(room.Events()))
+			for _, ev := range room.Events() {
+				eventIDs = append(eventIDs, ev.EventID())
+			}
+			keptEventIDs := make([]string, 0, len(kept.Events()))
+			for _, ev := range kept.Events() {
+				keptEventIDs = append(keptEventIDs, ev.EventID())
+			}
+
+			purgeRes := &api.PerformAdminRoomShutdownResponse{}
+			admin.PerformAdminRoomShutdown(ctx, &api.PerformAdminRoomShutdownRequest{
+				RoomID: room.ID, UserID: alice, Purge: true,
+			}, purgeRes)
+			if purgeRes.Error != nil {
+				t.Fatalf("PerformAdminRoomShutdown failed: %s", purgeRes.Error)
+			}
+			if want := []string{"mxc://test/avatar", "mxc://test/image"}; !reflect.DeepEqual(purgeRes.MediaURIs, want) {
+				t.Errorf("got media URIs %v, want %v", purgeRes.MediaURIs, want)
+			}
+
+			if info, err := rs.db.RoomInfo(ctx, room.ID); err != nil || info != nil {
+				t.Errorf("got room info %+v (err %v) for a purged room, want none", info, err)
+			}
+			events, err := rs.db.EventsFromIDs(ctx, eventIDs)
+			if err != nil {
+				t.Fatalf("EventsFromIDs failed: %s", err)
+			}
+			if len(events) != 0 {
+				t.Errorf("got %d events from a purged room, want none", len(events))
+			}
+			if blocked, err := rs.db.IsRoomBlocked(ctx, room.ID); err != nil || !blocked {
+				t.Errorf("purged room isn't blocked any more (err %v)", err)
+			}
+
+			// Other rooms are left alone.
+			events, err = rs.db.EventsFromIDs(ctx, keptEventIDs)
+			if err != nil {
+				t.Fatalf("EventsFromIDs failed: %s", err)
+			}
+			if len(events) != len(keptEventIDs) {
+				t.Errorf("got %d events from another room, want %d", len(events), len(keptEventIDs))
+			}
+			rs.mustCurrentState(t, kept.ID)
+
+			// The other components are told to purge the room too.
+			rs.jetstream.mu.Lock()
+			defer rs.jetstream.mu.Unlock()
+			last := rs.jetstream.output[len(rs.jetstream.output)-1]
+			if last.Type != api.OutputTypePurgeRoom || last.PurgeRoom == nil || last.PurgeRoom.RoomID != room.ID {
+				t.Errorf("got last output event %+v, want a purge of %s", last, room.ID)
+			}
+		})
+	})
+}
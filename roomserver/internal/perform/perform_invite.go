@@ -89,6 +89,21 @@ func (r *Inviter) PerformInvite(
 		}
 	}
 
+	if isTargetLocal {
+		var blocked bool
+		if blocked, err = r.DB.IsRoomBlocked(ctx, roomID); err != nil {
+			return nil, fmt.Errorf("r.DB.IsRoomBlocked: %w", err)
+		}
+		if blocked {
+			res.Error = &api.PerformError{
+				Code: api.PerformErrorNotAllowed,
+				Msg:  "This room has been blocked on this server",
+			}
+			logger.Debugf("room is blocked")
+			return nil, nil
+		}
+	}
+
 	var isAlreadyJoined bool
 	if info != nil {
 		_, isAlreadyJoined, _, err = r.DB.GetMembership(ctx, info.RoomNID, *event.StateKey())
@@ -145,7 +160,8 @@ func (r *Inviter) PerformInvite(
 		}
 
 		unwrapped := event.Unwrap()
-		outputUpdates, err := helpers.UpdateToInviteMembership(updater, unwrapped, nil, req.Event.RoomVersion)
+		outputUpdates := helpers.RetireKnockMembership(updater, unwrapped, gomatrixserverlib.Invite, nil)
+		outputUpdates, err = helpers.UpdateToInviteMembership(updater, unwrapped, outputUpdates, req.Event.RoomVersion)
 		if err != nil {
 			return nil, fmt.Errorf("updateToInviteMembership: %w", err)
 		}
@@ -233,6 +249,27 @@ func buildInviteStrippedState(
 	info *types.RoomInfo,
 	input *api.PerformInviteRequest,
 ) ([]gomatrixserverlib.InviteV2StrippedState, error) {
+	stateEvents, err := loadStrippedStateEvents(ctx, db, info)
+	if err != nil {
+		return nil, err
+	}
+	inviteState := []gomatrixserverlib.InviteV2StrippedState{
+		gomatrixserverlib.NewInviteV2StrippedState(input.Event.Event),
+	}
+	stateEvents = append(stateEvents, types.Event{Event: input.Event.Unwrap()})
+	for _, event := range stateEvents {
+		inviteState = append(inviteState, gomatrixserverlib.NewInviteV2StrippedState(event.Event))
+	}
+	return inviteState, nil
+}
+
+// loadStrippedStateEvents loads the current state events of the room that
+// are given to users who aren't in the room yet, e.g. when invited.
+func loadStrippedStateEvents(
+	ctx context.Context,
+	db storage.Database,
+	info *types.RoomInfo,
+) ([]types.Event, error) {
 	stateWanted := []gomatrixserverlib.StateKeyTuple{}
 	// "If they are set on the room, at least the state for m.room.avatar, m.room.canonical_alias, m.room.join_rules, and m.room.name SHOULD be included."
 	// https://matrix.org/docs/spec/client_server/r0.6.0#m-room-member
@@ -257,16 +294,5 @@ func buildInviteStrippedState(
 	for _, stateNID := range stateEntries {
 		stateNIDs = append(stateNIDs, stateNID.EventNID)
 	}
-	stateEvents, err := db.Events(ctx, stateNIDs)
-	if err != nil {
-		return nil, err
-	}
-	inviteState := []gomatrixserverlib.InviteV2StrippedState{
-		gomatrixserverlib.NewInviteV2StrippedState(input.Event.Event),
-	}
-	stateEvents = append(stateEvents, types.Event{Event: input.Event.Unwrap()})
-	for _, event := range stateEvents {
-		inviteState = append(inviteState, gomatrixserverlib.NewInviteV2StrippedState(event.Event))
-	}
-	return inviteState, nil
+	return db.Events(ctx, stateNIDs)
 }
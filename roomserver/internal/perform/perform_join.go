@@ -16,6 +16,7 @@ package perform
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -189,6 +190,24 @@ func (r *Joiner) performJoinRoomByID(
 		req.ServerNames = append(req.ServerNames, domain)
 	}
 
+	// Don't let anyone join a room that an admin has shut down.
+	blocked, err := r.DB.IsRoomBlocked(ctx, req.RoomIDOrAlias)
+	if err != nil {
+		return "", "", fmt.Errorf("r.DB.IsRoomBlocked: %w", err)
+	}
+	if blocked {
+		return "", "", &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNotAllowed,
+			Msg:  "This room has been blocked on this server",
+		}
+	}
+
+	if req.IsGuest {
+		if err = r.checkGuestCanJoin(ctx, req.RoomIDOrAlias); err != nil {
+			return "", "", err
+		}
+	}
+
 	// Prepare the template for the join event.
 	userID := req.UserID
 	eb := gomatrixserverlib.EventBuilder{
@@ -242,6 +261,45 @@ func (r *Joiner) performJoinRoomByID(
 		}
 	}
 
+	// If the room has a restricted join rule then work out whether the
+	// user is allowed to join it, and which of our users can authorise
+	// the join. If we can't authorise it ourselves then we'll have to ask
+	// another server in the room to do it for us.
+	if serverInRoom && !forceFederatedJoin {
+		restrictedReq := &rsAPI.QueryRestrictedJoinAllowedRequest{
+			RoomID: req.RoomIDOrAlias,
+			UserID: req.UserID,
+		}
+		restrictedRes := &rsAPI.QueryRestrictedJoinAllowedResponse{}
+		if err = r.Queryer.QueryRestrictedJoinAllowed(ctx, restrictedReq, restrictedRes); err != nil {
+			return "", "", fmt.Errorf("r.Queryer.QueryRestrictedJoinAllowed: %w", err)
+		}
+		switch {
+		case !restrictedRes.Restricted:
+		case !restrictedRes.Resident:
+			if err = r.addResidentServers(ctx, req); err != nil {
+				return "", "", err
+			}
+			if len(req.ServerNames) == 0 {
+				return "", "", &rsAPI.PerformError{
+					Code: rsAPI.PerformErrorNotAllowed,
+					Msg:  "No server in the room is able to authorise the join",
+				}
+			}
+			forceFederatedJoin = true
+		case !restrictedRes.Allowed:
+			return "", "", &rsAPI.PerformError{
+				Code: rsAPI.PerformErrorNotAllowed,
+				Msg:  "You are not a member of any of the rooms that allow joining this room",
+			}
+		case restrictedRes.AuthorisedVia != "":
+			req.Content["join_authorised_via_users_server"] = restrictedRes.AuthorisedVia
+			if err = eb.SetContent(req.Content); err != nil {
+				return "", "", fmt.Errorf("eb.SetContent: %w", err)
+			}
+		}
+	}
+
 	// If we should do a forced federated join then do that.
 	var joinedVia gomatrixserverlib.ServerName
 	if forceFederatedJoin {
@@ -326,6 +384,25 @@ func (r *Joiner) performJoinRoomByID(
 	return req.RoomIDOrAlias, r.Cfg.Matrix.ServerName, nil
 }
 
+// addResidentServers adds the other servers that are joined to the room
+// to the list of servers to join through, so that one of them can authorise
+// a restricted join that we can't authorise ourselves.
+func (r *Joiner) addResidentServers(
+	ctx context.Context,
+	req *rsAPI.PerformJoinRequest,
+) error {
+	serverReq := &fsAPI.QueryJoinedHostServerNamesInRoomRequest{
+		RoomID:      req.RoomIDOrAlias,
+		ExcludeSelf: true,
+	}
+	serverRes := &fsAPI.QueryJoinedHostServerNamesInRoomResponse{}
+	if err := r.FSAPI.QueryJoinedHostServerNamesInRoom(ctx, serverReq, serverRes); err != nil {
+		return fmt.Errorf("r.FSAPI.QueryJoinedHostServerNamesInRoom: %w", err)
+	}
+	req.ServerNames = append(req.ServerNames, serverRes.ServerNames...)
+	return nil
+}
+
 func (r *Joiner) performFederatedJoinRoomByID(
 	ctx context.Context,
 	req *rsAPI.PerformJoinRequest,
@@ -349,6 +426,42 @@ func (r *Joiner) performFederatedJoinRoomByID(
 	return fedRes.JoinedVia, nil
 }
 
+// checkGuestCanJoin returns an error unless the room allows guests to join it.
+// We can only tell if it does when we are in the room, so guests can't join
+// rooms that no one on this server is in.
+// https://spec.matrix.org/v1.2/client-server-api/#server-behaviour-17
+func (r *Joiner) checkGuestCanJoin(ctx context.Context, roomID string) error {
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorGuestAccessForbidden,
+			Msg:  "Guests can only join rooms that this server is in",
+		}
+	}
+	ev, err := r.DB.GetStateEvent(ctx, roomID, gomatrixserverlib.MRoomGuestAccess, "")
+	if err != nil {
+		return fmt.Errorf("r.DB.GetStateEvent: %w", err)
+	}
+	var content struct {
+		GuestAccess string `json:"guest_access"`
+	}
+	if ev != nil {
+		if err = json.Unmarshal(ev.Content(), &content); err != nil {
+			logrus.WithError(err).WithField("room_id", roomID).Warn("Failed to unmarshal m.room.guest_access content")
+		}
+	}
+	if content.GuestAccess != "can_join" {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorGuestAccessForbidden,
+			Msg:  "Guest access not allowed",
+		}
+	}
+	return nil
+}
+
 func buildEvent(
 	ctx context.Context, db storage.Database, cfg *config.Global, builder *gomatrixserverlib.EventBuilder,
 ) (*gomatrixserverlib.HeaderedEvent, *rsAPI.QueryLatestEventsAndStateResponse, error) {
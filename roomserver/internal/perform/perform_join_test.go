@@ -0,0 +1,199 @@
+package perform
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/query"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// joiningFederationAPI reports which other servers are in a room, and
+// records the joins that would have been made over federation.
+type joiningFederationAPI struct {
+	fsAPI.FederationInternalAPI
+	joinedServers []gomatrixserverlib.ServerName
+	joins         []fsAPI.PerformJoinRequest
+}
+
+func (a *joiningFederationAPI) QueryJoinedHostServerNamesInRoom(
+	ctx context.Context, req *fsAPI.QueryJoinedHostServerNamesInRoomRequest, res *fsAPI.QueryJoinedHostServerNamesInRoomResponse,
+) error {
+	res.ServerNames = a.joinedServers
+	return nil
+}
+
+func (a *joiningFederationAPI) PerformJoin(
+	ctx context.Context, req *fsAPI.PerformJoinRequest, res *fsAPI.PerformJoinResponse,
+) {
+	a.joins = append(a.joins, *req)
+	res.JoinedVia = req.ServerNames[0]
+}
+
+func TestPerformJoinRestricted(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, charlie, dave := "@alice:test", "@bob:test", "@charlie:test", "@dave:test"
+
+	// Bob is in the space, and so may join the rooms restricted to it.
+	space := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV8)
+	space.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, bob)
+	restrictedTo := func(roomID string) map[string]interface{} {
+		return map[string]interface{}{
+			"join_rule": gomatrixserverlib.Restricted,
+			"allow": []map[string]interface{}{
+				{"type": gomatrixserverlib.MRoomMembership, "room_id": roomID},
+			},
+		}
+	}
+	room := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV8)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, restrictedTo(space.ID), "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "invite"}, charlie)
+	// We aren't in the room that this one is restricted to, so we can't tell
+	// whether anyone is allowed to join it.
+	remoteRestrictedRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV8)
+	remoteRestrictedRoom.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, restrictedTo("!space:remote"), "")
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		rs := newTestRoomserver(t, dbType)
+		rs.mustInputRoom(t, space)
+		rs.mustInputRoom(t, room)
+		rs.mustInputRoom(t, remoteRestrictedRoom)
+		fedAPI := &joiningFederationAPI{}
+		joiner := &Joiner{
+			ServerName: serverName,
+			Cfg:        rs.cfg,
+			DB:         rs.db,
+			FSAPI:      fedAPI,
+			Inputer:    rs.inputer,
+			Queryer:    &query.Queryer{DB: rs.db, ServerName: serverName},
+		}
+		join := func(roomID, userID string) *api.PerformJoinResponse {
+			res := &api.PerformJoinResponse{}
+			joiner.PerformJoin(ctx, &api.PerformJoinRequest{RoomIDOrAlias: roomID, UserID: userID}, res)
+			return res
+		}
+		mustMemberContent := func(t *testing.T, roomID, userID string) map[string]interface{} {
+			t.Helper()
+			member := rs.mustCurrentState(t, roomID)[stateTuple(gomatrixserverlib.MRoomMember, userID)]
+			if member == nil {
+				t.Fatalf("%s has no membership in %s", userID, roomID)
+			}
+			return mustUnmarshalContent(t, member)
+		}
+
+		t.Run("member of an allowed room", func(t *testing.T) {
+			if res := join(room.ID, bob); res.Error != nil {
+				t.Fatalf("PerformJoin failed: %s", res.Error)
+			}
+			content := mustMemberContent(t, room.ID, bob)
+			if content["membership"] != gomatrixserverlib.Join {
+				t.Errorf("got membership %v, want join", content["membership"])
+			}
+			if content["join_authorised_via_users_server"] != alice {
+				t.Errorf("got join authorised via %v, want %s", content["join_authorised_via_users_server"], alice)
+			}
+		})
+
+		t.Run("invited user", func(t *testing.T) {
+			if res := join(room.ID, charlie); res.Error != nil {
+				t.Fatalf("PerformJoin failed: %s", res.Error)
+			}
+			content := mustMemberContent(t, room.ID, charlie)
+			if content["membership"] != gomatrixserverlib.Join {
+				t.Errorf("got membership %v, want join", content["membership"])
+			}
+			if via, ok := content["join_authorised_via_users_server"]; ok {
+				t.Errorf("got join authorised via %v for an invited user", via)
+			}
+		})
+
+		t.Run("not a member of an allowed room", func(t *testing.T) {
+			res := join(room.ID, dave)
+			if res.Error == nil || res.Error.Code != api.PerformErrorNotAllowed {
+				t.Fatalf("got error %+v, want not allowed", res.Error)
+			}
+			if member := rs.mustCurrentState(t, room.ID)[stateTuple(gomatrixserverlib.MRoomMember, dave)]; member != nil {
+				t.Errorf("got membership %s for a refused join", member.Content())
+			}
+		})
+
+		t.Run("no resident server can authorise", func(t *testing.T) {
+			fedAPI.joinedServers, fedAPI.joins = nil, nil
+			res := join(remoteRestrictedRoom.ID, bob)
+			if res.Error == nil || res.Error.Code != api.PerformErrorNotAllowed {
+				t.Errorf("got error %+v, want not allowed", res.Error)
+			}
+			if len(fedAPI.joins) != 0 {
+				t.Errorf("got federated joins %+v, want none", fedAPI.joins)
+			}
+		})
+
+		t.Run("join through a resident server", func(t *testing.T) {
+			fedAPI.joinedServers, fedAPI.joins = []gomatrixserverlib.ServerName{"remote"}, nil
+			res := join(remoteRestrictedRoom.ID, bob)
+			if res.Error != nil {
+				t.Fatalf("PerformJoin failed: %s", res.Error)
+			}
+			if len(fedAPI.joins) != 1 {
+				t.Fatalf("got %d federated joins, want 1", len(fedAPI.joins))
+			}
+			if got := []gomatrixserverlib.ServerName(fedAPI.joins[0].ServerNames); !reflect.DeepEqual(got, fedAPI.joinedServers) {
+				t.Errorf("joined through %v, want %v", got, fedAPI.joinedServers)
+			}
+			if res.JoinedVia != "remote" {
+				t.Errorf("got joined via %s, want remote", res.JoinedVia)
+			}
+		})
+	})
+}
+
+func TestCheckGuestCanJoin(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	withGuestAccess := func(guestAccess string) *test.Room {
+		room := test.NewRoom(t, alice)
+		room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomGuestAccess, map[string]interface{}{
+			"guest_access": guestAccess,
+		}, "")
+		return room
+	}
+	canJoin, forbidden := withGuestAccess("can_join"), withGuestAccess("forbidden")
+	missing := test.NewRoom(t, alice)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		rs := newTestRoomserver(t, dbType)
+		for _, room := range []*test.Room{canJoin, forbidden, missing} {
+			rs.mustInputRoom(t, room)
+		}
+		joiner := &Joiner{ServerName: serverName, Cfg: rs.cfg, DB: rs.db, Inputer: rs.inputer}
+
+		for _, tc := range []struct {
+			name    string
+			roomID  string
+			allowed bool
+		}{
+			{"guest access can_join", canJoin.ID, true},
+			{"guest access forbidden", forbidden.ID, false},
+			{"no guest access event", missing.ID, false},
+			{"room this server isn't in", "!unknown:remote", false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				err := joiner.checkGuestCanJoin(ctx, tc.roomID)
+				if tc.allowed {
+					if err != nil {
+						t.Errorf("checkGuestCanJoin: got %s, want no error", err)
+					}
+					return
+				}
+				perr, ok := err.(*api.PerformError)
+				if !ok || perr.Code != api.PerformErrorGuestAccessForbidden {
+					t.Errorf("checkGuestCanJoin: got %v, want guest access forbidden", err)
+				}
+			})
+		}
+	})
+}
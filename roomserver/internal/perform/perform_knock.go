@@ -0,0 +1,359 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	rsAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/internal/query"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+type Knocker struct {
+	Cfg   *config.RoomServer
+	FSAPI fsAPI.FederationInternalAPI
+	RSAPI rsAPI.RoomserverInternalAPI
+	DB    storage.Database
+
+	Inputer *input.Inputer
+	Queryer *query.Queryer
+}
+
+// PerformKnock handles knocking on matrix rooms, including over federation
+// by talking to the federationapi.
+func (r *Knocker) PerformKnock(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+	res *rsAPI.PerformKnockResponse,
+) {
+	logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"room_id": req.RoomIDOrAlias,
+		"user_id": req.UserID,
+		"servers": req.ServerNames,
+	})
+	logger.Info("User requested to knock on room")
+	roomID, err := r.performKnock(ctx, req)
+	if err != nil {
+		logger.WithError(err).Error("Failed to knock on room")
+		perr, ok := err.(*rsAPI.PerformError)
+		if ok {
+			res.Error = perr
+		} else {
+			res.Error = &rsAPI.PerformError{
+				Msg: err.Error(),
+			}
+		}
+		return
+	}
+	logger.Info("User knocked on room successfully")
+	res.RoomID = roomID
+}
+
+func (r *Knocker) performKnock(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+) (string, error) {
+	_, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return "", &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Supplied user ID %q in incorrect format", req.UserID),
+		}
+	}
+	if domain != r.Cfg.Matrix.ServerName {
+		return "", &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("User %q does not belong to this homeserver", req.UserID),
+		}
+	}
+	if strings.HasPrefix(req.RoomIDOrAlias, "#") {
+		if err = r.resolveRoomAlias(ctx, req); err != nil {
+			return "", err
+		}
+	}
+	if strings.HasPrefix(req.RoomIDOrAlias, "!") {
+		return req.RoomIDOrAlias, r.performKnockRoomByID(ctx, req)
+	}
+	return "", &rsAPI.PerformError{
+		Code: rsAPI.PerformErrorBadRequest,
+		Msg:  fmt.Sprintf("Room ID or alias %q is invalid", req.RoomIDOrAlias),
+	}
+}
+
+// resolveRoomAlias replaces the room alias in the request with the room ID
+// that it points to, adding any servers that might be in the room to the
+// list of servers to try.
+func (r *Knocker) resolveRoomAlias(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+) error {
+	_, domain, err := gomatrixserverlib.SplitID('#', req.RoomIDOrAlias)
+	if err != nil {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Alias %q is not in the correct format", req.RoomIDOrAlias),
+		}
+	}
+	req.ServerNames = append(req.ServerNames, domain)
+
+	var roomID string
+	if domain != r.Cfg.Matrix.ServerName {
+		dirReq := fsAPI.PerformDirectoryLookupRequest{
+			RoomAlias:  req.RoomIDOrAlias,
+			ServerName: domain,
+		}
+		dirRes := fsAPI.PerformDirectoryLookupResponse{}
+		if err = r.FSAPI.PerformDirectoryLookup(ctx, &dirReq, &dirRes); err != nil {
+			return fmt.Errorf("looking up alias %q over federation failed: %w", req.RoomIDOrAlias, err)
+		}
+		roomID = dirRes.RoomID
+		req.ServerNames = append(req.ServerNames, dirRes.ServerNames...)
+	} else {
+		getRoomReq := rsAPI.GetRoomIDForAliasRequest{
+			Alias:              req.RoomIDOrAlias,
+			IncludeAppservices: true,
+		}
+		getRoomRes := rsAPI.GetRoomIDForAliasResponse{}
+		if err = r.RSAPI.GetRoomIDForAlias(ctx, &getRoomReq, &getRoomRes); err != nil {
+			return fmt.Errorf("lookup room alias %q failed: %w", req.RoomIDOrAlias, err)
+		}
+		roomID = getRoomRes.RoomID
+	}
+	if roomID == "" {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNoRoom,
+			Msg:  fmt.Sprintf("Alias %q not found", req.RoomIDOrAlias),
+		}
+	}
+	req.RoomIDOrAlias = roomID
+	return nil
+}
+
+func (r *Knocker) performKnockRoomByID(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+) error {
+	// The original client request ?server_name=... may include this HS so
+	// filter that out so we don't attempt to make_knock with ourselves.
+	for i := 0; i < len(req.ServerNames); i++ {
+		if req.ServerNames[i] == r.Cfg.Matrix.ServerName {
+			req.ServerNames = append(req.ServerNames[:i], req.ServerNames[i+1:]...)
+			i--
+		}
+	}
+
+	_, domain, err := gomatrixserverlib.SplitID('!', req.RoomIDOrAlias)
+	if err != nil {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Room ID %q is invalid: %s", req.RoomIDOrAlias, err),
+		}
+	}
+	if domain != r.Cfg.Matrix.ServerName {
+		req.ServerNames = append(req.ServerNames, domain)
+	}
+
+	blocked, err := r.DB.IsRoomBlocked(ctx, req.RoomIDOrAlias)
+	if err != nil {
+		return fmt.Errorf("r.DB.IsRoomBlocked: %w", err)
+	}
+	if blocked {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNotAllowed,
+			Msg:  "This room has been blocked on this server",
+		}
+	}
+
+	// The "membership" key is always overwritten, but the rest of the
+	// content, like the "reason", is kept.
+	if req.Content == nil {
+		req.Content = map[string]interface{}{}
+	}
+	req.Content["membership"] = gomatrixserverlib.Knock
+
+	inRoomReq := &rsAPI.QueryServerJoinedToRoomRequest{
+		RoomID: req.RoomIDOrAlias,
+	}
+	inRoomRes := &rsAPI.QueryServerJoinedToRoomResponse{}
+	if err = r.Queryer.QueryServerJoinedToRoom(ctx, inRoomReq, inRoomRes); err != nil {
+		return fmt.Errorf("r.Queryer.QueryServerJoinedToRoom: %w", err)
+	}
+	if inRoomRes.IsInRoom {
+		return r.performLocalKnock(ctx, req)
+	}
+	if len(req.ServerNames) == 0 {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNoRoom,
+			Msg:  fmt.Sprintf("Room ID %q does not exist", req.RoomIDOrAlias),
+		}
+	}
+	return r.performFederatedKnock(ctx, req)
+}
+
+// performLocalKnock knocks on a room that this server is in. The knock
+// goes through the roomserver like any other event, which checks that the
+// room version and join rules allow it.
+func (r *Knocker) performLocalKnock(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+) error {
+	userID := req.UserID
+	eb := gomatrixserverlib.EventBuilder{
+		Type:     gomatrixserverlib.MRoomMember,
+		Sender:   userID,
+		StateKey: &userID,
+		RoomID:   req.RoomIDOrAlias,
+	}
+	if err := eb.SetUnsigned(struct{}{}); err != nil {
+		return fmt.Errorf("eb.SetUnsigned: %w", err)
+	}
+	if err := eb.SetContent(req.Content); err != nil {
+		return fmt.Errorf("eb.SetContent: %w", err)
+	}
+
+	event, buildRes, err := buildEvent(ctx, r.DB, r.Cfg.Matrix, &eb)
+	if err == eventutil.ErrRoomNoExists {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNoRoom,
+			Msg:  fmt.Sprintf("Room ID %q does not exist", req.RoomIDOrAlias),
+		}
+	} else if err != nil {
+		return fmt.Errorf("buildEvent: %w", err)
+	}
+	if err = checkKnockingSupported(buildRes.RoomVersion); err != nil {
+		return err
+	}
+
+	inputReq := rsAPI.InputRoomEventsRequest{
+		InputRoomEvents: []rsAPI.InputRoomEvent{
+			{
+				Kind:         rsAPI.KindNew,
+				Event:        event,
+				SendAsServer: string(r.Cfg.Matrix.ServerName),
+			},
+		},
+	}
+	inputRes := rsAPI.InputRoomEventsResponse{}
+	r.Inputer.InputRoomEvents(ctx, &inputReq, &inputRes)
+	if err = inputRes.Err(); err != nil {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNotAllowed,
+			Msg:  fmt.Sprintf("InputRoomEvents auth failed: %s", err),
+		}
+	}
+
+	// Tell the sync API about the knock, along with the stripped state of
+	// the room so that the client knows what was knocked on.
+	info, err := r.DB.RoomInfo(ctx, req.RoomIDOrAlias)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if info == nil {
+		return fmt.Errorf("room %q has no room info", req.RoomIDOrAlias)
+	}
+	stateEvents, err := loadStrippedStateEvents(ctx, r.DB, info)
+	if err != nil {
+		return fmt.Errorf("loadStrippedStateEvents: %w", err)
+	}
+	knockState := make([]gomatrixserverlib.InviteV2StrippedState, 0, len(stateEvents))
+	for _, ev := range stateEvents {
+		knockState = append(knockState, gomatrixserverlib.NewInviteV2StrippedState(ev.Event))
+	}
+	return r.writeKnockOutput(event, buildRes.RoomVersion, knockState)
+}
+
+// performFederatedKnock knocks on a room that this server isn't in through
+// one of the servers that are. As the server has no state for the room, the
+// membership is stored in the same way as an invite received over federation.
+func (r *Knocker) performFederatedKnock(
+	ctx context.Context,
+	req *rsAPI.PerformKnockRequest,
+) error {
+	fedReq := fsAPI.PerformKnockRequest{
+		RoomID:      req.RoomIDOrAlias,
+		UserID:      req.UserID,
+		ServerNames: req.ServerNames,
+		Content:     req.Content,
+	}
+	fedRes := fsAPI.PerformKnockResponse{}
+	r.FSAPI.PerformKnock(ctx, &fedReq, &fedRes)
+	if fedRes.LastError != nil {
+		return &rsAPI.PerformError{
+			Code:       rsAPI.PerformErrRemote,
+			Msg:        fedRes.LastError.Message,
+			RemoteCode: fedRes.LastError.Code,
+		}
+	}
+
+	event := fedRes.Event
+	roomVersion := event.RoomVersion
+	updater, err := r.DB.MembershipUpdater(ctx, req.RoomIDOrAlias, req.UserID, true, roomVersion)
+	if err != nil {
+		return fmt.Errorf("r.DB.MembershipUpdater: %w", err)
+	}
+	if _, err = updater.SetToKnock(event.Unwrap()); err != nil {
+		_ = updater.Rollback()
+		return fmt.Errorf("updater.SetToKnock: %w", err)
+	}
+	if err = updater.Commit(); err != nil {
+		return fmt.Errorf("updater.Commit: %w", err)
+	}
+	return r.writeKnockOutput(event, roomVersion, fedRes.KnockRoomState)
+}
+
+func (r *Knocker) writeKnockOutput(
+	event *gomatrixserverlib.HeaderedEvent,
+	roomVersion gomatrixserverlib.RoomVersion,
+	knockState []gomatrixserverlib.InviteV2StrippedState,
+) error {
+	if err := event.SetUnsignedField("knock_room_state", knockState); err != nil {
+		return fmt.Errorf("event.SetUnsignedField: %w", err)
+	}
+	return r.Inputer.WriteOutputEvents(event.RoomID(), []rsAPI.OutputEvent{
+		{
+			Type: rsAPI.OutputTypeNewKnockEvent,
+			NewKnockEvent: &rsAPI.OutputNewKnockEvent{
+				RoomVersion: roomVersion,
+				Event:       event,
+			},
+		},
+	})
+}
+
+func checkKnockingSupported(roomVersion gomatrixserverlib.RoomVersion) error {
+	allowed, err := roomVersion.AllowKnockingInEventAuth()
+	if err != nil {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	if !allowed {
+		return &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNotAllowed,
+			Msg:  fmt.Sprintf("Room version %q does not support knocking", roomVersion),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package perform
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/query"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// knockingFederationAPI completes knocks on rooms that we aren't in as if a
+// remote server had accepted them.
+type knockingFederationAPI struct {
+	fsAPI.FederationInternalAPI
+	res fsAPI.PerformKnockResponse
+	req *fsAPI.PerformKnockRequest
+}
+
+func (a *knockingFederationAPI) PerformKnock(
+	ctx context.Context, req *fsAPI.PerformKnockRequest, res *fsAPI.PerformKnockResponse,
+) {
+	a.req = req
+	*res = a.res
+}
+
+// lastKnockOutput returns the last knock that the roomserver told the other
+// components about.
+func (rs *testRoomserver) lastKnockOutput(t *testing.T) *api.OutputNewKnockEvent {
+	t.Helper()
+	rs.jetstream.mu.Lock()
+	defer rs.jetstream.mu.Unlock()
+	for i := len(rs.jetstream.output) - 1; i >= 0; i-- {
+		if output := rs.jetstream.output[i]; output.Type == api.OutputTypeNewKnockEvent {
+			return output.NewKnockEvent
+		}
+	}
+	t.Fatalf("no knock was output")
+	return nil
+}
+
+func TestPerformKnock(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := "@alice:test", "@bob:test"
+
+	room := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomName, map[string]interface{}{"name": "Knock knock"}, "")
+	publicRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV7)
+	oldRoom := test.NewRoomWithVersion(t, alice, gomatrixserverlib.RoomVersionV6)
+
+	// A room on another server that we aren't in, with the knock that the
+	// remote server accepted.
+	remoteRoom := test.NewRoomWithVersion(t, "@carol:remote", gomatrixserverlib.RoomVersionV7)
+	remoteRoom.CreateAndInsert(t, "@carol:remote", gomatrixserverlib.MRoomJoinRules, map[string]interface{}{"join_rule": gomatrixserverlib.Knock}, "")
+	remoteKnock := remoteRoom.CreateEvent(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": gomatrixserverlib.Knock}, bob)
+	remoteKnockState := []gomatrixserverlib.InviteV2StrippedState{
+		gomatrixserverlib.NewInviteV2StrippedState(remoteRoom.Events()[0].Event),
+	}
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		rs := newTestRoomserver(t, dbType)
+		rs.mustInputRoom(t, room)
+		rs.mustInputRoom(t, publicRoom)
+		rs.mustInputRoom(t, oldRoom)
+		fedAPI := &knockingFederationAPI{}
+		knocker := &Knocker{
+			Cfg:     rs.cfg,
+			DB:      rs.db,
+			FSAPI:   fedAPI,
+			Inputer: rs.inputer,
+			Queryer: &query.Queryer{DB: rs.db, ServerName: serverName},
+		}
+		knock := func(roomID, userID string) *api.PerformKnockResponse {
+			res := &api.PerformKnockResponse{}
+			knocker.PerformKnock(ctx, &api.PerformKnockRequest{
+				RoomIDOrAlias: roomID,
+				UserID:        userID,
+				Content:       map[string]interface{}{"reason": "let me in"},
+			}, res)
+			return res
+		}
+
+		rejections := []struct {
+			name     string
+			roomID   string
+			userID   string
+			wantCode api.PerformErrorCode
+		}{
+			{name: "remote user", roomID: room.ID, userID: "@bob:remote", wantCode: api.PerformErrorBadRequest},
+			{name: "public room", roomID: publicRoom.ID, userID: bob, wantCode: api.PerformErrorNotAllowed},
+			{name: "room version without knocking", roomID: oldRoom.ID, userID: bob, wantCode: api.PerformErrorNotAllowed},
+			{name: "unknown room", roomID: "!unknown:test", userID: bob, wantCode: api.PerformErrorNoRoom},
+		}
+		for _, tc := range rejections {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				res := knock(tc.roomID, tc.userID)
+				if res.Error == nil || res.Error.Code != tc.wantCode {
+					t.Errorf("got error %+v, want code %d", res.Error, tc.wantCode)
+				}
+			})
+		}
+
+		t.Run("local room", func(t *testing.T) {
+			res := knock(room.ID, bob)
+			if res.Error != nil {
+				t.Fatalf("PerformKnock failed: %s", res.Error)
+			}
+			member := rs.mustCurrentState(t, room.ID)[stateTuple(gomatrixserverlib.MRoomMember, bob)]
+			if member == nil {
+				t.Fatalf("knock isn't in the current state")
+			}
+			content := mustUnmarshalContent(t, member)
+			if content["membership"] != gomatrixserverlib.Knock || content["reason"] != "let me in" {
+				t.Errorf("got member content %v, want a knock with the reason", content)
+			}
+
+			output := rs.lastKnockOutput(t)
+			if output.Event.EventID() != member.EventID() || output.RoomVersion != gomatrixserverlib.RoomVersionV7 {
+				t.Fatalf("got knock output for %s in version %s", output.Event.EventID(), output.RoomVersion)
+			}
+			var unsigned struct {
+				KnockRoomState []gomatrixserverlib.InviteV2StrippedState `json:"knock_room_state"`
+			}
+			if err := json.Unmarshal(output.Event.Unsigned(), &unsigned); err != nil {
+				t.Fatalf("failed to unmarshal unsigned: %s", err)
+			}
+			evTypes := map[string]bool{}
+			for _, ev := range unsigned.KnockRoomState {
+				evTypes[ev.Type()] = true
+			}
+			for _, evType := range []string{gomatrixserverlib.MRoomCreate, gomatrixserverlib.MRoomJoinRules, gomatrixserverlib.MRoomName} {
+				if !evTypes[evType] {
+					t.Errorf("knock room state is missing %s", evType)
+				}
+			}
+		})
+
+		t.Run("remote room", func(t *testing.T) {
+			fedAPI.res = fsAPI.PerformKnockResponse{
+				Event:          remoteKnock,
+				KnockRoomState: remoteKnockState,
+				KnockedVia:     "remote",
+			}
+			res := knock(remoteRoom.ID, bob)
+			if res.Error != nil {
+				t.Fatalf("PerformKnock failed: %s", res.Error)
+			}
+			if len(fedAPI.req.ServerNames) != 1 || fedAPI.req.ServerNames[0] != "remote" {
+				t.Errorf("got servers %v, want the server of the room ID", fedAPI.req.ServerNames)
+			}
+
+			updater, err := rs.db.MembershipUpdater(ctx, remoteRoom.ID, bob, true, gomatrixserverlib.RoomVersionV7)
+			if err != nil {
+				t.Fatalf("MembershipUpdater failed: %s", err)
+			}
+			isKnock := updater.IsKnock()
+			_ = updater.Rollback()
+			if !isKnock {
+				t.Errorf("knock on the remote room wasn't stored")
+			}
+			if output := rs.lastKnockOutput(t); output.Event.EventID() != remoteKnock.EventID() {
+				t.Errorf("got knock output for %s, want %s", output.Event.EventID(), remoteKnock.EventID())
+			}
+		})
+
+		t.Run("remote room refuses", func(t *testing.T) {
+			fedAPI.res = fsAPI.PerformKnockResponse{
+				LastError: &gomatrix.HTTPError{Code: 403, Message: `{"errcode":"M_FORBIDDEN"}`},
+			}
+			res := knock("!refused:remote", bob)
+			if res.Error == nil || res.Error.Code != api.PerformErrRemote || res.Error.RemoteCode != 403 {
+				t.Errorf("got error %+v, want the remote error", res.Error)
+			}
+		})
+	})
+}
@@ -0,0 +1,145 @@
+package perform
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/caching"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+)
+
+const serverName = gomatrixserverlib.ServerName("test")
+
+// dummyJetStream records the output events of the roomserver instead of
+// publishing them.
+type dummyJetStream struct {
+	nats.JetStreamContext
+	mu     sync.Mutex
+	output []api.OutputEvent
+}
+
+func (j *dummyJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	var output api.OutputEvent
+	if err := json.Unmarshal(m.Data, &output); err != nil {
+		return nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.output = append(j.output, output)
+	return &nats.PubAck{}, nil
+}
+
+// testRoomserver is a roomserver database with an inputer that processes
+// events synchronously, for the performers to use.
+type testRoomserver struct {
+	cfg       *config.RoomServer
+	db        storage.Database
+	inputer   *input.Inputer
+	jetstream *dummyJetStream
+}
+
+func newTestRoomserver(t *testing.T, dbType test.DBType) *testRoomserver {
+	t.Helper()
+	cache, err := caching.NewInMemoryLRUCache(false)
+	if err != nil {
+		t.Fatalf("failed to make cache: %s", err)
+	}
+	db, err := storage.Open(&config.DatabaseOptions{
+		ConnectionString: test.PrepareDBConnectionString(t, dbType),
+	}, cache)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	js := &dummyJetStream{}
+	return &testRoomserver{
+		cfg: &config.RoomServer{
+			Matrix: &config.Global{
+				ServerName: serverName,
+				KeyID:      test.KeyID,
+				PrivateKey: test.PrivateKey,
+			},
+		},
+		db:        db,
+		inputer:   &input.Inputer{DB: db, JetStream: js, ServerName: serverName},
+		jetstream: js,
+	}
+}
+
+// mustInputRoom sends all of the events in the room to the roomserver.
+func (rs *testRoomserver) mustInputRoom(t *testing.T, room *test.Room) {
+	t.Helper()
+	rs.mustInputEvents(t, room.Events()...)
+}
+
+func (rs *testRoomserver) mustInputEvents(t *testing.T, events ...*gomatrixserverlib.HeaderedEvent) {
+	t.Helper()
+	for _, ev := range events {
+		if err := rs.inputEvent(context.Background(), ev); err != nil {
+			t.Fatalf("failed to input event %s: %s", ev.EventID(), err)
+		}
+	}
+}
+
+// inputEvent sends a new event from this server to the roomserver, returning
+// why it was rejected if it was.
+func (rs *testRoomserver) inputEvent(ctx context.Context, ev *gomatrixserverlib.HeaderedEvent) error {
+	res := &api.InputRoomEventsResponse{}
+	rs.inputer.InputRoomEvents(ctx, &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{{
+			Kind:         api.KindNew,
+			Event:        ev,
+			Origin:       serverName,
+			SendAsServer: api.DoNotSendToOtherServers,
+		}},
+	}, res)
+	return res.Err()
+}
+
+// buildMember builds a membership event for the user in the room on top of
+// its current state.
+func (rs *testRoomserver) buildMember(
+	ctx context.Context, roomID, userID, membership string,
+) (*gomatrixserverlib.HeaderedEvent, error) {
+	builder := &gomatrixserverlib.EventBuilder{
+		Sender:   userID,
+		RoomID:   roomID,
+		Type:     gomatrixserverlib.MRoomMember,
+		StateKey: &userID,
+	}
+	if err := builder.SetContent(map[string]interface{}{"membership": membership}); err != nil {
+		return nil, err
+	}
+	ev, _, err := buildEvent(ctx, rs.db, rs.cfg.Matrix, builder)
+	return ev, err
+}
+
+// mustCurrentState returns the current state of the room, keyed by type and
+// state key.
+func (rs *testRoomserver) mustCurrentState(
+	t *testing.T, roomID string,
+) map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	var res api.QueryLatestEventsAndStateResponse
+	if err := helpers.QueryLatestEventsAndState(context.Background(), rs.db, &api.QueryLatestEventsAndStateRequest{
+		RoomID: roomID,
+	}, &res); err != nil {
+		t.Fatalf("failed to query state of %s: %s", roomID, err)
+	}
+	if !res.RoomExists {
+		t.Fatalf("room %s doesn't exist", roomID)
+	}
+	state := make(map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent, len(res.StateEvents))
+	for _, ev := range res.StateEvents {
+		state[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+	}
+	return state
+}
@@ -0,0 +1,475 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/roomserver/version"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+const mRoomTombstone = "m.room.tombstone"
+
+// upgradeStateToCopy is the state, all with empty state keys, that is copied
+// from the old room into the new room when a room is upgraded.
+var upgradeStateToCopy = []string{
+	gomatrixserverlib.MRoomJoinRules,
+	gomatrixserverlib.MRoomHistoryVisibility,
+	gomatrixserverlib.MRoomGuestAccess,
+	gomatrixserverlib.MRoomName,
+	gomatrixserverlib.MRoomTopic,
+	"m.room.avatar",
+	"m.room.encryption",
+	"m.room.server_acl",
+	gomatrixserverlib.MRoomCanonicalAlias,
+}
+
+type Upgrader struct {
+	Cfg *config.RoomServer
+	DB  storage.Database
+
+	Inputer *input.Inputer
+}
+
+// PerformRoomUpgrade upgrades a room to a new room version. The old room is
+// tombstoned and restricted, and its state, aliases and directory listing are
+// moved to a newly created room.
+func (r *Upgrader) PerformRoomUpgrade(
+	ctx context.Context,
+	req *api.PerformRoomUpgradeRequest,
+	res *api.PerformRoomUpgradeResponse,
+) {
+	newRoomID, err := r.performRoomUpgrade(ctx, req)
+	if err != nil {
+		perr, ok := err.(*api.PerformError)
+		if ok {
+			res.Error = perr
+		} else {
+			res.Error = &api.PerformError{
+				Msg: err.Error(),
+			}
+		}
+	}
+	res.NewRoomID = newRoomID
+}
+
+func (r *Upgrader) performRoomUpgrade(
+	ctx context.Context,
+	req *api.PerformRoomUpgradeRequest,
+) (string, error) {
+	_, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return "", &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Supplied user ID %q in incorrect format", req.UserID),
+		}
+	}
+	if domain != r.Cfg.Matrix.ServerName {
+		return "", &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("User %q does not belong to this homeserver", req.UserID),
+		}
+	}
+	if _, err = version.SupportedRoomVersion(req.RoomVersion); err != nil {
+		return "", &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Room version %q is not supported by this server", req.RoomVersion),
+		}
+	}
+
+	// Look up the full current state of the old room.
+	var oldState api.QueryLatestEventsAndStateResponse
+	if err = helpers.QueryLatestEventsAndState(ctx, r.DB, &api.QueryLatestEventsAndStateRequest{
+		RoomID: req.RoomID,
+	}, &oldState); err != nil {
+		return "", fmt.Errorf("helpers.QueryLatestEventsAndState: %w", err)
+	}
+	if !oldState.RoomExists {
+		return "", &api.PerformError{
+			Code: api.PerformErrorNoRoom,
+			Msg:  "Unknown room",
+		}
+	}
+	stateMap := make(map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent, len(oldState.StateEvents))
+	for _, ev := range oldState.StateEvents {
+		stateMap[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+	}
+
+	// The user must be joined to the old room and must be allowed to send a
+	// tombstone into it.
+	memberEvent := stateMap[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomMember, StateKey: req.UserID}]
+	if memberEvent == nil {
+		return "", &api.PerformError{
+			Code: api.PerformErrorNotAllowed,
+			Msg:  "You are not in the room",
+		}
+	}
+	membership, err := memberEvent.Membership()
+	if err != nil || membership != gomatrixserverlib.Join {
+		return "", &api.PerformError{
+			Code: api.PerformErrorNotAllowed,
+			Msg:  "You are not in the room",
+		}
+	}
+	plEvent := stateMap[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomPowerLevels, StateKey: ""}]
+	if plEvent == nil {
+		return "", fmt.Errorf("room %s has no power levels event", req.RoomID)
+	}
+	powerLevels, err := plEvent.PowerLevels()
+	if err != nil {
+		return "", fmt.Errorf("plEvent.PowerLevels: %w", err)
+	}
+	if powerLevels.UserLevel(req.UserID) < powerLevels.EventLevel(mRoomTombstone, true) {
+		return "", &api.PerformError{
+			Code: api.PerformErrorNotAllowed,
+			Msg:  "You don't have permission to upgrade the room",
+		}
+	}
+
+	newRoomID := fmt.Sprintf("!%s:%s", util.RandomString(16), r.Cfg.Matrix.ServerName)
+
+	// Build the tombstone first, as the create event of the new room needs to
+	// refer to it, but don't send it until the new room exists.
+	tombstone, err := r.buildOldRoomEvent(ctx, req, mRoomTombstone, map[string]interface{}{
+		"body":             "This room has been replaced",
+		"replacement_room": newRoomID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Create the new room and copy the state over.
+	eventsToMake, err := upgradeEventsToMake(req, tombstone.EventID(), stateMap, *powerLevels)
+	if err != nil {
+		return "", err
+	}
+	if err = r.createNewRoom(ctx, req, newRoomID, eventsToMake); err != nil {
+		return "", err
+	}
+
+	// Tombstone the old room, then stop anyone who isn't a moderator from
+	// talking or inviting people into it. The new room exists by now, so if
+	// the user isn't allowed to restrict the old room then just carry on.
+	if err = r.sendOldRoomEvent(ctx, tombstone); err != nil {
+		return "", err
+	}
+	restrictedLevel := powerLevels.UsersDefault + 1
+	if restrictedLevel < 50 {
+		restrictedLevel = 50
+	}
+	restricted := *powerLevels
+	restricted.EventsDefault = restrictedLevel
+	restricted.Invite = restrictedLevel
+	if err = r.buildAndSendOldRoomEvent(ctx, req, gomatrixserverlib.MRoomPowerLevels, restricted); err != nil {
+		logrus.WithError(err).WithField("room_id", req.RoomID).Warn("Failed to restrict power levels in upgraded room")
+	}
+
+	// Move the local aliases and the directory listing over.
+	if err = r.moveAliases(ctx, req, newRoomID, stateMap); err != nil {
+		return "", err
+	}
+	if err = r.movePublication(ctx, req.RoomID, newRoomID); err != nil {
+		return "", err
+	}
+
+	return newRoomID, nil
+}
+
+// upgradeEventsToMake returns the initial events of the new room, in order.
+func upgradeEventsToMake(
+	req *api.PerformRoomUpgradeRequest, tombstoneEventID string,
+	stateMap map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent,
+	powerLevels gomatrixserverlib.PowerLevelContent,
+) ([]fledglingEvent, error) {
+	createContent := map[string]interface{}{
+		"creator":      req.UserID,
+		"room_version": req.RoomVersion,
+		"predecessor": map[string]string{
+			"room_id":  req.RoomID,
+			"event_id": tombstoneEventID,
+		},
+	}
+	oldCreate := stateMap[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomCreate, StateKey: ""}]
+	if oldCreate != nil {
+		var oldCreateContent gomatrixserverlib.CreateContent
+		if err := json.Unmarshal(oldCreate.Content(), &oldCreateContent); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		if oldCreateContent.Federate != nil {
+			createContent["m.federate"] = *oldCreateContent.Federate
+		}
+	}
+
+	// Keep the user's profile from the old room.
+	var memberContent gomatrixserverlib.MemberContent
+	oldMember := stateMap[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomMember, StateKey: req.UserID}]
+	if err := json.Unmarshal(oldMember.Content(), &memberContent); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	// If the user can't send all of the copied state with their own power
+	// level then give them enough to do so, and put the original power
+	// levels back afterwards.
+	initialPowerLevels := powerLevels
+	neededLevel := powerLevels.EventLevel(gomatrixserverlib.MRoomPowerLevels, true)
+	for _, evType := range upgradeStateToCopy {
+		if level := powerLevels.EventLevel(evType, true); level > neededLevel {
+			neededLevel = level
+		}
+	}
+	if powerLevels.Ban > neededLevel {
+		neededLevel = powerLevels.Ban
+	}
+	raised := neededLevel > powerLevels.UserLevel(req.UserID)
+	if raised {
+		initialPowerLevels.Users = make(map[string]int64, len(powerLevels.Users)+1)
+		for userID, level := range powerLevels.Users {
+			initialPowerLevels.Users[userID] = level
+		}
+		initialPowerLevels.Users[req.UserID] = neededLevel
+	}
+
+	memberContent.Membership = gomatrixserverlib.Join
+	eventsToMake := []fledglingEvent{
+		{Type: gomatrixserverlib.MRoomCreate, Content: createContent},
+		{Type: gomatrixserverlib.MRoomMember, StateKey: req.UserID, Content: memberContent},
+		{Type: gomatrixserverlib.MRoomPowerLevels, Content: initialPowerLevels},
+	}
+	for _, evType := range upgradeStateToCopy {
+		ev := stateMap[gomatrixserverlib.StateKeyTuple{EventType: evType, StateKey: ""}]
+		if ev == nil {
+			continue
+		}
+		eventsToMake = append(eventsToMake, fledglingEvent{
+			Type:    evType,
+			Content: json.RawMessage(ev.Content()),
+		})
+	}
+	// Carry the bans over, so that upgrading isn't a way to get unbanned.
+	for tuple, ev := range stateMap {
+		if tuple.EventType != gomatrixserverlib.MRoomMember {
+			continue
+		}
+		if membership, err := ev.Membership(); err != nil || membership != gomatrixserverlib.Ban {
+			continue
+		}
+		eventsToMake = append(eventsToMake, fledglingEvent{
+			Type:     gomatrixserverlib.MRoomMember,
+			StateKey: tuple.StateKey,
+			Content:  json.RawMessage(ev.Content()),
+		})
+	}
+	if raised {
+		eventsToMake = append(eventsToMake, fledglingEvent{
+			Type:    gomatrixserverlib.MRoomPowerLevels,
+			Content: powerLevels,
+		})
+	}
+	return eventsToMake, nil
+}
+
+// fledglingEvent is a helper representation of an event used when building
+// up the initial events of a new room.
+type fledglingEvent struct {
+	Type     string
+	StateKey string
+	Content  interface{}
+}
+
+// createNewRoom builds the initial events of the new room and sends them to
+// the roomserver. Nobody else is in the room yet, so nothing is sent over
+// federation.
+func (r *Upgrader) createNewRoom(
+	ctx context.Context, req *api.PerformRoomUpgradeRequest, newRoomID string, eventsToMake []fledglingEvent,
+) error {
+	evTime := time.Now()
+	var builtEvents []*gomatrixserverlib.HeaderedEvent
+	authEvents := gomatrixserverlib.NewAuthEvents(nil)
+	for i, e := range eventsToMake {
+		depth := i + 1 // depth starts at 1
+
+		builder := gomatrixserverlib.EventBuilder{
+			Sender:   req.UserID,
+			RoomID:   newRoomID,
+			Type:     e.Type,
+			StateKey: &e.StateKey,
+			Depth:    int64(depth),
+		}
+		if err := builder.SetContent(e.Content); err != nil {
+			return fmt.Errorf("builder.SetContent: %w", err)
+		}
+		if i > 0 {
+			builder.PrevEvents = []gomatrixserverlib.EventReference{builtEvents[i-1].EventReference()}
+		}
+		eventsNeeded, err := gomatrixserverlib.StateNeededForEventBuilder(&builder)
+		if err != nil {
+			return fmt.Errorf("gomatrixserverlib.StateNeededForEventBuilder: %w", err)
+		}
+		builder.AuthEvents, err = eventsNeeded.AuthEventReferences(&authEvents)
+		if err != nil {
+			return fmt.Errorf("eventsNeeded.AuthEventReferences: %w", err)
+		}
+		ev, err := builder.Build(
+			evTime, r.Cfg.Matrix.ServerName, r.Cfg.Matrix.KeyID,
+			r.Cfg.Matrix.PrivateKey, req.RoomVersion,
+		)
+		if err != nil {
+			return fmt.Errorf("builder.Build: %w", err)
+		}
+		if err = gomatrixserverlib.Allowed(ev, &authEvents); err != nil {
+			return fmt.Errorf("gomatrixserverlib.Allowed: %w", err)
+		}
+		builtEvents = append(builtEvents, ev.Headered(req.RoomVersion))
+		if err = authEvents.AddEvent(ev); err != nil {
+			return fmt.Errorf("authEvents.AddEvent: %w", err)
+		}
+	}
+
+	inputReq := api.InputRoomEventsRequest{
+		InputRoomEvents: make([]api.InputRoomEvent, 0, len(builtEvents)),
+	}
+	for _, event := range builtEvents {
+		inputReq.InputRoomEvents = append(inputReq.InputRoomEvents, api.InputRoomEvent{
+			Kind:         api.KindNew,
+			Event:        event,
+			Origin:       r.Cfg.Matrix.ServerName,
+			SendAsServer: api.DoNotSendToOtherServers,
+		})
+	}
+	inputRes := api.InputRoomEventsResponse{}
+	r.Inputer.InputRoomEvents(ctx, &inputReq, &inputRes)
+	if err := inputRes.Err(); err != nil {
+		return fmt.Errorf("r.InputRoomEvents: %w", err)
+	}
+	return nil
+}
+
+// buildOldRoomEvent builds a state event with an empty state key in the old
+// room, on top of its current forward extremities.
+func (r *Upgrader) buildOldRoomEvent(
+	ctx context.Context, req *api.PerformRoomUpgradeRequest, evType string, content interface{},
+) (*gomatrixserverlib.HeaderedEvent, error) {
+	stateKey := ""
+	builder := gomatrixserverlib.EventBuilder{
+		Sender:   req.UserID,
+		RoomID:   req.RoomID,
+		Type:     evType,
+		StateKey: &stateKey,
+	}
+	if err := builder.SetContent(content); err != nil {
+		return nil, fmt.Errorf("builder.SetContent: %w", err)
+	}
+	event, _, err := buildEvent(ctx, r.DB, r.Cfg.Matrix, &builder)
+	if err != nil {
+		return nil, fmt.Errorf("buildEvent: %w", err)
+	}
+	return event, nil
+}
+
+func (r *Upgrader) buildAndSendOldRoomEvent(
+	ctx context.Context, req *api.PerformRoomUpgradeRequest, evType string, content interface{},
+) error {
+	event, err := r.buildOldRoomEvent(ctx, req, evType, content)
+	if err != nil {
+		return err
+	}
+	return r.sendOldRoomEvent(ctx, event)
+}
+
+// sendOldRoomEvent sends an event into the old room, which other servers in
+// the room need to hear about.
+func (r *Upgrader) sendOldRoomEvent(ctx context.Context, event *gomatrixserverlib.HeaderedEvent) error {
+	inputReq := api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{
+			{
+				Kind:         api.KindNew,
+				Event:        event,
+				Origin:       r.Cfg.Matrix.ServerName,
+				SendAsServer: string(r.Cfg.Matrix.ServerName),
+			},
+		},
+	}
+	inputRes := api.InputRoomEventsResponse{}
+	r.Inputer.InputRoomEvents(ctx, &inputReq, &inputRes)
+	if err := inputRes.Err(); err != nil {
+		return fmt.Errorf("r.InputRoomEvents: %w", err)
+	}
+	return nil
+}
+
+// moveAliases points the local aliases of the old room at the new room, and
+// removes the canonical alias from the old room since it no longer applies.
+func (r *Upgrader) moveAliases(
+	ctx context.Context, req *api.PerformRoomUpgradeRequest, newRoomID string,
+	stateMap map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent,
+) error {
+	aliases, err := r.DB.GetAliasesForRoomID(ctx, req.RoomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.GetAliasesForRoomID: %w", err)
+	}
+	for _, alias := range aliases {
+		creatorID, err := r.DB.GetCreatorIDForAlias(ctx, alias)
+		if err != nil {
+			return fmt.Errorf("r.DB.GetCreatorIDForAlias: %w", err)
+		}
+		if err = r.DB.RemoveRoomAlias(ctx, alias); err != nil {
+			return fmt.Errorf("r.DB.RemoveRoomAlias: %w", err)
+		}
+		if err = r.DB.SetRoomAlias(ctx, alias, newRoomID, creatorID); err != nil {
+			return fmt.Errorf("r.DB.SetRoomAlias: %w", err)
+		}
+	}
+
+	if stateMap[gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomCanonicalAlias, StateKey: ""}] == nil {
+		return nil
+	}
+	if err = r.buildAndSendOldRoomEvent(ctx, req, gomatrixserverlib.MRoomCanonicalAlias, struct{}{}); err != nil {
+		logrus.WithError(err).WithField("room_id", req.RoomID).Warn("Failed to remove canonical alias from upgraded room")
+	}
+	return nil
+}
+
+// movePublication lists the new room in the room directory in place of the
+// old room, if the old room was listed.
+func (r *Upgrader) movePublication(ctx context.Context, oldRoomID, newRoomID string) error {
+	published, err := r.DB.GetPublishedRooms(ctx)
+	if err != nil {
+		return fmt.Errorf("r.DB.GetPublishedRooms: %w", err)
+	}
+	for _, roomID := range published {
+		if roomID != oldRoomID {
+			continue
+		}
+		if err = r.DB.PublishRoom(ctx, newRoomID, true); err != nil {
+			return fmt.Errorf("r.DB.PublishRoom: %w", err)
+		}
+		if err = r.DB.PublishRoom(ctx, oldRoomID, false); err != nil {
+			return fmt.Errorf("r.DB.PublishRoom: %w", err)
+		}
+		break
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package perform
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func stateTuple(evType, stateKey string) gomatrixserverlib.StateKeyTuple {
+	return gomatrixserverlib.StateKeyTuple{EventType: evType, StateKey: stateKey}
+}
+
+func mustUnmarshalContent(t *testing.T, ev *gomatrixserverlib.HeaderedEvent) map[string]interface{} {
+	t.Helper()
+	var content map[string]interface{}
+	if err := json.Unmarshal(ev.Content(), &content); err != nil {
+		t.Fatalf("failed to unmarshal content of %s: %s", ev.EventID(), err)
+	}
+	return content
+}
+
+func TestPerformRoomUpgrade(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, charlie, dave := "@alice:test", "@bob:test", "@charlie:test", "@dave:test"
+	alias := "#old:test"
+
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, bob)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomName, map[string]interface{}{"name": "Old room"}, "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomTopic, map[string]interface{}{"topic": "Upgrades"}, "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomCanonicalAlias, map[string]interface{}{"alias": alias}, "")
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "ban"}, charlie)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		rs := newTestRoomserver(t, dbType)
+		rs.mustInputRoom(t, room)
+		if err := rs.db.SetRoomAlias(ctx, alias, room.ID, alice); err != nil {
+			t.Fatalf("failed to set alias: %s", err)
+		}
+		if err := rs.db.PublishRoom(ctx, room.ID, true); err != nil {
+			t.Fatalf("failed to publish room: %s", err)
+		}
+		upgrader := &Upgrader{Cfg: rs.cfg, DB: rs.db, Inputer: rs.inputer}
+		oldState := rs.mustCurrentState(t, room.ID)
+
+		rejections := []struct {
+			name     string
+			userID   string
+			version  gomatrixserverlib.RoomVersion
+			wantCode api.PerformErrorCode
+		}{
+			{name: "unsupported version", userID: alice, version: "999", wantCode: api.PerformErrorBadRequest},
+			{name: "not enough power", userID: bob, version: gomatrixserverlib.RoomVersionV6, wantCode: api.PerformErrorNotAllowed},
+			{name: "not in the room", userID: dave, version: gomatrixserverlib.RoomVersionV6, wantCode: api.PerformErrorNotAllowed},
+		}
+		for _, tc := range rejections {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				res := &api.PerformRoomUpgradeResponse{}
+				upgrader.PerformRoomUpgrade(ctx, &api.PerformRoomUpgradeRequest{
+					RoomID: room.ID, UserID: tc.userID, RoomVersion: tc.version,
+				}, res)
+				if res.Error == nil || res.Error.Code != tc.wantCode {
+					t.Fatalf("got error %+v, want code %d", res.Error, tc.wantCode)
+				}
+				if res.NewRoomID != "" {
+					t.Errorf("got new room %s for a rejected upgrade", res.NewRoomID)
+				}
+			})
+		}
+		// None of the rejected upgrades should have touched the old room.
+		if state := rs.mustCurrentState(t, room.ID); state[stateTuple(mRoomTombstone, "")] != nil {
+			t.Fatalf("rejected upgrade tombstoned the room")
+		}
+
+		res := &api.PerformRoomUpgradeResponse{}
+		upgrader.PerformRoomUpgrade(ctx, &api.PerformRoomUpgradeRequest{
+			RoomID: room.ID, UserID: alice, RoomVersion: gomatrixserverlib.RoomVersionV6,
+		}, res)
+		if res.Error != nil {
+			t.Fatalf("PerformRoomUpgrade failed: %s", res.Error)
+		}
+		newRoomID := res.NewRoomID
+		newState := rs.mustCurrentState(t, newRoomID)
+		updatedOldState := rs.mustCurrentState(t, room.ID)
+
+		t.Run("tombstone", func(t *testing.T) {
+			tombstone := updatedOldState[stateTuple(mRoomTombstone, "")]
+			if tombstone == nil {
+				t.Fatalf("old room has no tombstone")
+			}
+			if got := mustUnmarshalContent(t, tombstone)["replacement_room"]; got != newRoomID {
+				t.Errorf("got replacement room %v, want %s", got, newRoomID)
+			}
+			create := newState[stateTuple(gomatrixserverlib.MRoomCreate, "")]
+			if create == nil {
+				t.Fatalf("new room has no create event")
+			}
+			content := mustUnmarshalContent(t, create)
+			wantPredecessor := map[string]interface{}{"room_id": room.ID, "event_id": tombstone.EventID()}
+			if !reflect.DeepEqual(content["predecessor"], wantPredecessor) {
+				t.Errorf("got predecessor %v, want %v", content["predecessor"], wantPredecessor)
+			}
+			if content["room_version"] != string(gomatrixserverlib.RoomVersionV6) {
+				t.Errorf("got room version %v, want %s", content["room_version"], gomatrixserverlib.RoomVersionV6)
+			}
+		})
+
+		t.Run("copied state", func(t *testing.T) {
+			for _, tuple := range []gomatrixserverlib.StateKeyTuple{
+				stateTuple(gomatrixserverlib.MRoomJoinRules, ""),
+				stateTuple(gomatrixserverlib.MRoomName, ""),
+				stateTuple(gomatrixserverlib.MRoomTopic, ""),
+				stateTuple(gomatrixserverlib.MRoomCanonicalAlias, ""),
+				stateTuple(gomatrixserverlib.MRoomMember, charlie),
+			} {
+				got, want := newState[tuple], oldState[tuple]
+				if got == nil {
+					t.Errorf("new room is missing %s %q", tuple.EventType, tuple.StateKey)
+					continue
+				}
+				if !reflect.DeepEqual(mustUnmarshalContent(t, got), mustUnmarshalContent(t, want)) {
+					t.Errorf("got %s %q content %s, want %s", tuple.EventType, tuple.StateKey, got.Content(), want.Content())
+				}
+			}
+			// The power levels are re-encoded, so compare what they say.
+			gotPL, err := newState[stateTuple(gomatrixserverlib.MRoomPowerLevels, "")].PowerLevels()
+			if err != nil {
+				t.Fatalf("failed to get new power levels: %s", err)
+			}
+			wantPL, err := oldState[stateTuple(gomatrixserverlib.MRoomPowerLevels, "")].PowerLevels()
+			if err != nil {
+				t.Fatalf("failed to get old power levels: %s", err)
+			}
+			if !reflect.DeepEqual(gotPL.Users, wantPL.Users) || gotPL.UsersDefault != wantPL.UsersDefault ||
+				gotPL.EventsDefault != wantPL.EventsDefault || gotPL.StateDefault != wantPL.StateDefault ||
+				gotPL.Invite != wantPL.Invite || gotPL.Ban != wantPL.Ban {
+				t.Errorf("got power levels %+v, want %+v", gotPL, wantPL)
+			}
+			member := newState[stateTuple(gomatrixserverlib.MRoomMember, alice)]
+			if member == nil {
+				t.Fatalf("upgrading user isn't in the new room")
+			}
+			if membership, _ := member.Membership(); membership != gomatrixserverlib.Join {
+				t.Errorf("got membership %q for the upgrading user, want join", membership)
+			}
+			if newState[stateTuple(gomatrixserverlib.MRoomMember, bob)] != nil {
+				t.Errorf("other members were copied into the new room")
+			}
+		})
+
+		t.Run("restricted power levels", func(t *testing.T) {
+			plEvent := updatedOldState[stateTuple(gomatrixserverlib.MRoomPowerLevels, "")]
+			// Older room versions don't parse events_default from the
+			// content, so check what was sent rather than what is parsed.
+			content := mustUnmarshalContent(t, plEvent)
+			if content["events_default"] != float64(50) || content["invite"] != float64(50) {
+				t.Errorf("got events_default %v and invite %v, want 50", content["events_default"], content["invite"])
+			}
+			powerLevels, err := plEvent.PowerLevels()
+			if err != nil {
+				t.Fatalf("failed to get power levels: %s", err)
+			}
+			if powerLevels.UserLevel(alice) != 100 {
+				t.Errorf("got power level %d for the upgrading user, want 100", powerLevels.UserLevel(alice))
+			}
+		})
+
+		t.Run("aliases", func(t *testing.T) {
+			roomID, err := rs.db.GetRoomIDForAlias(ctx, alias)
+			if err != nil {
+				t.Fatalf("GetRoomIDForAlias failed: %s", err)
+			}
+			if roomID != newRoomID {
+				t.Errorf("got alias pointing at %s, want %s", roomID, newRoomID)
+			}
+			if got := mustUnmarshalContent(t, updatedOldState[stateTuple(gomatrixserverlib.MRoomCanonicalAlias, "")]); len(got) != 0 {
+				t.Errorf("got canonical alias %v in the old room, want none", got)
+			}
+			published, err := rs.db.GetPublishedRooms(ctx)
+			if err != nil {
+				t.Fatalf("GetPublishedRooms failed: %s", err)
+			}
+			if !reflect.DeepEqual(published, []string{newRoomID}) {
+				t.Errorf("got published rooms %v, want %v", published, []string{newRoomID})
+			}
+		})
+	})
+}
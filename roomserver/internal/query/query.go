@@ -16,6 +16,7 @@ package query
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -724,3 +725,127 @@ func (r *Queryer) QueryAuthChain(ctx context.Context, req *api.QueryAuthChainReq
 	res.AuthChain = hchain
 	return nil
 }
+
+// QueryRestrictedJoinAllowed implements api.RoomserverInternalAPI
+func (r *Queryer) QueryRestrictedJoinAllowed(ctx context.Context, req *api.QueryRestrictedJoinAllowedRequest, res *api.QueryRestrictedJoinAllowedResponse) error {
+	// If we don't know about the room, or it's only a stub from an invite,
+	// then we can't say anything about it.
+	info, err := r.DB.RoomInfo(ctx, req.RoomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if info == nil || info.IsStub {
+		return nil
+	}
+	if allowed, err := info.RoomVersion.AllowRestrictedJoinsInEventAuth(); err != nil || !allowed {
+		return err
+	}
+
+	joinRulesEvent, err := r.DB.GetStateEvent(ctx, req.RoomID, gomatrixserverlib.MRoomJoinRules, "")
+	if err != nil {
+		return fmt.Errorf("r.DB.GetStateEvent: %w", err)
+	}
+	if joinRulesEvent == nil {
+		return nil
+	}
+	var joinRules gomatrixserverlib.JoinRuleContent
+	if err = json.Unmarshal(joinRulesEvent.Content(), &joinRules); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	res.Restricted = joinRules.JoinRule == gomatrixserverlib.Restricted
+	if !res.Restricted {
+		return nil
+	}
+
+	// We can only authorise joins to rooms that we are joined to.
+	res.Resident, err = r.DB.GetLocalServerInRoom(ctx, info.RoomNID)
+	if err != nil {
+		return fmt.Errorf("r.DB.GetLocalServerInRoom: %w", err)
+	}
+	if !res.Resident {
+		return nil
+	}
+
+	// Users who are already joined or invited don't need the join to be
+	// authorised, as the auth rules will let them in anyway.
+	if _, isJoined, _, err := r.DB.GetMembership(ctx, info.RoomNID, req.UserID); err != nil {
+		return fmt.Errorf("r.DB.GetMembership: %w", err)
+	} else if isJoined {
+		res.Allowed = true
+		return nil
+	}
+	if isInvited, _, _, err := helpers.IsInvitePending(ctx, r.DB, req.RoomID, req.UserID); err != nil {
+		return fmt.Errorf("helpers.IsInvitePending: %w", err)
+	} else if isInvited {
+		res.Allowed = true
+		return nil
+	}
+
+	// Work out whether the user is joined to any of the rooms in the allow
+	// conditions. If we aren't in one of those rooms then our view of its
+	// memberships can't be trusted, so we aren't fully resident.
+	for _, rule := range joinRules.Allow {
+		if rule.Type != gomatrixserverlib.MRoomMembership {
+			continue
+		}
+		allowedInfo, err := r.DB.RoomInfo(ctx, rule.RoomID)
+		if err != nil {
+			return fmt.Errorf("r.DB.RoomInfo: %w", err)
+		}
+		if allowedInfo == nil || allowedInfo.IsStub {
+			res.Resident = false
+			continue
+		}
+		if isIn, err := r.DB.GetLocalServerInRoom(ctx, allowedInfo.RoomNID); err != nil {
+			return fmt.Errorf("r.DB.GetLocalServerInRoom: %w", err)
+		} else if !isIn {
+			res.Resident = false
+			continue
+		}
+		if _, isJoined, _, err := r.DB.GetMembership(ctx, allowedInfo.RoomNID, req.UserID); err != nil {
+			return fmt.Errorf("r.DB.GetMembership: %w", err)
+		} else if isJoined {
+			res.Allowed = true
+			break
+		}
+	}
+	if !res.Allowed {
+		return nil
+	}
+
+	// The join is allowed, so pick one of our own users who has the power to
+	// invite to authorise it.
+	powerLevelsEvent, err := r.DB.GetStateEvent(ctx, req.RoomID, gomatrixserverlib.MRoomPowerLevels, "")
+	if err != nil {
+		return fmt.Errorf("r.DB.GetStateEvent: %w", err)
+	}
+	if powerLevelsEvent == nil {
+		return fmt.Errorf("room %q has no power levels", req.RoomID)
+	}
+	powerLevels, err := powerLevelsEvent.PowerLevels()
+	if err != nil {
+		return fmt.Errorf("powerLevelsEvent.PowerLevels: %w", err)
+	}
+	joinNIDs, err := r.DB.GetMembershipEventNIDsForRoom(ctx, info.RoomNID, true, true)
+	if err != nil {
+		return fmt.Errorf("r.DB.GetMembershipEventNIDsForRoom: %w", err)
+	}
+	events, err := r.DB.Events(ctx, joinNIDs)
+	if err != nil {
+		return fmt.Errorf("r.DB.Events: %w", err)
+	}
+	for _, event := range events {
+		if event.StateKey() == nil {
+			continue
+		}
+		if powerLevels.UserLevel(*event.StateKey()) >= powerLevels.Invite {
+			res.AuthorisedVia = *event.StateKey()
+			return nil
+		}
+	}
+
+	// None of our users can invite, so another resident server will have
+	// to authorise the join instead.
+	res.Resident = false
+	return nil
+}
@@ -134,6 +134,14 @@ type Database interface {
 	PublishRoom(ctx context.Context, roomID string, publish bool) error
 	// Returns a list of room IDs for rooms which are published.
 	GetPublishedRooms(ctx context.Context) ([]string, error)
+	// Stop local users from joining a room, e.g. because an admin has shut it down.
+	BlockRoom(ctx context.Context, roomID, userID string) error
+	// Returns whether local users are no longer allowed to join a room.
+	IsRoomBlocked(ctx context.Context, roomID string) (bool, error)
+	// Look up the JSON of every event in a room.
+	RoomEventJSONs(ctx context.Context, roomID string) ([][]byte, error)
+	// Remove a room along with all of its events and state snapshots.
+	PurgeRoom(ctx context.Context, roomID string) error
 
 	// TODO: factor out - from currentstateserver
 
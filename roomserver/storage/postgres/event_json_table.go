@@ -54,9 +54,16 @@ const bulkSelectEventJSONSQL = "" +
 	" WHERE event_nid = ANY($1)" +
 	" ORDER BY event_nid ASC"
 
+// Event JSON lookup for every event in a room.
+const selectEventJSONsForRoomSQL = "" +
+	"SELECT j.event_json FROM roomserver_event_json AS j" +
+	" INNER JOIN roomserver_events AS e ON e.event_nid = j.event_nid" +
+	" WHERE e.room_nid = $1"
+
 type eventJSONStatements struct {
-	insertEventJSONStmt     *sql.Stmt
-	bulkSelectEventJSONStmt *sql.Stmt
+	insertEventJSONStmt         *sql.Stmt
+	bulkSelectEventJSONStmt     *sql.Stmt
+	selectEventJSONsForRoomStmt *sql.Stmt
 }
 
 func createEventJSONTable(db *sql.DB) error {
@@ -70,6 +77,7 @@ func prepareEventJSONTable(db *sql.DB) (tables.EventJSON, error) {
 	return s, sqlutil.StatementList{
 		{&s.insertEventJSONStmt, insertEventJSONSQL},
 		{&s.bulkSelectEventJSONStmt, bulkSelectEventJSONSQL},
+		{&s.selectEventJSONsForRoomStmt, selectEventJSONsForRoomSQL},
 	}.Prepare(db)
 }
 
@@ -107,3 +115,22 @@ func (s *eventJSONStatements) BulkSelectEventJSON(
 	}
 	return results[:i], rows.Err()
 }
+
+func (s *eventJSONStatements) SelectEventJSONsForRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) ([][]byte, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectEventJSONsForRoomStmt).QueryContext(ctx, int64(roomNID))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventJSONsForRoom: rows.close() failed")
+	var results [][]byte
+	for rows.Next() {
+		var eventJSON []byte
+		if err = rows.Scan(&eventJSON); err != nil {
+			return nil, err
+		}
+		results = append(results, eventJSON)
+	}
+	return results, rows.Err()
+}
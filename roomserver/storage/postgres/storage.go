@@ -106,6 +106,9 @@ func (d *Database) create(db *sql.DB) error {
 	if err := createRedactionsTable(db); err != nil {
 		return err
 	}
+	if err := createBlockedRoomsTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -163,6 +166,14 @@ func (d *Database) prepare(db *sql.DB, cache caching.RoomServerCaches) error {
 	if err != nil {
 		return err
 	}
+	blockedRooms, err := prepareBlockedRoomsTable(db)
+	if err != nil {
+		return err
+	}
+	purge, err := preparePurgeStatements(db)
+	if err != nil {
+		return err
+	}
 	d.Database = shared.Database{
 		DB:                  db,
 		Cache:               cache,
@@ -180,6 +191,8 @@ func (d *Database) prepare(db *sql.DB, cache caching.RoomServerCaches) error {
 		MembershipTable:     membership,
 		PublishedTable:      published,
 		RedactionsTable:     redactions,
+		BlockedRoomsTable:   blockedRooms,
+		Purge:               purge,
 	}
 	return nil
 }
@@ -42,6 +42,8 @@ type Database struct {
 	MembershipTable     tables.Membership
 	PublishedTable      tables.Published
 	RedactionsTable     tables.Redactions
+	BlockedRoomsTable   tables.BlockedRooms
+	Purge               tables.Purge
 	GetRoomUpdaterFn    func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
 }
 
@@ -681,6 +683,54 @@ func (d *Database) GetPublishedRooms(ctx context.Context) ([]string, error) {
 	return d.PublishedTable.SelectAllPublishedRooms(ctx, nil, true)
 }
 
+func (d *Database) BlockRoom(ctx context.Context, roomID, userID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.BlockedRoomsTable.InsertBlockedRoom(ctx, txn, roomID, userID)
+	})
+}
+
+func (d *Database) IsRoomBlocked(ctx context.Context, roomID string) (bool, error) {
+	return d.BlockedRoomsTable.SelectBlockedRoom(ctx, nil, roomID)
+}
+
+// RoomEventJSONs returns the JSON of every event in the room, or nothing if
+// the room doesn't exist.
+func (d *Database) RoomEventJSONs(ctx context.Context, roomID string) ([][]byte, error) {
+	roomInfo, err := d.RoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("d.RoomInfo: %w", err)
+	}
+	if roomInfo == nil {
+		return nil, nil
+	}
+	return d.EventJSONTable.SelectEventJSONsForRoom(ctx, nil, roomInfo.RoomNID)
+}
+
+// PurgeRoom removes the room and all of its events and state snapshots.
+// Does nothing if the room doesn't exist.
+func (d *Database) PurgeRoom(ctx context.Context, roomID string) error {
+	roomInfo, err := d.RoomInfo(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("d.RoomInfo: %w", err)
+	}
+	if roomInfo == nil {
+		return nil
+	}
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		// Lock the room so that nothing else is written to it while it's
+		// being purged.
+		if _, _, _, err = d.RoomsTable.SelectLatestEventsNIDsForUpdate(ctx, txn, roomInfo.RoomNID); err != nil {
+			return fmt.Errorf("d.RoomsTable.SelectLatestEventsNIDsForUpdate: %w", err)
+		}
+		return d.Purge.PurgeRoom(ctx, txn, roomInfo.RoomNID, roomID)
+	})
+	if err != nil {
+		return err
+	}
+	d.Cache.InvalidateRoomInfo(roomID)
+	return nil
+}
+
 func (d *Database) assignRoomNID(
 	ctx context.Context, txn *sql.Tx,
 	roomID string, roomVersion gomatrixserverlib.RoomVersion,
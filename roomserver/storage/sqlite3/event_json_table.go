@@ -46,10 +46,18 @@ const bulkSelectEventJSONSQL = `
 	  ORDER BY event_nid ASC
 `
 
+// Event JSON lookup for every event in a room.
+const selectEventJSONsForRoomSQL = `
+	SELECT j.event_json FROM roomserver_event_json AS j
+	  INNER JOIN roomserver_events AS e ON e.event_nid = j.event_nid
+	  WHERE e.room_nid = $1
+`
+
 type eventJSONStatements struct {
-	db                      *sql.DB
-	insertEventJSONStmt     *sql.Stmt
-	bulkSelectEventJSONStmt *sql.Stmt
+	db                          *sql.DB
+	insertEventJSONStmt         *sql.Stmt
+	bulkSelectEventJSONStmt     *sql.Stmt
+	selectEventJSONsForRoomStmt *sql.Stmt
 }
 
 func createEventJSONTable(db *sql.DB) error {
@@ -65,6 +73,7 @@ func prepareEventJSONTable(db *sql.DB) (tables.EventJSON, error) {
 	return s, sqlutil.StatementList{
 		{&s.insertEventJSONStmt, insertEventJSONSQL},
 		{&s.bulkSelectEventJSONStmt, bulkSelectEventJSONSQL},
+		{&s.selectEventJSONsForRoomStmt, selectEventJSONsForRoomSQL},
 	}.Prepare(db)
 }
 
@@ -111,3 +120,22 @@ func (s *eventJSONStatements) BulkSelectEventJSON(
 	}
 	return results[:i], nil
 }
+
+func (s *eventJSONStatements) SelectEventJSONsForRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) ([][]byte, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectEventJSONsForRoomStmt).QueryContext(ctx, int64(roomNID))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventJSONsForRoom: rows.close() failed")
+	var results [][]byte
+	for rows.Next() {
+		var eventJSON []byte
+		if err = rows.Scan(&eventJSON); err != nil {
+			return nil, err
+		}
+		results = append(results, eventJSON)
+	}
+	return results, rows.Err()
+}
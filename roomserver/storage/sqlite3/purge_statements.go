@@ -0,0 +1,194 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+)
+
+const purgeEventJSONSQL = "" +
+	"DELETE FROM roomserver_event_json WHERE event_nid IN (" +
+	" SELECT event_nid FROM roomserver_events WHERE room_nid = $1" +
+	")"
+
+const purgePreviousEventsSQL = "" +
+	"DELETE FROM roomserver_previous_events WHERE previous_event_id IN (" +
+	" SELECT event_id FROM roomserver_events WHERE room_nid = $1" +
+	")"
+
+const purgeRedactionsSQL = "" +
+	"DELETE FROM roomserver_redactions WHERE redaction_event_id IN (" +
+	" SELECT event_id FROM roomserver_events WHERE room_nid = $1" +
+	")"
+
+// The state block NIDs of a snapshot are stored as JSON, so the state blocks
+// are found with selectStateBlockNIDsSQL and then deleted in batches.
+const selectStateBlockNIDsSQL = "" +
+	"SELECT state_block_nids FROM roomserver_state_snapshots WHERE room_nid = $1"
+
+const purgeStateBlockEntriesSQL = "" +
+	"DELETE FROM roomserver_state_block WHERE state_block_nid IN ($1)"
+
+const purgeStateSnapshotEntriesSQL = "" +
+	"DELETE FROM roomserver_state_snapshots WHERE room_nid = $1"
+
+const purgeEventsSQL = "" +
+	"DELETE FROM roomserver_events WHERE room_nid = $1"
+
+const purgeInvitesSQL = "" +
+	"DELETE FROM roomserver_invites WHERE room_nid = $1"
+
+const purgeMembershipsSQL = "" +
+	"DELETE FROM roomserver_membership WHERE room_nid = $1"
+
+const purgeRoomSQL = "" +
+	"DELETE FROM roomserver_rooms WHERE room_nid = $1"
+
+const purgePublishedSQL = "" +
+	"DELETE FROM roomserver_published WHERE room_id = $1"
+
+const purgeRoomAliasesSQL = "" +
+	"DELETE FROM roomserver_room_aliases WHERE room_id = $1"
+
+type purgeStatements struct {
+	db                            *sql.DB
+	purgeEventJSONStmt            *sql.Stmt
+	purgePreviousEventsStmt       *sql.Stmt
+	purgeRedactionsStmt           *sql.Stmt
+	selectStateBlockNIDsStmt      *sql.Stmt
+	purgeStateSnapshotEntriesStmt *sql.Stmt
+	purgeEventsStmt               *sql.Stmt
+	purgeInvitesStmt              *sql.Stmt
+	purgeMembershipsStmt          *sql.Stmt
+	purgeRoomStmt                 *sql.Stmt
+	purgePublishedStmt            *sql.Stmt
+	purgeRoomAliasesStmt          *sql.Stmt
+}
+
+func preparePurgeStatements(db *sql.DB) (tables.Purge, error) {
+	s := &purgeStatements{
+		db: db,
+	}
+
+	return s, sqlutil.StatementList{
+		{&s.purgeEventJSONStmt, purgeEventJSONSQL},
+		{&s.purgePreviousEventsStmt, purgePreviousEventsSQL},
+		{&s.purgeRedactionsStmt, purgeRedactionsSQL},
+		{&s.selectStateBlockNIDsStmt, selectStateBlockNIDsSQL},
+		{&s.purgeStateSnapshotEntriesStmt, purgeStateSnapshotEntriesSQL},
+		{&s.purgeEventsStmt, purgeEventsSQL},
+		{&s.purgeInvitesStmt, purgeInvitesSQL},
+		{&s.purgeMembershipsStmt, purgeMembershipsSQL},
+		{&s.purgeRoomStmt, purgeRoomSQL},
+		{&s.purgePublishedStmt, purgePublishedSQL},
+		{&s.purgeRoomAliasesStmt, purgeRoomAliasesSQL},
+	}.Prepare(db)
+}
+
+// PurgeRoom deletes the room and everything that refers to it. The order
+// matters, as some of the deletions look up the rows of the later ones.
+func (s *purgeStatements) PurgeRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, roomID string,
+) error {
+	for _, stmt := range []*sql.Stmt{
+		s.purgeEventJSONStmt,
+		s.purgePreviousEventsStmt,
+		s.purgeRedactionsStmt,
+	} {
+		if _, err := sqlutil.TxStmt(txn, stmt).ExecContext(ctx, roomNID); err != nil {
+			return err
+		}
+	}
+	if err := s.purgeStateBlocks(ctx, txn, roomNID); err != nil {
+		return err
+	}
+	for _, stmt := range []*sql.Stmt{
+		s.purgeStateSnapshotEntriesStmt,
+		s.purgeEventsStmt,
+		s.purgeInvitesStmt,
+		s.purgeMembershipsStmt,
+		s.purgeRoomStmt,
+	} {
+		if _, err := sqlutil.TxStmt(txn, stmt).ExecContext(ctx, roomNID); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range []*sql.Stmt{
+		s.purgePublishedStmt,
+		s.purgeRoomAliasesStmt,
+	} {
+		if _, err := sqlutil.TxStmt(txn, stmt).ExecContext(ctx, roomID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *purgeStatements) purgeStateBlocks(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) error {
+	rows, err := sqlutil.TxStmt(txn, s.selectStateBlockNIDsStmt).QueryContext(ctx, roomNID)
+	if err != nil {
+		return err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "purgeStateBlocks: rows.close() failed")
+	seen := map[types.StateBlockNID]struct{}{}
+	var stateBlockNIDs []interface{}
+	for rows.Next() {
+		var stateBlockNIDsJSON string
+		if err = rows.Scan(&stateBlockNIDsJSON); err != nil {
+			return err
+		}
+		var nids []types.StateBlockNID
+		if err = json.Unmarshal([]byte(stateBlockNIDsJSON), &nids); err != nil {
+			return err
+		}
+		for _, nid := range nids {
+			if _, ok := seen[nid]; ok {
+				continue
+			}
+			seen[nid] = struct{}{}
+			stateBlockNIDs = append(stateBlockNIDs, nid)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(stateBlockNIDs); start += sqlutil.SQLite3MaxVariables {
+		end := start + sqlutil.SQLite3MaxVariables
+		if end > len(stateBlockNIDs) {
+			end = len(stateBlockNIDs)
+		}
+		query := strings.Replace(purgeStateBlockEntriesSQL, "($1)", sqlutil.QueryVariadic(end-start), 1)
+		if txn != nil {
+			_, err = txn.ExecContext(ctx, query, stateBlockNIDs[start:end]...)
+		} else {
+			_, err = s.db.ExecContext(ctx, query, stateBlockNIDs[start:end]...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -114,6 +114,9 @@ func (d *Database) create(db *sql.DB) error {
 	if err := createRedactionsTable(db); err != nil {
 		return err
 	}
+	if err := createBlockedRoomsTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -171,6 +174,14 @@ func (d *Database) prepare(db *sql.DB, cache caching.RoomServerCaches) error {
 	if err != nil {
 		return err
 	}
+	blockedRooms, err := prepareBlockedRoomsTable(db)
+	if err != nil {
+		return err
+	}
+	purge, err := preparePurgeStatements(db)
+	if err != nil {
+		return err
+	}
 	d.Database = shared.Database{
 		DB:                  db,
 		Cache:               cache,
@@ -188,6 +199,8 @@ func (d *Database) prepare(db *sql.DB, cache caching.RoomServerCaches) error {
 		MembershipTable:     membership,
 		PublishedTable:      published,
 		RedactionsTable:     redactions,
+		BlockedRoomsTable:   blockedRooms,
+		Purge:               purge,
 		GetRoomUpdaterFn:    d.GetRoomUpdater,
 	}
 	return nil
@@ -19,6 +19,8 @@ type EventJSON interface {
 	// Insert the event JSON. On conflict, replace the event JSON with the new value (for redactions).
 	InsertEventJSON(ctx context.Context, tx *sql.Tx, eventNID types.EventNID, eventJSON []byte) error
 	BulkSelectEventJSON(ctx context.Context, tx *sql.Tx, eventNIDs []types.EventNID) ([]EventJSONPair, error)
+	// SelectEventJSONsForRoom returns the JSON of every event in the room.
+	SelectEventJSONsForRoom(ctx context.Context, tx *sql.Tx, roomNID types.RoomNID) ([][]byte, error)
 }
 
 type EventTypes interface {
@@ -141,6 +143,18 @@ type Published interface {
 	SelectAllPublishedRooms(ctx context.Context, txn *sql.Tx, published bool) ([]string, error)
 }
 
+// BlockedRooms are rooms that an admin has shut down, which local users
+// may no longer join.
+type BlockedRooms interface {
+	InsertBlockedRoom(ctx context.Context, txn *sql.Tx, roomID, userID string) error
+	SelectBlockedRoom(ctx context.Context, txn *sql.Tx, roomID string) (bool, error)
+}
+
+// Purge removes everything that the roomserver knows about a room.
+type Purge interface {
+	PurgeRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, roomID string) error
+}
+
 type RedactionInfo struct {
 	// whether this redaction is validated (we have both events)
 	Validated bool
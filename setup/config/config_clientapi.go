@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -38,6 +40,9 @@ type ClientAPI struct {
 	// Rate-limiting options
 	RateLimiting RateLimiting `yaml:"rate_limiting"`
 
+	// Login options
+	Login Login `yaml:"login"`
+
 	MSCs *MSCs `yaml:"mscs"`
 }
 
@@ -52,7 +57,9 @@ func (c *ClientAPI) Defaults(generate bool) {
 	c.RecaptchaBypassSecret = ""
 	c.RecaptchaSiteVerifyAPI = ""
 	c.RegistrationDisabled = false
+	c.TURN.AllowGuests = true
 	c.RateLimiting.Defaults()
+	c.Login.SSO.Defaults()
 }
 
 func (c *ClientAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
@@ -68,12 +75,12 @@ func (c *ClientAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	}
 	c.TURN.Verify(configErrs)
 	c.RateLimiting.Verify(configErrs)
+	c.Login.SSO.Verify(configErrs)
 }
 
 type TURN struct {
-	// TODO Guest Support
 	// Whether or not guests can request TURN credentials
-	// AllowGuests bool `yaml:"turn_allow_guests"`
+	AllowGuests bool `yaml:"turn_allow_guests"`
 	// How long the authorization should last
 	UserLifetime string `yaml:"turn_user_lifetime"`
 	// The list of TURN URIs to pass to clients
@@ -123,3 +130,116 @@ func (r *RateLimiting) Defaults() {
 	r.Threshold = 5
 	r.CooloffMS = 500
 }
+
+type Login struct {
+	// Single sign-on options
+	SSO SSO `yaml:"sso"`
+}
+
+type SSO struct {
+	// Is single sign-on enabled or disabled?
+	Enabled bool `yaml:"enabled"`
+
+	// The URL that identity providers should redirect users back to after
+	// they have logged in. This must point at /_matrix/client/r0/login/sso/callback
+	// on this server as seen by the user's browser, and must normally be
+	// registered with each of the identity providers.
+	CallbackURL string `yaml:"callback_url"`
+
+	// The identity provider to use when the client doesn't ask for a
+	// particular one. Defaults to the first provider.
+	DefaultProviderID string `yaml:"default_provider"`
+
+	// The OpenID Connect identity providers that users can log in with.
+	Providers []IdentityProvider `yaml:"providers"`
+
+	// The clients that users can be sent back to after logging in, as URL
+	// prefixes. The redirect to the client carries a login token, so logins
+	// for any other redirectUrl are refused.
+	ClientWhitelist []string `yaml:"client_whitelist"`
+}
+
+type IdentityProvider struct {
+	// A unique, stable ID for the provider. Users' accounts are linked to
+	// the subject that the provider with this ID gives us, so it must not
+	// change once users have logged in.
+	ID string `yaml:"id"`
+
+	// The name of the provider, shown to users by clients.
+	Name string `yaml:"name"`
+
+	// An optional mxc:// URI of an icon for the provider.
+	Icon string `yaml:"icon"`
+
+	// The issuer URL of the provider. The provider metadata will be looked
+	// up at <issuer>/.well-known/openid-configuration.
+	Issuer string `yaml:"issuer"`
+
+	// The OAuth2 client credentials that this server uses with the provider.
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// The scopes to request. Defaults to "openid", "profile" and "email".
+	Scopes []string `yaml:"scopes"`
+
+	// If set, users that log in with this provider for the first time get a
+	// new account, rather than being refused.
+	AutoProvision bool `yaml:"auto_provision"`
+
+	// The claim to take the localpart of auto-provisioned accounts from.
+	// Defaults to "preferred_username".
+	LocalpartClaim string `yaml:"localpart_claim"`
+}
+
+func (s *SSO) Defaults() {
+	s.Enabled = false
+}
+
+func (s *SSO) Verify(configErrs *ConfigErrors) {
+	if !s.Enabled {
+		return
+	}
+	checkURL(configErrs, "client_api.login.sso.callback_url", s.CallbackURL)
+	if len(s.Providers) == 0 {
+		configErrs.Add("no providers configured for config key \"client_api.login.sso.providers\"")
+	}
+	seen := map[string]bool{}
+	for _, p := range s.Providers {
+		checkNotEmpty(configErrs, "client_api.login.sso.providers.id", p.ID)
+		checkNotEmpty(configErrs, "client_api.login.sso.providers.name", p.Name)
+		checkURL(configErrs, "client_api.login.sso.providers.issuer", p.Issuer)
+		checkNotEmpty(configErrs, "client_api.login.sso.providers.client_id", p.ClientID)
+		if seen[p.ID] {
+			configErrs.Add(fmt.Sprintf("duplicate provider ID %q for config key %q", p.ID, "client_api.login.sso.providers.id"))
+		}
+		seen[p.ID] = true
+	}
+	if s.DefaultProviderID != "" && !seen[s.DefaultProviderID] {
+		configErrs.Add(fmt.Sprintf("unknown provider %q for config key %q", s.DefaultProviderID, "client_api.login.sso.default_provider"))
+	}
+	if len(s.ClientWhitelist) == 0 {
+		configErrs.Add("no clients configured for config key \"client_api.login.sso.client_whitelist\"")
+	}
+	for _, client := range s.ClientWhitelist {
+		checkURL(configErrs, "client_api.login.sso.client_whitelist", client)
+	}
+}
+
+// IsClientAllowed returns true if users may be sent back to the given URL
+// after logging in. The scheme and host must match one of the clients in the
+// whitelist exactly, and the path must start with the client's path.
+func (s *SSO) IsClientAllowed(redirectURL *url.URL) bool {
+	for _, client := range s.ClientWhitelist {
+		u, err := url.Parse(client)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, redirectURL.Scheme) || !strings.EqualFold(u.Host, redirectURL.Host) {
+			continue
+		}
+		if strings.HasPrefix(redirectURL.Path, u.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,13 @@
 package config
 
-import "github.com/matrix-org/gomatrixserverlib"
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
 
 type FederationAPI struct {
 	Matrix *Global `yaml:"-"`
@@ -37,6 +44,9 @@ type FederationAPI struct {
 
 	// Should we prefer direct key fetches over perspective ones?
 	PreferDirectFetch bool `yaml:"prefer_direct_fetch"`
+
+	// Restricts which servers we federate with, regardless of room ACLs.
+	FederationDomains FederationDomains `yaml:"federation_domains"`
 }
 
 func (c *FederationAPI) Defaults(generate bool) {
@@ -61,10 +71,56 @@ func (c *FederationAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 		checkURL(configErrs, "federation_api.external_api.listen", string(c.ExternalAPI.Listen))
 	}
 	checkNotEmpty(configErrs, "federation_api.database.connection_string", string(c.Database.ConnectionString))
+	c.FederationDomains.Verify(configErrs)
 	// TODO: not applicable always, e.g. in demos
 	//checkNotZero(configErrs, "federation_api.federation_certificates", int64(len(c.FederationCertificatePaths)))
 }
 
+// FederationDomains is a server-wide allow list and deny list of the servers
+// that we federate with. Entries are hostnames and may contain * and ?
+// wildcards, as with room server ACLs, e.g. "*.example.com".
+type FederationDomains struct {
+	// If not empty, we only federate with servers that match an entry.
+	Allow []string `yaml:"allow"`
+	// We never federate with servers that match an entry.
+	Deny []string `yaml:"deny"`
+}
+
+func (c *FederationDomains) Verify(configErrs *ConfigErrors) {
+	checkPatterns := func(key string, patterns []string) {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", key, pattern))
+			}
+		}
+	}
+	checkPatterns("federation_api.federation_domains.allow", c.Allow)
+	checkPatterns("federation_api.federation_domains.deny", c.Deny)
+}
+
+// IsAllowed returns true if we may federate with the given server. Any port
+// is ignored. The deny list takes precedence over the allow list.
+func (c *FederationDomains) IsAllowed(serverName gomatrixserverlib.ServerName) bool {
+	host := strings.ToLower(string(serverName))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, pattern := range c.Deny {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return false
+		}
+	}
+	if len(c.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range c.Allow {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // The config for setting a proxy to use for server->server requests
 type Proxy struct {
 	// Is the proxy enabled?
@@ -57,6 +57,12 @@ type Global struct {
 
 	// DNS caching options for all outbound HTTP requests
 	DNSCache DNSCacheOptions `yaml:"dns_cache"`
+
+	// Presence options
+	Presence PresenceOptions `yaml:"presence"`
+
+	// Server notices options
+	ServerNotices ServerNotices `yaml:"server_notices"`
 }
 
 func (c *Global) Defaults(generate bool) {
@@ -72,6 +78,7 @@ func (c *Global) Defaults(generate bool) {
 	c.Metrics.Defaults(generate)
 	c.DNSCache.Defaults()
 	c.Sentry.Defaults()
+	c.ServerNotices.Defaults(generate)
 }
 
 func (c *Global) Verify(configErrs *ConfigErrors, isMonolith bool) {
@@ -82,6 +89,7 @@ func (c *Global) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	c.Metrics.Verify(configErrs, isMonolith)
 	c.Sentry.Verify(configErrs, isMonolith)
 	c.DNSCache.Verify(configErrs, isMonolith)
+	c.ServerNotices.Verify(configErrs, isMonolith)
 }
 
 type OldVerifyKeys struct {
@@ -123,6 +131,34 @@ func (c *Metrics) Defaults(generate bool) {
 func (c *Metrics) Verify(configErrs *ConfigErrors, isMonolith bool) {
 }
 
+// ServerNotices defines the system user that sends notices from the server
+// to users, such as maintenance announcements.
+type ServerNotices struct {
+	Enabled bool `yaml:"enabled"`
+	// The localpart of the user that sends the notices
+	LocalPart string `yaml:"local_part"`
+	// The display name of the user that sends the notices
+	DisplayName string `yaml:"display_name"`
+	// The avatar of the user that sends the notices, as an mxc:// URL
+	AvatarURL string `yaml:"avatar_url"`
+	// The name of the room that each user receives notices in
+	RoomName string `yaml:"room_name"`
+}
+
+func (c *ServerNotices) Defaults(generate bool) {
+	c.LocalPart = "_server"
+	c.DisplayName = "Server Alerts"
+	c.RoomName = "Server Alerts"
+}
+
+func (c *ServerNotices) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "global.server_notices.local_part", c.LocalPart)
+	checkNotEmpty(configErrs, "global.server_notices.room_name", c.RoomName)
+}
+
 // The configuration to use for Sentry error reporting
 type Sentry struct {
 	Enabled bool `yaml:"enabled"`
@@ -195,3 +231,11 @@ func (c *DNSCacheOptions) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	checkPositive(configErrs, "cache_size", int64(c.CacheSize))
 	checkPositive(configErrs, "cache_lifetime", int64(c.CacheLifetime))
 }
+
+// PresenceOptions defines possible configurations for presence events.
+type PresenceOptions struct {
+	// Whether inbound presence events are allowed
+	EnableInbound bool `yaml:"enable_inbound"`
+	// Whether outbound presence events are allowed
+	EnableOutbound bool `yaml:"enable_outbound"`
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"time"
 )
 
 type MediaAPI struct {
@@ -25,6 +27,10 @@ type MediaAPI struct {
 	// Note: if max_file_size_bytes is not set, it will default to 10485760 (10MB)
 	MaxFileSizeBytes *FileSizeBytes `yaml:"max_file_size_bytes,omitempty"`
 
+	// The maximum total size in bytes of the media that each local user may
+	// upload. Admins can override this for individual users. 0 is unlimited.
+	DefaultUserQuotaBytes FileSizeBytes `yaml:"default_user_quota_bytes"`
+
 	// Whether to dynamically generate thumbnails on-the-fly if the requested resolution is not already generated
 	DynamicThumbnails bool `yaml:"dynamic_thumbnails"`
 
@@ -33,6 +39,83 @@ type MediaAPI struct {
 
 	// A list of thumbnail sizes to be pre-generated for downloaded remote / uploaded content
 	ThumbnailSizes []ThumbnailSize `yaml:"thumbnail_sizes"`
+
+	// How many days to keep media from other servers for after it was last
+	// downloaded. 0 keeps it forever.
+	RemoteMediaLifetimeDays int `yaml:"remote_media_lifetime_days"`
+
+	// Configuration for generating previews of URLs
+	URLPreviews URLPreviews `yaml:"url_previews"`
+}
+
+type URLPreviews struct {
+	// Whether to generate previews of URLs at the request of clients
+	Enabled bool `yaml:"enabled"`
+	// IP ranges, in CIDR notation, that we will never connect to when
+	// previewing URLs. This stops clients from using us to probe the
+	// internal network.
+	IPRangeBlacklist []string `yaml:"ip_range_blacklist"`
+	// IP ranges, in CIDR notation, that we will connect to even if they
+	// appear in the blacklist
+	IPRangeWhitelist []string `yaml:"ip_range_whitelist"`
+	// The maximum number of bytes to download from a URL. default: 10MB
+	MaxSpiderSize FileSizeBytes `yaml:"max_spider_size"`
+	// How long previews are cached for. default: 24h
+	CacheLifetime time.Duration `yaml:"cache_lifetime"`
+}
+
+// DefaultIPRangeBlacklist contains the loopback, private, link-local and other
+// special-purpose IP ranges that URL previews must never connect to.
+var DefaultIPRangeBlacklist = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"169.254.0.0/16",
+	"192.88.99.0/24",
+	"198.18.0.0/15",
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"0.0.0.0/8",
+	"::1/128",
+	"fe80::/10",
+	"fc00::/7",
+	"2001:db8::/32",
+	"ff00::/8",
+	"fec0::/10",
+	"::/128",
+}
+
+func (c *URLPreviews) Defaults() {
+	c.Enabled = false
+	c.IPRangeBlacklist = append([]string{}, DefaultIPRangeBlacklist...)
+	c.MaxSpiderSize = DefaultMaxFileSizeBytes
+	c.CacheLifetime = time.Hour * 24
+}
+
+func (c *URLPreviews) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if len(c.IPRangeBlacklist) == 0 {
+		configErrs.Add("media_api.url_previews.ip_range_blacklist must not be empty when URL previews are enabled")
+	}
+	for i, cidr := range c.IPRangeBlacklist {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid CIDR for config key %q: %s", fmt.Sprintf("media_api.url_previews.ip_range_blacklist[%d]", i), cidr))
+		}
+	}
+	for i, cidr := range c.IPRangeWhitelist {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid CIDR for config key %q: %s", fmt.Sprintf("media_api.url_previews.ip_range_whitelist[%d]", i), cidr))
+		}
+	}
+	checkPositive(configErrs, "media_api.url_previews.max_spider_size", int64(c.MaxSpiderSize))
+	checkPositive(configErrs, "media_api.url_previews.cache_lifetime", int64(c.CacheLifetime))
 }
 
 // DefaultMaxFileSizeBytes defines the default file size allowed in transfers
@@ -50,6 +133,7 @@ func (c *MediaAPI) Defaults(generate bool) {
 
 	c.MaxFileSizeBytes = &DefaultMaxFileSizeBytes
 	c.MaxThumbnailGenerators = 10
+	c.URLPreviews.Defaults()
 }
 
 func (c *MediaAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
@@ -62,10 +146,14 @@ func (c *MediaAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 
 	checkNotEmpty(configErrs, "media_api.base_path", string(c.BasePath))
 	checkPositive(configErrs, "media_api.max_file_size_bytes", int64(*c.MaxFileSizeBytes))
+	checkPositive(configErrs, "media_api.default_user_quota_bytes", int64(c.DefaultUserQuotaBytes))
 	checkPositive(configErrs, "media_api.max_thumbnail_generators", int64(c.MaxThumbnailGenerators))
+	checkPositive(configErrs, "media_api.remote_media_lifetime_days", int64(c.RemoteMediaLifetimeDays))
 
 	for i, size := range c.ThumbnailSizes {
 		checkPositive(configErrs, fmt.Sprintf("media_api.thumbnail_sizes[%d].width", i), int64(size.Width))
 		checkPositive(configErrs, fmt.Sprintf("media_api.thumbnail_sizes[%d].height", i), int64(size.Height))
 	}
+
+	c.URLPreviews.Verify(configErrs)
 }
@@ -16,7 +16,10 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
 )
 
 func TestLoadConfigRelative(t *testing.T) {
@@ -245,6 +248,56 @@ func TestReadKey(t *testing.T) {
 	}
 }
 
+func TestFederationDomains(t *testing.T) {
+	domains := FederationDomains{
+		Allow: []string{"*.example.com", "partner.org"},
+		Deny:  []string{"bad.example.com"},
+	}
+	for serverName, want := range map[gomatrixserverlib.ServerName]bool{
+		"a.example.com":      true,
+		"A.Example.com:8448": true,
+		"partner.org":        true,
+		"partner.org:443":    true,
+		"bad.example.com":    false,
+		"example.com":        false,
+		"other.org":          false,
+	} {
+		if got := domains.IsAllowed(serverName); got != want {
+			t.Errorf("IsAllowed(%q): wanted %v, got %v", serverName, want, got)
+		}
+	}
+
+	// With no allow list, everything that isn't denied is allowed.
+	domains.Allow = nil
+	if !domains.IsAllowed("other.org") || domains.IsAllowed("bad.example.com") {
+		t.Errorf("deny list wasn't applied correctly without an allow list")
+	}
+}
+
+func TestSSOIsClientAllowed(t *testing.T) {
+	sso := SSO{
+		ClientWhitelist: []string{"https://app.example.com/", "http://localhost:8080/web"},
+	}
+	for redirectURL, want := range map[string]bool{
+		"https://app.example.com/":             true,
+		"https://APP.example.com/#/login":      true,
+		"http://localhost:8080/web/index.html": true,
+		"http://app.example.com/":              false,
+		"https://app.example.com.evil.org/":    false,
+		"https://app.example.com@evil.org/":    false,
+		"http://localhost:8081/web":            false,
+		"http://localhost:8080/other":          false,
+	} {
+		u, err := url.Parse(redirectURL)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) failed: %v", redirectURL, err)
+		}
+		if got := sso.IsClientAllowed(u); got != want {
+			t.Errorf("IsClientAllowed(%q): wanted %v, got %v", redirectURL, want, got)
+		}
+	}
+}
+
 const testKeyID = "ed25519:c8NsuQ"
 
 const testKey = `
@@ -13,12 +13,27 @@ type UserAPI struct {
 	// The length of time an OpenID token is condidered valid in milliseconds
 	OpenIDTokenLifetimeMS int64 `yaml:"openid_token_lifetime_ms"`
 
+	// How long an access token is valid for in milliseconds, when the client
+	// asked for a refresh token. Zero disables refresh tokens.
+	RefreshableAccessTokenLifetimeMS int64 `yaml:"refreshable_access_token_lifetime_ms"`
+	// How long an access token is valid for in milliseconds, when the client
+	// didn't ask for a refresh token. Zero means that these never expire.
+	NonRefreshableAccessTokenLifetimeMS int64 `yaml:"nonrefreshable_access_token_lifetime_ms"`
+
 	// The Account database stores the login details and account information
 	// for local users. It is accessed by the UserAPI.
 	AccountDatabase DatabaseOptions `yaml:"account_database"`
 	// The Device database stores session information for the devices of logged
 	// in local users. It is accessed by the UserAPI.
 	DeviceDatabase DatabaseOptions `yaml:"device_database"`
+
+	// How many days to keep notifications for after they have been read.
+	// 0 keeps them forever.
+	ReadNotificationLifetimeDays int `yaml:"read_notification_lifetime_days"`
+
+	// PushGatewayDisableTLSValidation disables the validation of X.509 TLS
+	// certs on push gateway endpoints. This is not recommended in production!
+	PushGatewayDisableTLSValidation bool `yaml:"push_gateway_disable_tls_validation"`
 }
 
 const DefaultOpenIDTokenLifetimeMS = 3600000 // 60 minutes
@@ -34,6 +49,7 @@ func (c *UserAPI) Defaults(generate bool) {
 	}
 	c.BCryptCost = bcrypt.DefaultCost
 	c.OpenIDTokenLifetimeMS = DefaultOpenIDTokenLifetimeMS
+	c.ReadNotificationLifetimeDays = 30
 }
 
 func (c *UserAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
@@ -42,4 +58,7 @@ func (c *UserAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	checkNotEmpty(configErrs, "user_api.account_database.connection_string", string(c.AccountDatabase.ConnectionString))
 	checkNotEmpty(configErrs, "user_api.device_database.connection_string", string(c.DeviceDatabase.ConnectionString))
 	checkPositive(configErrs, "user_api.openid_token_lifetime_ms", c.OpenIDTokenLifetimeMS)
+	checkPositive(configErrs, "user_api.refreshable_access_token_lifetime_ms", c.RefreshableAccessTokenLifetimeMS)
+	checkPositive(configErrs, "user_api.nonrefreshable_access_token_lifetime_ms", c.NonRefreshableAccessTokenLifetimeMS)
+	checkPositive(configErrs, "user_api.read_notification_lifetime_days", int64(c.ReadNotificationLifetimeDays))
 }
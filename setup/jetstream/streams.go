@@ -19,6 +19,8 @@ var (
 	OutputTypingEvent       = "OutputTypingEvent"
 	OutputClientData        = "OutputClientData"
 	OutputReceiptEvent      = "OutputReceiptEvent"
+	OutputNotificationData  = "OutputNotificationData"
+	OutputPresenceEvent     = "OutputPresenceEvent"
 )
 
 var streams = []*nats.StreamConfig{
@@ -58,4 +60,15 @@ var streams = []*nats.StreamConfig{
 		Retention: nats.InterestPolicy,
 		Storage:   nats.FileStorage,
 	},
+	{
+		Name:      OutputNotificationData,
+		Retention: nats.InterestPolicy,
+		Storage:   nats.FileStorage,
+	},
+	{
+		Name:      OutputPresenceEvent,
+		Retention: nats.InterestPolicy,
+		Storage:   nats.MemoryStorage,
+		MaxAge:    time.Minute * 5,
+	},
 }
@@ -137,6 +137,9 @@ func Enable(
 			if fedReq == nil {
 				return errResp
 			}
+			if !base.Cfg.FederationAPI.FederationDomains.IsAllowed(fedReq.Origin()) {
+				return util.MatrixErrorResponse(http.StatusForbidden, "M_FORBIDDEN", "Federation with this server is not allowed")
+			}
 			return federatedEventRelationship(req.Context(), fedReq, db, rsAPI, fsAPI)
 		},
 	)).Methods(http.MethodPost, http.MethodOptions)
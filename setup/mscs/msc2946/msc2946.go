@@ -82,6 +82,9 @@ func Enable(
 			if fedReq == nil {
 				return errResp
 			}
+			if !base.Cfg.FederationAPI.FederationDomains.IsAllowed(fedReq.Origin()) {
+				return util.MatrixErrorResponse(http.StatusForbidden, "M_FORBIDDEN", "Federation with this server is not allowed")
+			}
 			// Extract the room ID from the request. Sanity check request data.
 			params, err := httputil.URLDecodeMapValues(mux.Vars(req))
 			if err != nil {
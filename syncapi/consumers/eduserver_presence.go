@@ -0,0 +1,96 @@
+// Copyright 2020 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/dendrite/syncapi/notifier"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// OutputPresenceEventConsumer consumes presence updates that originated in
+// the EDU server or from users syncing.
+type OutputPresenceEventConsumer struct {
+	ctx       context.Context
+	jetstream nats.JetStreamContext
+	durable   string
+	topic     string
+	db        storage.Database
+	stream    types.StreamProvider
+	notifier  *notifier.Notifier
+}
+
+// NewOutputPresenceEventConsumer creates a new OutputPresenceEventConsumer.
+// Call Start() to begin consuming presence updates.
+func NewOutputPresenceEventConsumer(
+	process *process.ProcessContext,
+	cfg *config.SyncAPI,
+	js nats.JetStreamContext,
+	store storage.Database,
+	notifier *notifier.Notifier,
+	stream types.StreamProvider,
+) *OutputPresenceEventConsumer {
+	return &OutputPresenceEventConsumer{
+		ctx:       process.Context(),
+		jetstream: js,
+		topic:     cfg.Matrix.JetStream.TopicFor(jetstream.OutputPresenceEvent),
+		durable:   cfg.Matrix.JetStream.Durable("SyncAPIPresenceConsumer"),
+		db:        store,
+		notifier:  notifier,
+		stream:    stream,
+	}
+}
+
+// Start consuming presence updates
+func (s *OutputPresenceEventConsumer) Start() error {
+	return jetstream.JetStreamConsumer(
+		s.ctx, s.jetstream, s.topic, s.durable, s.onMessage,
+		nats.DeliverAll(), nats.ManualAck(),
+	)
+}
+
+func (s *OutputPresenceEventConsumer) onMessage(ctx context.Context, msg *nats.Msg) bool {
+	var output api.OutputPresenceEvent
+	if err := json.Unmarshal(msg.Data, &output); err != nil {
+		// If the message was invalid, log it and move on to the next message in the stream
+		log.WithError(err).Errorf("presence output log: message parse failure")
+		sentry.CaptureException(err)
+		return true
+	}
+
+	streamPos, err := s.db.UpsertPresence(
+		ctx, output.UserID, output.Presence, output.StatusMsg, output.LastActiveTS,
+	)
+	if err != nil {
+		log.WithError(err).WithField("user_id", output.UserID).Error("failed to store presence")
+		sentry.CaptureException(err)
+		return false
+	}
+
+	s.stream.Advance(streamPos)
+	s.notifier.OnNewPresence(types.StreamingToken{PresencePosition: streamPos}, output.UserID)
+
+	return true
+}
@@ -111,12 +111,18 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msg *nats.Msg)
 		s.onNewInviteEvent(s.ctx, *output.NewInviteEvent)
 	case api.OutputTypeRetireInviteEvent:
 		s.onRetireInviteEvent(s.ctx, *output.RetireInviteEvent)
+	case api.OutputTypeNewKnockEvent:
+		s.onNewKnockEvent(s.ctx, *output.NewKnockEvent)
+	case api.OutputTypeRetireKnockEvent:
+		s.onRetireKnockEvent(s.ctx, *output.RetireKnockEvent)
 	case api.OutputTypeNewPeek:
 		s.onNewPeek(s.ctx, *output.NewPeek)
 	case api.OutputTypeRetirePeek:
 		s.onRetirePeek(s.ctx, *output.RetirePeek)
 	case api.OutputTypeRedactedEvent:
 		err = s.onRedactEvent(s.ctx, *output.RedactedEvent)
+	case api.OutputTypePurgeRoom:
+		err = s.onPurgeRoom(s.ctx, *output.PurgeRoom)
 	default:
 		log.WithField("type", output.Type).Debug(
 			"roomserver output log: ignoring unknown output type",
@@ -145,6 +151,16 @@ func (s *OutputRoomEventConsumer) onRedactEvent(
 	})
 }
 
+func (s *OutputRoomEventConsumer) onPurgeRoom(
+	ctx context.Context, msg api.OutputPurgeRoom,
+) error {
+	if err := s.db.PurgeRoom(ctx, msg.RoomID); err != nil {
+		log.WithError(err).WithField("room_id", msg.RoomID).Error("PurgeRoom error'd")
+		return err
+	}
+	return nil
+}
+
 func (s *OutputRoomEventConsumer) onNewRoomEvent(
 	ctx context.Context, msg api.OutputNewRoomEvent,
 ) error {
@@ -322,6 +338,56 @@ func (s *OutputRoomEventConsumer) onRetireInviteEvent(
 	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, msg.TargetUserID)
 }
 
+func (s *OutputRoomEventConsumer) onNewKnockEvent(
+	ctx context.Context, msg api.OutputNewKnockEvent,
+) {
+	if msg.Event.StateKey() == nil {
+		log.WithFields(log.Fields{
+			"event": string(msg.Event.JSON()),
+		}).Panicf("roomserver output log: knock has no state key")
+		return
+	}
+	pduPos, err := s.db.AddKnockEvent(ctx, msg.Event)
+	if err != nil {
+		sentry.CaptureException(err)
+		// panic rather than continue with an inconsistent database
+		log.WithFields(log.Fields{
+			"event_id":   msg.Event.EventID(),
+			"event":      string(msg.Event.JSON()),
+			"pdupos":     pduPos,
+			log.ErrorKey: err,
+		}).Panicf("roomserver output log: write knock failure")
+		return
+	}
+
+	s.inviteStream.Advance(pduPos)
+	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, *msg.Event.StateKey())
+}
+
+func (s *OutputRoomEventConsumer) onRetireKnockEvent(
+	ctx context.Context, msg api.OutputRetireKnockEvent,
+) {
+	pduPos, err := s.db.RetireKnockEvent(ctx, msg.RoomID, msg.TargetUserID)
+	if err != nil {
+		sentry.CaptureException(err)
+		// panic rather than continue with an inconsistent database
+		log.WithFields(log.Fields{
+			"room_id":    msg.RoomID,
+			"user_id":    msg.TargetUserID,
+			log.ErrorKey: err,
+		}).Panicf("roomserver output log: remove knock failure")
+		return
+	}
+	if pduPos == 0 {
+		// The user didn't have a pending knock in the room.
+		return
+	}
+
+	// Notify any active sync requests that the knock has been retired.
+	s.inviteStream.Advance(pduPos)
+	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, msg.TargetUserID)
+}
+
 func (s *OutputRoomEventConsumer) onNewPeek(
 	ctx context.Context, msg api.OutputNewPeek,
 ) {
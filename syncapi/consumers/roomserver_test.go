@@ -0,0 +1,77 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+)
+
+func TestOnPurgeRoom(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	purged := test.NewRoom(t, alice)
+	purged.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": "purge me"})
+	kept := test.NewRoom(t, alice)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, err := storage.NewSyncServerDatasource(&config.DatabaseOptions{
+			ConnectionString:   test.PrepareDBConnectionString(t, dbType),
+			MaxOpenConnections: 1,
+			MaxIdleConnections: 1,
+		})
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		for _, ev := range append(purged.Events(), kept.Events()...) {
+			var addStateEvents []*gomatrixserverlib.HeaderedEvent
+			var addStateEventIDs []string
+			if ev.StateKey() != nil {
+				addStateEvents = append(addStateEvents, ev)
+				addStateEventIDs = append(addStateEventIDs, ev.EventID())
+			}
+			if _, err = db.WriteEvent(ctx, ev, addStateEvents, addStateEventIDs, nil, nil, false); err != nil {
+				t.Fatalf("WriteEvent failed: %s", err)
+			}
+		}
+
+		s := &OutputRoomEventConsumer{ctx: ctx, db: db}
+		data, err := json.Marshal(api.OutputEvent{
+			Type:      api.OutputTypePurgeRoom,
+			PurgeRoom: &api.OutputPurgeRoom{RoomID: purged.ID},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal output event: %s", err)
+		}
+		if !s.onMessage(ctx, &nats.Msg{Data: data}) {
+			t.Fatalf("purge wasn't acknowledged")
+		}
+
+		for room, wantCount := range map[*test.Room]int{purged: 0, kept: len(kept.Events())} {
+			eventIDs := make([]string, 0, len(room.Events()))
+			for _, ev := range room.Events() {
+				eventIDs = append(eventIDs, ev.EventID())
+			}
+			events, err := db.Events(ctx, eventIDs)
+			if err != nil {
+				t.Fatalf("Events failed: %s", err)
+			}
+			if len(events) != wantCount {
+				t.Errorf("got %d events in %s, want %d", len(events), room.ID, wantCount)
+			}
+			create, err := db.GetStateEvent(ctx, room.ID, gomatrixserverlib.MRoomCreate, "")
+			if err != nil {
+				t.Fatalf("GetStateEvent failed: %s", err)
+			}
+			if (create != nil) != (wantCount > 0) {
+				t.Errorf("got create event %v in the current state of %s", create, room.ID)
+			}
+		}
+	})
+}
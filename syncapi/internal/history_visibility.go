@@ -0,0 +1,229 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// ApplyHistoryVisibilityFilter returns the events that the user is allowed to
+// see, in the same order. The events can be from any number of rooms.
+// See https://spec.matrix.org/v1.2/client-server-api/#server-behaviour-5
+func ApplyHistoryVisibilityFilter(
+	ctx context.Context, db storage.Database, userID string,
+	events []*gomatrixserverlib.HeaderedEvent,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	rooms := make(map[string]*roomHistory)
+	result := make([]*gomatrixserverlib.HeaderedEvent, 0, len(events))
+	for _, ev := range events {
+		room, ok := rooms[ev.RoomID()]
+		if !ok {
+			memberships, err := db.MembershipChanges(ctx, ev.RoomID(), userID)
+			if err != nil {
+				return nil, fmt.Errorf("db.MembershipChanges: %w", err)
+			}
+			room = &roomHistory{
+				db:          db,
+				roomID:      ev.RoomID(),
+				userID:      userID,
+				memberships: memberships,
+			}
+			rooms[ev.RoomID()] = room
+		}
+		visible, err := room.isVisible(ctx, ev)
+		if err != nil {
+			return nil, err
+		}
+		if visible {
+			result = append(result, ev)
+		}
+	}
+	return result, nil
+}
+
+// roomHistory holds what we need to know about a room in order to work out
+// whether its events are visible to a user.
+type roomHistory struct {
+	db          storage.Database
+	roomID      string
+	userID      string
+	memberships []types.MembershipChange
+	// visibilities is only loaded when it is needed, as it isn't for
+	// the events that were sent while the user was joined to the room.
+	visibilities []types.StreamEvent
+	loaded       bool
+	// positions caches the stream positions of the events being filtered,
+	// which are only looked up to order events at the same depth.
+	positions map[string]types.StreamPosition
+}
+
+func (r *roomHistory) isVisible(ctx context.Context, ev *gomatrixserverlib.HeaderedEvent) (bool, error) {
+	// Users can always see their own membership events, otherwise they
+	// wouldn't find out that they left or were removed from the room.
+	if ev.Type() == gomatrixserverlib.MRoomMember && ev.StateKeyEquals(r.userID) {
+		return true, nil
+	}
+	membership, err := r.membershipAt(ctx, ev)
+	if err != nil {
+		return false, err
+	}
+	// 2. If the user's membership was join, allow.
+	// This is checked first as it doesn't need the history visibility.
+	if membership == gomatrixserverlib.Join {
+		return true, nil
+	}
+	if !r.loaded {
+		if r.visibilities, err = r.db.HistoryVisibilityEvents(ctx, r.roomID); err != nil {
+			return false, fmt.Errorf("db.HistoryVisibilityEvents: %w", err)
+		}
+		r.loaded = true
+	}
+	// A m.room.history_visibility event is visible if either the history
+	// visibility before or after it allows the user to see it.
+	visibility, err := r.visibilityAt(ctx, ev)
+	if err != nil {
+		return false, err
+	}
+	visibilities := []string{visibility}
+	if ev.Type() == gomatrixserverlib.MRoomHistoryVisibility && ev.StateKeyEquals("") {
+		visibilities = append(visibilities, historyVisibility(ev))
+	}
+	for _, visibility := range visibilities {
+		switch visibility {
+		case "world_readable":
+			// 1. If the history_visibility was set to world_readable, allow.
+			return true, nil
+		case "shared":
+			// 3. If history_visibility was set to shared, and the user joined the room at any point after the event was sent, allow.
+			joined, err := r.joinedSince(ctx, ev)
+			if err != nil {
+				return false, err
+			}
+			if joined {
+				return true, nil
+			}
+		case "invited":
+			// 4. If the user's membership was invite, and the history_visibility was set to invited, allow.
+			if membership == gomatrixserverlib.Invite {
+				return true, nil
+			}
+		}
+	}
+	// 5. Otherwise, deny.
+	return false, nil
+}
+
+// isBefore returns whether the given position comes before the event in the
+// room's topological ordering, which orders events by depth and then by
+// stream position. Events on different branches of the room DAG can have the
+// same depth, so the stream position of the event is looked up in that case.
+func (r *roomHistory) isBefore(ctx context.Context, pos types.TopologyToken, ev *gomatrixserverlib.HeaderedEvent) (bool, error) {
+	if depth := types.StreamPosition(ev.Depth()); pos.Depth != depth {
+		return pos.Depth < depth, nil
+	}
+	streamPos, ok := r.positions[ev.EventID()]
+	if !ok {
+		evPos, err := r.db.EventPositionInTopology(ctx, ev.EventID())
+		if err != nil {
+			return false, fmt.Errorf("db.EventPositionInTopology: %w", err)
+		}
+		if r.positions == nil {
+			r.positions = make(map[string]types.StreamPosition)
+		}
+		streamPos = evPos.PDUPosition
+		r.positions[ev.EventID()] = streamPos
+	}
+	return pos.PDUPosition < streamPos, nil
+}
+
+// membershipAt returns the membership of the user before the event.
+func (r *roomHistory) membershipAt(ctx context.Context, ev *gomatrixserverlib.HeaderedEvent) (string, error) {
+	membership := gomatrixserverlib.Leave
+	// The membership changes are in topological order.
+	for _, change := range r.memberships {
+		before, err := r.isBefore(ctx, change.Position(), ev)
+		if err != nil {
+			return "", err
+		}
+		if !before {
+			break
+		}
+		membership = change.Membership
+	}
+	return membership, nil
+}
+
+// joinedSince returns whether the user joined the room at or after the event,
+// which includes them being joined to the room now.
+func (r *roomHistory) joinedSince(ctx context.Context, ev *gomatrixserverlib.HeaderedEvent) (bool, error) {
+	for _, change := range r.memberships {
+		if change.Membership != gomatrixserverlib.Join {
+			continue
+		}
+		before, err := r.isBefore(ctx, change.Position(), ev)
+		if err != nil {
+			return false, err
+		}
+		if !before {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// visibilityAt returns the history visibility of the room before the event.
+func (r *roomHistory) visibilityAt(ctx context.Context, ev *gomatrixserverlib.HeaderedEvent) (string, error) {
+	visibility := "shared"
+	var latest *types.TopologyToken
+	for _, visEv := range r.visibilities {
+		if !visEv.StateKeyEquals("") {
+			continue
+		}
+		pos := types.TopologyToken{
+			Depth:       types.StreamPosition(visEv.Depth()),
+			PDUPosition: visEv.StreamPosition,
+		}
+		before, err := r.isBefore(ctx, pos, ev)
+		if err != nil {
+			return "", err
+		}
+		if !before || (latest != nil && pos.IsBefore(*latest)) {
+			continue
+		}
+		visibility, latest = historyVisibility(visEv.HeaderedEvent), &pos
+	}
+	return visibility, nil
+}
+
+// historyVisibility returns the history visibility set by the event. If no
+// history visibility is set, or if the value is not understood, the visibility
+// is assumed to be shared.
+func historyVisibility(ev *gomatrixserverlib.HeaderedEvent) string {
+	visibility, err := ev.HistoryVisibility()
+	if err != nil {
+		return "shared"
+	}
+	switch visibility {
+	case "world_readable", "shared", "invited", "joined":
+		return visibility
+	default:
+		return "shared"
+	}
+}
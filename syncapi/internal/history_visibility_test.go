@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const (
+	hisVisRoomID = "!hisvis:localhost"
+	hisVisBob    = "@bob:localhost"
+	hisVisCarol  = "@carol:localhost"
+)
+
+type mockHistoryVisibilityDB struct {
+	storage.Database
+	memberships  map[string][]types.MembershipChange
+	visibilities []types.StreamEvent
+	positions    map[string]types.TopologyToken
+}
+
+func (d *mockHistoryVisibilityDB) MembershipChanges(ctx context.Context, roomID, userID string) ([]types.MembershipChange, error) {
+	return d.memberships[userID], nil
+}
+
+func (d *mockHistoryVisibilityDB) HistoryVisibilityEvents(ctx context.Context, roomID string) ([]types.StreamEvent, error) {
+	return d.visibilities, nil
+}
+
+func (d *mockHistoryVisibilityDB) EventPositionInTopology(ctx context.Context, eventID string) (types.TopologyToken, error) {
+	pos, ok := d.positions[eventID]
+	if !ok {
+		return types.TopologyToken{}, fmt.Errorf("unknown event %s", eventID)
+	}
+	return pos, nil
+}
+
+// add inserts an event into the mock database at the given position.
+func (d *mockHistoryVisibilityDB) add(ev *gomatrixserverlib.HeaderedEvent, streamPos types.StreamPosition) {
+	if d.positions == nil {
+		d.positions = make(map[string]types.TopologyToken)
+	}
+	d.positions[ev.EventID()] = types.TopologyToken{Depth: types.StreamPosition(ev.Depth()), PDUPosition: streamPos}
+	if ev.Type() == gomatrixserverlib.MRoomHistoryVisibility {
+		d.visibilities = append(d.visibilities, types.StreamEvent{HeaderedEvent: ev, StreamPosition: streamPos})
+	}
+}
+
+func mustCreateHistoryEvent(t *testing.T, depth int64, evType string, stateKey *string, content interface{}) *gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	b := gomatrixserverlib.EventBuilder{
+		Sender:   hisVisBob,
+		RoomID:   hisVisRoomID,
+		Type:     evType,
+		StateKey: stateKey,
+		Depth:    depth,
+	}
+	if err := b.SetContent(content); err != nil {
+		t.Fatalf("failed to set content: %s", err)
+	}
+	_, key, _ := ed25519.GenerateKey(nil)
+	ev, err := b.Build(time.Now(), "localhost", "ed25519:test", key, gomatrixserverlib.RoomVersionV4)
+	if err != nil {
+		t.Fatalf("failed to build event: %s", err)
+	}
+	return ev.Headered(gomatrixserverlib.RoomVersionV4)
+}
+
+func TestApplyHistoryVisibilityFilter(t *testing.T) {
+	emptyStateKey := ""
+	syncingUserKey := syncingUser
+	db := &mockHistoryVisibilityDB{
+		memberships: map[string][]types.MembershipChange{
+			syncingUser: {
+				{Membership: gomatrixserverlib.Invite, StreamPosition: 4, TopologicalPosition: 4},
+				{Membership: gomatrixserverlib.Join, StreamPosition: 10, TopologicalPosition: 10},
+				{Membership: gomatrixserverlib.Leave, StreamPosition: 12, TopologicalPosition: 12},
+			},
+		},
+	}
+	var events []*gomatrixserverlib.HeaderedEvent
+	names := map[string]string{}
+	add := func(name, evType string, stateKey *string, content interface{}) {
+		ev := mustCreateHistoryEvent(t, int64(len(events)+1), evType, stateKey, content)
+		db.add(ev, types.StreamPosition(len(events)+1))
+		names[ev.EventID()] = name
+		events = append(events, ev)
+	}
+	message := map[string]string{"body": "hello"}
+	hisVis := func(visibility string) interface{} {
+		return map[string]string{"history_visibility": visibility}
+	}
+	membership := func(membership string) interface{} {
+		return map[string]string{"membership": membership}
+	}
+	add("create", gomatrixserverlib.MRoomCreate, &emptyStateKey, map[string]string{"creator": hisVisBob})
+	add("shared", gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, hisVis("shared"))
+	add("msg before invite", "m.room.message", nil, message)
+	add("invite", gomatrixserverlib.MRoomMember, &syncingUserKey, membership(gomatrixserverlib.Invite))
+	add("msg while invited", "m.room.message", nil, message)
+	add("invited", gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, hisVis("invited"))
+	add("msg while invited with invited", "m.room.message", nil, message)
+	add("joined", gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, hisVis("joined"))
+	add("msg while invited with joined", "m.room.message", nil, message)
+	add("join", gomatrixserverlib.MRoomMember, &syncingUserKey, membership(gomatrixserverlib.Join))
+	add("msg while joined", "m.room.message", nil, message)
+	add("leave", gomatrixserverlib.MRoomMember, &syncingUserKey, membership(gomatrixserverlib.Leave))
+	add("msg after leave", "m.room.message", nil, message)
+	add("world_readable", gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, hisVis("world_readable"))
+	add("msg while world_readable", "m.room.message", nil, message)
+
+	testCases := []struct {
+		userID string
+		want   []string
+	}{
+		{
+			userID: syncingUser,
+			want: []string{
+				"create", "shared", "msg before invite", "invite", "msg while invited",
+				"invited", "msg while invited with invited", "joined", "join", "msg while joined",
+				"leave", "world_readable", "msg while world_readable",
+			},
+		},
+		{
+			// never in the room
+			userID: hisVisCarol,
+			want:   []string{"world_readable", "msg while world_readable"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.userID, func(t *testing.T) {
+			visible, err := ApplyHistoryVisibilityFilter(context.Background(), db, tc.userID, events)
+			if err != nil {
+				t.Fatalf("ApplyHistoryVisibilityFilter returned an error: %s", err)
+			}
+			got := make([]string, 0, len(visible))
+			for _, ev := range visible {
+				got = append(got, names[ev.EventID()])
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got visible events %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Events on different branches of the room DAG can have the same depth, in
+// which case they are ordered by when we received them.
+func TestApplyHistoryVisibilityFilterSameDepth(t *testing.T) {
+	emptyStateKey := ""
+	syncingUserKey := syncingUser
+	db := &mockHistoryVisibilityDB{}
+	create := mustCreateHistoryEvent(t, 1, gomatrixserverlib.MRoomCreate, &emptyStateKey, map[string]string{"creator": hisVisBob})
+	joined := mustCreateHistoryEvent(t, 2, gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, map[string]string{"history_visibility": "joined"})
+	// These are all at depth 3, on different branches of the room.
+	msgBeforeJoin := mustCreateHistoryEvent(t, 3, "m.room.message", nil, map[string]string{"body": "before join"})
+	join := mustCreateHistoryEvent(t, 3, gomatrixserverlib.MRoomMember, &syncingUserKey, map[string]string{"membership": gomatrixserverlib.Join})
+	msgAfterJoin := mustCreateHistoryEvent(t, 3, "m.room.message", nil, map[string]string{"body": "after join"})
+	worldReadable := mustCreateHistoryEvent(t, 3, gomatrixserverlib.MRoomHistoryVisibility, &emptyStateKey, map[string]string{"history_visibility": "world_readable"})
+	msgWhileWorldReadable := mustCreateHistoryEvent(t, 3, "m.room.message", nil, map[string]string{"body": "while world_readable"})
+	events := []*gomatrixserverlib.HeaderedEvent{create, joined, msgBeforeJoin, join, msgAfterJoin, worldReadable, msgWhileWorldReadable}
+	for i, ev := range events {
+		db.add(ev, types.StreamPosition(i+1))
+	}
+	names := map[string]string{
+		create.EventID():                "create",
+		joined.EventID():                "joined",
+		msgBeforeJoin.EventID():         "msg before join",
+		join.EventID():                  "join",
+		msgAfterJoin.EventID():          "msg after join",
+		worldReadable.EventID():         "world_readable",
+		msgWhileWorldReadable.EventID(): "msg while world_readable",
+	}
+	db.memberships = map[string][]types.MembershipChange{
+		syncingUser: {{Membership: gomatrixserverlib.Join, StreamPosition: 4, TopologicalPosition: 3}},
+	}
+
+	testCases := []struct {
+		userID string
+		want   []string
+	}{
+		{
+			userID: syncingUser,
+			want:   []string{"create", "joined", "join", "msg after join", "world_readable", "msg while world_readable"},
+		},
+		{
+			// never in the room
+			userID: hisVisCarol,
+			want:   []string{"world_readable", "msg while world_readable"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.userID, func(t *testing.T) {
+			visible, err := ApplyHistoryVisibilityFilter(context.Background(), db, tc.userID, events)
+			if err != nil {
+				t.Fatalf("ApplyHistoryVisibilityFilter returned an error: %s", err)
+			}
+			got := make([]string, 0, len(visible))
+			for _, ev := range visible {
+				got = append(got, names[ev.EventID()])
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got visible events %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
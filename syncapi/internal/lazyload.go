@@ -0,0 +1,74 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/caching"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// LazyLoadMembers returns the current membership events that a client needs
+// in order to show the given timeline events of a room when lazy-loading room
+// members, i.e. those of the senders of the events and of any extra users.
+// Membership events that are in the timeline already, or that were already
+// sent to the device, are left out unless includeRedundant is set. The
+// membership events that the device will have are remembered in the cache.
+// See https://spec.matrix.org/v1.2/client-server-api/#lazy-loading-room-members
+func LazyLoadMembers(
+	ctx context.Context, db storage.Database, cache *caching.LazyLoadCache,
+	device *userapi.Device, roomID string, timeline []*gomatrixserverlib.HeaderedEvent,
+	extraUserIDs []string, includeRedundant bool,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	inTimeline := make(map[string]bool)
+	for _, ev := range timeline {
+		if ev.Type() == gomatrixserverlib.MRoomMember && ev.StateKey() != nil {
+			inTimeline[*ev.StateKey()] = true
+			cache.StoreLazyLoadedUser(device, roomID, *ev.StateKey(), ev.EventID())
+		}
+	}
+	userIDs := make([]string, 0, len(extraUserIDs)+len(timeline))
+	userIDs = append(userIDs, extraUserIDs...)
+	for _, ev := range timeline {
+		userIDs = append(userIDs, ev.Sender())
+	}
+	seen := make(map[string]bool, len(userIDs))
+	var members []*gomatrixserverlib.HeaderedEvent
+	for _, userID := range userIDs {
+		if seen[userID] || inTimeline[userID] {
+			continue
+		}
+		seen[userID] = true
+		memberEvent, err := db.GetStateEvent(ctx, roomID, gomatrixserverlib.MRoomMember, userID)
+		if err != nil {
+			return nil, fmt.Errorf("db.GetStateEvent: %w", err)
+		}
+		if memberEvent == nil {
+			continue
+		}
+		if !includeRedundant {
+			if eventID, ok := cache.IsLazyLoadedUserCached(device, roomID, userID); ok && eventID == memberEvent.EventID() {
+				continue
+			}
+		}
+		cache.StoreLazyLoadedUser(device, roomID, userID, memberEvent.EventID())
+		members = append(members, memberEvent)
+	}
+	return members, nil
+}
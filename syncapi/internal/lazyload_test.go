@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/caching"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const (
+	lazyLoadRoomID = "!lazyload:localhost"
+	lazyLoadAlice  = "@alice:localhost"
+	lazyLoadBob    = "@bob:localhost"
+	lazyLoadCarol  = "@carol:localhost"
+)
+
+type mockLazyLoadDB struct {
+	storage.Database
+	members map[string]*gomatrixserverlib.HeaderedEvent
+}
+
+func (d *mockLazyLoadDB) GetStateEvent(ctx context.Context, roomID, evType, stateKey string) (*gomatrixserverlib.HeaderedEvent, error) {
+	return d.members[stateKey], nil
+}
+
+func mustCreateLazyLoadEvent(t *testing.T, sender, evType string, stateKey *string, content interface{}) *gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	b := gomatrixserverlib.EventBuilder{
+		Sender:   sender,
+		RoomID:   lazyLoadRoomID,
+		Type:     evType,
+		StateKey: stateKey,
+	}
+	if err := b.SetContent(content); err != nil {
+		t.Fatalf("failed to set content: %s", err)
+	}
+	_, key, _ := ed25519.GenerateKey(nil)
+	ev, err := b.Build(time.Now(), "localhost", "ed25519:test", key, gomatrixserverlib.RoomVersionV4)
+	if err != nil {
+		t.Fatalf("failed to build event: %s", err)
+	}
+	return ev.Headered(gomatrixserverlib.RoomVersionV4)
+}
+
+func TestLazyLoadMembers(t *testing.T) {
+	join := map[string]string{"membership": gomatrixserverlib.Join}
+	message := map[string]string{"body": "hello"}
+	db := &mockLazyLoadDB{members: map[string]*gomatrixserverlib.HeaderedEvent{}}
+	for _, userID := range []string{lazyLoadAlice, lazyLoadBob, lazyLoadCarol} {
+		stateKey := userID
+		db.members[userID] = mustCreateLazyLoadEvent(t, userID, gomatrixserverlib.MRoomMember, &stateKey, join)
+	}
+	cache, err := caching.NewLazyLoadCache(false)
+	if err != nil {
+		t.Fatalf("failed to create cache: %s", err)
+	}
+	device := &userapi.Device{UserID: lazyLoadAlice, ID: "ALICEDEVICE"}
+	otherDevice := &userapi.Device{UserID: lazyLoadAlice, ID: "OTHERDEVICE"}
+	carolKey := lazyLoadCarol
+	carolRejoin := mustCreateLazyLoadEvent(t, lazyLoadCarol, gomatrixserverlib.MRoomMember, &carolKey, map[string]string{
+		"membership":  gomatrixserverlib.Join,
+		"displayname": "Carol",
+	})
+	timeline := []*gomatrixserverlib.HeaderedEvent{
+		mustCreateLazyLoadEvent(t, lazyLoadBob, "m.room.message", nil, message),
+		mustCreateLazyLoadEvent(t, lazyLoadBob, "m.room.message", nil, message),
+		carolRejoin,
+		mustCreateLazyLoadEvent(t, lazyLoadCarol, "m.room.message", nil, message),
+	}
+
+	testCases := []struct {
+		name             string
+		device           *userapi.Device
+		extraUserIDs     []string
+		includeRedundant bool
+		want             []string
+	}{
+		{
+			// carol's membership event is in the timeline already
+			name:         "first load",
+			device:       device,
+			extraUserIDs: []string{lazyLoadAlice},
+			want:         []string{lazyLoadAlice, lazyLoadBob},
+		},
+		{
+			name:         "already sent",
+			device:       device,
+			extraUserIDs: []string{lazyLoadAlice},
+			want:         []string{},
+		},
+		{
+			name:             "redundant members",
+			device:           device,
+			includeRedundant: true,
+			want:             []string{lazyLoadBob},
+		},
+		{
+			name:   "other device",
+			device: otherDevice,
+			want:   []string{lazyLoadBob},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			members, err := LazyLoadMembers(
+				context.Background(), db, cache, tc.device, lazyLoadRoomID, timeline,
+				tc.extraUserIDs, tc.includeRedundant,
+			)
+			if err != nil {
+				t.Fatalf("LazyLoadMembers returned an error: %s", err)
+			}
+			got := make([]string, 0, len(members))
+			for _, ev := range members {
+				got = append(got, *ev.StateKey())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got members %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// The device was sent carol's membership event in the timeline, so when
+	// her current membership event is a different one it is sent again.
+	members, err := LazyLoadMembers(
+		context.Background(), db, cache, device, lazyLoadRoomID, timeline[3:], nil, false,
+	)
+	if err != nil {
+		t.Fatalf("LazyLoadMembers returned an error: %s", err)
+	}
+	if len(members) != 1 || members[0].EventID() != db.members[lazyLoadCarol].EventID() {
+		t.Errorf("expected carol's current membership event to be sent")
+	}
+}
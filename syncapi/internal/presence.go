@@ -0,0 +1,51 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// ApplyLastSeen brings the last active time of a local user's presence
+// forward to when one of their devices was last seen, as recorded by
+// PerformLastSeenUpdate. Whether the user is idle is then worked out from it
+// when the presence is shown to clients. The presence of remote users is
+// left alone, since their servers tell us when they were last active.
+func ApplyLastSeen(
+	ctx context.Context, userAPI userapi.UserInternalAPI,
+	serverName gomatrixserverlib.ServerName, presence *types.PresenceInternal,
+) error {
+	_, domain, err := gomatrixserverlib.SplitID('@', presence.UserID)
+	if err != nil {
+		return err
+	}
+	if domain != serverName {
+		return nil
+	}
+	var res userapi.QueryDevicesResponse
+	if err = userAPI.QueryDevices(ctx, &userapi.QueryDevicesRequest{UserID: presence.UserID}, &res); err != nil {
+		return err
+	}
+	for _, dev := range res.Devices {
+		if lastSeen := gomatrixserverlib.Timestamp(dev.LastSeenTS); lastSeen > presence.LastActiveTS {
+			presence.LastActiveTS = lastSeen
+		}
+	}
+	return nil
+}
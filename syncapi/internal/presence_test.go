@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+type mockLastSeenUserAPI struct {
+	userapi.UserInternalAPI
+	lastSeen map[string][]time.Time
+}
+
+func (u *mockLastSeenUserAPI) QueryDevices(ctx context.Context, req *userapi.QueryDevicesRequest, res *userapi.QueryDevicesResponse) error {
+	for _, ts := range u.lastSeen[req.UserID] {
+		res.Devices = append(res.Devices, userapi.Device{UserID: req.UserID, LastSeenTS: int64(gomatrixserverlib.AsTimestamp(ts))})
+	}
+	return nil
+}
+
+func TestApplyLastSeen(t *testing.T) {
+	now := time.Now()
+	// The presence was last sent long ago, so without the devices' last
+	// seen times online users would be shown as idle.
+	sentAt := now.Add(-time.Hour)
+	userAPI := &mockLastSeenUserAPI{lastSeen: map[string][]time.Time{
+		"@active:localhost": {now.Add(-time.Hour), now.Add(-10 * time.Second)},
+		"@idle:localhost":   {now.Add(-10 * time.Minute)},
+		"@remote:other":     {now},
+	}}
+	tests := []struct {
+		userID     string
+		want       eduAPI.Presence
+		wantActive bool
+	}{
+		{"@active:localhost", eduAPI.PresenceOnline, true},
+		{"@idle:localhost", eduAPI.PresenceUnavailable, false},
+		{"@nodevices:localhost", eduAPI.PresenceUnavailable, false},
+		// Remote servers tell us when their users were last active.
+		{"@remote:other", eduAPI.PresenceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			p := &types.PresenceInternal{
+				UserID:       tt.userID,
+				Presence:     eduAPI.PresenceOnline,
+				LastActiveTS: gomatrixserverlib.AsTimestamp(sentAt),
+			}
+			if err := ApplyLastSeen(context.Background(), userAPI, "localhost", p); err != nil {
+				t.Fatalf("ApplyLastSeen failed: %v", err)
+			}
+			got := p.ClientPresence(now)
+			if got.Presence != tt.want || got.CurrentlyActive != tt.wantActive {
+				t.Errorf("got presence %q currently_active %v, want %q currently_active %v", got.Presence, got.CurrentlyActive, tt.want, tt.wantActive)
+			}
+		})
+	}
+}
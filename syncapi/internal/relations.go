@@ -0,0 +1,87 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/tidwall/sjson"
+)
+
+// ClientEventsWithRelations converts the events into client events, bundling
+// the aggregated relations to each of them into their unsigned m.relations.
+func ClientEventsWithRelations(
+	ctx context.Context, db storage.Database, userID string,
+	events []*gomatrixserverlib.HeaderedEvent, format gomatrixserverlib.ClientEventFormat,
+) ([]gomatrixserverlib.ClientEvent, error) {
+	clientEvents := gomatrixserverlib.HeaderedToClientEvents(events, format)
+	if len(events) == 0 {
+		return clientEvents, nil
+	}
+	aggregations, err := db.RelationAggregations(ctx, userID, events)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clientEvents {
+		agg, ok := aggregations[clientEvents[i].EventID]
+		if !ok {
+			continue
+		}
+		relations := map[string]interface{}{}
+		if len(agg.Annotations) > 0 {
+			relations[types.RelationAnnotation] = map[string]interface{}{
+				"chunk": agg.Annotations,
+			}
+		}
+		if len(agg.References) > 0 {
+			chunk := make([]map[string]string, len(agg.References))
+			for j, eventID := range agg.References {
+				chunk[j] = map[string]string{"event_id": eventID}
+			}
+			relations[types.RelationReference] = map[string]interface{}{
+				"chunk": chunk,
+			}
+		}
+		if agg.Replace != nil {
+			relations[types.RelationReplace] = map[string]interface{}{
+				"event_id":         agg.Replace.EventID(),
+				"origin_server_ts": agg.Replace.OriginServerTS(),
+				"sender":           agg.Replace.Sender(),
+			}
+		}
+		if agg.ThreadCount > 0 {
+			thread := map[string]interface{}{
+				"count":                     agg.ThreadCount,
+				"current_user_participated": agg.ThreadCurrentUserParticipated,
+			}
+			if agg.ThreadLatest != nil {
+				thread["latest_event"] = gomatrixserverlib.HeaderedToClientEvent(agg.ThreadLatest, format)
+			}
+			relations[types.RelationThread] = thread
+		}
+		if len(relations) == 0 {
+			continue
+		}
+		unsigned, err := sjson.SetBytes(clientEvents[i].Unsigned, `m\.relations`, relations)
+		if err != nil {
+			return nil, err
+		}
+		clientEvents[i].Unsigned = unsigned
+	}
+	return clientEvents, nil
+}
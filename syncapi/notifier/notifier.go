@@ -134,6 +134,28 @@ func (n *Notifier) OnNewAccountData(
 	n.wakeupUsers([]string{userID}, nil, posUpdate)
 }
 
+func (n *Notifier) OnNewNotificationData(
+	userID string, posUpdate types.StreamingToken,
+) {
+	n.streamLock.Lock()
+	defer n.streamLock.Unlock()
+
+	n.currPos.ApplyUpdates(posUpdate)
+	n.wakeupUsers([]string{userID}, nil, posUpdate)
+}
+
+// OnNewPresence wakes up the users who share a room with the user whose
+// presence changed, as well as the user themselves.
+func (n *Notifier) OnNewPresence(
+	posUpdate types.StreamingToken, userID string,
+) {
+	n.streamLock.Lock()
+	defer n.streamLock.Unlock()
+
+	n.currPos.ApplyUpdates(posUpdate)
+	n.wakeupUsers(n.sharedUsers(userID), nil, n.currPos)
+}
+
 func (n *Notifier) OnNewPeek(
 	roomID, userID, deviceID string,
 	posUpdate types.StreamingToken,
@@ -380,6 +402,20 @@ func (n *Notifier) removeJoinedUser(roomID, userID string) {
 	n.roomIDToJoinedUsers[roomID].remove(userID)
 }
 
+// Not thread-safe: must be called on the OnNewEvent goroutine only
+func (n *Notifier) sharedUsers(userID string) (userIDs []string) {
+	shared := userIDSet{userID: true}
+	for _, users := range n.roomIDToJoinedUsers {
+		if !users[userID] {
+			continue
+		}
+		for other := range users {
+			shared.add(other)
+		}
+	}
+	return shared.values()
+}
+
 // Not thread-safe: must be called on the OnNewEvent goroutine only
 func (n *Notifier) joinedUsers(roomID string) (userIDs []string) {
 	if _, ok := n.roomIDToJoinedUsers[roomID]; !ok {
@@ -0,0 +1,63 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producers
+
+import (
+	"encoding/json"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// PresenceProducer produces presence updates for users who are syncing,
+// for the sync API and the federation sender to consume
+type PresenceProducer struct {
+	Topic     string
+	JetStream nats.JetStreamContext
+}
+
+// SendPresence sends the presence of a user
+func (p *PresenceProducer) SendPresence(
+	userID string, presence eduAPI.Presence, statusMsg *string,
+	lastActiveTS gomatrixserverlib.Timestamp,
+) error {
+	m := &nats.Msg{
+		Subject: p.Topic,
+		Header:  nats.Header{},
+	}
+	m.Header.Set(jetstream.UserID, userID)
+
+	var err error
+	m.Data, err = json.Marshal(eduAPI.OutputPresenceEvent{
+		UserID:       userID,
+		Presence:     presence,
+		StatusMsg:    statusMsg,
+		LastActiveTS: lastActiveTS,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"user_id":  userID,
+		"presence": presence,
+	}).Tracef("Producing to topic '%s'", p.Topic)
+
+	_, err = p.JetStream.PublishMsg(m)
+	return err
+}
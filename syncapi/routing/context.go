@@ -0,0 +1,216 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+const (
+	defaultContextLimit = 10
+	maxContextLimit     = 100
+)
+
+type contextResponse struct {
+	Start        string                          `json:"start"`
+	End          string                          `json:"end"`
+	Event        gomatrixserverlib.ClientEvent   `json:"event"`
+	EventsBefore []gomatrixserverlib.ClientEvent `json:"events_before"`
+	EventsAfter  []gomatrixserverlib.ClientEvent `json:"events_after"`
+	State        []gomatrixserverlib.ClientEvent `json:"state"`
+}
+
+// Context implements GET /_matrix/client/r0/rooms/{roomID}/context/{eventID}
+func Context(
+	req *http.Request, device *userapi.Device, syncDB storage.Database,
+	rsAPI roomserverAPI.RoomserverInternalAPI,
+	roomID, eventID string,
+) util.JSONResponse {
+	ctx := req.Context()
+	limit := defaultContextLimit
+	if s := req.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("limit must be a non-negative integer"),
+			}
+		}
+	}
+	if limit > maxContextLimit {
+		limit = maxContextLimit
+	}
+	filter := gomatrixserverlib.DefaultRoomEventFilter()
+	if s := req.URL.Query().Get("filter"); s != "" {
+		if err := json.Unmarshal([]byte(s), &filter); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("The filter is not a valid room event filter: " + err.Error()),
+			}
+		}
+	}
+
+	var membershipRes roomserverAPI.QueryMembershipForUserResponse
+	err := rsAPI.QueryMembershipForUser(ctx, &roomserverAPI.QueryMembershipForUserRequest{
+		RoomID: roomID,
+		UserID: device.UserID,
+	}, &membershipRes)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryMembershipForUser failed")
+		return jsonerror.InternalServerError()
+	}
+	if !membershipRes.HasBeenInRoom || membershipRes.IsRoomForgotten {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("You aren't a member of the room"),
+		}
+	}
+
+	events, err := syncDB.Events(ctx, []string{eventID})
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("syncDB.Events failed")
+		return jsonerror.InternalServerError()
+	}
+	if len(events) == 0 || events[0].RoomID() != roomID {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event not found"),
+		}
+	}
+	ev := events[0]
+	if !eventVisibleToUser(ctx, syncDB, ev, device.UserID) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("You don't have permission to see this event"),
+		}
+	}
+
+	// The limit is shared between the events before and after the event.
+	beforeLimit := limit / 2
+	before, after, start, end, err := roomEventContext(
+		ctx, syncDB, device, ev, &filter, beforeLimit, limit-beforeLimit,
+	)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("roomEventContext failed")
+		return jsonerror.InternalServerError()
+	}
+
+	// The state is the state at the last event that is returned.
+	lastEventID := ev.EventID()
+	if len(after) > 0 {
+		lastEventID = after[len(after)-1].EventID()
+	}
+	var stateRes roomserverAPI.QueryStateAfterEventsResponse
+	err = rsAPI.QueryStateAfterEvents(ctx, &roomserverAPI.QueryStateAfterEventsRequest{
+		RoomID:       roomID,
+		PrevEventIDs: []string{lastEventID},
+	}, &stateRes)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryStateAfterEvents failed")
+		return jsonerror.InternalServerError()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: contextResponse{
+			Start:        start.String(),
+			End:          end.String(),
+			Event:        gomatrixserverlib.HeaderedToClientEvent(ev, gomatrixserverlib.FormatAll),
+			EventsBefore: gomatrixserverlib.HeaderedToClientEvents(before, gomatrixserverlib.FormatAll),
+			EventsAfter:  gomatrixserverlib.HeaderedToClientEvents(after, gomatrixserverlib.FormatAll),
+			State:        gomatrixserverlib.HeaderedToClientEvents(stateRes.StateEvents, gomatrixserverlib.FormatAll),
+		},
+	}
+}
+
+// roomEventContext returns the events that the user is allowed to see either
+// side of the given event, newest first before it and oldest first after it,
+// along with the /messages tokens for paginating backwards from the events
+// before it and forwards from the events after it.
+func roomEventContext(
+	ctx context.Context, syncDB storage.Database,
+	device *userapi.Device, ev *gomatrixserverlib.HeaderedEvent,
+	filter *gomatrixserverlib.RoomEventFilter, beforeLimit, afterLimit int,
+) (before, after []*gomatrixserverlib.HeaderedEvent, start, end types.TopologyToken, err error) {
+	evToken, err := syncDB.EventPositionInTopology(ctx, ev.EventID())
+	if err != nil {
+		err = fmt.Errorf("syncDB.EventPositionInTopology: %w", err)
+		return
+	}
+	start, end = evToken, evToken
+
+	if beforeLimit > 0 {
+		beforeFilter := *filter
+		beforeFilter.Limit = beforeLimit
+		var streamEvents []types.StreamEvent
+		streamEvents, _, err = syncDB.RecentEvents(
+			ctx, ev.RoomID(), types.Range{From: 0, To: evToken.PDUPosition - 1},
+			&beforeFilter, false, false,
+		)
+		if err != nil {
+			err = fmt.Errorf("syncDB.RecentEvents: %w", err)
+			return
+		}
+		before = visibleEventsUntilHidden(ctx, syncDB, device.UserID, syncDB.StreamEventsToEvents(device, streamEvents))
+		if len(before) > 0 {
+			if start, err = syncDB.EventPositionInTopology(ctx, before[len(before)-1].EventID()); err != nil {
+				err = fmt.Errorf("syncDB.EventPositionInTopology: %w", err)
+				return
+			}
+		}
+	}
+	// The start token is the position of the oldest event, so step back
+	// from it to avoid returning it again when paginating backwards.
+	start.Decrement()
+
+	if afterLimit > 0 {
+		var maxPos types.StreamPosition
+		if maxPos, err = syncDB.MaxStreamPositionForPDUs(ctx); err != nil {
+			err = fmt.Errorf("syncDB.MaxStreamPositionForPDUs: %w", err)
+			return
+		}
+		afterFilter := *filter
+		afterFilter.Limit = afterLimit
+		var streamEvents []types.StreamEvent
+		streamEvents, err = syncDB.GetEventsInStreamingRange(
+			ctx, &types.StreamingToken{PDUPosition: evToken.PDUPosition}, &types.StreamingToken{PDUPosition: maxPos},
+			ev.RoomID(), &afterFilter, false,
+		)
+		if err != nil {
+			err = fmt.Errorf("syncDB.GetEventsInStreamingRange: %w", err)
+			return
+		}
+		after = visibleEventsUntilHidden(ctx, syncDB, device.UserID, syncDB.StreamEventsToEvents(device, streamEvents))
+		if len(after) > 0 {
+			if end, err = syncDB.EventPositionInTopology(ctx, after[len(after)-1].EventID()); err != nil {
+				err = fmt.Errorf("syncDB.EventPositionInTopology: %w", err)
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// memberRoomserverAPI says that every user is in every room, leaving the
+// sync API's history visibility checks to decide what they can see.
+type memberRoomserverAPI struct {
+	roomserverAPI.RoomserverInternalAPI
+}
+
+func (a *memberRoomserverAPI) QueryMembershipForUser(
+	ctx context.Context, req *roomserverAPI.QueryMembershipForUserRequest, res *roomserverAPI.QueryMembershipForUserResponse,
+) error {
+	res.HasBeenInRoom, res.IsInRoom = true, true
+	res.Membership = gomatrixserverlib.Join
+	return nil
+}
+
+func (a *memberRoomserverAPI) QueryStateAfterEvents(
+	ctx context.Context, req *roomserverAPI.QueryStateAfterEventsRequest, res *roomserverAPI.QueryStateAfterEventsResponse,
+) error {
+	res.RoomExists, res.PrevEventsExist = true, true
+	return nil
+}
+
+func mustCreateDatabase(t *testing.T, dbType test.DBType) storage.Database {
+	t.Helper()
+	db, err := storage.NewSyncServerDatasource(&config.DatabaseOptions{
+		ConnectionString:   test.PrepareDBConnectionString(t, dbType),
+		MaxOpenConnections: 1,
+		MaxIdleConnections: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	return db
+}
+
+func mustWriteEvents(t *testing.T, db storage.Database, events []*gomatrixserverlib.HeaderedEvent) {
+	t.Helper()
+	for _, ev := range events {
+		var addStateEvents []*gomatrixserverlib.HeaderedEvent
+		var addStateEventIDs []string
+		if ev.StateKey() != nil {
+			addStateEvents = append(addStateEvents, ev)
+			addStateEventIDs = append(addStateEventIDs, ev.EventID())
+		}
+		if _, err := db.WriteEvent(context.Background(), ev, addStateEvents, addStateEventIDs, nil, nil, false); err != nil {
+			t.Fatalf("WriteEvent failed: %s", err)
+		}
+	}
+}
+
+func eventIDs(events []gomatrixserverlib.ClientEvent) []string {
+	ids := make([]string, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.EventID)
+	}
+	return ids
+}
+
+func headeredEventIDs(events ...*gomatrixserverlib.HeaderedEvent) []string {
+	ids := make([]string, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.EventID())
+	}
+	return ids
+}
+
+func TestContext(t *testing.T) {
+	alice, bob := "@alice:test", "@bob:test"
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomHistoryVisibility, map[string]interface{}{
+		"history_visibility": "joined",
+	}, "")
+	var messages []*gomatrixserverlib.HeaderedEvent
+	sendMessage := func(sender string) {
+		messages = append(messages, room.CreateAndInsert(t, sender, "m.room.message", map[string]interface{}{
+			"msgtype": "m.text", "body": fmt.Sprintf("message %d", len(messages)),
+		}))
+	}
+	for i := 0; i < 6; i++ {
+		sendMessage(alice)
+	}
+	bobJoin := room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, bob)
+	for i := 0; i < 4; i++ {
+		sendMessage(bob)
+	}
+	// The events that set up the room, newest first as /messages returns
+	// them when going backwards.
+	var setupEventIDs []string
+	for i := len(room.Events()) - 1; i >= 0; i-- {
+		if ev := room.Events()[i]; ev.Depth() < messages[0].Depth() {
+			setupEventIDs = append(setupEventIDs, ev.EventID())
+		}
+	}
+	rsAPI := &memberRoomserverAPI{}
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db := mustCreateDatabase(t, dbType)
+		mustWriteEvents(t, db, room.Events())
+
+		getContext := func(t *testing.T, userID, eventID string, limit int) (int, contextResponse) {
+			t.Helper()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/context?limit=%d", limit), nil)
+			res := Context(req, &userapi.Device{UserID: userID}, db, rsAPI, room.ID, eventID)
+			body, _ := res.JSON.(contextResponse)
+			return res.Code, body
+		}
+		getMessages := func(t *testing.T, userID, from, dir string) []string {
+			t.Helper()
+			query := url.Values{"from": {from}, "dir": {dir}, "limit": {"100"}}
+			req := httptest.NewRequest(http.MethodGet, "/messages?"+query.Encode(), nil)
+			res := OnIncomingMessagesRequest(req, db, room.ID, &userapi.Device{UserID: userID}, nil, rsAPI, nil, nil, nil)
+			if res.Code != http.StatusOK {
+				t.Fatalf("got /messages status %d: %+v", res.Code, res.JSON)
+			}
+			return eventIDs(res.JSON.(messagesResp).Chunk)
+		}
+
+		t.Run("limit is split around the event", func(t *testing.T) {
+			code, res := getContext(t, alice, messages[3].EventID(), 5)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d", code)
+			}
+			if res.Event.EventID != messages[3].EventID() {
+				t.Errorf("got event %s, want %s", res.Event.EventID, messages[3].EventID())
+			}
+			if got, want := eventIDs(res.EventsBefore), headeredEventIDs(messages[2], messages[1]); !reflect.DeepEqual(got, want) {
+				t.Errorf("got events before %v, want %v", got, want)
+			}
+			if got, want := eventIDs(res.EventsAfter), headeredEventIDs(messages[4], messages[5], bobJoin); !reflect.DeepEqual(got, want) {
+				t.Errorf("got events after %v, want %v", got, want)
+			}
+		})
+
+		t.Run("paginating from the tokens", func(t *testing.T) {
+			_, res := getContext(t, alice, messages[3].EventID(), 5)
+
+			// Going backwards from the start picks up right before the
+			// oldest event that was returned.
+			backwards := getMessages(t, alice, res.Start, "b")
+			wantBackwards := append(headeredEventIDs(messages[0]), setupEventIDs...)
+			if !reflect.DeepEqual(backwards, wantBackwards) {
+				t.Errorf("got events before the start %v, want %v", backwards, wantBackwards)
+			}
+
+			// Going forwards from the end picks up right after the newest
+			// event that was returned.
+			forwards := getMessages(t, alice, res.End, "f")
+			if want := headeredEventIDs(messages[6:]...); !reflect.DeepEqual(forwards, want) {
+				t.Errorf("got events after the end %v, want %v", forwards, want)
+			}
+		})
+
+		t.Run("history visibility", func(t *testing.T) {
+			// Bob can't see what was said before he joined, so the events
+			// before stop at his join, and paginating back from there only
+			// finds the events from before the history visibility changed.
+			code, res := getContext(t, bob, messages[7].EventID(), 6)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d", code)
+			}
+			if got, want := eventIDs(res.EventsBefore), headeredEventIDs(messages[6], bobJoin); !reflect.DeepEqual(got, want) {
+				t.Errorf("got events before %v, want %v", got, want)
+			}
+			if got, want := eventIDs(res.EventsAfter), headeredEventIDs(messages[8:]...); !reflect.DeepEqual(got, want) {
+				t.Errorf("got events after %v, want %v", got, want)
+			}
+			if backwards := getMessages(t, bob, res.Start, "b"); !reflect.DeepEqual(backwards, setupEventIDs) {
+				t.Errorf("got events before the start %v, want %v", backwards, setupEventIDs)
+			}
+
+			if code, _ = getContext(t, bob, messages[5].EventID(), 6); code != http.StatusForbidden {
+				t.Errorf("got status %d for an event from before bob joined, want %d", code, http.StatusForbidden)
+			}
+		})
+	})
+}
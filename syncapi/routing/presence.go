@@ -0,0 +1,121 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/producers"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+type presenceRequest struct {
+	Presence  eduAPI.Presence `json:"presence"`
+	StatusMsg *string         `json:"status_msg,omitempty"`
+}
+
+// SetPresence implements PUT /_matrix/client/r0/presence/{userID}/status
+func SetPresence(
+	req *http.Request, device *userapi.Device,
+	producer *producers.PresenceProducer, userID string,
+) util.JSONResponse {
+	if userID != device.UserID {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Cannot set another user's presence"),
+		}
+	}
+
+	var r presenceRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if !r.Presence.IsValid() {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("presence must be one of 'online', 'unavailable' or 'offline'"),
+		}
+	}
+
+	err := producer.SendPresence(
+		userID, r.Presence, r.StatusMsg, gomatrixserverlib.AsTimestamp(time.Now()),
+	)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("producer.SendPresence failed")
+		return jsonerror.InternalServerError()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// GetPresence implements GET /_matrix/client/r0/presence/{userID}/status
+func GetPresence(
+	req *http.Request, device *userapi.Device, syncDB storage.Database,
+	rsAPI roomserverAPI.RoomserverInternalAPI, userAPI userapi.UserInternalAPI,
+	cfg *config.SyncAPI, userID string,
+) util.JSONResponse {
+	// Users can only see the presence of users that they share a room with.
+	if userID != device.UserID {
+		var sharedRes roomserverAPI.QuerySharedUsersResponse
+		if err := rsAPI.QuerySharedUsers(req.Context(), &roomserverAPI.QuerySharedUsersRequest{
+			UserID: device.UserID,
+		}, &sharedRes); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QuerySharedUsers failed")
+			return jsonerror.InternalServerError()
+		}
+		if _, ok := sharedRes.UserIDsToCount[userID]; !ok {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden("You do not share a room with this user"),
+			}
+		}
+	}
+
+	presence, err := syncDB.GetPresence(req.Context(), userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("syncDB.GetPresence failed")
+		return jsonerror.InternalServerError()
+	}
+	if presence == nil {
+		// Users that nothing is known about are offline.
+		presence = &types.PresenceInternal{
+			UserID:   userID,
+			Presence: eduAPI.PresenceOffline,
+		}
+	}
+	if err = internal.ApplyLastSeen(req.Context(), userAPI, cfg.Matrix.ServerName, presence); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("internal.ApplyLastSeen failed")
+		return jsonerror.InternalServerError()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: presence.ClientPresence(time.Now()),
+	}
+}
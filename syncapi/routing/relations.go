@@ -0,0 +1,182 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+const (
+	defaultRelationsLimit = 5
+	maxRelationsLimit     = 50
+)
+
+type relationsResponse struct {
+	Chunk     []gomatrixserverlib.ClientEvent `json:"chunk"`
+	NextBatch string                          `json:"next_batch,omitempty"`
+	PrevBatch string                          `json:"prev_batch,omitempty"`
+}
+
+// Relations implements GET /_matrix/client/r0/rooms/{roomID}/relations/{eventID}[/{relType}[/{eventType}]]
+func Relations(
+	req *http.Request, device *userapi.Device, syncDB storage.Database,
+	rsAPI roomserverAPI.RoomserverInternalAPI,
+	roomID, eventID, relType, eventType string,
+) util.JSONResponse {
+	ctx := req.Context()
+	query := req.URL.Query()
+	limit := defaultRelationsLimit
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("limit must be a positive integer"),
+			}
+		}
+	}
+	if limit > maxRelationsLimit {
+		limit = maxRelationsLimit
+	}
+	var backwards bool
+	switch query.Get("dir") {
+	case "", "b":
+		backwards = true
+	case "f":
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("dir must be either 'b' or 'f'"),
+		}
+	}
+	from, err := parseRelationsToken(query.Get("from"))
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("from is invalid: " + err.Error()),
+		}
+	}
+	to, err := parseRelationsToken(query.Get("to"))
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("to is invalid: " + err.Error()),
+		}
+	}
+
+	var membershipRes roomserverAPI.QueryMembershipForUserResponse
+	err = rsAPI.QueryMembershipForUser(ctx, &roomserverAPI.QueryMembershipForUserRequest{
+		RoomID: roomID,
+		UserID: device.UserID,
+	}, &membershipRes)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rsAPI.QueryMembershipForUser failed")
+		return jsonerror.InternalServerError()
+	}
+	if !membershipRes.HasBeenInRoom || membershipRes.IsRoomForgotten {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("You aren't a member of the room"),
+		}
+	}
+	events, err := syncDB.Events(ctx, []string{eventID})
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("syncDB.Events failed")
+		return jsonerror.InternalServerError()
+	}
+	if len(events) == 0 || events[0].RoomID() != roomID {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Event not found"),
+		}
+	}
+	if !eventVisibleToUser(ctx, syncDB, events[0], device.UserID) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("You don't have permission to see this event"),
+		}
+	}
+
+	maxPos, err := syncDB.MaxStreamPositionForPDUs(ctx)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("syncDB.MaxStreamPositionForPDUs failed")
+		return jsonerror.InternalServerError()
+	}
+	r := types.Range{From: from, To: to, Backwards: backwards}
+	if backwards && r.From == 0 {
+		r.From = maxPos
+	}
+	if !backwards && r.To == 0 {
+		r.To = maxPos
+	}
+	streamEvents, err := syncDB.RelationsForEvent(ctx, roomID, eventID, relType, eventType, r, limit)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("syncDB.RelationsForEvent failed")
+		return jsonerror.InternalServerError()
+	}
+
+	res := relationsResponse{
+		PrevBatch: query.Get("from"),
+	}
+	if len(streamEvents) == limit {
+		// The low end of a range is exclusive and the high end inclusive, so
+		// step past the last event when going backwards.
+		last := streamEvents[len(streamEvents)-1].StreamPosition
+		if backwards {
+			last--
+		}
+		res.NextBatch = types.StreamingToken{PDUPosition: last}.String()
+	}
+	visible, err := internal.ApplyHistoryVisibilityFilter(ctx, syncDB, device.UserID, syncDB.StreamEventsToEvents(device, streamEvents))
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("internal.ApplyHistoryVisibilityFilter failed")
+		return jsonerror.InternalServerError()
+	}
+	res.Chunk, err = internal.ClientEventsWithRelations(ctx, syncDB, device.UserID, visible, gomatrixserverlib.FormatAll)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("internal.ClientEventsWithRelations failed")
+		return jsonerror.InternalServerError()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// parseRelationsToken returns the stream position of a pagination token,
+// which may be a token from /sync or /messages, or zero if there isn't one.
+func parseRelationsToken(token string) (types.StreamPosition, error) {
+	if token == "" {
+		return 0, nil
+	}
+	if topologyToken, err := types.NewTopologyTokenFromString(token); err == nil {
+		return topologyToken.PDUPosition, nil
+	}
+	streamToken, err := types.NewStreamTokenFromString(token)
+	if err != nil {
+		return 0, err
+	}
+	return streamToken.PDUPosition, nil
+}
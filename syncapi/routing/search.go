@@ -0,0 +1,391 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+const (
+	defaultSearchLimit        = 10
+	maxSearchLimit            = 50
+	defaultSearchContextLimit = 5
+	maxSearchContextLimit     = 20
+)
+
+// searchKeys are the content keys that can be searched, all of which are
+// searched by default.
+var searchKeys = []string{"content.body", "content.name", "content.topic"}
+
+type searchRequest struct {
+	SearchCategories struct {
+		RoomEvents *searchRoomEventsRequest `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+type searchRoomEventsRequest struct {
+	SearchTerm   string                            `json:"search_term"`
+	Keys         []string                          `json:"keys"`
+	Filter       gomatrixserverlib.RoomEventFilter `json:"filter"`
+	OrderBy      string                            `json:"order_by"`
+	EventContext *struct {
+		BeforeLimit    *int `json:"before_limit"`
+		AfterLimit     *int `json:"after_limit"`
+		IncludeProfile bool `json:"include_profile"`
+	} `json:"event_context"`
+	IncludeState bool `json:"include_state"`
+	Groupings    struct {
+		GroupBy []struct {
+			Key string `json:"key"`
+		} `json:"group_by"`
+	} `json:"groupings"`
+}
+
+type searchResponse struct {
+	SearchCategories struct {
+		RoomEvents searchRoomEventsResponse `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+type searchRoomEventsResponse struct {
+	Count      int                                        `json:"count"`
+	Highlights []string                                   `json:"highlights"`
+	Results    []searchResult                             `json:"results"`
+	State      map[string][]gomatrixserverlib.ClientEvent `json:"state,omitempty"`
+	Groups     map[string]map[string]*searchGroup         `json:"groups,omitempty"`
+	NextBatch  string                                     `json:"next_batch,omitempty"`
+}
+
+type searchResult struct {
+	Rank    float64                       `json:"rank"`
+	Result  gomatrixserverlib.ClientEvent `json:"result"`
+	Context *searchContext                `json:"context,omitempty"`
+}
+
+type searchContext struct {
+	Start        string                          `json:"start"`
+	End          string                          `json:"end"`
+	EventsBefore []gomatrixserverlib.ClientEvent `json:"events_before"`
+	EventsAfter  []gomatrixserverlib.ClientEvent `json:"events_after"`
+	ProfileInfo  map[string]searchProfile        `json:"profile_info,omitempty"`
+}
+
+type searchProfile struct {
+	DisplayName string `json:"displayname,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// searchMatch is a search result that the user is allowed to see.
+type searchMatch struct {
+	rank  float64
+	event *gomatrixserverlib.HeaderedEvent
+}
+
+type searchGroup struct {
+	Order   int      `json:"order"`
+	Results []string `json:"results"`
+}
+
+// Search implements POST /_matrix/client/r0/search
+func Search(
+	req *http.Request, device *userapi.Device, syncDB storage.Database,
+) util.JSONResponse {
+	ctx := req.Context()
+	var offset int
+	if nextBatch := req.URL.Query().Get("next_batch"); nextBatch != "" {
+		var err error
+		if offset, err = strconv.Atoi(nextBatch); err != nil || offset < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("next_batch is invalid"),
+			}
+		}
+	}
+
+	var body searchRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+	r := body.SearchCategories.RoomEvents
+	if r == nil {
+		// Room events are the only category that can be searched.
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: map[string]interface{}{"search_categories": struct{}{}},
+		}
+	}
+	if strings.TrimSpace(r.SearchTerm) == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingParam("search_term is required"),
+		}
+	}
+	keys := r.Keys
+	if len(keys) == 0 {
+		keys = searchKeys
+	}
+	for _, key := range keys {
+		if !stringInSlice(key, searchKeys) {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidParam("keys must only contain " + strings.Join(searchKeys, ", ")),
+			}
+		}
+	}
+	var orderByStreamPos bool
+	switch r.OrderBy {
+	case "", "rank":
+	case "recent":
+		orderByStreamPos = true
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("order_by must be either 'rank' or 'recent'"),
+		}
+	}
+	limit := r.Filter.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	// Only search the rooms that the user is in.
+	joinedRooms, err := syncDB.RoomIDsWithMembership(ctx, device.UserID, gomatrixserverlib.Join)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("syncDB.RoomIDsWithMembership failed")
+		return jsonerror.InternalServerError()
+	}
+	roomIDs := make([]string, 0, len(joinedRooms))
+	for _, roomID := range joinedRooms {
+		if len(r.Filter.Rooms) > 0 && !stringInSlice(roomID, r.Filter.Rooms) {
+			continue
+		}
+		if stringInSlice(roomID, r.Filter.NotRooms) {
+			continue
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	var res searchResponse
+	roomEvents := &res.SearchCategories.RoomEvents
+	roomEvents.Highlights = strings.Fields(r.SearchTerm)
+	roomEvents.Results = []searchResult{}
+	if len(roomIDs) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: res,
+		}
+	}
+
+	// Keep searching until there are enough results that the user is allowed
+	// to see, or there are no more matches. The next batch starts after the
+	// last match that was looked at, whether or not it was shown.
+	var visible []searchMatch
+	var count, examined, hidden int
+	for len(visible) < limit {
+		matches, total, err := syncDB.SearchEvents(ctx, r.SearchTerm, roomIDs, keys, &r.Filter, orderByStreamPos, limit, offset+examined)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("syncDB.SearchEvents failed")
+			return jsonerror.InternalServerError()
+		}
+		if total > count {
+			count = total
+		}
+		if len(matches) == 0 {
+			break
+		}
+		eventIDs := make([]string, len(matches))
+		for i := range matches {
+			eventIDs[i] = matches[i].EventID
+		}
+		events, err := syncDB.Events(ctx, eventIDs)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("syncDB.Events failed")
+			return jsonerror.InternalServerError()
+		}
+		eventsByID := make(map[string]*gomatrixserverlib.HeaderedEvent, len(events))
+		for _, ev := range events {
+			eventsByID[ev.EventID()] = ev
+		}
+		for _, match := range matches {
+			if len(visible) == limit {
+				break
+			}
+			examined++
+			ev, ok := eventsByID[match.EventID]
+			if !ok || !eventVisibleToUser(ctx, syncDB, ev, device.UserID) {
+				hidden++
+				continue
+			}
+			visible = append(visible, searchMatch{rank: match.Rank, event: ev})
+		}
+		if len(matches) < limit {
+			break
+		}
+	}
+	// The count is approximate, since only the hidden matches that were
+	// looked at are left out of it.
+	roomEvents.Count = count - hidden
+	if offset+examined < count {
+		roomEvents.NextBatch = strconv.Itoa(offset + examined)
+	}
+
+	var groupBy []string
+	for _, g := range r.Groupings.GroupBy {
+		if g.Key == "room_id" || g.Key == "sender" {
+			groupBy = append(groupBy, g.Key)
+		}
+	}
+	resultRooms := map[string]struct{}{}
+	for _, match := range visible {
+		ev := match.event
+		result := searchResult{
+			Rank:   match.rank,
+			Result: gomatrixserverlib.HeaderedToClientEvent(ev, gomatrixserverlib.FormatAll),
+		}
+		if r.EventContext != nil {
+			result.Context, err = searchEventContext(
+				ctx, syncDB, device, ev,
+				searchContextLimit(r.EventContext.BeforeLimit),
+				searchContextLimit(r.EventContext.AfterLimit),
+				r.EventContext.IncludeProfile,
+			)
+			if err != nil {
+				util.GetLogger(ctx).WithError(err).Error("searchEventContext failed")
+				return jsonerror.InternalServerError()
+			}
+		}
+		roomEvents.Results = append(roomEvents.Results, result)
+		resultRooms[ev.RoomID()] = struct{}{}
+
+		for _, key := range groupBy {
+			if roomEvents.Groups == nil {
+				roomEvents.Groups = map[string]map[string]*searchGroup{}
+			}
+			if roomEvents.Groups[key] == nil {
+				roomEvents.Groups[key] = map[string]*searchGroup{}
+			}
+			value := ev.RoomID()
+			if key == "sender" {
+				value = ev.Sender()
+			}
+			group, ok := roomEvents.Groups[key][value]
+			if !ok {
+				group = &searchGroup{Order: len(roomEvents.Groups[key]) + 1}
+				roomEvents.Groups[key][value] = group
+			}
+			group.Results = append(group.Results, ev.EventID())
+		}
+	}
+
+	if r.IncludeState {
+		roomEvents.State = make(map[string][]gomatrixserverlib.ClientEvent, len(resultRooms))
+		stateFilter := gomatrixserverlib.DefaultStateFilter()
+		for roomID := range resultRooms {
+			state, err := syncDB.CurrentState(ctx, roomID, &stateFilter, nil)
+			if err != nil {
+				util.GetLogger(ctx).WithError(err).Error("syncDB.CurrentState failed")
+				return jsonerror.InternalServerError()
+			}
+			roomEvents.State[roomID] = gomatrixserverlib.HeaderedToClientEvents(state, gomatrixserverlib.FormatAll)
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func searchContextLimit(limit *int) int {
+	if limit == nil || *limit < 0 {
+		return defaultSearchContextLimit
+	}
+	if *limit > maxSearchContextLimit {
+		return maxSearchContextLimit
+	}
+	return *limit
+}
+
+// searchEventContext returns the events either side of a search result,
+// along with the profiles of their senders if requested.
+func searchEventContext(
+	ctx context.Context, syncDB storage.Database,
+	device *userapi.Device, ev *gomatrixserverlib.HeaderedEvent,
+	beforeLimit, afterLimit int, includeProfile bool,
+) (*searchContext, error) {
+	filter := gomatrixserverlib.DefaultRoomEventFilter()
+	before, after, start, end, err := roomEventContext(ctx, syncDB, device, ev, &filter, beforeLimit, afterLimit)
+	if err != nil {
+		return nil, err
+	}
+	searchCtx := &searchContext{
+		Start:        start.String(),
+		End:          end.String(),
+		EventsBefore: gomatrixserverlib.HeaderedToClientEvents(before, gomatrixserverlib.FormatAll),
+		EventsAfter:  gomatrixserverlib.HeaderedToClientEvents(after, gomatrixserverlib.FormatAll),
+	}
+	senders := map[string]struct{}{ev.Sender(): {}}
+	for _, bev := range before {
+		senders[bev.Sender()] = struct{}{}
+	}
+	for _, aev := range after {
+		senders[aev.Sender()] = struct{}{}
+	}
+
+	if includeProfile {
+		searchCtx.ProfileInfo = make(map[string]searchProfile, len(senders))
+		for sender := range senders {
+			memberEvent, err := syncDB.GetStateEvent(ctx, ev.RoomID(), gomatrixserverlib.MRoomMember, sender)
+			if err != nil {
+				return nil, err
+			}
+			if memberEvent == nil {
+				continue
+			}
+			var content gomatrixserverlib.MemberContent
+			if err = json.Unmarshal(memberEvent.Content(), &content); err != nil {
+				continue
+			}
+			searchCtx.ProfileInfo[sender] = searchProfile{
+				DisplayName: content.DisplayName,
+				AvatarURL:   content.AvatarURL,
+			}
+		}
+	}
+	return searchCtx, nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// eventVisibleToUser returns whether the history visibility of the room at
+// the event allows the user to see it.
+func eventVisibleToUser(
+	ctx context.Context, syncDB storage.Database,
+	ev *gomatrixserverlib.HeaderedEvent, userID string,
+) bool {
+	visible, err := internal.ApplyHistoryVisibilityFilter(ctx, syncDB, userID, []*gomatrixserverlib.HeaderedEvent{ev})
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("internal.ApplyHistoryVisibilityFilter failed")
+		return false
+	}
+	return len(visible) == 1
+}
+
+// visibleEventsUntilHidden returns the events up to, but not including, the
+// first one that the user isn't allowed to see. The events should be ordered
+// moving away from an event that the user is allowed to see.
+func visibleEventsUntilHidden(
+	ctx context.Context, syncDB storage.Database,
+	userID string, events []*gomatrixserverlib.HeaderedEvent,
+) []*gomatrixserverlib.HeaderedEvent {
+	visible, err := internal.ApplyHistoryVisibilityFilter(ctx, syncDB, userID, events)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("internal.ApplyHistoryVisibilityFilter failed")
+		return nil
+	}
+	// The filter keeps the order of the events, so the visible events
+	// match up with the first events until one is hidden.
+	for i := range visible {
+		if visible[i].EventID() != events[i].EventID() {
+			return events[:i]
+		}
+	}
+	return events[:len(visible)]
+}
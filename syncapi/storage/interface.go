@@ -18,6 +18,7 @@ import (
 	"context"
 
 	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/syncapi/types"
@@ -31,6 +32,8 @@ type Database interface {
 	MaxStreamPositionForInvites(ctx context.Context) (types.StreamPosition, error)
 	MaxStreamPositionForAccountData(ctx context.Context) (types.StreamPosition, error)
 	MaxStreamPositionForSendToDeviceMessages(ctx context.Context) (types.StreamPosition, error)
+	MaxStreamPositionForNotificationData(ctx context.Context) (types.StreamPosition, error)
+	MaxStreamPositionForPresence(ctx context.Context) (types.StreamPosition, error)
 
 	CurrentState(ctx context.Context, roomID string, stateFilterPart *gomatrixserverlib.StateFilter, excludeEventIDs []string) ([]*gomatrixserverlib.HeaderedEvent, error)
 	GetStateDeltasForFullStateSync(ctx context.Context, device *userapi.Device, r types.Range, userID string, stateFilter *gomatrixserverlib.StateFilter) ([]types.StateDelta, []string, error)
@@ -43,6 +46,7 @@ type Database interface {
 	PositionInTopology(ctx context.Context, eventID string) (pos types.StreamPosition, spos types.StreamPosition, err error)
 
 	InviteEventsInRange(ctx context.Context, targetUserID string, r types.Range) (map[string]*gomatrixserverlib.HeaderedEvent, map[string]*gomatrixserverlib.HeaderedEvent, error)
+	KnockEventsInRange(ctx context.Context, targetUserID string, r types.Range) (map[string]*gomatrixserverlib.HeaderedEvent, map[string]*gomatrixserverlib.HeaderedEvent, error)
 	PeeksInRange(ctx context.Context, userID, deviceID string, r types.Range) (peeks []types.Peek, err error)
 	RoomReceiptsAfter(ctx context.Context, roomIDs []string, streamPos types.StreamPosition) (types.StreamPosition, []eduAPI.OutputReceiptEvent, error)
 
@@ -56,6 +60,17 @@ type Database interface {
 	// Returns an error if there was a problem talking with the database.
 	// Does not include any transaction IDs in the returned events.
 	Events(ctx context.Context, eventIDs []string) ([]*gomatrixserverlib.HeaderedEvent, error)
+	// MembershipChanges returns every known change to the membership of the user in the room,
+	// ordered by topological position.
+	MembershipChanges(ctx context.Context, roomID, userID string) ([]types.MembershipChange, error)
+	// HistoryVisibilityEvents returns all of the m.room.history_visibility events in the room, with their stream positions.
+	HistoryVisibilityEvents(ctx context.Context, roomID string) ([]types.StreamEvent, error)
+	// MaxStreamPositionsForRooms returns the stream position of the latest event that is sent to clients
+	// in each of the given rooms, keyed by room ID. Rooms without any such events are left out.
+	MaxStreamPositionsForRooms(ctx context.Context, roomIDs []string) (map[string]types.StreamPosition, error)
+	// StateEventsOfType returns the current state events of the given type with an empty state key
+	// in the given rooms. Rooms without such an event are left out.
+	StateEventsOfType(ctx context.Context, roomIDs []string, eventType string) ([]*gomatrixserverlib.HeaderedEvent, error)
 	// WriteEvent into the database. It is not safe to call this function from multiple goroutines, as it would create races
 	// when generating the sync stream position for this event. Returns the sync stream position for the inserted event.
 	// Returns an error if there was a problem inserting this event.
@@ -64,6 +79,9 @@ type Database interface {
 	// PurgeRoomState completely purges room state from the sync API. This is done when
 	// receiving an output event that completely resets the state.
 	PurgeRoomState(ctx context.Context, roomID string) error
+	// PurgeRoom removes everything that the sync API knows about a room. This is
+	// done when an admin has purged the room from the roomserver.
+	PurgeRoom(ctx context.Context, roomID string) error
 	// GetStateEvent returns the Matrix state event of a given type for a given room with a given state key
 	// If no event could be found, returns nil
 	// If there was an issue during the retrieval, returns an error
@@ -92,6 +110,12 @@ type Database interface {
 	// RetireInviteEvent removes an old invite event from the database. Returns the new position of the retired invite.
 	// Returns an error if there was a problem communicating with the database.
 	RetireInviteEvent(ctx context.Context, inviteEventID string) (types.StreamPosition, error)
+	// AddKnockEvent stores a new pending knock for a user, replacing any older knock in the same room.
+	// Returns the stream ID that the knock was stored at.
+	AddKnockEvent(ctx context.Context, knockEvent *gomatrixserverlib.HeaderedEvent) (types.StreamPosition, error)
+	// RetireKnockEvent retires the pending knock of a user in a room. Returns the new position of the retired
+	// knock, or zero if the user had no pending knock.
+	RetireKnockEvent(ctx context.Context, roomID, userID string) (types.StreamPosition, error)
 	// AddPeek adds a new peek to our DB for a given room by a given user's device.
 	// Returns an error if there was a problem communicating with the database.
 	AddPeek(ctx context.Context, RoomID, UserID, DeviceID string) (types.StreamPosition, error)
@@ -137,4 +161,27 @@ type Database interface {
 	StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId string, timestamp gomatrixserverlib.Timestamp) (pos types.StreamPosition, err error)
 	// GetRoomReceipts gets all receipts for a given roomID
 	GetRoomReceipts(ctx context.Context, roomIDs []string, streamPos types.StreamPosition) ([]eduAPI.OutputReceiptEvent, error)
+	// UpsertRoomUnreadNotificationCounts stores the unread notification counts of a user in a room
+	UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int) (types.StreamPosition, error)
+	// GetUserUnreadNotificationCounts returns the unread notification counts of a user in the rooms where
+	// they changed after from and up to and including to, keyed by room ID
+	GetUserUnreadNotificationCounts(ctx context.Context, userID string, from, to types.StreamPosition) (map[string]*eventutil.NotificationData, error)
+	// UpsertPresence replaces the presence of a user
+	UpsertPresence(ctx context.Context, userID string, presence eduAPI.Presence, statusMsg *string, lastActiveTS gomatrixserverlib.Timestamp) (types.StreamPosition, error)
+	// GetPresence returns the presence of a user, or nil if it isn't known
+	GetPresence(ctx context.Context, userID string) (*types.PresenceInternal, error)
+	// GetPresenceAfter returns the presence of the users whose presence changed
+	// after from and up to and including to
+	GetPresenceAfter(ctx context.Context, from, to types.StreamPosition) ([]*types.PresenceInternal, error)
+	// SearchEvents returns up to limit of the events in the given rooms whose given content keys
+	// match the search term and which pass the sender and type parts of the filter, skipping the
+	// first offset of them, along with the total number of matching events. Results are ordered
+	// by rank, or newest first if orderByStreamPos is true.
+	SearchEvents(ctx context.Context, searchTerm string, roomIDs, keys []string, filter *gomatrixserverlib.RoomEventFilter, orderByStreamPos bool, limit, offset int) ([]types.SearchResult, int, error)
+	// RelationsForEvent returns up to limit of the events in the range that relate to the given event,
+	// optionally only those with the given rel_type and event type, in the direction of the range.
+	RelationsForEvent(ctx context.Context, roomID, eventID, relType, eventType string, r types.Range, limit int) ([]types.StreamEvent, error)
+	// RelationAggregations returns the aggregated relations to those of the given events that have any,
+	// keyed by event ID. The thread participation is that of the given user.
+	RelationAggregations(ctx context.Context, userID string, events []*gomatrixserverlib.HeaderedEvent) (map[string]*types.RelationAggregations, error)
 }
@@ -72,7 +72,7 @@ const deleteRoomStateByEventIDSQL = "" +
 	"DELETE FROM syncapi_current_room_state WHERE event_id = $1"
 
 const DeleteRoomStateForRoomSQL = "" +
-	"DELETE FROM syncapi_current_room_state WHERE event_id = $1"
+	"DELETE FROM syncapi_current_room_state WHERE room_id = $1"
 
 const selectRoomIDsWithMembershipSQL = "" +
 	"SELECT DISTINCT room_id FROM syncapi_current_room_state WHERE type = 'm.room.member' AND state_key = $1 AND membership = $2"
@@ -93,6 +93,10 @@ const selectJoinedUsersSQL = "" +
 const selectStateEventSQL = "" +
 	"SELECT headered_event_json FROM syncapi_current_room_state WHERE room_id = $1 AND type = $2 AND state_key = $3"
 
+const selectStateEventsOfTypeSQL = "" +
+	"SELECT event_id, headered_event_json FROM syncapi_current_room_state" +
+	" WHERE room_id = ANY($1) AND type = $2 AND state_key = ''"
+
 const selectEventsWithEventIDsSQL = "" +
 	// TODO: The session_id and transaction_id blanks are here because otherwise
 	// the rowsToStreamEvents expects there to be exactly six columns. We need to
@@ -110,6 +114,7 @@ type currentRoomStateStatements struct {
 	selectJoinedUsersStmt           *sql.Stmt
 	selectEventsWithEventIDsStmt    *sql.Stmt
 	selectStateEventStmt            *sql.Stmt
+	selectStateEventsOfTypeStmt     *sql.Stmt
 }
 
 func NewPostgresCurrentRoomStateTable(db *sql.DB) (tables.CurrentRoomState, error) {
@@ -142,6 +147,9 @@ func NewPostgresCurrentRoomStateTable(db *sql.DB) (tables.CurrentRoomState, erro
 	if s.selectStateEventStmt, err = db.Prepare(selectStateEventSQL); err != nil {
 		return nil, err
 	}
+	if s.selectStateEventsOfTypeStmt, err = db.Prepare(selectStateEventsOfTypeSQL); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -316,3 +324,17 @@ func (s *currentRoomStateStatements) SelectStateEvent(
 	}
 	return &ev, err
 }
+
+// SelectStateEventsOfType returns the current state events of the given type
+// with an empty state key in the given rooms.
+func (s *currentRoomStateStatements) SelectStateEventsOfType(
+	ctx context.Context, txn *sql.Tx, roomIDs []string, eventType string,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectStateEventsOfTypeStmt)
+	rows, err := stmt.QueryContext(ctx, pq.StringArray(roomIDs), eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectStateEventsOfType: rows.close() failed")
+	return rowsToEvents(rows)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadMembershipHistory(m *sqlutil.Migrations) {
+	m.AddMigration(UpMembershipHistory, DownMembershipHistory)
+}
+
+// UpMembershipHistory stops the memberships table from only keeping the latest
+// change of each kind, and fills in the membership changes that were lost from
+// the membership events that we already have.
+func UpMembershipHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE syncapi_memberships
+		  DROP CONSTRAINT IF EXISTS syncapi_memberships_unique;
+		INSERT INTO syncapi_memberships (room_id, user_id, membership, event_id, stream_pos, topological_pos)
+		  SELECT o.room_id, o.headered_event_json::jsonb->>'state_key', o.headered_event_json::jsonb->'content'->>'membership',
+		    o.event_id, o.id, t.topological_position
+		  FROM syncapi_output_room_events o
+		  JOIN syncapi_output_room_events_topology t ON t.event_id = o.event_id
+		  WHERE o.type = 'm.room.member'
+		    AND o.headered_event_json::jsonb->>'state_key' IS NOT NULL
+		    AND o.headered_event_json::jsonb->'content'->>'membership' IS NOT NULL
+		  ON CONFLICT (event_id) DO NOTHING;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownMembershipHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DELETE FROM syncapi_memberships a USING syncapi_memberships b
+		  WHERE a.room_id = b.room_id AND a.user_id = b.user_id AND a.membership = b.membership
+		    AND a.stream_pos < b.stream_pos;
+		ALTER TABLE syncapi_memberships
+		  ADD CONSTRAINT syncapi_memberships_unique UNIQUE (room_id, user_id, membership);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const knockEventsSchema = `
+-- Stores the pending knocks of local users, so that they can be told about
+-- them in the knock section of /sync.
+CREATE TABLE IF NOT EXISTS syncapi_knock_events (
+	id BIGINT PRIMARY KEY DEFAULT nextval('syncapi_stream_id'),
+	event_id TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	target_user_id TEXT NOT NULL,
+	headered_event_json TEXT NOT NULL,
+	deleted BOOL NOT NULL,
+	CONSTRAINT syncapi_knock_events_unique UNIQUE (room_id, target_user_id)
+);
+
+-- For looking up the knocks for a given user.
+CREATE INDEX IF NOT EXISTS syncapi_knocks_target_user_id_idx
+	ON syncapi_knock_events (target_user_id, id);
+`
+
+const upsertKnockEventSQL = "" +
+	"INSERT INTO syncapi_knock_events (" +
+	" room_id, event_id, target_user_id, headered_event_json, deleted" +
+	") VALUES ($1, $2, $3, $4, FALSE)" +
+	" ON CONFLICT ON CONSTRAINT syncapi_knock_events_unique" +
+	" DO UPDATE SET id = nextval('syncapi_stream_id'), event_id = $2, headered_event_json = $4, deleted = FALSE" +
+	" RETURNING id"
+
+const retireKnockEventSQL = "" +
+	"UPDATE syncapi_knock_events SET deleted=TRUE, id=nextval('syncapi_stream_id')" +
+	" WHERE room_id = $1 AND target_user_id = $2 AND NOT deleted RETURNING id"
+
+const selectKnockEventsInRangeSQL = "" +
+	"SELECT room_id, headered_event_json, deleted FROM syncapi_knock_events" +
+	" WHERE target_user_id = $1 AND id > $2 AND id <= $3" +
+	" ORDER BY id DESC"
+
+const selectMaxKnockIDSQL = "" +
+	"SELECT MAX(id) FROM syncapi_knock_events"
+
+type knockEventsStatements struct {
+	upsertKnockEventStmt         *sql.Stmt
+	retireKnockEventStmt         *sql.Stmt
+	selectKnockEventsInRangeStmt *sql.Stmt
+	selectMaxKnockIDStmt         *sql.Stmt
+}
+
+func NewPostgresKnocksTable(db *sql.DB) (tables.Knocks, error) {
+	s := &knockEventsStatements{}
+	_, err := db.Exec(knockEventsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertKnockEventStmt, upsertKnockEventSQL},
+		{&s.retireKnockEventStmt, retireKnockEventSQL},
+		{&s.selectKnockEventsInRangeStmt, selectKnockEventsInRangeSQL},
+		{&s.selectMaxKnockIDStmt, selectMaxKnockIDSQL},
+	}.Prepare(db)
+}
+
+func (s *knockEventsStatements) UpsertKnockEvent(
+	ctx context.Context, txn *sql.Tx, knockEvent *gomatrixserverlib.HeaderedEvent,
+) (streamPos types.StreamPosition, err error) {
+	var headeredJSON []byte
+	headeredJSON, err = json.Marshal(knockEvent)
+	if err != nil {
+		return
+	}
+
+	stmt := sqlutil.TxStmt(txn, s.upsertKnockEventStmt)
+	err = stmt.QueryRowContext(
+		ctx,
+		knockEvent.RoomID(),
+		knockEvent.EventID(),
+		*knockEvent.StateKey(),
+		headeredJSON,
+	).Scan(&streamPos)
+	return
+}
+
+func (s *knockEventsStatements) RetireKnockEvent(
+	ctx context.Context, txn *sql.Tx, roomID, targetUserID string,
+) (sp types.StreamPosition, err error) {
+	stmt := sqlutil.TxStmt(txn, s.retireKnockEventStmt)
+	err = stmt.QueryRowContext(ctx, roomID, targetUserID).Scan(&sp)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return
+}
+
+// SelectKnockEventsInRange returns a map of room ID to knock event for the
+// pending and retired knocks for the target user ID in the supplied range.
+func (s *knockEventsStatements) SelectKnockEventsInRange(
+	ctx context.Context, txn *sql.Tx, targetUserID string, r types.Range,
+) (map[string]*gomatrixserverlib.HeaderedEvent, map[string]*gomatrixserverlib.HeaderedEvent, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectKnockEventsInRangeStmt)
+	rows, err := stmt.QueryContext(ctx, targetUserID, r.Low(), r.High())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectKnockEventsInRange: rows.close() failed")
+	result := map[string]*gomatrixserverlib.HeaderedEvent{}
+	retired := map[string]*gomatrixserverlib.HeaderedEvent{}
+	for rows.Next() {
+		var (
+			roomID    string
+			eventJSON []byte
+			deleted   bool
+		)
+		if err = rows.Scan(&roomID, &eventJSON, &deleted); err != nil {
+			return nil, nil, err
+		}
+
+		var event *gomatrixserverlib.HeaderedEvent
+		if err := json.Unmarshal(eventJSON, &event); err != nil {
+			return nil, nil, err
+		}
+
+		if deleted {
+			retired[roomID] = event
+		} else {
+			result[roomID] = event
+		}
+	}
+	return result, retired, rows.Err()
+}
+
+func (s *knockEventsStatements) SelectMaxKnockID(
+	ctx context.Context, txn *sql.Tx,
+) (id int64, err error) {
+	var nullableID sql.NullInt64
+	stmt := sqlutil.TxStmt(txn, s.selectMaxKnockIDStmt)
+	err = stmt.QueryRowContext(ctx).Scan(&nullableID)
+	if nullableID.Valid {
+		id = nullableID.Int64
+	}
+	return
+}
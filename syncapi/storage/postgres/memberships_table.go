@@ -19,17 +19,17 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/syncapi/storage/tables"
 	"github.com/matrix-org/dendrite/syncapi/types"
 	"github.com/matrix-org/gomatrixserverlib"
 )
 
-// The memberships table is designed to track the last time that
-// the user was a given state. This allows us to find out the
-// most recent time that a user was invited to, joined or left
-// a room, either by choice or otherwise. This is important for
-// building history visibility.
+// The memberships table is designed to track every change to the
+// membership of a user in a room. This allows us to find out when
+// a user was invited to, joined or left a room, either by choice
+// or otherwise. This is important for building history visibility.
 
 const membershipsSchema = `
 CREATE TABLE IF NOT EXISTS syncapi_memberships (
@@ -39,22 +39,22 @@ CREATE TABLE IF NOT EXISTS syncapi_memberships (
 	user_id TEXT NOT NULL,
 	-- The status of the membership
 	membership TEXT NOT NULL,
-	-- The event ID that last changed the membership
+	-- The event ID that changed the membership
 	event_id TEXT NOT NULL,
 	-- The stream position of the change
 	stream_pos BIGINT NOT NULL,
 	-- The topological position of the change in the room
-	topological_pos BIGINT NOT NULL,
-	-- Unique index
-	CONSTRAINT syncapi_memberships_unique UNIQUE (room_id, user_id, membership)
+	topological_pos BIGINT NOT NULL
 );
+
+CREATE UNIQUE INDEX IF NOT EXISTS syncapi_memberships_event_id_idx ON syncapi_memberships(event_id);
+CREATE INDEX IF NOT EXISTS syncapi_memberships_room_user_idx ON syncapi_memberships(room_id, user_id);
 `
 
-const upsertMembershipSQL = "" +
+const insertMembershipSQL = "" +
 	"INSERT INTO syncapi_memberships (room_id, user_id, membership, event_id, stream_pos, topological_pos)" +
 	" VALUES ($1, $2, $3, $4, $5, $6)" +
-	" ON CONFLICT ON CONSTRAINT syncapi_memberships_unique" +
-	" DO UPDATE SET event_id = $4, stream_pos = $5, topological_pos = $6"
+	" ON CONFLICT (event_id) DO NOTHING"
 
 const selectMembershipSQL = "" +
 	"SELECT event_id, stream_pos, topological_pos FROM syncapi_memberships" +
@@ -62,9 +62,19 @@ const selectMembershipSQL = "" +
 	" ORDER BY stream_pos DESC" +
 	" LIMIT 1"
 
+const selectMembershipChangesSQL = "" +
+	"SELECT event_id, membership, stream_pos, topological_pos FROM syncapi_memberships" +
+	" WHERE room_id = $1 AND user_id = $2" +
+	" ORDER BY topological_pos ASC, stream_pos ASC"
+
+const deleteMembershipsForRoomSQL = "" +
+	"DELETE FROM syncapi_memberships WHERE room_id = $1"
+
 type membershipsStatements struct {
-	upsertMembershipStmt *sql.Stmt
-	selectMembershipStmt *sql.Stmt
+	insertMembershipStmt         *sql.Stmt
+	deleteMembershipsForRoomStmt *sql.Stmt
+	selectMembershipChangesStmt  *sql.Stmt
+	selectMembershipStmt         *sql.Stmt
 }
 
 func NewPostgresMembershipsTable(db *sql.DB) (tables.Memberships, error) {
@@ -73,7 +83,13 @@ func NewPostgresMembershipsTable(db *sql.DB) (tables.Memberships, error) {
 	if err != nil {
 		return nil, err
 	}
-	if s.upsertMembershipStmt, err = db.Prepare(upsertMembershipSQL); err != nil {
+	if s.insertMembershipStmt, err = db.Prepare(insertMembershipSQL); err != nil {
+		return nil, err
+	}
+	if s.deleteMembershipsForRoomStmt, err = db.Prepare(deleteMembershipsForRoomSQL); err != nil {
+		return nil, err
+	}
+	if s.selectMembershipChangesStmt, err = db.Prepare(selectMembershipChangesSQL); err != nil {
 		return nil, err
 	}
 	if s.selectMembershipStmt, err = db.Prepare(selectMembershipSQL); err != nil {
@@ -82,7 +98,7 @@ func NewPostgresMembershipsTable(db *sql.DB) (tables.Memberships, error) {
 	return s, nil
 }
 
-func (s *membershipsStatements) UpsertMembership(
+func (s *membershipsStatements) InsertMembership(
 	ctx context.Context, txn *sql.Tx, event *gomatrixserverlib.HeaderedEvent,
 	streamPos, topologicalPos types.StreamPosition,
 ) error {
@@ -90,7 +106,7 @@ func (s *membershipsStatements) UpsertMembership(
 	if err != nil {
 		return fmt.Errorf("event.Membership: %w", err)
 	}
-	_, err = sqlutil.TxStmt(txn, s.upsertMembershipStmt).ExecContext(
+	_, err = sqlutil.TxStmt(txn, s.insertMembershipStmt).ExecContext(
 		ctx,
 		event.RoomID(),
 		*event.StateKey(),
@@ -109,3 +125,29 @@ func (s *membershipsStatements) SelectMembership(
 	err = stmt.QueryRowContext(ctx, roomID, userID, memberships).Scan(&eventID, &streamPos, &topologyPos)
 	return
 }
+
+func (s *membershipsStatements) SelectMembershipChanges(
+	ctx context.Context, txn *sql.Tx, roomID, userID string,
+) ([]types.MembershipChange, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMembershipChangesStmt).QueryContext(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectMembershipChanges: rows.close() failed")
+	var changes []types.MembershipChange
+	for rows.Next() {
+		var change types.MembershipChange
+		if err = rows.Scan(&change.EventID, &change.Membership, &change.StreamPosition, &change.TopologicalPosition); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (s *membershipsStatements) DeleteMembershipsForRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteMembershipsForRoomStmt).ExecContext(ctx, roomID)
+	return err
+}
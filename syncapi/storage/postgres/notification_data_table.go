@@ -0,0 +1,118 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+)
+
+const notificationDataSchema = `
+CREATE SEQUENCE IF NOT EXISTS syncapi_notification_data_id;
+
+-- Stores the unread notification counts of users in rooms
+CREATE TABLE IF NOT EXISTS syncapi_notification_data (
+	-- The stream position at which the counts last changed
+	id BIGINT PRIMARY KEY DEFAULT nextval('syncapi_notification_data_id'),
+	user_id TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	notification_count BIGINT NOT NULL DEFAULT 0,
+	highlight_count BIGINT NOT NULL DEFAULT 0,
+	CONSTRAINT syncapi_notification_data_unique UNIQUE (user_id, room_id)
+);
+`
+
+const upsertRoomUnreadNotificationCountsSQL = "" +
+	"INSERT INTO syncapi_notification_data" +
+	" (user_id, room_id, notification_count, highlight_count)" +
+	" VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (user_id, room_id)" +
+	" DO UPDATE SET id = nextval('syncapi_notification_data_id'), notification_count = $3, highlight_count = $4" +
+	" RETURNING id"
+
+const selectUserUnreadNotificationCountsSQL = "" +
+	"SELECT id, room_id, notification_count, highlight_count" +
+	" FROM syncapi_notification_data" +
+	" WHERE user_id = $1 AND id > $2 AND id <= $3"
+
+const selectMaxNotificationIDSQL = "" +
+	"SELECT MAX(id) FROM syncapi_notification_data"
+
+type notificationDataStatements struct {
+	upsertRoomUnreadCounts *sql.Stmt
+	selectUserUnreadCounts *sql.Stmt
+	selectMaxID            *sql.Stmt
+}
+
+func NewPostgresNotificationDataTable(db *sql.DB) (tables.NotificationData, error) {
+	_, err := db.Exec(notificationDataSchema)
+	if err != nil {
+		return nil, err
+	}
+	r := &notificationDataStatements{}
+	return r, sqlutil.StatementList{
+		{&r.upsertRoomUnreadCounts, upsertRoomUnreadNotificationCountsSQL},
+		{&r.selectUserUnreadCounts, selectUserUnreadNotificationCountsSQL},
+		{&r.selectMaxID, selectMaxNotificationIDSQL},
+	}.Prepare(db)
+}
+
+func (r *notificationDataStatements) UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
+	stmt := sqlutil.TxStmt(txn, r.upsertRoomUnreadCounts)
+	err = stmt.QueryRowContext(ctx, userID, roomID, notificationCount, highlightCount).Scan(&pos)
+	return
+}
+
+func (r *notificationDataStatements) SelectUserUnreadCounts(ctx context.Context, txn *sql.Tx, userID string, fromExcl, toIncl types.StreamPosition) (map[string]*eventutil.NotificationData, error) {
+	stmt := sqlutil.TxStmt(txn, r.selectUserUnreadCounts)
+	rows, err := stmt.QueryContext(ctx, userID, fromExcl, toIncl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query unread notification counts: %w", err)
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectUserUnreadCounts: rows.close() failed")
+
+	roomCounts := map[string]*eventutil.NotificationData{}
+	for rows.Next() {
+		var id types.StreamPosition
+		var roomID string
+		var total, highlight int
+		if err = rows.Scan(&id, &roomID, &total, &highlight); err != nil {
+			return nil, err
+		}
+		roomCounts[roomID] = &eventutil.NotificationData{
+			RoomID:                  roomID,
+			UnreadNotificationCount: total,
+			UnreadHighlightCount:    highlight,
+		}
+	}
+	return roomCounts, rows.Err()
+}
+
+func (r *notificationDataStatements) SelectMaxID(ctx context.Context, txn *sql.Tx) (id int64, err error) {
+	var nullableID sql.NullInt64
+	stmt := sqlutil.TxStmt(txn, r.selectMaxID)
+	err = stmt.QueryRowContext(ctx).Scan(&nullableID)
+	if nullableID.Valid {
+		id = nullableID.Int64
+	}
+	return
+}
@@ -69,6 +69,8 @@ CREATE TABLE IF NOT EXISTS syncapi_output_room_events (
   -- were emitted.
   exclude_from_sync BOOL DEFAULT FALSE
 );
+
+CREATE INDEX IF NOT EXISTS syncapi_output_room_events_type_idx ON syncapi_output_room_events(room_id, type);
 `
 
 const insertEventSQL = "" +
@@ -108,9 +110,19 @@ const selectEarlyEventsSQL = "" +
 	" AND ( $7::text[] IS NULL OR NOT(type LIKE ANY($7)) )" +
 	" ORDER BY id ASC LIMIT $8"
 
+const selectEventsOfTypeSQL = "" +
+	"SELECT event_id, id, headered_event_json, session_id, exclude_from_sync, transaction_id FROM syncapi_output_room_events" +
+	" WHERE room_id = $1 AND type = $2" +
+	" ORDER BY id ASC"
+
 const selectMaxEventIDSQL = "" +
 	"SELECT MAX(id) FROM syncapi_output_room_events"
 
+const selectMaxEventIDsForRoomsSQL = "" +
+	"SELECT room_id, MAX(id) FROM syncapi_output_room_events" +
+	" WHERE room_id = ANY($1) AND exclude_from_sync = FALSE" +
+	" GROUP BY room_id"
+
 const updateEventJSONSQL = "" +
 	"UPDATE syncapi_output_room_events SET headered_event_json=$1 WHERE event_id=$2"
 
@@ -133,7 +145,9 @@ const deleteEventsForRoomSQL = "" +
 type outputRoomEventsStatements struct {
 	insertEventStmt               *sql.Stmt
 	selectEventsStmt              *sql.Stmt
+	selectEventsOfTypeStmt        *sql.Stmt
 	selectMaxEventIDStmt          *sql.Stmt
+	selectMaxEventIDsForRoomsStmt *sql.Stmt
 	selectRecentEventsStmt        *sql.Stmt
 	selectRecentEventsForSyncStmt *sql.Stmt
 	selectEarlyEventsStmt         *sql.Stmt
@@ -154,9 +168,15 @@ func NewPostgresEventsTable(db *sql.DB) (tables.Events, error) {
 	if s.selectEventsStmt, err = db.Prepare(selectEventsSQL); err != nil {
 		return nil, err
 	}
+	if s.selectEventsOfTypeStmt, err = db.Prepare(selectEventsOfTypeSQL); err != nil {
+		return nil, err
+	}
 	if s.selectMaxEventIDStmt, err = db.Prepare(selectMaxEventIDSQL); err != nil {
 		return nil, err
 	}
+	if s.selectMaxEventIDsForRoomsStmt, err = db.Prepare(selectMaxEventIDsForRoomsSQL); err != nil {
+		return nil, err
+	}
 	if s.selectRecentEventsStmt, err = db.Prepare(selectRecentEventsSQL); err != nil {
 		return nil, err
 	}
@@ -429,6 +449,41 @@ func (s *outputRoomEventsStatements) SelectEvents(
 	return rowsToStreamEvents(rows)
 }
 
+// SelectEventsOfType returns all of the events of the given type in the room,
+// ordered by stream position.
+func (s *outputRoomEventsStatements) SelectEventsOfType(
+	ctx context.Context, txn *sql.Tx, roomID, eventType string,
+) ([]types.StreamEvent, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectEventsOfTypeStmt).QueryContext(ctx, roomID, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventsOfType: rows.close() failed")
+	return rowsToStreamEvents(rows)
+}
+
+// SelectMaxEventIDsForRooms returns the stream position of the latest event
+// that is sent to clients in each of the given rooms, keyed by room ID.
+func (s *outputRoomEventsStatements) SelectMaxEventIDsForRooms(
+	ctx context.Context, txn *sql.Tx, roomIDs []string,
+) (map[string]types.StreamPosition, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMaxEventIDsForRoomsStmt).QueryContext(ctx, pq.StringArray(roomIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectMaxEventIDsForRooms: rows.close() failed")
+	result := make(map[string]types.StreamPosition, len(roomIDs))
+	for rows.Next() {
+		var roomID string
+		var id types.StreamPosition
+		if err = rows.Scan(&roomID, &id); err != nil {
+			return nil, err
+		}
+		result[roomID] = id
+	}
+	return result, rows.Err()
+}
+
 func (s *outputRoomEventsStatements) DeleteEventsForRoom(
 	ctx context.Context, txn *sql.Tx, roomID string,
 ) (err error) {
@@ -0,0 +1,155 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const presenceSchema = `
+CREATE SEQUENCE IF NOT EXISTS syncapi_presence_id;
+
+-- Stores the current presence of users
+CREATE TABLE IF NOT EXISTS syncapi_presence (
+	-- The stream position at which the presence last changed
+	id BIGINT PRIMARY KEY DEFAULT nextval('syncapi_presence_id'),
+	user_id TEXT NOT NULL,
+	-- One of online, unavailable or offline
+	presence TEXT NOT NULL,
+	status_msg TEXT,
+	-- When the user was last active, as a unix timestamp (ms resolution)
+	last_active_ts BIGINT NOT NULL,
+	CONSTRAINT syncapi_presence_unique UNIQUE (user_id)
+);
+`
+
+const upsertPresenceSQL = "" +
+	"INSERT INTO syncapi_presence" +
+	" (user_id, presence, status_msg, last_active_ts)" +
+	" VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (user_id)" +
+	" DO UPDATE SET id = nextval('syncapi_presence_id')," +
+	" presence = $2, status_msg = $3, last_active_ts = $4" +
+	" RETURNING id"
+
+const selectPresenceForUserSQL = "" +
+	"SELECT id, presence, status_msg, last_active_ts FROM syncapi_presence" +
+	" WHERE user_id = $1"
+
+const selectPresenceAfterSQL = "" +
+	"SELECT id, user_id, presence, status_msg, last_active_ts FROM syncapi_presence" +
+	" WHERE id > $1 AND id <= $2 ORDER BY id ASC"
+
+const selectMaxPresenceIDSQL = "" +
+	"SELECT MAX(id) FROM syncapi_presence"
+
+type presenceStatements struct {
+	upsertPresenceStmt        *sql.Stmt
+	selectPresenceForUserStmt *sql.Stmt
+	selectPresenceAfterStmt   *sql.Stmt
+	selectMaxPresenceIDStmt   *sql.Stmt
+}
+
+func NewPostgresPresenceTable(db *sql.DB) (tables.Presence, error) {
+	_, err := db.Exec(presenceSchema)
+	if err != nil {
+		return nil, err
+	}
+	s := &presenceStatements{}
+	return s, sqlutil.StatementList{
+		{&s.upsertPresenceStmt, upsertPresenceSQL},
+		{&s.selectPresenceForUserStmt, selectPresenceForUserSQL},
+		{&s.selectPresenceAfterStmt, selectPresenceAfterSQL},
+		{&s.selectMaxPresenceIDStmt, selectMaxPresenceIDSQL},
+	}.Prepare(db)
+}
+
+// UpsertPresence replaces the presence of the user, returning the new
+// stream position.
+func (s *presenceStatements) UpsertPresence(
+	ctx context.Context, txn *sql.Tx, userID string, presence eduAPI.Presence,
+	statusMsg *string, lastActiveTS gomatrixserverlib.Timestamp,
+) (pos types.StreamPosition, err error) {
+	stmt := sqlutil.TxStmt(txn, s.upsertPresenceStmt)
+	err = stmt.QueryRowContext(ctx, userID, presence, statusMsg, lastActiveTS).Scan(&pos)
+	return
+}
+
+// SelectPresenceForUser returns the presence of the user, or nil if
+// nothing is known about it.
+func (s *presenceStatements) SelectPresenceForUser(
+	ctx context.Context, txn *sql.Tx, userID string,
+) (*types.PresenceInternal, error) {
+	p := &types.PresenceInternal{UserID: userID}
+	var statusMsg sql.NullString
+	stmt := sqlutil.TxStmt(txn, s.selectPresenceForUserStmt)
+	err := stmt.QueryRowContext(ctx, userID).Scan(&p.StreamPos, &p.Presence, &statusMsg, &p.LastActiveTS)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if statusMsg.Valid {
+		p.StatusMsg = &statusMsg.String
+	}
+	return p, nil
+}
+
+// SelectPresenceAfter returns the presence of the users whose presence
+// changed after fromExcl and up to and including toIncl.
+func (s *presenceStatements) SelectPresenceAfter(
+	ctx context.Context, txn *sql.Tx, fromExcl, toIncl types.StreamPosition,
+) ([]*types.PresenceInternal, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectPresenceAfterStmt)
+	rows, err := stmt.QueryContext(ctx, fromExcl, toIncl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query presence: %w", err)
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectPresenceAfter: rows.close() failed")
+
+	var presences []*types.PresenceInternal
+	for rows.Next() {
+		p := &types.PresenceInternal{}
+		var statusMsg sql.NullString
+		if err = rows.Scan(&p.StreamPos, &p.UserID, &p.Presence, &statusMsg, &p.LastActiveTS); err != nil {
+			return nil, err
+		}
+		if statusMsg.Valid {
+			p.StatusMsg = &statusMsg.String
+		}
+		presences = append(presences, p)
+	}
+	return presences, rows.Err()
+}
+
+func (s *presenceStatements) SelectMaxPresenceID(ctx context.Context, txn *sql.Tx) (id int64, err error) {
+	var nullableID sql.NullInt64
+	stmt := sqlutil.TxStmt(txn, s.selectMaxPresenceIDStmt)
+	err = stmt.QueryRowContext(ctx).Scan(&nullableID)
+	if nullableID.Valid {
+		id = nullableID.Int64
+	}
+	return
+}
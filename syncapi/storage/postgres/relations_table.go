@@ -0,0 +1,185 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const relationsSchema = `
+-- Stores the events that relate to other events through m.relates_to.
+CREATE TABLE IF NOT EXISTS syncapi_relations (
+	-- The stream position of the relating event
+	id BIGINT PRIMARY KEY,
+	room_id TEXT NOT NULL,
+	event_id TEXT NOT NULL CONSTRAINT syncapi_relations_event_id_idx UNIQUE,
+	event_type TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	-- The event that the event relates to
+	relates_to TEXT NOT NULL,
+	rel_type TEXT NOT NULL,
+	-- The key of annotations, e.g. the emoji of a reaction
+	aggregation_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS syncapi_relations_relates_to_idx ON syncapi_relations(relates_to, rel_type);
+`
+
+const insertRelationSQL = "" +
+	"INSERT INTO syncapi_relations (id, room_id, event_id, event_type, sender, relates_to, rel_type, aggregation_key)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)" +
+	" ON CONFLICT ON CONSTRAINT syncapi_relations_event_id_idx DO NOTHING"
+
+const deleteRelationSQL = "" +
+	"DELETE FROM syncapi_relations WHERE event_id = $1"
+
+const deleteRelationsForRoomSQL = "" +
+	"DELETE FROM syncapi_relations WHERE room_id = $1"
+
+const selectRelationsInRangeAscSQL = "" +
+	"SELECT id, event_id FROM syncapi_relations" +
+	" WHERE room_id = $1 AND relates_to = $2 AND ($3 = '' OR rel_type = $3) AND ($4 = '' OR event_type = $4)" +
+	" AND id > $5 AND id <= $6" +
+	" ORDER BY id ASC LIMIT $7"
+
+const selectRelationsInRangeDescSQL = "" +
+	"SELECT id, event_id FROM syncapi_relations" +
+	" WHERE room_id = $1 AND relates_to = $2 AND ($3 = '' OR rel_type = $3) AND ($4 = '' OR event_type = $4)" +
+	" AND id > $5 AND id <= $6" +
+	" ORDER BY id DESC LIMIT $7"
+
+// The latest event in each group is joined on afterwards, as there's no
+// portable way to select it alongside the aggregates.
+const selectRelationGroupsSQL = "" +
+	"SELECT g.relates_to, g.rel_type, g.event_type, g.aggregation_key, g.subgroup," +
+	" g.count, g.user_count, latest.event_id, g.latest_id FROM (" +
+	"  SELECT relates_to, rel_type, event_type, aggregation_key," +
+	"  CASE rel_type WHEN 'm.replace' THEN sender WHEN 'm.reference' THEN event_id ELSE '' END AS subgroup," +
+	"  COUNT(*) AS count, COUNT(CASE WHEN sender = $2 THEN 1 END) AS user_count," +
+	"  MAX(id) AS latest_id, MIN(id) AS first_id" +
+	"  FROM syncapi_relations WHERE relates_to = ANY($1)" +
+	"  GROUP BY relates_to, rel_type, event_type, aggregation_key, subgroup" +
+	" ) AS g JOIN syncapi_relations AS latest ON latest.id = g.latest_id" +
+	" ORDER BY g.count DESC, g.first_id ASC"
+
+type relationsStatements struct {
+	insertRelationStmt             *sql.Stmt
+	deleteRelationStmt             *sql.Stmt
+	deleteRelationsForRoomStmt     *sql.Stmt
+	selectRelationsInRangeAscStmt  *sql.Stmt
+	selectRelationsInRangeDescStmt *sql.Stmt
+	selectRelationGroupsStmt       *sql.Stmt
+}
+
+func NewPostgresRelationsTable(db *sql.DB) (tables.Relations, error) {
+	_, err := db.Exec(relationsSchema)
+	if err != nil {
+		return nil, err
+	}
+	s := &relationsStatements{}
+	return s, sqlutil.StatementList{
+		{&s.insertRelationStmt, insertRelationSQL},
+		{&s.deleteRelationStmt, deleteRelationSQL},
+		{&s.deleteRelationsForRoomStmt, deleteRelationsForRoomSQL},
+		{&s.selectRelationsInRangeAscStmt, selectRelationsInRangeAscSQL},
+		{&s.selectRelationsInRangeDescStmt, selectRelationsInRangeDescSQL},
+		{&s.selectRelationGroupsStmt, selectRelationGroupsSQL},
+	}.Prepare(db)
+}
+
+func (s *relationsStatements) InsertRelation(
+	ctx context.Context, txn *sql.Tx, pos types.StreamPosition,
+	event *gomatrixserverlib.HeaderedEvent, relatesTo, relType, key string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertRelationStmt)
+	_, err := stmt.ExecContext(
+		ctx, pos, event.RoomID(), event.EventID(), event.Type(), event.Sender(), relatesTo, relType, key,
+	)
+	return err
+}
+
+func (s *relationsStatements) DeleteRelation(
+	ctx context.Context, txn *sql.Tx, eventID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteRelationStmt)
+	_, err := stmt.ExecContext(ctx, eventID)
+	return err
+}
+
+func (s *relationsStatements) DeleteRelationsForRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteRelationsForRoomStmt)
+	_, err := stmt.ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *relationsStatements) SelectRelationsInRange(
+	ctx context.Context, txn *sql.Tx, roomID, relatesTo, relType, eventType string,
+	r types.Range, limit int,
+) ([]types.RelationEntry, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRelationsInRangeAscStmt)
+	if r.Backwards {
+		stmt = sqlutil.TxStmt(txn, s.selectRelationsInRangeDescStmt)
+	}
+	rows, err := stmt.QueryContext(ctx, roomID, relatesTo, relType, eventType, r.Low(), r.High(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRelationsInRange: rows.close() failed")
+	var entries []types.RelationEntry
+	for rows.Next() {
+		var entry types.RelationEntry
+		if err = rows.Scan(&entry.StreamPos, &entry.EventID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *relationsStatements) SelectRelationGroups(
+	ctx context.Context, txn *sql.Tx, eventIDs []string, userID string,
+) ([]types.RelationGroup, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRelationGroupsStmt)
+	rows, err := stmt.QueryContext(ctx, pq.StringArray(eventIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRelationGroups: rows.close() failed")
+	var groups []types.RelationGroup
+	for rows.Next() {
+		var group types.RelationGroup
+		var subgroup string
+		if err = rows.Scan(
+			&group.RelatesTo, &group.RelType, &group.EventType, &group.Key, &subgroup,
+			&group.Count, &group.UserCount, &group.LatestEventID, &group.LatestPos,
+		); err != nil {
+			return nil, err
+		}
+		if group.RelType == types.RelationReplace {
+			group.Sender = subgroup
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
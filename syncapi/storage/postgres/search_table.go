@@ -0,0 +1,159 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const searchSchema = `
+-- Stores a full-text index of the searchable content of events.
+CREATE TABLE IF NOT EXISTS syncapi_search (
+	-- The stream position of the event
+	id BIGINT PRIMARY KEY,
+	event_id TEXT NOT NULL CONSTRAINT syncapi_search_event_id_idx UNIQUE,
+	room_id TEXT NOT NULL,
+	-- The sender and type of the event, so that searches can be filtered by them
+	sender TEXT NOT NULL,
+	type TEXT NOT NULL,
+	-- The content key that was indexed, e.g. content.body
+	key TEXT NOT NULL,
+	-- The indexed content
+	vector TSVECTOR NOT NULL
+);
+CREATE INDEX IF NOT EXISTS syncapi_search_vector_idx ON syncapi_search USING GIN (vector);
+CREATE INDEX IF NOT EXISTS syncapi_search_room_id_idx ON syncapi_search(room_id);
+`
+
+const insertSearchEventSQL = "" +
+	"INSERT INTO syncapi_search (id, event_id, room_id, sender, type, key, vector)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, to_tsvector('english', $7))" +
+	" ON CONFLICT ON CONSTRAINT syncapi_search_event_id_idx DO NOTHING"
+
+const deleteSearchEventSQL = "" +
+	"DELETE FROM syncapi_search WHERE event_id = $1"
+
+const deleteSearchEventsForRoomSQL = "" +
+	"DELETE FROM syncapi_search WHERE room_id = $1"
+
+const selectSearchByRankSQL = "" +
+	"SELECT id, event_id, room_id, ts_rank_cd(vector, query) AS rank, COUNT(*) OVER ()" +
+	" FROM syncapi_search, plainto_tsquery('english', $1) query" +
+	" WHERE vector @@ query AND room_id = ANY($2) AND key = ANY($3)" +
+	" AND ( $4::text[] IS NULL OR     sender  = ANY($4)  )" +
+	" AND ( $5::text[] IS NULL OR NOT(sender  = ANY($5)) )" +
+	" AND ( $6::text[] IS NULL OR     type LIKE ANY($6)  )" +
+	" AND ( $7::text[] IS NULL OR NOT(type LIKE ANY($7)) )" +
+	" ORDER BY rank DESC, id DESC LIMIT $8 OFFSET $9"
+
+const selectSearchByStreamPosSQL = "" +
+	"SELECT id, event_id, room_id, ts_rank_cd(vector, query) AS rank, COUNT(*) OVER ()" +
+	" FROM syncapi_search, plainto_tsquery('english', $1) query" +
+	" WHERE vector @@ query AND room_id = ANY($2) AND key = ANY($3)" +
+	" AND ( $4::text[] IS NULL OR     sender  = ANY($4)  )" +
+	" AND ( $5::text[] IS NULL OR NOT(sender  = ANY($5)) )" +
+	" AND ( $6::text[] IS NULL OR     type LIKE ANY($6)  )" +
+	" AND ( $7::text[] IS NULL OR NOT(type LIKE ANY($7)) )" +
+	" ORDER BY id DESC LIMIT $8 OFFSET $9"
+
+type searchStatements struct {
+	insertSearchEventStmt         *sql.Stmt
+	deleteSearchEventStmt         *sql.Stmt
+	deleteSearchEventsForRoomStmt *sql.Stmt
+	selectSearchByRankStmt        *sql.Stmt
+	selectSearchByStreamPosStmt   *sql.Stmt
+}
+
+func NewPostgresSearchTable(db *sql.DB) (tables.Search, error) {
+	_, err := db.Exec(searchSchema)
+	if err != nil {
+		return nil, err
+	}
+	s := &searchStatements{}
+	return s, sqlutil.StatementList{
+		{&s.insertSearchEventStmt, insertSearchEventSQL},
+		{&s.deleteSearchEventStmt, deleteSearchEventSQL},
+		{&s.deleteSearchEventsForRoomStmt, deleteSearchEventsForRoomSQL},
+		{&s.selectSearchByRankStmt, selectSearchByRankSQL},
+		{&s.selectSearchByStreamPosStmt, selectSearchByStreamPosSQL},
+	}.Prepare(db)
+}
+
+func (s *searchStatements) InsertSearchEvent(
+	ctx context.Context, txn *sql.Tx, pos types.StreamPosition,
+	event *gomatrixserverlib.HeaderedEvent, key, value string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertSearchEventStmt)
+	_, err := stmt.ExecContext(ctx, pos, event.EventID(), event.RoomID(), event.Sender(), event.Type(), key, value)
+	return err
+}
+
+func (s *searchStatements) DeleteSearchEvent(
+	ctx context.Context, txn *sql.Tx, eventID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSearchEventStmt)
+	_, err := stmt.ExecContext(ctx, eventID)
+	return err
+}
+
+func (s *searchStatements) DeleteSearchEventsForRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSearchEventsForRoomStmt)
+	_, err := stmt.ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *searchStatements) SelectSearch(
+	ctx context.Context, txn *sql.Tx, searchTerm string, roomIDs, keys []string,
+	filter *gomatrixserverlib.RoomEventFilter, orderByStreamPos bool, limit, offset int,
+) ([]types.SearchResult, int, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectSearchByRankStmt)
+	if orderByStreamPos {
+		stmt = sqlutil.TxStmt(txn, s.selectSearchByStreamPosStmt)
+	}
+	rows, err := stmt.QueryContext(
+		ctx, searchTerm, pq.StringArray(roomIDs), pq.StringArray(keys),
+		pq.StringArray(filter.Senders),
+		pq.StringArray(filter.NotSenders),
+		pq.StringArray(filterConvertTypeWildcardToSQL(filter.Types)),
+		pq.StringArray(filterConvertTypeWildcardToSQL(filter.NotTypes)),
+		limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to query search index: %w", err)
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectSearch: rows.close() failed")
+
+	var results []types.SearchResult
+	var count int
+	for rows.Next() {
+		var result types.SearchResult
+		if err = rows.Scan(&result.StreamPos, &result.EventID, &result.RoomID, &result.Rank, &count); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, result)
+	}
+	return results, count, rows.Err()
+}
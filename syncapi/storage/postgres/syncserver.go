@@ -62,6 +62,10 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*SyncServerDatasource, e
 	if err != nil {
 		return nil, err
 	}
+	knocks, err := NewPostgresKnocksTable(d.db)
+	if err != nil {
+		return nil, err
+	}
 	peeks, err := NewPostgresPeeksTable(d.db)
 	if err != nil {
 		return nil, err
@@ -86,13 +90,30 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*SyncServerDatasource, e
 	if err != nil {
 		return nil, err
 	}
+	notificationData, err := NewPostgresNotificationDataTable(d.db)
+	if err != nil {
+		return nil, err
+	}
 	memberships, err := NewPostgresMembershipsTable(d.db)
 	if err != nil {
 		return nil, err
 	}
+	presence, err := NewPostgresPresenceTable(d.db)
+	if err != nil {
+		return nil, err
+	}
+	search, err := NewPostgresSearchTable(d.db)
+	if err != nil {
+		return nil, err
+	}
+	relations, err := NewPostgresRelationsTable(d.db)
+	if err != nil {
+		return nil, err
+	}
 	m := sqlutil.NewMigrations()
 	deltas.LoadFixSequences(m)
 	deltas.LoadRemoveSendToDeviceSentColumn(m)
+	deltas.LoadMembershipHistory(m)
 	if err = m.RunDeltas(d.db, dbProperties); err != nil {
 		return nil, err
 	}
@@ -100,6 +121,7 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*SyncServerDatasource, e
 		DB:                  d.db,
 		Writer:              d.writer,
 		Invites:             invites,
+		Knocks:              knocks,
 		Peeks:               peeks,
 		AccountData:         accountData,
 		OutputEvents:        events,
@@ -110,6 +132,10 @@ func NewDatabase(dbProperties *config.DatabaseOptions) (*SyncServerDatasource, e
 		SendToDevice:        sendToDevice,
 		Receipts:            receipts,
 		Memberships:         memberships,
+		NotificationData:    notificationData,
+		Presence:            presence,
+		Search:              search,
+		Relations:           relations,
 	}
 	return &d, nil
 }
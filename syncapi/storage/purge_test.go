@@ -0,0 +1,75 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestPurgeRoom(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	purged := test.NewRoom(t, alice)
+	message := purged.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": "purge me"})
+	purged.CreateAndInsert(t, alice, "m.reaction", map[string]interface{}{
+		"m.relates_to": map[string]interface{}{"rel_type": types.RelationAnnotation, "event_id": message.EventID(), "key": "👍"},
+	})
+	kept := test.NewRoom(t, alice)
+	kept.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": "keep me"})
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db := mustCreateDatabase(t, dbType)
+		mustWriteEvents(t, db, purged.Events())
+		mustWriteEvents(t, db, kept.Events())
+
+		if err := db.PurgeRoom(ctx, purged.ID); err != nil {
+			t.Fatalf("PurgeRoom failed: %s", err)
+		}
+
+		for _, tc := range []struct {
+			room       *test.Room
+			wantExists bool
+		}{
+			{room: purged, wantExists: false},
+			{room: kept, wantExists: true},
+		} {
+			eventIDs := make([]string, 0, len(tc.room.Events()))
+			for _, ev := range tc.room.Events() {
+				eventIDs = append(eventIDs, ev.EventID())
+			}
+			events, err := db.Events(ctx, eventIDs)
+			if err != nil {
+				t.Fatalf("Events failed: %s", err)
+			}
+			if wantCount := map[bool]int{true: len(eventIDs)}[tc.wantExists]; len(events) != wantCount {
+				t.Errorf("got %d events in %s, want %d", len(events), tc.room.ID, wantCount)
+			}
+			stateFilter := gomatrixserverlib.DefaultStateFilter()
+			state, err := db.GetStateEventsForRoom(ctx, tc.room.ID, &stateFilter)
+			if err != nil {
+				t.Fatalf("GetStateEventsForRoom failed: %s", err)
+			}
+			if (len(state) > 0) != tc.wantExists {
+				t.Errorf("got %d state events in %s, want exists=%v", len(state), tc.room.ID, tc.wantExists)
+			}
+			_, count, err := db.SearchEvents(ctx, "me", []string{tc.room.ID}, []string{"content.body"}, &gomatrixserverlib.RoomEventFilter{}, true, 10, 0)
+			if err != nil {
+				t.Fatalf("SearchEvents failed: %s", err)
+			}
+			if (count > 0) != tc.wantExists {
+				t.Errorf("got %d search results in %s, want exists=%v", count, tc.room.ID, tc.wantExists)
+			}
+		}
+
+		aggregations, err := db.RelationAggregations(ctx, alice, []*gomatrixserverlib.HeaderedEvent{message})
+		if err != nil {
+			t.Fatalf("RelationAggregations failed: %s", err)
+		}
+		if len(aggregations) != 0 {
+			t.Errorf("got aggregations %+v for a purged room", aggregations)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package storage_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func mustCreateDatabase(t *testing.T, dbType test.DBType) storage.Database {
+	t.Helper()
+	db, err := storage.NewSyncServerDatasource(&config.DatabaseOptions{
+		ConnectionString:   test.PrepareDBConnectionString(t, dbType),
+		MaxOpenConnections: 1,
+		MaxIdleConnections: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	return db
+}
+
+// mustWriteEvents writes the events to the database as the roomserver would
+// send them, with each state event updating the current state.
+func mustWriteEvents(t *testing.T, db storage.Database, events []*gomatrixserverlib.HeaderedEvent) {
+	t.Helper()
+	for _, ev := range events {
+		var addStateEvents []*gomatrixserverlib.HeaderedEvent
+		var addStateEventIDs []string
+		if ev.StateKey() != nil {
+			addStateEvents = append(addStateEvents, ev)
+			addStateEventIDs = append(addStateEventIDs, ev.EventID())
+		}
+		if _, err := db.WriteEvent(context.Background(), ev, addStateEvents, addStateEventIDs, nil, nil, false); err != nil {
+			t.Fatalf("WriteEvent failed: %s", err)
+		}
+	}
+}
+
+func TestRelationAggregations(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, carol := "@alice:test", "@bob:test", "@carol:test"
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, bob)
+	root := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": "hello"})
+	unrelated := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": "world"})
+
+	relation := func(relType string, extra map[string]interface{}) map[string]interface{} {
+		relatesTo := map[string]interface{}{"rel_type": relType, "event_id": root.EventID()}
+		for k, v := range extra {
+			relatesTo[k] = v
+		}
+		return map[string]interface{}{"m.relates_to": relatesTo}
+	}
+	react := func(sender, key string) *gomatrixserverlib.HeaderedEvent {
+		return room.CreateAndInsert(t, sender, "m.reaction", relation(types.RelationAnnotation, map[string]interface{}{"key": key}))
+	}
+	message := func(sender, relType string) *gomatrixserverlib.HeaderedEvent {
+		content := relation(relType, nil)
+		content["msgtype"], content["body"] = "m.text", "hi"
+		return room.CreateAndInsert(t, sender, "m.room.message", content)
+	}
+
+	react(bob, "🎉")
+	react(bob, "👍")
+	react(alice, "👍")
+	firstRef := message(bob, types.RelationReference)
+	secondRef := message(alice, types.RelationReference)
+	message(alice, types.RelationReplace)
+	latestEdit := message(alice, types.RelationReplace)
+	// Edits by anyone other than the original sender don't count, even if
+	// they are newer.
+	message(bob, types.RelationReplace)
+	message(bob, types.RelationThread)
+	threadLatest := message(bob, types.RelationThread)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db := mustCreateDatabase(t, dbType)
+		mustWriteEvents(t, db, room.Events())
+
+		for _, tc := range []struct {
+			userID           string
+			wantParticipated bool
+		}{
+			// Alice sent the thread root, and Bob replied in the thread.
+			{userID: alice, wantParticipated: true},
+			{userID: bob, wantParticipated: true},
+			{userID: carol, wantParticipated: false},
+		} {
+			tc := tc
+			t.Run(tc.userID, func(t *testing.T) {
+				aggregations, err := db.RelationAggregations(ctx, tc.userID, []*gomatrixserverlib.HeaderedEvent{root, unrelated})
+				if err != nil {
+					t.Fatalf("RelationAggregations failed: %s", err)
+				}
+				if _, ok := aggregations[unrelated.EventID()]; ok {
+					t.Errorf("got aggregations for an event without relations")
+				}
+				agg, ok := aggregations[root.EventID()]
+				if !ok {
+					t.Fatalf("got no aggregations for the related event")
+				}
+
+				wantAnnotations := []types.AnnotationCount{
+					{Type: "m.reaction", Key: "👍", Count: 2},
+					{Type: "m.reaction", Key: "🎉", Count: 1},
+				}
+				if !reflect.DeepEqual(agg.Annotations, wantAnnotations) {
+					t.Errorf("got annotations %+v, want %+v", agg.Annotations, wantAnnotations)
+				}
+				wantReferences := []string{firstRef.EventID(), secondRef.EventID()}
+				if !reflect.DeepEqual(agg.References, wantReferences) {
+					t.Errorf("got references %v, want %v", agg.References, wantReferences)
+				}
+				if agg.Replace == nil || agg.Replace.EventID() != latestEdit.EventID() {
+					t.Errorf("got replacement %v, want %s", agg.Replace, latestEdit.EventID())
+				}
+				if agg.ThreadCount != 2 {
+					t.Errorf("got thread count %d, want 2", agg.ThreadCount)
+				}
+				if agg.ThreadLatest == nil || agg.ThreadLatest.EventID() != threadLatest.EventID() {
+					t.Errorf("got latest thread event %v, want %s", agg.ThreadLatest, threadLatest.EventID())
+				}
+				if agg.ThreadCurrentUserParticipated != tc.wantParticipated {
+					t.Errorf("got current user participated %v, want %v", agg.ThreadCurrentUserParticipated, tc.wantParticipated)
+				}
+			})
+		}
+	})
+}
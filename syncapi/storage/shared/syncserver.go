@@ -19,6 +19,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
@@ -30,6 +31,7 @@ import (
 	"github.com/matrix-org/dendrite/syncapi/types"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
 )
 
 // Database is a temporary struct until we have made syncserver.go the same for both pq/sqlite
@@ -38,6 +40,7 @@ type Database struct {
 	DB                  *sql.DB
 	Writer              sqlutil.Writer
 	Invites             tables.Invites
+	Knocks              tables.Knocks
 	Peeks               tables.Peeks
 	AccountData         tables.AccountData
 	OutputEvents        tables.Events
@@ -48,6 +51,10 @@ type Database struct {
 	Filter              tables.Filter
 	Receipts            tables.Receipts
 	Memberships         tables.Memberships
+	NotificationData    tables.NotificationData
+	Presence            tables.Presence
+	Search              tables.Search
+	Relations           tables.Relations
 }
 
 func (d *Database) readOnlySnapshot(ctx context.Context) (*sql.Tx, error) {
@@ -83,6 +90,14 @@ func (d *Database) MaxStreamPositionForInvites(ctx context.Context) (types.Strea
 	if err != nil {
 		return 0, fmt.Errorf("d.Invites.SelectMaxInviteID: %w", err)
 	}
+	// Knocks are sent down the invite stream too.
+	knockID, err := d.Knocks.SelectMaxKnockID(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.Knocks.SelectMaxKnockID: %w", err)
+	}
+	if knockID > id {
+		id = knockID
+	}
 	return types.StreamPosition(id), nil
 }
 
@@ -102,6 +117,22 @@ func (d *Database) MaxStreamPositionForAccountData(ctx context.Context) (types.S
 	return types.StreamPosition(id), nil
 }
 
+func (d *Database) MaxStreamPositionForNotificationData(ctx context.Context) (types.StreamPosition, error) {
+	id, err := d.NotificationData.SelectMaxID(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.NotificationData.SelectMaxID: %w", err)
+	}
+	return types.StreamPosition(id), nil
+}
+
+func (d *Database) MaxStreamPositionForPresence(ctx context.Context) (types.StreamPosition, error) {
+	id, err := d.Presence.SelectMaxPresenceID(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("d.Presence.SelectMaxPresenceID: %w", err)
+	}
+	return types.StreamPosition(id), nil
+}
+
 func (d *Database) CurrentState(ctx context.Context, roomID string, stateFilterPart *gomatrixserverlib.StateFilter, excludeEventIDs []string) ([]*gomatrixserverlib.HeaderedEvent, error) {
 	return d.CurrentRoomState.SelectCurrentState(ctx, nil, roomID, stateFilterPart, excludeEventIDs)
 }
@@ -122,6 +153,10 @@ func (d *Database) InviteEventsInRange(ctx context.Context, targetUserID string,
 	return d.Invites.SelectInviteEventsInRange(ctx, nil, targetUserID, r)
 }
 
+func (d *Database) KnockEventsInRange(ctx context.Context, targetUserID string, r types.Range) (map[string]*gomatrixserverlib.HeaderedEvent, map[string]*gomatrixserverlib.HeaderedEvent, error) {
+	return d.Knocks.SelectKnockEventsInRange(ctx, nil, targetUserID, r)
+}
+
 func (d *Database) PeeksInRange(ctx context.Context, userID, deviceID string, r types.Range) (peeks []types.Peek, err error) {
 	return d.Peeks.SelectPeeksInRange(ctx, nil, userID, deviceID, r)
 }
@@ -146,6 +181,22 @@ func (d *Database) Events(ctx context.Context, eventIDs []string) ([]*gomatrixse
 	return d.StreamEventsToEvents(nil, streamEvents), nil
 }
 
+func (d *Database) MembershipChanges(ctx context.Context, roomID, userID string) ([]types.MembershipChange, error) {
+	return d.Memberships.SelectMembershipChanges(ctx, nil, roomID, userID)
+}
+
+func (d *Database) HistoryVisibilityEvents(ctx context.Context, roomID string) ([]types.StreamEvent, error) {
+	return d.OutputEvents.SelectEventsOfType(ctx, nil, roomID, gomatrixserverlib.MRoomHistoryVisibility)
+}
+
+func (d *Database) MaxStreamPositionsForRooms(ctx context.Context, roomIDs []string) (map[string]types.StreamPosition, error) {
+	return d.OutputEvents.SelectMaxEventIDsForRooms(ctx, nil, roomIDs)
+}
+
+func (d *Database) StateEventsOfType(ctx context.Context, roomIDs []string, eventType string) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	return d.CurrentRoomState.SelectStateEventsOfType(ctx, nil, roomIDs, eventType)
+}
+
 // GetEventsInStreamingRange retrieves all of the events on a given ordering using the
 // given extremities and limit.
 func (d *Database) GetEventsInStreamingRange(
@@ -223,6 +274,31 @@ func (d *Database) RetireInviteEvent(
 	return
 }
 
+// AddKnockEvent stores a new pending knock for a user, replacing any
+// older knock by the same user in the same room.
+// Returns the stream ID that the knock was stored at.
+func (d *Database) AddKnockEvent(
+	ctx context.Context, knockEvent *gomatrixserverlib.HeaderedEvent,
+) (sp types.StreamPosition, err error) {
+	_ = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		sp, err = d.Knocks.UpsertKnockEvent(ctx, txn, knockEvent)
+		return err
+	})
+	return
+}
+
+// RetireKnockEvent marks the pending knock of a user in a room as retired.
+// Returns a zero stream position if the user had no pending knock.
+func (d *Database) RetireKnockEvent(
+	ctx context.Context, roomID, userID string,
+) (sp types.StreamPosition, err error) {
+	_ = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		sp, err = d.Knocks.RetireKnockEvent(ctx, txn, roomID, userID)
+		return err
+	})
+	return
+}
+
 // AddPeek tracks the fact that a user has started peeking.
 // If the peek was successfully stored this returns the stream ID it was stored at.
 // Returns an error if there was a problem communicating with the database.
@@ -367,6 +443,35 @@ func (d *Database) PurgeRoomState(
 	})
 }
 
+func (d *Database) PurgeRoom(
+	ctx context.Context, roomID string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if err := d.OutputEvents.DeleteEventsForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.OutputEvents.DeleteEventsForRoom: %w", err)
+		}
+		if err := d.Topology.DeleteTopologyForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.Topology.DeleteTopologyForRoom: %w", err)
+		}
+		if err := d.CurrentRoomState.DeleteRoomStateForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.CurrentRoomState.DeleteRoomStateForRoom: %w", err)
+		}
+		if err := d.BackwardExtremities.DeleteBackwardExtremitiesForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.BackwardExtremities.DeleteBackwardExtremitiesForRoom: %w", err)
+		}
+		if err := d.Memberships.DeleteMembershipsForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.Memberships.DeleteMembershipsForRoom: %w", err)
+		}
+		if err := d.Search.DeleteSearchEventsForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.Search.DeleteSearchEventsForRoom: %w", err)
+		}
+		if err := d.Relations.DeleteRelationsForRoom(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("d.Relations.DeleteRelationsForRoom: %w", err)
+		}
+		return nil
+	})
+}
+
 func (d *Database) WriteEvent(
 	ctx context.Context,
 	ev *gomatrixserverlib.HeaderedEvent,
@@ -392,6 +497,14 @@ func (d *Database) WriteEvent(
 			return fmt.Errorf("d.handleBackwardExtremities: %w", err)
 		}
 
+		if err = d.indexEventForSearch(ctx, txn, ev, pos); err != nil {
+			return fmt.Errorf("d.indexEventForSearch: %w", err)
+		}
+
+		if err = d.indexEventRelation(ctx, txn, ev, pos); err != nil {
+			return fmt.Errorf("d.indexEventRelation: %w", err)
+		}
+
 		if len(addStateEvents) == 0 && len(removeStateEventIDs) == 0 {
 			// Nothing to do, the event may have just been a message event.
 			return nil
@@ -430,8 +543,8 @@ func (d *Database) updateRoomState(
 				return fmt.Errorf("event.Membership: %w", err)
 			}
 			membership = &value
-			if err = d.Memberships.UpsertMembership(ctx, txn, event, pduPosition, topoPosition); err != nil {
-				return fmt.Errorf("d.Memberships.UpsertMembership: %w", err)
+			if err = d.Memberships.InsertMembership(ctx, txn, event, pduPosition, topoPosition); err != nil {
+				return fmt.Errorf("d.Memberships.InsertMembership: %w", err)
 			}
 		}
 
@@ -462,6 +575,9 @@ func (d *Database) GetEventsInTopologicalRange(
 		// Forward ordering means the 'from' token has a lower depth than the 'to' token.
 		minDepth = from.Depth
 		maxDepth = to.Depth
+		// The 'to' token defaults to the position of the latest event in the
+		// room, so include the events up to and including that position.
+		maxStreamPosForMaxDepth = to.PDUPosition
 	}
 
 	// Select the event IDs from the defined range.
@@ -540,11 +656,199 @@ func (d *Database) RedactEvent(ctx context.Context, redactedEventID string, reda
 
 	newEvent := ev.Headered(redactedBecause.RoomVersion)
 	err = d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		if err := d.Search.DeleteSearchEvent(ctx, txn, redactedEventID); err != nil {
+			return fmt.Errorf("d.Search.DeleteSearchEvent: %w", err)
+		}
+		if err := d.Relations.DeleteRelation(ctx, txn, redactedEventID); err != nil {
+			return fmt.Errorf("d.Relations.DeleteRelation: %w", err)
+		}
 		return d.OutputEvents.UpdateEventJSON(ctx, newEvent)
 	})
 	return err
 }
 
+// searchKeys maps the types of events that are indexed for search to the
+// key in their content that is indexed.
+var searchKeys = map[string]string{
+	"m.room.message":             "content.body",
+	gomatrixserverlib.MRoomName:  "content.name",
+	gomatrixserverlib.MRoomTopic: "content.topic",
+}
+
+// indexEventForSearch adds the searchable content of the event, if it has
+// any, to the search index.
+// This function should always be called within a sqlutil.Writer for safety in SQLite.
+func (d *Database) indexEventForSearch(
+	ctx context.Context, txn *sql.Tx, ev *gomatrixserverlib.HeaderedEvent, pos types.StreamPosition,
+) error {
+	key, ok := searchKeys[ev.Type()]
+	if !ok {
+		return nil
+	}
+	value := gjson.GetBytes(ev.Content(), strings.TrimPrefix(key, "content.")).Str
+	if value == "" {
+		return nil
+	}
+	return d.Search.InsertSearchEvent(ctx, txn, pos, ev, key, value)
+}
+
+// SearchEvents returns up to limit of the events in the given rooms whose
+// searchable content matches the search term and which pass the filter,
+// skipping the first offset of them, along with the total number of
+// matching events.
+func (d *Database) SearchEvents(
+	ctx context.Context, searchTerm string, roomIDs, keys []string,
+	filter *gomatrixserverlib.RoomEventFilter, orderByStreamPos bool, limit, offset int,
+) ([]types.SearchResult, int, error) {
+	return d.Search.SelectSearch(ctx, nil, searchTerm, roomIDs, keys, filter, orderByStreamPos, limit, offset)
+}
+
+// indexEventRelation records the event that the m.relates_to of the event
+// refers to, if it has one.
+// This function should always be called within a sqlutil.Writer for safety in SQLite.
+func (d *Database) indexEventRelation(
+	ctx context.Context, txn *sql.Tx, ev *gomatrixserverlib.HeaderedEvent, pos types.StreamPosition,
+) error {
+	relatesTo := gjson.GetBytes(ev.Content(), `m\.relates_to`)
+	relType, eventID := relatesTo.Get("rel_type").Str, relatesTo.Get("event_id").Str
+	if relType == "" || eventID == "" {
+		return nil
+	}
+	var key string
+	if relType == types.RelationAnnotation {
+		key = relatesTo.Get("key").Str
+	}
+	return d.Relations.InsertRelation(ctx, txn, pos, ev, eventID, relType, key)
+}
+
+// RelationsForEvent returns up to limit of the events in the range that relate
+// to the given event, optionally only those with the given rel_type and event
+// type, in the direction of the range.
+func (d *Database) RelationsForEvent(
+	ctx context.Context, roomID, eventID, relType, eventType string, r types.Range, limit int,
+) ([]types.StreamEvent, error) {
+	entries, err := d.Relations.SelectRelationsInRange(ctx, nil, roomID, eventID, relType, eventType, r, limit)
+	if err != nil {
+		return nil, fmt.Errorf("d.Relations.SelectRelationsInRange: %w", err)
+	}
+	eventIDs := make([]string, len(entries))
+	for i := range entries {
+		eventIDs[i] = entries[i].EventID
+	}
+	streamEvents, err := d.OutputEvents.SelectEvents(ctx, nil, eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("d.OutputEvents.SelectEvents: %w", err)
+	}
+	eventsByID := make(map[string]types.StreamEvent, len(streamEvents))
+	for _, ev := range streamEvents {
+		eventsByID[ev.EventID()] = ev
+	}
+	events := make([]types.StreamEvent, 0, len(entries))
+	for _, entry := range entries {
+		if ev, ok := eventsByID[entry.EventID]; ok {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// RelationAggregations returns the aggregated relations to those of the given
+// events that have any, as seen by the given user.
+func (d *Database) RelationAggregations(
+	ctx context.Context, userID string, events []*gomatrixserverlib.HeaderedEvent,
+) (map[string]*types.RelationAggregations, error) {
+	eventIDs := make([]string, len(events))
+	for i := range events {
+		eventIDs[i] = events[i].EventID()
+	}
+	groups, err := d.Relations.SelectRelationGroups(ctx, nil, eventIDs, userID)
+	if err != nil {
+		return nil, fmt.Errorf("d.Relations.SelectRelationGroups: %w", err)
+	}
+	aggregations := make(map[string]*types.RelationAggregations)
+	if len(groups) == 0 {
+		return aggregations, nil
+	}
+	eventsByID := make(map[string]*gomatrixserverlib.HeaderedEvent, len(events))
+	for _, ev := range events {
+		eventsByID[ev.EventID()] = ev
+	}
+
+	// The latest edits and thread events are looked up all at once after
+	// aggregating, so remember which event they belong to.
+	replacePos := map[string]types.StreamPosition{}
+	threadPos := map[string]types.StreamPosition{}
+	replaceIDs := map[string]string{}
+	threadIDs := map[string]string{}
+	for _, group := range groups {
+		ev, ok := eventsByID[group.RelatesTo]
+		if !ok {
+			continue
+		}
+		agg, ok := aggregations[group.RelatesTo]
+		if !ok {
+			agg = &types.RelationAggregations{}
+			aggregations[group.RelatesTo] = agg
+		}
+		switch group.RelType {
+		case types.RelationAnnotation:
+			agg.Annotations = append(agg.Annotations, types.AnnotationCount{
+				Type:  group.EventType,
+				Key:   group.Key,
+				Count: group.Count,
+			})
+		case types.RelationReference:
+			agg.References = append(agg.References, group.LatestEventID)
+		case types.RelationReplace:
+			// Only edits made by the original sender are valid.
+			if group.Sender == ev.Sender() && group.LatestPos > replacePos[group.RelatesTo] {
+				replacePos[group.RelatesTo] = group.LatestPos
+				replaceIDs[group.RelatesTo] = group.LatestEventID
+			}
+		case types.RelationThread:
+			agg.ThreadCount += group.Count
+			agg.ThreadCurrentUserParticipated = agg.ThreadCurrentUserParticipated ||
+				group.UserCount > 0 || ev.Sender() == userID
+			if group.LatestPos > threadPos[group.RelatesTo] {
+				threadPos[group.RelatesTo] = group.LatestPos
+				threadIDs[group.RelatesTo] = group.LatestEventID
+			}
+		}
+	}
+	replacedBy := make(map[string]string, len(replaceIDs))
+	for eventID, replaceID := range replaceIDs {
+		replacedBy[replaceID] = eventID
+	}
+	threadLatest := make(map[string]string, len(threadIDs))
+	for eventID, latestID := range threadIDs {
+		threadLatest[latestID] = eventID
+	}
+
+	latestIDs := make([]string, 0, len(replacedBy)+len(threadLatest))
+	for eventID := range replacedBy {
+		latestIDs = append(latestIDs, eventID)
+	}
+	for eventID := range threadLatest {
+		latestIDs = append(latestIDs, eventID)
+	}
+	if len(latestIDs) == 0 {
+		return aggregations, nil
+	}
+	latestEvents, err := d.Events(ctx, latestIDs)
+	if err != nil {
+		return nil, fmt.Errorf("d.Events: %w", err)
+	}
+	for _, ev := range latestEvents {
+		if eventID, ok := replacedBy[ev.EventID()]; ok {
+			aggregations[eventID].Replace = ev
+		}
+		if eventID, ok := threadLatest[ev.EventID()]; ok {
+			aggregations[eventID].ThreadLatest = ev
+		}
+	}
+	return aggregations, nil
+}
+
 // Retrieve the backward topology position, i.e. the position of the
 // oldest event in the room's topology.
 func (d *Database) GetBackwardTopologyPos(
@@ -955,3 +1259,39 @@ func (d *Database) GetRoomReceipts(ctx context.Context, roomIDs []string, stream
 	_, receipts, err := d.Receipts.SelectRoomReceiptsAfter(ctx, roomIDs, streamPos)
 	return receipts, err
 }
+
+// UpsertRoomUnreadNotificationCounts stores the unread notification
+// counts of a user in a room
+func (d *Database) UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		pos, err = d.NotificationData.UpsertRoomUnreadCounts(ctx, txn, userID, roomID, notificationCount, highlightCount)
+		return err
+	})
+	return
+}
+
+// GetUserUnreadNotificationCounts returns the unread notification counts
+// of the user in the rooms where they changed between the two positions
+func (d *Database) GetUserUnreadNotificationCounts(ctx context.Context, userID string, from, to types.StreamPosition) (map[string]*eventutil.NotificationData, error) {
+	return d.NotificationData.SelectUserUnreadCounts(ctx, nil, userID, from, to)
+}
+
+// UpsertPresence replaces the presence of a user
+func (d *Database) UpsertPresence(ctx context.Context, userID string, presence eduAPI.Presence, statusMsg *string, lastActiveTS gomatrixserverlib.Timestamp) (pos types.StreamPosition, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		pos, err = d.Presence.UpsertPresence(ctx, txn, userID, presence, statusMsg, lastActiveTS)
+		return err
+	})
+	return
+}
+
+// GetPresence returns the presence of a user, or nil if it isn't known
+func (d *Database) GetPresence(ctx context.Context, userID string) (*types.PresenceInternal, error) {
+	return d.Presence.SelectPresenceForUser(ctx, nil, userID)
+}
+
+// GetPresenceAfter returns the presence of the users whose presence
+// changed between the two positions
+func (d *Database) GetPresenceAfter(ctx context.Context, from, to types.StreamPosition) ([]*types.PresenceInternal, error) {
+	return d.Presence.SelectPresenceAfter(ctx, nil, from, to)
+}
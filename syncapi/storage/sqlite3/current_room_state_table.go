@@ -61,7 +61,7 @@ const deleteRoomStateByEventIDSQL = "" +
 	"DELETE FROM syncapi_current_room_state WHERE event_id = $1"
 
 const DeleteRoomStateForRoomSQL = "" +
-	"DELETE FROM syncapi_current_room_state WHERE event_id = $1"
+	"DELETE FROM syncapi_current_room_state WHERE room_id = $1"
 
 const selectRoomIDsWithMembershipSQL = "" +
 	"SELECT DISTINCT room_id FROM syncapi_current_room_state WHERE type = 'm.room.member' AND state_key = $1 AND membership = $2"
@@ -76,6 +76,10 @@ const selectJoinedUsersSQL = "" +
 const selectStateEventSQL = "" +
 	"SELECT headered_event_json FROM syncapi_current_room_state WHERE room_id = $1 AND type = $2 AND state_key = $3"
 
+const selectStateEventsOfTypeSQL = "" +
+	"SELECT event_id, headered_event_json FROM syncapi_current_room_state" +
+	" WHERE type = $1 AND state_key = '' AND room_id IN ($2)"
+
 const selectEventsWithEventIDsSQL = "" +
 	// TODO: The session_id and transaction_id blanks are here because otherwise
 	// the rowsToStreamEvents expects there to be exactly six columns. We need to
@@ -322,3 +326,39 @@ func (s *currentRoomStateStatements) SelectStateEvent(
 	}
 	return &ev, err
 }
+
+// SelectStateEventsOfType returns the current state events of the given type
+// with an empty state key in the given rooms.
+func (s *currentRoomStateStatements) SelectStateEventsOfType(
+	ctx context.Context, txn *sql.Tx, roomIDs []string, eventType string,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	result := []*gomatrixserverlib.HeaderedEvent{}
+	var start int
+	for start < len(roomIDs) {
+		n := minOfInts(len(roomIDs)-start, sqlutil.SQLite3MaxVariables-1)
+		query := strings.Replace(selectStateEventsOfTypeSQL, "($2)", sqlutil.QueryVariadicOffset(n, 1), 1)
+		params := make([]interface{}, 0, n+1)
+		params = append(params, eventType)
+		for _, roomID := range roomIDs[start : start+n] {
+			params = append(params, roomID)
+		}
+		var rows *sql.Rows
+		var err error
+		if txn != nil {
+			rows, err = txn.QueryContext(ctx, query, params...)
+		} else {
+			rows, err = s.db.QueryContext(ctx, query, params...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		start = start + n
+		events, err := rowsToEvents(rows)
+		internal.CloseAndLogIfError(ctx, rows, "selectStateEventsOfType: rows.close() failed")
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, events...)
+	}
+	return result, nil
+}
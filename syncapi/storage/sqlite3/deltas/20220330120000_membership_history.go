@@ -0,0 +1,132 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadMembershipHistory(m *sqlutil.Migrations) {
+	m.AddMigration(UpMembershipHistory, DownMembershipHistory)
+}
+
+// UpMembershipHistory stops the memberships table from only keeping the latest
+// change of each kind, and fills in the membership changes that were lost from
+// the membership events that we already have.
+func UpMembershipHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE syncapi_memberships RENAME TO syncapi_memberships_tmp;
+		CREATE TABLE syncapi_memberships (
+			room_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			membership TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			stream_pos BIGINT NOT NULL,
+			topological_pos BIGINT NOT NULL
+		);
+		INSERT INTO syncapi_memberships (room_id, user_id, membership, event_id, stream_pos, topological_pos)
+		  SELECT room_id, user_id, membership, event_id, stream_pos, topological_pos FROM syncapi_memberships_tmp;
+		DROP TABLE syncapi_memberships_tmp;
+		CREATE UNIQUE INDEX IF NOT EXISTS syncapi_memberships_event_id_idx ON syncapi_memberships(event_id);
+		CREATE INDEX IF NOT EXISTS syncapi_memberships_room_user_idx ON syncapi_memberships(room_id, user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	if err = backfillMembershipHistory(tx); err != nil {
+		return fmt.Errorf("failed to backfill membership history: %w", err)
+	}
+	return nil
+}
+
+func backfillMembershipHistory(tx *sql.Tx) error {
+	type membershipRow struct {
+		roomID, userID, membership, eventID string
+		streamPos, topologicalPos           int64
+	}
+	rows, err := tx.Query(`
+		SELECT o.room_id, o.event_id, o.headered_event_json, o.id, t.topological_position
+		  FROM syncapi_output_room_events o
+		  JOIN syncapi_output_room_events_topology t ON t.event_id = o.event_id
+		  WHERE o.type = 'm.room.member'
+	`)
+	if err != nil {
+		return err
+	}
+	var memberships []membershipRow
+	for rows.Next() {
+		var row membershipRow
+		var eventJSON []byte
+		if err = rows.Scan(&row.roomID, &row.eventID, &eventJSON, &row.streamPos, &row.topologicalPos); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		var event struct {
+			StateKey *string `json:"state_key"`
+			Content  struct {
+				Membership string `json:"membership"`
+			} `json:"content"`
+		}
+		if err = json.Unmarshal(eventJSON, &event); err != nil || event.StateKey == nil || event.Content.Membership == "" {
+			continue
+		}
+		row.userID, row.membership = *event.StateKey, event.Content.Membership
+		memberships = append(memberships, row)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	for _, row := range memberships {
+		if _, err = tx.Exec(`
+			INSERT INTO syncapi_memberships (room_id, user_id, membership, event_id, stream_pos, topological_pos)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  ON CONFLICT (event_id) DO NOTHING
+		`, row.roomID, row.userID, row.membership, row.eventID, row.streamPos, row.topologicalPos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DownMembershipHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DELETE FROM syncapi_memberships WHERE EXISTS (
+		  SELECT 1 FROM syncapi_memberships b
+		    WHERE b.room_id = syncapi_memberships.room_id AND b.user_id = syncapi_memberships.user_id
+		      AND b.membership = syncapi_memberships.membership AND b.stream_pos > syncapi_memberships.stream_pos
+		);
+		ALTER TABLE syncapi_memberships RENAME TO syncapi_memberships_tmp;
+		CREATE TABLE syncapi_memberships (
+			room_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			membership TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			stream_pos BIGINT NOT NULL,
+			topological_pos BIGINT NOT NULL,
+			UNIQUE (room_id, user_id, membership)
+		);
+		INSERT INTO syncapi_memberships (room_id, user_id, membership, event_id, stream_pos, topological_pos)
+		  SELECT room_id, user_id, membership, event_id, stream_pos, topological_pos FROM syncapi_memberships_tmp;
+		DROP TABLE syncapi_memberships_tmp;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
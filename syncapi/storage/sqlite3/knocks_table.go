@@ -0,0 +1,183 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const knockEventsSchema = `
+-- Stores the pending knocks of local users, so that they can be told about
+-- them in the knock section of /sync.
+CREATE TABLE IF NOT EXISTS syncapi_knock_events (
+	id INTEGER PRIMARY KEY,
+	event_id TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	target_user_id TEXT NOT NULL,
+	headered_event_json TEXT NOT NULL,
+	deleted BOOL NOT NULL,
+	UNIQUE (room_id, target_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS syncapi_knocks_target_user_id_idx ON syncapi_knock_events (target_user_id, id);
+`
+
+const upsertKnockEventSQL = "" +
+	"INSERT INTO syncapi_knock_events" +
+	" (id, room_id, event_id, target_user_id, headered_event_json, deleted)" +
+	" VALUES ($1, $2, $3, $4, $5, false)" +
+	" ON CONFLICT (room_id, target_user_id)" +
+	" DO UPDATE SET id = $1, event_id = $3, headered_event_json = $5, deleted = false"
+
+const retireKnockEventSQL = "" +
+	"UPDATE syncapi_knock_events SET deleted=true, id=$1" +
+	" WHERE room_id = $2 AND target_user_id = $3 AND NOT deleted"
+
+const selectKnockEventsInRangeSQL = "" +
+	"SELECT room_id, headered_event_json, deleted FROM syncapi_knock_events" +
+	" WHERE target_user_id = $1 AND id > $2 AND id <= $3" +
+	" ORDER BY id DESC"
+
+const selectMaxKnockIDSQL = "" +
+	"SELECT MAX(id) FROM syncapi_knock_events"
+
+type knockEventsStatements struct {
+	db                           *sql.DB
+	streamIDStatements           *streamIDStatements
+	upsertKnockEventStmt         *sql.Stmt
+	retireKnockEventStmt         *sql.Stmt
+	selectKnockEventsInRangeStmt *sql.Stmt
+	selectMaxKnockIDStmt         *sql.Stmt
+}
+
+func NewSqliteKnocksTable(db *sql.DB, streamID *streamIDStatements) (tables.Knocks, error) {
+	s := &knockEventsStatements{
+		db:                 db,
+		streamIDStatements: streamID,
+	}
+	_, err := db.Exec(knockEventsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertKnockEventStmt, upsertKnockEventSQL},
+		{&s.retireKnockEventStmt, retireKnockEventSQL},
+		{&s.selectKnockEventsInRangeStmt, selectKnockEventsInRangeSQL},
+		{&s.selectMaxKnockIDStmt, selectMaxKnockIDSQL},
+	}.Prepare(db)
+}
+
+func (s *knockEventsStatements) UpsertKnockEvent(
+	ctx context.Context, txn *sql.Tx, knockEvent *gomatrixserverlib.HeaderedEvent,
+) (streamPos types.StreamPosition, err error) {
+	// Knocks share the invite stream, since they are returned by the same
+	// stream provider.
+	streamPos, err = s.streamIDStatements.nextInviteID(ctx, txn)
+	if err != nil {
+		return
+	}
+
+	var headeredJSON []byte
+	headeredJSON, err = json.Marshal(knockEvent)
+	if err != nil {
+		return
+	}
+
+	stmt := sqlutil.TxStmt(txn, s.upsertKnockEventStmt)
+	_, err = stmt.ExecContext(
+		ctx,
+		streamPos,
+		knockEvent.RoomID(),
+		knockEvent.EventID(),
+		*knockEvent.StateKey(),
+		headeredJSON,
+	)
+	return
+}
+
+func (s *knockEventsStatements) RetireKnockEvent(
+	ctx context.Context, txn *sql.Tx, roomID, targetUserID string,
+) (types.StreamPosition, error) {
+	streamPos, err := s.streamIDStatements.nextInviteID(ctx, txn)
+	if err != nil {
+		return 0, err
+	}
+	stmt := sqlutil.TxStmt(txn, s.retireKnockEventStmt)
+	res, err := stmt.ExecContext(ctx, streamPos, roomID, targetUserID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// There was no pending knock to retire.
+		return 0, err
+	}
+	return streamPos, nil
+}
+
+// SelectKnockEventsInRange returns a map of room ID to knock event for the
+// pending and retired knocks for the target user ID in the supplied range.
+func (s *knockEventsStatements) SelectKnockEventsInRange(
+	ctx context.Context, txn *sql.Tx, targetUserID string, r types.Range,
+) (map[string]*gomatrixserverlib.HeaderedEvent, map[string]*gomatrixserverlib.HeaderedEvent, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectKnockEventsInRangeStmt)
+	rows, err := stmt.QueryContext(ctx, targetUserID, r.Low(), r.High())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectKnockEventsInRange: rows.close() failed")
+	result := map[string]*gomatrixserverlib.HeaderedEvent{}
+	retired := map[string]*gomatrixserverlib.HeaderedEvent{}
+	for rows.Next() {
+		var (
+			roomID    string
+			eventJSON []byte
+			deleted   bool
+		)
+		if err = rows.Scan(&roomID, &eventJSON, &deleted); err != nil {
+			return nil, nil, err
+		}
+
+		var event *gomatrixserverlib.HeaderedEvent
+		if err := json.Unmarshal(eventJSON, &event); err != nil {
+			return nil, nil, err
+		}
+		if deleted {
+			retired[roomID] = event
+		} else {
+			result[roomID] = event
+		}
+	}
+	return result, retired, rows.Err()
+}
+
+func (s *knockEventsStatements) SelectMaxKnockID(
+	ctx context.Context, txn *sql.Tx,
+) (id int64, err error) {
+	var nullableID sql.NullInt64
+	stmt := sqlutil.TxStmt(txn, s.selectMaxKnockIDStmt)
+	err = stmt.QueryRowContext(ctx).Scan(&nullableID)
+	if nullableID.Valid {
+		id = nullableID.Int64
+	}
+	return
+}
@@ -48,6 +48,8 @@ CREATE TABLE IF NOT EXISTS syncapi_output_room_events (
   transaction_id TEXT,
   exclude_from_sync BOOL NOT NULL DEFAULT FALSE
 );
+
+CREATE INDEX IF NOT EXISTS syncapi_output_room_events_type_idx ON syncapi_output_room_events(room_id, type);
 `
 
 const insertEventSQL = "" +
@@ -74,6 +76,11 @@ const selectEarlyEventsSQL = "" +
 	" WHERE room_id = $1 AND id > $2 AND id <= $3"
 	// WHEN, ORDER BY and LIMIT are appended by prepareWithFilters
 
+const selectEventsOfTypeSQL = "" +
+	"SELECT event_id, id, headered_event_json, session_id, exclude_from_sync, transaction_id FROM syncapi_output_room_events" +
+	" WHERE room_id = $1 AND type = $2" +
+	" ORDER BY id ASC"
+
 const selectMaxEventIDSQL = "" +
 	"SELECT MAX(id) FROM syncapi_output_room_events"
 
@@ -87,6 +94,11 @@ const selectStateInRangeSQL = "" +
 	" AND ((add_state_ids IS NOT NULL AND add_state_ids != '') OR (remove_state_ids IS NOT NULL AND remove_state_ids != ''))"
 	// WHEN, ORDER BY and LIMIT are appended by prepareWithFilters
 
+const selectMaxEventIDsForRoomsSQL = "" +
+	"SELECT room_id, MAX(id) FROM syncapi_output_room_events" +
+	" WHERE exclude_from_sync = FALSE AND room_id IN ($1)" +
+	" GROUP BY room_id"
+
 const deleteEventsForRoomSQL = "" +
 	"DELETE FROM syncapi_output_room_events WHERE room_id = $1"
 
@@ -95,6 +107,7 @@ type outputRoomEventsStatements struct {
 	streamIDStatements      *streamIDStatements
 	insertEventStmt         *sql.Stmt
 	selectEventsStmt        *sql.Stmt
+	selectEventsOfTypeStmt  *sql.Stmt
 	selectMaxEventIDStmt    *sql.Stmt
 	updateEventJSONStmt     *sql.Stmt
 	deleteEventsForRoomStmt *sql.Stmt
@@ -115,6 +128,9 @@ func NewSqliteEventsTable(db *sql.DB, streamID *streamIDStatements) (tables.Even
 	if s.selectEventsStmt, err = db.Prepare(selectEventsSQL); err != nil {
 		return nil, err
 	}
+	if s.selectEventsOfTypeStmt, err = db.Prepare(selectEventsOfTypeSQL); err != nil {
+		return nil, err
+	}
 	if s.selectMaxEventIDStmt, err = db.Prepare(selectMaxEventIDSQL); err != nil {
 		return nil, err
 	}
@@ -418,6 +434,50 @@ func (s *outputRoomEventsStatements) SelectEvents(
 	return returnEvents, nil
 }
 
+// SelectEventsOfType returns all of the events of the given type in the room,
+// ordered by stream position.
+func (s *outputRoomEventsStatements) SelectEventsOfType(
+	ctx context.Context, txn *sql.Tx, roomID, eventType string,
+) ([]types.StreamEvent, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectEventsOfTypeStmt).QueryContext(ctx, roomID, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventsOfType: rows.close() failed")
+	return rowsToStreamEvents(rows)
+}
+
+// SelectMaxEventIDsForRooms returns the stream position of the latest event
+// that is sent to clients in each of the given rooms, keyed by room ID.
+func (s *outputRoomEventsStatements) SelectMaxEventIDsForRooms(
+	ctx context.Context, txn *sql.Tx, roomIDs []string,
+) (map[string]types.StreamPosition, error) {
+	params := make([]interface{}, len(roomIDs))
+	for i, roomID := range roomIDs {
+		params[i] = roomID
+	}
+	var qp sqlutil.QueryProvider = s.db
+	if txn != nil {
+		qp = txn
+	}
+	result := make(map[string]types.StreamPosition, len(roomIDs))
+	err := sqlutil.RunLimitedVariablesQuery(
+		ctx, selectMaxEventIDsForRoomsSQL, qp, params, sqlutil.SQLite3MaxVariables,
+		func(rows *sql.Rows) error {
+			for rows.Next() {
+				var roomID string
+				var id types.StreamPosition
+				if err := rows.Scan(&roomID, &id); err != nil {
+					return err
+				}
+				result[roomID] = id
+			}
+			return rows.Err()
+		},
+	)
+	return result, err
+}
+
 func (s *outputRoomEventsStatements) DeleteEventsForRoom(
 	ctx context.Context, txn *sql.Tx, roomID string,
 ) (err error) {
@@ -0,0 +1,224 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// The docid of each row is the stream position of the event. Only the
+// content is tokenised, the other columns are just stored alongside it.
+// FTS4 has no ranking function, so the number of words in the content is
+// stored too, for ranking by the proportion of the words that were searched
+// for.
+const searchSchema = `
+-- Stores a full-text index of the searchable content of events.
+CREATE VIRTUAL TABLE IF NOT EXISTS syncapi_search USING fts4(
+	event_id, room_id, sender, type, key, words, value,
+	notindexed=event_id, notindexed=room_id, notindexed=sender,
+	notindexed=type, notindexed=key, notindexed=words,
+	tokenize=porter
+);
+`
+
+const insertSearchEventSQL = "" +
+	"INSERT OR REPLACE INTO syncapi_search (docid, event_id, room_id, sender, type, key, words, value)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+
+const deleteSearchEventSQL = "" +
+	"DELETE FROM syncapi_search WHERE event_id = $1"
+
+const deleteSearchEventsForRoomSQL = "" +
+	"DELETE FROM syncapi_search WHERE room_id = $1"
+
+const selectSearchSQL = "" +
+	"SELECT docid, event_id, room_id, words FROM syncapi_search" +
+	" WHERE value MATCH $1 AND room_id IN ($2) AND key IN ($3)"
+	// Filters, ORDER BY, LIMIT and OFFSET are appended by SelectSearch
+
+const selectSearchCountSQL = "" +
+	"SELECT COUNT(*) FROM syncapi_search" +
+	" WHERE value MATCH $1 AND room_id IN ($2) AND key IN ($3)"
+	// Filters are appended by SelectSearch
+
+type searchStatements struct {
+	db                            *sql.DB
+	insertSearchEventStmt         *sql.Stmt
+	deleteSearchEventStmt         *sql.Stmt
+	deleteSearchEventsForRoomStmt *sql.Stmt
+}
+
+func NewSqliteSearchTable(db *sql.DB) (tables.Search, error) {
+	_, err := db.Exec(searchSchema)
+	if err != nil {
+		return nil, err
+	}
+	s := &searchStatements{
+		db: db,
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertSearchEventStmt, insertSearchEventSQL},
+		{&s.deleteSearchEventStmt, deleteSearchEventSQL},
+		{&s.deleteSearchEventsForRoomStmt, deleteSearchEventsForRoomSQL},
+	}.Prepare(db)
+}
+
+func (s *searchStatements) InsertSearchEvent(
+	ctx context.Context, txn *sql.Tx, pos types.StreamPosition,
+	event *gomatrixserverlib.HeaderedEvent, key, value string,
+) error {
+	// There are no unique constraints on full-text tables, so make sure that
+	// the event isn't indexed twice if it was given a new stream position.
+	if err := s.DeleteSearchEvent(ctx, txn, event.EventID()); err != nil {
+		return err
+	}
+	words := len(searchTerms(value))
+	if words == 0 {
+		words = 1
+	}
+	stmt := sqlutil.TxStmt(txn, s.insertSearchEventStmt)
+	_, err := stmt.ExecContext(ctx, pos, event.EventID(), event.RoomID(), event.Sender(), event.Type(), key, words, value)
+	return err
+}
+
+func (s *searchStatements) DeleteSearchEvent(
+	ctx context.Context, txn *sql.Tx, eventID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSearchEventStmt)
+	_, err := stmt.ExecContext(ctx, eventID)
+	return err
+}
+
+func (s *searchStatements) DeleteSearchEventsForRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSearchEventsForRoomStmt)
+	_, err := stmt.ExecContext(ctx, roomID)
+	return err
+}
+
+// SelectSearch ranks events by the proportion of their words that are search
+// terms. Each term is assumed to appear once, since the index only tells us
+// that they all appear, so the events with the fewest words rank highest.
+func (s *searchStatements) SelectSearch(
+	ctx context.Context, txn *sql.Tx, searchTerm string, roomIDs, keys []string,
+	filter *gomatrixserverlib.RoomEventFilter, orderByStreamPos bool, limit, offset int,
+) ([]types.SearchResult, int, error) {
+	terms := searchTerms(searchTerm)
+	if len(terms) == 0 || len(roomIDs) == 0 || len(keys) == 0 {
+		return nil, 0, nil
+	}
+	// Quote each of the terms so that nothing in the search term is taken
+	// as query syntax. The terms are implicitly ANDed together.
+	quoted := make([]string, len(terms))
+	for i := range terms {
+		quoted[i] = `"` + terms[i] + `"`
+	}
+	params := []interface{}{strings.Join(quoted, " ")}
+	for _, roomID := range roomIDs {
+		params = append(params, roomID)
+	}
+	for _, key := range keys {
+		params = append(params, key)
+	}
+	var filters string
+	for _, f := range []struct {
+		clause string
+		values []string
+	}{
+		{" AND sender IN ", filter.Senders},
+		{" AND sender NOT IN ", filter.NotSenders},
+		{" AND type IN ", filter.Types},
+		{" AND type NOT IN ", filter.NotTypes},
+	} {
+		if len(f.values) == 0 {
+			continue
+		}
+		filters += f.clause + sqlutil.QueryVariadicOffset(len(f.values), len(params))
+		for _, v := range f.values {
+			params = append(params, v)
+		}
+	}
+
+	var count int
+	countQuery := strings.Replace(selectSearchCountSQL, "($2)", sqlutil.QueryVariadicOffset(len(roomIDs), 1), 1)
+	countQuery = strings.Replace(countQuery, "($3)", sqlutil.QueryVariadicOffset(len(keys), 1+len(roomIDs)), 1)
+	countQuery += filters
+	if err := s.queryRow(ctx, txn, countQuery, params...).Scan(&count); err != nil {
+		return nil, 0, fmt.Errorf("unable to count search results: %w", err)
+	}
+	if offset >= count {
+		return nil, count, nil
+	}
+
+	query := strings.Replace(selectSearchSQL, "($2)", sqlutil.QueryVariadicOffset(len(roomIDs), 1), 1)
+	query = strings.Replace(query, "($3)", sqlutil.QueryVariadicOffset(len(keys), 1+len(roomIDs)), 1)
+	query += filters
+	if orderByStreamPos {
+		query += " ORDER BY docid DESC"
+	} else {
+		query += " ORDER BY words ASC, docid DESC"
+	}
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(params)+1, len(params)+2)
+	params = append(params, limit, offset)
+
+	var rows *sql.Rows
+	var err error
+	if txn != nil {
+		rows, err = txn.QueryContext(ctx, query, params...)
+	} else {
+		rows, err = s.db.QueryContext(ctx, query, params...)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to query search index: %w", err)
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectSearch: rows.close() failed")
+
+	var results []types.SearchResult
+	for rows.Next() {
+		var result types.SearchResult
+		var words int
+		if err = rows.Scan(&result.StreamPos, &result.EventID, &result.RoomID, &words); err != nil {
+			return nil, 0, err
+		}
+		result.Rank = float64(len(terms)) / float64(words)
+		results = append(results, result)
+	}
+	return results, count, rows.Err()
+}
+
+func (s *searchStatements) queryRow(ctx context.Context, txn *sql.Tx, query string, params ...interface{}) *sql.Row {
+	if txn != nil {
+		return txn.QueryRowContext(ctx, query, params...)
+	}
+	return s.db.QueryRowContext(ctx, query, params...)
+}
+
+// searchTerms splits a search term into lower-cased words.
+func searchTerms(searchTerm string) []string {
+	return strings.FieldsFunc(strings.ToLower(searchTerm), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -72,6 +72,10 @@ func (d *SyncServerDatasource) prepare(dbProperties *config.DatabaseOptions) (er
 	if err != nil {
 		return err
 	}
+	knocks, err := NewSqliteKnocksTable(d.db, &d.streamID)
+	if err != nil {
+		return err
+	}
 	peeks, err := NewSqlitePeeksTable(d.db, &d.streamID)
 	if err != nil {
 		return err
@@ -96,13 +100,30 @@ func (d *SyncServerDatasource) prepare(dbProperties *config.DatabaseOptions) (er
 	if err != nil {
 		return err
 	}
+	notificationData, err := NewSqliteNotificationDataTable(d.db, &d.streamID)
+	if err != nil {
+		return err
+	}
 	memberships, err := NewSqliteMembershipsTable(d.db)
 	if err != nil {
 		return err
 	}
+	presence, err := NewSqlitePresenceTable(d.db, &d.streamID)
+	if err != nil {
+		return err
+	}
+	search, err := NewSqliteSearchTable(d.db)
+	if err != nil {
+		return err
+	}
+	relations, err := NewSqliteRelationsTable(d.db)
+	if err != nil {
+		return err
+	}
 	m := sqlutil.NewMigrations()
 	deltas.LoadFixSequences(m)
 	deltas.LoadRemoveSendToDeviceSentColumn(m)
+	deltas.LoadMembershipHistory(m)
 	if err = m.RunDeltas(d.db, dbProperties); err != nil {
 		return err
 	}
@@ -110,6 +131,7 @@ func (d *SyncServerDatasource) prepare(dbProperties *config.DatabaseOptions) (er
 		DB:                  d.db,
 		Writer:              d.writer,
 		Invites:             invites,
+		Knocks:              knocks,
 		Peeks:               peeks,
 		AccountData:         accountData,
 		OutputEvents:        events,
@@ -120,6 +142,10 @@ func (d *SyncServerDatasource) prepare(dbProperties *config.DatabaseOptions) (er
 		SendToDevice:        sendToDevice,
 		Receipts:            receipts,
 		Memberships:         memberships,
+		NotificationData:    notificationData,
+		Presence:            presence,
+		Search:              search,
+		Relations:           relations,
 	}
 	return nil
 }
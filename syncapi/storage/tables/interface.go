@@ -19,6 +19,7 @@ import (
 	"database/sql"
 
 	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/syncapi/types"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -40,6 +41,18 @@ type Invites interface {
 	SelectMaxInviteID(ctx context.Context, txn *sql.Tx) (id int64, err error)
 }
 
+// Knocks stores the pending knocks of local users. There is at most one row per
+// room and user, which is moved to a new stream position when it is updated.
+type Knocks interface {
+	UpsertKnockEvent(ctx context.Context, txn *sql.Tx, knockEvent *gomatrixserverlib.HeaderedEvent) (streamPos types.StreamPosition, err error)
+	// RetireKnockEvent marks the pending knock of the user in the room as retired. Returns a zero stream
+	// position if there was no pending knock.
+	RetireKnockEvent(ctx context.Context, txn *sql.Tx, roomID, targetUserID string) (types.StreamPosition, error)
+	// SelectKnockEventsInRange returns a map of room ID to pending knocks and a map of room ID to retired knocks.
+	SelectKnockEventsInRange(ctx context.Context, txn *sql.Tx, targetUserID string, r types.Range) (knocks map[string]*gomatrixserverlib.HeaderedEvent, retired map[string]*gomatrixserverlib.HeaderedEvent, err error)
+	SelectMaxKnockID(ctx context.Context, txn *sql.Tx) (id int64, err error)
+}
+
 type Peeks interface {
 	InsertPeek(ctx context.Context, txn *sql.Tx, roomID, userID, deviceID string) (streamPos types.StreamPosition, err error)
 	DeletePeek(ctx context.Context, txn *sql.Tx, roomID, userID, deviceID string) (streamPos types.StreamPosition, err error)
@@ -60,6 +73,11 @@ type Events interface {
 	// SelectEarlyEvents returns the earliest events in the given room.
 	SelectEarlyEvents(ctx context.Context, txn *sql.Tx, roomID string, r types.Range, eventFilter *gomatrixserverlib.RoomEventFilter) ([]types.StreamEvent, error)
 	SelectEvents(ctx context.Context, txn *sql.Tx, eventIDs []string) ([]types.StreamEvent, error)
+	// SelectEventsOfType returns all of the events of the given type in the room, ordered by stream position.
+	SelectEventsOfType(ctx context.Context, txn *sql.Tx, roomID, eventType string) ([]types.StreamEvent, error)
+	// SelectMaxEventIDsForRooms returns the stream position of the latest event that is sent to clients in
+	// each of the given rooms, keyed by room ID. Rooms without any such events are left out.
+	SelectMaxEventIDsForRooms(ctx context.Context, txn *sql.Tx, roomIDs []string) (map[string]types.StreamPosition, error)
 	UpdateEventJSON(ctx context.Context, event *gomatrixserverlib.HeaderedEvent) error
 	// DeleteEventsForRoom removes all event information for a room. This should only be done when removing the room entirely.
 	DeleteEventsForRoom(ctx context.Context, txn *sql.Tx, roomID string) (err error)
@@ -86,6 +104,8 @@ type Topology interface {
 
 type CurrentRoomState interface {
 	SelectStateEvent(ctx context.Context, roomID, evType, stateKey string) (*gomatrixserverlib.HeaderedEvent, error)
+	// SelectStateEventsOfType returns the current state events of the given type with an empty state key in the given rooms.
+	SelectStateEventsOfType(ctx context.Context, txn *sql.Tx, roomIDs []string, eventType string) ([]*gomatrixserverlib.HeaderedEvent, error)
 	SelectEventsWithEventIDs(ctx context.Context, txn *sql.Tx, eventIDs []string) ([]types.StreamEvent, error)
 	UpsertRoomState(ctx context.Context, txn *sql.Tx, event *gomatrixserverlib.HeaderedEvent, membership *string, addedAt types.StreamPosition) error
 	DeleteRoomStateByEventID(ctx context.Context, txn *sql.Tx, eventID string) error
@@ -163,7 +183,63 @@ type Receipts interface {
 	SelectMaxReceiptID(ctx context.Context, txn *sql.Tx) (id int64, err error)
 }
 
+type NotificationData interface {
+	UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID, roomID string, notificationCount, highlightCount int) (types.StreamPosition, error)
+	SelectUserUnreadCounts(ctx context.Context, txn *sql.Tx, userID string, fromExcl, toIncl types.StreamPosition) (map[string]*eventutil.NotificationData, error)
+	SelectMaxID(ctx context.Context, txn *sql.Tx) (int64, error)
+}
+
+type Presence interface {
+	UpsertPresence(ctx context.Context, txn *sql.Tx, userID string, presence eduAPI.Presence, statusMsg *string, lastActiveTS gomatrixserverlib.Timestamp) (types.StreamPosition, error)
+	SelectPresenceForUser(ctx context.Context, txn *sql.Tx, userID string) (*types.PresenceInternal, error)
+	SelectPresenceAfter(ctx context.Context, txn *sql.Tx, fromExcl, toIncl types.StreamPosition) ([]*types.PresenceInternal, error)
+	SelectMaxPresenceID(ctx context.Context, txn *sql.Tx) (int64, error)
+}
+
+// Search is a full-text index over the searchable content of events.
+type Search interface {
+	// InsertSearchEvent indexes value, taken from the given content key of the
+	// event at the given stream position.
+	InsertSearchEvent(ctx context.Context, txn *sql.Tx, pos types.StreamPosition, event *gomatrixserverlib.HeaderedEvent, key, value string) error
+	DeleteSearchEvent(ctx context.Context, txn *sql.Tx, eventID string) error
+	// DeleteSearchEventsForRoom removes the whole room from the index. This should only be done when removing the room entirely.
+	DeleteSearchEventsForRoom(ctx context.Context, txn *sql.Tx, roomID string) error
+	// SelectSearch returns up to limit of the events in the given rooms whose
+	// given content keys match the search term and which pass the sender and
+	// type parts of the filter, skipping the first offset of them, along with
+	// the total number of matching events. Results are ordered by rank, or
+	// newest first if orderByStreamPos is true.
+	SelectSearch(ctx context.Context, txn *sql.Tx, searchTerm string, roomIDs, keys []string, filter *gomatrixserverlib.RoomEventFilter, orderByStreamPos bool, limit, offset int) ([]types.SearchResult, int, error)
+}
+
+// Relations indexes events by the event that their m.relates_to refers to.
+type Relations interface {
+	// InsertRelation records that the event at the given stream position
+	// relates to another event. The key is only set for annotations.
+	InsertRelation(ctx context.Context, txn *sql.Tx, pos types.StreamPosition, event *gomatrixserverlib.HeaderedEvent, relatesTo, relType, key string) error
+	DeleteRelation(ctx context.Context, txn *sql.Tx, eventID string) error
+	// DeleteRelationsForRoom removes all relations in a room. This should only be done when removing the room entirely.
+	DeleteRelationsForRoom(ctx context.Context, txn *sql.Tx, roomID string) error
+	// SelectRelationsInRange returns up to limit of the events in the range
+	// that relate to the given event, optionally only those with the given
+	// rel_type and event type. Events are returned in the direction of the range.
+	SelectRelationsInRange(ctx context.Context, txn *sql.Tx, roomID, relatesTo, relType, eventType string, r types.Range, limit int) ([]types.RelationEntry, error)
+	// SelectRelationGroups returns the events relating to any of the given
+	// events, grouped by the event they relate to, rel_type, event type and key.
+	// Annotations are ordered by how many there are of them, and references
+	// by age. The user counts are of the events sent by the given user.
+	SelectRelationGroups(ctx context.Context, txn *sql.Tx, eventIDs []string, userID string) ([]types.RelationGroup, error)
+}
+
+// Memberships records every change to the membership of users in rooms, which
+// is needed to work out which events a user is allowed to see.
 type Memberships interface {
-	UpsertMembership(ctx context.Context, txn *sql.Tx, event *gomatrixserverlib.HeaderedEvent, streamPos, topologicalPos types.StreamPosition) error
+	// InsertMembership records a membership change. Recording the same membership event again has no effect.
+	InsertMembership(ctx context.Context, txn *sql.Tx, event *gomatrixserverlib.HeaderedEvent, streamPos, topologicalPos types.StreamPosition) error
 	SelectMembership(ctx context.Context, txn *sql.Tx, roomID, userID, memberships []string) (eventID string, streamPos, topologyPos types.StreamPosition, err error)
+	// SelectMembershipChanges returns all of the recorded membership changes of the user in the room,
+	// ordered by topological position.
+	SelectMembershipChanges(ctx context.Context, txn *sql.Tx, roomID, userID string) ([]types.MembershipChange, error)
+	// DeleteMembershipsForRoom removes all memberships of a room. This should only be done when removing the room entirely.
+	DeleteMembershipsForRoom(ctx context.Context, txn *sql.Tx, roomID string) error
 }
@@ -0,0 +1,199 @@
+package tables_test
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage/postgres"
+	"github.com/matrix-org/dendrite/syncapi/storage/sqlite3"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const (
+	alice = "@alice:test"
+	bob   = "@bob:test"
+)
+
+func mustOpenDB(t *testing.T, dbType test.DBType) *sql.DB {
+	t.Helper()
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString:   test.PrepareDBConnectionString(t, dbType),
+		MaxOpenConnections: 1,
+		MaxIdleConnections: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() {
+		db.Close() // nolint: errcheck
+	})
+	return db
+}
+
+func newSearchTable(t *testing.T, dbType test.DBType) tables.Search {
+	t.Helper()
+	db := mustOpenDB(t, dbType)
+	var tab tables.Search
+	var err error
+	switch dbType {
+	case test.DBTypePostgres:
+		tab, err = postgres.NewPostgresSearchTable(db)
+	case test.DBTypeSQLite:
+		tab, err = sqlite3.NewSqliteSearchTable(db)
+	}
+	if err != nil {
+		t.Fatalf("failed to make search table: %s", err)
+	}
+	return tab
+}
+
+func TestSelectSearch(t *testing.T) {
+	ctx := context.Background()
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{"membership": "join"}, bob)
+	otherRoom := test.NewRoom(t, alice)
+
+	type indexed struct {
+		ev         *gomatrixserverlib.HeaderedEvent
+		key, value string
+	}
+	message := func(r *test.Room, sender, body string) indexed {
+		return indexed{
+			ev:    r.CreateAndInsert(t, sender, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": body}),
+			key:   "content.body",
+			value: body,
+		}
+	}
+	// The events are indexed oldest first, so their rank order differs from
+	// their stream order.
+	far := message(room, alice, "quick thinking is what you need when you chase a fox")
+	near := message(room, alice, "quick fox")
+	middle := message(room, bob, "the quick brown fox")
+	name := indexed{
+		ev:    room.CreateAndInsert(t, alice, gomatrixserverlib.MRoomName, map[string]interface{}{"name": "Fox den"}, ""),
+		key:   "content.name",
+		value: "Fox den",
+	}
+	elsewhere := message(otherRoom, alice, "quick fox in another room")
+
+	bodyKeys := []string{"content.body"}
+	allKeys := []string{"content.body", "content.name", "content.topic"}
+	testCases := []struct {
+		name             string
+		searchTerm       string
+		roomIDs          []string
+		keys             []string
+		filter           gomatrixserverlib.RoomEventFilter
+		orderByStreamPos bool
+		limit, offset    int
+		want             []indexed
+		wantCount        int
+	}{
+		{
+			name: "ordered by rank", searchTerm: "quick fox",
+			roomIDs: []string{room.ID}, keys: bodyKeys, limit: 10,
+			want: []indexed{near, middle, far}, wantCount: 3,
+		},
+		{
+			name: "ordered by stream position", searchTerm: "quick fox",
+			roomIDs: []string{room.ID}, keys: bodyKeys, orderByStreamPos: true, limit: 10,
+			want: []indexed{middle, near, far}, wantCount: 3,
+		},
+		{
+			name: "first page", searchTerm: "quick fox",
+			roomIDs: []string{room.ID}, keys: bodyKeys, orderByStreamPos: true, limit: 2,
+			want: []indexed{middle, near}, wantCount: 3,
+		},
+		{
+			name: "second page", searchTerm: "quick fox",
+			roomIDs: []string{room.ID}, keys: bodyKeys, orderByStreamPos: true, limit: 2, offset: 2,
+			want: []indexed{far}, wantCount: 3,
+		},
+		{
+			name: "several rooms", searchTerm: "quick fox",
+			roomIDs: []string{room.ID, otherRoom.ID}, keys: bodyKeys, orderByStreamPos: true, limit: 10,
+			want: []indexed{elsewhere, middle, near, far}, wantCount: 4,
+		},
+		{
+			name: "only some keys", searchTerm: "fox",
+			roomIDs: []string{room.ID}, keys: []string{"content.name"}, limit: 10,
+			want: []indexed{name}, wantCount: 1,
+		},
+		{
+			name: "senders", searchTerm: "fox",
+			roomIDs: []string{room.ID}, keys: allKeys, limit: 10,
+			filter: gomatrixserverlib.RoomEventFilter{Senders: []string{bob}},
+			want:   []indexed{middle}, wantCount: 1,
+		},
+		{
+			name: "not senders", searchTerm: "fox",
+			roomIDs: []string{room.ID}, keys: allKeys, orderByStreamPos: true, limit: 10,
+			filter: gomatrixserverlib.RoomEventFilter{NotSenders: []string{bob}},
+			want:   []indexed{name, near, far}, wantCount: 3,
+		},
+		{
+			name: "types", searchTerm: "fox",
+			roomIDs: []string{room.ID}, keys: allKeys, limit: 10,
+			filter: gomatrixserverlib.RoomEventFilter{Types: []string{gomatrixserverlib.MRoomName}},
+			want:   []indexed{name}, wantCount: 1,
+		},
+		{
+			name: "not types", searchTerm: "fox",
+			roomIDs: []string{room.ID}, keys: allKeys, orderByStreamPos: true, limit: 10,
+			filter: gomatrixserverlib.RoomEventFilter{NotTypes: []string{gomatrixserverlib.MRoomName}},
+			want:   []indexed{middle, near, far}, wantCount: 3,
+		},
+		{
+			name: "no matches", searchTerm: "badger",
+			roomIDs: []string{room.ID}, keys: allKeys, limit: 10,
+		},
+	}
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab := newSearchTable(t, dbType)
+		for i, ix := range []indexed{far, near, middle, name, elsewhere} {
+			if err := tab.InsertSearchEvent(ctx, nil, types.StreamPosition(i+1), ix.ev, ix.key, ix.value); err != nil {
+				t.Fatalf("InsertSearchEvent failed: %s", err)
+			}
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				results, count, err := tab.SelectSearch(
+					ctx, nil, tc.searchTerm, tc.roomIDs, tc.keys, &tc.filter, tc.orderByStreamPos, tc.limit, tc.offset,
+				)
+				if err != nil {
+					t.Fatalf("SelectSearch failed: %s", err)
+				}
+				var got, want []string
+				for _, result := range results {
+					got = append(got, result.EventID)
+				}
+				for _, ix := range tc.want {
+					want = append(want, ix.ev.EventID())
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got events %v, want %v", got, want)
+				}
+				if count != tc.wantCount {
+					t.Errorf("got count %d, want %d", count, tc.wantCount)
+				}
+				if !tc.orderByStreamPos {
+					for i := 1; i < len(results); i++ {
+						if results[i].Rank > results[i-1].Rank {
+							t.Errorf("result %d ranks higher than the one before it", i)
+						}
+					}
+				}
+			})
+		}
+	})
+}
@@ -60,21 +60,56 @@ func (p *InviteStreamProvider) IncrementalSync(
 
 	for roomID := range retiredInvites {
 		if _, ok := req.Response.Rooms.Join[roomID]; !ok {
-			lr := types.NewLeaveResponse()
-			h := sha256.Sum256(append([]byte(roomID), []byte(strconv.FormatInt(int64(to), 10))...))
-			lr.Timeline.Events = append(lr.Timeline.Events, gomatrixserverlib.ClientEvent{
-				// fake event ID which muxes in the to position
-				EventID:        "$" + base64.RawURLEncoding.EncodeToString(h[:]),
-				OriginServerTS: gomatrixserverlib.AsTimestamp(time.Now()),
-				RoomID:         roomID,
-				Sender:         req.Device.UserID,
-				StateKey:       &req.Device.UserID,
-				Type:           "m.room.member",
-				Content:        gomatrixserverlib.RawJSON(`{"membership":"leave"}`),
-			})
-			req.Response.Rooms.Leave[roomID] = *lr
+			req.Response.Rooms.Leave[roomID] = *p.retiredLeaveResponse(req, roomID, to)
+		}
+	}
+
+	// Knocks are sent down the same stream as invites, since a pending
+	// knock is also a room that the user is not joined to yet.
+	knocks, retiredKnocks, err := p.DB.KnockEventsInRange(
+		ctx, req.Device.UserID, r,
+	)
+	if err != nil {
+		req.Log.WithError(err).Error("p.DB.KnockEventsInRange failed")
+		return from
+	}
+
+	for roomID, knockEvent := range knocks {
+		kr := types.NewKnockResponse(knockEvent)
+		req.Response.Rooms.Knock[roomID] = *kr
+	}
+
+	for roomID := range retiredKnocks {
+		// If the knock was accepted then the room will show up as an
+		// invite or a join instead.
+		_, joined := req.Response.Rooms.Join[roomID]
+		_, invited := req.Response.Rooms.Invite[roomID]
+		_, left := req.Response.Rooms.Leave[roomID]
+		if !joined && !invited && !left {
+			req.Response.Rooms.Leave[roomID] = *p.retiredLeaveResponse(req, roomID, to)
 		}
 	}
 
 	return to
 }
+
+// retiredLeaveResponse builds a leave response for a room in which an invite
+// or knock was retired, since we won't necessarily have the event that
+// retired it.
+func (p *InviteStreamProvider) retiredLeaveResponse(
+	req *types.SyncRequest, roomID string, to types.StreamPosition,
+) *types.LeaveResponse {
+	lr := types.NewLeaveResponse()
+	h := sha256.Sum256(append([]byte(roomID), []byte(strconv.FormatInt(int64(to), 10))...))
+	lr.Timeline.Events = append(lr.Timeline.Events, gomatrixserverlib.ClientEvent{
+		// fake event ID which muxes in the to position
+		EventID:        "$" + base64.RawURLEncoding.EncodeToString(h[:]),
+		OriginServerTS: gomatrixserverlib.AsTimestamp(time.Now()),
+		RoomID:         roomID,
+		Sender:         req.Device.UserID,
+		StateKey:       &req.Device.UserID,
+		Type:           "m.room.member",
+		Content:        gomatrixserverlib.RawJSON(`{"membership":"leave"}`),
+	})
+	return lr
+}
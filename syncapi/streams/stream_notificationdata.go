@@ -0,0 +1,65 @@
+package streams
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+type NotificationDataStreamProvider struct {
+	StreamProvider
+}
+
+func (p *NotificationDataStreamProvider) Setup() {
+	p.StreamProvider.Setup()
+
+	id, err := p.DB.MaxStreamPositionForNotificationData(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	p.latest = id
+}
+
+func (p *NotificationDataStreamProvider) CompleteSync(
+	ctx context.Context,
+	req *types.SyncRequest,
+) types.StreamPosition {
+	return p.IncrementalSync(ctx, req, 0, p.LatestPosition(ctx))
+}
+
+func (p *NotificationDataStreamProvider) IncrementalSync(
+	ctx context.Context,
+	req *types.SyncRequest,
+	from, to types.StreamPosition,
+) types.StreamPosition {
+	counts, err := p.DB.GetUserUnreadNotificationCounts(ctx, req.Device.UserID, from, to)
+	if err != nil {
+		req.Log.WithError(err).Error("p.DB.GetUserUnreadNotificationCounts failed")
+		return from
+	}
+
+	// Counts are only sent for rooms that the user is joined to. This
+	// stream runs after the others, so it adds to any join responses
+	// that they have already created.
+	for roomID, membership := range req.Rooms {
+		if membership != gomatrixserverlib.Join {
+			continue
+		}
+		count, ok := counts[roomID]
+		if !ok {
+			continue
+		}
+		jr := *types.NewJoinResponse()
+		if existing, ok := req.Response.Rooms.Join[roomID]; ok {
+			jr = existing
+		}
+		jr.UnreadNotifications = &types.UnreadNotifications{
+			HighlightCount:    count.UnreadHighlightCount,
+			NotificationCount: count.UnreadNotificationCount,
+		}
+		req.Response.Rooms.Join[roomID] = jr
+	}
+
+	return to
+}
@@ -0,0 +1,101 @@
+package streams
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNotificationDataStream(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	joinedRoom, leftRoom, otherRoom := "!joined:test", "!left:test", "!other:test"
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, err := storage.NewSyncServerDatasource(&config.DatabaseOptions{
+			ConnectionString: test.PrepareDBConnectionString(t, dbType),
+		})
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		p := &NotificationDataStreamProvider{StreamProvider: StreamProvider{DB: db}}
+		p.Setup()
+
+		upsert := func(t *testing.T, userID, roomID string, notifications, highlights int) types.StreamPosition {
+			t.Helper()
+			pos, err := db.UpsertRoomUnreadNotificationCounts(ctx, userID, roomID, notifications, highlights)
+			if err != nil {
+				t.Fatalf("UpsertRoomUnreadNotificationCounts failed: %s", err)
+			}
+			p.Advance(pos)
+			return pos
+		}
+		newRequest := func() *types.SyncRequest {
+			return &types.SyncRequest{
+				Context:  ctx,
+				Log:      logrus.NewEntry(logrus.New()),
+				Device:   &userapi.Device{UserID: alice},
+				Response: types.NewResponse(),
+				Rooms: map[string]string{
+					joinedRoom: gomatrixserverlib.Join,
+					leftRoom:   gomatrixserverlib.Leave,
+				},
+			}
+		}
+
+		upsert(t, alice, joinedRoom, 1, 0)
+		upsert(t, alice, leftRoom, 2, 1)
+		upsert(t, "@bob:test", otherRoom, 4, 4)
+		// The newest counts for a room replace the older ones.
+		before := upsert(t, alice, joinedRoom, 3, 1)
+
+		req := newRequest()
+		if pos := p.CompleteSync(ctx, req); pos != p.LatestPosition(ctx) {
+			t.Errorf("CompleteSync: got position %d, want %d", pos, p.LatestPosition(ctx))
+		}
+		jr, ok := req.Response.Rooms.Join[joinedRoom]
+		if !ok || jr.UnreadNotifications == nil {
+			t.Fatalf("CompleteSync: got no unread notifications for %s", joinedRoom)
+		}
+		if got := *jr.UnreadNotifications; got.NotificationCount != 3 || got.HighlightCount != 1 {
+			t.Errorf("CompleteSync: got counts %+v, want 3 notifications and 1 highlight", got)
+		}
+		if len(req.Response.Rooms.Join) != 1 {
+			t.Errorf("CompleteSync: got join responses for %d rooms, want 1", len(req.Response.Rooms.Join))
+		}
+
+		// Reading the room's notifications clears its counts in the next
+		// incremental sync, which keeps the timeline that the PDU stream
+		// has already added.
+		after := upsert(t, alice, joinedRoom, 0, 0)
+		req = newRequest()
+		existing := *types.NewJoinResponse()
+		existing.Timeline.Limited = true
+		req.Response.Rooms.Join[joinedRoom] = existing
+		p.IncrementalSync(ctx, req, before, after)
+		jr = req.Response.Rooms.Join[joinedRoom]
+		if jr.UnreadNotifications == nil {
+			t.Fatalf("IncrementalSync: got no unread notifications for %s", joinedRoom)
+		}
+		if got := *jr.UnreadNotifications; got.NotificationCount != 0 || got.HighlightCount != 0 {
+			t.Errorf("IncrementalSync: got counts %+v, want none", got)
+		}
+		if !jr.Timeline.Limited {
+			t.Errorf("IncrementalSync: the existing join response was replaced")
+		}
+
+		// Nothing has changed since, so there's nothing to send.
+		req = newRequest()
+		p.IncrementalSync(ctx, req, after, p.LatestPosition(ctx))
+		if len(req.Response.Rooms.Join) != 0 {
+			t.Errorf("IncrementalSync: got join responses %+v, want none", req.Response.Rooms.Join)
+		}
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/matrix-org/dendrite/internal/caching"
+	"github.com/matrix-org/dendrite/syncapi/internal"
 	"github.com/matrix-org/dendrite/syncapi/types"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -23,8 +25,9 @@ const PDU_STREAM_QUEUESIZE = PDU_STREAM_WORKERS * 8
 type PDUStreamProvider struct {
 	StreamProvider
 
-	tasks   chan func()
-	workers atomic.Int32
+	tasks         chan func()
+	workers       atomic.Int32
+	lazyLoadCache *caching.LazyLoadCache
 }
 
 func (p *PDUStreamProvider) worker() {
@@ -78,11 +81,17 @@ func (p *PDUStreamProvider) CompleteSync(
 	}
 
 	// Extract room state and recent events for all rooms the user is joined to.
-	joinedRoomIDs, err := p.DB.RoomIDsWithMembership(ctx, req.Device.UserID, gomatrixserverlib.Join)
+	allJoinedRoomIDs, err := p.DB.RoomIDsWithMembership(ctx, req.Device.UserID, gomatrixserverlib.Join)
 	if err != nil {
 		req.Log.WithError(err).Error("p.DB.RoomIDsWithMembership failed")
 		return from
 	}
+	joinedRoomIDs := make([]string, 0, len(allJoinedRoomIDs))
+	for _, roomID := range allJoinedRoomIDs {
+		if roomIncluded(&req.Filter.Room, roomID) {
+			joinedRoomIDs = append(joinedRoomIDs, roomID)
+		}
+	}
 
 	stateFilter := req.Filter.Room.State
 	eventFilter := req.Filter.Room.Timeline
@@ -121,7 +130,7 @@ func (p *PDUStreamProvider) CompleteSync(
 		return from
 	}
 	for _, peek := range peeks {
-		if !peek.Deleted {
+		if !peek.Deleted && roomIncluded(&req.Filter.Room, peek.RoomID) {
 			var jr *types.JoinResponse
 			jr, err = p.getJoinResponseForCompleteSync(
 				ctx, peek.RoomID, r, &stateFilter, &eventFilter, req.WantFullState, req.Device,
@@ -157,6 +166,9 @@ func (p *PDUStreamProvider) IncrementalSync(
 	eventFilter := req.Filter.Room.Timeline
 
 	if req.WantFullState {
+		// A client asking for the full state wants all of the room members
+		// it needs, including the ones that it has been sent before.
+		stateFilter.IncludeRedundantMembers = true
 		if stateDeltas, joinedRooms, err = p.DB.GetStateDeltasForFullStateSync(ctx, req.Device, r, req.Device.UserID, &stateFilter); err != nil {
 			req.Log.WithError(err).Error("p.DB.GetStateDeltasForFullStateSync failed")
 			return
@@ -169,11 +181,16 @@ func (p *PDUStreamProvider) IncrementalSync(
 	}
 
 	for _, roomID := range joinedRooms {
-		req.Rooms[roomID] = gomatrixserverlib.Join
+		if roomIncluded(&req.Filter.Room, roomID) {
+			req.Rooms[roomID] = gomatrixserverlib.Join
+		}
 	}
 
 	for _, delta := range stateDeltas {
-		if err = p.addRoomDeltaToResponse(ctx, req.Device, r, delta, &eventFilter, req.Response); err != nil {
+		if !roomIncluded(&req.Filter.Room, delta.RoomID) {
+			continue
+		}
+		if err = p.addRoomDeltaToResponse(ctx, req.Device, r, delta, &stateFilter, &eventFilter, req.Response); err != nil {
 			req.Log.WithError(err).Error("d.addRoomDeltaToResponse failed")
 			return newPos
 		}
@@ -187,16 +204,14 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 	device *userapi.Device,
 	r types.Range,
 	delta types.StateDelta,
+	stateFilter *gomatrixserverlib.StateFilter,
 	eventFilter *gomatrixserverlib.RoomEventFilter,
 	res *types.Response,
 ) error {
 	if delta.MembershipPos > 0 && delta.Membership == gomatrixserverlib.Leave {
-		// make sure we don't leak recent events after the leave event.
-		// TODO: History visibility makes this somewhat complex to handle correctly. For example:
-		// TODO: This doesn't work for join -> leave in a single /sync request (see events prior to join).
-		// TODO: This will fail on join -> leave -> sensitive msg -> join -> leave
-		//       in a single /sync request
-		// This is all "okay" assuming history_visibility == "shared" which it is by default.
+		// The timeline of a room that the user left ends at the leave event.
+		// Which of the earlier events they can see is down to the history
+		// visibility, which is applied below.
 		r.To = delta.MembershipPos
 	}
 	recentStreamEvents, limited, err := p.DB.RecentEvents(
@@ -206,8 +221,22 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 	if err != nil {
 		return err
 	}
-	recentEvents := p.DB.StreamEventsToEvents(device, recentStreamEvents)
+	recentEvents, err := internal.ApplyHistoryVisibilityFilter(
+		ctx, p.DB, device.UserID, p.DB.StreamEventsToEvents(device, recentStreamEvents),
+	)
+	if err != nil {
+		return err
+	}
 	delta.StateEvents = removeDuplicates(delta.StateEvents, recentEvents) // roll back
+	if stateFilter.LazyLoadMembers {
+		delta.StateEvents, err = p.lazyLoadMembers(
+			ctx, device, delta.RoomID, recentEvents, delta.StateEvents,
+			stateFilter.IncludeRedundantMembers,
+		)
+		if err != nil {
+			return err
+		}
+	}
 	prevBatch, err := p.DB.GetBackwardTopologyPos(ctx, recentStreamEvents)
 	if err != nil {
 		return err
@@ -218,12 +247,16 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 	if len(recentEvents) == 0 && len(delta.StateEvents) == 0 {
 		return nil
 	}
+	timeline, err := internal.ClientEventsWithRelations(ctx, p.DB, device.UserID, recentEvents, gomatrixserverlib.FormatSync)
+	if err != nil {
+		return err
+	}
 
 	switch delta.Membership {
 	case gomatrixserverlib.Join:
 		jr := types.NewJoinResponse()
 		jr.Timeline.PrevBatch = &prevBatch
-		jr.Timeline.Events = gomatrixserverlib.HeaderedToClientEvents(recentEvents, gomatrixserverlib.FormatSync)
+		jr.Timeline.Events = timeline
 		jr.Timeline.Limited = limited
 		jr.State.Events = gomatrixserverlib.HeaderedToClientEvents(delta.StateEvents, gomatrixserverlib.FormatSync)
 		res.Rooms.Join[delta.RoomID] = *jr
@@ -231,7 +264,7 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 	case gomatrixserverlib.Peek:
 		jr := types.NewJoinResponse()
 		jr.Timeline.PrevBatch = &prevBatch
-		jr.Timeline.Events = gomatrixserverlib.HeaderedToClientEvents(recentEvents, gomatrixserverlib.FormatSync)
+		jr.Timeline.Events = timeline
 		jr.Timeline.Limited = limited
 		jr.State.Events = gomatrixserverlib.HeaderedToClientEvents(delta.StateEvents, gomatrixserverlib.FormatSync)
 		res.Rooms.Peek[delta.RoomID] = *jr
@@ -240,11 +273,9 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 		fallthrough // transitions to leave are the same as ban
 
 	case gomatrixserverlib.Ban:
-		// TODO: recentEvents may contain events that this user is not allowed to see because they are
-		//       no longer in the room.
 		lr := types.NewLeaveResponse()
 		lr.Timeline.PrevBatch = &prevBatch
-		lr.Timeline.Events = gomatrixserverlib.HeaderedToClientEvents(recentEvents, gomatrixserverlib.FormatSync)
+		lr.Timeline.Events = timeline
 		lr.Timeline.Limited = false // TODO: if len(events) >= numRecents + 1 and then set limited:true
 		lr.State.Events = gomatrixserverlib.HeaderedToClientEvents(delta.StateEvents, gomatrixserverlib.FormatSync)
 		res.Rooms.Leave[delta.RoomID] = *lr
@@ -271,49 +302,46 @@ func (p *PDUStreamProvider) getJoinResponseForCompleteSync(
 		return
 	}
 
+	// We don't include a device here as we don't need to send down
+	// transaction IDs for complete syncs, but we do it anyway because Sytest demands it for:
+	// "Can sync a room with a message with a transaction id" - which does a complete sync to check.
+	recentEvents, err := internal.ApplyHistoryVisibilityFilter(
+		ctx, p.DB, device.UserID, p.DB.StreamEventsToEvents(device, recentStreamEvents),
+	)
+	if err != nil {
+		return
+	}
+	if len(recentEvents) < len(recentStreamEvents) {
+		// The user isn't allowed to see some of the history, which is usually
+		// everything before they joined, so tell clients not to backpaginate.
+		limited = false
+	}
+
 	// Get the event IDs of the stream events we fetched. There's no point in us
 	var excludingEventIDs []string
 	if !wantFullState {
-		excludingEventIDs = make([]string, 0, len(recentStreamEvents))
-		for _, event := range recentStreamEvents {
+		excludingEventIDs = make([]string, 0, len(recentEvents))
+		for _, event := range recentEvents {
 			if event.StateKey() != nil {
 				excludingEventIDs = append(excludingEventIDs, event.EventID())
 			}
 		}
 	}
 
+	if stateFilter.LazyLoadMembers {
+		// Only the membership events that are needed for the timeline are
+		// sent, so there's no point in fetching all of them.
+		lazyStateFilter := *stateFilter
+		lazyStateFilter.NotTypes = append(
+			append([]string{}, stateFilter.NotTypes...), gomatrixserverlib.MRoomMember,
+		)
+		stateFilter = &lazyStateFilter
+	}
 	stateEvents, err := p.DB.CurrentState(ctx, roomID, stateFilter, excludingEventIDs)
 	if err != nil {
 		return
 	}
 
-	// TODO FIXME: We don't fully implement history visibility yet. To avoid leaking events which the
-	// user shouldn't see, we check the recent events and remove any prior to the join event of the user
-	// which is equiv to history_visibility: joined
-	joinEventIndex := -1
-	for i := len(recentStreamEvents) - 1; i >= 0; i-- {
-		ev := recentStreamEvents[i]
-		if ev.Type() == gomatrixserverlib.MRoomMember && ev.StateKeyEquals(device.UserID) {
-			membership, _ := ev.Membership()
-			if membership == "join" {
-				joinEventIndex = i
-				if i > 0 {
-					// the create event happens before the first join, so we should cut it at that point instead
-					if recentStreamEvents[i-1].Type() == gomatrixserverlib.MRoomCreate && recentStreamEvents[i-1].StateKeyEquals("") {
-						joinEventIndex = i - 1
-						break
-					}
-				}
-				break
-			}
-		}
-	}
-	if joinEventIndex != -1 {
-		// cut all events earlier than the join (but not the join itself)
-		recentStreamEvents = recentStreamEvents[joinEventIndex:]
-		limited = false // so clients know not to try to backpaginate
-	}
-
 	// Retrieve the backward topology position, i.e. the position of the
 	// oldest event in the room's topology.
 	var prevBatch *types.TopologyToken
@@ -330,19 +358,69 @@ func (p *PDUStreamProvider) getJoinResponseForCompleteSync(
 		prevBatch.Decrement()
 	}
 
-	// We don't include a device here as we don't need to send down
-	// transaction IDs for complete syncs, but we do it anyway because Sytest demands it for:
-	// "Can sync a room with a message with a transaction id" - which does a complete sync to check.
-	recentEvents := p.DB.StreamEventsToEvents(device, recentStreamEvents)
 	stateEvents = removeDuplicates(stateEvents, recentEvents)
+	if stateFilter.LazyLoadMembers {
+		// The client doesn't have any room members yet, so send all of the
+		// ones that it needs.
+		stateEvents, err = p.lazyLoadMembers(ctx, device, roomID, recentEvents, stateEvents, true)
+		if err != nil {
+			return
+		}
+	}
+	timeline, err := internal.ClientEventsWithRelations(ctx, p.DB, device.UserID, recentEvents, gomatrixserverlib.FormatSync)
+	if err != nil {
+		return
+	}
 	jr = types.NewJoinResponse()
 	jr.Timeline.PrevBatch = prevBatch
-	jr.Timeline.Events = gomatrixserverlib.HeaderedToClientEvents(recentEvents, gomatrixserverlib.FormatSync)
+	jr.Timeline.Events = timeline
 	jr.Timeline.Limited = limited
 	jr.State.Events = gomatrixserverlib.HeaderedToClientEvents(stateEvents, gomatrixserverlib.FormatSync)
 	return jr, nil
 }
 
+// lazyLoadMembers replaces the membership events in the state of a room with
+// the ones that the client needs to show the timeline events, along with the
+// syncing user's own membership event.
+func (p *PDUStreamProvider) lazyLoadMembers(
+	ctx context.Context, device *userapi.Device, roomID string,
+	timeline, state []*gomatrixserverlib.HeaderedEvent, includeRedundant bool,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	members, err := internal.LazyLoadMembers(
+		ctx, p.DB, p.lazyLoadCache, device, roomID, timeline,
+		[]string{device.UserID}, includeRedundant,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stateEvents := make([]*gomatrixserverlib.HeaderedEvent, 0, len(state)+len(members))
+	for _, ev := range state {
+		if ev.Type() != gomatrixserverlib.MRoomMember {
+			stateEvents = append(stateEvents, ev)
+		}
+	}
+	return append(stateEvents, members...), nil
+}
+
+// roomIncluded returns whether the rooms and not_rooms of the room filter
+// allow the room.
+func roomIncluded(filter *gomatrixserverlib.RoomFilter, roomID string) bool {
+	for _, notRoomID := range filter.NotRooms {
+		if notRoomID == roomID {
+			return false
+		}
+	}
+	if filter.Rooms == nil {
+		return true
+	}
+	for _, includedRoomID := range filter.Rooms {
+		if includedRoomID == roomID {
+			return true
+		}
+	}
+	return false
+}
+
 func removeDuplicates(stateEvents, recentEvents []*gomatrixserverlib.HeaderedEvent) []*gomatrixserverlib.HeaderedEvent {
 	for _, recentEv := range recentEvents {
 		if recentEv.StateKey() == nil {
@@ -0,0 +1,85 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+type PresenceStreamProvider struct {
+	StreamProvider
+	rsAPI      rsapi.RoomserverInternalAPI
+	userAPI    userapi.UserInternalAPI
+	serverName gomatrixserverlib.ServerName
+}
+
+func (p *PresenceStreamProvider) Setup() {
+	p.StreamProvider.Setup()
+
+	id, err := p.DB.MaxStreamPositionForPresence(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	p.latest = id
+}
+
+func (p *PresenceStreamProvider) CompleteSync(
+	ctx context.Context,
+	req *types.SyncRequest,
+) types.StreamPosition {
+	return p.IncrementalSync(ctx, req, 0, p.LatestPosition(ctx))
+}
+
+func (p *PresenceStreamProvider) IncrementalSync(
+	ctx context.Context,
+	req *types.SyncRequest,
+	from, to types.StreamPosition,
+) types.StreamPosition {
+	presences, err := p.DB.GetPresenceAfter(ctx, from, to)
+	if err != nil {
+		req.Log.WithError(err).Error("p.DB.GetPresenceAfter failed")
+		return from
+	}
+	if len(presences) == 0 {
+		return to
+	}
+
+	// Users only see the presence of the users that they share a room with.
+	var sharedRes rsapi.QuerySharedUsersResponse
+	if err = p.rsAPI.QuerySharedUsers(ctx, &rsapi.QuerySharedUsersRequest{
+		UserID: req.Device.UserID,
+	}, &sharedRes); err != nil {
+		req.Log.WithError(err).Error("p.rsAPI.QuerySharedUsers failed")
+		return from
+	}
+
+	now := time.Now()
+	for _, presence := range presences {
+		if _, ok := sharedRes.UserIDsToCount[presence.UserID]; !ok && presence.UserID != req.Device.UserID {
+			continue
+		}
+		if err = internal.ApplyLastSeen(ctx, p.userAPI, p.serverName, presence); err != nil {
+			req.Log.WithError(err).Error("internal.ApplyLastSeen failed")
+			return from
+		}
+		ev := gomatrixserverlib.ClientEvent{
+			Type:   eduAPI.MPresence,
+			Sender: presence.UserID,
+		}
+		ev.Content, err = json.Marshal(presence.ClientPresence(now))
+		if err != nil {
+			req.Log.WithError(err).Error("json.Marshal failed")
+			return from
+		}
+		req.Response.Presence.Events = append(req.Response.Presence.Events, ev)
+	}
+
+	return to
+}
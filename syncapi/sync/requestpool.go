@@ -24,15 +24,18 @@ import (
 	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
 	keyapi "github.com/matrix-org/dendrite/keyserver/api"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/internal"
 	"github.com/matrix-org/dendrite/syncapi/notifier"
+	"github.com/matrix-org/dendrite/syncapi/producers"
 	"github.com/matrix-org/dendrite/syncapi/storage"
 	"github.com/matrix-org/dendrite/syncapi/streams"
 	"github.com/matrix-org/dendrite/syncapi/types"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -45,8 +48,12 @@ type RequestPool struct {
 	keyAPI   keyapi.KeyInternalAPI
 	rsAPI    roomserverAPI.RoomserverInternalAPI
 	lastseen sync.Map
+	presence sync.Map
 	streams  *streams.Streams
 	Notifier *notifier.Notifier
+	producer *producers.PresenceProducer
+	// slidingSessions are the sliding sync sessions, keyed by user and device.
+	slidingSessions sync.Map
 }
 
 // NewRequestPool makes a new RequestPool
@@ -55,6 +62,7 @@ func NewRequestPool(
 	userAPI userapi.UserInternalAPI, keyAPI keyapi.KeyInternalAPI,
 	rsAPI roomserverAPI.RoomserverInternalAPI,
 	streams *streams.Streams, notifier *notifier.Notifier,
+	producer *producers.PresenceProducer,
 ) *RequestPool {
 	rp := &RequestPool{
 		db:       db,
@@ -63,10 +71,13 @@ func NewRequestPool(
 		keyAPI:   keyAPI,
 		rsAPI:    rsAPI,
 		lastseen: sync.Map{},
+		presence: sync.Map{},
 		streams:  streams,
 		Notifier: notifier,
+		producer: producer,
 	}
 	go rp.cleanLastSeen()
+	go rp.cleanSlidingSyncSessions()
 	return rp
 }
 
@@ -109,6 +120,51 @@ func (rp *RequestPool) updateLastSeen(req *http.Request, device *userapi.Device)
 	rp.lastseen.Store(device.UserID+device.ID, time.Now())
 }
 
+// lastPresence is the presence that was last sent for a syncing user.
+type lastPresence struct {
+	presence eduAPI.Presence
+	sentAt   time.Time
+}
+
+// updatePresence marks the user as active with the presence requested by
+// the set_presence parameter. It is sent again at most once a minute while
+// nothing changes, in case it has been changed through the presence API since.
+// Users who stop syncing aren't marked as unavailable here: that is worked out
+// from when their devices were last seen whenever their presence is read.
+func (rp *RequestPool) updatePresence(req *http.Request, userID string) {
+	presence := eduAPI.Presence(req.URL.Query().Get("set_presence"))
+	if presence == "" {
+		presence = eduAPI.PresenceOnline
+	}
+	if presence == eduAPI.PresenceOffline || !presence.IsValid() {
+		// The user isn't marked as online when they sync.
+		return
+	}
+
+	now := time.Now()
+	if v, ok := rp.presence.Load(userID); ok {
+		last := v.(lastPresence)
+		if last.presence == presence && now.Sub(last.sentAt) < types.PresenceActiveTimeout {
+			return
+		}
+	}
+	rp.presence.Store(userID, lastPresence{presence: presence, sentAt: now})
+
+	// Syncing doesn't change the status message of the user.
+	var statusMsg *string
+	current, err := rp.db.GetPresence(req.Context(), userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rp.db.GetPresence failed")
+		return
+	}
+	if current != nil {
+		statusMsg = current.StatusMsg
+	}
+	if err = rp.producer.SendPresence(userID, presence, statusMsg, gomatrixserverlib.AsTimestamp(now)); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rp.producer.SendPresence failed")
+	}
+}
+
 func init() {
 	prometheus.MustRegister(
 		activeSyncRequests, waitingSyncRequests,
@@ -156,6 +212,7 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 	defer activeSyncRequests.Dec()
 
 	rp.updateLastSeen(req, device)
+	rp.updatePresence(req, device.UserID)
 
 	waitingSyncRequests.Inc()
 	defer waitingSyncRequests.Dec()
@@ -216,6 +273,12 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 			DeviceListPosition: rp.streams.DeviceListStreamProvider.CompleteSync(
 				syncReq.Context, syncReq,
 			),
+			PresencePosition: rp.streams.PresenceStreamProvider.CompleteSync(
+				syncReq.Context, syncReq,
+			),
+			NotificationDataPosition: rp.streams.NotificationDataStreamProvider.CompleteSync(
+				syncReq.Context, syncReq,
+			),
 		}
 	} else {
 		// Incremental sync
@@ -248,6 +311,14 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 				syncReq.Context, syncReq,
 				syncReq.Since.DeviceListPosition, currentPos.DeviceListPosition,
 			),
+			PresencePosition: rp.streams.PresenceStreamProvider.IncrementalSync(
+				syncReq.Context, syncReq,
+				syncReq.Since.PresencePosition, currentPos.PresencePosition,
+			),
+			NotificationDataPosition: rp.streams.NotificationDataStreamProvider.IncrementalSync(
+				syncReq.Context, syncReq,
+				syncReq.Since.NotificationDataPosition, currentPos.NotificationDataPosition,
+			),
 		}
 	}
 
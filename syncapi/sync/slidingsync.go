@@ -0,0 +1,622 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+// slidingSyncSessionTimeout is how long a sliding sync session is kept
+// for after the last request that used it.
+const slidingSyncSessionTimeout = 30 * time.Minute
+
+// slidingSyncSession is what we remember about what a device was sent over
+// sliding sync, so that the next response only contains what changed.
+type slidingSyncSession struct {
+	// lastUsed is the time the session was last used in unix nanoseconds.
+	// It is accessed atomically so that cleaning up sessions doesn't wait
+	// for long-polling requests to finish.
+	lastUsed int64
+	sync.Mutex
+	pos           int64
+	since         types.StreamingToken
+	lists         []slidingList
+	subscriptions map[string]types.SlidingRoomSubscription
+}
+
+// slidingList is a list as it was last sent to the client.
+type slidingList struct {
+	request types.SlidingListRequest
+	count   int
+	// windows are the rooms that were sent for each of the ranges.
+	windows [][]string
+}
+
+// slidingSortData is what the rooms of a sliding sync list are sorted by.
+type slidingSortData struct {
+	recency map[string]types.StreamPosition
+	names   map[string]string
+	unread  map[string]types.UnreadNotifications
+}
+
+func (rp *RequestPool) cleanSlidingSyncSessions() {
+	for {
+		time.Sleep(time.Minute)
+		expired := time.Now().Add(-slidingSyncSessionTimeout).UnixNano()
+		rp.slidingSessions.Range(func(key, value interface{}) bool {
+			if atomic.LoadInt64(&value.(*slidingSyncSession).lastUsed) < expired {
+				rp.slidingSessions.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// OnIncomingSlidingSyncRequest implements POST /_matrix/client/unstable/org.matrix.msc3575/sync
+// Like /sync, this function blocks until there is something new to send
+// to the client or the request times out.
+func (rp *RequestPool) OnIncomingSlidingSyncRequest(req *http.Request, device *userapi.Device) util.JSONResponse {
+	var body types.SlidingSyncRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+	if err := validateSlidingSyncRequest(&body); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam(err.Error()),
+		}
+	}
+	ctx := req.Context()
+	timeout := getTimeout(req.URL.Query().Get("timeout"))
+
+	// A request without a position starts a new session, replacing any
+	// session that the device had before.
+	key := device.UserID + "|" + device.ID
+	pos := req.URL.Query().Get("pos")
+	var session *slidingSyncSession
+	if pos == "" {
+		session = &slidingSyncSession{
+			subscriptions: map[string]types.SlidingRoomSubscription{},
+		}
+		rp.slidingSessions.Store(key, session)
+	} else {
+		v, ok := rp.slidingSessions.Load(key)
+		if !ok {
+			return unknownSlidingSyncPos()
+		}
+		session = v.(*slidingSyncSession)
+	}
+	session.Lock()
+	defer session.Unlock()
+	if pos != "" && pos != strconv.FormatInt(session.pos, 10) {
+		return unknownSlidingSyncPos()
+	}
+	atomic.StoreInt64(&session.lastUsed, time.Now().UnixNano())
+
+	// The subscriptions are only stored in the session once the response
+	// has been sent, in case the client gives up and tries again.
+	subscriptions := make(map[string]types.SlidingRoomSubscription, len(session.subscriptions))
+	for roomID, sub := range session.subscriptions {
+		subscriptions[roomID] = sub
+	}
+	for _, roomID := range body.UnsubscribeRooms {
+		delete(subscriptions, roomID)
+	}
+	for roomID, sub := range body.RoomSubscriptions {
+		subscriptions[roomID] = sub
+	}
+
+	activeSyncRequests.Inc()
+	defer activeSyncRequests.Dec()
+
+	rp.updateLastSeen(req, device)
+	rp.updatePresence(req, device.UserID)
+
+	to := rp.Notifier.CurrentPosition()
+	res, lists, changed, err := rp.buildSlidingSyncResponse(ctx, device, session, &body, subscriptions, to)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("rp.buildSlidingSyncResponse failed")
+		return jsonerror.InternalServerError()
+	}
+
+	// Only wait for something to change if the client already has the
+	// rooms. The first response of a session is always sent straight away.
+	if pos != "" && timeout > 0 && !changed {
+		waitingSyncRequests.Inc()
+		defer waitingSyncRequests.Dec()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		listener := rp.Notifier.GetListener(types.SyncRequest{Context: ctx, Device: device})
+		defer listener.Close()
+
+	wait:
+		for !changed {
+			select {
+			case <-ctx.Done(): // Caller gave up
+				return util.JSONResponse{
+					Code: http.StatusOK,
+					JSON: types.NewSlidingSyncResponse(),
+				}
+
+			case <-timer.C: // Timeout reached
+				break wait
+
+			case <-listener.GetNotifyChannel(to):
+				to.ApplyUpdates(listener.GetSyncPosition())
+				res, lists, changed, err = rp.buildSlidingSyncResponse(ctx, device, session, &body, subscriptions, to)
+				if err != nil {
+					util.GetLogger(ctx).WithError(err).Error("rp.buildSlidingSyncResponse failed")
+					return jsonerror.InternalServerError()
+				}
+			}
+		}
+	}
+
+	session.lists = lists
+	session.subscriptions = subscriptions
+	session.since = to
+	session.pos++
+	atomic.StoreInt64(&session.lastUsed, time.Now().UnixNano())
+	res.Pos = strconv.FormatInt(session.pos, 10)
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func unknownSlidingSyncPos() util.JSONResponse {
+	return util.JSONResponse{
+		Code: http.StatusBadRequest,
+		JSON: jsonerror.UnknownPos("Unknown or expired position, start a new session"),
+	}
+}
+
+func validateSlidingSyncRequest(body *types.SlidingSyncRequest) error {
+	for i, list := range body.Lists {
+		for _, rng := range list.Ranges {
+			if rng[0] < 0 || rng[1] < rng[0] {
+				return fmt.Errorf("list %d has an invalid range %v", i, rng)
+			}
+		}
+		for _, by := range list.Sort {
+			switch by {
+			case types.SlidingSortByRecency, types.SlidingSortByName, types.SlidingSortByNotificationCount:
+			default:
+				return fmt.Errorf("list %d has an unknown sort %q", i, by)
+			}
+		}
+		if list.TimelineLimit < 0 {
+			return fmt.Errorf("list %d has a negative timeline_limit", i)
+		}
+	}
+	for roomID, sub := range body.RoomSubscriptions {
+		if sub.TimelineLimit < 0 {
+			return fmt.Errorf("room subscription %s has a negative timeline_limit", roomID)
+		}
+	}
+	return nil
+}
+
+// buildSlidingSyncResponse works out what has changed for the session up to
+// the given position. It returns the response along with the lists as they
+// will be once the client has been sent it, and whether anything changed.
+func (rp *RequestPool) buildSlidingSyncResponse(
+	ctx context.Context, device *userapi.Device, session *slidingSyncSession,
+	body *types.SlidingSyncRequest, subscriptions map[string]types.SlidingRoomSubscription,
+	to types.StreamingToken,
+) (*types.SlidingSyncResponse, []slidingList, bool, error) {
+	joinedRoomIDs, err := rp.db.RoomIDsWithMembership(ctx, device.UserID, gomatrixserverlib.Join)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("rp.db.RoomIDsWithMembership: %w", err)
+	}
+	data, err := rp.getSlidingSortData(ctx, device.UserID, joinedRoomIDs, to)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	res := types.NewSlidingSyncResponse()
+	changed := len(body.Lists) != len(session.lists)
+	lists := make([]slidingList, 0, len(body.Lists))
+	for i, request := range body.Lists {
+		sorted := sortSlidingRooms(joinedRoomIDs, request.Sort, data)
+		list := slidingList{
+			request: request,
+			count:   len(sorted),
+			windows: slidingWindows(sorted, request.Ranges),
+		}
+		var prev *slidingList
+		if i < len(session.lists) {
+			prev = &session.lists[i]
+		}
+		if prev == nil || prev.count != list.count {
+			changed = true
+		}
+
+		// The client already has the rooms that were in the windows of the
+		// list last time, unless it now wants different things about them.
+		reusable := prev != nil && sameSlidingRoomSubscription(prev.request.SlidingRoomSubscription, request.SlidingRoomSubscription)
+		known := map[string]bool{}
+		if reusable {
+			for _, window := range prev.windows {
+				for _, roomID := range window {
+					known[roomID] = true
+				}
+			}
+		}
+		var initialRoomIDs, knownRoomIDs []string
+		seen := map[string]bool{}
+		for _, window := range list.windows {
+			for _, roomID := range window {
+				if seen[roomID] {
+					continue
+				}
+				seen[roomID] = true
+				if known[roomID] {
+					knownRoomIDs = append(knownRoomIDs, roomID)
+				} else {
+					initialRoomIDs = append(initialRoomIDs, roomID)
+				}
+			}
+		}
+		rooms := rp.getSlidingRooms(ctx, device, request.SlidingRoomSubscription, initialRoomIDs, knownRoomIDs, session.since, to, data)
+
+		res.Ops = append(res.Ops, slidingListOps(i, &list, prev, reusable, rooms, data)...)
+		res.Counts = append(res.Counts, list.count)
+		lists = append(lists, list)
+	}
+
+	joined := make(map[string]bool, len(joinedRoomIDs))
+	for _, roomID := range joinedRoomIDs {
+		joined[roomID] = true
+	}
+	for roomID, sub := range subscriptions {
+		if !joined[roomID] {
+			continue
+		}
+		var rooms map[string]types.SlidingRoom
+		if _, ok := body.RoomSubscriptions[roomID]; ok {
+			rooms = rp.getSlidingRooms(ctx, device, sub, []string{roomID}, nil, session.since, to, data)
+		} else {
+			rooms = rp.getSlidingRooms(ctx, device, sub, nil, []string{roomID}, session.since, to, data)
+		}
+		if room, ok := rooms[roomID]; ok {
+			res.RoomSubscriptions[roomID] = room
+		}
+	}
+
+	return res, lists, changed || !res.IsEmpty(), nil
+}
+
+func (rp *RequestPool) getSlidingSortData(
+	ctx context.Context, userID string, roomIDs []string, to types.StreamingToken,
+) (*slidingSortData, error) {
+	data := &slidingSortData{
+		names:  make(map[string]string, len(roomIDs)),
+		unread: make(map[string]types.UnreadNotifications, len(roomIDs)),
+	}
+	var err error
+	if data.recency, err = rp.db.MaxStreamPositionsForRooms(ctx, roomIDs); err != nil {
+		return nil, fmt.Errorf("rp.db.MaxStreamPositionsForRooms: %w", err)
+	}
+	// The canonical alias is used as the name of rooms without a name.
+	for _, evType := range []string{gomatrixserverlib.MRoomCanonicalAlias, gomatrixserverlib.MRoomName} {
+		events, err := rp.db.StateEventsOfType(ctx, roomIDs, evType)
+		if err != nil {
+			return nil, fmt.Errorf("rp.db.StateEventsOfType: %w", err)
+		}
+		for _, ev := range events {
+			var content struct {
+				Name  string `json:"name"`
+				Alias string `json:"alias"`
+			}
+			if err = json.Unmarshal(ev.Content(), &content); err != nil {
+				continue
+			}
+			if content.Name != "" {
+				data.names[ev.RoomID()] = content.Name
+			} else if content.Alias != "" {
+				data.names[ev.RoomID()] = content.Alias
+			}
+		}
+	}
+	counts, err := rp.db.GetUserUnreadNotificationCounts(ctx, userID, 0, to.NotificationDataPosition)
+	if err != nil {
+		return nil, fmt.Errorf("rp.db.GetUserUnreadNotificationCounts: %w", err)
+	}
+	for roomID, count := range counts {
+		data.unread[roomID] = types.UnreadNotifications{
+			HighlightCount:    count.UnreadHighlightCount,
+			NotificationCount: count.UnreadNotificationCount,
+		}
+	}
+	return data, nil
+}
+
+// sortSlidingRooms returns the rooms sorted by the given sorts, most recently
+// active first if there are none. Ties are broken by room ID so that the
+// order of the rooms is stable between requests.
+func sortSlidingRooms(roomIDs []string, sorts []string, data *slidingSortData) []string {
+	if len(sorts) == 0 {
+		sorts = []string{types.SlidingSortByRecency}
+	}
+	sorted := make([]string, len(roomIDs))
+	copy(sorted, roomIDs)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		for _, by := range sorts {
+			switch by {
+			case types.SlidingSortByRecency:
+				if data.recency[a] != data.recency[b] {
+					return data.recency[a] > data.recency[b]
+				}
+			case types.SlidingSortByName:
+				nameA, nameB := strings.ToLower(data.names[a]), strings.ToLower(data.names[b])
+				if nameA != nameB {
+					// Rooms without a name go at the end.
+					if nameA == "" || nameB == "" {
+						return nameB == ""
+					}
+					return nameA < nameB
+				}
+			case types.SlidingSortByNotificationCount:
+				unreadA, unreadB := data.unread[a], data.unread[b]
+				if unreadA.HighlightCount != unreadB.HighlightCount {
+					return unreadA.HighlightCount > unreadB.HighlightCount
+				}
+				if unreadA.NotificationCount != unreadB.NotificationCount {
+					return unreadA.NotificationCount > unreadB.NotificationCount
+				}
+			}
+		}
+		return a < b
+	})
+	return sorted
+}
+
+// slidingWindows returns the rooms in each of the ranges. Ranges that go past
+// the end of the list are cut short.
+func slidingWindows(roomIDs []string, ranges [][2]int) [][]string {
+	windows := make([][]string, len(ranges))
+	for i, rng := range ranges {
+		start, end := rng[0], rng[1]+1
+		if end > len(roomIDs) {
+			end = len(roomIDs)
+		}
+		if start > end {
+			start = end
+		}
+		windows[i] = roomIDs[start:end]
+	}
+	return windows
+}
+
+// slidingListOps returns the operations that take the client from the previous
+// version of the list to the new one. Ranges that still contain the same rooms
+// in the same order are updated in place, others are invalidated and sent again.
+// The rooms map contains the rooms that are new to the client or have changed.
+func slidingListOps(
+	index int, list, prev *slidingList, reusable bool,
+	rooms map[string]types.SlidingRoom, data *slidingSortData,
+) []types.SlidingOperation {
+	var ops []types.SlidingOperation
+	for i, rng := range list.request.Ranges {
+		window := list.windows[i]
+		hadRange := prev != nil && i < len(prev.request.Ranges)
+		if reusable && hadRange && prev.request.Ranges[i] == rng && sameRoomIDs(prev.windows[i], window) {
+			for j, roomID := range window {
+				room, ok := rooms[roomID]
+				if !ok {
+					continue
+				}
+				roomIndex := rng[0] + j
+				ops = append(ops, types.SlidingOperation{
+					List:  index,
+					Op:    types.SlidingOpUpdate,
+					Index: &roomIndex,
+					Room:  &room,
+				})
+			}
+			continue
+		}
+		if hadRange {
+			prevRange := prev.request.Ranges[i]
+			ops = append(ops, types.SlidingOperation{
+				List:  index,
+				Op:    types.SlidingOpInvalidate,
+				Range: &prevRange,
+			})
+		}
+		syncRange := rng
+		syncOp := types.SlidingOperation{
+			List:  index,
+			Op:    types.SlidingOpSync,
+			Range: &syncRange,
+			Rooms: make([]types.SlidingRoom, 0, len(window)),
+		}
+		for _, roomID := range window {
+			room, ok := rooms[roomID]
+			if !ok {
+				// The client already has the room, so it only needs to know
+				// where it is in the list now.
+				room = newSlidingRoom(roomID, types.NewJoinResponse(), nil, data)
+			}
+			syncOp.Rooms = append(syncOp.Rooms, room)
+		}
+		ops = append(ops, syncOp)
+	}
+	// Ranges that the client no longer wants are forgotten about.
+	if prev != nil {
+		for i := len(list.request.Ranges); i < len(prev.request.Ranges); i++ {
+			prevRange := prev.request.Ranges[i]
+			ops = append(ops, types.SlidingOperation{
+				List:  index,
+				Op:    types.SlidingOpInvalidate,
+				Range: &prevRange,
+			})
+		}
+	}
+	return ops
+}
+
+// getSlidingRooms returns the initial rooms with everything that the
+// subscription asks for, and the known rooms that changed since the
+// last response with only what changed.
+func (rp *RequestPool) getSlidingRooms(
+	ctx context.Context, device *userapi.Device, sub types.SlidingRoomSubscription,
+	initialRoomIDs, knownRoomIDs []string, since, to types.StreamingToken,
+	data *slidingSortData,
+) map[string]types.SlidingRoom {
+	rooms := make(map[string]types.SlidingRoom, len(initialRoomIDs))
+	if len(initialRoomIDs) > 0 {
+		syncReq := newSlidingSyncRoomsRequest(ctx, device, sub, initialRoomIDs)
+		rp.streams.PDUStreamProvider.CompleteSync(ctx, syncReq)
+		rp.streams.NotificationDataStreamProvider.CompleteSync(ctx, syncReq)
+		for _, roomID := range initialRoomIDs {
+			jr := types.NewJoinResponse()
+			if existing, ok := syncReq.Response.Rooms.Join[roomID]; ok {
+				jr = &existing
+			}
+			room := newSlidingRoom(roomID, jr, sub.RequiredState, data)
+			room.Initial = true
+			rooms[roomID] = room
+		}
+	}
+	if len(knownRoomIDs) > 0 {
+		syncReq := newSlidingSyncRoomsRequest(ctx, device, sub, knownRoomIDs)
+		rp.streams.PDUStreamProvider.IncrementalSync(ctx, syncReq, since.PDUPosition, to.PDUPosition)
+		rp.streams.NotificationDataStreamProvider.IncrementalSync(ctx, syncReq, since.NotificationDataPosition, to.NotificationDataPosition)
+		for roomID, jr := range syncReq.Response.Rooms.Join {
+			jr := jr
+			rooms[roomID] = newSlidingRoom(roomID, &jr, sub.RequiredState, data)
+		}
+	}
+	return rooms
+}
+
+// newSlidingSyncRoomsRequest makes a sync request for the streams that only
+// includes the given rooms, with the state and timeline that the subscription
+// asks for.
+func newSlidingSyncRoomsRequest(
+	ctx context.Context, device *userapi.Device, sub types.SlidingRoomSubscription, roomIDs []string,
+) *types.SyncRequest {
+	filter := gomatrixserverlib.DefaultFilter()
+	filter.Room.Rooms = roomIDs
+	filter.Room.Timeline.Limit = sub.TimelineLimit
+	// The state is filtered by state key in newSlidingRoom, so only the
+	// event types are narrowed down here.
+	filter.Room.State.Types = nil
+	for _, required := range sub.RequiredState {
+		if required[0] == "*" {
+			filter.Room.State.Types = nil
+			break
+		}
+		filter.Room.State.Types = append(filter.Room.State.Types, required[0])
+	}
+	if len(sub.RequiredState) == 0 {
+		filter.Room.State.Limit = 0
+	}
+	return &types.SyncRequest{
+		Context: ctx,
+		Log: util.GetLogger(ctx).WithFields(logrus.Fields{
+			"user_id":   device.UserID,
+			"device_id": device.ID,
+		}),
+		Device:   device,
+		Response: types.NewResponse(),
+		Filter:   filter,
+		Rooms:    make(map[string]string),
+	}
+}
+
+// newSlidingRoom converts a join response from the streams into a sliding
+// sync room, keeping only the state that was asked for.
+func newSlidingRoom(
+	roomID string, jr *types.JoinResponse, requiredState [][2]string, data *slidingSortData,
+) types.SlidingRoom {
+	room := types.SlidingRoom{
+		RoomID:    roomID,
+		Name:      data.names[roomID],
+		Timeline:  jr.Timeline.Events,
+		Limited:   jr.Timeline.Limited,
+		PrevBatch: jr.Timeline.PrevBatch,
+	}
+	for _, ev := range jr.State.Events {
+		if ev.StateKey != nil && requiredStateIncludes(requiredState, ev.Type, *ev.StateKey) {
+			room.RequiredState = append(room.RequiredState, ev)
+		}
+	}
+	unread := data.unread[roomID]
+	if jr.UnreadNotifications != nil {
+		unread = *jr.UnreadNotifications
+	}
+	room.NotificationCount = unread.NotificationCount
+	room.HighlightCount = unread.HighlightCount
+	return room
+}
+
+// requiredStateIncludes returns whether one of the [event type, state key]
+// pairs matches the state event, where "*" matches anything.
+func requiredStateIncludes(requiredState [][2]string, evType, stateKey string) bool {
+	for _, required := range requiredState {
+		if (required[0] == "*" || required[0] == evType) && (required[1] == "*" || required[1] == stateKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSlidingRoomSubscription(a, b types.SlidingRoomSubscription) bool {
+	if a.TimelineLimit != b.TimelineLimit || len(a.RequiredState) != len(b.RequiredState) {
+		return false
+	}
+	for i := range a.RequiredState {
+		if a.RequiredState[i] != b.RequiredState[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRoomIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
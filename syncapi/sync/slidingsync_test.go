@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/syncapi/types"
+)
+
+func TestSortSlidingRooms(t *testing.T) {
+	data := &slidingSortData{
+		recency: map[string]types.StreamPosition{
+			"!a:localhost": 3,
+			"!b:localhost": 5,
+			"!c:localhost": 5,
+			"!d:localhost": 1,
+		},
+		names: map[string]string{
+			"!a:localhost": "beta",
+			"!b:localhost": "Alpha",
+			"!d:localhost": "gamma",
+		},
+		unread: map[string]types.UnreadNotifications{
+			"!a:localhost": {NotificationCount: 2},
+			"!c:localhost": {NotificationCount: 1, HighlightCount: 1},
+			"!d:localhost": {NotificationCount: 2},
+		},
+	}
+	roomIDs := []string{"!a:localhost", "!b:localhost", "!c:localhost", "!d:localhost"}
+	testCases := []struct {
+		name  string
+		sorts []string
+		want  []string
+	}{
+		{
+			name: "default",
+			want: []string{"!b:localhost", "!c:localhost", "!a:localhost", "!d:localhost"},
+		},
+		{
+			name:  "by name",
+			sorts: []string{types.SlidingSortByName},
+			want:  []string{"!b:localhost", "!a:localhost", "!d:localhost", "!c:localhost"},
+		},
+		{
+			name:  "by notification count then recency",
+			sorts: []string{types.SlidingSortByNotificationCount, types.SlidingSortByRecency},
+			want:  []string{"!c:localhost", "!a:localhost", "!d:localhost", "!b:localhost"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sortSlidingRooms(roomIDs, tc.sorts, data); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlidingWindows(t *testing.T) {
+	roomIDs := []string{"!a:localhost", "!b:localhost", "!c:localhost"}
+	got := slidingWindows(roomIDs, [][2]int{{0, 1}, {2, 10}, {5, 9}})
+	want := [][]string{{"!a:localhost", "!b:localhost"}, {"!c:localhost"}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSlidingListOps(t *testing.T) {
+	data := &slidingSortData{}
+	prev := &slidingList{
+		request: types.SlidingListRequest{Ranges: [][2]int{{0, 1}, {5, 6}}},
+		windows: [][]string{{"!a:localhost", "!b:localhost"}, {"!f:localhost", "!g:localhost"}},
+	}
+	list := &slidingList{
+		request: types.SlidingListRequest{Ranges: [][2]int{{0, 1}, {5, 6}}},
+		windows: [][]string{{"!a:localhost", "!b:localhost"}, {"!g:localhost", "!h:localhost"}},
+	}
+	rooms := map[string]types.SlidingRoom{
+		"!b:localhost": {RoomID: "!b:localhost"},
+		"!h:localhost": {RoomID: "!h:localhost", Initial: true},
+	}
+
+	ops := slidingListOps(0, list, prev, true, rooms, data)
+	var got []string
+	for _, op := range ops {
+		got = append(got, op.Op)
+	}
+	want := []string{types.SlidingOpUpdate, types.SlidingOpInvalidate, types.SlidingOpSync}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ops %v, want %v", got, want)
+	}
+	if *ops[0].Index != 1 || ops[0].Room.RoomID != "!b:localhost" {
+		t.Errorf("UPDATE is for %s at %d, want !b:localhost at 1", ops[0].Room.RoomID, *ops[0].Index)
+	}
+	if *ops[1].Range != [2]int{5, 6} {
+		t.Errorf("INVALIDATE is for range %v, want [5 6]", *ops[1].Range)
+	}
+	syncOp := ops[2]
+	if len(syncOp.Rooms) != 2 || syncOp.Rooms[0].Initial || !syncOp.Rooms[1].Initial {
+		t.Errorf("SYNC should send !g:localhost without data and !h:localhost with it, got %+v", syncOp.Rooms)
+	}
+
+	// Without a previous list that can be reused, every range is sent again.
+	ops = slidingListOps(0, list, nil, false, rooms, data)
+	if len(ops) != 2 || ops[0].Op != types.SlidingOpSync || ops[1].Op != types.SlidingOpSync {
+		t.Errorf("got %+v, want two SYNC operations", ops)
+	}
+}
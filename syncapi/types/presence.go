@@ -0,0 +1,75 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"time"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const (
+	// PresenceIdleTimeout is how long a user who is online can go without
+	// any activity before they are shown as unavailable.
+	PresenceIdleTimeout = 5 * time.Minute
+	// PresenceActiveTimeout is how long after their last activity a user
+	// is shown as currently active.
+	PresenceActiveTimeout = time.Minute
+)
+
+// PresenceInternal is the presence of a user as stored by the sync API.
+type PresenceInternal struct {
+	StreamPos    StreamPosition
+	UserID       string
+	Presence     eduAPI.Presence
+	StatusMsg    *string
+	LastActiveTS gomatrixserverlib.Timestamp
+}
+
+// PresenceContent is the content of an m.presence event sent to clients,
+// and the response of GET /presence/{userID}/status.
+type PresenceContent struct {
+	Presence        eduAPI.Presence `json:"presence"`
+	StatusMsg       *string         `json:"status_msg,omitempty"`
+	LastActiveAgo   int64           `json:"last_active_ago,omitempty"`
+	CurrentlyActive bool            `json:"currently_active"`
+}
+
+// ClientPresence returns the presence as it should be shown to clients at
+// the given time. Users who are online but haven't been active recently
+// are shown as unavailable.
+func (p *PresenceInternal) ClientPresence(now time.Time) PresenceContent {
+	c := PresenceContent{
+		Presence:  p.Presence,
+		StatusMsg: p.StatusMsg,
+	}
+	if p.LastActiveTS == 0 {
+		return c
+	}
+	ago := now.Sub(p.LastActiveTS.Time())
+	if ago < 0 {
+		ago = 0
+	}
+	c.LastActiveAgo = ago.Milliseconds()
+	if p.Presence == eduAPI.PresenceOnline {
+		if ago > PresenceIdleTimeout {
+			c.Presence = eduAPI.PresenceUnavailable
+		} else {
+			c.CurrentlyActive = ago <= PresenceActiveTimeout
+		}
+	}
+	return c
+}
@@ -0,0 +1,58 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	eduAPI "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestClientPresence(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		presence   eduAPI.Presence
+		lastActive time.Duration
+		want       eduAPI.Presence
+		wantActive bool
+	}{
+		{"online and active", eduAPI.PresenceOnline, 10 * time.Second, eduAPI.PresenceOnline, true},
+		{"online but not active", eduAPI.PresenceOnline, 2 * time.Minute, eduAPI.PresenceOnline, false},
+		{"online but idle", eduAPI.PresenceOnline, 10 * time.Minute, eduAPI.PresenceUnavailable, false},
+		{"unavailable", eduAPI.PresenceUnavailable, 10 * time.Second, eduAPI.PresenceUnavailable, false},
+		{"offline", eduAPI.PresenceOffline, time.Hour, eduAPI.PresenceOffline, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PresenceInternal{
+				Presence:     tt.presence,
+				LastActiveTS: gomatrixserverlib.AsTimestamp(now.Add(-tt.lastActive)),
+			}
+			got := p.ClientPresence(now)
+			if got.Presence != tt.want {
+				t.Errorf("got presence %q, want %q", got.Presence, tt.want)
+			}
+			if got.CurrentlyActive != tt.wantActive {
+				t.Errorf("got currently_active %v, want %v", got.CurrentlyActive, tt.wantActive)
+			}
+			if want := tt.lastActive.Milliseconds(); got.LastActiveAgo != want {
+				t.Errorf("got last_active_ago %d, want %d", got.LastActiveAgo, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// The orderings that sliding sync lists can be sorted by. Later sorts in a
+// list only break the ties of the earlier ones.
+const (
+	SlidingSortByRecency           = "by_recency"
+	SlidingSortByName              = "by_name"
+	SlidingSortByNotificationCount = "by_notification_count"
+)
+
+// The operations that tell a client how to update its copy of a list.
+const (
+	// SlidingOpSync replaces the rooms in a range of the list.
+	SlidingOpSync = "SYNC"
+	// SlidingOpInvalidate makes the client forget the rooms in a range of the list.
+	SlidingOpInvalidate = "INVALIDATE"
+	// SlidingOpUpdate updates a room in the list without moving it.
+	SlidingOpUpdate = "UPDATE"
+)
+
+// SlidingSyncRequest is the body of a sliding sync request, with which a client
+// subscribes to windows of sorted room lists rather than syncing every room.
+// See https://github.com/matrix-org/matrix-doc/pull/3575
+type SlidingSyncRequest struct {
+	Lists             []SlidingListRequest               `json:"lists"`
+	RoomSubscriptions map[string]SlidingRoomSubscription `json:"room_subscriptions"`
+	UnsubscribeRooms  []string                           `json:"unsubscribe_rooms"`
+}
+
+// SlidingRoomSubscription is what a client wants to know about the rooms in a
+// list, or about a room that it has subscribed to.
+type SlidingRoomSubscription struct {
+	// RequiredState is a list of [event type, state key] pairs, either of
+	// which can be "*" to match anything.
+	RequiredState [][2]string `json:"required_state"`
+	TimelineLimit int         `json:"timeline_limit"`
+}
+
+// SlidingListRequest is a sorted list of the rooms that the user is joined to,
+// of which the client wants to know about the rooms in the given ranges.
+type SlidingListRequest struct {
+	SlidingRoomSubscription
+	// Ranges are inclusive [start, end] indexes into the sorted list.
+	Ranges [][2]int `json:"ranges"`
+	Sort   []string `json:"sort"`
+}
+
+// SlidingSyncResponse is the response to a sliding sync request.
+type SlidingSyncResponse struct {
+	Ops               []SlidingOperation     `json:"ops"`
+	RoomSubscriptions map[string]SlidingRoom `json:"room_subscriptions"`
+	// Counts are the number of rooms in each of the lists.
+	Counts []int  `json:"counts"`
+	Pos    string `json:"pos"`
+}
+
+// NewSlidingSyncResponse creates an empty response with initialised fields.
+func NewSlidingSyncResponse() *SlidingSyncResponse {
+	return &SlidingSyncResponse{
+		Ops:               []SlidingOperation{},
+		RoomSubscriptions: map[string]SlidingRoom{},
+		Counts:            []int{},
+	}
+}
+
+// IsEmpty returns whether the response doesn't tell the client anything new
+// about the rooms in its lists or its room subscriptions.
+func (r *SlidingSyncResponse) IsEmpty() bool {
+	return len(r.Ops) == 0 && len(r.RoomSubscriptions) == 0
+}
+
+// SlidingOperation is a change to a list. SYNC and INVALIDATE operations apply
+// to a range of the list, UPDATE operations to a single index.
+type SlidingOperation struct {
+	List  int           `json:"list"`
+	Op    string        `json:"op"`
+	Range *[2]int       `json:"range,omitempty"`
+	Index *int          `json:"index,omitempty"`
+	Rooms []SlidingRoom `json:"rooms,omitempty"`
+	Room  *SlidingRoom  `json:"room,omitempty"`
+}
+
+// SlidingRoom is what is sent to a client about a room. Initial is set when
+// the client is sent the room for the first time, otherwise the room only
+// contains what changed since the last response.
+type SlidingRoom struct {
+	RoomID            string                          `json:"room_id"`
+	Name              string                          `json:"name,omitempty"`
+	RequiredState     []gomatrixserverlib.ClientEvent `json:"required_state,omitempty"`
+	Timeline          []gomatrixserverlib.ClientEvent `json:"timeline,omitempty"`
+	Limited           bool                            `json:"limited,omitempty"`
+	PrevBatch         *TopologyToken                  `json:"prev_batch,omitempty"`
+	NotificationCount int                             `json:"notification_count"`
+	HighlightCount    int                             `json:"highlight_count"`
+	Initial           bool                            `json:"initial,omitempty"`
+}
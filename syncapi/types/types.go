@@ -95,13 +95,15 @@ const (
 )
 
 type StreamingToken struct {
-	PDUPosition          StreamPosition
-	TypingPosition       StreamPosition
-	ReceiptPosition      StreamPosition
-	SendToDevicePosition StreamPosition
-	InvitePosition       StreamPosition
-	AccountDataPosition  StreamPosition
-	DeviceListPosition   StreamPosition
+	PDUPosition              StreamPosition
+	TypingPosition           StreamPosition
+	ReceiptPosition          StreamPosition
+	SendToDevicePosition     StreamPosition
+	InvitePosition           StreamPosition
+	AccountDataPosition      StreamPosition
+	DeviceListPosition       StreamPosition
+	NotificationDataPosition StreamPosition
+	PresencePosition         StreamPosition
 }
 
 // This will be used as a fallback by json.Marshal.
@@ -117,10 +119,11 @@ func (s *StreamingToken) UnmarshalText(text []byte) (err error) {
 
 func (t StreamingToken) String() string {
 	posStr := fmt.Sprintf(
-		"s%d_%d_%d_%d_%d_%d_%d",
+		"s%d_%d_%d_%d_%d_%d_%d_%d_%d",
 		t.PDUPosition, t.TypingPosition,
 		t.ReceiptPosition, t.SendToDevicePosition,
 		t.InvitePosition, t.AccountDataPosition, t.DeviceListPosition,
+		t.NotificationDataPosition, t.PresencePosition,
 	)
 	return posStr
 }
@@ -142,12 +145,16 @@ func (t *StreamingToken) IsAfter(other StreamingToken) bool {
 		return true
 	case t.DeviceListPosition > other.DeviceListPosition:
 		return true
+	case t.NotificationDataPosition > other.NotificationDataPosition:
+		return true
+	case t.PresencePosition > other.PresencePosition:
+		return true
 	}
 	return false
 }
 
 func (t *StreamingToken) IsEmpty() bool {
-	return t == nil || t.PDUPosition+t.TypingPosition+t.ReceiptPosition+t.SendToDevicePosition+t.InvitePosition+t.AccountDataPosition+t.DeviceListPosition == 0
+	return t == nil || t.PDUPosition+t.TypingPosition+t.ReceiptPosition+t.SendToDevicePosition+t.InvitePosition+t.AccountDataPosition+t.DeviceListPosition+t.NotificationDataPosition+t.PresencePosition == 0
 }
 
 // WithUpdates returns a copy of the StreamingToken with updates applied from another StreamingToken.
@@ -185,6 +192,12 @@ func (t *StreamingToken) ApplyUpdates(other StreamingToken) {
 	if other.DeviceListPosition > t.DeviceListPosition {
 		t.DeviceListPosition = other.DeviceListPosition
 	}
+	if other.NotificationDataPosition > t.NotificationDataPosition {
+		t.NotificationDataPosition = other.NotificationDataPosition
+	}
+	if other.PresencePosition > t.PresencePosition {
+		t.PresencePosition = other.PresencePosition
+	}
 }
 
 type TopologyToken struct {
@@ -213,6 +226,15 @@ func (t TopologyToken) String() string {
 	return fmt.Sprintf("t%d_%d", t.Depth, t.PDUPosition)
 }
 
+// IsBefore returns true if the token is earlier than the other token in the
+// topological ordering, which is by depth and then by stream position.
+func (t TopologyToken) IsBefore(other TopologyToken) bool {
+	if t.Depth != other.Depth {
+		return t.Depth < other.Depth
+	}
+	return t.PDUPosition < other.PDUPosition
+}
+
 // Decrement the topology token to one event earlier.
 func (t *TopologyToken) Decrement() {
 	depth := t.Depth
@@ -277,9 +299,11 @@ func NewStreamTokenFromString(tok string) (token StreamingToken, err error) {
 	// s478_0_0_0_0_13.dl-0-2 but we have now removed partitioned stream positions
 	tok = strings.Split(tok, ".")[0]
 	parts := strings.Split(tok[1:], "_")
-	var positions [7]StreamPosition
+	// Tokens from before the notification data and presence streams were
+	// added have fewer positions, so missing positions are left as zero.
+	var positions [9]StreamPosition
 	for i, p := range parts {
-		if i > len(positions) {
+		if i >= len(positions) {
 			break
 		}
 		var pos int
@@ -291,13 +315,15 @@ func NewStreamTokenFromString(tok string) (token StreamingToken, err error) {
 		positions[i] = StreamPosition(pos)
 	}
 	token = StreamingToken{
-		PDUPosition:          positions[0],
-		TypingPosition:       positions[1],
-		ReceiptPosition:      positions[2],
-		SendToDevicePosition: positions[3],
-		InvitePosition:       positions[4],
-		AccountDataPosition:  positions[5],
-		DeviceListPosition:   positions[6],
+		PDUPosition:              positions[0],
+		TypingPosition:           positions[1],
+		ReceiptPosition:          positions[2],
+		SendToDevicePosition:     positions[3],
+		InvitePosition:           positions[4],
+		AccountDataPosition:      positions[5],
+		DeviceListPosition:       positions[6],
+		NotificationDataPosition: positions[7],
+		PresencePosition:         positions[8],
 	}
 	return token, nil
 }
@@ -322,6 +348,7 @@ type Response struct {
 		Join   map[string]JoinResponse   `json:"join"`
 		Peek   map[string]JoinResponse   `json:"peek"`
 		Invite map[string]InviteResponse `json:"invite"`
+		Knock  map[string]KnockResponse  `json:"knock"`
 		Leave  map[string]LeaveResponse  `json:"leave"`
 	} `json:"rooms"`
 	ToDevice struct {
@@ -342,6 +369,7 @@ func NewResponse() *Response {
 	res.Rooms.Join = map[string]JoinResponse{}
 	res.Rooms.Peek = map[string]JoinResponse{}
 	res.Rooms.Invite = map[string]InviteResponse{}
+	res.Rooms.Knock = map[string]KnockResponse{}
 	res.Rooms.Leave = map[string]LeaveResponse{}
 
 	// Also pre-intialise empty slices or else we'll insert 'null' instead of '[]' for the value.
@@ -361,6 +389,7 @@ func NewResponse() *Response {
 func (r *Response) IsEmpty() bool {
 	return len(r.Rooms.Join) == 0 &&
 		len(r.Rooms.Invite) == 0 &&
+		len(r.Rooms.Knock) == 0 &&
 		len(r.Rooms.Leave) == 0 &&
 		len(r.AccountData.Events) == 0 &&
 		len(r.Presence.Events) == 0 &&
@@ -383,6 +412,13 @@ type JoinResponse struct {
 	AccountData struct {
 		Events []gomatrixserverlib.ClientEvent `json:"events"`
 	} `json:"account_data"`
+	UnreadNotifications *UnreadNotifications `json:"unread_notifications,omitempty"`
+}
+
+// UnreadNotifications are the unread notification counts of a joined room.
+type UnreadNotifications struct {
+	HighlightCount    int `json:"highlight_count"`
+	NotificationCount int `json:"notification_count"`
 }
 
 // NewJoinResponse creates an empty response with initialised arrays.
@@ -425,6 +461,35 @@ func NewInviteResponse(event *gomatrixserverlib.HeaderedEvent) *InviteResponse {
 	return &res
 }
 
+// KnockResponse represents a /sync response for a room which is under the 'knock' key.
+type KnockResponse struct {
+	KnockState struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"knock_state"`
+}
+
+// NewKnockResponse creates an empty response with initialised arrays.
+func NewKnockResponse(event *gomatrixserverlib.HeaderedEvent) *KnockResponse {
+	res := KnockResponse{}
+	res.KnockState.Events = []json.RawMessage{}
+
+	// The roomserver puts the stripped state of the room that was knocked on
+	// into the knock_room_state unsigned key of the knock.
+	if knockRoomState := gjson.GetBytes(event.Unsigned(), "knock_room_state"); knockRoomState.Exists() {
+		_ = json.Unmarshal([]byte(knockRoomState.Raw), &res.KnockState.Events)
+	}
+
+	// Then include the knock event itself, so that clients can see the
+	// user's membership.
+	knockEvent := gomatrixserverlib.ToClientEvent(event.Unwrap(), gomatrixserverlib.FormatSync)
+	knockEvent.Unsigned = nil
+	if ev, err := json.Marshal(knockEvent); err == nil {
+		res.KnockState.Events = append(res.KnockState.Events, ev)
+	}
+
+	return &res
+}
+
 // LeaveResponse represents a /sync response for a room which is under the 'leave' key.
 type LeaveResponse struct {
 	State struct {
@@ -462,3 +527,81 @@ type Peek struct {
 	New     bool
 	Deleted bool
 }
+
+// MembershipChange is a change to the membership of a user in a room.
+type MembershipChange struct {
+	EventID             string
+	Membership          string
+	StreamPosition      StreamPosition
+	TopologicalPosition StreamPosition
+}
+
+// Position returns where the membership change is in the room's topology.
+func (c MembershipChange) Position() TopologyToken {
+	return TopologyToken{Depth: c.TopologicalPosition, PDUPosition: c.StreamPosition}
+}
+
+// SearchResult is an event that matched a full-text search.
+type SearchResult struct {
+	EventID   string
+	RoomID    string
+	StreamPos StreamPosition
+	Rank      float64
+}
+
+// The rel_types of m.relates_to that are aggregated.
+const (
+	RelationAnnotation = "m.annotation"
+	RelationReference  = "m.reference"
+	RelationReplace    = "m.replace"
+	RelationThread     = "m.thread"
+)
+
+// RelationEntry is an event that relates to another event.
+type RelationEntry struct {
+	EventID   string
+	StreamPos StreamPosition
+}
+
+// RelationGroup is a group of the events relating to an event which have the
+// same rel_type, event type and key. Edits are also grouped by their sender,
+// as only those by the original sender count, and each reference is a group
+// of its own.
+type RelationGroup struct {
+	RelatesTo string
+	RelType   string
+	EventType string
+	Key       string
+	// Sender is only set for edits.
+	Sender string
+	Count  int
+	// UserCount is how many of the events in the group were sent by the user
+	// that the relations are being aggregated for.
+	UserCount     int
+	LatestEventID string
+	LatestPos     StreamPosition
+}
+
+// AnnotationCount is the number of annotations, e.g. reactions, of the same
+// type and key that have been made on an event.
+type AnnotationCount struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// RelationAggregations are the aggregated relations to an event, which are
+// bundled into the unsigned m.relations of the event for clients.
+type RelationAggregations struct {
+	Annotations []AnnotationCount
+	// References are the IDs of the events which reference the event, oldest
+	// first.
+	References []string
+	// Replace is the latest edit of the event, if it has been edited.
+	Replace *gomatrixserverlib.HeaderedEvent
+	// ThreadLatest is the latest event in the thread rooted at the event, if
+	// there is one.
+	ThreadLatest                  *gomatrixserverlib.HeaderedEvent
+	ThreadCount                   int
+	ThreadCurrentUserParticipated bool
+}
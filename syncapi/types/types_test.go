@@ -9,10 +9,11 @@ import (
 
 func TestSyncTokens(t *testing.T) {
 	shouldPass := map[string]string{
-		"s4_0_0_0_0_0_0": StreamingToken{4, 0, 0, 0, 0, 0, 0}.String(),
-		"s3_1_0_0_0_0_2": StreamingToken{3, 1, 0, 0, 0, 0, 2}.String(),
-		"s3_1_2_3_5_0_0": StreamingToken{3, 1, 2, 3, 5, 0, 0}.String(),
-		"t3_1":           TopologyToken{3, 1}.String(),
+		"s4_0_0_0_0_0_0_0_0": StreamingToken{4, 0, 0, 0, 0, 0, 0, 0, 0}.String(),
+		"s3_1_0_0_0_0_2_0_0": StreamingToken{3, 1, 0, 0, 0, 0, 2, 0, 0}.String(),
+		"s3_1_2_3_5_0_0_7_0": StreamingToken{3, 1, 2, 3, 5, 0, 0, 7, 0}.String(),
+		"s3_1_2_3_5_0_0_7_9": StreamingToken{3, 1, 2, 3, 5, 0, 0, 7, 9}.String(),
+		"t3_1":               TopologyToken{3, 1}.String(),
 	}
 
 	for a, b := range shouldPass {
@@ -44,6 +45,24 @@ func TestSyncTokens(t *testing.T) {
 	}
 }
 
+func TestSyncTokensWithMissingPositions(t *testing.T) {
+	// Tokens issued before the notification data and presence positions
+	// were added must still be accepted.
+	tests := map[string]StreamingToken{
+		"s3_1_2_3_5_0_4":   {3, 1, 2, 3, 5, 0, 4, 0, 0},
+		"s3_1_2_3_5_0_4_6": {3, 1, 2, 3, 5, 0, 4, 6, 0},
+	}
+	for s, want := range tests {
+		tok, err := NewStreamTokenFromString(s)
+		if err != nil {
+			t.Fatalf("NewStreamTokenFromString %q failed: %v", s, err)
+		}
+		if tok != want {
+			t.Errorf("NewStreamTokenFromString %q: got %+v, want %+v", s, tok, want)
+		}
+	}
+}
+
 func TestNewInviteResponse(t *testing.T) {
 	event := `{"auth_events":["$SbSsh09j26UAXnjd3RZqf2lyA3Kw2sY_VZJVZQAV9yA","$EwL53onrLwQ5gL8Dv3VrOOCvHiueXu2ovLdzqkNi3lo","$l2wGmz9iAwevBDGpHT_xXLUA5O8BhORxWIGU1cGi1ZM","$GsWFJLXgdlF5HpZeyWkP72tzXYWW3uQ9X28HBuTztHE"],"content":{"avatar_url":"","displayname":"neilalexander","membership":"invite"},"depth":9,"hashes":{"sha256":"8p+Ur4f8vLFX6mkIXhxI0kegPG7X3tWy56QmvBkExAg"},"origin":"matrix.org","origin_server_ts":1602087113066,"prev_events":["$1v-O6tNwhOZcA8bvCYY-Dnj1V2ZDE58lLPxtlV97S28"],"prev_state":[],"room_id":"!XbeXirGWSPXbEaGokF:matrix.org","sender":"@neilalexander:matrix.org","signatures":{"dendrite.neilalexander.dev":{"ed25519:BMJi":"05KQ5lPw0cSFsE4A0x1z7vi/3cc8bG4WHUsFWYkhxvk/XkXMGIYAYkpNThIvSeLfdcHlbm/k10AsBSKH8Uq4DA"},"matrix.org":{"ed25519:a_RXGa":"jeovuHr9E/x0sHbFkdfxDDYV/EyoeLi98douZYqZ02iYddtKhfB7R3WLay/a+D3V3V7IW0FUmPh/A404x5sYCw"}},"state_key":"@neilalexander:dendrite.neilalexander.dev","type":"m.room.member","unsigned":{"age":2512,"invite_room_state":[{"content":{"join_rule":"invite"},"sender":"@neilalexander:matrix.org","state_key":"","type":"m.room.join_rules"},{"content":{"avatar_url":"mxc://matrix.org/BpDaozLwgLnlNStxDxvLzhPr","displayname":"neilalexander","membership":"join"},"sender":"@neilalexander:matrix.org","state_key":"@neilalexander:matrix.org","type":"m.room.member"},{"content":{"name":"Test room"},"sender":"@neilalexander:matrix.org","state_key":"","type":"m.room.name"}]},"_room_version":"5"}`
 	expected := `{"invite_state":{"events":[{"content":{"join_rule":"invite"},"sender":"@neilalexander:matrix.org","state_key":"","type":"m.room.join_rules"},{"content":{"avatar_url":"mxc://matrix.org/BpDaozLwgLnlNStxDxvLzhPr","displayname":"neilalexander","membership":"join"},"sender":"@neilalexander:matrix.org","state_key":"@neilalexander:matrix.org","type":"m.room.member"},{"content":{"name":"Test room"},"sender":"@neilalexander:matrix.org","state_key":"","type":"m.room.name"},{"content":{"avatar_url":"","displayname":"neilalexander","membership":"invite"},"event_id":"$GQmw8e8-26CQv1QuFoHBHpKF1hQj61Flg3kvv_v_XWs","origin_server_ts":1602087113066,"sender":"@neilalexander:matrix.org","state_key":"@neilalexander:dendrite.neilalexander.dev","type":"m.room.member"}]}}`
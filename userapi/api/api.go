@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/gomatrixserverlib"
 )
 
@@ -29,13 +30,19 @@ type UserInternalAPI interface {
 	InputAccountData(ctx context.Context, req *InputAccountDataRequest, res *InputAccountDataResponse) error
 	PerformAccountCreation(ctx context.Context, req *PerformAccountCreationRequest, res *PerformAccountCreationResponse) error
 	PerformPasswordUpdate(ctx context.Context, req *PerformPasswordUpdateRequest, res *PerformPasswordUpdateResponse) error
+	PerformGuestUpgrade(ctx context.Context, req *PerformGuestUpgradeRequest, res *PerformGuestUpgradeResponse) error
+	PerformAccountTypeUpdate(ctx context.Context, req *PerformAccountTypeUpdateRequest, res *PerformAccountTypeUpdateResponse) error
 	PerformDeviceCreation(ctx context.Context, req *PerformDeviceCreationRequest, res *PerformDeviceCreationResponse) error
 	PerformDeviceDeletion(ctx context.Context, req *PerformDeviceDeletionRequest, res *PerformDeviceDeletionResponse) error
+	PerformTokenRefresh(ctx context.Context, req *PerformTokenRefreshRequest, res *PerformTokenRefreshResponse) error
 	PerformLastSeenUpdate(ctx context.Context, req *PerformLastSeenUpdateRequest, res *PerformLastSeenUpdateResponse) error
 	PerformDeviceUpdate(ctx context.Context, req *PerformDeviceUpdateRequest, res *PerformDeviceUpdateResponse) error
 	PerformAccountDeactivation(ctx context.Context, req *PerformAccountDeactivationRequest, res *PerformAccountDeactivationResponse) error
 	PerformOpenIDTokenCreation(ctx context.Context, req *PerformOpenIDTokenCreationRequest, res *PerformOpenIDTokenCreationResponse) error
 	PerformKeyBackup(ctx context.Context, req *PerformKeyBackupRequest, res *PerformKeyBackupResponse) error
+	PerformPushRulesPut(ctx context.Context, req *PerformPushRulesPutRequest, res *PerformPushRulesPutResponse) error
+	PerformPusherSet(ctx context.Context, req *PerformPusherSetRequest, res *PerformPusherSetResponse) error
+	PerformPusherDeletion(ctx context.Context, req *PerformPusherDeletionRequest, res *PerformPusherDeletionResponse) error
 	QueryKeyBackup(ctx context.Context, req *QueryKeyBackupRequest, res *QueryKeyBackupResponse)
 	QueryProfile(ctx context.Context, req *QueryProfileRequest, res *QueryProfileResponse) error
 	QueryAccessToken(ctx context.Context, req *QueryAccessTokenRequest, res *QueryAccessTokenResponse) error
@@ -44,6 +51,13 @@ type UserInternalAPI interface {
 	QueryDeviceInfos(ctx context.Context, req *QueryDeviceInfosRequest, res *QueryDeviceInfosResponse) error
 	QuerySearchProfiles(ctx context.Context, req *QuerySearchProfilesRequest, res *QuerySearchProfilesResponse) error
 	QueryOpenIDToken(ctx context.Context, req *QueryOpenIDTokenRequest, res *QueryOpenIDTokenResponse) error
+	QueryPushRules(ctx context.Context, req *QueryPushRulesRequest, res *QueryPushRulesResponse) error
+	QueryPushers(ctx context.Context, req *QueryPushersRequest, res *QueryPushersResponse) error
+	QueryNotifications(ctx context.Context, req *QueryNotificationsRequest, res *QueryNotificationsResponse) error
+	PerformEventReport(ctx context.Context, req *PerformEventReportRequest, res *PerformEventReportResponse) error
+	PerformEventReportResolution(ctx context.Context, req *PerformEventReportResolutionRequest, res *PerformEventReportResolutionResponse) error
+	QueryEventReports(ctx context.Context, req *QueryEventReportsRequest, res *QueryEventReportsResponse) error
+	QueryEventReport(ctx context.Context, req *QueryEventReportRequest, res *QueryEventReportResponse) error
 }
 
 type PerformKeyBackupRequest struct {
@@ -184,6 +198,9 @@ type QueryAccessTokenRequest struct {
 type QueryAccessTokenResponse struct {
 	Device *Device
 	Err    string // e.g ErrorForbidden
+	// Expired is true if the access token was valid but has expired. The
+	// device is not returned in that case.
+	Expired bool
 }
 
 // QueryAccountDataRequest is the request for QueryAccountData
@@ -268,6 +285,32 @@ type PerformPasswordUpdateResponse struct {
 	Account         *Account
 }
 
+// PerformGuestUpgradeRequest is the request for PerformGuestUpgrade
+type PerformGuestUpgradeRequest struct {
+	Localpart string // Required: The localpart of the guest account.
+	Password  string // Required: The password of the upgraded account.
+}
+
+// PerformGuestUpgradeResponse is the response for PerformGuestUpgrade
+type PerformGuestUpgradeResponse struct {
+	// Upgraded is false if there is no guest account with the localpart,
+	// for example because it has already been upgraded.
+	Upgraded bool
+	Account  *Account
+}
+
+// PerformAccountTypeUpdateRequest is the request for PerformAccountTypeUpdate
+type PerformAccountTypeUpdateRequest struct {
+	Localpart   string      // Required: The localpart of the account.
+	AccountType AccountType // Required: Either AccountTypeUser or AccountTypeAdmin.
+}
+
+// PerformAccountTypeUpdateResponse is the response for PerformAccountTypeUpdate
+type PerformAccountTypeUpdateResponse struct {
+	// Updated is false if there is no user or admin account with the localpart.
+	Updated bool
+}
+
 // PerformLastSeenUpdateRequest is the request for PerformLastSeenUpdate.
 type PerformLastSeenUpdateRequest struct {
 	UserID     string
@@ -296,12 +339,32 @@ type PerformDeviceCreationRequest struct {
 	// update for this account. Generally the only reason to do this is if the account
 	// is an appservice account.
 	NoDeviceListUpdate bool
+	// RefreshToken is true if the client asked for a refresh token. One is only
+	// issued if refresh tokens are enabled.
+	RefreshToken bool
 }
 
 // PerformDeviceCreationResponse is the response for PerformDeviceCreation
 type PerformDeviceCreationResponse struct {
 	DeviceCreated bool
 	Device        *Device
+	// The refresh token for the device, if one was issued.
+	RefreshToken string
+}
+
+// PerformTokenRefreshRequest is the request for PerformTokenRefresh
+type PerformTokenRefreshRequest struct {
+	RefreshToken string
+	// The access token that replaces the current one.
+	AccessToken string
+}
+
+// PerformTokenRefreshResponse is the response for PerformTokenRefresh
+type PerformTokenRefreshResponse struct {
+	// Device is nil if the refresh token was not valid.
+	Device *Device
+	// The refresh token that replaces the one in the request.
+	RefreshToken string
 }
 
 // PerformAccountDeactivationRequest is the request for PerformAccountDeactivation
@@ -335,6 +398,190 @@ type QueryOpenIDTokenResponse struct {
 	ExpiresAtMS int64
 }
 
+// PerformPushRulesPutRequest is the request for PerformPushRulesPut
+type PerformPushRulesPutRequest struct {
+	UserID   string                     // required: the user to set push rules for
+	RuleSets *pushrules.AccountRuleSets // required: the complete push rules to store
+}
+
+// PerformPushRulesPutResponse is the response for PerformPushRulesPut
+type PerformPushRulesPutResponse struct {
+}
+
+// QueryPushRulesRequest is the request for QueryPushRules
+type QueryPushRulesRequest struct {
+	UserID string // required: the user to get push rules for
+}
+
+// QueryPushRulesResponse is the response for QueryPushRules
+type QueryPushRulesResponse struct {
+	// The user's push rules, with the server-default rules merged in.
+	RuleSets *pushrules.AccountRuleSets
+}
+
+// PerformPusherSetRequest is the request for PerformPusherSet
+type PerformPusherSetRequest struct {
+	Pusher
+	Localpart string
+	// If true, the pusher is added alongside any existing pushers with the
+	// same push key for other users, otherwise those are removed.
+	Append bool
+}
+
+// PerformPusherSetResponse is the response for PerformPusherSet
+type PerformPusherSetResponse struct {
+}
+
+// PerformPusherDeletionRequest is the request for PerformPusherDeletion
+type PerformPusherDeletionRequest struct {
+	Localpart string
+	AppID     string
+	PushKey   string
+}
+
+// PerformPusherDeletionResponse is the response for PerformPusherDeletion
+type PerformPusherDeletionResponse struct {
+}
+
+// QueryPushersRequest is the request for QueryPushers
+type QueryPushersRequest struct {
+	Localpart string
+}
+
+// QueryPushersResponse is the response for QueryPushers
+type QueryPushersResponse struct {
+	Pushers []Pusher `json:"pushers"`
+}
+
+// Pusher represents a push notification subscriber, as described in
+// https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3pushers
+type Pusher struct {
+	// The ID of the session (access token) which registered the pusher.
+	SessionID         int64                       `json:"session_id,omitempty"`
+	PushKey           string                      `json:"pushkey"`
+	PushKeyTS         gomatrixserverlib.Timestamp `json:"pushkey_ts,omitempty"`
+	Kind              PusherKind                  `json:"kind"`
+	AppID             string                      `json:"app_id"`
+	AppDisplayName    string                      `json:"app_display_name"`
+	DeviceDisplayName string                      `json:"device_display_name"`
+	ProfileTag        string                      `json:"profile_tag"`
+	Language          string                      `json:"lang"`
+	Data              map[string]interface{}      `json:"data"`
+}
+
+// PusherKind is the kind of a pusher, which determines how
+// notifications are delivered.
+type PusherKind string
+
+const (
+	// EmailKind pushers send notifications by email. They are not
+	// currently supported for delivery.
+	EmailKind PusherKind = "email"
+	// HTTPKind pushers send notifications to a push gateway.
+	HTTPKind PusherKind = "http"
+)
+
+// QueryNotificationsRequest is the request for QueryNotifications
+type QueryNotificationsRequest struct {
+	Localpart string `json:"localpart"`
+	// From is the pagination token returned in a previous response.
+	From string `json:"from,omitempty"`
+	// Limit is the maximum number of notifications to return.
+	Limit int `json:"limit,omitempty"`
+	// Only, if set to "highlight", restricts the results to
+	// notifications that highlighted the user.
+	Only string `json:"only,omitempty"`
+}
+
+// QueryNotificationsResponse is the response for QueryNotifications
+type QueryNotificationsResponse struct {
+	// NextToken is empty when there are no more notifications.
+	NextToken     string          `json:"next_token,omitempty"`
+	Notifications []*Notification `json:"notifications"`
+}
+
+// Notification is an event that a user's push rules said they should
+// be notified about, as described in
+// https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3notifications
+type Notification struct {
+	Actions    []*pushrules.Action           `json:"actions"`
+	Event      gomatrixserverlib.ClientEvent `json:"event"`
+	ProfileTag string                        `json:"profile_tag"`
+	Read       bool                          `json:"read"`
+	RoomID     string                        `json:"room_id"`
+	TS         gomatrixserverlib.Timestamp   `json:"ts"`
+}
+
+// EventReport is a report that a user made about an event, e.g. for abuse.
+type EventReport struct {
+	ID      int64  `json:"id"`
+	RoomID  string `json:"room_id"`
+	EventID string `json:"event_id"`
+	// UserID is the user who made the report.
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+	// Score is from -100 (most offensive) to 0 (inoffensive), if given.
+	Score      *int                        `json:"score,omitempty"`
+	ReceivedTS gomatrixserverlib.Timestamp `json:"received_ts"`
+	Resolved   bool                        `json:"resolved"`
+}
+
+// PerformEventReportRequest is the request for PerformEventReport
+type PerformEventReportRequest struct {
+	RoomID  string
+	EventID string
+	UserID  string
+	Reason  string
+	Score   *int
+}
+
+// PerformEventReportResponse is the response for PerformEventReport
+type PerformEventReportResponse struct {
+	ReportID int64
+}
+
+// PerformEventReportResolutionRequest is the request for PerformEventReportResolution
+type PerformEventReportResolutionRequest struct {
+	ReportID int64
+}
+
+// PerformEventReportResolutionResponse is the response for PerformEventReportResolution
+type PerformEventReportResolutionResponse struct {
+	// Found is false if there is no report with the given ID.
+	Found bool
+}
+
+// QueryEventReportsRequest is the request for QueryEventReports
+type QueryEventReportsRequest struct {
+	// RoomID and UserID, if set, only return the reports about that room
+	// or made by that user.
+	RoomID         string
+	UserID         string
+	OnlyUnresolved bool
+	Limit          int
+	Offset         int
+}
+
+// QueryEventReportsResponse is the response for QueryEventReports
+type QueryEventReportsResponse struct {
+	// Reports are newest first.
+	Reports []EventReport
+	// Total is the number of reports matching the request, ignoring the
+	// limit and offset.
+	Total int64
+}
+
+// QueryEventReportRequest is the request for QueryEventReport
+type QueryEventReportRequest struct {
+	ReportID int64
+}
+
+// QueryEventReportResponse is the response for QueryEventReport
+type QueryEventReportResponse struct {
+	// Report is nil if there is no report with the given ID.
+	Report *EventReport
+}
+
 // Device represents a client's device (mobile, web, etc)
 type Device struct {
 	ID     string
@@ -342,6 +589,9 @@ type Device struct {
 	// The access_token granted to this device.
 	// This uniquely identifies the device from all other devices and clients.
 	AccessToken string
+	// When the access token expires, as a unix timestamp (ms resolution),
+	// or 0 if it never expires.
+	AccessTokenExpiresTS int64
 	// The unique ID of the session identified by the access token.
 	// Can be used as a secure substitution in places where data needs to be
 	// associated with access tokens.
@@ -353,6 +603,8 @@ type Device struct {
 	// If the device is for an appservice user,
 	// this is the appservice ID.
 	AppserviceID string
+	// The type of the account that the device belongs to.
+	AccountType AccountType
 }
 
 // Account represents a Matrix account on this home server.
@@ -361,7 +613,7 @@ type Account struct {
 	Localpart    string
 	ServerName   gomatrixserverlib.ServerName
 	AppServiceID string
-	// TODO: Other flags like IsAdmin, IsGuest
+	AccountType  AccountType
 	// TODO: Associations (e.g. with application services)
 }
 
@@ -417,4 +669,6 @@ const (
 	AccountTypeUser AccountType = 1
 	// AccountTypeGuest indicates this is a guest account
 	AccountTypeGuest AccountType = 2
+	// AccountTypeAdmin indicates this is an admin account
+	AccountTypeAdmin AccountType = 3
 )
@@ -79,6 +79,11 @@ func (t *UserInternalAPITrace) PerformKeyBackup(ctx context.Context, req *Perfor
 	util.GetLogger(ctx).Infof("PerformKeyBackup req=%+v res=%+v", js(req), js(res))
 	return err
 }
+func (t *UserInternalAPITrace) PerformPushRulesPut(ctx context.Context, req *PerformPushRulesPutRequest, res *PerformPushRulesPutResponse) error {
+	err := t.Impl.PerformPushRulesPut(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformPushRulesPut req=%+v res=%+v", js(req), js(res))
+	return err
+}
 func (t *UserInternalAPITrace) QueryKeyBackup(ctx context.Context, req *QueryKeyBackupRequest, res *QueryKeyBackupResponse) {
 	t.Impl.QueryKeyBackup(ctx, req, res)
 	util.GetLogger(ctx).Infof("QueryKeyBackup req=%+v res=%+v", js(req), js(res))
@@ -88,6 +93,12 @@ func (t *UserInternalAPITrace) QueryProfile(ctx context.Context, req *QueryProfi
 	util.GetLogger(ctx).Infof("QueryProfile req=%+v res=%+v", js(req), js(res))
 	return err
 }
+func (t *UserInternalAPITrace) PerformTokenRefresh(ctx context.Context, req *PerformTokenRefreshRequest, res *PerformTokenRefreshResponse) error {
+	err := t.Impl.PerformTokenRefresh(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformTokenRefresh req=%+v res=%+v", js(req), js(res))
+	return err
+}
+
 func (t *UserInternalAPITrace) QueryAccessToken(ctx context.Context, req *QueryAccessTokenRequest, res *QueryAccessTokenResponse) error {
 	err := t.Impl.QueryAccessToken(ctx, req, res)
 	util.GetLogger(ctx).Infof("QueryAccessToken req=%+v res=%+v", js(req), js(res))
@@ -118,6 +129,61 @@ func (t *UserInternalAPITrace) QueryOpenIDToken(ctx context.Context, req *QueryO
 	util.GetLogger(ctx).Infof("QueryOpenIDToken req=%+v res=%+v", js(req), js(res))
 	return err
 }
+func (t *UserInternalAPITrace) QueryPushRules(ctx context.Context, req *QueryPushRulesRequest, res *QueryPushRulesResponse) error {
+	err := t.Impl.QueryPushRules(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryPushRules req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformPusherSet(ctx context.Context, req *PerformPusherSetRequest, res *PerformPusherSetResponse) error {
+	err := t.Impl.PerformPusherSet(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformPusherSet req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformPusherDeletion(ctx context.Context, req *PerformPusherDeletionRequest, res *PerformPusherDeletionResponse) error {
+	err := t.Impl.PerformPusherDeletion(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformPusherDeletion req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryPushers(ctx context.Context, req *QueryPushersRequest, res *QueryPushersResponse) error {
+	err := t.Impl.QueryPushers(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryPushers req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryNotifications(ctx context.Context, req *QueryNotificationsRequest, res *QueryNotificationsResponse) error {
+	err := t.Impl.QueryNotifications(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryNotifications req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformEventReport(ctx context.Context, req *PerformEventReportRequest, res *PerformEventReportResponse) error {
+	err := t.Impl.PerformEventReport(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformEventReport req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformGuestUpgrade(ctx context.Context, req *PerformGuestUpgradeRequest, res *PerformGuestUpgradeResponse) error {
+	err := t.Impl.PerformGuestUpgrade(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformGuestUpgrade req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformAccountTypeUpdate(ctx context.Context, req *PerformAccountTypeUpdateRequest, res *PerformAccountTypeUpdateResponse) error {
+	err := t.Impl.PerformAccountTypeUpdate(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformAccountTypeUpdate req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformEventReportResolution(ctx context.Context, req *PerformEventReportResolutionRequest, res *PerformEventReportResolutionResponse) error {
+	err := t.Impl.PerformEventReportResolution(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformEventReportResolution req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryEventReports(ctx context.Context, req *QueryEventReportsRequest, res *QueryEventReportsResponse) error {
+	err := t.Impl.QueryEventReports(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryEventReports req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryEventReport(ctx context.Context, req *QueryEventReportRequest, res *QueryEventReportResponse) error {
+	err := t.Impl.QueryEventReport(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryEventReport req=%+v res=%+v", js(req), js(res))
+	return err
+}
 
 func js(thing interface{}) string {
 	b, err := json.Marshal(thing)
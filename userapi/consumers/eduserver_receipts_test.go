@@ -0,0 +1,136 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	eduapi "github.com/matrix-org/dendrite/eduserver/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/test"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/producers"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// receiptRoomserverAPI knows about a fixed set of events.
+type receiptRoomserverAPI struct {
+	rsapi.RoomserverInternalAPI
+	events map[string]*gomatrixserverlib.HeaderedEvent
+}
+
+func (r *receiptRoomserverAPI) QueryEventsByID(ctx context.Context, req *rsapi.QueryEventsByIDRequest, res *rsapi.QueryEventsByIDResponse) error {
+	for _, eventID := range req.EventIDs {
+		if ev, ok := r.events[eventID]; ok {
+			res.Events = append(res.Events, ev)
+		}
+	}
+	return nil
+}
+
+// receiptJetStream records the notification data sent to the sync API.
+type receiptJetStream struct {
+	nats.JetStreamContext
+	sent []eventutil.NotificationData
+}
+
+func (js *receiptJetStream) PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	var data eventutil.NotificationData
+	if err := json.Unmarshal(m.Data, &data); err != nil {
+		return nil, err
+	}
+	js.sent = append(js.sent, data)
+	return &nats.PubAck{}, nil
+}
+
+func TestReceiptsClearNotifications(t *testing.T) {
+	ctx := context.Background()
+	alice := "@alice:test"
+	room := test.NewRoom(t, alice)
+	readEvent := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "hello"})
+	readTS := readEvent.OriginServerTS()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, err := accounts.NewDatabase(&config.DatabaseOptions{
+			ConnectionString: test.PrepareDBConnectionString(t, dbType),
+		}, "test", bcrypt.MinCost, config.DefaultOpenIDTokenLifetimeMS)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		for i, ts := range []gomatrixserverlib.Timestamp{readTS - 1000, readTS, readTS + 1000} {
+			if err = db.InsertNotification(ctx, "alice", fmt.Sprintf("$event%d", i), i == 2, &api.Notification{
+				Event:  gomatrixserverlib.HeaderedToClientEvent(readEvent, gomatrixserverlib.FormatAll),
+				RoomID: room.ID,
+				TS:     ts,
+			}); err != nil {
+				t.Fatalf("InsertNotification failed: %s", err)
+			}
+		}
+
+		js := &receiptJetStream{}
+		s := &OutputReceiptEventConsumer{
+			ctx:          ctx,
+			rsAPI:        &receiptRoomserverAPI{events: map[string]*gomatrixserverlib.HeaderedEvent{readEvent.EventID(): readEvent}},
+			db:           db,
+			syncProducer: producers.NewSyncAPI(db, js, "notificationdata"),
+			serverName:   "test",
+		}
+		receive := func(t *testing.T, receipt eduapi.OutputReceiptEvent) {
+			t.Helper()
+			data, err := json.Marshal(receipt)
+			if err != nil {
+				t.Fatalf("failed to marshal receipt: %s", err)
+			}
+			if !s.onMessage(ctx, &nats.Msg{Data: data}) {
+				t.Fatalf("onMessage: got false, want the receipt to be acknowledged")
+			}
+		}
+		assertCounts := func(t *testing.T, wantTotal, wantHighlight int64) {
+			t.Helper()
+			total, highlight, err := db.GetRoomNotificationCounts(ctx, "alice", room.ID)
+			if err != nil {
+				t.Fatalf("GetRoomNotificationCounts failed: %s", err)
+			}
+			if total != wantTotal || highlight != wantHighlight {
+				t.Errorf("got %d unread notifications and %d highlights, want %d and %d", total, highlight, wantTotal, wantHighlight)
+			}
+		}
+		assertCounts(t, 3, 1)
+
+		// Receipts that aren't m.read, or are for remote users, are ignored.
+		receive(t, eduapi.OutputReceiptEvent{UserID: alice, RoomID: room.ID, EventID: readEvent.EventID(), Type: "m.fully_read", Timestamp: readTS + 5000})
+		receive(t, eduapi.OutputReceiptEvent{UserID: "@alice:remote", RoomID: room.ID, EventID: readEvent.EventID(), Type: "m.read", Timestamp: readTS + 5000})
+		assertCounts(t, 3, 1)
+		if len(js.sent) != 0 {
+			t.Errorf("got notification data %+v, want none", js.sent)
+		}
+
+		// A receipt for a known event reads up to that event, regardless of
+		// when the receipt was sent.
+		receive(t, eduapi.OutputReceiptEvent{UserID: alice, RoomID: room.ID, EventID: readEvent.EventID(), Type: "m.read", Timestamp: readTS + 5000})
+		assertCounts(t, 1, 1)
+		if len(js.sent) != 1 || js.sent[0].RoomID != room.ID || js.sent[0].UnreadNotificationCount != 1 || js.sent[0].UnreadHighlightCount != 1 {
+			t.Errorf("got notification data %+v, want 1 notification and 1 highlight in %s", js.sent, room.ID)
+		}
+
+		// A receipt for an event the room server doesn't know reads up to
+		// the time of the receipt.
+		receive(t, eduapi.OutputReceiptEvent{UserID: alice, RoomID: room.ID, EventID: "$unknown", Type: "m.read", Timestamp: readTS + 2000})
+		assertCounts(t, 0, 0)
+		if len(js.sent) != 2 || js.sent[1].UnreadNotificationCount != 0 || js.sent[1].UnreadHighlightCount != 0 {
+			t.Errorf("got notification data %+v, want the counts to be cleared", js.sent)
+		}
+
+		// Nothing is sent to the sync API if no notifications were read.
+		receive(t, eduapi.OutputReceiptEvent{UserID: alice, RoomID: room.ID, EventID: "$unknown", Type: "m.read", Timestamp: readTS + 3000})
+		if len(js.sent) != 2 {
+			t.Errorf("got notification data %+v, want nothing new", js.sent)
+		}
+	})
+}
@@ -0,0 +1,522 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/pushgateway"
+	"github.com/matrix-org/dendrite/internal/pushrules"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/producers"
+	"github.com/matrix-org/dendrite/userapi/storage/accounts"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// pushWorkers is the number of notifications that are sent to push
+	// gateways at the same time.
+	pushWorkers = 8
+	// pushQueueSize is the number of notifications that can wait for a
+	// worker before the consumer stops taking new events.
+	pushQueueSize = 1000
+	// pushDrainTimeout is how long the workers keep sending the waiting
+	// notifications for once Dendrite is shutting down.
+	pushDrainTimeout = 10 * time.Second
+)
+
+// OutputRoomEventConsumer consumes events that originated in the room server
+// and records notifications for them, which are then sent to the pushers
+// of local users.
+type OutputRoomEventConsumer struct {
+	ctx          context.Context
+	process      *process.ProcessContext
+	cfg          *config.UserAPI
+	userAPI      api.UserInternalAPI
+	rsAPI        rsapi.RoomserverInternalAPI
+	db           accounts.Database
+	pgClient     pushgateway.Client
+	syncProducer *producers.SyncAPI
+	jetstream    nats.JetStreamContext
+	durable      string
+	topic        string
+	serverName   gomatrixserverlib.ServerName
+	pushQueue    chan *pushJob
+}
+
+// pushJob is a notification waiting to be sent to a push gateway.
+type pushJob struct {
+	event     *gomatrixserverlib.HeaderedEvent
+	info      *roomInfo
+	userID    string
+	localpart string
+	url       string
+	format    string
+	devices   []*pushgateway.Device
+	unread    int
+}
+
+// NewOutputRoomEventConsumer creates a new OutputRoomEventConsumer. Call Start() to begin consuming from room servers.
+func NewOutputRoomEventConsumer(
+	process *process.ProcessContext,
+	cfg *config.UserAPI,
+	js nats.JetStreamContext,
+	userAPI api.UserInternalAPI,
+	rsAPI rsapi.RoomserverInternalAPI,
+	db accounts.Database,
+	pgClient pushgateway.Client,
+	syncProducer *producers.SyncAPI,
+) *OutputRoomEventConsumer {
+	return &OutputRoomEventConsumer{
+		ctx:          process.Context(),
+		process:      process,
+		cfg:          cfg,
+		userAPI:      userAPI,
+		rsAPI:        rsAPI,
+		db:           db,
+		pgClient:     pgClient,
+		syncProducer: syncProducer,
+		jetstream:    js,
+		topic:        cfg.Matrix.JetStream.TopicFor(jetstream.OutputRoomEvent),
+		durable:      cfg.Matrix.JetStream.Durable("UserAPIRoomServerConsumer"),
+		serverName:   cfg.Matrix.ServerName,
+		pushQueue:    make(chan *pushJob, pushQueueSize),
+	}
+}
+
+// Start consuming from room servers
+func (s *OutputRoomEventConsumer) Start() error {
+	s.startPushWorkers()
+	// Only new events are consumed, so that enabling push on an existing
+	// deployment doesn't send notifications for the whole history.
+	return jetstream.JetStreamConsumer(
+		s.ctx, s.jetstream, s.topic, s.durable, s.onMessage,
+		nats.DeliverNew(), nats.ManualAck(),
+	)
+}
+
+// onMessage is called when the user API receives a new event from the room server output log.
+func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msg *nats.Msg) bool {
+	var output rsapi.OutputEvent
+	if err := json.Unmarshal(msg.Data, &output); err != nil {
+		// If the message was invalid, log it and move on to the next message in the stream
+		log.WithError(err).Errorf("roomserver output log: message parse failure")
+		return true
+	}
+	if output.Type != rsapi.OutputTypeNewRoomEvent {
+		return true
+	}
+
+	event := output.NewRoomEvent.Event
+	if err := s.processEvent(ctx, event); err != nil {
+		// Failures aren't retried, since that could send the same
+		// notification to users that were already notified.
+		log.WithFields(log.Fields{
+			"event_id": event.EventID(),
+			"room_id":  event.RoomID(),
+		}).WithError(err).Error("userapi: failed to send push notifications for event")
+	}
+	return true
+}
+
+// roomInfo is the room state needed to evaluate push rules and to build
+// notifications for an event.
+type roomInfo struct {
+	roomID       string
+	roomName     string
+	roomAlias    string
+	memberCount  int
+	displayNames map[string]string
+	powerLevels  *gomatrixserverlib.PowerLevelContent
+	notifyLevels map[string]int64
+}
+
+func (s *OutputRoomEventConsumer) processEvent(ctx context.Context, event *gomatrixserverlib.HeaderedEvent) error {
+	info, localMembers, err := s.queryRoomInfo(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range localMembers {
+		if userID == event.Sender() {
+			// Users are never notified about their own events.
+			continue
+		}
+		if err := s.notifyLocal(ctx, event, info, userID); err != nil {
+			log.WithFields(log.Fields{
+				"event_id": event.EventID(),
+				"user_id":  userID,
+			}).WithError(err).Error("userapi: failed to notify local user")
+		}
+	}
+	return nil
+}
+
+// queryRoomInfo returns the room state relevant to push rules and
+// the local users who should be considered for notifications: the
+// joined members and, for invites, the invited user.
+func (s *OutputRoomEventConsumer) queryRoomInfo(ctx context.Context, event *gomatrixserverlib.HeaderedEvent) (*roomInfo, []string, error) {
+	info := &roomInfo{
+		roomID:       event.RoomID(),
+		displayNames: map[string]string{},
+		notifyLevels: map[string]int64{"room": 50},
+	}
+
+	var membersRes rsapi.QueryMembershipsForRoomResponse
+	if err := s.rsAPI.QueryMembershipsForRoom(ctx, &rsapi.QueryMembershipsForRoomRequest{
+		RoomID:     event.RoomID(),
+		JoinedOnly: true,
+	}, &membersRes); err != nil {
+		return nil, nil, fmt.Errorf("s.rsAPI.QueryMembershipsForRoom: %w", err)
+	}
+
+	var localMembers []string
+	for _, ev := range membersRes.JoinEvents {
+		if ev.StateKey == nil {
+			continue
+		}
+		userID := *ev.StateKey
+		var content gomatrixserverlib.MemberContent
+		if err := json.Unmarshal(ev.Content, &content); err == nil {
+			info.displayNames[userID] = content.DisplayName
+		}
+		info.memberCount++
+		if s.isLocalUser(userID) {
+			localMembers = append(localMembers, userID)
+		}
+	}
+
+	if event.Type() == gomatrixserverlib.MRoomMember && event.StateKey() != nil {
+		if membership, err := event.Membership(); err == nil && membership == gomatrixserverlib.Invite && s.isLocalUser(*event.StateKey()) {
+			localMembers = append(localMembers, *event.StateKey())
+		}
+	}
+
+	powerLevelsTuple := gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomPowerLevels, StateKey: ""}
+	nameTuple := gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomName, StateKey: ""}
+	canonicalTuple := gomatrixserverlib.StateKeyTuple{EventType: gomatrixserverlib.MRoomCanonicalAlias, StateKey: ""}
+	var stateRes rsapi.QueryCurrentStateResponse
+	if err := s.rsAPI.QueryCurrentState(ctx, &rsapi.QueryCurrentStateRequest{
+		RoomID:      event.RoomID(),
+		StateTuples: []gomatrixserverlib.StateKeyTuple{powerLevelsTuple, nameTuple, canonicalTuple},
+	}, &stateRes); err != nil {
+		return nil, nil, fmt.Errorf("s.rsAPI.QueryCurrentState: %w", err)
+	}
+
+	if ev, ok := stateRes.StateEvents[powerLevelsTuple]; ok && ev != nil {
+		plc, err := gomatrixserverlib.NewPowerLevelContentFromEvent(ev.Event)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gomatrixserverlib.NewPowerLevelContentFromEvent: %w", err)
+		}
+		info.powerLevels = &plc
+
+		var notifications struct {
+			Notifications map[string]int64 `json:"notifications"`
+		}
+		if err = json.Unmarshal(ev.Content(), &notifications); err == nil {
+			for key, level := range notifications.Notifications {
+				info.notifyLevels[key] = level
+			}
+		}
+	}
+	if ev, ok := stateRes.StateEvents[nameTuple]; ok && ev != nil {
+		var content struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(ev.Content(), &content); err == nil {
+			info.roomName = content.Name
+		}
+	}
+	if ev, ok := stateRes.StateEvents[canonicalTuple]; ok && ev != nil {
+		var content struct {
+			Alias string `json:"alias"`
+		}
+		if err := json.Unmarshal(ev.Content(), &content); err == nil {
+			info.roomAlias = content.Alias
+		}
+	}
+
+	return info, localMembers, nil
+}
+
+func (s *OutputRoomEventConsumer) isLocalUser(userID string) bool {
+	_, domain, err := gomatrixserverlib.SplitID('@', userID)
+	return err == nil && domain == s.serverName
+}
+
+// notifyLocal evaluates the push rules of a local user for the event
+// and, if they say so, records a notification for the user, updates
+// their unread counts and sends the notification to their pushers.
+func (s *OutputRoomEventConsumer) notifyLocal(ctx context.Context, event *gomatrixserverlib.HeaderedEvent, info *roomInfo, userID string) error {
+	actions, err := s.evaluatePushRules(ctx, event, info, userID)
+	if err != nil {
+		return err
+	}
+	kind, tweaks, err := pushrules.ActionsToTweaks(actions)
+	if err != nil {
+		return err
+	}
+	if kind != pushrules.NotifyAction && kind != pushrules.CoalesceAction {
+		return nil
+	}
+
+	localpart, _, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		return err
+	}
+
+	n := &api.Notification{
+		Actions: actions,
+		Event:   gomatrixserverlib.HeaderedToClientEvent(event, gomatrixserverlib.FormatAll),
+		RoomID:  event.RoomID(),
+		TS:      event.OriginServerTS(),
+	}
+	highlight := pushrules.BoolTweakOr(tweaks, pushrules.HighlightTweak, false)
+	if err = s.db.InsertNotification(ctx, localpart, event.EventID(), highlight, n); err != nil {
+		return fmt.Errorf("s.db.InsertNotification: %w", err)
+	}
+	if err = s.syncProducer.GetAndSendNotificationData(ctx, userID, event.RoomID(), localpart); err != nil {
+		return fmt.Errorf("s.syncProducer.GetAndSendNotificationData: %w", err)
+	}
+	unread, err := s.db.GetNotificationCount(ctx, localpart)
+	if err != nil {
+		return fmt.Errorf("s.db.GetNotificationCount: %w", err)
+	}
+
+	var pushersRes api.QueryPushersResponse
+	if err = s.userAPI.QueryPushers(ctx, &api.QueryPushersRequest{Localpart: localpart}, &pushersRes); err != nil {
+		return fmt.Errorf("s.userAPI.QueryPushers: %w", err)
+	}
+
+	// Pushers are grouped by their gateway URL, so each gateway is
+	// only sent one request per event.
+	devicesByURLAndFormat := map[string]map[string][]*pushgateway.Device{}
+	for _, pusher := range pushersRes.Pushers {
+		if pusher.Kind != api.HTTPKind {
+			continue
+		}
+		url, _ := pusher.Data["url"].(string)
+		if url == "" {
+			continue
+		}
+		format, _ := pusher.Data["format"].(string)
+		data := make(map[string]interface{}, len(pusher.Data))
+		for k, v := range pusher.Data {
+			if k != "url" {
+				data[k] = v
+			}
+		}
+		if devicesByURLAndFormat[url] == nil {
+			devicesByURLAndFormat[url] = map[string][]*pushgateway.Device{}
+		}
+		devicesByURLAndFormat[url][format] = append(devicesByURLAndFormat[url][format], &pushgateway.Device{
+			AppID:     pusher.AppID,
+			Data:      data,
+			PushKey:   pusher.PushKey,
+			PushKeyTS: pusher.PushKeyTS,
+			Tweaks:    tweaks,
+		})
+	}
+
+	for url, devicesByFormat := range devicesByURLAndFormat {
+		for format, devices := range devicesByFormat {
+			// Sending happens in the background, since gateways may be slow
+			// and notifications are retried with a backoff. If the queue is
+			// full then this waits, which holds up the consumer rather than
+			// starting ever more requests.
+			job := &pushJob{
+				event:     event,
+				info:      info,
+				userID:    userID,
+				localpart: localpart,
+				url:       url,
+				format:    format,
+				devices:   devices,
+				unread:    int(unread),
+			}
+			select {
+			case s.pushQueue <- job:
+			case <-s.ctx.Done():
+				return s.ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *OutputRoomEventConsumer) startPushWorkers() {
+	for i := 0; i < pushWorkers; i++ {
+		s.process.ComponentStarted()
+		go s.pushWorker()
+	}
+}
+
+// pushWorker sends the queued notifications to push gateways. When Dendrite
+// shuts down, the notifications that are still queued are sent before the
+// worker stops, for up to pushDrainTimeout.
+func (s *OutputRoomEventConsumer) pushWorker() {
+	defer s.process.ComponentFinished()
+	for {
+		select {
+		case job := <-s.pushQueue:
+			s.notifyHTTP(s.ctx, job)
+		case <-s.ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), pushDrainTimeout)
+			defer cancel()
+			for {
+				select {
+				case job := <-s.pushQueue:
+					s.notifyHTTP(ctx, job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// evaluatePushRules returns the actions of the first of the user's
+// push rules that matches the event.
+func (s *OutputRoomEventConsumer) evaluatePushRules(ctx context.Context, event *gomatrixserverlib.HeaderedEvent, info *roomInfo, userID string) ([]*pushrules.Action, error) {
+	var rulesRes api.QueryPushRulesResponse
+	if err := s.userAPI.QueryPushRules(ctx, &api.QueryPushRulesRequest{UserID: userID}, &rulesRes); err != nil {
+		return nil, fmt.Errorf("s.userAPI.QueryPushRules: %w", err)
+	}
+
+	ec := &ruleSetEvalContext{
+		info:            info,
+		userDisplayName: info.displayNames[userID],
+	}
+	eval := pushrules.NewRuleSetEvaluator(ec, &rulesRes.RuleSets.Global)
+	rule, err := eval.MatchEvent(event.Unwrap())
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, nil
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": event.EventID(),
+		"room_id":  event.RoomID(),
+		"user_id":  userID,
+		"rule_id":  rule.RuleID,
+	}).Trace("Matched a push rule")
+
+	return rule.Actions, nil
+}
+
+// notifyHTTP sends a notification to a push gateway, and removes the
+// pushers of any push keys that the gateway rejected.
+func (s *OutputRoomEventConsumer) notifyHTTP(ctx context.Context, job *pushJob) {
+	event := job.event
+	logger := log.WithFields(log.Fields{
+		"event_id":    event.EventID(),
+		"url":         job.url,
+		"localpart":   job.localpart,
+		"num_devices": len(job.devices),
+	})
+
+	var req pushgateway.NotifyRequest
+	switch job.format {
+	case "event_id_only":
+		req = pushgateway.NotifyRequest{
+			Notification: pushgateway.Notification{
+				Counts:  &pushgateway.Counts{Unread: job.unread},
+				Devices: job.devices,
+				EventID: event.EventID(),
+				RoomID:  event.RoomID(),
+			},
+		}
+
+	default:
+		req = pushgateway.NotifyRequest{
+			Notification: pushgateway.Notification{
+				Content:           event.Content(),
+				Counts:            &pushgateway.Counts{Unread: job.unread},
+				Devices:           job.devices,
+				EventID:           event.EventID(),
+				Priority:          pushgateway.HighPriority,
+				RoomAlias:         job.info.roomAlias,
+				RoomID:            event.RoomID(),
+				RoomName:          job.info.roomName,
+				Sender:            event.Sender(),
+				SenderDisplayName: job.info.displayNames[event.Sender()],
+				Type:              event.Type(),
+			},
+		}
+		if event.Type() == gomatrixserverlib.MRoomMember {
+			if membership, err := event.Membership(); err == nil {
+				req.Notification.Membership = membership
+			}
+			req.Notification.UserIsTarget = event.StateKey() != nil && *event.StateKey() == job.userID
+		}
+	}
+
+	logger.Debugf("Notifying push gateway %s", job.url)
+	var res pushgateway.NotifyResponse
+	if err := s.pgClient.Notify(ctx, job.url, &req, &res); err != nil {
+		logger.WithError(err).Error("failed to notify push gateway")
+		return
+	}
+
+	for _, pushKey := range res.Rejected {
+		for _, device := range job.devices {
+			if device.PushKey != pushKey {
+				continue
+			}
+			// The gateway doesn't know about this push key any more,
+			// so the pusher is removed, as the specification requires.
+			if err := s.userAPI.PerformPusherDeletion(ctx, &api.PerformPusherDeletionRequest{
+				Localpart: job.localpart,
+				AppID:     device.AppID,
+				PushKey:   pushKey,
+			}, &api.PerformPusherDeletionResponse{}); err != nil {
+				logger.WithError(err).Errorf("failed to remove rejected pusher %q", pushKey)
+			}
+		}
+	}
+}
+
+// ruleSetEvalContext implements pushrules.EvaluationContext for a
+// user in a room.
+type ruleSetEvalContext struct {
+	info            *roomInfo
+	userDisplayName string
+}
+
+func (rse *ruleSetEvalContext) UserDisplayName() string { return rse.userDisplayName }
+
+func (rse *ruleSetEvalContext) RoomMemberCount() (int, error) { return rse.info.memberCount, nil }
+
+func (rse *ruleSetEvalContext) HasPowerLevel(userID, levelKey string) (bool, error) {
+	if rse.info.powerLevels == nil {
+		return false, nil
+	}
+	level, ok := rse.info.notifyLevels[levelKey]
+	if !ok {
+		return false, nil
+	}
+	return rse.info.powerLevels.UserLevel(userID) >= level, nil
+}
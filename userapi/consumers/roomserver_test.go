@@ -0,0 +1,103 @@
+package consumers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/pushgateway"
+	"github.com/matrix-org/dendrite/internal/test"
+	"github.com/matrix-org/dendrite/setup/process"
+)
+
+// blockingPushClient counts the notifications sent to it, blocking each of
+// them until release is closed.
+type blockingPushClient struct {
+	release  chan struct{}
+	mu       sync.Mutex
+	inFlight int
+	maxSeen  int
+	sent     int
+}
+
+func (c *blockingPushClient) Notify(ctx context.Context, url string, req *pushgateway.NotifyRequest, resp *pushgateway.NotifyResponse) error {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxSeen {
+		c.maxSeen = c.inFlight
+	}
+	c.mu.Unlock()
+	<-c.release
+	c.mu.Lock()
+	c.inFlight--
+	c.sent++
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *blockingPushClient) counts() (inFlight, maxSeen, sent int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight, c.maxSeen, c.sent
+}
+
+func TestPushWorkers(t *testing.T) {
+	room := test.NewRoom(t, "@alice:test")
+	event := room.CreateAndInsert(t, "@alice:test", "m.room.message", map[string]interface{}{"body": "hello"})
+
+	procCtx := process.NewProcessContext()
+	client := &blockingPushClient{release: make(chan struct{})}
+	s := &OutputRoomEventConsumer{
+		ctx:       procCtx.Context(),
+		process:   procCtx,
+		pgClient:  client,
+		pushQueue: make(chan *pushJob, pushQueueSize),
+	}
+	s.startPushWorkers()
+
+	jobs := pushWorkers * 3
+	for i := 0; i < jobs; i++ {
+		s.pushQueue <- &pushJob{
+			event:     event,
+			info:      &roomInfo{roomID: room.ID, displayNames: map[string]string{}},
+			userID:    "@bob:test",
+			localpart: "bob",
+			url:       "https://push.example.com/_matrix/push/v1/notify",
+		}
+	}
+
+	// Only as many notifications as there are workers are sent at once.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		inFlight, _, _ := client.counts()
+		if inFlight == pushWorkers {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d notifications in flight, want %d", inFlight, pushWorkers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, maxSeen, _ := client.counts(); maxSeen != pushWorkers {
+		t.Fatalf("got up to %d notifications in flight, want %d", maxSeen, pushWorkers)
+	}
+
+	// Shutting down sends the notifications that are still queued.
+	procCtx.ShutdownDendrite()
+	close(client.release)
+	done := make(chan struct{})
+	go func() {
+		procCtx.WaitForComponentsToFinish()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("push workers didn't stop")
+	}
+	if _, maxSeen, sent := client.counts(); sent != jobs || maxSeen != pushWorkers {
+		t.Errorf("sent %d notifications with up to %d at once, want %d with up to %d", sent, maxSeen, jobs, pushWorkers)
+	}
+}
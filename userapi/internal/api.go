@@ -20,9 +20,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"time"
 
 	"github.com/matrix-org/dendrite/appservice/types"
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	keyapi "github.com/matrix-org/dendrite/keyserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -34,6 +38,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const pushRulesAccountDataType = "m.push_rules"
+
+// maxNotificationsLimit is the most notifications returned by a single
+// QueryNotifications call.
+const maxNotificationsLimit = 100
+
 type UserInternalAPI struct {
 	AccountDB  accounts.Database
 	DeviceDB   devices.Database
@@ -41,6 +51,7 @@ type UserInternalAPI struct {
 	// AppServices is the list of all registered AS
 	AppServices []config.ApplicationService
 	KeyAPI      keyapi.KeyInternalAPI
+	Config      *config.UserAPI
 }
 
 func (a *UserInternalAPI) InputAccountData(ctx context.Context, req *api.InputAccountDataRequest, res *api.InputAccountDataResponse) error {
@@ -67,7 +78,7 @@ func (a *UserInternalAPI) PerformAccountCreation(ctx context.Context, req *api.P
 		res.Account = acc
 		return nil
 	}
-	acc, err := a.AccountDB.CreateAccount(ctx, req.Localpart, req.Password, req.AppServiceID)
+	acc, err := a.AccountDB.CreateAccount(ctx, req.Localpart, req.Password, req.AppServiceID, req.AccountType)
 	if err != nil {
 		if errors.Is(err, sqlutil.ErrUserExists) { // This account already exists
 			switch req.OnConflict {
@@ -107,18 +118,71 @@ func (a *UserInternalAPI) PerformPasswordUpdate(ctx context.Context, req *api.Pe
 	return nil
 }
 
+func (a *UserInternalAPI) PerformGuestUpgrade(ctx context.Context, req *api.PerformGuestUpgradeRequest, res *api.PerformGuestUpgradeResponse) error {
+	acc, err := a.AccountDB.UpgradeGuestAccount(ctx, req.Localpart, req.Password)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Existing devices keep working, so they must stop being treated as guests.
+	if err = a.DeviceDB.UpdateDevicesAccountType(ctx, req.Localpart, acc.AccountType); err != nil {
+		return err
+	}
+	res.Upgraded = true
+	res.Account = acc
+	return nil
+}
+
+func (a *UserInternalAPI) PerformAccountTypeUpdate(ctx context.Context, req *api.PerformAccountTypeUpdateRequest, res *api.PerformAccountTypeUpdateResponse) error {
+	if req.AccountType != api.AccountTypeUser && req.AccountType != api.AccountTypeAdmin {
+		return fmt.Errorf("PerformAccountTypeUpdate: account type %d can't be set", req.AccountType)
+	}
+	err := a.AccountDB.SetAccountType(ctx, req.Localpart, req.AccountType)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The account type is read from the device on every request, so existing
+	// devices must be updated too for the change to take effect.
+	if err = a.DeviceDB.UpdateDevicesAccountType(ctx, req.Localpart, req.AccountType); err != nil {
+		return err
+	}
+	res.Updated = true
+	return nil
+}
+
 func (a *UserInternalAPI) PerformDeviceCreation(ctx context.Context, req *api.PerformDeviceCreationRequest, res *api.PerformDeviceCreationResponse) error {
 	util.GetLogger(ctx).WithFields(logrus.Fields{
 		"localpart":    req.Localpart,
 		"device_id":    req.DeviceID,
 		"display_name": req.DeviceDisplayName,
 	}).Info("PerformDeviceCreation")
-	dev, err := a.DeviceDB.CreateDevice(ctx, req.Localpart, req.DeviceID, req.AccessToken, req.DeviceDisplayName, req.IPAddr, req.UserAgent)
+	refreshable := req.RefreshToken && a.Config.RefreshableAccessTokenLifetimeMS > 0
+	var refreshToken string
+	if refreshable {
+		var err error
+		if refreshToken, err = generateRefreshToken(); err != nil {
+			return err
+		}
+	}
+	acc, err := a.AccountDB.GetAccountByLocalpart(ctx, req.Localpart)
+	if err != nil {
+		return fmt.Errorf("a.AccountDB.GetAccountByLocalpart: %w", err)
+	}
+	dev, err := a.DeviceDB.CreateDevice(
+		ctx, req.Localpart, req.DeviceID, req.AccessToken, refreshToken, a.accessTokenExpiresTS(refreshable),
+		req.DeviceDisplayName, req.IPAddr, req.UserAgent, acc.AccountType,
+	)
 	if err != nil {
 		return err
 	}
 	res.DeviceCreated = true
 	res.Device = dev
+	res.RefreshToken = refreshToken
 	if req.NoDeviceListUpdate {
 		return nil
 	}
@@ -335,6 +399,11 @@ func (a *UserInternalAPI) QueryAccountData(ctx context.Context, req *api.QueryAc
 		if err != nil {
 			return err
 		}
+		if req.RoomID == "" && req.DataType == pushRulesAccountDataType {
+			if data, err = a.mergePushRules(local, data); err != nil {
+				return err
+			}
+		}
 		res.RoomAccountData = make(map[string]map[string]json.RawMessage)
 		res.GlobalAccountData = make(map[string]json.RawMessage)
 		if data != nil {
@@ -353,11 +422,162 @@ func (a *UserInternalAPI) QueryAccountData(ctx context.Context, req *api.QueryAc
 	if err != nil {
 		return err
 	}
+	if global[pushRulesAccountDataType], err = a.mergePushRules(local, global[pushRulesAccountDataType]); err != nil {
+		return err
+	}
 	res.RoomAccountData = rooms
 	res.GlobalAccountData = global
 	return nil
 }
 
+// mergePushRules returns the given push rules account data with the
+// server-default push rules merged in.
+func (a *UserInternalAPI) mergePushRules(localpart string, data json.RawMessage) (json.RawMessage, error) {
+	var ruleSets pushrules.AccountRuleSets
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &ruleSets); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+	}
+	pushrules.MergeDefaults(&ruleSets, localpart, a.ServerName)
+	ruleSets.Global.Normalize()
+	return json.Marshal(&ruleSets)
+}
+
+func (a *UserInternalAPI) PerformPushRulesPut(ctx context.Context, req *api.PerformPushRulesPutRequest, res *api.PerformPushRulesPutResponse) error {
+	if req.RuleSets == nil {
+		return fmt.Errorf("push rules must not be empty")
+	}
+	data, err := json.Marshal(req.RuleSets)
+	if err != nil {
+		return err
+	}
+	dataReq := api.InputAccountDataRequest{
+		UserID:      req.UserID,
+		DataType:    pushRulesAccountDataType,
+		AccountData: data,
+	}
+	dataRes := api.InputAccountDataResponse{}
+	return a.InputAccountData(ctx, &dataReq, &dataRes)
+}
+
+func (a *UserInternalAPI) QueryPushRules(ctx context.Context, req *api.QueryPushRulesRequest, res *api.QueryPushRulesResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if domain != a.ServerName {
+		return fmt.Errorf("cannot query push rules of remote users: got %s want %s", domain, a.ServerName)
+	}
+	data, err := a.AccountDB.GetAccountDataByType(ctx, local, "", pushRulesAccountDataType)
+	if err != nil {
+		return err
+	}
+	if data, err = a.mergePushRules(local, data); err != nil {
+		return err
+	}
+	var ruleSets pushrules.AccountRuleSets
+	if err = json.Unmarshal(data, &ruleSets); err != nil {
+		return err
+	}
+	res.RuleSets = &ruleSets
+	return nil
+}
+
+func (a *UserInternalAPI) PerformPusherSet(ctx context.Context, req *api.PerformPusherSetRequest, res *api.PerformPusherSetResponse) error {
+	util.GetLogger(ctx).WithFields(logrus.Fields{
+		"localpart":    req.Localpart,
+		"pushkey":      req.Pusher.PushKey,
+		"display_name": req.Pusher.AppDisplayName,
+	}).Info("PerformPusherSet")
+	if !req.Append {
+		// The same push key for other users is removed, as a device can
+		// only have one user logged in at a time.
+		if err := a.AccountDB.RemovePushers(ctx, req.Pusher.AppID, req.Pusher.PushKey); err != nil {
+			return err
+		}
+	}
+	if req.Pusher.PushKeyTS == 0 {
+		req.Pusher.PushKeyTS = gomatrixserverlib.AsTimestamp(time.Now())
+	}
+	return a.AccountDB.UpsertPusher(ctx, req.Pusher, req.Localpart)
+}
+
+func (a *UserInternalAPI) PerformPusherDeletion(ctx context.Context, req *api.PerformPusherDeletionRequest, res *api.PerformPusherDeletionResponse) error {
+	return a.AccountDB.RemovePusher(ctx, req.AppID, req.PushKey, req.Localpart)
+}
+
+func (a *UserInternalAPI) QueryPushers(ctx context.Context, req *api.QueryPushersRequest, res *api.QueryPushersResponse) error {
+	var err error
+	res.Pushers, err = a.AccountDB.GetPushers(ctx, req.Localpart)
+	return err
+}
+
+func (a *UserInternalAPI) PerformEventReport(ctx context.Context, req *api.PerformEventReportRequest, res *api.PerformEventReportResponse) error {
+	util.GetLogger(ctx).WithFields(logrus.Fields{
+		"room_id":  req.RoomID,
+		"event_id": req.EventID,
+		"user_id":  req.UserID,
+	}).Info("PerformEventReport")
+	var err error
+	res.ReportID, err = a.AccountDB.InsertEventReport(ctx, &api.EventReport{
+		RoomID:     req.RoomID,
+		EventID:    req.EventID,
+		UserID:     req.UserID,
+		Reason:     req.Reason,
+		Score:      req.Score,
+		ReceivedTS: gomatrixserverlib.AsTimestamp(time.Now()),
+	})
+	return err
+}
+
+func (a *UserInternalAPI) PerformEventReportResolution(ctx context.Context, req *api.PerformEventReportResolutionRequest, res *api.PerformEventReportResolutionResponse) error {
+	var err error
+	res.Found, err = a.AccountDB.ResolveEventReport(ctx, req.ReportID)
+	return err
+}
+
+func (a *UserInternalAPI) QueryEventReports(ctx context.Context, req *api.QueryEventReportsRequest, res *api.QueryEventReportsResponse) error {
+	var err error
+	res.Reports, res.Total, err = a.AccountDB.GetEventReports(ctx, req.RoomID, req.UserID, req.OnlyUnresolved, req.Limit, req.Offset)
+	return err
+}
+
+func (a *UserInternalAPI) QueryEventReport(ctx context.Context, req *api.QueryEventReportRequest, res *api.QueryEventReportResponse) error {
+	var err error
+	res.Report, err = a.AccountDB.GetEventReport(ctx, req.ReportID)
+	return err
+}
+
+func (a *UserInternalAPI) QueryNotifications(ctx context.Context, req *api.QueryNotificationsRequest, res *api.QueryNotificationsResponse) error {
+	fromID := int64(math.MaxInt64)
+	if req.From != "" {
+		var err error
+		fromID, err = strconv.ParseInt(req.From, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid from token %q: %w", req.From, err)
+		}
+	}
+	if req.Limit <= 0 || req.Limit > maxNotificationsLimit {
+		req.Limit = maxNotificationsLimit
+	}
+
+	notifs, lastID, err := a.AccountDB.GetNotifications(ctx, req.Localpart, fromID, req.Limit, req.Only == "highlight")
+	if err != nil {
+		return err
+	}
+	res.Notifications = notifs
+	if res.Notifications == nil {
+		res.Notifications = []*api.Notification{}
+	}
+	if len(notifs) == req.Limit {
+		// There may be more, so the client should carry on paginating
+		// from the oldest notification returned.
+		res.NextToken = strconv.FormatInt(lastID, 10)
+	}
+	return nil
+}
+
 func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAccessTokenRequest, res *api.QueryAccessTokenResponse) error {
 	if req.AppServiceUserID != "" {
 		appServiceDevice, err := a.queryAppServiceToken(ctx, req.AccessToken, req.AppServiceUserID)
@@ -375,6 +595,30 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 		}
 		return err
 	}
+	if device.AccessTokenExpiresTS != 0 && time.Now().UnixNano()/int64(time.Millisecond) >= device.AccessTokenExpiresTS {
+		res.Expired = true
+		return nil
+	}
+	if device.AccountType == 0 {
+		// The device was created before account types were stored with
+		// devices, so look it up once and remember it for next time.
+		localPart, _, err := gomatrixserverlib.SplitID('@', device.UserID)
+		if err != nil {
+			return err
+		}
+		acc, err := a.AccountDB.GetAccountByLocalpart(ctx, localPart)
+		if err == sql.ErrNoRows {
+			// The account is gone, so the token is no good any more.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		device.AccountType = acc.AccountType
+		if err = a.DeviceDB.UpdateDevicesAccountType(ctx, localPart, acc.AccountType); err != nil {
+			util.GetLogger(ctx).WithError(err).Warn("failed to store account type on devices")
+		}
+	}
 	res.Device = device
 	return nil
 }
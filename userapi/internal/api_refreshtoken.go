@@ -0,0 +1,71 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/util"
+)
+
+// The length of generated refresh tokens
+const refreshTokenByteLength = 32
+
+// PerformTokenRefresh swaps a refresh token for a new access token and
+// refresh token. The old ones stop working straight away.
+func (a *UserInternalAPI) PerformTokenRefresh(ctx context.Context, req *api.PerformTokenRefreshRequest, res *api.PerformTokenRefreshResponse) error {
+	util.GetLogger(ctx).Info("PerformTokenRefresh")
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return err
+	}
+	dev, err := a.DeviceDB.RefreshDeviceTokens(ctx, req.RefreshToken, req.AccessToken, refreshToken, a.accessTokenExpiresTS(true))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	res.Device = dev
+	res.RefreshToken = refreshToken
+	return nil
+}
+
+// accessTokenExpiresTS returns when an access token issued now should expire,
+// as a unix timestamp (ms resolution), or 0 if it should never expire.
+func (a *UserInternalAPI) accessTokenExpiresTS(refreshable bool) int64 {
+	lifetimeMS := a.Config.NonRefreshableAccessTokenLifetimeMS
+	if refreshable {
+		lifetimeMS = a.Config.RefreshableAccessTokenLifetimeMS
+	}
+	if lifetimeMS == 0 {
+		return 0
+	}
+	return time.Now().UnixNano()/int64(time.Millisecond) + lifetimeMS
+}
+
+func generateRefreshToken() (string, error) {
+	b := make([]byte, refreshTokenByteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// url-safe no padding
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -28,15 +28,23 @@ import (
 const (
 	InputAccountDataPath = "/userapi/inputAccountData"
 
-	PerformDeviceCreationPath      = "/userapi/performDeviceCreation"
-	PerformAccountCreationPath     = "/userapi/performAccountCreation"
-	PerformPasswordUpdatePath      = "/userapi/performPasswordUpdate"
-	PerformDeviceDeletionPath      = "/userapi/performDeviceDeletion"
-	PerformLastSeenUpdatePath      = "/userapi/performLastSeenUpdate"
-	PerformDeviceUpdatePath        = "/userapi/performDeviceUpdate"
-	PerformAccountDeactivationPath = "/userapi/performAccountDeactivation"
-	PerformOpenIDTokenCreationPath = "/userapi/performOpenIDTokenCreation"
-	PerformKeyBackupPath           = "/userapi/performKeyBackup"
+	PerformDeviceCreationPath        = "/userapi/performDeviceCreation"
+	PerformAccountCreationPath       = "/userapi/performAccountCreation"
+	PerformPasswordUpdatePath        = "/userapi/performPasswordUpdate"
+	PerformDeviceDeletionPath        = "/userapi/performDeviceDeletion"
+	PerformTokenRefreshPath          = "/userapi/performTokenRefresh"
+	PerformLastSeenUpdatePath        = "/userapi/performLastSeenUpdate"
+	PerformDeviceUpdatePath          = "/userapi/performDeviceUpdate"
+	PerformAccountDeactivationPath   = "/userapi/performAccountDeactivation"
+	PerformOpenIDTokenCreationPath   = "/userapi/performOpenIDTokenCreation"
+	PerformKeyBackupPath             = "/userapi/performKeyBackup"
+	PerformPushRulesPutPath          = "/userapi/performPushRulesPut"
+	PerformPusherSetPath             = "/userapi/performPusherSet"
+	PerformPusherDeletionPath        = "/userapi/performPusherDeletion"
+	PerformEventReportPath           = "/userapi/performEventReport"
+	PerformEventReportResolutionPath = "/userapi/performEventReportResolution"
+	PerformGuestUpgradePath          = "/userapi/performGuestUpgrade"
+	PerformAccountTypeUpdatePath     = "/userapi/performAccountTypeUpdate"
 
 	QueryKeyBackupPath      = "/userapi/queryKeyBackup"
 	QueryProfilePath        = "/userapi/queryProfile"
@@ -46,6 +54,11 @@ const (
 	QueryDeviceInfosPath    = "/userapi/queryDeviceInfos"
 	QuerySearchProfilesPath = "/userapi/querySearchProfiles"
 	QueryOpenIDTokenPath    = "/userapi/queryOpenIDToken"
+	QueryPushRulesPath      = "/userapi/queryPushRules"
+	QueryPushersPath        = "/userapi/queryPushers"
+	QueryNotificationsPath  = "/userapi/queryNotifications"
+	QueryEventReportsPath   = "/userapi/queryEventReports"
+	QueryEventReportPath    = "/userapi/queryEventReport"
 )
 
 // NewUserAPIClient creates a UserInternalAPI implemented by talking to a HTTP POST API.
@@ -112,6 +125,18 @@ func (h *httpUserInternalAPI) PerformDeviceCreation(
 	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
 }
 
+func (h *httpUserInternalAPI) PerformTokenRefresh(
+	ctx context.Context,
+	request *api.PerformTokenRefreshRequest,
+	response *api.PerformTokenRefreshResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformTokenRefresh")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformTokenRefreshPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, request, response)
+}
+
 func (h *httpUserInternalAPI) PerformDeviceDeletion(
 	ctx context.Context,
 	request *api.PerformDeviceDeletionRequest,
@@ -249,3 +274,99 @@ func (h *httpUserInternalAPI) QueryKeyBackup(ctx context.Context, req *api.Query
 		res.Error = err.Error()
 	}
 }
+
+func (h *httpUserInternalAPI) PerformPushRulesPut(ctx context.Context, req *api.PerformPushRulesPutRequest, res *api.PerformPushRulesPutResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformPushRulesPut")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformPushRulesPutPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) QueryPushRules(ctx context.Context, req *api.QueryPushRulesRequest, res *api.QueryPushRulesResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryPushRules")
+	defer span.Finish()
+
+	apiURL := h.apiURL + QueryPushRulesPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformPusherSet(ctx context.Context, req *api.PerformPusherSetRequest, res *api.PerformPusherSetResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformPusherSet")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformPusherSetPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformPusherDeletion(ctx context.Context, req *api.PerformPusherDeletionRequest, res *api.PerformPusherDeletionResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformPusherDeletion")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformPusherDeletionPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) QueryPushers(ctx context.Context, req *api.QueryPushersRequest, res *api.QueryPushersResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryPushers")
+	defer span.Finish()
+
+	apiURL := h.apiURL + QueryPushersPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) QueryNotifications(ctx context.Context, req *api.QueryNotificationsRequest, res *api.QueryNotificationsResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryNotifications")
+	defer span.Finish()
+
+	apiURL := h.apiURL + QueryNotificationsPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformEventReport(ctx context.Context, req *api.PerformEventReportRequest, res *api.PerformEventReportResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformEventReport")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformEventReportPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformEventReportResolution(ctx context.Context, req *api.PerformEventReportResolutionRequest, res *api.PerformEventReportResolutionResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformEventReportResolution")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformEventReportResolutionPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformGuestUpgrade(ctx context.Context, req *api.PerformGuestUpgradeRequest, res *api.PerformGuestUpgradeResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformGuestUpgrade")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformGuestUpgradePath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) PerformAccountTypeUpdate(ctx context.Context, req *api.PerformAccountTypeUpdateRequest, res *api.PerformAccountTypeUpdateResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PerformAccountTypeUpdate")
+	defer span.Finish()
+
+	apiURL := h.apiURL + PerformAccountTypeUpdatePath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) QueryEventReports(ctx context.Context, req *api.QueryEventReportsRequest, res *api.QueryEventReportsResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryEventReports")
+	defer span.Finish()
+
+	apiURL := h.apiURL + QueryEventReportsPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
+
+func (h *httpUserInternalAPI) QueryEventReport(ctx context.Context, req *api.QueryEventReportRequest, res *api.QueryEventReportResponse) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "QueryEventReport")
+	defer span.Finish()
+
+	apiURL := h.apiURL + QueryEventReportPath
+	return httputil.PostJSON(ctx, span, h.httpClient, apiURL, req, res)
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
 )
 
 type Database interface {
@@ -32,8 +33,14 @@ type Database interface {
 	// CreateAccount makes a new account with the given login name and password, and creates an empty profile
 	// for this account. If no password is supplied, the account will be a passwordless account. If the
 	// account already exists, it will return nil, ErrUserExists.
-	CreateAccount(ctx context.Context, localpart, plaintextPassword, appserviceID string) (*api.Account, error)
+	CreateAccount(ctx context.Context, localpart, plaintextPassword, appserviceID string, accountType api.AccountType) (*api.Account, error)
 	CreateGuestAccount(ctx context.Context) (*api.Account, error)
+	// UpgradeGuestAccount turns a guest account into a user account with the given password,
+	// keeping its user ID. Returns sql.ErrNoRows if there is no guest account with the localpart.
+	UpgradeGuestAccount(ctx context.Context, localpart, plaintextPassword string) (*api.Account, error)
+	// SetAccountType changes whether an account is a user or admin account. Returns sql.ErrNoRows
+	// if there is no such account with the localpart, since guest accounts must be upgraded instead.
+	SetAccountType(ctx context.Context, localpart string, accountType api.AccountType) error
 	SaveAccountData(ctx context.Context, localpart, roomID, dataType string, content json.RawMessage) error
 	GetAccountData(ctx context.Context, localpart string) (global map[string]json.RawMessage, rooms map[string]map[string]json.RawMessage, err error)
 	// GetAccountDataByType returns account data matching a given
@@ -48,6 +55,9 @@ type Database interface {
 	GetThreePIDsForLocalpart(ctx context.Context, localpart string) (threepids []authtypes.ThreePID, err error)
 	CheckAccountAvailability(ctx context.Context, localpart string) (bool, error)
 	GetAccountByLocalpart(ctx context.Context, localpart string) (*api.Account, error)
+	// GetActiveLocalparts returns the localparts of all accounts that belong to people,
+	// which excludes guests, appservice users and deactivated accounts.
+	GetActiveLocalparts(ctx context.Context) ([]string, error)
 	SearchProfiles(ctx context.Context, searchString string, limit int) ([]authtypes.Profile, error)
 	DeactivateAccount(ctx context.Context, localpart string) (err error)
 	CreateOpenIDToken(ctx context.Context, token, localpart string) (exp int64, err error)
@@ -61,6 +71,32 @@ type Database interface {
 	UpsertBackupKeys(ctx context.Context, version, userID string, uploads []api.InternalKeyBackupSession) (count int64, etag string, err error)
 	GetBackupKeys(ctx context.Context, version, userID, filterRoomID, filterSessionID string) (result map[string]map[string]api.KeyBackupSession, err error)
 	CountBackupKeys(ctx context.Context, version, userID string) (count int64, err error)
+
+	// Pushers
+	UpsertPusher(ctx context.Context, pusher api.Pusher, localpart string) error
+	GetPushers(ctx context.Context, localpart string) ([]api.Pusher, error)
+	RemovePusher(ctx context.Context, appID, pushKey, localpart string) error
+	RemovePushers(ctx context.Context, appID, pushKey string) error
+
+	// Notifications
+	InsertNotification(ctx context.Context, localpart, eventID string, highlight bool, n *api.Notification) error
+	SetNotificationsRead(ctx context.Context, localpart, roomID string, upToTS gomatrixserverlib.Timestamp) (affected bool, err error)
+	GetNotifications(ctx context.Context, localpart string, fromID int64, limit int, highlightOnly bool) ([]*api.Notification, int64, error)
+	GetNotificationCount(ctx context.Context, localpart string) (int64, error)
+	GetRoomNotificationCounts(ctx context.Context, localpart, roomID string) (total int64, highlight int64, err error)
+	DeleteOldReadNotifications(ctx context.Context, beforeTS gomatrixserverlib.Timestamp) (int64, error)
+
+	// Event reports
+	InsertEventReport(ctx context.Context, report *api.EventReport) (int64, error)
+	GetEventReports(ctx context.Context, roomID, userID string, onlyUnresolved bool, limit, offset int) ([]api.EventReport, int64, error)
+	// GetEventReport returns the report with the given ID, or nil if there is no such report.
+	GetEventReport(ctx context.Context, id int64) (*api.EventReport, error)
+	ResolveEventReport(ctx context.Context, id int64) (bool, error)
+
+	// Single sign-on identities
+	// GetLocalpartForSSOIdentity returns the localpart linked to the identity, or an empty string if there is none.
+	GetLocalpartForSSOIdentity(ctx context.Context, idpID, subject string) (string, error)
+	SaveSSOIdentity(ctx context.Context, idpID, subject, localpart string) error
 }
 
 // Err3PIDInUse is the error returned when trying to save an association involving
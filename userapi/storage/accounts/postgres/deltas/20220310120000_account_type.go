@@ -0,0 +1,39 @@
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadAddAccountType(m *sqlutil.Migrations) {
+	m.AddMigration(UpAddAccountType, DownAddAccountType)
+}
+
+// UpAddAccountType adds the account type. Existing guest accounts, which are
+// the ones with a numeric localpart and no password, become guests (2) and
+// every other account becomes a user account (1). Accounts that were made
+// for single sign-on users have no password either, so accounts with a
+// linked SSO identity are never guests.
+func UpAddAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE account_accounts ADD COLUMN IF NOT EXISTS account_type SMALLINT;
+UPDATE account_accounts SET account_type = CASE
+    WHEN COALESCE(password_hash, '') = '' AND appservice_id IS NULL AND localpart ~ '^[0-9]+$'
+        AND localpart NOT IN (SELECT localpart FROM account_sso_identities) THEN 2
+    ELSE 1
+END WHERE account_type IS NULL;
+ALTER TABLE account_accounts ALTER COLUMN account_type SET NOT NULL;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownAddAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE account_accounts DROP COLUMN account_type;")
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
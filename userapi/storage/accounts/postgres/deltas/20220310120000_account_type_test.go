@@ -0,0 +1,120 @@
+package deltas
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func psqlConnectionString() config.DataSource {
+	user := os.Getenv("POSTGRES_USER")
+	if user == "" {
+		user = "dendrite"
+	}
+	dbName := os.Getenv("POSTGRES_DB")
+	if dbName == "" {
+		dbName = "dendrite"
+	}
+	connStr := fmt.Sprintf(
+		"user=%s dbname=%s sslmode=disable", user, dbName,
+	)
+	password := os.Getenv("POSTGRES_PASSWORD")
+	if password != "" {
+		connStr += fmt.Sprintf(" password=%s", password)
+	}
+	host := os.Getenv("POSTGRES_HOST")
+	if host != "" {
+		connStr += fmt.Sprintf(" host=%s", host)
+	}
+	return config.DataSource(connStr)
+}
+
+func TestUpAddAccountType(t *testing.T) {
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString:   psqlConnectionString(),
+		MaxOpenConnections: 1,
+		MaxIdleConnections: 1,
+	})
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		t.Logf("PostgreSQL not available (%s), skipping", err)
+		t.SkipNow()
+	}
+	defer db.Close() // nolint: errcheck
+
+	// The old table is a temporary one which shadows any real table and is
+	// thrown away with the transaction.
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	defer txn.Rollback() // nolint: errcheck
+	_, err = txn.Exec(`CREATE TEMPORARY TABLE account_accounts (
+    localpart TEXT NOT NULL PRIMARY KEY,
+    created_ts BIGINT NOT NULL,
+    password_hash TEXT,
+    appservice_id TEXT,
+    is_deactivated BOOLEAN DEFAULT FALSE
+) ON COMMIT DROP;
+INSERT INTO account_accounts (localpart, created_ts, password_hash, appservice_id) VALUES
+    ('alice', 1, '$2a$10$hash', NULL),
+    ('1234', 1, NULL, NULL),
+    ('5678', 1, '', NULL),
+    ('42', 1, '$2a$10$hash', NULL),
+    ('sso_user', 1, NULL, NULL),
+    ('99', 1, NULL, NULL),
+    ('7', 1, NULL, 'bridge');
+CREATE TEMPORARY TABLE account_sso_identities (
+    idp_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    localpart TEXT NOT NULL,
+    PRIMARY KEY(idp_id, subject)
+) ON COMMIT DROP;
+INSERT INTO account_sso_identities (idp_id, subject, localpart) VALUES ('github', 'octocat', '99');`)
+	if err != nil {
+		t.Fatalf("failed to create the old table: %s", err)
+	}
+	if err = UpAddAccountType(txn); err != nil {
+		t.Fatalf("UpAddAccountType failed: %s", err)
+	}
+
+	want := map[string]int{
+		"alice":    1,
+		"1234":     2,
+		"5678":     2,
+		"42":       1,
+		"sso_user": 1,
+		"99":       1,
+		"7":        1,
+	}
+	rows, err := txn.Query("SELECT localpart, account_type FROM account_accounts")
+	if err != nil {
+		t.Fatalf("failed to select accounts: %s", err)
+	}
+	defer rows.Close() // nolint: errcheck
+	got := map[string]int{}
+	for rows.Next() {
+		var localpart string
+		var accountType int
+		if err = rows.Scan(&localpart, &accountType); err != nil {
+			t.Fatalf("failed to scan: %s", err)
+		}
+		got[localpart] = accountType
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows.Err: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d accounts, want %d", len(got), len(want))
+	}
+	for localpart, accountType := range want {
+		if got[localpart] != accountType {
+			t.Errorf("%s: got account type %d, want %d", localpart, got[localpart], accountType)
+		}
+	}
+}
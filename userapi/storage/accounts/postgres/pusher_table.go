@@ -0,0 +1,155 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const pushersSchema = `
+-- Stores data about pushers, which deliver push notifications for users.
+CREATE TABLE IF NOT EXISTS account_pushers (
+	id BIGSERIAL PRIMARY KEY,
+	-- The Matrix user ID localpart for this pusher
+	localpart TEXT NOT NULL,
+	-- The session ID of the access token which registered the pusher
+	session_id BIGINT DEFAULT NULL,
+	profile_tag TEXT,
+	kind TEXT NOT NULL,
+	app_id TEXT NOT NULL,
+	app_display_name TEXT NOT NULL,
+	device_display_name TEXT NOT NULL,
+	pushkey TEXT NOT NULL,
+	-- When the pushkey was last updated, as a unix timestamp (ms resolution).
+	pushkey_ts_ms BIGINT NOT NULL DEFAULT 0,
+	lang TEXT NOT NULL,
+	-- The pusher data, as a JSON object
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS account_pushers_localpart_idx ON account_pushers(localpart);
+CREATE UNIQUE INDEX IF NOT EXISTS account_pushers_app_id_pushkey_localpart_idx ON account_pushers(app_id, pushkey, localpart);
+`
+
+const insertPusherSQL = "" +
+	"INSERT INTO account_pushers (localpart, session_id, pushkey, pushkey_ts_ms, kind, app_id, app_display_name, device_display_name, profile_tag, lang, data)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)" +
+	" ON CONFLICT (app_id, pushkey, localpart) DO UPDATE SET session_id = $2, pushkey_ts_ms = $4, kind = $5, app_display_name = $7, device_display_name = $8, profile_tag = $9, lang = $10, data = $11"
+
+const selectPushersSQL = "" +
+	"SELECT session_id, pushkey, pushkey_ts_ms, kind, app_id, app_display_name, device_display_name, profile_tag, lang, data FROM account_pushers WHERE localpart = $1"
+
+const deletePusherSQL = "" +
+	"DELETE FROM account_pushers WHERE app_id = $1 AND pushkey = $2 AND localpart = $3"
+
+const deletePushersByAppIDAndPushKeySQL = "" +
+	"DELETE FROM account_pushers WHERE app_id = $1 AND pushkey = $2"
+
+type pushersStatements struct {
+	insertPusherStmt                   *sql.Stmt
+	selectPushersStmt                  *sql.Stmt
+	deletePusherStmt                   *sql.Stmt
+	deletePushersByAppIDAndPushKeyStmt *sql.Stmt
+}
+
+func (s *pushersStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(pushersSchema)
+	if err != nil {
+		return
+	}
+	return sqlutil.StatementList{
+		{&s.insertPusherStmt, insertPusherSQL},
+		{&s.selectPushersStmt, selectPushersSQL},
+		{&s.deletePusherStmt, deletePusherSQL},
+		{&s.deletePushersByAppIDAndPushKeyStmt, deletePushersByAppIDAndPushKeySQL},
+	}.Prepare(db)
+}
+
+// insertPusher creates a new pusher, or updates the existing pusher
+// with the same app ID and push key for the user.
+func (s *pushersStatements) insertPusher(
+	ctx context.Context, txn *sql.Tx, localpart string, pusher *api.Pusher,
+) error {
+	data, err := json.Marshal(pusher.Data)
+	if err != nil {
+		return err
+	}
+	stmt := sqlutil.TxStmt(txn, s.insertPusherStmt)
+	_, err = stmt.ExecContext(
+		ctx, localpart, pusher.SessionID, pusher.PushKey, pusher.PushKeyTS,
+		pusher.Kind, pusher.AppID, pusher.AppDisplayName, pusher.DeviceDisplayName,
+		pusher.ProfileTag, pusher.Language, string(data),
+	)
+	return err
+}
+
+func (s *pushersStatements) selectPushers(
+	ctx context.Context, txn *sql.Tx, localpart string,
+) ([]api.Pusher, error) {
+	pushers := []api.Pusher{}
+	stmt := sqlutil.TxStmt(txn, s.selectPushersStmt)
+	rows, err := stmt.QueryContext(ctx, localpart)
+	if err != nil {
+		return pushers, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectPushers: rows.close() failed")
+
+	for rows.Next() {
+		var pusher api.Pusher
+		var sessionID sql.NullInt64
+		var profileTag sql.NullString
+		var pushKeyTS int64
+		var data string
+		if err = rows.Scan(
+			&sessionID, &pusher.PushKey, &pushKeyTS, &pusher.Kind, &pusher.AppID,
+			&pusher.AppDisplayName, &pusher.DeviceDisplayName, &profileTag,
+			&pusher.Language, &data,
+		); err != nil {
+			return pushers, err
+		}
+		pusher.SessionID = sessionID.Int64
+		pusher.ProfileTag = profileTag.String
+		pusher.PushKeyTS = gomatrixserverlib.Timestamp(pushKeyTS)
+		if err = json.Unmarshal([]byte(data), &pusher.Data); err != nil {
+			return pushers, err
+		}
+		pushers = append(pushers, pusher)
+	}
+
+	return pushers, rows.Err()
+}
+
+func (s *pushersStatements) deletePusher(
+	ctx context.Context, txn *sql.Tx, appID, pushKey, localpart string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deletePusherStmt)
+	_, err := stmt.ExecContext(ctx, appID, pushKey, localpart)
+	return err
+}
+
+func (s *pushersStatements) deletePushersByAppIDAndPushKey(
+	ctx context.Context, txn *sql.Tx, appID, pushKey string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deletePushersByAppIDAndPushKeyStmt)
+	_, err := stmt.ExecContext(ctx, appID, pushKey)
+	return err
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -39,23 +40,31 @@ CREATE TABLE IF NOT EXISTS account_accounts (
     -- Identifies which application service this account belongs to, if any.
     appservice_id TEXT,
     -- If the account is currently active
-    is_deactivated BOOLEAN DEFAULT 0
+    is_deactivated BOOLEAN DEFAULT 0,
+    -- The account type (1 = user, 2 = guest, 3 = admin)
+    account_type SMALLINT NOT NULL
     -- TODO:
-    -- is_guest, is_admin, upgraded_ts, devices, any email reset stuff?
+    -- upgraded_ts, devices, any email reset stuff?
 );
 `
 
 const insertAccountSQL = "" +
-	"INSERT INTO account_accounts(localpart, created_ts, password_hash, appservice_id) VALUES ($1, $2, $3, $4)"
+	"INSERT INTO account_accounts(localpart, created_ts, password_hash, appservice_id, account_type) VALUES ($1, $2, $3, $4, $5)"
 
 const updatePasswordSQL = "" +
 	"UPDATE account_accounts SET password_hash = $1 WHERE localpart = $2"
 
+const upgradeGuestAccountSQL = "" +
+	"UPDATE account_accounts SET password_hash = $1, account_type = $2 WHERE localpart = $3 AND account_type = $4"
+
+const updateAccountTypeSQL = "" +
+	"UPDATE account_accounts SET account_type = $1 WHERE localpart = $2 AND account_type != $3"
+
 const deactivateAccountSQL = "" +
 	"UPDATE account_accounts SET is_deactivated = 1 WHERE localpart = $1"
 
 const selectAccountByLocalpartSQL = "" +
-	"SELECT localpart, appservice_id FROM account_accounts WHERE localpart = $1"
+	"SELECT localpart, appservice_id, account_type FROM account_accounts WHERE localpart = $1"
 
 const selectPasswordHashSQL = "" +
 	"SELECT password_hash FROM account_accounts WHERE localpart = $1 AND is_deactivated = 0"
@@ -63,14 +72,20 @@ const selectPasswordHashSQL = "" +
 const selectNewNumericLocalpartSQL = "" +
 	"SELECT COUNT(localpart) FROM account_accounts"
 
+const selectActiveLocalpartsSQL = "" +
+	"SELECT localpart FROM account_accounts WHERE is_deactivated = 0 AND appservice_id IS NULL AND account_type != $1"
+
 type accountsStatements struct {
 	db                            *sql.DB
 	insertAccountStmt             *sql.Stmt
 	updatePasswordStmt            *sql.Stmt
+	upgradeGuestAccountStmt       *sql.Stmt
+	updateAccountTypeStmt         *sql.Stmt
 	deactivateAccountStmt         *sql.Stmt
 	selectAccountByLocalpartStmt  *sql.Stmt
 	selectPasswordHashStmt        *sql.Stmt
 	selectNewNumericLocalpartStmt *sql.Stmt
+	selectActiveLocalpartsStmt    *sql.Stmt
 	serverName                    gomatrixserverlib.ServerName
 }
 
@@ -85,10 +100,13 @@ func (s *accountsStatements) prepare(db *sql.DB, server gomatrixserverlib.Server
 	return sqlutil.StatementList{
 		{&s.insertAccountStmt, insertAccountSQL},
 		{&s.updatePasswordStmt, updatePasswordSQL},
+		{&s.upgradeGuestAccountStmt, upgradeGuestAccountSQL},
+		{&s.updateAccountTypeStmt, updateAccountTypeSQL},
 		{&s.deactivateAccountStmt, deactivateAccountSQL},
 		{&s.selectAccountByLocalpartStmt, selectAccountByLocalpartSQL},
 		{&s.selectPasswordHashStmt, selectPasswordHashSQL},
 		{&s.selectNewNumericLocalpartStmt, selectNewNumericLocalpartSQL},
+		{&s.selectActiveLocalpartsStmt, selectActiveLocalpartsSQL},
 	}.Prepare(db)
 }
 
@@ -97,15 +115,16 @@ func (s *accountsStatements) prepare(db *sql.DB, server gomatrixserverlib.Server
 // on success.
 func (s *accountsStatements) insertAccount(
 	ctx context.Context, txn *sql.Tx, localpart, hash, appserviceID string,
+	accountType api.AccountType,
 ) (*api.Account, error) {
 	createdTimeMS := time.Now().UnixNano() / 1000000
 	stmt := s.insertAccountStmt
 
 	var err error
 	if appserviceID == "" {
-		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, nil)
+		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, nil, accountType)
 	} else {
-		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, appserviceID)
+		_, err = sqlutil.TxStmt(txn, stmt).ExecContext(ctx, localpart, createdTimeMS, hash, appserviceID, accountType)
 	}
 	if err != nil {
 		return nil, err
@@ -116,6 +135,7 @@ func (s *accountsStatements) insertAccount(
 		UserID:       userutil.MakeUserID(localpart, s.serverName),
 		ServerName:   s.serverName,
 		AppServiceID: appserviceID,
+		AccountType:  accountType,
 	}, nil
 }
 
@@ -126,6 +146,47 @@ func (s *accountsStatements) updatePassword(
 	return
 }
 
+// upgradeGuestAccount turns a guest account into a user account with the
+// given password hash. Returns sql.ErrNoRows if there is no guest account
+// with the localpart.
+func (s *accountsStatements) upgradeGuestAccount(
+	ctx context.Context, txn *sql.Tx, localpart, passwordHash string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.upgradeGuestAccountStmt)
+	res, err := stmt.ExecContext(ctx, passwordHash, api.AccountTypeUser, localpart, api.AccountTypeGuest)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// updateAccountType changes the type of a user or admin account. Returns
+// sql.ErrNoRows if there is no such account with the localpart.
+func (s *accountsStatements) updateAccountType(
+	ctx context.Context, txn *sql.Tx, localpart string, accountType api.AccountType,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateAccountTypeStmt)
+	res, err := stmt.ExecContext(ctx, accountType, localpart, api.AccountTypeGuest)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (s *accountsStatements) deactivateAccount(
 	ctx context.Context, localpart string,
 ) (err error) {
@@ -147,7 +208,7 @@ func (s *accountsStatements) selectAccountByLocalpart(
 	var acc api.Account
 
 	stmt := s.selectAccountByLocalpartStmt
-	err := stmt.QueryRowContext(ctx, localpart).Scan(&acc.Localpart, &appserviceIDPtr)
+	err := stmt.QueryRowContext(ctx, localpart).Scan(&acc.Localpart, &appserviceIDPtr, &acc.AccountType)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			log.WithError(err).Error("Unable to retrieve user from the db")
@@ -174,3 +235,25 @@ func (s *accountsStatements) selectNewNumericLocalpart(
 	err = stmt.QueryRowContext(ctx).Scan(&id)
 	return
 }
+
+// selectActiveLocalparts returns the localparts of all accounts that belong to
+// people, which excludes guests, appservice users and deactivated accounts.
+func (s *accountsStatements) selectActiveLocalparts(
+	ctx context.Context,
+) ([]string, error) {
+	rows, err := s.selectActiveLocalpartsStmt.QueryContext(ctx, api.AccountTypeGuest)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectActiveLocalparts: rows.close() failed")
+
+	var localparts []string
+	for rows.Next() {
+		var localpart string
+		if err = rows.Scan(&localpart); err != nil {
+			return nil, err
+		}
+		localparts = append(localparts, localpart)
+	}
+	return localparts, rows.Err()
+}
@@ -0,0 +1,72 @@
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadAddAccountType(m *sqlutil.Migrations) {
+	m.AddMigration(UpAddAccountType, DownAddAccountType)
+}
+
+// UpAddAccountType adds the account type. Existing guest accounts, which are
+// the ones with a numeric localpart and no password, become guests (2) and
+// every other account becomes a user account (1). Accounts that were made
+// for single sign-on users have no password either, so accounts with a
+// linked SSO identity are never guests.
+func UpAddAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	ALTER TABLE account_accounts RENAME TO account_accounts_tmp;
+CREATE TABLE account_accounts (
+    localpart TEXT NOT NULL PRIMARY KEY,
+    created_ts BIGINT NOT NULL,
+    password_hash TEXT,
+    appservice_id TEXT,
+    is_deactivated BOOLEAN DEFAULT 0,
+    account_type SMALLINT NOT NULL
+);
+INSERT
+    INTO account_accounts (
+      localpart, created_ts, password_hash, appservice_id, is_deactivated, account_type
+    ) SELECT
+        localpart, created_ts, password_hash, appservice_id, is_deactivated,
+        CASE
+            WHEN COALESCE(password_hash, '') = '' AND appservice_id IS NULL
+                AND localpart != '' AND localpart NOT GLOB '*[^0-9]*'
+                AND localpart NOT IN (SELECT localpart FROM account_sso_identities) THEN 2
+            ELSE 1
+        END
+    FROM account_accounts_tmp
+;
+DROP TABLE account_accounts_tmp;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownAddAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	ALTER TABLE account_accounts RENAME TO account_accounts_tmp;
+CREATE TABLE account_accounts (
+    localpart TEXT NOT NULL PRIMARY KEY,
+    created_ts BIGINT NOT NULL,
+    password_hash TEXT,
+    appservice_id TEXT,
+    is_deactivated BOOLEAN DEFAULT 0
+);
+INSERT
+    INTO account_accounts (
+      localpart, created_ts, password_hash, appservice_id, is_deactivated
+    ) SELECT
+        localpart, created_ts, password_hash, appservice_id, is_deactivated
+    FROM account_accounts_tmp
+;
+DROP TABLE account_accounts_tmp;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
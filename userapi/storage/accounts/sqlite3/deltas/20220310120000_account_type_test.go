@@ -0,0 +1,90 @@
+package deltas
+
+import (
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestUpAddAccountType(t *testing.T) {
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: "file::memory:",
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close() // nolint: errcheck
+
+	_, err = db.Exec(`CREATE TABLE account_accounts (
+    localpart TEXT NOT NULL PRIMARY KEY,
+    created_ts BIGINT NOT NULL,
+    password_hash TEXT,
+    appservice_id TEXT,
+    is_deactivated BOOLEAN DEFAULT 0
+);
+INSERT INTO account_accounts (localpart, created_ts, password_hash, appservice_id) VALUES
+    ('alice', 1, '$2a$10$hash', NULL),
+    ('1234', 1, NULL, NULL),
+    ('5678', 1, '', NULL),
+    ('42', 1, '$2a$10$hash', NULL),
+    ('sso_user', 1, NULL, NULL),
+    ('99', 1, NULL, NULL),
+    ('7', 1, NULL, 'bridge');
+CREATE TABLE account_sso_identities (
+    idp_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    localpart TEXT NOT NULL,
+    PRIMARY KEY(idp_id, subject)
+);
+INSERT INTO account_sso_identities (idp_id, subject, localpart) VALUES ('github', 'octocat', '99');`)
+	if err != nil {
+		t.Fatalf("failed to create the old table: %s", err)
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err)
+	}
+	if err = UpAddAccountType(txn); err != nil {
+		t.Fatalf("UpAddAccountType failed: %s", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+
+	want := map[string]int{
+		"alice":    1,
+		"1234":     2,
+		"5678":     2,
+		"42":       1,
+		"sso_user": 1,
+		"99":       1,
+		"7":        1,
+	}
+	rows, err := db.Query("SELECT localpart, account_type FROM account_accounts")
+	if err != nil {
+		t.Fatalf("failed to select accounts: %s", err)
+	}
+	defer rows.Close() // nolint: errcheck
+	got := map[string]int{}
+	for rows.Next() {
+		var localpart string
+		var accountType int
+		if err = rows.Scan(&localpart, &accountType); err != nil {
+			t.Fatalf("failed to scan: %s", err)
+		}
+		got[localpart] = accountType
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("rows.Err: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d accounts, want %d", len(got), len(want))
+	}
+	for localpart, accountType := range want {
+		if got[localpart] != accountType {
+			t.Errorf("%s: got account type %d, want %d", localpart, got[localpart], accountType)
+		}
+	}
+}
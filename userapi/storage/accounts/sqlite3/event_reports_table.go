@@ -0,0 +1,179 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+const eventReportsSchema = `
+-- Stores the reports that users have made about events.
+CREATE TABLE IF NOT EXISTS account_event_reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	-- The user who reported the event
+	user_id TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	-- From -100 (most offensive) to 0 (inoffensive), if the user gave one
+	score INTEGER,
+	-- When the report was received, as a unix timestamp (ms resolution).
+	received_ts BIGINT NOT NULL,
+	-- Whether an admin has dealt with the report
+	resolved BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS account_event_reports_room_id_idx ON account_event_reports(room_id);
+CREATE INDEX IF NOT EXISTS account_event_reports_user_id_idx ON account_event_reports(user_id);
+`
+
+const insertEventReportSQL = "" +
+	"INSERT INTO account_event_reports (room_id, event_id, user_id, reason, score, received_ts)" +
+	" VALUES ($1, $2, $3, $4, $5, $6)"
+
+const selectEventReportsSQL = "" +
+	"SELECT id, room_id, event_id, user_id, reason, score, received_ts, resolved FROM account_event_reports" +
+	" WHERE ($1 = '' OR room_id = $1) AND ($2 = '' OR user_id = $2) AND ($3 = 0 OR resolved = 0)" +
+	" ORDER BY id DESC LIMIT $4 OFFSET $5"
+
+const selectEventReportCountSQL = "" +
+	"SELECT COUNT(*) FROM account_event_reports" +
+	" WHERE ($1 = '' OR room_id = $1) AND ($2 = '' OR user_id = $2) AND ($3 = 0 OR resolved = 0)"
+
+const selectEventReportSQL = "" +
+	"SELECT id, room_id, event_id, user_id, reason, score, received_ts, resolved FROM account_event_reports" +
+	" WHERE id = $1"
+
+const updateEventReportResolvedSQL = "" +
+	"UPDATE account_event_reports SET resolved = 1 WHERE id = $1"
+
+type eventReportsStatements struct {
+	insertEventReportStmt         *sql.Stmt
+	selectEventReportsStmt        *sql.Stmt
+	selectEventReportCountStmt    *sql.Stmt
+	selectEventReportStmt         *sql.Stmt
+	updateEventReportResolvedStmt *sql.Stmt
+}
+
+func (s *eventReportsStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(eventReportsSchema)
+	if err != nil {
+		return
+	}
+	return sqlutil.StatementList{
+		{&s.insertEventReportStmt, insertEventReportSQL},
+		{&s.selectEventReportsStmt, selectEventReportsSQL},
+		{&s.selectEventReportCountStmt, selectEventReportCountSQL},
+		{&s.selectEventReportStmt, selectEventReportSQL},
+		{&s.updateEventReportResolvedStmt, updateEventReportResolvedSQL},
+	}.Prepare(db)
+}
+
+// insertEventReport stores the report and returns its ID.
+func (s *eventReportsStatements) insertEventReport(
+	ctx context.Context, txn *sql.Tx, report *api.EventReport,
+) (id int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.insertEventReportStmt)
+	res, err := stmt.ExecContext(
+		ctx, report.RoomID, report.EventID, report.UserID, report.Reason, report.Score, report.ReceivedTS,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// selectEventReports returns up to limit of the reports, newest first,
+// skipping the first offset of them. The room ID and user ID are optional.
+func (s *eventReportsStatements) selectEventReports(
+	ctx context.Context, txn *sql.Tx, roomID, userID string, onlyUnresolved bool, limit, offset int,
+) ([]api.EventReport, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventReportsStmt)
+	rows, err := stmt.QueryContext(ctx, roomID, userID, onlyUnresolved, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventReports: rows.close() failed")
+
+	var reports []api.EventReport
+	for rows.Next() {
+		var report api.EventReport
+		if err = scanEventReport(rows, &report); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (s *eventReportsStatements) selectEventReportCount(
+	ctx context.Context, txn *sql.Tx, roomID, userID string, onlyUnresolved bool,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventReportCountStmt)
+	err = stmt.QueryRowContext(ctx, roomID, userID, onlyUnresolved).Scan(&count)
+	return
+}
+
+func (s *eventReportsStatements) selectEventReport(
+	ctx context.Context, txn *sql.Tx, id int64,
+) (*api.EventReport, error) {
+	var report api.EventReport
+	stmt := sqlutil.TxStmt(txn, s.selectEventReportStmt)
+	if err := scanEventReport(stmt.QueryRowContext(ctx, id), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// updateEventReportResolved marks the report as resolved. Returns whether
+// the report exists.
+func (s *eventReportsStatements) updateEventReportResolved(
+	ctx context.Context, txn *sql.Tx, id int64,
+) (bool, error) {
+	stmt := sqlutil.TxStmt(txn, s.updateEventReportResolvedStmt)
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func scanEventReport(row interface{ Scan(...interface{}) error }, report *api.EventReport) error {
+	var score sql.NullInt64
+	var receivedTS int64
+	err := row.Scan(
+		&report.ID, &report.RoomID, &report.EventID, &report.UserID,
+		&report.Reason, &score, &receivedTS, &report.Resolved,
+	)
+	if err != nil {
+		return err
+	}
+	if score.Valid {
+		s := int(score.Int64)
+		report.Score = &s
+	}
+	report.ReceivedTS = gomatrixserverlib.Timestamp(receivedTS)
+	return nil
+}
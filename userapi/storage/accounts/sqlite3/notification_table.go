@@ -0,0 +1,186 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+const notificationSchema = `
+-- Stores the notifications that push rules have generated for users.
+CREATE TABLE IF NOT EXISTS account_notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	-- The Matrix user ID localpart for this notification
+	localpart TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	-- The origin_server_ts of the event, as a unix timestamp (ms resolution).
+	ts_ms BIGINT NOT NULL,
+	-- Whether the push rules said the event should be highlighted
+	highlight BOOLEAN NOT NULL,
+	-- The notification, as a JSON object
+	notification_json TEXT NOT NULL,
+	-- Whether the user has sent a read receipt at or after this event
+	read BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS account_notifications_localpart_room_id_idx ON account_notifications(localpart, room_id);
+CREATE INDEX IF NOT EXISTS account_notifications_localpart_id_idx ON account_notifications(localpart, id);
+`
+
+const insertNotificationSQL = "" +
+	"INSERT INTO account_notifications (localpart, room_id, event_id, ts_ms, highlight, notification_json)" +
+	" VALUES ($1, $2, $3, $4, $5, $6)"
+
+const updateNotificationsReadSQL = "" +
+	"UPDATE account_notifications SET read = 1" +
+	" WHERE localpart = $1 AND room_id = $2 AND ts_ms <= $3 AND read = 0"
+
+const selectNotificationsSQL = "" +
+	"SELECT id, read, notification_json FROM account_notifications" +
+	" WHERE localpart = $1 AND id < $2 AND (highlight OR NOT $3)" +
+	" ORDER BY id DESC LIMIT $4"
+
+const selectNotificationCountSQL = "" +
+	"SELECT COUNT(*) FROM account_notifications WHERE localpart = $1 AND read = 0"
+
+const selectRoomNotificationCountsSQL = "" +
+	"SELECT COUNT(*), COALESCE(SUM(CASE WHEN highlight THEN 1 ELSE 0 END), 0) FROM account_notifications" +
+	" WHERE localpart = $1 AND room_id = $2 AND read = 0"
+
+const deleteOldReadNotificationsSQL = "" +
+	"DELETE FROM account_notifications WHERE read = 1 AND ts_ms < $1"
+
+type notificationsStatements struct {
+	insertNotificationStmt           *sql.Stmt
+	updateNotificationsReadStmt      *sql.Stmt
+	selectNotificationsStmt          *sql.Stmt
+	selectNotificationCountStmt      *sql.Stmt
+	selectRoomNotificationCountsStmt *sql.Stmt
+	deleteOldReadNotificationsStmt   *sql.Stmt
+}
+
+func (s *notificationsStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(notificationSchema)
+	if err != nil {
+		return
+	}
+	return sqlutil.StatementList{
+		{&s.insertNotificationStmt, insertNotificationSQL},
+		{&s.updateNotificationsReadStmt, updateNotificationsReadSQL},
+		{&s.selectNotificationsStmt, selectNotificationsSQL},
+		{&s.selectNotificationCountStmt, selectNotificationCountSQL},
+		{&s.selectRoomNotificationCountsStmt, selectRoomNotificationCountsSQL},
+		{&s.deleteOldReadNotificationsStmt, deleteOldReadNotificationsSQL},
+	}.Prepare(db)
+}
+
+// insertNotification stores a notification for the user.
+func (s *notificationsStatements) insertNotification(
+	ctx context.Context, txn *sql.Tx, localpart, eventID string, highlight bool, n *api.Notification,
+) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	stmt := sqlutil.TxStmt(txn, s.insertNotificationStmt)
+	_, err = stmt.ExecContext(ctx, localpart, n.RoomID, eventID, n.TS, highlight, string(data))
+	return err
+}
+
+// updateNotificationsRead marks all of the user's notifications in the
+// room up to and including the given timestamp as read. Returns whether
+// any notifications were changed.
+func (s *notificationsStatements) updateNotificationsRead(
+	ctx context.Context, txn *sql.Tx, localpart, roomID string, upToTS int64,
+) (bool, error) {
+	stmt := sqlutil.TxStmt(txn, s.updateNotificationsReadStmt)
+	res, err := stmt.ExecContext(ctx, localpart, roomID, upToTS)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// selectNotifications returns up to limit of the user's notifications
+// with IDs lower than fromID, newest first.
+func (s *notificationsStatements) selectNotifications(
+	ctx context.Context, txn *sql.Tx, localpart string, fromID int64, limit int, highlightOnly bool,
+) ([]*api.Notification, int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectNotificationsStmt)
+	rows, err := stmt.QueryContext(ctx, localpart, fromID, highlightOnly, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectNotifications: rows.close() failed")
+
+	var notifs []*api.Notification
+	var lastID int64
+	for rows.Next() {
+		var id int64
+		var read bool
+		var data string
+		if err = rows.Scan(&id, &read, &data); err != nil {
+			return notifs, 0, err
+		}
+		var n api.Notification
+		if err = json.Unmarshal([]byte(data), &n); err != nil {
+			return notifs, 0, err
+		}
+		n.Read = read
+		notifs = append(notifs, &n)
+		lastID = id
+	}
+	return notifs, lastID, rows.Err()
+}
+
+func (s *notificationsStatements) selectNotificationCount(
+	ctx context.Context, txn *sql.Tx, localpart string,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectNotificationCountStmt)
+	err = stmt.QueryRowContext(ctx, localpart).Scan(&count)
+	return
+}
+
+func (s *notificationsStatements) selectRoomNotificationCounts(
+	ctx context.Context, txn *sql.Tx, localpart, roomID string,
+) (total, highlight int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomNotificationCountsStmt)
+	err = stmt.QueryRowContext(ctx, localpart, roomID).Scan(&total, &highlight)
+	return
+}
+
+// deleteOldReadNotifications deletes the notifications that have been read
+// for events sent before the given timestamp. Returns how many were deleted.
+func (s *notificationsStatements) deleteOldReadNotifications(
+	ctx context.Context, txn *sql.Tx, beforeTS int64,
+) (int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.deleteOldReadNotificationsStmt)
+	res, err := stmt.ExecContext(ctx, beforeTS)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
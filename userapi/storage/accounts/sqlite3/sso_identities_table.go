@@ -0,0 +1,81 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+const ssoIdentitiesSchema = `
+-- Links the identities that users have at single sign-on identity
+-- providers to their local accounts.
+CREATE TABLE IF NOT EXISTS account_sso_identities (
+	-- The ID of the identity provider, as configured
+	idp_id TEXT NOT NULL,
+	-- The subject that the identity provider gave us for the user
+	subject TEXT NOT NULL,
+	-- The localpart of the Matrix user ID that the identity belongs to
+	localpart TEXT NOT NULL,
+
+	PRIMARY KEY(idp_id, subject)
+);
+
+CREATE INDEX IF NOT EXISTS account_sso_identities_localpart ON account_sso_identities(localpart);
+`
+
+const selectLocalpartForSSOIdentitySQL = "" +
+	"SELECT localpart FROM account_sso_identities WHERE idp_id = $1 AND subject = $2"
+
+const insertSSOIdentitySQL = "" +
+	"INSERT INTO account_sso_identities (idp_id, subject, localpart) VALUES ($1, $2, $3)"
+
+type ssoIdentitiesStatements struct {
+	selectLocalpartForSSOIdentityStmt *sql.Stmt
+	insertSSOIdentityStmt             *sql.Stmt
+}
+
+func (s *ssoIdentitiesStatements) execSchema(db *sql.DB) error {
+	_, err := db.Exec(ssoIdentitiesSchema)
+	return err
+}
+
+func (s *ssoIdentitiesStatements) prepare(db *sql.DB) (err error) {
+	return sqlutil.StatementList{
+		{&s.selectLocalpartForSSOIdentityStmt, selectLocalpartForSSOIdentitySQL},
+		{&s.insertSSOIdentityStmt, insertSSOIdentitySQL},
+	}.Prepare(db)
+}
+
+func (s *ssoIdentitiesStatements) selectLocalpartForSSOIdentity(
+	ctx context.Context, txn *sql.Tx, idpID, subject string,
+) (localpart string, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectLocalpartForSSOIdentityStmt)
+	err = stmt.QueryRowContext(ctx, idpID, subject).Scan(&localpart)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return
+}
+
+func (s *ssoIdentitiesStatements) insertSSOIdentity(
+	ctx context.Context, txn *sql.Tx, idpID, subject, localpart string,
+) (err error) {
+	stmt := sqlutil.TxStmt(txn, s.insertSSOIdentityStmt)
+	_, err = stmt.ExecContext(ctx, idpID, subject, localpart)
+	return
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
@@ -46,6 +47,10 @@ type Database struct {
 	openIDTokens          tokenStatements
 	keyBackupVersions     keyBackupVersionStatements
 	keyBackups            keyBackupStatements
+	pushers               pushersStatements
+	notifications         notificationsStatements
+	eventReports          eventReportsStatements
+	ssoIdentities         ssoIdentitiesStatements
 	serverName            gomatrixserverlib.ServerName
 	bcryptCost            int
 	openIDTokenLifetimeMS int64
@@ -75,8 +80,13 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 	if err = d.accounts.execSchema(db); err != nil {
 		return nil, err
 	}
+	// The account type migration needs to know which accounts are SSO users.
+	if err = d.ssoIdentities.execSchema(db); err != nil {
+		return nil, err
+	}
 	m := sqlutil.NewMigrations()
 	deltas.LoadIsActive(m)
+	deltas.LoadAddAccountType(m)
 	if err = m.RunDeltas(db, dbProperties); err != nil {
 		return nil, err
 	}
@@ -106,6 +116,18 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 	if err = d.keyBackups.prepare(db); err != nil {
 		return nil, err
 	}
+	if err = d.pushers.prepare(db); err != nil {
+		return nil, err
+	}
+	if err = d.notifications.prepare(db); err != nil {
+		return nil, err
+	}
+	if err = d.eventReports.prepare(db); err != nil {
+		return nil, err
+	}
+	if err = d.ssoIdentities.prepare(db); err != nil {
+		return nil, err
+	}
 
 	return d, nil
 }
@@ -170,6 +192,36 @@ func (d *Database) SetPassword(
 	})
 }
 
+// UpgradeGuestAccount turns a guest account into a user account with the given
+// password, keeping its user ID. Returns sql.ErrNoRows if there is no guest
+// account with the localpart.
+func (d *Database) UpgradeGuestAccount(
+	ctx context.Context, localpart, plaintextPassword string,
+) (*api.Account, error) {
+	hash, err := d.hashPassword(plaintextPassword)
+	if err != nil {
+		return nil, err
+	}
+	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.accounts.upgradeGuestAccount(ctx, txn, localpart, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.accounts.selectAccountByLocalpart(ctx, localpart)
+}
+
+// SetAccountType changes whether an account is a user or admin account.
+// Returns sql.ErrNoRows if there is no such account with the localpart,
+// since guest accounts must be upgraded instead.
+func (d *Database) SetAccountType(
+	ctx context.Context, localpart string, accountType api.AccountType,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.accounts.updateAccountType(ctx, txn, localpart, accountType)
+	})
+}
+
 // CreateGuestAccount makes a new guest account and creates an empty profile
 // for this account.
 func (d *Database) CreateGuestAccount(ctx context.Context) (acc *api.Account, err error) {
@@ -191,7 +243,7 @@ func (d *Database) CreateGuestAccount(ctx context.Context) (acc *api.Account, er
 			return err
 		}
 		localpart := strconv.FormatInt(numLocalpart, 10)
-		acc, err = d.createAccount(ctx, txn, localpart, "", "")
+		acc, err = d.createAccount(ctx, txn, localpart, "", "", api.AccountTypeGuest)
 		return err
 	})
 	return acc, err
@@ -201,7 +253,7 @@ func (d *Database) CreateGuestAccount(ctx context.Context) (acc *api.Account, er
 // for this account. If no password is supplied, the account will be a passwordless account. If the
 // account already exists, it will return nil, ErrUserExists.
 func (d *Database) CreateAccount(
-	ctx context.Context, localpart, plaintextPassword, appserviceID string,
+	ctx context.Context, localpart, plaintextPassword, appserviceID string, accountType api.AccountType,
 ) (acc *api.Account, err error) {
 	// Create one account at a time else we can get 'database is locked'.
 	d.profilesMu.Lock()
@@ -211,7 +263,7 @@ func (d *Database) CreateAccount(
 	defer d.accountDatasMu.Unlock()
 	defer d.accountsMu.Unlock()
 	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
-		acc, err = d.createAccount(ctx, txn, localpart, plaintextPassword, appserviceID)
+		acc, err = d.createAccount(ctx, txn, localpart, plaintextPassword, appserviceID, accountType)
 		return err
 	})
 	return
@@ -220,7 +272,7 @@ func (d *Database) CreateAccount(
 // WARNING! This function assumes that the relevant mutexes have already
 // been taken out by the caller (e.g. CreateAccount or CreateGuestAccount).
 func (d *Database) createAccount(
-	ctx context.Context, txn *sql.Tx, localpart, plaintextPassword, appserviceID string,
+	ctx context.Context, txn *sql.Tx, localpart, plaintextPassword, appserviceID string, accountType api.AccountType,
 ) (*api.Account, error) {
 	var err error
 	var account *api.Account
@@ -232,21 +284,18 @@ func (d *Database) createAccount(
 			return nil, err
 		}
 	}
-	if account, err = d.accounts.insertAccount(ctx, txn, localpart, hash, appserviceID); err != nil {
+	if account, err = d.accounts.insertAccount(ctx, txn, localpart, hash, appserviceID, accountType); err != nil {
 		return nil, sqlutil.ErrUserExists
 	}
 	if err = d.profiles.insertProfile(ctx, txn, localpart); err != nil {
 		return nil, err
 	}
-	if err = d.accountDatas.insertAccountData(ctx, txn, localpart, "", "m.push_rules", json.RawMessage(`{
-		"global": {
-			"content": [],
-			"override": [],
-			"room": [],
-			"sender": [],
-			"underride": []
-		}
-	}`)); err != nil {
+	pushRuleSets := pushrules.DefaultAccountRuleSets(localpart, d.serverName)
+	prbs, err := json.Marshal(pushRuleSets)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.accountDatas.insertAccountData(ctx, txn, localpart, "", "m.push_rules", json.RawMessage(prbs)); err != nil {
 		return nil, err
 	}
 	return account, nil
@@ -385,6 +434,12 @@ func (d *Database) GetAccountByLocalpart(ctx context.Context, localpart string,
 	return d.accounts.selectAccountByLocalpart(ctx, localpart)
 }
 
+// GetActiveLocalparts returns the localparts of all accounts that belong to
+// people, which excludes guests, appservice users and deactivated accounts.
+func (d *Database) GetActiveLocalparts(ctx context.Context) ([]string, error) {
+	return d.accounts.selectActiveLocalparts(ctx)
+}
+
 // SearchProfiles returns all profiles where the provided localpart or display name
 // match any part of the profiles in the database.
 func (d *Database) SearchProfiles(ctx context.Context, searchString string, limit int,
@@ -561,3 +616,184 @@ func (d *Database) UpsertBackupKeys(
 	})
 	return
 }
+
+// UpsertPusher creates or updates the pusher with the given app ID and
+// push key for the user.
+func (d *Database) UpsertPusher(
+	ctx context.Context, pusher api.Pusher, localpart string,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.pushers.insertPusher(ctx, txn, localpart, &pusher)
+	})
+}
+
+// GetPushers returns all pushers for the given user.
+func (d *Database) GetPushers(
+	ctx context.Context, localpart string,
+) ([]api.Pusher, error) {
+	return d.pushers.selectPushers(ctx, nil, localpart)
+}
+
+// RemovePusher deletes the user's pusher with the given app ID and push key.
+func (d *Database) RemovePusher(
+	ctx context.Context, appID, pushKey, localpart string,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.pushers.deletePusher(ctx, txn, appID, pushKey, localpart)
+	})
+}
+
+// RemovePushers deletes the pushers with the given app ID and push key
+// for all users.
+func (d *Database) RemovePushers(
+	ctx context.Context, appID, pushKey string,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.pushers.deletePushersByAppIDAndPushKey(ctx, txn, appID, pushKey)
+	})
+}
+
+// InsertNotification stores a notification that was generated for the
+// user by the event.
+func (d *Database) InsertNotification(
+	ctx context.Context, localpart, eventID string, highlight bool, n *api.Notification,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.notifications.insertNotification(ctx, txn, localpart, eventID, highlight, n)
+	})
+}
+
+// SetNotificationsRead marks the user's notifications in the room up to
+// and including the given timestamp as read. Returns whether any
+// notifications were changed.
+func (d *Database) SetNotificationsRead(
+	ctx context.Context, localpart, roomID string, upToTS gomatrixserverlib.Timestamp,
+) (affected bool, err error) {
+	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		affected, err = d.notifications.updateNotificationsRead(ctx, txn, localpart, roomID, int64(upToTS))
+		return err
+	})
+	return
+}
+
+// GetNotifications returns up to limit of the user's notifications older
+// than fromID, newest first, along with the ID of the last one returned.
+func (d *Database) GetNotifications(
+	ctx context.Context, localpart string, fromID int64, limit int, highlightOnly bool,
+) ([]*api.Notification, int64, error) {
+	return d.notifications.selectNotifications(ctx, nil, localpart, fromID, limit, highlightOnly)
+}
+
+// GetNotificationCount returns the number of unread notifications the
+// user has across all rooms.
+func (d *Database) GetNotificationCount(
+	ctx context.Context, localpart string,
+) (int64, error) {
+	return d.notifications.selectNotificationCount(ctx, nil, localpart)
+}
+
+// GetRoomNotificationCounts returns the number of unread notifications
+// and unread highlights the user has in the room.
+func (d *Database) GetRoomNotificationCounts(
+	ctx context.Context, localpart, roomID string,
+) (total int64, highlight int64, err error) {
+	return d.notifications.selectRoomNotificationCounts(ctx, nil, localpart, roomID)
+}
+
+// InsertEventReport stores a report that a user made about an event and
+// returns the ID of the report.
+func (d *Database) InsertEventReport(
+	ctx context.Context, report *api.EventReport,
+) (id int64, err error) {
+	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		id, err = d.eventReports.insertEventReport(ctx, txn, report)
+		return err
+	})
+	return
+}
+
+// GetEventReports returns up to limit of the event reports, newest first,
+// skipping the first offset of them, along with the total number of reports.
+// The room ID and user ID are optional filters.
+func (d *Database) GetEventReports(
+	ctx context.Context, roomID, userID string, onlyUnresolved bool, limit, offset int,
+) ([]api.EventReport, int64, error) {
+	reports, err := d.eventReports.selectEventReports(ctx, nil, roomID, userID, onlyUnresolved, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := d.eventReports.selectEventReportCount(ctx, nil, roomID, userID, onlyUnresolved)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
+
+// GetEventReport returns the event report with the given ID, or nil if
+// there is no such report.
+func (d *Database) GetEventReport(
+	ctx context.Context, id int64,
+) (*api.EventReport, error) {
+	report, err := d.eventReports.selectEventReport(ctx, nil, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return report, err
+}
+
+// ResolveEventReport marks the event report as resolved. Returns whether
+// the report exists.
+func (d *Database) ResolveEventReport(
+	ctx context.Context, id int64,
+) (found bool, err error) {
+	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		found, err = d.eventReports.updateEventReportResolved(ctx, txn, id)
+		return err
+	})
+	return
+}
+
+// ErrSSOIdentityInUse is the error returned when trying to link an identity
+// at an identity provider which is already linked to a local user.
+var ErrSSOIdentityInUse = errors.New("this identity is already linked to an account")
+
+// GetLocalpartForSSOIdentity returns the localpart of the account that the
+// given identity at the identity provider is linked to, or an empty string
+// if the identity isn't linked to an account.
+func (d *Database) GetLocalpartForSSOIdentity(
+	ctx context.Context, idpID, subject string,
+) (string, error) {
+	return d.ssoIdentities.selectLocalpartForSSOIdentity(ctx, nil, idpID, subject)
+}
+
+// SaveSSOIdentity links the given identity at the identity provider to an
+// account. Returns ErrSSOIdentityInUse if the identity is already linked to
+// an account.
+func (d *Database) SaveSSOIdentity(
+	ctx context.Context, idpID, subject, localpart string,
+) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		existing, err := d.ssoIdentities.selectLocalpartForSSOIdentity(ctx, txn, idpID, subject)
+		if err != nil {
+			return err
+		}
+		if existing != "" {
+			return ErrSSOIdentityInUse
+		}
+		return d.ssoIdentities.insertSSOIdentity(ctx, txn, idpID, subject, localpart)
+	})
+}
+
+// DeleteOldReadNotifications deletes the notifications that users have read
+// for events sent before the given timestamp. Returns how many were deleted.
+func (d *Database) DeleteOldReadNotifications(
+	ctx context.Context, beforeTS gomatrixserverlib.Timestamp,
+) (int64, error) {
+	var deleted int64
+	err := d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		var err error
+		deleted, err = d.notifications.deleteOldReadNotifications(ctx, txn, int64(beforeTS))
+		return err
+	})
+	return deleted, err
+}
@@ -28,14 +28,20 @@ type Database interface {
 	// CreateDevice makes a new device associated with the given user ID localpart.
 	// If there is already a device with the same device ID for this user, that access token will be revoked
 	// and replaced with the given accessToken. If the given accessToken is already in use for another device,
-	// an error will be returned.
+	// an error will be returned. The refresh token is optional, and accessTokenExpiresTS is 0 if the access
+	// token never expires. The account type is stored with the device.
 	// If no device ID is given one is generated.
 	// Returns the device on success.
-	CreateDevice(ctx context.Context, localpart string, deviceID *string, accessToken string, displayName *string, ipAddr, userAgent string) (dev *api.Device, returnErr error)
+	CreateDevice(ctx context.Context, localpart string, deviceID *string, accessToken, refreshToken string, accessTokenExpiresTS int64, displayName *string, ipAddr, userAgent string, accountType api.AccountType) (dev *api.Device, returnErr error)
 	UpdateDevice(ctx context.Context, localpart, deviceID string, displayName *string) error
 	UpdateDeviceLastSeen(ctx context.Context, localpart, deviceID, ipAddr string) error
+	// UpdateDevicesAccountType sets the account type stored with all of a user's devices.
+	UpdateDevicesAccountType(ctx context.Context, localpart string, accountType api.AccountType) error
 	RemoveDevice(ctx context.Context, deviceID, localpart string) error
 	RemoveDevices(ctx context.Context, localpart string, devices []string) error
+	// RefreshDeviceTokens replaces the access token and refresh token of the device that
+	// the refresh token was granted to. Returns sql.ErrNoRows if the refresh token is unknown.
+	RefreshDeviceTokens(ctx context.Context, refreshToken, newAccessToken, newRefreshToken string, accessTokenExpiresTS int64) (*api.Device, error)
 	// RemoveAllDevices deleted all devices for this user. Returns the devices deleted.
 	RemoveAllDevices(ctx context.Context, localpart, exceptDeviceID string) (devices []api.Device, err error)
 
@@ -0,0 +1,34 @@
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadRefreshTokens(m *sqlutil.Migrations) {
+	m.AddMigration(UpRefreshTokens, DownRefreshTokens)
+}
+
+// UpRefreshTokens adds refresh tokens and access token expiry. Existing
+// access tokens never expire.
+func UpRefreshTokens(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE device_devices ADD COLUMN IF NOT EXISTS refresh_token TEXT UNIQUE;
+ALTER TABLE device_devices ADD COLUMN IF NOT EXISTS access_token_expires_ts BIGINT NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownRefreshTokens(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE device_devices DROP COLUMN refresh_token;
+ALTER TABLE device_devices DROP COLUMN access_token_expires_ts;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
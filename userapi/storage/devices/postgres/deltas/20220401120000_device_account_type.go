@@ -0,0 +1,32 @@
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadDeviceAccountType(m *sqlutil.Migrations) {
+	m.AddMigration(UpDeviceAccountType, DownDeviceAccountType)
+}
+
+// UpDeviceAccountType stores the account type with each device. Existing
+// devices get 0 and have it filled in the first time their token is used.
+func UpDeviceAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE device_devices ADD COLUMN IF NOT EXISTS account_type SMALLINT NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownDeviceAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE device_devices DROP COLUMN account_type;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
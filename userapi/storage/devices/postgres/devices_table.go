@@ -57,8 +57,15 @@ CREATE TABLE IF NOT EXISTS device_devices (
 	-- The last seen IP address of this device
 	ip TEXT,
 	-- User agent of this device
-	user_agent TEXT
-                                          
+	user_agent TEXT,
+	-- The refresh token granted to this device, if the client asked for one.
+	refresh_token TEXT UNIQUE,
+	-- When the access token expires, as a unix timestamp (ms resolution), or 0 if it never expires.
+	access_token_expires_ts BIGINT NOT NULL DEFAULT 0,
+	-- The account type of the user when the device was created, so that requests
+	-- don't need to look up the account. 0 for devices created before this was stored.
+	account_type SMALLINT NOT NULL DEFAULT 0
+
     -- TODO: device keys, device display names, token restrictions (if 3rd-party OAuth app)
 );
 
@@ -67,11 +74,15 @@ CREATE UNIQUE INDEX IF NOT EXISTS device_localpart_id_idx ON device_devices(loca
 `
 
 const insertDeviceSQL = "" +
-	"INSERT INTO device_devices(device_id, localpart, access_token, created_ts, display_name, last_seen_ts, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)" +
+	"INSERT INTO device_devices(device_id, localpart, access_token, created_ts, display_name, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts, account_type)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)" +
 	" RETURNING session_id"
 
 const selectDeviceByTokenSQL = "" +
-	"SELECT session_id, device_id, localpart FROM device_devices WHERE access_token = $1"
+	"SELECT session_id, device_id, localpart, access_token_expires_ts, account_type FROM device_devices WHERE access_token = $1"
+
+const selectDeviceByRefreshTokenSQL = "" +
+	"SELECT session_id, device_id, localpart FROM device_devices WHERE refresh_token = $1"
 
 const selectDeviceByIDSQL = "" +
 	"SELECT display_name FROM device_devices WHERE localpart = $1 and device_id = $2"
@@ -97,18 +108,27 @@ const selectDevicesByIDSQL = "" +
 const updateDeviceLastSeen = "" +
 	"UPDATE device_devices SET last_seen_ts = $1, ip = $2 WHERE localpart = $3 AND device_id = $4"
 
+const updateDevicesAccountTypeSQL = "" +
+	"UPDATE device_devices SET account_type = $1 WHERE localpart = $2"
+
+const updateDeviceTokensSQL = "" +
+	"UPDATE device_devices SET access_token = $1, refresh_token = $2, access_token_expires_ts = $3 WHERE localpart = $4 AND device_id = $5"
+
 type devicesStatements struct {
-	insertDeviceStmt             *sql.Stmt
-	selectDeviceByTokenStmt      *sql.Stmt
-	selectDeviceByIDStmt         *sql.Stmt
-	selectDevicesByLocalpartStmt *sql.Stmt
-	selectDevicesByIDStmt        *sql.Stmt
-	updateDeviceNameStmt         *sql.Stmt
-	updateDeviceLastSeenStmt     *sql.Stmt
-	deleteDeviceStmt             *sql.Stmt
-	deleteDevicesByLocalpartStmt *sql.Stmt
-	deleteDevicesStmt            *sql.Stmt
-	serverName                   gomatrixserverlib.ServerName
+	insertDeviceStmt               *sql.Stmt
+	selectDeviceByTokenStmt        *sql.Stmt
+	selectDeviceByRefreshTokenStmt *sql.Stmt
+	selectDeviceByIDStmt           *sql.Stmt
+	selectDevicesByLocalpartStmt   *sql.Stmt
+	selectDevicesByIDStmt          *sql.Stmt
+	updateDeviceNameStmt           *sql.Stmt
+	updateDeviceLastSeenStmt       *sql.Stmt
+	updateDeviceTokensStmt         *sql.Stmt
+	updateDevicesAccountTypeStmt   *sql.Stmt
+	deleteDeviceStmt               *sql.Stmt
+	deleteDevicesByLocalpartStmt   *sql.Stmt
+	deleteDevicesStmt              *sql.Stmt
+	serverName                     gomatrixserverlib.ServerName
 }
 
 func (s *devicesStatements) execSchema(db *sql.DB) error {
@@ -147,6 +167,15 @@ func (s *devicesStatements) prepare(db *sql.DB, server gomatrixserverlib.ServerN
 	if s.updateDeviceLastSeenStmt, err = db.Prepare(updateDeviceLastSeen); err != nil {
 		return
 	}
+	if s.selectDeviceByRefreshTokenStmt, err = db.Prepare(selectDeviceByRefreshTokenSQL); err != nil {
+		return
+	}
+	if s.updateDeviceTokensStmt, err = db.Prepare(updateDeviceTokensSQL); err != nil {
+		return
+	}
+	if s.updateDevicesAccountTypeStmt, err = db.Prepare(updateDevicesAccountTypeSQL); err != nil {
+		return
+	}
 	s.serverName = server
 	return
 }
@@ -155,23 +184,28 @@ func (s *devicesStatements) prepare(db *sql.DB, server gomatrixserverlib.ServerN
 // Returns an error if the user already has a device with the given device ID.
 // Returns the device on success.
 func (s *devicesStatements) insertDevice(
-	ctx context.Context, txn *sql.Tx, id, localpart, accessToken string,
-	displayName *string, ipAddr, userAgent string,
+	ctx context.Context, txn *sql.Tx, id, localpart, accessToken, refreshToken string,
+	accessTokenExpiresTS int64, displayName *string, ipAddr, userAgent string, accountType api.AccountType,
 ) (*api.Device, error) {
 	createdTimeMS := time.Now().UnixNano() / 1000000
 	var sessionID int64
 	stmt := sqlutil.TxStmt(txn, s.insertDeviceStmt)
-	if err := stmt.QueryRowContext(ctx, id, localpart, accessToken, createdTimeMS, displayName, createdTimeMS, ipAddr, userAgent).Scan(&sessionID); err != nil {
+	if err := stmt.QueryRowContext(
+		ctx, id, localpart, accessToken, createdTimeMS, displayName, createdTimeMS, ipAddr, userAgent,
+		sql.NullString{String: refreshToken, Valid: refreshToken != ""}, accessTokenExpiresTS, accountType,
+	).Scan(&sessionID); err != nil {
 		return nil, err
 	}
 	return &api.Device{
-		ID:          id,
-		UserID:      userutil.MakeUserID(localpart, s.serverName),
-		AccessToken: accessToken,
-		SessionID:   sessionID,
-		LastSeenTS:  createdTimeMS,
-		LastSeenIP:  ipAddr,
-		UserAgent:   userAgent,
+		ID:                   id,
+		UserID:               userutil.MakeUserID(localpart, s.serverName),
+		AccessToken:          accessToken,
+		AccessTokenExpiresTS: accessTokenExpiresTS,
+		SessionID:            sessionID,
+		LastSeenTS:           createdTimeMS,
+		LastSeenIP:           ipAddr,
+		UserAgent:            userAgent,
+		AccountType:          accountType,
 	}, nil
 }
 
@@ -218,7 +252,7 @@ func (s *devicesStatements) selectDeviceByToken(
 	var dev api.Device
 	var localpart string
 	stmt := s.selectDeviceByTokenStmt
-	err := stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart)
+	err := stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart, &dev.AccessTokenExpiresTS, &dev.AccountType)
 	if err == nil {
 		dev.UserID = userutil.MakeUserID(localpart, s.serverName)
 		dev.AccessToken = accessToken
@@ -226,6 +260,21 @@ func (s *devicesStatements) selectDeviceByToken(
 	return &dev, err
 }
 
+// selectDeviceByRefreshToken retrieves the device that the refresh token
+// was granted to. Returns sql.ErrNoRows if there is no such device.
+func (s *devicesStatements) selectDeviceByRefreshToken(
+	ctx context.Context, txn *sql.Tx, refreshToken string,
+) (*api.Device, string, error) {
+	var dev api.Device
+	var localpart string
+	stmt := sqlutil.TxStmt(txn, s.selectDeviceByRefreshTokenStmt)
+	err := stmt.QueryRowContext(ctx, refreshToken).Scan(&dev.SessionID, &dev.ID, &localpart)
+	if err == nil {
+		dev.UserID = userutil.MakeUserID(localpart, s.serverName)
+	}
+	return &dev, localpart, err
+}
+
 // selectDeviceByID retrieves a device from the database with the given user
 // localpart and deviceID
 func (s *devicesStatements) selectDeviceByID(
@@ -316,3 +365,22 @@ func (s *devicesStatements) updateDeviceLastSeen(ctx context.Context, txn *sql.T
 	_, err := stmt.ExecContext(ctx, lastSeenTs, ipAddr, localpart, deviceID)
 	return err
 }
+
+// updateDeviceTokens replaces the access token and refresh token of a device.
+func (s *devicesStatements) updateDeviceTokens(
+	ctx context.Context, txn *sql.Tx, localpart, deviceID, accessToken, refreshToken string,
+	accessTokenExpiresTS int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateDeviceTokensStmt)
+	_, err := stmt.ExecContext(ctx, accessToken, refreshToken, accessTokenExpiresTS, localpart, deviceID)
+	return err
+}
+
+// updateDevicesAccountType sets the account type stored with all of a user's devices.
+func (s *devicesStatements) updateDevicesAccountType(
+	ctx context.Context, txn *sql.Tx, localpart string, accountType api.AccountType,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateDevicesAccountTypeStmt)
+	_, err := stmt.ExecContext(ctx, accountType, localpart)
+	return err
+}
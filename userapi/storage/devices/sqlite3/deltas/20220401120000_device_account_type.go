@@ -0,0 +1,75 @@
+package deltas
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+func LoadDeviceAccountType(m *sqlutil.Migrations) {
+	m.AddMigration(UpDeviceAccountType, DownDeviceAccountType)
+}
+
+// UpDeviceAccountType stores the account type with each device. Existing
+// devices get 0 and have it filled in the first time their token is used.
+func UpDeviceAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    ALTER TABLE device_devices RENAME TO device_devices_tmp;
+    CREATE TABLE device_devices (
+        access_token TEXT PRIMARY KEY,
+        session_id INTEGER,
+        device_id TEXT ,
+        localpart TEXT ,
+        created_ts BIGINT,
+        display_name TEXT,
+        last_seen_ts BIGINT,
+        ip TEXT,
+        user_agent TEXT,
+        refresh_token TEXT UNIQUE,
+        access_token_expires_ts BIGINT NOT NULL DEFAULT 0,
+        account_type INTEGER NOT NULL DEFAULT 0,
+        UNIQUE (localpart, device_id)
+    );
+    INSERT
+    INTO device_devices (
+        access_token, session_id, device_id, localpart, created_ts, display_name, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts
+    )  SELECT
+           access_token, session_id, device_id, localpart, created_ts, display_name, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts
+    FROM device_devices_tmp;
+    DROP TABLE device_devices_tmp;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownDeviceAccountType(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    ALTER TABLE device_devices RENAME TO device_devices_tmp;
+    CREATE TABLE device_devices (
+        access_token TEXT PRIMARY KEY,
+        session_id INTEGER,
+        device_id TEXT ,
+        localpart TEXT ,
+        created_ts BIGINT,
+        display_name TEXT,
+        last_seen_ts BIGINT,
+        ip TEXT,
+        user_agent TEXT,
+        refresh_token TEXT UNIQUE,
+        access_token_expires_ts BIGINT NOT NULL DEFAULT 0,
+        UNIQUE (localpart, device_id)
+    );
+    INSERT
+    INTO device_devices (
+        access_token, session_id, device_id, localpart, created_ts, display_name, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts
+    )  SELECT
+           access_token, session_id, device_id, localpart, created_ts, display_name, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts
+    FROM device_devices_tmp;
+    DROP TABLE device_devices_tmp;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -43,20 +43,26 @@ CREATE TABLE IF NOT EXISTS device_devices (
     last_seen_ts BIGINT,
     ip TEXT,
     user_agent TEXT,
+    refresh_token TEXT UNIQUE,
+    access_token_expires_ts BIGINT NOT NULL DEFAULT 0,
+    account_type INTEGER NOT NULL DEFAULT 0,
 
 		UNIQUE (localpart, device_id)
 );
 `
 
 const insertDeviceSQL = "" +
-	"INSERT INTO device_devices (device_id, localpart, access_token, created_ts, display_name, session_id, last_seen_ts, ip, user_agent)" +
-	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	"INSERT INTO device_devices (device_id, localpart, access_token, created_ts, display_name, session_id, last_seen_ts, ip, user_agent, refresh_token, access_token_expires_ts, account_type)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
 
 const selectDevicesCountSQL = "" +
 	"SELECT COUNT(access_token) FROM device_devices"
 
 const selectDeviceByTokenSQL = "" +
-	"SELECT session_id, device_id, localpart FROM device_devices WHERE access_token = $1"
+	"SELECT session_id, device_id, localpart, access_token_expires_ts, account_type FROM device_devices WHERE access_token = $1"
+
+const selectDeviceByRefreshTokenSQL = "" +
+	"SELECT session_id, device_id, localpart FROM device_devices WHERE refresh_token = $1"
 
 const selectDeviceByIDSQL = "" +
 	"SELECT display_name FROM device_devices WHERE localpart = $1 and device_id = $2"
@@ -82,20 +88,29 @@ const selectDevicesByIDSQL = "" +
 const updateDeviceLastSeen = "" +
 	"UPDATE device_devices SET last_seen_ts = $1, ip = $2 WHERE localpart = $3 AND device_id = $4"
 
+const updateDevicesAccountTypeSQL = "" +
+	"UPDATE device_devices SET account_type = $1 WHERE localpart = $2"
+
+const updateDeviceTokensSQL = "" +
+	"UPDATE device_devices SET access_token = $1, refresh_token = $2, access_token_expires_ts = $3 WHERE localpart = $4 AND device_id = $5"
+
 type devicesStatements struct {
-	db                           *sql.DB
-	writer                       sqlutil.Writer
-	insertDeviceStmt             *sql.Stmt
-	selectDevicesCountStmt       *sql.Stmt
-	selectDeviceByTokenStmt      *sql.Stmt
-	selectDeviceByIDStmt         *sql.Stmt
-	selectDevicesByIDStmt        *sql.Stmt
-	selectDevicesByLocalpartStmt *sql.Stmt
-	updateDeviceNameStmt         *sql.Stmt
-	updateDeviceLastSeenStmt     *sql.Stmt
-	deleteDeviceStmt             *sql.Stmt
-	deleteDevicesByLocalpartStmt *sql.Stmt
-	serverName                   gomatrixserverlib.ServerName
+	db                             *sql.DB
+	writer                         sqlutil.Writer
+	insertDeviceStmt               *sql.Stmt
+	selectDevicesCountStmt         *sql.Stmt
+	selectDeviceByTokenStmt        *sql.Stmt
+	selectDeviceByRefreshTokenStmt *sql.Stmt
+	selectDeviceByIDStmt           *sql.Stmt
+	selectDevicesByIDStmt          *sql.Stmt
+	selectDevicesByLocalpartStmt   *sql.Stmt
+	updateDeviceNameStmt           *sql.Stmt
+	updateDeviceLastSeenStmt       *sql.Stmt
+	updateDeviceTokensStmt         *sql.Stmt
+	updateDevicesAccountTypeStmt   *sql.Stmt
+	deleteDeviceStmt               *sql.Stmt
+	deleteDevicesByLocalpartStmt   *sql.Stmt
+	serverName                     gomatrixserverlib.ServerName
 }
 
 func (s *devicesStatements) execSchema(db *sql.DB) error {
@@ -136,6 +151,15 @@ func (s *devicesStatements) prepare(db *sql.DB, writer sqlutil.Writer, server go
 	if s.updateDeviceLastSeenStmt, err = db.Prepare(updateDeviceLastSeen); err != nil {
 		return
 	}
+	if s.selectDeviceByRefreshTokenStmt, err = db.Prepare(selectDeviceByRefreshTokenSQL); err != nil {
+		return
+	}
+	if s.updateDeviceTokensStmt, err = db.Prepare(updateDeviceTokensSQL); err != nil {
+		return
+	}
+	if s.updateDevicesAccountTypeStmt, err = db.Prepare(updateDevicesAccountTypeSQL); err != nil {
+		return
+	}
 	s.serverName = server
 	return
 }
@@ -144,8 +168,8 @@ func (s *devicesStatements) prepare(db *sql.DB, writer sqlutil.Writer, server go
 // Returns an error if the user already has a device with the given device ID.
 // Returns the device on success.
 func (s *devicesStatements) insertDevice(
-	ctx context.Context, txn *sql.Tx, id, localpart, accessToken string,
-	displayName *string, ipAddr, userAgent string,
+	ctx context.Context, txn *sql.Tx, id, localpart, accessToken, refreshToken string,
+	accessTokenExpiresTS int64, displayName *string, ipAddr, userAgent string, accountType api.AccountType,
 ) (*api.Device, error) {
 	createdTimeMS := time.Now().UnixNano() / 1000000
 	var sessionID int64
@@ -155,17 +179,22 @@ func (s *devicesStatements) insertDevice(
 		return nil, err
 	}
 	sessionID++
-	if _, err := insertStmt.ExecContext(ctx, id, localpart, accessToken, createdTimeMS, displayName, sessionID, createdTimeMS, ipAddr, userAgent); err != nil {
+	if _, err := insertStmt.ExecContext(
+		ctx, id, localpart, accessToken, createdTimeMS, displayName, sessionID, createdTimeMS, ipAddr, userAgent,
+		sql.NullString{String: refreshToken, Valid: refreshToken != ""}, accessTokenExpiresTS, accountType,
+	); err != nil {
 		return nil, err
 	}
 	return &api.Device{
-		ID:          id,
-		UserID:      userutil.MakeUserID(localpart, s.serverName),
-		AccessToken: accessToken,
-		SessionID:   sessionID,
-		LastSeenTS:  createdTimeMS,
-		LastSeenIP:  ipAddr,
-		UserAgent:   userAgent,
+		ID:                   id,
+		UserID:               userutil.MakeUserID(localpart, s.serverName),
+		AccessToken:          accessToken,
+		AccessTokenExpiresTS: accessTokenExpiresTS,
+		SessionID:            sessionID,
+		LastSeenTS:           createdTimeMS,
+		LastSeenIP:           ipAddr,
+		UserAgent:            userAgent,
+		AccountType:          accountType,
 	}, nil
 }
 
@@ -217,7 +246,7 @@ func (s *devicesStatements) selectDeviceByToken(
 	var dev api.Device
 	var localpart string
 	stmt := s.selectDeviceByTokenStmt
-	err := stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart)
+	err := stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart, &dev.AccessTokenExpiresTS, &dev.AccountType)
 	if err == nil {
 		dev.UserID = userutil.MakeUserID(localpart, s.serverName)
 		dev.AccessToken = accessToken
@@ -225,6 +254,21 @@ func (s *devicesStatements) selectDeviceByToken(
 	return &dev, err
 }
 
+// selectDeviceByRefreshToken retrieves the device that the refresh token
+// was granted to. Returns sql.ErrNoRows if there is no such device.
+func (s *devicesStatements) selectDeviceByRefreshToken(
+	ctx context.Context, txn *sql.Tx, refreshToken string,
+) (*api.Device, string, error) {
+	var dev api.Device
+	var localpart string
+	stmt := sqlutil.TxStmt(txn, s.selectDeviceByRefreshTokenStmt)
+	err := stmt.QueryRowContext(ctx, refreshToken).Scan(&dev.SessionID, &dev.ID, &localpart)
+	if err == nil {
+		dev.UserID = userutil.MakeUserID(localpart, s.serverName)
+	}
+	return &dev, localpart, err
+}
+
 // selectDeviceByID retrieves a device from the database with the given user
 // localpart and deviceID
 func (s *devicesStatements) selectDeviceByID(
@@ -320,3 +364,21 @@ func (s *devicesStatements) updateDeviceLastSeen(ctx context.Context, txn *sql.T
 	_, err := stmt.ExecContext(ctx, lastSeenTs, ipAddr, localpart, deviceID)
 	return err
 }
+
+func (s *devicesStatements) updateDeviceTokens(
+	ctx context.Context, txn *sql.Tx, localpart, deviceID, accessToken, refreshToken string,
+	accessTokenExpiresTS int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateDeviceTokensStmt)
+	_, err := stmt.ExecContext(ctx, accessToken, refreshToken, accessTokenExpiresTS, localpart, deviceID)
+	return err
+}
+
+// updateDevicesAccountType sets the account type stored with all of a user's devices.
+func (s *devicesStatements) updateDevicesAccountType(
+	ctx context.Context, txn *sql.Tx, localpart string, accountType api.AccountType,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateDevicesAccountTypeStmt)
+	_, err := stmt.ExecContext(ctx, accountType, localpart)
+	return err
+}
@@ -65,6 +65,8 @@ func NewDatabase(dbProperties *config.DatabaseOptions, serverName gomatrixserver
 
 	m := sqlutil.NewMigrations()
 	deltas.LoadLastSeenTSIP(m)
+	deltas.LoadRefreshTokens(m)
+	deltas.LoadDeviceAccountType(m)
 	if err = m.RunDeltas(db, dbProperties); err != nil {
 		return nil, err
 	}
@@ -107,12 +109,14 @@ func (d *Database) GetDevicesByID(ctx context.Context, deviceIDs []string) ([]ap
 // CreateDevice makes a new device associated with the given user ID localpart.
 // If there is already a device with the same device ID for this user, that access token will be revoked
 // and replaced with the given accessToken. If the given accessToken is already in use for another device,
-// an error will be returned.
+// an error will be returned. The refresh token is optional, and accessTokenExpiresTS is 0 if the access
+// token never expires. The account type is stored with the device so that it
+// doesn't need to be looked up whenever the access token is used.
 // If no device ID is given one is generated.
 // Returns the device on success.
 func (d *Database) CreateDevice(
-	ctx context.Context, localpart string, deviceID *string, accessToken string,
-	displayName *string, ipAddr, userAgent string,
+	ctx context.Context, localpart string, deviceID *string, accessToken, refreshToken string,
+	accessTokenExpiresTS int64, displayName *string, ipAddr, userAgent string, accountType api.AccountType,
 ) (dev *api.Device, returnErr error) {
 	if deviceID != nil {
 		returnErr = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
@@ -122,7 +126,7 @@ func (d *Database) CreateDevice(
 				return err
 			}
 
-			dev, err = d.devices.insertDevice(ctx, txn, *deviceID, localpart, accessToken, displayName, ipAddr, userAgent)
+			dev, err = d.devices.insertDevice(ctx, txn, *deviceID, localpart, accessToken, refreshToken, accessTokenExpiresTS, displayName, ipAddr, userAgent, accountType)
 			return err
 		})
 	} else {
@@ -137,7 +141,7 @@ func (d *Database) CreateDevice(
 
 			returnErr = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
 				var err error
-				dev, err = d.devices.insertDevice(ctx, txn, newDeviceID, localpart, accessToken, displayName, ipAddr, userAgent)
+				dev, err = d.devices.insertDevice(ctx, txn, newDeviceID, localpart, accessToken, refreshToken, accessTokenExpiresTS, displayName, ipAddr, userAgent, accountType)
 				return err
 			})
 			if returnErr == nil {
@@ -226,6 +230,36 @@ func (d *Database) UpdateDeviceLastSeen(ctx context.Context, localpart, deviceID
 	})
 }
 
+// UpdateDevicesAccountType sets the account type stored with all of a user's devices.
+func (d *Database) UpdateDevicesAccountType(ctx context.Context, localpart string, accountType api.AccountType) error {
+	return d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		return d.devices.updateDevicesAccountType(ctx, txn, localpart, accountType)
+	})
+}
+
+// RefreshDeviceTokens replaces the access token and refresh token of the device that
+// the refresh token was granted to, so that the old tokens can no longer be used.
+// Returns sql.ErrNoRows if the refresh token is unknown.
+func (d *Database) RefreshDeviceTokens(
+	ctx context.Context, refreshToken, newAccessToken, newRefreshToken string,
+	accessTokenExpiresTS int64,
+) (dev *api.Device, err error) {
+	err = d.writer.Do(d.db, nil, func(txn *sql.Tx) error {
+		var localpart string
+		dev, localpart, err = d.devices.selectDeviceByRefreshToken(ctx, txn, refreshToken)
+		if err != nil {
+			return err
+		}
+		if err = d.devices.updateDeviceTokens(ctx, txn, localpart, dev.ID, newAccessToken, newRefreshToken, accessTokenExpiresTS); err != nil {
+			return err
+		}
+		dev.AccessToken = newAccessToken
+		dev.AccessTokenExpiresTS = accessTokenExpiresTS
+		return nil
+	})
+	return
+}
+
 // CreateLoginToken generates a token, stores and returns it. The lifetime is
 // determined by the loginTokenLifetime given to the Database constructor.
 func (d *Database) CreateLoginToken(ctx context.Context, data *api.LoginTokenData) (*api.LoginTokenMetadata, error) {
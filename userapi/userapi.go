@@ -15,16 +15,24 @@
 package userapi
 
 import (
+	"context"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/internal/pushgateway"
 	keyapi "github.com/matrix-org/dendrite/keyserver/api"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/base"
 	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
 	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/consumers"
 	"github.com/matrix-org/dendrite/userapi/internal"
 	"github.com/matrix-org/dendrite/userapi/inthttp"
+	"github.com/matrix-org/dendrite/userapi/producers"
 	"github.com/matrix-org/dendrite/userapi/storage/accounts"
 	"github.com/matrix-org/dendrite/userapi/storage/devices"
+	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/sirupsen/logrus"
 )
 
@@ -44,14 +52,60 @@ func AddInternalRoutes(router *mux.Router, intAPI api.UserInternalAPI) {
 // NewInternalAPI returns a concerete implementation of the internal API. Callers
 // can call functions directly on the returned API or via an HTTP interface using AddInternalRoutes.
 func NewInternalAPI(
-	accountDB accounts.Database, cfg *config.UserAPI, appServices []config.ApplicationService, keyAPI keyapi.KeyInternalAPI,
+	base *base.BaseDendrite, accountDB accounts.Database, cfg *config.UserAPI,
+	appServices []config.ApplicationService, keyAPI keyapi.KeyInternalAPI,
+	rsAPI rsapi.RoomserverInternalAPI,
 ) api.UserInternalAPI {
 	deviceDB, err := devices.NewDatabase(&cfg.DeviceDatabase, cfg.Matrix.ServerName, defaultLoginTokenLifetime)
 	if err != nil {
 		logrus.WithError(err).Panicf("failed to connect to device db")
 	}
 
-	return newInternalAPI(accountDB, deviceDB, cfg, appServices, keyAPI)
+	userAPI := newInternalAPI(accountDB, deviceDB, cfg, appServices, keyAPI)
+
+	js := jetstream.Prepare(&cfg.Matrix.JetStream)
+	pgClient := pushgateway.NewHTTPClient(cfg.PushGatewayDisableTLSValidation)
+	syncProducer := producers.NewSyncAPI(
+		accountDB, js, cfg.Matrix.JetStream.TopicFor(jetstream.OutputNotificationData),
+	)
+
+	roomConsumer := consumers.NewOutputRoomEventConsumer(
+		base.ProcessContext, cfg, js, userAPI, rsAPI, accountDB, pgClient, syncProducer,
+	)
+	if err = roomConsumer.Start(); err != nil {
+		logrus.WithError(err).Panicf("failed to start user API room server consumer")
+	}
+
+	receiptConsumer := consumers.NewOutputReceiptEventConsumer(
+		base.ProcessContext, cfg, js, rsAPI, accountDB, syncProducer,
+	)
+	if err = receiptConsumer.Start(); err != nil {
+		logrus.WithError(err).Panicf("failed to start user API receipt consumer")
+	}
+
+	if cfg.ReadNotificationLifetimeDays > 0 {
+		go pruneReadNotificationsPeriodically(cfg, accountDB)
+	}
+
+	return userAPI
+}
+
+// pruneReadNotificationsPeriodically deletes notifications that were read
+// more than user_api.read_notification_lifetime_days ago, checking once an
+// hour.
+func pruneReadNotificationsPeriodically(cfg *config.UserAPI, accountDB accounts.Database) {
+	lifetime := time.Duration(cfg.ReadNotificationLifetimeDays) * 24 * time.Hour
+	logger := logrus.WithField("read_notification_lifetime_days", cfg.ReadNotificationLifetimeDays)
+	for {
+		before := gomatrixserverlib.AsTimestamp(time.Now().Add(-lifetime))
+		deleted, err := accountDB.DeleteOldReadNotifications(context.Background(), before)
+		if err != nil {
+			logger.WithError(err).Error("Failed to delete old read notifications")
+		} else if deleted > 0 {
+			logger.Infof("Deleted %d old read notifications", deleted)
+		}
+		time.Sleep(time.Hour)
+	}
 }
 
 func newInternalAPI(
@@ -67,5 +121,6 @@ func newInternalAPI(
 		ServerName:  cfg.Matrix.ServerName,
 		AppServices: appServices,
 		KeyAPI:      keyAPI,
+		Config:      cfg,
 	}
 }
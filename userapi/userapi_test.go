@@ -27,6 +27,7 @@ import (
 	"github.com/matrix-org/dendrite/internal/test"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/internal"
 	"github.com/matrix-org/dendrite/userapi/inthttp"
 	"github.com/matrix-org/dendrite/userapi/storage/accounts"
 	"github.com/matrix-org/dendrite/userapi/storage/devices"
@@ -40,6 +41,8 @@ const (
 
 type apiTestOpts struct {
 	loginTokenLifetime time.Duration
+	// How long refreshable access tokens are valid for in milliseconds.
+	refreshableAccessTokenLifetimeMS int64
 }
 
 func MustMakeInternalAPI(t *testing.T, opts apiTestOpts) (api.UserInternalAPI, accounts.Database) {
@@ -64,6 +67,7 @@ func MustMakeInternalAPI(t *testing.T, opts apiTestOpts) (api.UserInternalAPI, a
 		Matrix: &config.Global{
 			ServerName: serverName,
 		},
+		RefreshableAccessTokenLifetimeMS: opts.refreshableAccessTokenLifetimeMS,
 	}
 
 	return newInternalAPI(accountDB, deviceDB, cfg, nil, nil), accountDB
@@ -73,7 +77,7 @@ func TestQueryProfile(t *testing.T) {
 	aliceAvatarURL := "mxc://example.com/alice"
 	aliceDisplayName := "Alice"
 	userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
-	_, err := accountDB.CreateAccount(context.TODO(), "alice", "foobar", "")
+	_, err := accountDB.CreateAccount(context.TODO(), "alice", "foobar", "", api.AccountTypeUser)
 	if err != nil {
 		t.Fatalf("failed to make account: %s", err)
 	}
@@ -151,7 +155,7 @@ func TestLoginToken(t *testing.T) {
 	t.Run("tokenLoginFlow", func(t *testing.T) {
 		userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
 
-		_, err := accountDB.CreateAccount(ctx, "auser", "apassword", "")
+		_, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser)
 		if err != nil {
 			t.Fatalf("failed to make account: %s", err)
 		}
@@ -256,3 +260,385 @@ func TestLoginToken(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryNotifications(t *testing.T) {
+	ctx := context.Background()
+	userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
+	room := test.NewRoom(t, "@alice:example.com")
+	ev := room.CreateAndInsert(t, "@alice:example.com", "m.room.message", map[string]interface{}{"body": "hello"})
+
+	// Notifications 1, 3 and 4 are highlights.
+	for i := 0; i < 5; i++ {
+		if err := accountDB.InsertNotification(ctx, "alice", ev.EventID(), i == 1 || i == 3 || i == 4, &api.Notification{
+			Event:  gomatrixserverlib.HeaderedToClientEvent(ev, gomatrixserverlib.FormatAll),
+			RoomID: room.ID,
+			TS:     gomatrixserverlib.Timestamp(1000 * (i + 1)),
+		}); err != nil {
+			t.Fatalf("InsertNotification failed: %s", err)
+		}
+	}
+	query := func(t *testing.T, req *api.QueryNotificationsRequest) (ts []gomatrixserverlib.Timestamp, nextToken string) {
+		t.Helper()
+		req.Localpart = "alice"
+		var res api.QueryNotificationsResponse
+		if err := userAPI.QueryNotifications(ctx, req, &res); err != nil {
+			t.Fatalf("QueryNotifications failed: %s", err)
+		}
+		for _, n := range res.Notifications {
+			ts = append(ts, n.TS)
+		}
+		return ts, res.NextToken
+	}
+
+	t.Run("all", func(t *testing.T) {
+		got, next := query(t, &api.QueryNotificationsRequest{})
+		want := []gomatrixserverlib.Timestamp{5000, 4000, 3000, 2000, 1000}
+		if !reflect.DeepEqual(got, want) || next != "" {
+			t.Errorf("QueryNotifications: got %v with next token %q, want %v and no next token", got, next, want)
+		}
+	})
+
+	t.Run("highlightsPaginated", func(t *testing.T) {
+		got, next := query(t, &api.QueryNotificationsRequest{Limit: 2, Only: "highlight"})
+		want := []gomatrixserverlib.Timestamp{5000, 4000}
+		if !reflect.DeepEqual(got, want) || next == "" {
+			t.Fatalf("QueryNotifications: got %v with next token %q, want %v and a next token", got, next, want)
+		}
+		got, next = query(t, &api.QueryNotificationsRequest{From: next, Limit: 2, Only: "highlight"})
+		want = []gomatrixserverlib.Timestamp{2000}
+		if !reflect.DeepEqual(got, want) || next != "" {
+			t.Errorf("QueryNotifications: got %v with next token %q, want %v and no next token", got, next, want)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		if _, err := accountDB.SetNotificationsRead(ctx, "alice", room.ID, 3000); err != nil {
+			t.Fatalf("SetNotificationsRead failed: %s", err)
+		}
+		total, highlight, err := accountDB.GetRoomNotificationCounts(ctx, "alice", room.ID)
+		if err != nil {
+			t.Fatalf("GetRoomNotificationCounts failed: %s", err)
+		}
+		if total != 2 || highlight != 2 {
+			t.Errorf("GetRoomNotificationCounts: got %d notifications and %d highlights, want 2 and 2", total, highlight)
+		}
+		var res api.QueryNotificationsResponse
+		if err = userAPI.QueryNotifications(ctx, &api.QueryNotificationsRequest{Localpart: "alice"}, &res); err != nil {
+			t.Fatalf("QueryNotifications failed: %s", err)
+		}
+		for _, n := range res.Notifications {
+			if wantRead := n.TS <= 3000; n.Read != wantRead {
+				t.Errorf("notification at %d: got read %v, want %v", n.TS, n.Read, wantRead)
+			}
+		}
+	})
+
+	t.Run("deleteOldRead", func(t *testing.T) {
+		// The notifications up to 3000 have been read, but only the ones
+		// before the cutoff are deleted.
+		deleted, err := accountDB.DeleteOldReadNotifications(ctx, 2500)
+		if err != nil {
+			t.Fatalf("DeleteOldReadNotifications failed: %s", err)
+		}
+		if deleted != 2 {
+			t.Errorf("DeleteOldReadNotifications: got %d deleted, want 2", deleted)
+		}
+		got, _ := query(t, &api.QueryNotificationsRequest{})
+		want := []gomatrixserverlib.Timestamp{5000, 4000, 3000}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("QueryNotifications: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestTokenRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	createDevice := func(t *testing.T, userAPI api.UserInternalAPI, accessToken string) *api.PerformDeviceCreationResponse {
+		t.Helper()
+		var res api.PerformDeviceCreationResponse
+		if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:          "auser",
+			AccessToken:        accessToken,
+			RefreshToken:       true,
+			NoDeviceListUpdate: true,
+		}, &res); err != nil {
+			t.Fatalf("PerformDeviceCreation failed: %v", err)
+		}
+		return &res
+	}
+	queryAccessToken := func(t *testing.T, userAPI api.UserInternalAPI, accessToken string) *api.QueryAccessTokenResponse {
+		t.Helper()
+		var res api.QueryAccessTokenResponse
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: accessToken}, &res); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		return &res
+	}
+
+	t.Run("refreshRotatesTokens", func(t *testing.T) {
+		userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{refreshableAccessTokenLifetimeMS: 60000})
+		if _, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+
+		cres := createDevice(t, userAPI, "firsttoken")
+		if cres.RefreshToken == "" {
+			t.Fatalf("PerformDeviceCreation RefreshToken: got %q, want non-empty", cres.RefreshToken)
+		}
+		if cres.Device.AccessTokenExpiresTS == 0 {
+			t.Errorf("PerformDeviceCreation AccessTokenExpiresTS: got 0, want an expiry")
+		}
+		if qres := queryAccessToken(t, userAPI, "firsttoken"); qres.Device == nil {
+			t.Fatalf("QueryAccessToken Device: got nil, want the device")
+		}
+
+		var rres api.PerformTokenRefreshResponse
+		if err := userAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: cres.RefreshToken,
+			AccessToken:  "secondtoken",
+		}, &rres); err != nil {
+			t.Fatalf("PerformTokenRefresh failed: %v", err)
+		}
+		if rres.Device == nil || rres.Device.ID != cres.Device.ID {
+			t.Fatalf("PerformTokenRefresh Device: got %+v, want device %q", rres.Device, cres.Device.ID)
+		}
+		if rres.RefreshToken == "" || rres.RefreshToken == cres.RefreshToken {
+			t.Errorf("PerformTokenRefresh RefreshToken: got %q, want a new refresh token", rres.RefreshToken)
+		}
+
+		if qres := queryAccessToken(t, userAPI, "firsttoken"); qres.Device != nil || qres.Expired {
+			t.Errorf("QueryAccessToken: got %+v, want the old access token to be unknown", qres)
+		}
+		if qres := queryAccessToken(t, userAPI, "secondtoken"); qres.Device == nil {
+			t.Errorf("QueryAccessToken Device: got nil, want the device")
+		}
+
+		// The old refresh token can't be used again.
+		rres = api.PerformTokenRefreshResponse{}
+		if err := userAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: cres.RefreshToken,
+			AccessToken:  "thirdtoken",
+		}, &rres); err != nil {
+			t.Fatalf("PerformTokenRefresh failed: %v", err)
+		}
+		if rres.Device != nil {
+			t.Errorf("PerformTokenRefresh Device: got %+v, want nil", rres.Device)
+		}
+	})
+
+	t.Run("expiredAccessTokenIsRejected", func(t *testing.T) {
+		userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{refreshableAccessTokenLifetimeMS: 1})
+		if _, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+
+		createDevice(t, userAPI, "expiredtoken")
+		time.Sleep(5 * time.Millisecond)
+		qres := queryAccessToken(t, userAPI, "expiredtoken")
+		if qres.Device != nil || !qres.Expired {
+			t.Errorf("QueryAccessToken: got %+v, want an expired token", qres)
+		}
+	})
+
+	t.Run("noRefreshTokenWhenDisabled", func(t *testing.T) {
+		userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
+		if _, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+
+		cres := createDevice(t, userAPI, "atoken")
+		if cres.RefreshToken != "" || cres.Device.AccessTokenExpiresTS != 0 {
+			t.Errorf("PerformDeviceCreation: got refresh token %q expiring at %d, want neither", cres.RefreshToken, cres.Device.AccessTokenExpiresTS)
+		}
+	})
+}
+
+func TestQueryAccessTokenAccountType(t *testing.T) {
+	ctx := context.Background()
+	userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
+	deviceDB := userAPI.(*internal.UserInternalAPI).DeviceDB
+	if _, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser); err != nil {
+		t.Fatalf("failed to make account: %s", err)
+	}
+	queryAccessToken := func(t *testing.T, accessToken string) *api.QueryAccessTokenResponse {
+		t.Helper()
+		var res api.QueryAccessTokenResponse
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: accessToken}, &res); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		return &res
+	}
+
+	t.Run("storedOnNewDevices", func(t *testing.T) {
+		var res api.PerformDeviceCreationResponse
+		if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:          "auser",
+			AccessToken:        "newtoken",
+			NoDeviceListUpdate: true,
+		}, &res); err != nil {
+			t.Fatalf("PerformDeviceCreation failed: %v", err)
+		}
+		dev, err := deviceDB.GetDeviceByAccessToken(ctx, "newtoken")
+		if err != nil {
+			t.Fatalf("GetDeviceByAccessToken failed: %v", err)
+		}
+		if dev.AccountType != api.AccountTypeUser {
+			t.Errorf("stored AccountType: got %d, want %d", dev.AccountType, api.AccountTypeUser)
+		}
+	})
+
+	t.Run("filledInForOldDevices", func(t *testing.T) {
+		// Devices from before the account type was stored with them have 0.
+		if _, err := deviceDB.CreateDevice(ctx, "auser", nil, "oldtoken", "", 0, nil, "", "", 0); err != nil {
+			t.Fatalf("CreateDevice failed: %v", err)
+		}
+		if qres := queryAccessToken(t, "oldtoken"); qres.Device == nil || qres.Device.AccountType != api.AccountTypeUser {
+			t.Fatalf("QueryAccessToken Device: got %+v, want a device of a user account", qres.Device)
+		}
+		dev, err := deviceDB.GetDeviceByAccessToken(ctx, "oldtoken")
+		if err != nil {
+			t.Fatalf("GetDeviceByAccessToken failed: %v", err)
+		}
+		if dev.AccountType != api.AccountTypeUser {
+			t.Errorf("stored AccountType: got %d, want %d", dev.AccountType, api.AccountTypeUser)
+		}
+	})
+
+	t.Run("missingAccountIsUnknownToken", func(t *testing.T) {
+		if _, err := deviceDB.CreateDevice(ctx, "nobody", nil, "orphantoken", "", 0, nil, "", "", 0); err != nil {
+			t.Fatalf("CreateDevice failed: %v", err)
+		}
+		if qres := queryAccessToken(t, "orphantoken"); qres.Device != nil || qres.Expired {
+			t.Errorf("QueryAccessToken: got %+v, want the token to be unknown", qres)
+		}
+	})
+}
+
+func TestGuestUpgrade(t *testing.T) {
+	ctx := context.Background()
+	userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
+
+	var accRes api.PerformAccountCreationResponse
+	if err := userAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+		AccountType: api.AccountTypeGuest,
+	}, &accRes); err != nil {
+		t.Fatalf("PerformAccountCreation failed: %v", err)
+	}
+	localpart := accRes.Account.Localpart
+	var devRes api.PerformDeviceCreationResponse
+	if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+		Localpart:          localpart,
+		AccessToken:        "guesttoken",
+		NoDeviceListUpdate: true,
+	}, &devRes); err != nil {
+		t.Fatalf("PerformDeviceCreation failed: %v", err)
+	}
+
+	var res api.PerformGuestUpgradeResponse
+	if err := userAPI.PerformGuestUpgrade(ctx, &api.PerformGuestUpgradeRequest{
+		Localpart: localpart,
+		Password:  "apassword",
+	}, &res); err != nil {
+		t.Fatalf("PerformGuestUpgrade failed: %v", err)
+	}
+	if !res.Upgraded || res.Account.UserID != accRes.Account.UserID || res.Account.AccountType != api.AccountTypeUser {
+		t.Fatalf("PerformGuestUpgrade: got %+v, want an upgraded user account for %s", res, accRes.Account.UserID)
+	}
+	if _, err := accountDB.GetAccountByPassword(ctx, localpart, "apassword"); err != nil {
+		t.Errorf("GetAccountByPassword failed: %v", err)
+	}
+
+	// The guest's device carries on working, but no longer as a guest.
+	var qres api.QueryAccessTokenResponse
+	if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "guesttoken"}, &qres); err != nil {
+		t.Fatalf("QueryAccessToken failed: %v", err)
+	}
+	if qres.Device == nil || qres.Device.ID != devRes.Device.ID || qres.Device.AccountType != api.AccountTypeUser {
+		t.Errorf("QueryAccessToken: got %+v, want device %s of a user account", qres.Device, devRes.Device.ID)
+	}
+
+	// Accounts can only be upgraded once.
+	res = api.PerformGuestUpgradeResponse{}
+	if err := userAPI.PerformGuestUpgrade(ctx, &api.PerformGuestUpgradeRequest{
+		Localpart: localpart,
+		Password:  "anotherpassword",
+	}, &res); err != nil {
+		t.Fatalf("PerformGuestUpgrade failed: %v", err)
+	}
+	if res.Upgraded {
+		t.Errorf("PerformGuestUpgrade upgraded an account that isn't a guest account")
+	}
+}
+
+func TestAccountTypeUpdate(t *testing.T) {
+	ctx := context.Background()
+	userAPI, accountDB := MustMakeInternalAPI(t, apiTestOpts{})
+	if _, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser); err != nil {
+		t.Fatalf("failed to make account: %s", err)
+	}
+	if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+		Localpart:          "auser",
+		AccessToken:        "usertoken",
+		NoDeviceListUpdate: true,
+	}, &api.PerformDeviceCreationResponse{}); err != nil {
+		t.Fatalf("PerformDeviceCreation failed: %v", err)
+	}
+	update := func(t *testing.T, localpart string, accountType api.AccountType) bool {
+		t.Helper()
+		var res api.PerformAccountTypeUpdateResponse
+		if err := userAPI.PerformAccountTypeUpdate(ctx, &api.PerformAccountTypeUpdateRequest{
+			Localpart:   localpart,
+			AccountType: accountType,
+		}, &res); err != nil {
+			t.Fatalf("PerformAccountTypeUpdate failed: %v", err)
+		}
+		return res.Updated
+	}
+	wantAccountType := func(t *testing.T, want api.AccountType) {
+		t.Helper()
+		acc, err := accountDB.GetAccountByLocalpart(ctx, "auser")
+		if err != nil {
+			t.Fatalf("GetAccountByLocalpart failed: %v", err)
+		}
+		if acc.AccountType != want {
+			t.Errorf("account AccountType: got %d, want %d", acc.AccountType, want)
+		}
+		// Existing devices see the change straight away.
+		var res api.QueryAccessTokenResponse
+		if err = userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "usertoken"}, &res); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if res.Device == nil || res.Device.AccountType != want {
+			t.Errorf("QueryAccessToken: got %+v, want a device with AccountType %d", res.Device, want)
+		}
+	}
+
+	if !update(t, "auser", api.AccountTypeAdmin) {
+		t.Fatalf("PerformAccountTypeUpdate didn't grant admin rights")
+	}
+	wantAccountType(t, api.AccountTypeAdmin)
+	if !update(t, "auser", api.AccountTypeUser) {
+		t.Fatalf("PerformAccountTypeUpdate didn't revoke admin rights")
+	}
+	wantAccountType(t, api.AccountTypeUser)
+
+	if update(t, "nobody", api.AccountTypeAdmin) {
+		t.Errorf("PerformAccountTypeUpdate updated an account that doesn't exist")
+	}
+	var guestRes api.PerformAccountCreationResponse
+	if err := userAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+		AccountType: api.AccountTypeGuest,
+	}, &guestRes); err != nil {
+		t.Fatalf("PerformAccountCreation failed: %v", err)
+	}
+	if update(t, guestRes.Account.Localpart, api.AccountTypeAdmin) {
+		t.Errorf("PerformAccountTypeUpdate made a guest account an admin")
+	}
+	if err := userAPI.PerformAccountTypeUpdate(ctx, &api.PerformAccountTypeUpdateRequest{
+		Localpart:   "auser",
+		AccountType: api.AccountTypeGuest,
+	}, &api.PerformAccountTypeUpdateResponse{}); err == nil {
+		t.Errorf("PerformAccountTypeUpdate made an account a guest")
+	}
+}